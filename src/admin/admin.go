@@ -1,27 +1,154 @@
 package admin
 
 import (
+	"io/fs"
 	"log/slog"
 	"net/http"
 
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/adminaudit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/oidc"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+type AdminHandlerOptions struct {
+	// Store persists the objects managed through the admin API
+	Store *store.Store
+	// Summarizer provides the top-N violation summaries
+	Summarizer *audit.Summarizer
+	// Heatmap provides the hourly rule hit counts
+	Heatmap *audit.RuleHeatmap
+	// WAFHandler is the in-process WAF handler exercised by the self-test
+	WAFHandler http.Handler
+	// Changes records every configuration change made through the admin API
+	Changes *changes.Trail
+	// Calls records every admin API call. Nil leaves out the audit endpoint.
+	Calls *adminaudit.Log
+	// Directives lists and rolls back the directive sets loaded into the WAF
+	Directives DirectiveReloader
+	// Rules lists the rules of the active directives. Nil leaves out the rules endpoint.
+	Rules RuleLister
+	// Bans are the banned client IPs managed through the admin API
+	Bans *ban.List
+	// ASNPolicies, ThreatIntel, FailurePolicy and Engine are checked when explaining a transaction. Nil leaves them
+	// out of explanations.
+	ASNPolicies   *middleware.ASNPolicies
+	ThreatIntel   middleware.ThreatIntelMatcher
+	FailurePolicy middleware.FailurePolicy
+	Engine        RuleEngine
+	// FTWTests holds the go-ftw regression test files run by the FTW endpoint
+	FTWTests fs.FS
+	// Debug captures the Coraza debug log of selected requests
+	Debug *coraza.DebugCapture
+	// Capture stores snapshots of selected requests. Nil leaves out the capture endpoints.
+	Capture *capture.Store
+	// Events stores the processed violation events. Nil leaves out the events, explanation and appeal endpoints.
+	Events *events.Store
+	// LogLevel changes the application log level at runtime
+	LogLevel *loglevel.Controller
+	// AccessLog receives a line per admin request. Nil uses the application log.
+	AccessLog *slog.Logger
+	// Config is the resolved configuration keyed by environment variable, with secrets already redacted
+	Config map[string]string
+	// Auth requires an OpenID Connect login for the admin API. Nil treats every caller as anonymous.
+	Auth *oidc.Authenticator
+	// Policy overrides the role required by admin API routes, keyed by method and path pattern
+	Policy rbac.Policy
+	// AnonymousRole is the role of callers when Auth is nil
+	AnonymousRole rbac.Role
+	// RateLimit limits the mutating calls of each client and locks out clients failing to authenticate
+	RateLimit RateLimitOptions
+	// HealthChecks returns the result of each check reported by the health endpoint, "ok" or an error message
+	HealthChecks func() map[string]string
+}
+
 // NewAdminHandler creates a separate HTTP server for administrative endpoints
-func NewAdminHandler() http.Handler {
+func NewAdminHandler(options AdminHandlerOptions) http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", healthHandler)
-	mux.Handle("/metrics", promhttp.Handler())
+	health := HealthHandler(options.HealthChecks)
+	mux.HandleFunc("/health", health)
+	// OpenMetrics is negotiated when the scraper asks for it, which is required to expose exemplars
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	routes := []route{
+		{Method: http.MethodGet, Path: "/health", Summary: "Health check, with the result of each check", Role: rbac.RoleViewer, Handler: health},
+	}
+	routes = append(routes, objectRoutes(options.Store, options.Changes)...)
+	routes = append(routes, banRoutes(options.Bans, options.Changes)...)
+	routes = append(routes, blocklistRoutes(options.Bans, options.Store, options.Changes)...)
+	routes = append(routes, directiveRoutes(options.Directives, options.Changes)...)
+	routes = append(routes, debugRoutes(options.Debug, options.Changes)...)
+	if options.Capture != nil {
+		routes = append(routes, captureRoutes(options.Capture)...)
+	}
+	routes = append(routes, logLevelRoutes(options.LogLevel, options.Changes)...)
+	routes = append(routes, route{Method: http.MethodGet, Path: "/changes", Summary: "Recent configuration changes, newest first", Role: rbac.RoleViewer, Handler: changesHandler(options.Changes)})
+	if options.Calls != nil {
+		routes = append(routes, route{Method: http.MethodGet, Path: "/audit", Summary: "Recent admin API calls, newest first", Role: rbac.RoleAdmin, Handler: auditHandler(options.Calls)})
+	}
+	routes = append(routes, route{Method: http.MethodGet, Path: "/config", Summary: "Effective configuration, directive hash and build info", Role: rbac.RoleViewer, Handler: configHandler(options.Config, options.Directives)})
+	routes = append(routes, grafanaRoutes()...)
+	routes = append(routes, route{Method: http.MethodPost, Path: "/selftest", Summary: "Run canned attack and benign requests through the WAF", Role: rbac.RoleOperator, Handler: selfTestHandler(options.WAFHandler)})
+	routes = append(routes, route{Method: http.MethodPost, Path: "/ftw", Summary: "Run go-ftw regression tests through the WAF", Role: rbac.RoleOperator, Handler: ftwHandler(options.WAFHandler, options.FTWTests)})
+	routes = append(routes, route{Method: http.MethodGet, Path: "/summary", Summary: "Top attackers, targeted paths and rules over a window", Role: rbac.RoleViewer, Handler: summaryHandler(options.Summarizer)})
+	if options.Events != nil {
+		routes = append(routes, route{Method: http.MethodGet, Path: "/events", Summary: "Stored violation events, newest first, filtered by from, to, client_ip, host and rule", Role: rbac.RoleViewer, Handler: eventsHandler(options.Events)})
+		routes = append(routes, route{Method: http.MethodGet, Path: "/transactions/{id}/explanation", Summary: "Explain why a stored transaction was blocked or allowed", Role: rbac.RoleViewer, Handler: explanationHandler(options.Events, decisionState{
+			store:       options.Store,
+			bans:        options.Bans,
+			asnPolicies: options.ASNPolicies,
+			threatIntel: options.ThreatIntel,
+			failure:     options.FailurePolicy,
+			engine:      options.Engine,
+		})})
+		routes = append(routes, appealRoutes(options.Events, options.Store, options.Changes)...)
+	}
+	if options.Rules != nil {
+		routes = append(routes, route{Method: http.MethodGet, Path: "/rules", Summary: "Loaded rules with their file, message, tags, severity and phase, filtered by tag", Role: rbac.RoleViewer, Handler: rulesHandler(options.Rules)})
+	}
+	routes = append(routes, route{Method: http.MethodGet, Path: "/rules/heatmap", Summary: "Hourly rule hit counts over the last 24 hours", Role: rbac.RoleViewer, Handler: heatmapHandler(options.Heatmap)})
+	limiter := newRateLimiter(options.RateLimit)
+	mountAPI(mux, routes, apiAccess{auth: options.Auth, policy: options.Policy, anonymousRole: options.AnonymousRole, calls: options.Calls, limiter: limiter})
+	if options.Auth != nil {
+		mux.Handle("/auth/", limiter.limit(options.Auth.Handler()))
+	}
 	// Add Datadog tracing and logging to admin endpoints
-	handler := middleware.LoggingMiddleware(mux, slog.LevelDebug)
+	accessLog := options.AccessLog
+	if accessLog == nil {
+		accessLog = slog.Default()
+	}
+	handler := middleware.LoggingMiddleware(mux, accessLog, slog.LevelDebug)
 	handler = middleware.PanicMiddleware(handler)
 	return handler
 }
 
-// healthHandler provides a basic health check endpoint
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"healthy","service":"coraza-waf-server"}`))
+// HealthHandler provides a basic health check endpoint reporting the checks, if any. The status is "degraded" when
+// a check fails, but the answer stays 200 since restarting the process does not fix them; readiness fails instead.
+// It is also served while the admin handler is being built.
+func HealthHandler(checks func() map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := struct {
+			Status  string            `json:"status"`
+			Service string            `json:"service"`
+			Checks  map[string]string `json:"checks,omitempty"`
+		}{Status: "healthy", Service: "coraza-waf-server"}
+		if checks != nil {
+			health.Checks = checks()
+		}
+		for _, result := range health.Checks {
+			if result != "ok" {
+				health.Status = "degraded"
+			}
+		}
+		writeJSON(w, http.StatusOK, health)
+	}
 }
@@ -1,16 +1,66 @@
 package admin
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/adminaudit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/geoip"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/oidc"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestOptions(t *testing.T) AdminHandlerOptions {
+	s, err := store.New("")
+	require.NoError(t, err)
+	trail, err := changes.Open("")
+	require.NoError(t, err)
+	return AdminHandlerOptions{
+		AnonymousRole: rbac.RoleAdmin,
+		Store:         s,
+		Summarizer:    audit.NewSummarizer(slog.Default()),
+		Heatmap:       audit.NewRuleHeatmap(),
+		Bans:          ban.New(),
+		Debug:         coraza.NewDebugCapture(coraza.DebugOptions{Size: 10}),
+		LogLevel:      loglevel.New(slog.LevelInfo, time.Hour),
+		Changes:       trail,
+		Directives:    &fakeDirectives{versions: []coraza.DirectiveVersion{{Hash: "current", Active: true}, {Hash: "previous"}}},
+		WAFHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Block anything with a query string, mimicking a WAF with rules loaded
+			if r.URL.RawQuery != "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+}
+
 func TestAdminHandler(t *testing.T) {
 	// Create test handler for admin endpoints
-	adminHandler := NewAdminHandler()
+	adminHandler := NewAdminHandler(newTestOptions(t))
 	if adminHandler == nil {
 		t.Fatal("Expected admin handler to be non-nil")
 	}
@@ -27,6 +77,17 @@ func TestAdminHandler(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200 OK")
 	})
 
+	t.Run("Health check should report failing checks as degraded", func(t *testing.T) {
+		options := newTestOptions(t)
+		options.HealthChecks = func() map[string]string {
+			return map[string]string{"audit_log_writable": "read-only file system"}
+		}
+		w := httptest.NewRecorder()
+		NewAdminHandler(options).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"degraded","service":"coraza-waf-server","checks":{"audit_log_writable":"read-only file system"}}`, w.Body.String())
+	})
+
 	t.Run("Metrics endpoint should respond with 200 OK", func(t *testing.T) {
 		req, err := http.NewRequest("GET", adminServer.URL+"/metrics", nil)
 		assert.NoError(t, err)
@@ -37,3 +98,945 @@ func TestAdminHandler(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200 OK")
 	})
 }
+
+func TestAdminAPI(t *testing.T) {
+	adminServer := httptest.NewServer(NewAdminHandler(newTestOptions(t)))
+	defer adminServer.Close()
+
+	t.Run("Should serve routes under the versioned prefix and the alias", func(t *testing.T) {
+		for _, prefix := range []string{"/api/v1", "/admin"} {
+			resp, err := http.Get(adminServer.URL + prefix + "/health")
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200 OK for %s", prefix)
+		}
+	})
+
+	t.Run("Should return a JSON error envelope for unknown routes", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/api/v1/does-not-exist")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		var body httperror.Response
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "admin.not_found", body.Error.Code)
+	})
+
+	t.Run("Should return 405 with the allowed methods for known routes", func(t *testing.T) {
+		for _, prefix := range []string{"/api/v1", "/admin"} {
+			resp, err := http.Post(adminServer.URL+prefix+"/health", "application/json", nil)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+			assert.Equal(t, "GET", resp.Header.Get("Allow"))
+
+			var body httperror.Response
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, "admin.method_not_allowed", body.Error.Code)
+		}
+	})
+
+	t.Run("Should serve generated Grafana provisioning", func(t *testing.T) {
+		for _, path := range []string{"/admin/metrics/catalog", "/admin/grafana/dashboard.json", "/admin/grafana/alert-rules.json"} {
+			resp, err := http.Get(adminServer.URL + path)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200 OK for %s", path)
+		}
+	})
+
+	t.Run("Should serve an OpenAPI document listing the routes", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/openapi.json")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var document struct {
+			OpenAPI string                    `json:"openapi"`
+			Paths   map[string]map[string]any `json:"paths"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&document))
+		assert.Equal(t, "3.0.3", document.OpenAPI)
+		assert.Contains(t, document.Paths, "/health")
+		assert.Contains(t, document.Paths, "/openapi.json")
+	})
+}
+
+func TestAdminAuth(t *testing.T) {
+	options := newTestOptions(t)
+	options.Auth = oidc.New(oidc.Options{IssuerURL: "http://127.0.0.1:1", ClientID: "waf", SessionKey: []byte("key")})
+	handler := NewAdminHandler(options)
+	serve := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	t.Run("Should leave the probes and metrics open", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("GET", "/health").Code)
+		assert.Equal(t, http.StatusOK, serve("GET", "/metrics").Code)
+	})
+
+	t.Run("Should require a login for the API", func(t *testing.T) {
+		for _, target := range []string{"/api/v1/changes", "/admin/openapi.json"} {
+			w := serve("GET", target)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, target)
+			assert.Contains(t, w.Body.String(), "admin.unauthenticated")
+		}
+		assert.Equal(t, http.StatusBadGateway, serve("GET", "/auth/login").Code, "Expected the unreachable identity provider to be reported")
+	})
+
+	t.Run("Should treat signed out callers as unauthenticated, not anonymous", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/v1/import").Code)
+	})
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	serve := func(options AdminHandlerOptions, method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		NewAdminHandler(options).ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader("{}")))
+		return w
+	}
+
+	t.Run("Should require the role of each route", func(t *testing.T) {
+		options := newTestOptions(t)
+		options.AnonymousRole = rbac.RoleViewer
+		assert.Equal(t, http.StatusOK, serve(options, "GET", "/api/v1/changes").Code)
+
+		w := serve(options, "POST", "/api/v1/import")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "admin.forbidden")
+		assert.Contains(t, w.Body.String(), "POST /import requires the admin role, anonymous has viewer")
+		assert.Equal(t, http.StatusForbidden, serve(options, "DELETE", "/admin/bans/203.0.113.7").Code)
+	})
+
+	t.Run("Should deny every route without a role", func(t *testing.T) {
+		options := newTestOptions(t)
+		options.AnonymousRole = rbac.RoleNone
+		assert.Equal(t, http.StatusForbidden, serve(options, "GET", "/api/v1/changes").Code)
+	})
+
+	t.Run("Should apply the route policy", func(t *testing.T) {
+		options := newTestOptions(t)
+		options.AnonymousRole = rbac.RoleOperator
+		options.Policy = rbac.Policy{"POST /import": rbac.RoleOperator, "GET /config": rbac.RoleNone}
+		assert.Equal(t, http.StatusOK, serve(options, "POST", "/api/v1/import").Code)
+
+		w := serve(options, "GET", "/api/v1/config")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "GET /config is disabled by the admin route policy")
+	})
+}
+
+func TestAdminObjectAPI(t *testing.T) {
+	adminServer := httptest.NewServer(NewAdminHandler(newTestOptions(t)))
+	defer adminServer.Close()
+
+	t.Run("Should create, read and delete an object", func(t *testing.T) {
+		objectURL := adminServer.URL + "/api/v1/objects/policies/strict"
+
+		req, err := http.NewRequest(http.MethodPut, objectURL, strings.NewReader(`{"path":"/upload","rule_ids":[920420]}`))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = http.Get(objectURL)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"path":"/upload","rule_ids":[920420]}`, string(body))
+
+		req, err = http.NewRequest(http.MethodDelete, objectURL, nil)
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = http.Get(objectURL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Should reject unknown collections", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/api/v1/objects/unknown")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Should round-trip export and import", func(t *testing.T) {
+		document := `{"policies":{},"iplists":{"office":{"action":"allow","cidrs":["10.0.0.0/8"]}},"bypass_tokens":{}}`
+		resp, err := http.Post(adminServer.URL+"/api/v1/import", "application/json", strings.NewReader(document))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = http.Get(adminServer.URL + "/api/v1/export")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.JSONEq(t, document, string(body))
+	})
+}
+
+func TestAdminSummaryAPI(t *testing.T) {
+	options := newTestOptions(t)
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	err := options.Summarizer.Send(audit.Log{
+		Transaction: audit.Transaction{ClientIP: "192.0.2.1", Request: &audit.TransactionRequest{URI: "/login"}},
+		Messages:    []audit.Message{{Data: audit.MessageData{ID: 942100}}},
+	})
+	require.NoError(t, err)
+
+	t.Run("Should return the summary for the window", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/summary?window=24h&n=5")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var summary audit.Summary
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&summary))
+		assert.Equal(t, 1, summary.Violations)
+		assert.Equal(t, "192.0.2.1", summary.TopAttackers[0].Key)
+	})
+
+	t.Run("Should reject an invalid window", func(t *testing.T) {
+		for _, window := range []string{"soon", "25h"} {
+			resp, err := http.Get(adminServer.URL + "/admin/summary?window=" + window)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, window)
+		}
+	})
+
+	t.Run("Should return the rule heatmap", func(t *testing.T) {
+		require.NoError(t, options.Heatmap.Send(audit.Log{
+			Messages: []audit.Message{{Data: audit.MessageData{ID: 942100}}},
+		}))
+
+		resp, err := http.Get(adminServer.URL + "/admin/rules/heatmap")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var heatmap audit.Heatmap
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&heatmap))
+		assert.Len(t, heatmap.Hours, 24)
+		assert.Equal(t, 942100, heatmap.Rules[0].RuleID)
+		assert.Equal(t, 1, heatmap.Rules[0].Total)
+	})
+}
+
+func TestAdminSelfTestAPI(t *testing.T) {
+	options := newTestOptions(t)
+
+	t.Run("Should report failures when attacks are not blocked", func(t *testing.T) {
+		adminServer := httptest.NewServer(NewAdminHandler(options))
+		defer adminServer.Close()
+
+		resp, err := http.Post(adminServer.URL+"/admin/selftest", "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		var report coraza.SelfTestReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.True(t, report.Categories["sqli"].Passed)
+		assert.False(t, report.Categories["benign"].Passed, "Expected the benign query parameter case to be blocked by the stub")
+	})
+
+	t.Run("Should fail everything when the WAF allows all requests", func(t *testing.T) {
+		options.WAFHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		adminServer := httptest.NewServer(NewAdminHandler(options))
+		defer adminServer.Close()
+
+		resp, err := http.Post(adminServer.URL+"/admin/selftest", "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var report coraza.SelfTestReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.False(t, report.Passed)
+		assert.True(t, report.Categories["benign"].Passed)
+		assert.False(t, report.Categories["xss"].Passed)
+	})
+}
+
+func TestAdminBanAPI(t *testing.T) {
+	options := newTestOptions(t)
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	options.Bans.Add("192.0.2.1", "honeypot:/.env", time.Hour)
+
+	t.Run("Should list bans", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/bans")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var entries []ban.Entry
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+		require.Len(t, entries, 1)
+		assert.Equal(t, "192.0.2.1", entries[0].IP)
+	})
+
+	t.Run("Should lift a ban", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, adminServer.URL+"/admin/bans/192.0.2.1", nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAdminBlocklistAPI(t *testing.T) {
+	options := newTestOptions(t)
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	options.Bans.Add("192.0.2.1", "honeypot:/.env", time.Hour)
+	require.NoError(t, options.Store.Put(store.CollectionIPLists, "office", json.RawMessage(`{"action":"allow","cidrs":["198.51.100.0/24"]}`)))
+	require.NoError(t, options.Store.Put(store.CollectionPolicies, "default", json.RawMessage(`{"path":"/login","rule_ids":[942100]}`)))
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	importState := func(mode string, document string) (*http.Response, BlockState) {
+		resp, err := http.Post(adminServer.URL+"/admin/blocklist/import?mode="+mode, "application/json", strings.NewReader(document))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var state BlockState
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+		}
+		return resp, state
+	}
+
+	t.Run("Should export the combined block state", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/blocklist/export")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var state BlockState
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+		require.Len(t, state.Bans, 1)
+		assert.Equal(t, "192.0.2.1", state.Bans[0].IP)
+		assert.JSONEq(t, `{"action":"allow","cidrs":["198.51.100.0/24"]}`, string(state.IPLists["office"]))
+		assert.Empty(t, state.BypassTokens)
+	})
+
+	t.Run("Should merge an imported document", func(t *testing.T) {
+		resp, state := importState("", fmt.Sprintf(`{"bans":[{"ip":"203.0.113.7","reason":"soar:incident-42","expires":%q}],"bypass_tokens":{"scanner":{"token":"0123456789abcdef"}}}`, expires.Format(time.RFC3339)))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, state.Bans, 2)
+		assert.Contains(t, state.IPLists, "office")
+		assert.Contains(t, state.BypassTokens, "scanner")
+		_, banned := options.Bans.Banned("203.0.113.7")
+		assert.True(t, banned)
+	})
+
+	t.Run("Should replace the imported sections only", func(t *testing.T) {
+		resp, state := importState("replace", fmt.Sprintf(`{"bans":[{"ip":"203.0.113.8","reason":"soar","expires":%q}],"ip_lists":{}}`, expires.Format(time.RFC3339)))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []ban.Entry{{IP: "203.0.113.8", Reason: "soar", Expires: expires}}, state.Bans)
+		assert.Empty(t, state.IPLists)
+		assert.Contains(t, state.BypassTokens, "scanner")
+		_, ok, _ := options.Store.Get(store.CollectionPolicies, "default")
+		assert.True(t, ok, "Expected other collections to be left alone")
+	})
+
+	t.Run("Should only show bypass tokens to admins", func(t *testing.T) {
+		recent := options.Changes.Recent(10)
+		require.NotEmpty(t, recent)
+		for _, change := range recent {
+			assert.NotContains(t, string(change.After), "0123456789abcdef", "Expected the change trail to redact tokens")
+		}
+
+		viewerOptions := options
+		viewerOptions.AnonymousRole = rbac.RoleViewer
+		viewerServer := httptest.NewServer(NewAdminHandler(viewerOptions))
+		defer viewerServer.Close()
+		for _, path := range []string{"/admin/blocklist/export", "/admin/export", "/admin/objects/bypass_tokens", "/admin/objects/bypass_tokens/scanner"} {
+			resp, err := http.Get(viewerServer.URL + path)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+			assert.Contains(t, string(body), `"token":"[redacted]"`, path)
+			assert.NotContains(t, string(body), "0123456789abcdef", path)
+		}
+
+		resp, err := http.Get(adminServer.URL + "/admin/objects/bypass_tokens/scanner")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "0123456789abcdef")
+	})
+
+	t.Run("Should reject invalid documents", func(t *testing.T) {
+		resp, _ := importState("append", `{}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp, _ = importState("", `{"bans":[{"ip":"not-an-ip","expires":"2030-01-01T00:00:00Z"}]}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp, _ = importState("", `{"bans":[{"ip":"192.0.2.9"}]}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+type fakeDirectives struct {
+	versions []coraza.DirectiveVersion
+}
+
+func (f *fakeDirectives) DirectiveHistory() []coraza.DirectiveVersion {
+	return f.versions
+}
+
+func (f *fakeDirectives) RollbackDirectives(hash string) error {
+	for i, v := range f.versions {
+		if v.Hash == hash {
+			f.versions[0].Active = false
+			f.versions[i].Active = true
+			return nil
+		}
+	}
+	return coraza.ErrUnknownDirectiveVersion
+}
+
+func TestAdminAuditAPI(t *testing.T) {
+	options := newTestOptions(t)
+	calls, err := adminaudit.Open("", nil)
+	require.NoError(t, err)
+	options.Calls = calls
+	options.AnonymousRole = rbac.RoleOperator
+	handler := NewAdminHandler(options)
+	serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	serve("PUT", "/api/v1/objects/policies/login", `{"path":"/login","rule_ids":[942100]}`)
+	serve("POST", "/api/v1/import", `{}`)
+	serve("GET", "/api/v1/unknown", "")
+
+	t.Run("Should record every call with its payload hash and result", func(t *testing.T) {
+		recent := calls.Recent(10)
+		require.Len(t, recent, 3)
+		assert.Equal(t, "", recent[0].Route)
+		assert.Equal(t, "failure", recent[0].Result)
+
+		assert.Equal(t, "POST /import", recent[1].Route)
+		assert.Equal(t, "denied", recent[1].Result)
+		assert.Equal(t, http.StatusForbidden, recent[1].Status)
+
+		put := recent[2]
+		assert.Equal(t, "anonymous", put.Actor)
+		assert.Equal(t, "operator", put.Role)
+		assert.Equal(t, "PUT /objects/{collection}/{key}", put.Route)
+		assert.Equal(t, "/api/v1/objects/policies/login", put.Path)
+		assert.Equal(t, "success", put.Result)
+		digest := sha256.Sum256([]byte(`{"path":"/login","rule_ids":[942100]}`))
+		assert.Equal(t, hex.EncodeToString(digest[:]), put.PayloadSHA256)
+	})
+
+	t.Run("Should only list the calls to admins", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve("GET", "/admin/audit", "").Code)
+
+		options.AnonymousRole = rbac.RoleAdmin
+		w := httptest.NewRecorder()
+		NewAdminHandler(options).ServeHTTP(w, httptest.NewRequest("GET", "/admin/audit?limit=2", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var entries []adminaudit.Entry
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		require.Len(t, entries, 2)
+		assert.Equal(t, "GET /audit", entries[0].Route)
+		assert.Equal(t, "denied", entries[0].Result)
+	})
+}
+
+func TestAdminDirectivesAPI(t *testing.T) {
+	adminServer := httptest.NewServer(NewAdminHandler(newTestOptions(t)))
+	defer adminServer.Close()
+
+	t.Run("Should list the directive history", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/directives/history")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var versions []coraza.DirectiveVersion
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&versions))
+		assert.Len(t, versions, 2)
+	})
+
+	t.Run("Should roll back to a known version", func(t *testing.T) {
+		resp, err := http.Post(adminServer.URL+"/admin/directives/rollback/previous", "", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var versions []coraza.DirectiveVersion
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&versions))
+		assert.True(t, versions[1].Active)
+	})
+
+	t.Run("Should reject an unknown version", func(t *testing.T) {
+		resp, err := http.Post(adminServer.URL+"/admin/directives/rollback/unknown", "", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+type fakeRules []coraza.RuleInfo
+
+func (f fakeRules) Rules() []coraza.RuleInfo {
+	return f
+}
+
+func TestAdminRulesAPI(t *testing.T) {
+	options := newTestOptions(t)
+	options.Rules = fakeRules{
+		{ID: 942100, File: "@owasp_crs/REQUEST-942-APPLICATION-ATTACK-SQLI.conf", Message: "SQL Injection Attack Detected via libinjection", Tags: []string{"attack-sqli", "paranoia-level/1"}, Severity: "critical", Phase: 2},
+		{ID: 942432, File: "@owasp_crs/REQUEST-942-APPLICATION-ATTACK-SQLI.conf", Message: "Restricted SQL Character Anomaly Detection (args): # of special characters exceeded (2)", Tags: []string{"attack-sqli", "paranoia-level/4"}, Severity: "warning", Phase: 2},
+		{ID: 941100, File: "@owasp_crs/REQUEST-941-APPLICATION-ATTACK-XSS.conf", Message: "XSS Attack Detected via libinjection", Tags: []string{"attack-xss", "paranoia-level/1"}, Severity: "critical", Phase: 2},
+	}
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	list := func(query string) []coraza.RuleInfo {
+		resp, err := http.Get(adminServer.URL + "/admin/rules" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var rules []coraza.RuleInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+		return rules
+	}
+
+	t.Run("Should list every loaded rule", func(t *testing.T) {
+		assert.Len(t, list(""), 3)
+	})
+
+	t.Run("Should only list the rules with every tag", func(t *testing.T) {
+		rules := list("?tag=attack-sqli&tag=paranoia-level/4")
+		require.Len(t, rules, 1)
+		assert.Equal(t, 942432, rules[0].ID)
+		assert.Empty(t, list("?tag=attack-rce"))
+	})
+}
+
+func TestAdminChangesAPI(t *testing.T) {
+	adminServer := httptest.NewServer(NewAdminHandler(newTestOptions(t)))
+	defer adminServer.Close()
+
+	put := func(body string) {
+		req, _ := http.NewRequest(http.MethodPut, adminServer.URL+"/admin/objects/iplists/office", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	put(`{"action":"allow","cidrs":["192.0.2.0/24"]}`)
+	put(`{"action":"allow","cidrs":["198.51.100.0/24"]}`)
+
+	resp, err := http.Get(adminServer.URL + "/admin/changes?limit=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var recent []changes.Change
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&recent))
+	require.Len(t, recent, 1)
+	assert.Equal(t, "objects.put", recent[0].Action)
+	assert.Equal(t, "iplists/office", recent[0].Target)
+	assert.JSONEq(t, `{"action":"allow","cidrs":["192.0.2.0/24"]}`, string(recent[0].Before))
+	assert.JSONEq(t, `{"action":"allow","cidrs":["198.51.100.0/24"]}`, string(recent[0].After))
+}
+
+func TestAdminConfigAPI(t *testing.T) {
+	options := newTestOptions(t)
+	options.Config = map[string]string{"WAF_PORT": "8080", "COOKIE_INTEGRITY_SECRET": "[redacted]"}
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	resp, err := http.Get(adminServer.URL + "/admin/config")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var report ConfigReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, options.Config, report.Settings)
+	assert.Equal(t, "current", report.DirectivesHash)
+	assert.NotEmpty(t, report.Build.GoVersion)
+}
+
+func TestAdminDebugAPI(t *testing.T) {
+	adminServer := httptest.NewServer(NewAdminHandler(newTestOptions(t)))
+	defer adminServer.Close()
+
+	t.Run("Should enable and list debug IPs", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, adminServer.URL+"/admin/debug/ips/203.0.113.7", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var ips []string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&ips))
+		assert.Equal(t, []string{"203.0.113.7"}, ips)
+	})
+
+	t.Run("Should disable a debug IP", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, adminServer.URL+"/admin/debug/ips/203.0.113.7", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Should return 404 for unknown traces", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/debug/traces/unknown")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAdminCaptureAPI(t *testing.T) {
+	captures, err := capture.Open(capture.Options{Dir: t.TempDir(), Retention: time.Hour, SampleRate: 1})
+	require.NoError(t, err)
+	defer captures.Close()
+	require.NoError(t, captures.Record(capture.Snapshot{TransactionID: "tx-1", Time: time.Now(), ClientIP: "203.0.113.7", Method: "POST", URI: "/login", Body: []byte("user=admin'--"), Decision: "deny", RuleIDs: []int{942100}}))
+	require.NoError(t, captures.Record(capture.Snapshot{TransactionID: "tx-2", Time: time.Now(), ClientIP: "198.51.100.1", Method: "GET", URI: "/", Decision: "allow"}))
+
+	options := newTestOptions(t)
+	options.Capture = captures
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	t.Run("Should list captured requests matching the filter", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/captures?rule=942100")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var summaries []capture.Summary
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&summaries))
+		require.Len(t, summaries, 1)
+		assert.Equal(t, "tx-1", summaries[0].TransactionID)
+	})
+
+	t.Run("Should get a captured request with its body", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/captures/tx-1")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var snapshot capture.Snapshot
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+		assert.Equal(t, "user=admin'--", string(snapshot.Body))
+	})
+
+	t.Run("Should return 404 for unknown transactions", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/captures/unknown")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAdminEventsAPI(t *testing.T) {
+	store, err := events.Open(events.Options{Dir: t.TempDir(), Retention: time.Hour})
+	require.NoError(t, err)
+	defer store.Close()
+	for i, clientIP := range []string{"203.0.113.7", "203.0.113.7", "198.51.100.1"} {
+		require.NoError(t, store.Send(audit.Log{Transaction: audit.Transaction{
+			ID:            fmt.Sprintf("tx-%d", i),
+			UnixTimestamp: time.Now().Add(time.Duration(i) * time.Second).UnixNano(),
+			ClientIP:      clientIP,
+		}}))
+	}
+	options := newTestOptions(t)
+	options.Events = store
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	t.Run("Should page through the matching events", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/events?client_ip=203.0.113.7&limit=1")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var page events.Page
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		require.Len(t, page.Events, 1)
+		assert.Equal(t, "tx-1", page.Events[0].ID)
+
+		resp, err = http.Get(adminServer.URL + "/admin/events?client_ip=203.0.113.7&limit=1&cursor=" + page.Next)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		page = events.Page{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		require.Len(t, page.Events, 1)
+		assert.Equal(t, "tx-0", page.Events[0].ID)
+		assert.Empty(t, page.Next)
+	})
+
+	t.Run("Should reject invalid filters", func(t *testing.T) {
+		for _, query := range []string{"from=yesterday", "rule=abc", "cursor=bogus"} {
+			resp, err := http.Get(adminServer.URL + "/admin/events?" + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func TestAdminAppealAPI(t *testing.T) {
+	eventStore, err := events.Open(events.Options{Dir: t.TempDir(), Retention: time.Hour})
+	require.NoError(t, err)
+	defer eventStore.Close()
+	require.NoError(t, eventStore.Send(audit.Log{
+		Transaction: audit.Transaction{
+			ID:            "tx-blocked",
+			UnixTimestamp: time.Now().UnixNano(),
+			ClientIP:      "203.0.113.7",
+			Request:       &audit.TransactionRequest{Method: "POST", URI: "/comments?draft=1"},
+		},
+		Messages: []audit.Message{{Data: audit.MessageData{ID: 941100}}, {Data: audit.MessageData{ID: 949110}}},
+	}))
+	options := newTestOptions(t)
+	options.Events = eventStore
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	t.Run("Should look up a blocked transaction", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/appeals/tx-blocked")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var appeal Appeal
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&appeal))
+		assert.Equal(t, "/comments", appeal.Exclusion.Path)
+		assert.Equal(t, []int{941100}, appeal.Exclusion.RuleIDs, "Expected the anomaly evaluation rule to be left out")
+		assert.Regexp(t, `^SecRule REQUEST_FILENAME "@streq /comments" "id:10\d{5},phase:1,pass,nolog,ctl:ruleRemoveById=941100"$`, appeal.Exclusion.Directive)
+		assert.Equal(t, []string{"203.0.113.7/32"}, appeal.Allow.CIDRs)
+	})
+
+	t.Run("Should store the accepted fixes", func(t *testing.T) {
+		for path, collection := range map[string]string{"exclusion": store.CollectionPolicies, "allow": store.CollectionIPLists} {
+			resp, err := http.Post(adminServer.URL+"/admin/appeals/tx-blocked/"+path, "", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusCreated, resp.StatusCode, path)
+
+			_, ok, err := options.Store.Get(collection, "appeal-tx-blocked")
+			require.NoError(t, err)
+			assert.True(t, ok, collection)
+		}
+		recent := options.Changes.Recent(10)
+		require.NotEmpty(t, recent)
+		assert.Equal(t, "appeals.accept", recent[0].Action)
+
+		enforced := options.Store.Enforced()
+		assert.Equal(t, []int{941100}, enforced.Excluded("/comments"))
+		action, _, ok := enforced.MatchIP(netip.MustParseAddr("203.0.113.7"))
+		assert.True(t, ok)
+		assert.Equal(t, store.IPListAllow, action)
+	})
+
+	t.Run("Should reject unknown transactions", func(t *testing.T) {
+		resp, err := http.Post(adminServer.URL+"/admin/appeals/unknown/allow", "", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestNewAppeal(t *testing.T) {
+	event := audit.Event{ID: "tx", URI: "/files/a%20b?x=1", Rules: []audit.EventRule{{ID: 930100}}}
+
+	t.Run("Should exclude the rules on the decoded path", func(t *testing.T) {
+		appeal := newAppeal(event)
+		assert.Equal(t, "/files/a b", appeal.Exclusion.Path)
+		assert.Contains(t, appeal.Exclusion.Directive, `"@streq /files/a b"`)
+	})
+
+	t.Run("Should leave out the directive for paths that cannot be written literally", func(t *testing.T) {
+		for _, uri := range []string{`/a%22b`, `/a%5Cb`, `/a%25%7Btx.x%7D`, `/a%0Ab`} {
+			event.URI = uri
+			appeal := newAppeal(event)
+			assert.NotEmpty(t, appeal.Exclusion.Path, uri)
+			assert.Empty(t, appeal.Exclusion.Directive, uri)
+		}
+	})
+}
+
+type fakeASNDatabase map[string]uint32
+
+func (f fakeASNDatabase) ASN(ip netip.Addr) (geoip.ASN, bool) {
+	number, ok := f[ip.String()]
+	return geoip.ASN{Number: number}, ok
+}
+
+type fakeRuleEngine bool
+
+func (f fakeRuleEngine) RuleEngineOff() bool {
+	return bool(f)
+}
+
+func bypassKinds(checks []BypassExplanation) []string {
+	kinds := make([]string, len(checks))
+	for i, check := range checks {
+		kinds[i] = check.Kind
+	}
+	return kinds
+}
+
+func TestAdminExplanationAPI(t *testing.T) {
+	eventStore, err := events.Open(events.Options{Dir: t.TempDir(), Retention: time.Hour})
+	require.NoError(t, err)
+	defer eventStore.Close()
+	require.NoError(t, eventStore.Send(audit.Log{
+		Transaction: audit.Transaction{
+			ID:            "tx-blocked",
+			UnixTimestamp: time.Now().UnixNano(),
+			ClientIP:      "203.0.113.7",
+			Request:       &audit.TransactionRequest{Method: "GET", URI: "/search?q=1%27%20OR%201=1"},
+			Response:      &audit.TransactionResponse{Status: http.StatusForbidden},
+		},
+		Messages: []audit.Message{
+			{Data: audit.MessageData{ID: 942100, Msg: "SQL Injection Attack Detected via libinjection", Data: "Matched Data: s&1 found within ARGS:q: 1' OR 1=1", Severity: 2, Tags: []string{"attack-sqli", "OWASP_CRS"}}},
+			{Data: audit.MessageData{ID: 920350, Msg: "Host header is a numeric IP address", Severity: 4, Tags: []string{"attack-protocol"}}},
+			{Data: audit.MessageData{ID: 949110, Msg: "Inbound Anomaly Score Exceeded (Total Score: 8)", Severity: 0}},
+		},
+	}))
+	options := newTestOptions(t)
+	options.Events = eventStore
+	require.NoError(t, options.Store.Put(store.CollectionIPLists, "office", json.RawMessage(`{"action":"allow","cidrs":["203.0.113.0/24"]}`)))
+	require.NoError(t, options.Store.Put(store.CollectionPolicies, "search", json.RawMessage(`{"path":"/search","rule_ids":[942100]}`)))
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	t.Run("Should explain a blocked transaction", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/transactions/tx-blocked/explanation")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var explanation Explanation
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&explanation))
+		assert.Equal(t, "blocked", explanation.Outcome)
+		assert.Equal(t, "GET /search?q=1%27%20OR%201=1 from 203.0.113.7", explanation.Request)
+		require.Len(t, explanation.Rules, 3)
+		assert.Equal(t, ExplainedRule{ID: 942100, Message: "SQL Injection Attack Detected via libinjection", Severity: "critical", Category: "SQL injection", MatchedData: "Matched Data: s&1 found within ARGS:q: 1' OR 1=1", Points: 5}, explanation.Rules[0])
+		assert.Equal(t, 3, explanation.Rules[1].Points)
+		assert.Equal(t, ScoreExplanation{Total: 8, Source: "reported by rule 949110"}, explanation.Score)
+		assert.Equal(t, policyAnomalyThreshold, explanation.Policy.Name)
+		assert.Contains(t, explanation.Summary, "looked like SQL injection and HTTP protocol violation")
+		assert.Contains(t, explanation.Bypasses, BypassExplanation{Kind: bypassIPList, Name: "office", Effect: effectAllow, Matches: true, Detail: "IP list office now allows 203.0.113.7, so its requests are let through without inspection"})
+		assert.Contains(t, explanation.Bypasses, BypassExplanation{Kind: bypassExclusion, Effect: effectExclude, Matches: true, Detail: "rules 942100 are now removed on /search"})
+		assert.Contains(t, explanation.Bypasses, BypassExplanation{Kind: bypassBan, Effect: effectReject, Detail: "203.0.113.7 is not banned now"})
+		assert.Contains(t, explanation.Summary, "IP list office now allows 203.0.113.7")
+	})
+
+	t.Run("Should check the mechanisms deciding requests before the rules", func(t *testing.T) {
+		options.Bans.Add("203.0.113.7", "honeypot", time.Hour)
+		defer options.Bans.Remove("203.0.113.7")
+		parsed, err := middleware.ParseASNPolicies("64496 allow /search")
+		require.NoError(t, err)
+		event, ok, err := eventStore.Get("tx-blocked")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		explanation := explain(event, decisionState{
+			bans:        options.Bans,
+			asnPolicies: middleware.NewASNPolicies(fakeASNDatabase{"203.0.113.7": 64496}, parsed),
+			engine:      fakeRuleEngine(true),
+		})
+		assert.Equal(t, []string{bypassBan, bypassASNPolicy, bypassRuleEngineOff}, bypassKinds(explanation.Bypasses))
+		assert.True(t, explanation.Bypasses[0].Matches)
+		assert.Equal(t, BypassExplanation{Kind: bypassASNPolicy, Name: "AS64496", Effect: effectExempt, Matches: true, Detail: "AS64496 is now allowed on /search, which exempts it from the ASN policies that follow but not from inspection"}, explanation.Bypasses[1])
+		assert.True(t, explanation.Bypasses[2].Matches)
+	})
+
+	t.Run("Should explain requests answered by the failure policy", func(t *testing.T) {
+		event := audit.Event{ID: "tx-failed", ClientIP: "203.0.113.7", URI: "/upload"}
+		explanation := explain(event, decisionState{failure: middleware.FailurePolicy{Default: middleware.FailOpen}})
+		assert.Equal(t, []BypassExplanation{{Kind: bypassFailOpen, Effect: effectAllow, Matches: true, Detail: "the WAF failed to evaluate the request and the failure mode is open, so it was let through without inspection"}}, explanation.Bypasses)
+	})
+
+	t.Run("Should reject unknown transactions", func(t *testing.T) {
+		resp, err := http.Get(adminServer.URL + "/admin/transactions/unknown/explanation")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAdminLogLevelAPI(t *testing.T) {
+	options := newTestOptions(t)
+	adminServer := httptest.NewServer(NewAdminHandler(options))
+	defer adminServer.Close()
+
+	put := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, adminServer.URL+"/admin/loglevel", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Should change the log level until it reverts", func(t *testing.T) {
+		resp := put(`{"level":"debug","duration":"10m"}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var state loglevel.State
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+		assert.Equal(t, "DEBUG", state.Level)
+		assert.Equal(t, "INFO", state.Configured)
+		assert.NotNil(t, state.RevertsAt)
+		assert.Equal(t, slog.LevelDebug, options.LogLevel.Leveler().Level())
+	})
+
+	t.Run("Should reject unknown levels", func(t *testing.T) {
+		resp := put(`{"level":"verbose"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Should revert to the configured level", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, adminServer.URL+"/admin/loglevel", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, slog.LevelInfo, options.LogLevel.Leveler().Level())
+	})
+}
@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/adminaudit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/oidc"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+const (
+	// apiVersionPrefix is the canonical, versioned mount point of the admin API
+	apiVersionPrefix = "/api/v1"
+	// apiAliasPrefix always serves the latest API version
+	apiAliasPrefix = "/admin"
+	apiVersion     = "1.0.0"
+)
+
+// route describes an admin API endpoint. The OpenAPI document is generated from the registered routes.
+type route struct {
+	Method  string
+	Path    string
+	Summary string
+	// Role is required to call the route unless the policy overrides it. Routes without a role are denied.
+	Role    rbac.Role
+	Handler http.HandlerFunc
+}
+
+// key identifies the route in a policy
+func (r route) key() string {
+	return r.Method + " " + r.Path
+}
+
+// apiAccess authenticates and authorizes the calls to the admin API routes
+type apiAccess struct {
+	// auth authenticates the callers. Nil treats every caller as anonymous.
+	auth *oidc.Authenticator
+	// policy overrides the role of routes
+	policy rbac.Policy
+	// anonymousRole is granted to every caller when there is no authentication
+	anonymousRole rbac.Role
+	// calls records every call. Nil records nothing.
+	calls *adminaudit.Log
+	// limiter refuses the calls of clients over the rate limit or locked out
+	limiter *rateLimiter
+}
+
+// mountAPI registers the routes under both the versioned prefix and the alias prefix, each requiring its role
+func mountAPI(mux *http.ServeMux, routes []route, access apiAccess) {
+	routes = append(routes, route{
+		Method:  http.MethodGet,
+		Path:    "/openapi.json",
+		Summary: "OpenAPI document describing the admin API",
+		Role:    rbac.RoleViewer,
+	})
+	routes[len(routes)-1].Handler = openAPIHandler(routes)
+
+	policy := rbac.Policy{}
+	for _, r := range routes {
+		policy[r.key()] = r.Role
+	}
+	for key, role := range access.policy {
+		if _, ok := policy[key]; !ok {
+			slog.Warn("Admin route policy matches no route", "route", key)
+		}
+		policy[key] = role
+	}
+
+	for _, prefix := range []string{apiVersionPrefix, apiAliasPrefix} {
+		for _, r := range routes {
+			handler := authorize(r.key(), policy, access.anonymousRole, r.Handler)
+			if access.auth != nil {
+				handler = access.auth.Authenticate(handler)
+			}
+			handler = access.limiter.limit(handler)
+			if access.calls != nil {
+				handler = recordCalls(access.calls, r.key(), handler)
+			}
+			mux.Handle(r.Method+" "+prefix+r.Path, handler)
+		}
+		var notFound http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed := allowedMethods(mux, routes, prefix+"/", r); len(allowed) > 0 {
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				writeError(w, http.StatusMethodNotAllowed, "admin.method_not_allowed", fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path))
+				return
+			}
+			writeError(w, http.StatusNotFound, "admin.not_found", "no admin API route matches "+r.URL.Path)
+		})
+		notFound = access.limiter.limit(notFound)
+		if access.calls != nil {
+			notFound = recordCalls(access.calls, "", notFound)
+		}
+		mux.Handle(prefix+"/", notFound)
+	}
+}
+
+// allowedMethods returns the methods of the routes matching the path of the request, which the catch-all pattern
+// received because no route matches its method
+func allowedMethods(mux *http.ServeMux, routes []route, catchAll string, r *http.Request) []string {
+	var allowed []string
+	for _, rt := range routes {
+		if slices.Contains(allowed, rt.Method) {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = rt.Method
+		if _, pattern := mux.Handler(probe); pattern != "" && pattern != catchAll {
+			allowed = append(allowed, rt.Method)
+		}
+	}
+	slices.Sort(allowed)
+	return allowed
+}
+
+// authorize only passes on calls from callers whose role the policy allows on the route, anonymous callers having
+// the anonymous role
+func authorize(key string, policy rbac.Policy, anonymousRole rbac.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := rbac.FromContext(r.Context())
+		if !ok {
+			principal = rbac.Principal{Actor: anonymousActor, Role: anonymousRole}
+		}
+		setCaller(r.Context(), principal)
+		r = r.WithContext(rbac.WithPrincipal(r.Context(), principal))
+		if !policy.Allows(key, principal.Role) {
+			slog.Warn("Admin call denied", "actor", principal.Actor, "role", principal.Role.String(), "route", key)
+			message := fmt.Sprintf("%s requires the %s role, %s has %s", key, policy[key], principal.Actor, principal.Role)
+			if policy[key] == rbac.RoleNone {
+				message = key + " is disabled by the admin route policy"
+			}
+			writeError(w, http.StatusForbidden, "admin.forbidden", message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write admin API response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	httperror.WriteJSON(w, status, httperror.Body{Code: code, Message: message})
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+func openAPIHandler(routes []route) http.HandlerFunc {
+	document := openAPIDocument(routes)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, document)
+	}
+}
+
+// openAPIDocument builds an OpenAPI 3 document from the registered routes
+func openAPIDocument(routes []route) map[string]any {
+	paths := map[string]map[string]any{}
+	for _, r := range routes {
+		path := pathParamPattern.ReplaceAllString(r.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+
+		operation := map[string]any{
+			"summary": r.Summary,
+			"responses": map[string]any{
+				"200": map[string]any{"description": "Success"},
+				"default": map[string]any{
+					"description": "Error",
+					"content": map[string]any{
+						"application/json": map[string]any{
+							"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+						},
+					},
+				},
+			},
+		}
+
+		var parameters []map[string]any
+		for _, match := range pathParamPattern.FindAllStringSubmatch(r.Path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		paths[path][strings.ToLower(r.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "coraza-traefik-middleware admin API",
+			"version": apiVersion,
+		},
+		"servers": []map[string]any{{"url": apiVersionPrefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"error": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"code":           map[string]any{"type": "string"},
+								"message":        map[string]any{"type": "string"},
+								"rule_ids":       map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+								"transaction_id": map[string]any{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,149 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+)
+
+// appealKeyPrefix names the objects created from appeals, followed by the transaction ID
+const appealKeyPrefix = "appeal-"
+
+// Appeal is a blocked transaction quoted by a user from the block page, with the fixes that would let it through.
+// Accepting either fix stores it, and the WAF enforces it from the next request.
+type Appeal struct {
+	Event     audit.Event     `json:"event"`
+	Exclusion store.Exclusion `json:"exclusion"`
+	Allow     store.IPList    `json:"allow"`
+}
+
+func appealRoutes(events *events.Store, s *store.Store, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/appeals/{id}", Summary: "Look up a blocked transaction and the fixes that would let it through", Role: rbac.RoleViewer, Handler: getAppealHandler(events)},
+		{Method: http.MethodPost, Path: "/appeals/{id}/exclusion", Summary: "Store a rule exclusion for the path of a blocked transaction", Role: rbac.RoleOperator, Handler: acceptAppealHandler(events, s, trail, store.CollectionPolicies)},
+		{Method: http.MethodPost, Path: "/appeals/{id}/allow", Summary: "Store an allow entry for the client IP of a blocked transaction", Role: rbac.RoleOperator, Handler: acceptAppealHandler(events, s, trail, store.CollectionIPLists)},
+	}
+}
+
+func getAppealHandler(events *events.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if appeal, ok := lookUpAppeal(w, r, events); ok {
+			writeJSON(w, http.StatusOK, appeal)
+		}
+	}
+}
+
+// acceptAppealHandler stores the appeal's exclusion or allow entry, depending on the collection
+func acceptAppealHandler(events *events.Store, s *store.Store, trail *changes.Trail, collection string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		appeal, ok := lookUpAppeal(w, r, events)
+		if !ok {
+			return
+		}
+		var value any = appeal.Allow
+		if collection == store.CollectionPolicies {
+			if len(appeal.Exclusion.RuleIDs) == 0 {
+				writeError(w, http.StatusConflict, "admin.nothing_to_exclude", "no rules to exclude for transaction "+appeal.Event.ID)
+				return
+			}
+			if appeal.Exclusion.Path == "" {
+				writeError(w, http.StatusConflict, "admin.nothing_to_exclude", "the URI of transaction "+appeal.Event.ID+" has no path to exclude rules on")
+				return
+			}
+			value = appeal.Exclusion
+		} else if len(appeal.Allow.CIDRs) == 0 {
+			writeError(w, http.StatusConflict, "admin.nothing_to_allow", "no client IP to allow for transaction "+appeal.Event.ID)
+			return
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "admin.appeal_failed", err.Error())
+			return
+		}
+
+		key := appealKeyPrefix + appeal.Event.ID
+		before, _, _ := s.Get(collection, key)
+		if err := s.Put(collection, key, body); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		recordChange(trail, r, "appeals.accept", collection+"/"+key, before, json.RawMessage(body))
+		writeJSON(w, http.StatusCreated, value)
+	}
+}
+
+// lookUpAppeal finds the event of the transaction in the path, answering with an error when there is none
+func lookUpAppeal(w http.ResponseWriter, r *http.Request, events *events.Store) (Appeal, bool) {
+	event, ok, err := events.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "admin.appeal_failed", err.Error())
+		return Appeal{}, false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "admin.unknown_transaction", "no stored event for transaction "+r.PathValue("id"))
+		return Appeal{}, false
+	}
+	return newAppeal(event), true
+}
+
+func newAppeal(event audit.Event) Appeal {
+	exclusion := store.Exclusion{TransactionID: event.ID, Path: requestPath(event.URI), RuleIDs: []int{}}
+	for _, rule := range event.Rules {
+		if !anomalyEvaluationRule(rule.ID) {
+			exclusion.RuleIDs = append(exclusion.RuleIDs, rule.ID)
+		}
+	}
+	if len(exclusion.RuleIDs) > 0 && exclusion.Path != "" {
+		exclusion.Directive = exclusionDirective(event.ID, exclusion.Path, exclusion.RuleIDs)
+	}
+
+	allow := store.IPList{TransactionID: event.ID, Action: store.IPListAllow, CIDRs: []string{}}
+	if addr, err := netip.ParseAddr(event.ClientIP); err == nil {
+		allow.CIDRs = append(allow.CIDRs, netip.PrefixFrom(addr, addr.BitLen()).String())
+	}
+	return Appeal{Event: event, Exclusion: exclusion, Allow: allow}
+}
+
+// anomalyEvaluationRule reports whether a CRS rule blocks on the anomaly score or reports on it. Excluding them
+// would stop blocking altogether rather than let the appealed request through.
+func anomalyEvaluationRule(id int) bool {
+	return id >= 949000 && id < 950000 || id >= 959000 && id < 960000 || id >= 980000 && id < 981000
+}
+
+// requestPath returns the decoded path of a request URI, as REQUEST_FILENAME holds it, or empty when the URI cannot be
+// parsed
+func requestPath(uri string) string {
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil || !strings.HasPrefix(parsed.Path, "/") {
+		return ""
+	}
+	return parsed.Path
+}
+
+// exclusionDirective removes the rules for the path. Its ID is derived from the transaction ID in the 1000000 to
+// 1099999 range, so re-accepting the same appeal yields the same rule. It is empty when the path cannot be written
+// literally in the operator argument: quotes, backslashes and control characters would end or escape it, and %{
+// would be expanded as a macro. The stored exclusion still applies to such paths.
+func exclusionDirective(transactionID string, path string, ruleIDs []int) string {
+	if strings.ContainsAny(path, "\"\\") || strings.Contains(path, "%{") || strings.ContainsFunc(path, unicode.IsControl) {
+		return ""
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(transactionID))
+	actions := []string{fmt.Sprintf("id:%d", 1000000+hash.Sum32()%100000), "phase:1", "pass", "nolog"}
+	for _, id := range ruleIDs {
+		actions = append(actions, fmt.Sprintf("ctl:ruleRemoveById=%d", id))
+	}
+	return fmt.Sprintf(`SecRule REQUEST_FILENAME "@streq %s" "%s"`, path, strings.Join(actions, ","))
+}
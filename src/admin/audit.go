@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/adminaudit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+const defaultAuditLimit = 100
+
+func auditHandler(calls *adminaudit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultAuditLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_limit", "limit must be a positive integer")
+				return
+			}
+			limit = parsed
+		}
+		writeJSON(w, http.StatusOK, calls.Recent(limit))
+	}
+}
+
+// callerKey holds the caller of an admin API call once it is authorized, for the admin audit log
+type callerKey struct{}
+
+// setCaller hands the caller of the call to the admin audit log
+func setCaller(ctx context.Context, principal rbac.Principal) {
+	if caller, ok := ctx.Value(callerKey{}).(*rbac.Principal); ok {
+		*caller = principal
+	}
+}
+
+// recordCalls records every call of the route to the admin audit log with its caller, payload hash and result
+func recordCalls(calls *adminaudit.Log, key string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := &rbac.Principal{Actor: "unauthenticated"}
+		payload := &hashingReader{ReadCloser: r.Body, hash: sha256.New()}
+		r.Body = payload
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+
+		entry := adminaudit.Entry{
+			Actor:      caller.Actor,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Route:      key,
+			Path:       r.URL.Path,
+			Status:     recorder.status,
+			Result:     adminaudit.ResultOf(recorder.status),
+		}
+		if caller.Role != rbac.RoleNone {
+			entry.Role = caller.Role.String()
+		}
+		if payload.read > 0 {
+			entry.PayloadSHA256 = hex.EncodeToString(payload.hash.Sum(nil))
+		}
+		if err := calls.Record(entry); err != nil {
+			slog.Error("Failed to record admin API call", "error", err, "route", key, "actor", caller.Actor)
+		}
+	})
+}
+
+// hashingReader hashes the request body as the route reads it
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	read int64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	h.read += int64(n)
+	return n, err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+func banRoutes(bans *ban.List, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/bans", Summary: "List banned client IPs", Role: rbac.RoleViewer, Handler: listBansHandler(bans)},
+		{Method: http.MethodDelete, Path: "/bans/{ip}", Summary: "Lift the ban on a client IP", Role: rbac.RoleOperator, Handler: deleteBanHandler(bans, trail)},
+	}
+}
+
+func listBansHandler(bans *ban.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, bans.Entries())
+	}
+}
+
+func deleteBanHandler(bans *ban.List, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.PathValue("ip")
+		before, _ := bans.Banned(ip)
+		if !bans.Remove(ip) {
+			writeError(w, http.StatusNotFound, "admin.not_found", fmt.Sprintf("%s is not banned", ip))
+			return
+		}
+		recordChange(trail, r, "bans.delete", ip, before, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
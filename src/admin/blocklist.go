@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+)
+
+// BlockState is the combined block state of the WAF, exchanged with external tools such as SOAR playbooks. A section
+// left out of an imported document is left unchanged.
+type BlockState struct {
+	Bans         []ban.Entry                `json:"bans"`
+	IPLists      map[string]json.RawMessage `json:"ip_lists"`
+	BypassTokens map[string]json.RawMessage `json:"bypass_tokens"`
+}
+
+// Modes of a block state import
+const (
+	// blockImportMerge adds the imported bans and objects to the existing ones, replacing those with the same key
+	blockImportMerge = "merge"
+	// blockImportReplace replaces each imported section with the imported one
+	blockImportReplace = "replace"
+)
+
+func blocklistRoutes(bans *ban.List, s *store.Store, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/blocklist/export", Summary: "Export the bans, IP lists and bypass tokens", Role: rbac.RoleViewer, Handler: exportBlocklistHandler(bans, s)},
+		{Method: http.MethodPost, Path: "/blocklist/import", Summary: "Merge or replace the bans, IP lists and bypass tokens with a document, mode=merge or replace", Role: rbac.RoleAdmin, Handler: importBlocklistHandler(bans, s, trail)},
+	}
+}
+
+func exportBlocklistHandler(bans *ban.List, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := blockState(bans, s)
+		if !mayReadTokens(r) {
+			state.BypassTokens = redactObjects(store.CollectionBypassTokens, state.BypassTokens)
+		}
+		writeJSON(w, http.StatusOK, state)
+	}
+}
+
+func importBlocklistHandler(bans *ban.List, s *store.Store, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = blockImportMerge
+		}
+		if mode != blockImportMerge && mode != blockImportReplace {
+			writeError(w, http.StatusBadRequest, "admin.invalid_mode", fmt.Sprintf("mode must be %s or %s, got %q", blockImportMerge, blockImportReplace, mode))
+			return
+		}
+		var imported BlockState
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxObjectBodyBytes)).Decode(&imported); err != nil {
+			writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+			return
+		}
+		for _, entry := range imported.Bans {
+			if _, err := netip.ParseAddr(entry.IP); err != nil {
+				writeError(w, http.StatusBadRequest, "admin.invalid_body", fmt.Sprintf("invalid banned IP %q", entry.IP))
+				return
+			}
+			if entry.Expires.IsZero() {
+				writeError(w, http.StatusBadRequest, "admin.invalid_body", fmt.Sprintf("the ban of %s has no expiry", entry.IP))
+				return
+			}
+		}
+
+		before := blockState(bans, s)
+		snapshot := s.Export()
+		importCollection(snapshot, store.CollectionIPLists, imported.IPLists, mode)
+		importCollection(snapshot, store.CollectionBypassTokens, imported.BypassTokens, mode)
+		if err := s.Import(snapshot); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		switch {
+		case imported.Bans == nil:
+		case mode == blockImportReplace:
+			bans.Replace(imported.Bans)
+		default:
+			bans.AddEntries(imported.Bans)
+		}
+		after := blockState(bans, s)
+		before.BypassTokens = redactObjects(store.CollectionBypassTokens, before.BypassTokens)
+		redactedAfter := after
+		redactedAfter.BypassTokens = redactObjects(store.CollectionBypassTokens, after.BypassTokens)
+		recordChange(trail, r, "blocklist.import", mode, before, redactedAfter)
+		if !mayReadTokens(r) {
+			after = redactedAfter
+		}
+		writeJSON(w, http.StatusOK, after)
+	}
+}
+
+// importCollection merges or replaces the objects of the collection in the snapshot. Nil objects leave it unchanged.
+func importCollection(snapshot store.Snapshot, collection string, objects map[string]json.RawMessage, mode string) {
+	if objects == nil {
+		return
+	}
+	if mode == blockImportReplace {
+		snapshot[collection] = map[string]json.RawMessage{}
+	}
+	maps.Copy(snapshot[collection], objects)
+}
+
+func blockState(bans *ban.List, s *store.Store) BlockState {
+	snapshot := s.Export()
+	return BlockState{
+		Bans:         bans.Entries(),
+		IPLists:      snapshot[store.CollectionIPLists],
+		BypassTokens: snapshot[store.CollectionBypassTokens],
+	}
+}
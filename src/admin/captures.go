@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+const defaultCapturesLimit = 100
+
+func captureRoutes(store *capture.Store) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/captures", Summary: "List captured requests, newest first, filtered by client_ip, decision and rule", Role: rbac.RoleViewer, Handler: listCapturesHandler(store)},
+		{Method: http.MethodGet, Path: "/captures/{id}", Summary: "Get the captured request of a transaction, including headers and body", Role: rbac.RoleOperator, Handler: getCaptureHandler(store)},
+	}
+}
+
+func listCapturesHandler(store *capture.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := capture.Filter{ClientIP: query.Get("client_ip"), Decision: query.Get("decision"), Limit: defaultCapturesLimit}
+		if value := query.Get("rule"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_rule", "rule must be a rule ID")
+				return
+			}
+			filter.RuleID = parsed
+		}
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_limit", "limit must be a positive integer")
+				return
+			}
+			filter.Limit = parsed
+		}
+		writeJSON(w, http.StatusOK, store.List(filter))
+	}
+}
+
+func getCaptureHandler(store *capture.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, ok, err := store.Get(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "admin.capture_failed", err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "admin.unknown_capture", "no captured request for transaction "+r.PathValue("id"))
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+const defaultChangesLimit = 100
+
+func changesHandler(trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultChangesLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_limit", "limit must be a positive integer")
+				return
+			}
+			limit = parsed
+		}
+		writeJSON(w, http.StatusOK, trail.Recent(limit))
+	}
+}
+
+// recordChange adds a successful mutation to the change trail. A nil before or after means the object did not exist.
+func recordChange(trail *changes.Trail, r *http.Request, action string, target string, before any, after any) {
+	change := changes.Change{
+		Actor:      requestActor(r),
+		RemoteAddr: r.RemoteAddr,
+		Action:     action,
+		Target:     target,
+		Before:     marshalChangeValue(before),
+		After:      marshalChangeValue(after),
+	}
+	slog.Info("Configuration changed", "actor", change.Actor, "action", action, "target", target)
+	if err := trail.Record(change); err != nil {
+		slog.Error("Failed to record configuration change", "error", err, "action", action, "target", target)
+	}
+}
+
+// anonymousActor names the callers of the admin API when it is not authenticated
+const anonymousActor = "anonymous"
+
+// requestActor identifies who made an admin API call
+func requestActor(r *http.Request) string {
+	if principal, ok := rbac.FromContext(r.Context()); ok {
+		return principal.Actor
+	}
+	return anonymousActor
+}
+
+func marshalChangeValue(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	if raw, ok := value.(json.RawMessage); ok {
+		return raw
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+const (
+	corazaModule      = "github.com/corazawaf/coraza/v3"
+	coreRuleSetModule = "github.com/corazawaf/coraza-coreruleset/v4"
+)
+
+// ConfigReport is the configuration a running instance resolved at startup
+type ConfigReport struct {
+	// Settings are keyed by environment variable with defaults applied and secrets redacted
+	Settings       map[string]string `json:"settings"`
+	DirectivesHash string            `json:"directives_hash"`
+	Build          BuildInfo         `json:"build"`
+}
+
+// BuildInfo identifies the binary and the rule engine it was built with
+type BuildInfo struct {
+	Version     string `json:"version"`
+	Revision    string `json:"revision,omitempty"`
+	GoVersion   string `json:"go_version"`
+	Coraza      string `json:"coraza"`
+	CoreRuleSet string `json:"coreruleset"`
+}
+
+func configHandler(settings map[string]string, directives DirectiveReloader) http.HandlerFunc {
+	build := readBuildInfo()
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := ConfigReport{Settings: settings, Build: build}
+		if report.Settings == nil {
+			report.Settings = map[string]string{}
+		}
+		if directives != nil {
+			report.DirectivesHash = activeDirectiveHash(directives.DirectiveHistory())
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func readBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{Version: "unknown"}
+	}
+
+	build := BuildInfo{Version: info.Main.Version, GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			build.Revision = setting.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		switch dep.Path {
+		case corazaModule:
+			build.Coraza = dep.Version
+		case coreRuleSetModule:
+			build.CoreRuleSet = dep.Version
+		}
+	}
+	return build
+}
@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+func debugRoutes(debug *coraza.DebugCapture, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/debug/ips", Summary: "List the client IPs whose requests are debug captured", Role: rbac.RoleViewer, Handler: listDebugIPsHandler(debug)},
+		{Method: http.MethodPut, Path: "/debug/ips/{ip}", Summary: "Start debug capture for a client IP", Role: rbac.RoleOperator, Handler: enableDebugIPHandler(debug, trail)},
+		{Method: http.MethodDelete, Path: "/debug/ips/{ip}", Summary: "Stop debug capture for a client IP", Role: rbac.RoleOperator, Handler: disableDebugIPHandler(debug, trail)},
+		{Method: http.MethodGet, Path: "/debug/traces", Summary: "List captured debug traces, newest first", Role: rbac.RoleViewer, Handler: listDebugTracesHandler(debug)},
+		{Method: http.MethodGet, Path: "/debug/traces/{id}", Summary: "Get the debug trace of a transaction", Role: rbac.RoleViewer, Handler: getDebugTraceHandler(debug)},
+	}
+}
+
+func listDebugIPsHandler(debug *coraza.DebugCapture) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, debug.IPs())
+	}
+}
+
+func enableDebugIPHandler(debug *coraza.DebugCapture, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.PathValue("ip")
+		debug.EnableIP(ip)
+		recordChange(trail, r, "debug.enable", ip, nil, ip)
+		writeJSON(w, http.StatusOK, debug.IPs())
+	}
+}
+
+func disableDebugIPHandler(debug *coraza.DebugCapture, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.PathValue("ip")
+		if !debug.DisableIP(ip) {
+			writeError(w, http.StatusNotFound, "admin.not_found", fmt.Sprintf("%s is not being debug captured", ip))
+			return
+		}
+		recordChange(trail, r, "debug.disable", ip, ip, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func listDebugTracesHandler(debug *coraza.DebugCapture) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, debug.Traces())
+	}
+}
+
+func getDebugTraceHandler(debug *coraza.DebugCapture) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trace, ok := debug.Trace(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "admin.unknown_trace", "no debug trace for transaction "+r.PathValue("id"))
+			return
+		}
+		writeJSON(w, http.StatusOK, trace)
+	}
+}
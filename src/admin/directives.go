@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+// DirectiveReloader exposes the versions of the directives loaded into the WAF
+type DirectiveReloader interface {
+	DirectiveHistory() []coraza.DirectiveVersion
+	RollbackDirectives(hash string) error
+}
+
+func directiveRoutes(directives DirectiveReloader, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/directives/history", Summary: "List the directive sets loaded into the WAF", Role: rbac.RoleViewer, Handler: directiveHistoryHandler(directives)},
+		{Method: http.MethodPost, Path: "/directives/rollback/{hash}", Summary: "Roll the WAF back to a previously loaded directive set", Role: rbac.RoleAdmin, Handler: directiveRollbackHandler(directives, trail)},
+	}
+}
+
+func directiveHistoryHandler(directives DirectiveReloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, directives.DirectiveHistory())
+	}
+}
+
+func directiveRollbackHandler(directives DirectiveReloader, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		before := activeDirectiveHash(directives.DirectiveHistory())
+		err := directives.RollbackDirectives(r.PathValue("hash"))
+		switch {
+		case errors.Is(err, coraza.ErrUnknownDirectiveVersion):
+			writeError(w, http.StatusNotFound, "admin.unknown_directive_version", err.Error())
+		case err != nil:
+			writeError(w, http.StatusUnprocessableEntity, "admin.invalid_directives", err.Error())
+		default:
+			history := directives.DirectiveHistory()
+			recordChange(trail, r, "directives.rollback", "", before, activeDirectiveHash(history))
+			writeJSON(w, http.StatusOK, history)
+		}
+	}
+}
+
+func activeDirectiveHash(history []coraza.DirectiveVersion) string {
+	for _, v := range history {
+		if v.Active {
+			return v.Hash
+		}
+	}
+	return ""
+}
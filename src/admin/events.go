@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+)
+
+// eventsHandler queries the event store. from and to are RFC 3339 times; next pages are requested with the
+// cursor returned by the previous page.
+func eventsHandler(store *events.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		q := events.Query{ClientIP: query.Get("client_ip"), Host: query.Get("host"), Cursor: query.Get("cursor")}
+		for name, target := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+			if value := query.Get(name); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "admin.invalid_"+name, name+" must be an RFC 3339 time such as 2024-05-15T08:00:00Z")
+					return
+				}
+				*target = parsed
+			}
+		}
+		if value := query.Get("rule"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_rule", "rule must be a rule ID")
+				return
+			}
+			q.RuleID = parsed
+		}
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_limit", "limit must be a positive integer")
+				return
+			}
+			q.Limit = parsed
+		}
+
+		page, err := store.Query(q)
+		if errors.Is(err, events.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "admin.invalid_cursor", "cursor must be the next cursor of a previous page")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "admin.events_failed", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
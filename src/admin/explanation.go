@@ -0,0 +1,371 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+)
+
+// Policies that decided a transaction
+const (
+	policyAnomalyThreshold = "anomaly_threshold"
+	policyRule             = "rule"
+	policyDetectionOnly    = "detection_only"
+	policyCancelled        = "cancelled"
+	policyUnknown          = "unknown"
+)
+
+// Mechanisms deciding requests before or instead of the rules, checked against a transaction
+const (
+	bypassBan           = "ban"
+	bypassIPList        = "ip_list"
+	bypassToken         = "bypass_token"
+	bypassThreatIntel   = "threat_intel"
+	bypassASNPolicy     = "asn_policy"
+	bypassRuleEngineOff = "rule_engine_off"
+	bypassExclusion     = "rule_exclusion"
+	bypassFailOpen      = "fail_open"
+)
+
+// Effects of a mechanism on the requests it matches
+const (
+	effectAllow     = "allow"
+	effectReject    = "reject"
+	effectExempt    = "exempt"
+	effectRateLimit = "rate_limit"
+	effectExclude   = "exclude_rules"
+)
+
+// Explanation tells why the WAF decided a transaction the way it did, in terms a support engineer can relay
+type Explanation struct {
+	TransactionID string    `json:"transaction_id"`
+	Time          time.Time `json:"time"`
+	// Request is the method, host and URI of the request, and the client it came from
+	Request string `json:"request"`
+	// Outcome is blocked, allowed, cancelled or unknown
+	Outcome string `json:"outcome"`
+	// Summary is the explanation in a few sentences
+	Summary  string              `json:"summary"`
+	Rules    []ExplainedRule     `json:"rules"`
+	Score    ScoreExplanation    `json:"score"`
+	Policy   PolicyExplanation   `json:"policy"`
+	Bypasses []BypassExplanation `json:"bypasses"`
+}
+
+// ExplainedRule is a rule that matched the transaction
+type ExplainedRule struct {
+	ID       int    `json:"id"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	// Category is the kind of attack the rule detects, from its tags
+	Category string `json:"category,omitempty"`
+	File     string `json:"file,omitempty"`
+	// MatchedData is the rule's log data, for CRS rules what matched and where
+	MatchedData string `json:"matched_data,omitempty"`
+	// Points is the rule's estimated contribution to the anomaly score
+	Points int `json:"points"`
+}
+
+// ScoreExplanation is the inbound anomaly score of the transaction
+type ScoreExplanation struct {
+	Total int `json:"total"`
+	// Source tells whether the total was reported by the anomaly evaluation rule or estimated from the severities
+	Source string `json:"source"`
+}
+
+// PolicyExplanation is what turned the matched rules into the outcome
+type PolicyExplanation struct {
+	Name        string `json:"name"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// BypassExplanation is a mechanism that decides requests before or instead of the rules, checked against the
+// transaction as it is enforced now. Only the failure policy is checked as it was when the request was made.
+type BypassExplanation struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+	// Effect is what the mechanism does to the requests it matches: allow them without inspection, reject them,
+	// exempt them from the ASN policies that follow, rate limit them or exclude rules
+	Effect  string `json:"effect"`
+	Matches bool   `json:"matches"`
+	Detail  string `json:"detail"`
+}
+
+// RuleEngine reports whether the active directives switch the rule engine off, such as coraza.WAFHandler
+type RuleEngine interface {
+	RuleEngineOff() bool
+}
+
+// decisionState is the state of the mechanisms deciding requests besides the rules. Nil fields are disabled.
+type decisionState struct {
+	store       *store.Store
+	bans        *ban.List
+	asnPolicies *middleware.ASNPolicies
+	threatIntel middleware.ThreatIntelMatcher
+	failure     middleware.FailurePolicy
+	engine      RuleEngine
+}
+
+// severityPoints are the anomaly points CRS adds for a match of each severity
+var severityPoints = map[string]int{"critical": 5, "error": 4, "warning": 3, "notice": 2}
+
+// attackCategories name the CRS attack tags
+var attackCategories = map[string]string{
+	"attack-sqli":               "SQL injection",
+	"attack-xss":                "cross-site scripting",
+	"attack-rce":                "remote command execution",
+	"attack-lfi":                "local file inclusion",
+	"attack-rfi":                "remote file inclusion",
+	"attack-injection-php":      "PHP injection",
+	"attack-injection-java":     "Java injection",
+	"attack-injection-generic":  "code injection",
+	"attack-fixation":           "session fixation",
+	"attack-protocol":           "HTTP protocol violation",
+	"attack-disclosure":         "information disclosure",
+	"attack-reputation-scanner": "security scanner",
+	"attack-generic":            "generic attack",
+}
+
+// totalScorePattern finds the total in the message of the CRS anomaly evaluation rules
+var totalScorePattern = regexp.MustCompile(`Total Score: (\d+)`)
+
+// explanationHandler explains the decision of a stored transaction
+func explanationHandler(events *events.Store, state decisionState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event, ok, err := events.Get(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "admin.explanation_failed", err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "admin.unknown_transaction", "no stored event for transaction "+r.PathValue("id"))
+			return
+		}
+		writeJSON(w, http.StatusOK, explain(event, state))
+	}
+}
+
+func explain(event audit.Event, state decisionState) Explanation {
+	explanation := Explanation{
+		TransactionID: event.ID,
+		Time:          event.Time,
+		Request:       strings.TrimSpace(fmt.Sprintf("%s %s%s from %s", event.Method, event.Host, event.URI, event.ClientIP)),
+		Rules:         []ExplainedRule{},
+	}
+
+	var categories []string
+	var evaluation *audit.EventRule
+	estimated := 0
+	for i, rule := range event.Rules {
+		explained := ExplainedRule{ID: rule.ID, Message: rule.Message, Severity: rule.Severity, Category: category(rule.Tags), File: rule.File, MatchedData: rule.Data}
+		switch {
+		case anomalyEvaluationRule(rule.ID):
+			if evaluation == nil {
+				evaluation = &event.Rules[i]
+			}
+		case rule.ID >= 900000 && rule.ID < 1000000:
+			explained.Points = severityPoints[rule.Severity]
+			estimated += explained.Points
+		}
+		if explained.Category != "" && !slices.Contains(categories, explained.Category) {
+			categories = append(categories, explained.Category)
+		}
+		explanation.Rules = append(explanation.Rules, explained)
+	}
+
+	explanation.Score = ScoreExplanation{Total: estimated, Source: "estimated from the severities of the matched CRS rules"}
+	if evaluation != nil {
+		if match := totalScorePattern.FindStringSubmatch(evaluation.Message); match != nil {
+			total, _ := strconv.Atoi(match[1])
+			explanation.Score = ScoreExplanation{Total: total, Source: fmt.Sprintf("reported by rule %d", evaluation.ID)}
+		}
+	}
+
+	explanation.Outcome, explanation.Policy = decidingPolicy(event, evaluation, explanation.Score.Total)
+	explanation.Bypasses = bypasses(event, state)
+
+	summary := []string{explanation.Policy.Description}
+	if len(categories) > 0 {
+		summary = append(summary, "The request looked like "+joinWords(categories)+".")
+	}
+	for _, bypass := range explanation.Bypasses {
+		if bypass.Matches {
+			summary = append(summary, strings.ToUpper(bypass.Detail[:1])+bypass.Detail[1:]+".")
+		}
+	}
+	explanation.Summary = strings.Join(summary, " ")
+	return explanation
+}
+
+// decidingPolicy returns the outcome of the transaction and what decided it
+func decidingPolicy(event audit.Event, evaluation *audit.EventRule, score int) (string, PolicyExplanation) {
+	policy := PolicyExplanation{Status: event.Status}
+	switch {
+	case event.ClientCancelled:
+		policy.Name = policyCancelled
+		policy.Description = "Traefik gave up on the request before the WAF answered, so it was neither blocked nor allowed."
+		return "cancelled", policy
+	case event.Status == 0:
+		policy.Name = policyUnknown
+		policy.Description = "The WAF recorded no answer for the request."
+		return "unknown", policy
+	case event.Status < 400:
+		policy.Name = policyDetectionOnly
+		policy.Description = fmt.Sprintf("The request was allowed with status %d: rules matched, but the anomaly score of %d stayed below the blocking threshold or the rule engine only detects.", event.Status, score)
+		return "allowed", policy
+	case evaluation != nil:
+		policy.Name = policyAnomalyThreshold
+		policy.Description = fmt.Sprintf("The request was blocked with status %d: the anomaly score of %d reached the blocking threshold, so rule %d blocked it.", event.Status, score, evaluation.ID)
+		return "blocked", policy
+	}
+	policy.Name = policyRule
+	policy.Description = fmt.Sprintf("The request was blocked with status %d by a rule that denies on its own, without waiting for the anomaly score.", event.Status)
+	if len(event.Rules) > 0 {
+		policy.Description = fmt.Sprintf("The request was blocked with status %d by rule %d, which denies on its own without waiting for the anomaly score.", event.Status, event.Rules[len(event.Rules)-1].ID)
+	}
+	return "blocked", policy
+}
+
+// bypasses checks the mechanisms deciding requests besides the rules against the transaction, in the order the WAF
+// applies them
+func bypasses(event audit.Event, state decisionState) []BypassExplanation {
+	checks := []BypassExplanation{}
+	addr, addrErr := netip.ParseAddr(event.ClientIP)
+	uri, uriErr := url.ParseRequestURI(event.URI)
+
+	if state.bans != nil {
+		if entry, ok := state.bans.Banned(event.ClientIP); ok {
+			checks = append(checks, BypassExplanation{Kind: bypassBan, Effect: effectReject, Matches: true, Detail: fmt.Sprintf("%s is now banned until %s (%s), so its requests are rejected before inspection", event.ClientIP, entry.Expires.Format(time.RFC3339), entry.Reason)})
+		} else {
+			checks = append(checks, BypassExplanation{Kind: bypassBan, Effect: effectReject, Detail: fmt.Sprintf("%s is not banned now", event.ClientIP)})
+		}
+	}
+
+	var enforced *store.Enforced
+	if state.store != nil {
+		enforced = state.store.Enforced()
+		check := BypassExplanation{Kind: bypassIPList, Effect: effectAllow, Detail: fmt.Sprintf("no IP list contains %s now", event.ClientIP)}
+		if addrErr == nil {
+			if action, key, ok := enforced.MatchIP(addr); ok {
+				check = BypassExplanation{Kind: bypassIPList, Name: key, Effect: effectAllow, Matches: true, Detail: fmt.Sprintf("IP list %s now allows %s, so its requests are let through without inspection", key, event.ClientIP)}
+				if action == store.IPListDeny {
+					check.Effect = effectReject
+					check.Detail = fmt.Sprintf("IP list %s now denies %s, so its requests are rejected before inspection", key, event.ClientIP)
+				}
+			}
+		}
+		checks = append(checks, check)
+		if len(storedObjects(state.store, store.CollectionBypassTokens)) > 0 {
+			checks = append(checks, BypassExplanation{Kind: bypassToken, Effect: effectAllow, Detail: "bypass tokens are not recorded with events, so whether the request presented one is unknown"})
+		}
+	}
+
+	if state.threatIntel != nil && uriErr == nil {
+		check := BypassExplanation{Kind: bypassThreatIntel, Effect: effectReject, Detail: "the request matches no blocking threat intel indicator now"}
+		if match, ok := state.threatIntel.Match(addr, event.Host, uri.EscapedPath(), uri.RawQuery); ok && match.Action == threatintel.ActionBlock {
+			check = BypassExplanation{Kind: bypassThreatIntel, Name: match.Feed, Effect: effectReject, Matches: true, Detail: fmt.Sprintf("the request now matches indicator %s of the blocking feed %s, so it is rejected before inspection", match.IndicatorID, match.Feed)}
+		}
+		checks = append(checks, check)
+	}
+
+	if state.asnPolicies != nil && addrErr == nil && uriErr == nil {
+		if policy, asn, ok := state.asnPolicies.Policy(addr, uri.Path); ok {
+			check := BypassExplanation{Kind: bypassASNPolicy, Name: fmt.Sprintf("AS%d", asn), Matches: true}
+			switch policy.Action {
+			case middleware.ASNDeny:
+				check.Effect = effectReject
+				check.Detail = fmt.Sprintf("AS%d is now denied on %s, so its requests are rejected before inspection", asn, uri.Path)
+			case middleware.ASNLimit:
+				check.Effect = effectRateLimit
+				check.Detail = fmt.Sprintf("AS%d is now rate limited on %s, but its requests within the limit are still inspected", asn, uri.Path)
+			default:
+				check.Effect = effectExempt
+				check.Detail = fmt.Sprintf("AS%d is now allowed on %s, which exempts it from the ASN policies that follow but not from inspection", asn, uri.Path)
+			}
+			checks = append(checks, check)
+		}
+	}
+
+	if state.engine != nil {
+		if state.engine.RuleEngineOff() {
+			checks = append(checks, BypassExplanation{Kind: bypassRuleEngineOff, Effect: effectAllow, Matches: true, Detail: "the rule engine is now off in the active directives, so every request is let through without inspection"})
+		} else {
+			checks = append(checks, BypassExplanation{Kind: bypassRuleEngineOff, Effect: effectAllow, Detail: "the rule engine is on in the active directives"})
+		}
+	}
+
+	if enforced != nil && uriErr == nil {
+		var removed []string
+		for _, rule := range event.Rules {
+			if slices.Contains(enforced.Excluded(uri.Path), rule.ID) {
+				removed = append(removed, strconv.Itoa(rule.ID))
+			}
+		}
+		if len(removed) > 0 {
+			checks = append(checks, BypassExplanation{Kind: bypassExclusion, Effect: effectExclude, Matches: true, Detail: fmt.Sprintf("rules %s are now removed on %s", strings.Join(removed, ", "), uri.Path)})
+		} else {
+			checks = append(checks, BypassExplanation{Kind: bypassExclusion, Effect: effectExclude, Detail: fmt.Sprintf("no stored exclusion removes the matched rules on %s now", uri.Path)})
+		}
+	}
+
+	// The WAF records no response status when it fails to evaluate a request, leaving the answer to the failure policy
+	if event.Status == 0 && !event.ClientCancelled {
+		check := BypassExplanation{Kind: bypassFailOpen, Effect: effectAllow, Detail: "the WAF failed to evaluate the request and the failure mode is closed, so it was rejected"}
+		if state.failure.Mode(middleware.FailureClassBodyRead) == middleware.FailOpen {
+			check.Matches = true
+			check.Detail = "the WAF failed to evaluate the request and the failure mode is open, so it was let through without inspection"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// storedObjects lists a collection, nil when there is no store or it fails
+func storedObjects(s *store.Store, collection string) map[string]json.RawMessage {
+	if s == nil {
+		return nil
+	}
+	objects, err := s.List(collection)
+	if err != nil {
+		return nil
+	}
+	return objects
+}
+
+// category names the kind of attack a rule's tags point to, empty when none does
+func category(tags []string) string {
+	for _, tag := range tags {
+		if name, ok := attackCategories[tag]; ok {
+			return name
+		}
+	}
+	for _, tag := range tags {
+		if name, ok := strings.CutPrefix(tag, "attack-"); ok {
+			return strings.ReplaceAll(name, "-", " ")
+		}
+	}
+	return ""
+}
+
+// joinWords joins words as in a sentence, "a, b and c"
+func joinWords(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
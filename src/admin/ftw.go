@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ftw"
+)
+
+// ftwHandler runs the go-ftw regression tests through the WAF handler. The optional rule query parameter
+// limits the run to rule IDs with that prefix. It responds with 503 when any test fails.
+func ftwHandler(wafHandler http.Handler, tests fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tests == nil {
+			writeError(w, http.StatusNotFound, "admin.ftw_unavailable", "no FTW tests are configured")
+			return
+		}
+
+		report, err := ftw.Run(wafHandler, tests, r.URL.Query().Get("rule"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "admin.ftw_error", err.Error())
+			return
+		}
+		if !report.Passed {
+			slog.Warn("FTW regression tests failed", "total", report.Total, "failed", report.Failed)
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+// grafanaRoutes expose Grafana provisioning generated from the registered metrics
+func grafanaRoutes() []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/metrics/catalog", Summary: "Every metric exposed by the service with its labels", Role: rbac.RoleViewer, Handler: func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, metrics.Catalog())
+		}},
+		{Method: http.MethodGet, Path: "/grafana/dashboard.json", Summary: "Grafana dashboard matching the exposed metrics", Role: rbac.RoleViewer, Handler: func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, metrics.GrafanaDashboard())
+		}},
+		{Method: http.MethodGet, Path: "/grafana/alert-rules.json", Summary: "Grafana alert rule provisioning for the exposed metrics", Role: rbac.RoleViewer, Handler: func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, metrics.GrafanaAlertRules())
+		}},
+	}
+}
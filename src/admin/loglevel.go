@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+// logLevelRequest changes the log level. Duration is optional and defaults to the configured revert duration.
+type logLevelRequest struct {
+	Level    string `json:"level"`
+	Duration string `json:"duration"`
+}
+
+func logLevelRoutes(levels *loglevel.Controller, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/loglevel", Summary: "Get the current log level", Role: rbac.RoleViewer, Handler: getLogLevelHandler(levels)},
+		{Method: http.MethodPut, Path: "/loglevel", Summary: "Change the log level until it reverts", Role: rbac.RoleOperator, Handler: setLogLevelHandler(levels, trail)},
+		{Method: http.MethodDelete, Path: "/loglevel", Summary: "Revert to the configured log level", Role: rbac.RoleOperator, Handler: resetLogLevelHandler(levels, trail)},
+	}
+}
+
+func getLogLevelHandler(levels *loglevel.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, levels.State())
+	}
+}
+
+func setLogLevelHandler(levels *loglevel.Controller, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body logLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+			writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+			return
+		}
+		var duration time.Duration
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d < 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_body", "duration must be a positive duration such as 15m")
+				return
+			}
+			duration = d
+		}
+
+		before := levels.State()
+		levels.Set(level, duration)
+		after := levels.State()
+		recordChange(trail, r, "loglevel.set", "", before, after)
+		writeJSON(w, http.StatusOK, after)
+	}
+}
+
+func resetLogLevelHandler(levels *loglevel.Controller, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		before := levels.State()
+		levels.Reset()
+		after := levels.State()
+		recordChange(trail, r, "loglevel.reset", "", before, after)
+		writeJSON(w, http.StatusOK, after)
+	}
+}
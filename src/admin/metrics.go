@@ -0,0 +1,20 @@
+package admin
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricRateLimited = metrics.NewCounterVec(
+	"waf_admin_rate_limited_total",
+	"The total number of admin API calls refused with 429, by whether the client exceeded the rate limit or is locked out",
+	[]string{"reason"},
+)
+
+var metricAuthFailures = metrics.NewCounter(
+	"waf_admin_auth_failures_total",
+	"The total number of admin API calls and logins that failed authentication",
+)
+
+var metricLockouts = metrics.NewCounter(
+	"waf_admin_lockouts_total",
+	"The total number of clients locked out of the admin API after repeated authentication failures",
+	metrics.WithAlertAbove(0),
+)
@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+)
+
+const maxObjectBodyBytes = 10 << 20
+
+// redactedToken replaces the values of bypass tokens shown to callers below the admin role and in the change trail
+const redactedToken = "[redacted]"
+
+// objectRoutes exposes CRUD and export/import endpoints for the persistent store
+func objectRoutes(s *store.Store, trail *changes.Trail) []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/objects/{collection}", Summary: "List the objects in a collection", Role: rbac.RoleViewer, Handler: listObjectsHandler(s)},
+		{Method: http.MethodGet, Path: "/objects/{collection}/{key}", Summary: "Get an object", Role: rbac.RoleViewer, Handler: getObjectHandler(s)},
+		{Method: http.MethodPut, Path: "/objects/{collection}/{key}", Summary: "Create or replace an object", Role: rbac.RoleOperator, Handler: putObjectHandler(s, trail)},
+		{Method: http.MethodDelete, Path: "/objects/{collection}/{key}", Summary: "Delete an object", Role: rbac.RoleOperator, Handler: deleteObjectHandler(s, trail)},
+		{Method: http.MethodGet, Path: "/export", Summary: "Export every stored object", Role: rbac.RoleViewer, Handler: exportHandler(s)},
+		{Method: http.MethodPost, Path: "/import", Summary: "Replace every stored object with an exported document", Role: rbac.RoleAdmin, Handler: importHandler(s, trail)},
+	}
+}
+
+func listObjectsHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection := r.PathValue("collection")
+		objects, err := s.List(collection)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if !mayReadTokens(r) {
+			objects = redactObjects(collection, objects)
+		}
+		writeJSON(w, http.StatusOK, objects)
+	}
+}
+
+func getObjectHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection := r.PathValue("collection")
+		value, ok, err := s.Get(collection, r.PathValue("key"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "admin.object_not_found", "no object with key "+r.PathValue("key"))
+			return
+		}
+		if !mayReadTokens(r) {
+			value = redactObject(collection, value)
+		}
+		writeJSON(w, http.StatusOK, value)
+	}
+}
+
+func putObjectHandler(s *store.Store, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectBodyBytes))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+			return
+		}
+		collection, key := r.PathValue("collection"), r.PathValue("key")
+		before, _, _ := s.Get(collection, key)
+		if err := s.Put(collection, key, body); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		recordChange(trail, r, "objects.put", collection+"/"+key, redactObject(collection, before), redactObject(collection, body))
+		writeJSON(w, http.StatusOK, json.RawMessage(body))
+	}
+}
+
+func deleteObjectHandler(s *store.Store, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, key := r.PathValue("collection"), r.PathValue("key")
+		before, _, _ := s.Get(collection, key)
+		if err := s.Delete(collection, key); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		recordChange(trail, r, "objects.delete", collection+"/"+key, redactObject(collection, before), nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func exportHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot := s.Export()
+		if !mayReadTokens(r) {
+			snapshot = redactSnapshot(snapshot)
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
+
+func importHandler(s *store.Store, trail *changes.Trail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var snapshot store.Snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxObjectBodyBytes)).Decode(&snapshot); err != nil {
+			writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+			return
+		}
+		before := s.Export()
+		if err := s.Import(snapshot); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		after := s.Export()
+		recordChange(trail, r, "objects.import", "", redactSnapshot(before), redactSnapshot(after))
+		if !mayReadTokens(r) {
+			after = redactSnapshot(after)
+		}
+		writeJSON(w, http.StatusOK, after)
+	}
+}
+
+// mayReadTokens reports whether the caller may read the values of bypass tokens, which only admins may
+func mayReadTokens(r *http.Request) bool {
+	principal, ok := rbac.FromContext(r.Context())
+	return ok && principal.Role >= rbac.RoleAdmin
+}
+
+// redactSnapshot returns a copy of the snapshot with the values of bypass tokens redacted
+func redactSnapshot(snapshot store.Snapshot) store.Snapshot {
+	redacted := maps.Clone(snapshot)
+	redacted[store.CollectionBypassTokens] = redactObjects(store.CollectionBypassTokens, snapshot[store.CollectionBypassTokens])
+	return redacted
+}
+
+// redactObjects returns a copy of the objects of the collection with the values of bypass tokens redacted
+func redactObjects(collection string, objects map[string]json.RawMessage) map[string]json.RawMessage {
+	if collection != store.CollectionBypassTokens || objects == nil {
+		return objects
+	}
+	redacted := make(map[string]json.RawMessage, len(objects))
+	for key, value := range objects {
+		redacted[key] = redactObject(collection, value)
+	}
+	return redacted
+}
+
+// redactObject returns the object with the value of a bypass token redacted. Objects that are not valid tokens are
+// redacted whole.
+func redactObject(collection string, value json.RawMessage) json.RawMessage {
+	if collection != store.CollectionBypassTokens || value == nil {
+		return value
+	}
+	var token store.BypassToken
+	if err := json.Unmarshal(value, &token); err != nil {
+		redacted, _ := json.Marshal(redactedToken)
+		return redacted
+	}
+	token.Token = redactedToken
+	redacted, _ := json.Marshal(token)
+	return redacted
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrUnknownCollection) {
+		writeError(w, http.StatusNotFound, "admin.unknown_collection", err.Error())
+		return
+	}
+	if errors.Is(err, store.ErrInvalidValue) {
+		writeError(w, http.StatusBadRequest, "admin.invalid_body", err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "admin.store_error", err.Error())
+}
@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+)
+
+// RateLimitOptions limits the admin API calls of each client IP
+type RateLimitOptions struct {
+	// Rate is the sustained number of mutating calls per second allowed per client. Zero disables the limit.
+	Rate float64
+	// Burst is the number of mutating calls a client may make at once
+	Burst int
+	// MaxAuthFailures locks a client out after this many failed authentications within AuthFailureWindow. Zero
+	// disables lockout.
+	MaxAuthFailures   int
+	AuthFailureWindow time.Duration
+	// LockoutDuration is how long a locked out client is refused every admin API call and login
+	LockoutDuration time.Duration
+}
+
+// pruneInterval is how often the state of idle clients is dropped
+const pruneInterval = time.Minute
+
+// rateLimiter keeps a token bucket and the recent authentication failures of each client
+type rateLimiter struct {
+	options RateLimitOptions
+	now     func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimit
+	lastPrune time.Time
+}
+
+type clientLimit struct {
+	tokens    float64
+	refilled  time.Time
+	failures  int
+	firstFail time.Time
+	lockedOut time.Time
+}
+
+func newRateLimiter(options RateLimitOptions) *rateLimiter {
+	return &rateLimiter{options: options, now: time.Now, clients: map[string]*clientLimit{}}
+}
+
+// client returns the state of the client IP, dropping idle clients now and then. The caller holds mu.
+func (l *rateLimiter) client(ip string, now time.Time) *clientLimit {
+	if now.Sub(l.lastPrune) >= pruneInterval {
+		l.lastPrune = now
+		for key, c := range l.clients {
+			if now.After(c.lockedOut) && now.Sub(c.firstFail) >= l.options.AuthFailureWindow && l.refill(c, now) >= float64(l.options.Burst) {
+				delete(l.clients, key)
+			}
+		}
+	}
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientLimit{tokens: float64(l.options.Burst), refilled: now}
+		l.clients[ip] = c
+	}
+	return c
+}
+
+func (l *rateLimiter) refill(c *clientLimit, now time.Time) float64 {
+	c.tokens = math.Min(float64(l.options.Burst), c.tokens+now.Sub(c.refilled).Seconds()*l.options.Rate)
+	c.refilled = now
+	return c.tokens
+}
+
+// admit reports whether the client may make a call, and otherwise the reason and how long it should wait
+func (l *rateLimiter) admit(ip string, mutating bool) (bool, string, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(ip, now)
+	if now.Before(c.lockedOut) {
+		return false, "locked_out", c.lockedOut.Sub(now)
+	}
+	if !mutating || l.options.Rate <= 0 {
+		return true, "", 0
+	}
+	if l.refill(c, now) < 1 {
+		return false, "rate", time.Duration((1 - c.tokens) / l.options.Rate * float64(time.Second))
+	}
+	c.tokens--
+	return true, "", 0
+}
+
+// failed counts a failed authentication of the client, locking it out after too many
+func (l *rateLimiter) failed(ip string) {
+	metricAuthFailures.Inc()
+	if l.options.MaxAuthFailures <= 0 {
+		return
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(ip, now)
+	if now.Sub(c.firstFail) >= l.options.AuthFailureWindow {
+		c.failures, c.firstFail = 0, now
+	}
+	c.failures++
+	if c.failures >= l.options.MaxAuthFailures {
+		c.failures = 0
+		c.lockedOut = now.Add(l.options.LockoutDuration)
+		metricLockouts.Inc()
+		slog.Warn("Admin client locked out after repeated authentication failures", "client_ip", ip, "until", c.lockedOut)
+	}
+}
+
+// limit refuses the calls of locked out clients and the mutating calls of clients over the rate limit with 429, and
+// counts the failed authentications of every call
+func (l *rateLimiter) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := middleware.ClientIP(r)
+		mutating := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		if ok, reason, wait := l.admit(ip, mutating); !ok {
+			metricRateLimited.WithLabelValues(reason).Inc()
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+			code, message := "admin.rate_limited", "too many admin API calls, slow down"
+			if reason == "locked_out" {
+				code, message = "admin.locked_out", "too many failed authentications, try again later"
+			}
+			writeError(w, http.StatusTooManyRequests, code, message)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusUnauthorized {
+			l.failed(ip)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(RateLimitOptions{Rate: 1, Burst: 2, MaxAuthFailures: 3, AuthFailureWindow: time.Minute, LockoutDuration: 10 * time.Minute})
+	limiter.now = func() time.Time { return now }
+	status := http.StatusOK
+	handler := limiter.limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	serve := func(method string, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/bans", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should limit the mutating calls of each client", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("POST", "10.0.0.1:1234").Code)
+		assert.Equal(t, http.StatusOK, serve("POST", "10.0.0.1:1234").Code)
+		w := serve("POST", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Contains(t, w.Body.String(), "admin.rate_limited")
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusOK, serve("GET", "10.0.0.1:1234").Code, "Expected reads not to be limited")
+		assert.Equal(t, http.StatusOK, serve("POST", "10.0.0.2:1234").Code, "Expected other clients not to be limited")
+
+		now = now.Add(time.Second)
+		assert.Equal(t, http.StatusOK, serve("POST", "10.0.0.1:1234").Code, "Expected the bucket to refill")
+	})
+
+	t.Run("Should lock out clients after repeated authentication failures", func(t *testing.T) {
+		status = http.StatusUnauthorized
+		serve("GET", "10.0.0.3:1234")
+		now = now.Add(2 * time.Minute)
+		serve("GET", "10.0.0.3:1234")
+		serve("GET", "10.0.0.3:1234")
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", "10.0.0.3:1234").Code, "Expected failures outside the window not to count")
+
+		status = http.StatusOK
+		w := serve("GET", "10.0.0.3:1234")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Contains(t, w.Body.String(), "admin.locked_out")
+		assert.Equal(t, "600", w.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, serve("GET", "10.0.0.4:1234").Code)
+
+		now = now.Add(10 * time.Minute)
+		assert.Equal(t, http.StatusOK, serve("GET", "10.0.0.3:1234").Code, "Expected the lockout to expire")
+	})
+
+	t.Run("Should drop idle clients", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		serve("GET", "10.0.0.5:1234")
+		assert.Len(t, limiter.clients, 1)
+	})
+}
@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+)
+
+// RuleLister lists the rules loaded into the WAF
+type RuleLister interface {
+	Rules() []coraza.RuleInfo
+}
+
+// rulesHandler lists the loaded rules, only those with every tag given by the tag query parameters
+func rulesHandler(lister RuleLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags := r.URL.Query()["tag"]
+		rules := []coraza.RuleInfo{}
+		for _, rule := range lister.Rules() {
+			if !slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(rule.Tags, tag) }) {
+				rules = append(rules, rule)
+			}
+		}
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
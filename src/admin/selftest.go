@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+)
+
+// selfTestHandler runs the canned attack and benign requests through the WAF handler.
+// It responds with 503 when any case fails so deploy pipelines can check the status code alone.
+func selfTestHandler(wafHandler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := coraza.RunSelfTest(wafHandler)
+		if !report.Passed {
+			slog.Warn("WAF self-test failed", "categories", report.Categories)
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+)
+
+const defaultSummaryTopN = 10
+
+// summaryHandler reports the top attackers, targeted paths and rules over the requested window
+func summaryHandler(summarizer *audit.Summarizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := time.Hour
+		if value := r.URL.Query().Get("window"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 || parsed > audit.MaxSummaryWindow {
+				writeError(w, http.StatusBadRequest, "admin.invalid_window", fmt.Sprintf("window must be a positive duration of at most %s such as 1h or 24h", audit.MaxSummaryWindow))
+				return
+			}
+			window = parsed
+		}
+
+		n := defaultSummaryTopN
+		if value := r.URL.Query().Get("n"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "admin.invalid_n", "n must be a positive integer")
+				return
+			}
+			n = parsed
+		}
+
+		writeJSON(w, http.StatusOK, summarizer.Summary(window, n))
+	}
+}
+
+// heatmapHandler reports hourly rule hit counts over the last 24 hours
+func heatmapHandler(heatmap *audit.RuleHeatmap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, heatmap.Heatmap())
+	}
+}
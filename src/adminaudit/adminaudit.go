@@ -0,0 +1,213 @@
+// Package adminaudit records every admin API call to a hash-chained, append-only log, so a deleted or edited entry
+// is detected when the log is verified
+package adminaudit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecent is the number of entries kept in memory for the admin API
+const maxRecent = 1000
+
+// Entry is a single admin API call
+type Entry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Role       string    `json:"role,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Method     string    `json:"method"`
+	// Route is the pattern of the route called, empty when no route matches
+	Route string `json:"route,omitempty"`
+	Path  string `json:"path"`
+	// PayloadSHA256 is the hash of the request body read by the route, empty without a body
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+	Status        int    `json:"status"`
+	// Result is "success", "denied" for refused calls, or "failure"
+	Result string `json:"result"`
+	// PrevHash is the hash of the previous entry, chaining every entry to the ones before it
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ResultOf classifies the response status of a call
+func ResultOf(status int) string {
+	switch {
+	case status < 400:
+		return "success"
+	case status == 401 || status == 403:
+		return "denied"
+	default:
+		return "failure"
+	}
+}
+
+// Log appends admin API calls to a JSON lines file. Each entry carries the hash of the previous one and its own hash,
+// keyed with HMAC-SHA256 when a key is set so the chain cannot be recomputed without it. A log without a path keeps
+// the recent entries in memory only.
+type Log struct {
+	now func() time.Time
+	key []byte
+
+	mu     sync.Mutex
+	file   *os.File
+	seq    int64
+	last   string
+	recent []Entry
+}
+
+// Open opens the log at path, continuing the chain of the entries already recorded
+func Open(path string, key []byte) (*Log, error) {
+	l := &Log{now: time.Now, key: key}
+	if path == "" {
+		return l, nil
+	}
+
+	if existing, err := os.Open(path); err == nil {
+		err := readEntries(existing, func(entry Entry) {
+			l.seq, l.last = entry.Seq, entry.Hash
+			l.remember(entry)
+		})
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin audit log: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read admin audit log: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open admin audit log: %w", err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// Record appends the call to the log, stamping it with the current time and chaining it to the previous entry
+func (l *Log) Record(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	entry.Seq = l.seq
+	entry.Time = l.now().UTC()
+	entry.PrevHash = l.last
+	entry.Hash = entryHash(l.key, entry)
+	l.last = entry.Hash
+	l.remember(entry)
+	if l.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write admin audit log: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// Recent returns up to n of the most recent entries, newest first
+func (l *Log) Recent(n int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n = min(n, len(l.recent))
+	recent := make([]Entry, 0, n)
+	for i := len(l.recent) - 1; i >= len(l.recent)-n; i-- {
+		recent = append(recent, l.recent[i])
+	}
+	return recent
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func (l *Log) remember(entry Entry) {
+	l.recent = append(l.recent, entry)
+	if len(l.recent) > maxRecent {
+		l.recent = l.recent[len(l.recent)-maxRecent:]
+	}
+}
+
+// Verify checks the chain of the log at path, returning the number of entries verified. An error names the first
+// entry that was edited, removed or inserted.
+func Verify(path string, key []byte) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	verified := 0
+	var prev Entry
+	var chainErr error
+	err = readEntries(file, func(entry Entry) {
+		if chainErr != nil {
+			return
+		}
+		switch {
+		case entry.Seq != prev.Seq+1:
+			chainErr = fmt.Errorf("entry %d follows entry %d", entry.Seq, prev.Seq)
+		case entry.PrevHash != prev.Hash:
+			chainErr = fmt.Errorf("entry %d does not chain to entry %d", entry.Seq, prev.Seq)
+		case !hmac.Equal([]byte(entry.Hash), []byte(entryHash(key, entry))):
+			chainErr = fmt.Errorf("entry %d does not match its hash", entry.Seq)
+		default:
+			verified++
+			prev = entry
+		}
+	})
+	if err != nil {
+		return verified, err
+	}
+	return verified, chainErr
+}
+
+func readEntries(r io.Reader, f func(Entry)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("malformed entry on line %d: %w", line, err)
+		}
+		f(entry)
+	}
+	return scanner.Err()
+}
+
+// entryHash hashes the entry without its own hash, which covers the previous hash
+func entryHash(key []byte, entry Entry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package adminaudit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin-audit.log")
+	key := []byte("secret")
+
+	log, err := Open(path, key)
+	require.NoError(t, err)
+	log.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	require.NoError(t, log.Record(Entry{Actor: "jane@example.com", Method: "POST", Route: "POST /import", Path: "/api/v1/import", Status: 200, Result: "success"}))
+	require.NoError(t, log.Record(Entry{Actor: "anonymous", Method: "GET", Route: "GET /changes", Path: "/api/v1/changes", Status: 403, Result: "denied"}))
+	require.NoError(t, log.Close())
+
+	t.Run("Should chain the entries across restarts", func(t *testing.T) {
+		reopened, err := Open(path, key)
+		require.NoError(t, err)
+		require.NoError(t, reopened.Record(Entry{Actor: "jane@example.com", Method: "GET", Path: "/api/v1/audit", Status: 200, Result: "success"}))
+		require.NoError(t, reopened.Close())
+
+		recent := reopened.Recent(10)
+		require.Len(t, recent, 3)
+		assert.Equal(t, int64(3), recent[0].Seq)
+		assert.Equal(t, recent[1].Hash, recent[0].PrevHash)
+		assert.Equal(t, "POST /import", recent[2].Route)
+		assert.Empty(t, recent[2].PrevHash)
+
+		verified, err := Verify(path, key)
+		require.NoError(t, err)
+		assert.Equal(t, 3, verified)
+	})
+
+	t.Run("Should detect edited and removed entries", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+
+		edited := filepath.Join(t.TempDir(), "edited.log")
+		require.NoError(t, os.WriteFile(edited, []byte(strings.Replace(string(data), `"status":403,"result":"denied"`, `"status":200,"result":"success"`, 1)), 0o600))
+		verified, err := Verify(edited, key)
+		assert.EqualError(t, err, "entry 2 does not match its hash")
+		assert.Equal(t, 1, verified)
+
+		removed := filepath.Join(t.TempDir(), "removed.log")
+		require.NoError(t, os.WriteFile(removed, []byte(lines[0]+lines[2]), 0o600))
+		_, err = Verify(removed, key)
+		assert.EqualError(t, err, "entry 3 follows entry 1")
+
+		_, err = Verify(path, []byte("another key"))
+		assert.EqualError(t, err, "entry 1 does not match its hash", "Expected the chain not to verify without the key")
+	})
+}
@@ -0,0 +1,231 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// Alert is a rule crossing its threshold for one group
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Description string            `json:"description,omitempty"`
+	Severity    string            `json:"severity"`
+	Group       map[string]string `json:"group,omitempty"`
+	// Count is the number of matching violations within the window, which is more than the threshold
+	Count     int       `json:"count"`
+	Threshold int       `json:"threshold"`
+	Window    string    `json:"window"`
+	FiredAt   time.Time `json:"fired_at"`
+	// TransactionIDs are the most recent matching transactions, to look up in the audit log
+	TransactionIDs []string `json:"transaction_ids"`
+}
+
+// Summary is a one-line description of the alert for chat and paging
+func (a Alert) Summary() string {
+	var group []string
+	for _, key := range slices.Sorted(maps.Keys(a.Group)) {
+		group = append(group, key+"="+a.Group[key])
+	}
+	summary := fmt.Sprintf("WAF alert %s: %d violations in %s (threshold %d)", a.Rule, a.Count, a.Window, a.Threshold)
+	if len(group) > 0 {
+		summary += " for " + strings.Join(group, ", ")
+	}
+	return summary
+}
+
+// maxTransactionIDs bounds the transaction IDs carried by an alert
+const maxTransactionIDs = 10
+
+type hit struct {
+	time time.Time
+	id   string
+}
+
+// window holds the most recent matching violations of one group, at most threshold+1 of them as that is
+// enough to tell whether the threshold is crossed
+type window struct {
+	hits      []hit
+	lastFired time.Time
+}
+
+type rule struct {
+	Rule
+	minSeverity types.RuleSeverity
+	windows     map[string]*window
+}
+
+// Engine evaluates the alert rules against every violation. It is an audit.Sink.
+type Engine struct {
+	rules    []*rule
+	notifier *notifier
+	logger   *slog.Logger
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+type Options struct {
+	// Path of the YAML rules file. An empty path disables alerting.
+	Path string
+	// Timeout bounds each notification
+	Timeout time.Duration
+	// Transport carries the notifications. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// New loads the rules file and builds its engine
+func New(options Options) (*Engine, error) {
+	config, err := Load(options.Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(config, options), nil
+}
+
+// NewEngine builds the engine of loaded rules
+func NewEngine(config *Config, options Options) *Engine {
+	engine := &Engine{notifier: newNotifier(config.Receivers, options.Transport, options.Timeout), logger: slog.Default(), now: time.Now}
+	for _, r := range config.Rules {
+		compiled := &rule{Rule: r, minSeverity: types.RuleSeverityDebug, windows: map[string]*window{}}
+		if r.Match.MinSeverity != "" {
+			compiled.minSeverity, _ = types.ParseRuleSeverity(r.Match.MinSeverity)
+		}
+		if compiled.Cooldown <= 0 {
+			compiled.Cooldown = compiled.Window
+		}
+		if compiled.Severity == "" {
+			compiled.Severity = "warning"
+		}
+		engine.rules = append(engine.rules, compiled)
+	}
+	return engine
+}
+
+func (e *Engine) Name() string {
+	return "alert"
+}
+
+func (e *Engine) Send(log audit.Log) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := log.Time()
+	for _, r := range e.rules {
+		if !r.matches(log) {
+			continue
+		}
+		key, group := r.group(log)
+		w := r.windows[key]
+		if w == nil {
+			w = &window{}
+			r.windows[key] = w
+		}
+		w.hits = append(w.hits, hit{time: now, id: log.Transaction.ID})
+		if len(w.hits) > r.Threshold+1 {
+			w.hits = w.hits[1:]
+		}
+		w.hits = slices.DeleteFunc(w.hits, func(h hit) bool { return now.Sub(h.time) >= r.Window })
+
+		if len(w.hits) <= r.Threshold || (!w.lastFired.IsZero() && now.Sub(w.lastFired) < r.Cooldown) {
+			continue
+		}
+		w.lastFired = now
+		alert := Alert{
+			Rule:        r.Name,
+			Description: r.Description,
+			Severity:    r.Severity,
+			Group:       group,
+			Count:       len(w.hits),
+			Threshold:   r.Threshold,
+			Window:      r.Window.String(),
+			FiredAt:     now,
+		}
+		for _, h := range w.hits[max(len(w.hits)-maxTransactionIDs, 0):] {
+			alert.TransactionIDs = append(alert.TransactionIDs, h.id)
+		}
+		metricAlerts.WithLabelValues(r.Name).Inc()
+		e.logger.Warn("Alert fired", "rule", r.Name, "count", alert.Count, "group", group)
+		e.notifier.enqueue(alert, r.Receivers)
+	}
+	return nil
+}
+
+// Flush forgets the groups without violations in their window, and on shutdown waits for the queued
+// notifications to be sent
+func (e *Engine) Flush(force bool) error {
+	e.mu.Lock()
+	now := e.now()
+	for _, r := range e.rules {
+		for key, w := range r.windows {
+			if len(w.hits) == 0 || (now.Sub(w.hits[len(w.hits)-1].time) >= r.Window && now.Sub(w.lastFired) >= r.Cooldown) {
+				delete(r.windows, key)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	if !force {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return e.notifier.flush(ctx)
+}
+
+func (r *rule) matches(log audit.Log) bool {
+	m := r.Match
+	if m.Blocked != nil {
+		blocked := log.Transaction.Response != nil && log.Transaction.Response.Status >= 400 && !log.ClientCancelled()
+		if blocked != *m.Blocked {
+			return false
+		}
+	}
+	if m.Host != "" && !strings.EqualFold(log.Host(), m.Host) {
+		return false
+	}
+	return slices.ContainsFunc(log.Messages, func(msg audit.Message) bool {
+		// Lower values are more severe, emergency being 0
+		return msg.Data.Severity <= r.minSeverity && (len(m.RuleIDs) == 0 || slices.Contains(m.RuleIDs, msg.Data.ID))
+	})
+}
+
+// group returns the key of the log's group and its field values
+func (r *rule) group(log audit.Log) (string, map[string]string) {
+	if len(r.GroupBy) == 0 {
+		return "", nil
+	}
+	group := make(map[string]string, len(r.GroupBy))
+	values := make([]string, len(r.GroupBy))
+	for i, field := range r.GroupBy {
+		var value string
+		switch field {
+		case GroupClientIP:
+			value = log.Transaction.ClientIP
+		case GroupHost:
+			value = log.Host()
+		case GroupPath, GroupMethod:
+			if request := log.Transaction.Request; request != nil {
+				value = request.Method
+				if field == GroupPath {
+					value = request.URI
+					if u, err := url.Parse(request.URI); err == nil {
+						value = u.Path
+					}
+				}
+			}
+		}
+		group[field] = value
+		values[i] = value
+	}
+	return strings.Join(values, "\x00"), group
+}
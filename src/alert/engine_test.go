@@ -0,0 +1,108 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func violation(clientIP string, uri string, status int, ruleID int, at time.Time) audit.Log {
+	return audit.Log{
+		Transaction: audit.Transaction{
+			ID:            clientIP + "-" + at.Format(time.RFC3339Nano),
+			UnixTimestamp: at.UnixNano(),
+			ClientIP:      clientIP,
+			Request:       &audit.TransactionRequest{Method: "GET", URI: uri},
+			Response:      &audit.TransactionResponse{Status: status},
+		},
+		Messages: []audit.Message{{Data: audit.MessageData{ID: ruleID, Severity: types.RuleSeverityCritical}}},
+	}
+}
+
+// receivedAlerts collects the alerts posted to a webhook receiver
+type receivedAlerts struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func newWebhookReceiver(t *testing.T) (*receivedAlerts, Receiver) {
+	received := &receivedAlerts{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		received.mu.Lock()
+		defer received.mu.Unlock()
+		received.alerts = append(received.alerts, alert)
+	}))
+	t.Cleanup(server.Close)
+	return received, Receiver{Name: "siem", Type: ReceiverWebhook, URL: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+}
+
+func TestEngine(t *testing.T) {
+	blocked := true
+	received, receiver := newWebhookReceiver(t)
+	engine := NewEngine(&Config{
+		Receivers: []Receiver{receiver},
+		Rules: []Rule{
+			{Name: "blocks-per-ip", Match: Match{Blocked: &blocked}, GroupBy: []string{GroupClientIP}, Window: time.Minute, Threshold: 3, Receivers: []string{"siem"}},
+			{Name: "anomaly-per-path", Match: Match{RuleIDs: []int{949110}}, GroupBy: []string{GroupPath}, Window: time.Minute, Threshold: 1, Cooldown: time.Hour, Receivers: []string{"siem"}},
+		},
+	}, Options{Timeout: time.Second})
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, engine.notifier.flush(ctx))
+	}
+	start := time.Unix(1700000000, 0)
+	engine.now = func() time.Time { return start.Add(time.Minute) }
+
+	t.Run("Should fire once a group exceeds the threshold within the window", func(t *testing.T) {
+		for i := range 3 {
+			assert.NoError(t, engine.Send(violation("192.0.2.1", "/login", 403, 942100, start.Add(time.Duration(i)*time.Second))))
+		}
+		// Another client and an old violation falling out of the window do not count
+		assert.NoError(t, engine.Send(violation("192.0.2.2", "/login", 403, 942100, start.Add(3*time.Second))))
+		assert.NoError(t, engine.Send(violation("192.0.2.1", "/login", 403, 942100, start.Add(61*time.Second))))
+		flush()
+		assert.Empty(t, received.alerts)
+
+		for i := range 3 {
+			assert.NoError(t, engine.Send(violation("192.0.2.1", "/login", 403, 942100, start.Add(time.Duration(62+i)*time.Second))))
+		}
+		flush()
+		require.Len(t, received.alerts, 1)
+		alert := received.alerts[0]
+		assert.Equal(t, "blocks-per-ip", alert.Rule)
+		assert.Equal(t, map[string]string{"client_ip": "192.0.2.1"}, alert.Group)
+		assert.Equal(t, 4, alert.Count)
+		assert.Equal(t, "warning", alert.Severity)
+		assert.Len(t, alert.TransactionIDs, 4)
+	})
+
+	t.Run("Should not fire again within the cooldown", func(t *testing.T) {
+		received.alerts = nil
+		assert.NoError(t, engine.Send(violation("192.0.2.9", "/admin", 200, 949110, start)))
+		assert.NoError(t, engine.Send(violation("192.0.2.9", "/admin", 200, 949110, start.Add(time.Second))))
+		assert.NoError(t, engine.Send(violation("192.0.2.9", "/admin", 200, 949110, start.Add(2*time.Second))))
+		assert.NoError(t, engine.Send(violation("192.0.2.9", "/other", 200, 942100, start.Add(3*time.Second))))
+		flush()
+		require.Len(t, received.alerts, 1, "Expected one alert for the rule and path despite further hits")
+		assert.Equal(t, map[string]string{"path": "/admin"}, received.alerts[0].Group)
+	})
+
+	t.Run("Should forget idle groups on flush", func(t *testing.T) {
+		engine.rules[0].windows["stale"] = &window{hits: []hit{{time: start.Add(-time.Hour)}}}
+		assert.NoError(t, engine.Flush(false))
+		assert.NotContains(t, engine.rules[0].windows, "stale")
+	})
+}
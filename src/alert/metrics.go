@@ -0,0 +1,15 @@
+package alert
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricAlerts = metrics.NewCounterVec(
+	"waf_alerts_fired_total",
+	"The total number of alerts fired by rule",
+	[]string{"rule"},
+)
+
+var metricNotifications = metrics.NewCounterVec(
+	"waf_alert_notifications_total",
+	"The total number of alert notifications by receiver and result (sent, failed, queue_full)",
+	[]string{"receiver", "result"},
+)
@@ -0,0 +1,157 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// queueSize bounds the notifications waiting to be sent, e.g. while a distributed attack fires per-IP rules
+	queueSize = 100
+)
+
+type notification struct {
+	alert    Alert
+	receiver Receiver
+}
+
+// notifier sends alerts to their receivers from a background goroutine, so a slow receiver never holds up the
+// audit log processor
+type notifier struct {
+	receivers map[string]Receiver
+	client    *http.Client
+	queue     chan notification
+	flushes   chan chan struct{}
+	logger    *slog.Logger
+}
+
+func newNotifier(receivers []Receiver, transport http.RoundTripper, timeout time.Duration) *notifier {
+	n := &notifier{
+		receivers: map[string]Receiver{},
+		client:    outbound.Client(transport, "alert", timeout),
+		queue:     make(chan notification, queueSize),
+		flushes:   make(chan chan struct{}),
+		logger:    slog.Default(),
+	}
+	for _, receiver := range receivers {
+		n.receivers[receiver.Name] = receiver
+	}
+	go n.run()
+	return n
+}
+
+// enqueue queues the alert for each of the named receivers, dropping it while the queue is full
+func (n *notifier) enqueue(alert Alert, receivers []string) {
+	for _, name := range receivers {
+		select {
+		case n.queue <- notification{alert: alert, receiver: n.receivers[name]}:
+		default:
+			metricNotifications.WithLabelValues(name, "queue_full").Inc()
+		}
+	}
+}
+
+// flush waits until the queued notifications have been sent or ctx is done
+func (n *notifier) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case n.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *notifier) run() {
+	for {
+		select {
+		case next := <-n.queue:
+			n.send(next)
+		case done := <-n.flushes:
+			for len(n.queue) > 0 {
+				n.send(<-n.queue)
+			}
+			close(done)
+		}
+	}
+}
+
+func (n *notifier) send(next notification) {
+	receiver := next.receiver
+	var err error
+	switch receiver.Type {
+	case ReceiverSlack:
+		err = n.post(receiver.URL, nil, map[string]string{"text": ":rotating_light: " + next.alert.Summary()})
+	case ReceiverPagerDuty:
+		endpoint := receiver.URL
+		if endpoint == "" {
+			endpoint = pagerDutyEventsURL
+		}
+		err = n.post(endpoint, nil, pagerDutyEvent(receiver.RoutingKey, next.alert))
+	default:
+		err = n.post(receiver.URL, receiver.Headers, next.alert)
+	}
+	if err != nil {
+		metricNotifications.WithLabelValues(receiver.Name, "failed").Inc()
+		n.logger.Warn("Failed to send alert", "rule", next.alert.Rule, "receiver", receiver.Name, "error", err)
+		return
+	}
+	metricNotifications.WithLabelValues(receiver.Name, "sent").Inc()
+}
+
+// pagerDutyEvent is a trigger event of the Events API v2. Alerts of one rule and group share a dedup key, so
+// repeated alerts update the open incident rather than opening new ones.
+func pagerDutyEvent(routingKey string, alert Alert) map[string]any {
+	return map[string]any{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"dedup_key":    "coraza-waf/" + alert.Rule + "/" + fmt.Sprint(alert.Group),
+		"payload": map[string]any{
+			"summary":        alert.Summary(),
+			"source":         "coraza-waf",
+			"severity":       alert.Severity,
+			"timestamp":      alert.FiredAt.UTC().Format(time.RFC3339),
+			"component":      "waf",
+			"custom_details": alert,
+		},
+	}
+}
+
+func (n *notifier) post(endpoint string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("receiver responded %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
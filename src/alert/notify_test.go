@@ -0,0 +1,58 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		payloads = map[string]map[string]any{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		mu.Lock()
+		defer mu.Unlock()
+		payloads[r.URL.Path] = payload
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	n := newNotifier([]Receiver{
+		{Name: "security", Type: ReceiverSlack, URL: server.URL + "/slack"},
+		{Name: "oncall", Type: ReceiverPagerDuty, URL: server.URL + "/pagerduty", RoutingKey: "R0UTING"},
+	}, nil, time.Second)
+	alert := Alert{
+		Rule:      "blocks-per-ip",
+		Severity:  "critical",
+		Group:     map[string]string{"client_ip": "192.0.2.1"},
+		Count:     101,
+		Threshold: 100,
+		Window:    "1m0s",
+		FiredAt:   time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	n.enqueue(alert, []string{"security", "oncall"})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, n.flush(ctx))
+
+	assert.Equal(t, ":rotating_light: WAF alert blocks-per-ip: 101 violations in 1m0s (threshold 100) for client_ip=192.0.2.1", payloads["/slack"]["text"])
+
+	event := payloads["/pagerduty"]
+	assert.Equal(t, "R0UTING", event["routing_key"])
+	assert.Equal(t, "trigger", event["event_action"])
+	assert.Equal(t, "coraza-waf/blocks-per-ip/map[client_ip:192.0.2.1]", event["dedup_key"])
+	payload := event["payload"].(map[string]any)
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "2025-01-01T12:00:00Z", payload["timestamp"])
+}
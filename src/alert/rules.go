@@ -0,0 +1,151 @@
+// Package alert fires notifications when violations cross thresholds over sliding windows, so basic WAF alerting
+// works without an external metrics stack
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Fields a rule can group violations by
+const (
+	GroupClientIP = "client_ip"
+	GroupHost     = "host"
+	GroupPath     = "path"
+	GroupMethod   = "method"
+)
+
+// Receiver types
+const (
+	ReceiverWebhook   = "webhook"
+	ReceiverSlack     = "slack"
+	ReceiverPagerDuty = "pagerduty"
+)
+
+// Config is the alert rules file
+type Config struct {
+	Receivers []Receiver `yaml:"receivers"`
+	Rules     []Rule     `yaml:"rules"`
+}
+
+// Receiver is where alerts are sent
+type Receiver struct {
+	Name string `yaml:"name"`
+	// Type is webhook, slack or pagerduty
+	Type string `yaml:"type"`
+	// URL is the webhook or Slack incoming webhook URL, or overrides the PagerDuty Events API URL
+	URL string `yaml:"url"`
+	// Headers are added to webhook requests, e.g. an Authorization header
+	Headers map[string]string `yaml:"headers"`
+	// RoutingKey is the integration key of a PagerDuty service
+	RoutingKey string `yaml:"routing_key"`
+}
+
+// Rule fires when more than Threshold matching violations of one group occur within Window
+type Rule struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Match       Match  `yaml:"match"`
+	// GroupBy counts violations separately per combination of these fields, e.g. per client_ip
+	GroupBy   []string      `yaml:"group_by"`
+	Window    time.Duration `yaml:"window"`
+	Threshold int           `yaml:"threshold"`
+	// Severity is the alert's severity: critical, error, warning or info
+	Severity string `yaml:"severity"`
+	// Cooldown is the least time between two alerts of one group, defaulting to the window
+	Cooldown  time.Duration `yaml:"cooldown"`
+	Receivers []string      `yaml:"receivers"`
+}
+
+// Match selects the violations a rule counts. Empty fields match every violation.
+type Match struct {
+	// RuleIDs matches violations of any of these rules, e.g. 949110 for the CRS inbound anomaly score
+	RuleIDs []int `yaml:"rule_ids"`
+	// MinSeverity matches violations of a rule at least this severe, e.g. critical
+	MinSeverity string `yaml:"min_severity"`
+	// Blocked matches violations whose request was rejected (true) or let through (false)
+	Blocked *bool `yaml:"blocked"`
+	// Host matches violations of requests to this host
+	Host string `yaml:"host"`
+}
+
+// Load reads and validates an alert rules file. Environment variables in the file, e.g. ${PAGERDUTY_KEY}, are
+// expanded so secrets can be kept out of it.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+	var config Config
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &config); err != nil {
+		return nil, fmt.Errorf("invalid alert rules: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid alert rules: %w", err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	receivers := map[string]bool{}
+	for _, receiver := range c.Receivers {
+		receivers[receiver.Name] = true
+		if receiver.Name == "" {
+			errs = append(errs, errors.New("receiver without a name"))
+		}
+		switch receiver.Type {
+		case ReceiverWebhook, ReceiverSlack:
+			if _, err := url.ParseRequestURI(receiver.URL); err != nil {
+				errs = append(errs, fmt.Errorf("receiver %s: invalid url: %w", receiver.Name, err))
+			}
+		case ReceiverPagerDuty:
+			if receiver.RoutingKey == "" {
+				errs = append(errs, fmt.Errorf("receiver %s: routing_key is required", receiver.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("receiver %s: unknown type %q, expected webhook, slack or pagerduty", receiver.Name, receiver.Type))
+		}
+	}
+
+	for _, rule := range c.Rules {
+		if rule.Name == "" {
+			errs = append(errs, errors.New("rule without a name"))
+		}
+		if rule.Window <= 0 {
+			errs = append(errs, fmt.Errorf("rule %s: window must be positive", rule.Name))
+		}
+		if rule.Threshold < 0 {
+			errs = append(errs, fmt.Errorf("rule %s: threshold must not be negative", rule.Name))
+		}
+		for _, field := range rule.GroupBy {
+			if !slices.Contains([]string{GroupClientIP, GroupHost, GroupPath, GroupMethod}, field) {
+				errs = append(errs, fmt.Errorf("rule %s: unknown group_by field %q, expected client_ip, host, path or method", rule.Name, field))
+			}
+		}
+		if rule.Match.MinSeverity != "" {
+			if _, err := types.ParseRuleSeverity(rule.Match.MinSeverity); err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+			}
+		}
+		if rule.Severity != "" && !slices.Contains([]string{"critical", "error", "warning", "info"}, rule.Severity) {
+			errs = append(errs, fmt.Errorf("rule %s: unknown severity %q, expected critical, error, warning or info", rule.Name, rule.Severity))
+		}
+		if len(rule.Receivers) == 0 {
+			errs = append(errs, fmt.Errorf("rule %s: at least one receiver is required", rule.Name))
+		}
+		for _, receiver := range rule.Receivers {
+			if !receivers[receiver] {
+				errs = append(errs, fmt.Errorf("rule %s: unknown receiver %q", rule.Name, receiver))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
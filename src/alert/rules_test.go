@@ -0,0 +1,79 @@
+package alert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "alerts.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Should load rules and expand environment variables", func(t *testing.T) {
+		t.Setenv("PAGERDUTY_KEY", "R0UTING")
+		config, err := Load(writeRules(t, `
+receivers:
+  - name: oncall
+    type: pagerduty
+    routing_key: ${PAGERDUTY_KEY}
+  - name: security
+    type: slack
+    url: https://hooks.slack.com/services/T000/B000/XXX
+rules:
+  - name: blocks-per-ip
+    match:
+      blocked: true
+    group_by: [client_ip]
+    window: 1m
+    threshold: 100
+    severity: critical
+    receivers: [oncall, security]
+  - name: anomaly-score-per-path
+    match:
+      rule_ids: [949110]
+    group_by: [path]
+    window: 5m
+    threshold: 10
+    receivers: [security]
+`))
+		require.NoError(t, err)
+		assert.Equal(t, "R0UTING", config.Receivers[0].RoutingKey)
+		assert.Len(t, config.Rules, 2)
+		assert.Equal(t, time.Minute, config.Rules[0].Window)
+		assert.True(t, *config.Rules[0].Match.Blocked)
+		assert.Equal(t, []int{949110}, config.Rules[1].Match.RuleIDs)
+	})
+
+	t.Run("Should report every invalid setting", func(t *testing.T) {
+		_, err := Load(writeRules(t, `
+receivers:
+  - name: email
+    type: smtp
+rules:
+  - name: broken
+    group_by: [user_agent]
+    match:
+      min_severity: fatal
+    receivers: [oncall]
+`))
+		assert.ErrorContains(t, err, `unknown type "smtp"`)
+		assert.ErrorContains(t, err, "window must be positive")
+		assert.ErrorContains(t, err, `unknown group_by field "user_agent"`)
+		assert.ErrorContains(t, err, "fatal")
+		assert.ErrorContains(t, err, `unknown receiver "oncall"`)
+	})
+
+	t.Run("Should fail on a missing file", func(t *testing.T) {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.ErrorContains(t, err, "failed to read alert rules")
+	})
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggregatingSink groups identical (client, rules, path) violations within a window into a single log with a count
+// before passing them to the wrapped sink, so scanners don't flood downstream outputs
+type AggregatingSink struct {
+	next   Sink
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*Log
+}
+
+func NewAggregatingSink(next Sink, window time.Duration) *AggregatingSink {
+	return &AggregatingSink{
+		next:   next,
+		window: window,
+		now:    time.Now,
+		groups: map[string]*Log{},
+	}
+}
+
+func (s *AggregatingSink) Name() string {
+	return s.next.Name()
+}
+
+func (s *AggregatingSink) Send(log Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := timeOf(log, s.now)
+	key := aggregationKey(log)
+	if group, ok := s.groups[key]; ok {
+		group.Aggregation.Count++
+		group.Aggregation.FirstSeen = minTime(group.Aggregation.FirstSeen, at)
+		group.Aggregation.LastSeen = maxTime(group.Aggregation.LastSeen, at)
+		return nil
+	}
+
+	log.Aggregation = &Aggregation{Count: 1, FirstSeen: at, LastSeen: at, received: s.now()}
+	s.groups[key] = &log
+	return nil
+}
+
+// Flush emits the groups whose window has elapsed, or every group when force is true
+func (s *AggregatingSink) Flush(force bool) error {
+	s.mu.Lock()
+	var ready []Log
+	now := s.now()
+	for key, group := range s.groups {
+		if force || now.Sub(group.Aggregation.received) >= s.window {
+			ready = append(ready, *group)
+			delete(s.groups, key)
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, log := range ready {
+		if err := s.next.Send(log); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.next.Flush(force); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func aggregationKey(log Log) string {
+	ruleIDs := make([]string, 0, len(log.Messages))
+	for _, msg := range log.Messages {
+		ruleIDs = append(ruleIDs, strconv.Itoa(msg.Data.ID))
+	}
+	sort.Strings(ruleIDs)
+
+	path := ""
+	if log.Transaction.Request != nil {
+		path = log.Transaction.Request.URI
+		if uri, err := url.Parse(path); err == nil {
+			path = uri.Path
+		}
+	}
+
+	return strings.Join([]string{log.Transaction.ClientIP, strings.Join(ruleIDs, ","), path}, "|")
+}
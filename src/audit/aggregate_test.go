@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	logs []Log
+}
+
+func (s *recordingSink) Name() string           { return "recording" }
+func (s *recordingSink) Send(log Log) error     { s.logs = append(s.logs, log); return nil }
+func (s *recordingSink) Flush(force bool) error { return nil }
+
+func violation(clientIP string, uri string, ruleID int) Log {
+	return Log{
+		Transaction: Transaction{
+			ClientIP: clientIP,
+			Request:  &TransactionRequest{Method: "GET", URI: uri},
+		},
+		Messages: []Message{{Data: MessageData{ID: ruleID}}},
+	}
+}
+
+func TestAggregatingSink(t *testing.T) {
+	recorder := &recordingSink{}
+	sink := NewAggregatingSink(recorder, time.Minute)
+
+	now := time.Unix(1700000000, 0)
+	sink.now = func() time.Time { return now }
+
+	assert.NoError(t, sink.Send(violation("192.0.2.1", "/login?user=a", 942100)))
+	assert.NoError(t, sink.Send(violation("192.0.2.1", "/login?user=b", 942100)))
+	assert.NoError(t, sink.Send(violation("192.0.2.1", "/login", 942100)))
+	assert.NoError(t, sink.Send(violation("192.0.2.2", "/login", 942100)))
+
+	// Nothing should be emitted before the window elapses
+	assert.NoError(t, sink.Flush(false))
+	assert.Empty(t, recorder.logs)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, sink.Flush(false))
+	assert.Len(t, recorder.logs, 2, "Expected one record per client")
+
+	counts := map[string]int{}
+	for _, log := range recorder.logs {
+		counts[log.Transaction.ClientIP] = log.Aggregation.Count
+	}
+	assert.Equal(t, 3, counts["192.0.2.1"])
+	assert.Equal(t, 1, counts["192.0.2.2"])
+
+	// A forced flush should emit groups regardless of the window
+	assert.NoError(t, sink.Send(violation("192.0.2.3", "/admin", 930100)))
+	assert.NoError(t, sink.Flush(true))
+	assert.Len(t, recorder.logs, 3)
+}
+
+func TestAggregatingSinkUsesEventTime(t *testing.T) {
+	recorder := &recordingSink{}
+	sink := NewAggregatingSink(recorder, time.Minute)
+
+	now := time.Unix(1700000000, 0)
+	sink.now = func() time.Time { return now }
+
+	// Logs of a backlog, processed out of order long after they were written
+	for _, age := range []time.Duration{2 * time.Hour, 3 * time.Hour, time.Hour} {
+		log := violation("192.0.2.1", "/login", 942100)
+		log.Transaction.Time = now.Add(-age)
+		assert.NoError(t, sink.Send(log))
+	}
+
+	// The window runs from when the group was started, not from the first event
+	assert.NoError(t, sink.Flush(false))
+	assert.Empty(t, recorder.logs)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, sink.Flush(false))
+	assert.Len(t, recorder.logs, 1)
+	aggregation := recorder.logs[0].Aggregation
+	assert.Equal(t, 3, aggregation.Count)
+	assert.Equal(t, time.Unix(1700000000, 0).Add(-3*time.Hour), aggregation.FirstSeen)
+	assert.Equal(t, time.Unix(1700000000, 0).Add(-time.Hour), aggregation.LastSeen)
+}
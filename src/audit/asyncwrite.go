@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+)
+
+// asyncWriterName is the SecAuditLogType of the audit log file written in the background
+const asyncWriterName = "coraza_traefik_async"
+
+// asyncLog queues formatted audit log lines in a bounded ring, which a flusher goroutine appends to the audit log
+// file in batches. When the ring is full the oldest line is dropped, so a slow disk never blocks a request.
+type asyncLog struct {
+	mu    sync.Mutex
+	ring  [][]byte
+	start int
+	count int
+	// written receives a value after a line is queued, waking the flusher
+	written chan struct{}
+
+	// writeMu is held from draining the ring until the batch is written, so a rotation that flushes first never
+	// copies the file with a batch missing
+	writeMu sync.Mutex
+	file    *os.File
+	batch   []byte
+	once    sync.Once
+}
+
+// asyncLogs are the queues of the audit log files written in the background, by path. Coraza creates its writers by
+// type, so this is how a writer finds the queue of its processor.
+var asyncLogs sync.Map
+
+func openAsyncLog(target string, lines int) *asyncLog {
+	log, _ := asyncLogs.LoadOrStore(target, &asyncLog{ring: make([][]byte, max(lines, 1)), written: make(chan struct{}, 1)})
+	return log.(*asyncLog)
+}
+
+// push queues a line, dropping the oldest line when the queue is full
+func (a *asyncLog) push(line []byte) {
+	a.mu.Lock()
+	if a.count == len(a.ring) {
+		a.ring[a.start] = nil
+		a.start = (a.start + 1) % len(a.ring)
+		a.count--
+		metricAsyncWriteDropped.WithLabelValues("queue_full").Inc()
+	}
+	a.ring[(a.start+a.count)%len(a.ring)] = line
+	a.count++
+	metricAsyncWriteQueued.Set(float64(a.count))
+	a.mu.Unlock()
+
+	select {
+	case a.written <- struct{}{}:
+	default:
+	}
+}
+
+// drain appends the queued lines to buf and empties the queue
+func (a *asyncLog) drain(buf []byte) ([]byte, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lines := a.count
+	for i := 0; i < a.count; i++ {
+		j := (a.start + i) % len(a.ring)
+		buf = append(buf, a.ring[j]...)
+		a.ring[j] = nil
+	}
+	a.start, a.count = 0, 0
+	metricAsyncWriteQueued.Set(0)
+	return buf, lines
+}
+
+// flush writes the queued lines to the audit log file
+func (a *asyncLog) flush() error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	batch, lines := a.drain(a.batch[:0])
+	// Keep the buffer for the next batch, unless a burst made it huge
+	if cap(batch) <= 1<<20 {
+		a.batch = batch
+	}
+	if lines == 0 {
+		return nil
+	}
+	if _, err := a.file.Write(batch); err != nil {
+		metricAsyncWriteDropped.WithLabelValues("write_failed").Add(float64(lines))
+		return err
+	}
+	return nil
+}
+
+// open opens the audit log file and starts the flusher, once
+func (a *asyncLog) open(config plugintypes.AuditLogConfig) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	if a.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(config.Target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, config.FileMode)
+	if err != nil {
+		return err
+	}
+	a.file = file
+	a.once.Do(func() { go a.run() })
+	return nil
+}
+
+func (a *asyncLog) run() {
+	for range a.written {
+		if err := a.flush(); err != nil {
+			slog.Error("Failed to write audit log", "error", err)
+		}
+	}
+}
+
+func init() {
+	plugins.RegisterAuditLogWriter(asyncWriterName, func() plugintypes.AuditLogWriter { return &asyncWriter{} })
+}
+
+// asyncWriter is the Coraza audit log writer queueing formatted logs for the flusher of an asyncLog
+type asyncWriter struct {
+	log       *asyncLog
+	formatter plugintypes.AuditLogFormatter
+}
+
+func (w *asyncWriter) Init(config plugintypes.AuditLogConfig) error {
+	if config.Formatter == nil {
+		return errors.New("asynchronous audit log requires a format")
+	}
+	log, ok := asyncLogs.Load(config.Target)
+	if !ok {
+		return errors.New("asynchronous audit log has no processor")
+	}
+	w.log = log.(*asyncLog)
+	w.formatter = config.Formatter
+	return w.log.open(config)
+}
+
+func (w *asyncWriter) Write(log plugintypes.AuditLog) error {
+	data, err := w.formatter.Format(log)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	w.log.push(append(data, '\n'))
+	return nil
+}
+
+func (w *asyncWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncLog(t *testing.T) {
+	log := openAsyncLog(filepath.Join(t.TempDir(), "audit.log"), 2)
+	log.push([]byte("1\n"))
+	log.push([]byte("2\n"))
+	log.push([]byte("3\n"))
+
+	batch, lines := log.drain(nil)
+	assert.Equal(t, "2\n3\n", string(batch), "Expected the oldest line to be dropped")
+	assert.Equal(t, 2, lines)
+
+	log.push([]byte("4\n"))
+	batch, _ = log.drain(nil)
+	assert.Equal(t, "4\n", string(batch))
+}
+
+func TestAsyncStorage(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	sink := &recordingSink{}
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath:     logFile,
+		Storage:          StorageFile,
+		AsyncBufferLines: 100,
+		Sinks:            []Sink{sink},
+	})
+	assert.Contains(t, processor.source.Directives(), asyncWriterName)
+
+	waf, err := coraza.NewWAF(processor.SetAuditLogDirectives(coraza.NewWAFConfig().WithDirectives(`
+		SecRuleEngine On
+		SecRule ARGS:attack "@streq 1" "id:1,phase:1,deny,status:403,log"`)))
+	require.NoError(t, err)
+
+	tx := waf.NewTransaction()
+	tx.ProcessURI("/?attack=1", "GET", "HTTP/1.1")
+	tx.ProcessRequestHeaders()
+	tx.ProcessLogging()
+	tx.Close()
+
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(logFile)
+		return len(data) > 0
+	}, 2*time.Second, 10*time.Millisecond, "Expected the flusher to write the queued line")
+
+	processor.processPending()
+	require.Len(t, sink.logs, 1)
+	assert.Equal(t, 1, sink.logs[0].Messages[0].Data.ID)
+
+	// Lines still queued at shutdown are written for the next run
+	processor.async.push([]byte("{}\n"))
+	require.NoError(t, processor.Stop(context.Background()))
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(data))
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"io"
+	"runtime"
+	"time"
+)
+
+// DefaultChunkLines is the number of audit log lines processed between yield points
+const DefaultChunkLines = 1000
+
+// chunker splits processing into chunks of lines. Between chunks it yields the processor, pauses, and slows down
+// to the maximum read rate, so a huge backlog does not starve the WAF handler of CPU and IO on a small pod.
+type chunker struct {
+	lines       int
+	pause       time.Duration
+	maxReadRate int64
+
+	reader *countingReader
+	size   int64
+	start  time.Time
+	count  int
+}
+
+// newChunker returns a chunker for r, of which size bytes are expected, and the reader to process instead of r
+func (p *LogProcessor) newChunker(r io.Reader, size int64) (*chunker, io.Reader) {
+	c := &chunker{
+		lines:       p.chunkLines,
+		pause:       p.chunkPause,
+		maxReadRate: p.maxReadRate,
+		reader:      &countingReader{reader: r},
+		size:        size,
+		start:       time.Now(),
+	}
+	if c.lines <= 0 {
+		c.lines = DefaultChunkLines
+	}
+	c.report()
+	return c, c.reader
+}
+
+// line is called after each line, yielding at the end of a chunk
+func (c *chunker) line() {
+	c.count++
+	if c.count%c.lines != 0 {
+		return
+	}
+	metricProcessingChunks.Inc()
+	c.report()
+
+	runtime.Gosched()
+	wait := c.pause
+	if c.maxReadRate > 0 {
+		// The time reading this far should have taken at the maximum rate
+		due := time.Duration(float64(c.reader.read) / float64(c.maxReadRate) * float64(time.Second))
+		wait = max(wait, due-time.Since(c.start))
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// done reports the processing as complete
+func (c *chunker) done() {
+	metricProcessingProgress.Set(1)
+	metricProcessingRemaining.Set(0)
+}
+
+func (c *chunker) report() {
+	read := c.reader.read
+	if c.size <= 0 {
+		return
+	}
+	metricProcessingProgress.Set(min(float64(read)/float64(c.size), 1))
+	metricProcessingRemaining.Set(float64(max(c.size-read, 0)))
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedProcessing(t *testing.T) {
+	data, err := os.ReadFile("testdata/audit.log")
+	require.NoError(t, err)
+
+	t.Run("yields between chunks and reports progress", func(t *testing.T) {
+		processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: filepath.Join(t.TempDir(), "audit.log"), ChunkLines: 2})
+		var progress []float64
+		processor.logHandler = func(l Log) error {
+			progress = append(progress, testutil.ToFloat64(metricProcessingProgress))
+			return nil
+		}
+		chunksBefore := testutil.ToFloat64(metricProcessingChunks)
+
+		require.NoError(t, processor.processLogs(bytes.NewReader(data), int64(len(data))))
+		assert.Len(t, progress, 4)
+		assert.Equal(t, float64(2), testutil.ToFloat64(metricProcessingChunks)-chunksBefore)
+		assert.Equal(t, float64(1), testutil.ToFloat64(metricProcessingProgress))
+		assert.Zero(t, testutil.ToFloat64(metricProcessingRemaining))
+	})
+
+	t.Run("limits the read rate", func(t *testing.T) {
+		processor := NewLogProcessor(AuditLogProcessorOptions{
+			AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+			ChunkLines:   1,
+			// The whole file should take a quarter of a second to read
+			MaxReadRate: int64(len(data)) * 4,
+		})
+		processor.logHandler = func(l Log) error { return nil }
+
+		start := time.Now()
+		require.NoError(t, processor.processLogs(bytes.NewReader(data), int64(len(data))))
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	})
+}
@@ -0,0 +1,267 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/aws"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// PutLogEvents limits: a request carries at most 10000 events and 1 MiB, counting 26 bytes of overhead per event
+const (
+	cloudWatchMaxEvents     = 10000
+	cloudWatchMaxBytes      = 1024 * 1024
+	cloudWatchEventOverhead = 26
+)
+
+type CloudWatchOptions struct {
+	// LogGroup receives the events. It must exist; LogStream is created in it when missing.
+	LogGroup  string
+	LogStream string
+	// MetricsNamespace publishes violation counts as CloudWatch metrics in the embedded metric format under
+	// this namespace. Empty disables metrics.
+	MetricsNamespace string
+	Region           string
+	// Endpoint overrides the CloudWatch Logs endpoint of the region, e.g. for a VPC endpoint or LocalStack
+	Endpoint string
+	// BatchSize sends the buffered events as soon as this many are waiting, rather than at the next flush
+	BatchSize int
+	Timeout   time.Duration
+	Retry     RetryOptions
+	// Transport carries the requests. Nil uses the default transport.
+	Transport http.RoundTripper
+	// Credentials sign the requests. Nil resolves them from the environment, IRSA or the container endpoint.
+	Credentials *aws.CredentialsProvider
+}
+
+// CloudWatchSink sends violation events to CloudWatch Logs, and optionally violation counts as metrics in the
+// embedded metric format (EMF), which CloudWatch extracts from the same log stream
+type CloudWatchSink struct {
+	options     CloudWatchOptions
+	client      *http.Client
+	credentials *aws.CredentialsProvider
+
+	mu      sync.Mutex
+	pending []Log
+	// streamCreated is set once the log stream is known to exist
+	streamCreated atomic.Bool
+}
+
+func NewCloudWatchSink(options CloudWatchOptions) *CloudWatchSink {
+	if options.Endpoint == "" {
+		options.Endpoint = "https://logs." + options.Region + ".amazonaws.com"
+	}
+	client := outbound.Client(options.Transport, "cloudwatch", options.Timeout)
+	credentials := options.Credentials
+	if credentials == nil {
+		credentials = aws.NewCredentialsProvider(client)
+	}
+	return &CloudWatchSink{options: options, client: client, credentials: credentials}
+}
+
+func (s *CloudWatchSink) Name() string {
+	return "cloudwatch"
+}
+
+func (s *CloudWatchSink) Send(log Log) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, log)
+	if len(s.pending) < s.options.BatchSize {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	return s.push(batch)
+}
+
+func (s *CloudWatchSink) Flush(force bool) error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.push(batch)
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+func (s *CloudWatchSink) push(batch []Log) error {
+	slices.SortStableFunc(batch, func(a, b Log) int { return a.Time().Compare(b.Time()) })
+	events := make([]cloudWatchEvent, 0, len(batch))
+	for _, log := range batch {
+		event := NewEvent(log)
+		message, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		events = append(events, cloudWatchEvent{Timestamp: event.Time.UnixMilli(), Message: string(message)})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	// The metrics are stamped with the newest event, keeping the request in chronological order
+	if s.options.MetricsNamespace != "" {
+		events = append(events, s.metricEvents(batch, events[len(events)-1].Timestamp)...)
+	}
+
+	// Split the events into requests within the PutLogEvents limits
+	var chunk []cloudWatchEvent
+	size := 0
+	var errs []error
+	for _, event := range events {
+		eventSize := len(event.Message) + cloudWatchEventOverhead
+		if len(chunk) == cloudWatchMaxEvents || (len(chunk) > 0 && size+eventSize > cloudWatchMaxBytes) {
+			errs = append(errs, s.putLogEvents(chunk))
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, event)
+		size += eventSize
+	}
+	if len(chunk) > 0 {
+		errs = append(errs, s.putLogEvents(chunk))
+	}
+	return errors.Join(errs...)
+}
+
+// metricEvents counts the batch's violations and blocked requests by severity and by rule family as EMF documents
+func (s *CloudWatchSink) metricEvents(batch []Log, timestamp int64) []cloudWatchEvent {
+	type counts struct{ violations, blocked int }
+	bySeverity := map[string]*counts{}
+	byFamily := map[string]*counts{}
+	for _, log := range batch {
+		blocked := log.Transaction.Response != nil && log.Transaction.Response.Status >= 400
+		family := "unknown"
+		if msg := log.mostSevere(); msg != nil {
+			family = RuleFamily(msg.Data.ID)
+		}
+		for key, groups := range map[string]map[string]*counts{log.Severity(): bySeverity, family: byFamily} {
+			if groups[key] == nil {
+				groups[key] = &counts{}
+			}
+			groups[key].violations++
+			if blocked {
+				groups[key].blocked++
+			}
+		}
+	}
+
+	var events []cloudWatchEvent
+	for dimension, groups := range map[string]map[string]*counts{"Severity": bySeverity, "RuleFamily": byFamily} {
+		for value, c := range groups {
+			document, err := json.Marshal(map[string]any{
+				"_aws": map[string]any{
+					"Timestamp": timestamp,
+					"CloudWatchMetrics": []map[string]any{{
+						"Namespace":  s.options.MetricsNamespace,
+						"Dimensions": [][]string{{dimension}},
+						"Metrics": []map[string]string{
+							{"Name": "Violations", "Unit": "Count"},
+							{"Name": "Blocked", "Unit": "Count"},
+						},
+					}},
+				},
+				dimension:    value,
+				"Violations": c.violations,
+				"Blocked":    c.blocked,
+			})
+			if err != nil {
+				continue
+			}
+			events = append(events, cloudWatchEvent{Timestamp: timestamp, Message: string(document)})
+		}
+	}
+	return events
+}
+
+func (s *CloudWatchSink) putLogEvents(events []cloudWatchEvent) error {
+	return deliver(s.Name(), len(events), s.options.Retry, func() error {
+		err := s.call("PutLogEvents", map[string]any{
+			"logGroupName":  s.options.LogGroup,
+			"logStreamName": s.options.LogStream,
+			"logEvents":     events,
+		})
+		if isCloudWatchError(err, "ResourceNotFoundException") && !s.streamCreated.Load() {
+			if err := s.call("CreateLogStream", map[string]any{"logGroupName": s.options.LogGroup, "logStreamName": s.options.LogStream}); err != nil && !isCloudWatchError(err, "ResourceAlreadyExistsException") {
+				return err
+			}
+			s.streamCreated.Store(true)
+			err = s.call("PutLogEvents", map[string]any{
+				"logGroupName":  s.options.LogGroup,
+				"logStreamName": s.options.LogStream,
+				"logEvents":     events,
+			})
+		}
+		return err
+	})
+}
+
+// cloudWatchError is an error response of the CloudWatch Logs API
+type cloudWatchError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	status  int
+}
+
+func (e *cloudWatchError) Error() string {
+	return fmt.Sprintf("cloudwatch responded %d: %s: %s", e.status, e.Type, e.Message)
+}
+
+func isCloudWatchError(err error, errorType string) bool {
+	var cwErr *cloudWatchError
+	// The type may be qualified, e.g. com.amazonaws.logs#ResourceNotFoundException
+	return errors.As(err, &cwErr) && (cwErr.Type == errorType || strings.HasSuffix(cwErr.Type, "#"+errorType))
+}
+
+func (s *CloudWatchSink) call(action string, input any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return permanentError{err}
+	}
+	credentials, err := s.credentials.Retrieve()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.options.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	aws.Sign(req, body, credentials, s.options.Region, "logs", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	cwErr := &cloudWatchError{status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, cwErr) != nil {
+		cwErr.Message = string(data)
+	}
+	// Throttling and server errors are worth retrying, other client errors are not
+	if resp.StatusCode >= 500 || strings.Contains(cwErr.Type, "Throttling") || strings.Contains(cwErr.Type, "ServiceUnavailable") {
+		return cwErr
+	}
+	return permanentError{cwErr}
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type cloudWatchRequest struct {
+	Action        string
+	LogGroupName  string            `json:"logGroupName"`
+	LogStreamName string            `json:"logStreamName"`
+	LogEvents     []cloudWatchEvent `json:"logEvents"`
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var (
+		mu       sync.Mutex
+		requests []cloudWatchRequest
+		failures []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-amz-json-1.1", r.Header.Get("Content-Type"))
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKID/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/logs/aws4_request")
+		var request cloudWatchRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		request.Action = strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request)
+		if len(failures) > 0 {
+			errorType := failures[0]
+			failures = failures[1:]
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"` + errorType + `","message":"failed"}`))
+		}
+	}))
+	defer server.Close()
+
+	sink := NewCloudWatchSink(CloudWatchOptions{
+		LogGroup:         "/waf/violations",
+		LogStream:        "waf-1",
+		MetricsNamespace: "CorazaWAF",
+		Region:           "eu-west-1",
+		Endpoint:         server.URL,
+		BatchSize:        100,
+		Timeout:          time.Second,
+		Retry:            RetryOptions{Attempts: 2, Backoff: time.Millisecond},
+	})
+
+	t.Run("Should create a missing log stream and put the events with their metrics", func(t *testing.T) {
+		failures = []string{"com.amazonaws.logs#ResourceNotFoundException"}
+		blocked := timedViolation("b", int64(2*time.Second), "example.com", 942100, types.RuleSeverityCritical)
+		blocked.Transaction.Response = &TransactionResponse{Status: 403}
+		assert.NoError(t, sink.Send(blocked))
+		assert.NoError(t, sink.Send(timedViolation("a", int64(time.Second), "example.com", 941100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Flush(false))
+
+		assert.Len(t, requests, 3)
+		assert.Equal(t, "PutLogEvents", requests[0].Action)
+		assert.Equal(t, "CreateLogStream", requests[1].Action)
+		assert.Equal(t, "/waf/violations", requests[1].LogGroupName)
+		assert.Equal(t, "waf-1", requests[1].LogStreamName)
+
+		put := requests[2]
+		assert.Equal(t, "PutLogEvents", put.Action)
+		// Two events, then the metrics by severity and by each of the two rule families
+		assert.Len(t, put.LogEvents, 5)
+		assert.Equal(t, int64(1000), put.LogEvents[0].Timestamp, "Expected events in time order")
+		assert.Contains(t, put.LogEvents[0].Message, `"id":"a"`)
+		assert.NotContains(t, put.LogEvents[0].Message, "secret")
+		assert.Equal(t, int64(2000), put.LogEvents[1].Timestamp)
+
+		var severity map[string]any
+		for _, event := range put.LogEvents[2:] {
+			assert.Equal(t, int64(2000), event.Timestamp)
+			if strings.Contains(event.Message, `"Severity"`) {
+				assert.NoError(t, json.Unmarshal([]byte(event.Message), &severity))
+			}
+		}
+		assert.Equal(t, "critical", severity["Severity"])
+		assert.Equal(t, float64(2), severity["Violations"])
+		assert.Equal(t, float64(1), severity["Blocked"])
+		assert.Contains(t, severity["_aws"], "CloudWatchMetrics")
+	})
+
+	t.Run("Should retry throttling but drop rejected events", func(t *testing.T) {
+		requests = nil
+		failures = []string{"ThrottlingException"}
+		assert.NoError(t, sink.Send(timedViolation("c", int64(3*time.Second), "example.com", 942100, types.RuleSeverityWarning)))
+		assert.NoError(t, sink.Flush(false))
+		assert.Len(t, requests, 2)
+
+		requests = nil
+		failures = []string{"InvalidParameterException"}
+		assert.NoError(t, sink.Send(timedViolation("d", int64(4*time.Second), "example.com", 942100, types.RuleSeverityWarning)))
+		assert.ErrorContains(t, sink.Flush(true), "InvalidParameterException")
+		assert.Len(t, requests, 1)
+	})
+}
@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"time"
+)
+
+// gzipMagic starts every compressed backup
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DiskGuardOptions configures the guard against the audit log filling its disk, e.g. under a runaway scanner
+type DiskGuardOptions struct {
+	// MaxBytes is the disk usage of the audit log and its backups above which the directory is under pressure. Zero
+	// disables the limit.
+	MaxBytes int64
+	// MinFreeRatio is the share of the audit log's filesystem that must stay free, from 0 to 1. Zero disables the
+	// check.
+	MinFreeRatio float64
+	// Interval is how often disk usage is checked
+	Interval time.Duration
+	// OnPressure is called when the directory comes under pressure, and again when it recovers
+	OnPressure func(underPressure bool)
+}
+
+func (o DiskGuardOptions) enabled() bool {
+	return o.MaxBytes > 0 || o.MinFreeRatio > 0
+}
+
+// StartDiskGuardJob begins the disk usage loop. While the audit log directory is under pressure, the audit log is
+// rotated at every check rather than at the next processing run, and backups are compressed.
+func (p *LogProcessor) StartDiskGuardJob() {
+	if !p.diskGuard.enabled() || !p.keepsBackups() {
+		return
+	}
+	p.logger.Info("Starting audit log disk guard job", "interval", p.diskGuard.Interval.String(), "max_bytes", p.diskGuard.MaxBytes, "min_free_ratio", p.diskGuard.MinFreeRatio)
+
+	ticker := p.clock.NewTicker(p.diskGuard.Interval)
+	defer ticker.Stop()
+
+	p.diskGuardDone = make(chan struct{})
+	defer close(p.diskGuardDone)
+
+	for {
+		select {
+		case <-p.stopSignal:
+			return
+		case <-ticker.C():
+			p.checkDiskUsage()
+		}
+	}
+}
+
+// UnderDiskPressure reports whether the audit log directory was over its limits at the last check
+func (p *LogProcessor) UnderDiskPressure() bool {
+	return p.pressure.Load()
+}
+
+// checkDiskUsage updates the pressure state and relieves the pressure
+func (p *LogProcessor) checkDiskUsage() {
+	reason, err := p.diskPressure()
+	if err != nil {
+		p.logger.Error("Failed to check audit log disk usage", "error", err)
+		return
+	}
+	if reason == "" {
+		if p.pressure.Swap(false) {
+			metricDiskPressure.Set(0)
+			p.logger.Info("Audit log directory is back within its disk limits")
+			if p.diskGuard.OnPressure != nil {
+				p.diskGuard.OnPressure(false)
+			}
+		}
+		return
+	}
+
+	if !p.pressure.Swap(true) {
+		metricDiskPressure.Set(1)
+		p.logger.Error("Audit log directory is running out of disk space, rotating and compressing audit logs", "reason", reason, "dir", p.auditLogDir)
+		if p.diskGuard.OnPressure != nil {
+			p.diskGuard.OnPressure(true)
+		}
+	}
+	// The processing job rotates the audit log, and seals the backup compressed
+	select {
+	case p.rotateNow <- struct{}{}:
+		metricDiskGuardActions.WithLabelValues("rotate").Inc()
+	default:
+	}
+	p.compressBackups()
+}
+
+// diskPressure returns why the audit log directory is under pressure, or "" when it is not
+func (p *LogProcessor) diskPressure() (string, error) {
+	if p.diskGuard.MaxBytes > 0 {
+		usage, err := p.DiskUsage()
+		if err != nil {
+			return "", err
+		}
+		if usage > p.diskGuard.MaxBytes {
+			return fmt.Sprintf("audit logs use %d bytes, over the limit of %d", usage, p.diskGuard.MaxBytes), nil
+		}
+	}
+	if p.diskGuard.MinFreeRatio > 0 {
+		free, total, err := p.diskSpace(p.auditLogDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to read free disk space: %w", err)
+		}
+		if total > 0 && float64(free)/float64(total) < p.diskGuard.MinFreeRatio {
+			return fmt.Sprintf("%d of %d bytes free, under the minimum ratio of %g", free, total, p.diskGuard.MinFreeRatio), nil
+		}
+	}
+	return "", nil
+}
+
+// compressBackups compresses the backups already on disk. Signed and encrypted backups are left alone: compression
+// would break their signatures, and ciphertext does not compress.
+func (p *LogProcessor) compressBackups() {
+	if p.signer != nil || p.encrypter != nil {
+		return
+	}
+	// Rotation copies the audit log into a new backup under the lock
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	backups, err := p.backupFiles()
+	if err != nil {
+		p.logger.Error("Failed to find audit log backups to compress", "error", err)
+		return
+	}
+	for _, backup := range backups {
+		if err := compressBackup(p.fs, backup); err != nil {
+			p.logger.Error("Failed to compress audit log backup", "error", err, "file", backup)
+		}
+	}
+}
+
+// compressBackup replaces the backup at backupPath with its gzip form, keeping its name so it still expires and
+// verifies as a backup. Backups already compressed or encrypted are skipped.
+func compressBackup(fsys FS, backupPath string) error {
+	data, err := readFile(fsys, backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, encryptedMagic) {
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// Write next to the backup and rename over it, so a crash never leaves a truncated backup behind
+	tmpPath := backupPath + ".tmp"
+	if err := fsys.WriteFile(tmpPath, compressed.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write compressed backup: %w", err)
+	}
+	if err := fsys.Rename(tmpPath, backupPath); err != nil {
+		fsys.Remove(tmpPath)
+		return fmt.Errorf("failed to replace backup: %w", err)
+	}
+	metricDiskGuardActions.WithLabelValues("compress").Inc()
+	return nil
+}
+
+// decompress returns the audit log of a backup, which is compressed when it was rotated under disk pressure
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed backup: %w", err)
+	}
+	var plaintext bytes.Buffer
+	if _, err := plaintext.ReadFrom(zr); err != nil {
+		return nil, fmt.Errorf("invalid compressed backup: %w", err)
+	}
+	return plaintext.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskGuard(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	fsys := newMemFS()
+	clock := newFakeClock(time.Unix(1700000000, 0))
+
+	data, err := os.ReadFile("testdata/audit.log")
+	require.NoError(t, err)
+	oldBackup := logFile + ".1699999000"
+	require.NoError(t, fsys.WriteFile(oldBackup, data, 0644))
+	require.NoError(t, fsys.WriteFile(logFile, data, 0644))
+
+	var mu sync.Mutex
+	var pressure []bool
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath:          logFile,
+		Storage:               StorageFile,
+		ProcessingJobInterval: time.Hour,
+		DiskGuard: DiskGuardOptions{
+			MaxBytes: int64(len(data)),
+			Interval: time.Second,
+			OnPressure: func(underPressure bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				pressure = append(pressure, underPressure)
+			},
+		},
+	}, WithFS(fsys), WithClock(clock))
+	processed := 0
+	processor.logHandler = func(Log) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+		return nil
+	}
+
+	go processor.StartProcessingJob()
+	go processor.StartDiskGuardJob()
+	clock.waitForTickers(t, 2)
+	clock.Advance(time.Second)
+
+	rotatedBackup := logFile + ".1700000001"
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return processed == 4 && isCompressed(fsys, rotatedBackup)
+	}, 2*time.Second, time.Millisecond, "Expected the log to be rotated early, processed and compressed")
+	assert.True(t, processor.UnderDiskPressure())
+	assert.True(t, isCompressed(fsys, oldBackup), "Expected backups already on disk to be compressed")
+
+	// The compressed backups are under the limit
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return !processor.UnderDiskPressure() }, 2*time.Second, time.Millisecond)
+	require.NoError(t, processor.Stop(context.Background()))
+
+	assert.Equal(t, []bool{true, false}, pressure)
+
+	// Compressed backups can still be reprocessed
+	processed = 0
+	require.NoError(t, processor.ProcessLogFile(oldBackup))
+	assert.Equal(t, 4, processed)
+}
+
+func isCompressed(fsys FS, name string) bool {
+	data, err := readFile(fsys, name)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+func TestDiskPressure(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath: logFile,
+		Storage:      StorageFile,
+		DiskGuard:    DiskGuardOptions{MinFreeRatio: 0.1},
+	}, WithFS(newMemFS()))
+
+	processor.diskSpace = func(dir string) (uint64, uint64, error) { return 50, 100, nil }
+	reason, err := processor.diskPressure()
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+
+	processor.diskSpace = func(dir string) (uint64, uint64, error) { return 5, 100, nil }
+	reason, err = processor.diskPressure()
+	require.NoError(t, err)
+	assert.Equal(t, "5 of 100 bytes free, under the minimum ratio of 0.1", reason)
+
+	processor.diskSpace = func(dir string) (uint64, uint64, error) { return 0, 0, errors.New("not supported") }
+	_, err = processor.diskPressure()
+	assert.ErrorContains(t, err, "failed to read free disk space")
+}
+
+func TestCompressBackup(t *testing.T) {
+	t.Run("Should compress before encrypting and signing", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "audit.log")
+		encryptionKey := bytes.Repeat([]byte{7}, 16)
+		signingKey := []byte("secret")
+		processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, EncryptionKey: encryptionKey, SigningKey: signingKey})
+		processor.pressure.Store(true)
+
+		plaintext := strings.Repeat(`{"transaction":{"id":"1"}}`+"\n", 100)
+		require.NoError(t, os.WriteFile(logFile, []byte(plaintext), 0o644))
+		backup, err := processor.rotateLogs()
+		require.NoError(t, err)
+		processor.sealBackup(backup)
+
+		results, err := VerifyBackups(logFile, signingKey)
+		require.NoError(t, err)
+		assert.Equal(t, []BackupVerification{{File: filepath.Base(backup), OK: true}}, results)
+
+		decrypted, err := DecryptBackup(backup, encryptionKey)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, string(decrypted), "Expected the decrypted backup to be decompressed")
+	})
+
+	t.Run("Should skip compressed and encrypted backups", func(t *testing.T) {
+		fsys := newMemFS()
+		sealed := append(bytes.Clone(encryptedMagic), "ciphertext"...)
+		require.NoError(t, fsys.WriteFile("audit.log.1", sealed, 0644))
+		require.NoError(t, compressBackup(fsys, "audit.log.1"))
+		data, err := readFile(fsys, "audit.log.1")
+		require.NoError(t, err)
+		assert.Equal(t, sealed, data)
+
+		require.NoError(t, fsys.WriteFile("audit.log.2", []byte("{}\n"), 0644))
+		require.NoError(t, compressBackup(fsys, "audit.log.2"))
+		compressed, err := readFile(fsys, "audit.log.2")
+		require.NoError(t, err)
+		require.NoError(t, compressBackup(fsys, "audit.log.2"))
+		data, err = readFile(fsys, "audit.log.2")
+		require.NoError(t, err)
+		assert.Equal(t, compressed, data, "Expected a compressed backup not to be compressed twice")
+
+		decompressed, err := decompress(data)
+		require.NoError(t, err)
+		assert.Equal(t, "{}\n", string(decompressed))
+	})
+}
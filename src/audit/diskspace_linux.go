@@ -0,0 +1,12 @@
+package audit
+
+import "syscall"
+
+// diskSpace returns the bytes available to unprivileged users and the size of the filesystem holding dir
+func diskSpace(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package audit
+
+import "errors"
+
+// diskSpace is only implemented on Linux, elsewhere the disk guard only enforces DiskGuardOptions.MaxBytes
+func diskSpace(string) (uint64, uint64, error) {
+	return 0, 0, errors.New("free disk space is only available on Linux")
+}
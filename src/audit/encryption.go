@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// encryptedMagic starts every encrypted backup, so encrypted and plaintext backups can be told apart
+var encryptedMagic = []byte("CTWAFENC1\n")
+
+// backupEncrypter seals rotated backups with AES-GCM once they have been processed
+type backupEncrypter struct {
+	fs   FS
+	aead cipher.AEAD
+}
+
+func newBackupEncrypter(fsys FS, key []byte) (*backupEncrypter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{fs: fsys, aead: aead}, nil
+}
+
+// encrypt replaces the plaintext backup at backupPath with its encrypted form
+func (e *backupEncrypter) encrypt(backupPath string) error {
+	plaintext, err := readFile(e.fs, backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if bytes.HasPrefix(plaintext, encryptedMagic) {
+		return nil
+	}
+
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := append(append(bytes.Clone(encryptedMagic), nonce...), e.aead.Seal(nil, nonce, plaintext, []byte(filepath.Base(backupPath)))...)
+
+	// Write next to the backup and rename over it, so a crash never leaves a truncated backup behind
+	tmpPath := backupPath + ".tmp"
+	if err := e.fs.WriteFile(tmpPath, sealed, 0o600); err != nil {
+		return fmt.Errorf("failed to write encrypted backup: %w", err)
+	}
+	if err := e.fs.Rename(tmpPath, backupPath); err != nil {
+		e.fs.Remove(tmpPath)
+		return fmt.Errorf("failed to replace backup: %w", err)
+	}
+	return nil
+}
+
+// DecryptBackup returns the plaintext of the encrypted backup at backupPath, decompressed when it was compressed
+// under disk pressure. The backup must keep the name it was encrypted under, since the name is authenticated along
+// with the content.
+func DecryptBackup(backupPath string, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return nil, errors.New("backup is not encrypted")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("backup is truncated")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(filepath.Base(backupPath)))
+	if err != nil {
+		return nil, errors.New("failed to decrypt backup: wrong key, renamed or modified file")
+	}
+	return decompress(plaintext)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encrypter, err := newBackupEncrypter(OSFS{}, key)
+	assert.NoError(t, err)
+
+	backup := filepath.Join(t.TempDir(), "audit.log.1000")
+	plaintext := []byte(`{"transaction":{"id":"1","request":{"body":"password=hunter2"}}}`)
+	assert.NoError(t, os.WriteFile(backup, plaintext, 0o644))
+	assert.NoError(t, encrypter.encrypt(backup))
+
+	sealed, err := os.ReadFile(backup)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), "hunter2")
+
+	decrypted, err := DecryptBackup(backup, key)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	t.Run("Should not encrypt a backup twice", func(t *testing.T) {
+		assert.NoError(t, encrypter.encrypt(backup))
+		decrypted, err := DecryptBackup(backup, key)
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	})
+
+	t.Run("Should reject a wrong key or a renamed backup", func(t *testing.T) {
+		_, err := DecryptBackup(backup, bytes.Repeat([]byte{8}, 32))
+		assert.Error(t, err)
+
+		renamed := filepath.Join(filepath.Dir(backup), "audit.log.2000")
+		assert.NoError(t, os.WriteFile(renamed, sealed, 0o644))
+		_, err = DecryptBackup(renamed, key)
+		assert.Error(t, err)
+	})
+
+	t.Run("Should reject invalid keys and plaintext backups", func(t *testing.T) {
+		_, err := newBackupEncrypter(OSFS{}, []byte("short"))
+		assert.Error(t, err)
+
+		plain := filepath.Join(filepath.Dir(backup), "audit.log.3000")
+		assert.NoError(t, os.WriteFile(plain, plaintext, 0o644))
+		_, err = DecryptBackup(plain, key)
+		assert.ErrorContains(t, err, "not encrypted")
+	})
+}
+
+func TestSealBackup(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	encryptionKey := bytes.Repeat([]byte{7}, 16)
+	signingKey := []byte("secret")
+	processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, EncryptionKey: encryptionKey, SigningKey: signingKey})
+
+	assert.NoError(t, os.WriteFile(logFile, []byte("{}\n"), 0o644))
+	backup, err := processor.rotateLogs()
+	assert.NoError(t, err)
+	processor.sealBackup(backup)
+
+	// The signature covers the encrypted file, so backups can be verified without the encryption key
+	results, err := VerifyBackups(logFile, signingKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupVerification{{File: filepath.Base(backup), OK: true}}, results)
+
+	decrypted, err := DecryptBackup(backup, encryptionKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n", string(decrypted))
+}
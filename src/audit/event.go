@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Event is the compact form of a violation shipped to remote sinks. Unlike the audit log it leaves out
+// request headers and bodies, which may contain credentials and personal data.
+type Event struct {
+	Time            time.Time    `json:"time"`
+	ID              string       `json:"id"`
+	ClientIP        string       `json:"client_ip"`
+	ClientHostname  string       `json:"client_hostname,omitempty"`
+	VerifiedCrawler string       `json:"verified_crawler,omitempty"`
+	Host            string       `json:"host,omitempty"`
+	Method          string       `json:"method,omitempty"`
+	URI             string       `json:"uri,omitempty"`
+	Status          int          `json:"status,omitempty"`
+	Severity        string       `json:"severity"`
+	Rules           []EventRule  `json:"rules"`
+	ClientCancelled bool         `json:"client_cancelled,omitempty"`
+	Aggregation     *Aggregation `json:"aggregation,omitempty"`
+}
+
+type EventRule struct {
+	ID       int    `json:"id"`
+	File     string `json:"file"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	// Data is the rule's log data, for CRS rules the matched data and the variable it was found in
+	Data string   `json:"data,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// NewEvent builds the event of a processed audit log
+func NewEvent(log Log) Event {
+	event := Event{
+		Time:            log.Time(),
+		ID:              log.Transaction.ID,
+		ClientIP:        log.Transaction.ClientIP,
+		ClientHostname:  log.ClientHostname,
+		VerifiedCrawler: log.VerifiedCrawler,
+		Host:            log.Host(),
+		Severity:        log.Severity(),
+		Rules:           make([]EventRule, 0, len(log.Messages)),
+		ClientCancelled: log.ClientCancelled(),
+		Aggregation:     log.Aggregation,
+	}
+	if request := log.Transaction.Request; request != nil {
+		event.Method = request.Method
+		event.URI = request.URI
+	}
+	if response := log.Transaction.Response; response != nil {
+		event.Status = response.Status
+	}
+	for _, msg := range log.Messages {
+		event.Rules = append(event.Rules, EventRule{
+			ID:       msg.Data.ID,
+			File:     msg.Data.File,
+			Message:  msg.Data.Msg,
+			Severity: msg.Data.Severity.String(),
+			Data:     msg.Data.Data,
+			Tags:     msg.Data.Tags,
+		})
+	}
+	return event
+}
+
+// Time returns when the transaction started, or now if the audit log has no timestamp
+func (l Log) Time() time.Time {
+	return timeOf(l, time.Now)
+}
+
+// timeOf returns when the transaction of the log started, or the time given by now if the log has no timestamp,
+// so analytics stay correct when processing falls behind
+func timeOf(log Log, now func() time.Time) time.Time {
+	if t, ok := log.EventTime(); ok {
+		return t
+	}
+	return now()
+}
+
+// EventTime returns when the transaction started, and false if the audit log has no timestamp
+func (l Log) EventTime() (time.Time, bool) {
+	t := l.Transaction.Time
+	if t.IsZero() {
+		// Logs built rather than parsed only have the raw timestamps
+		t = l.Transaction.parseTime()
+	}
+	return t, !t.IsZero()
+}
+
+// Host returns the host the request was addressed to, from the request URI or else the Host header
+func (l Log) Host() string {
+	request := l.Transaction.Request
+	if request == nil {
+		return ""
+	}
+	if uri, err := url.Parse(request.URI); err == nil && uri.Host != "" {
+		return uri.Host
+	}
+	for name, values := range request.Headers {
+		if len(values) > 0 && (name == "Host" || name == "host") {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Severity returns the name of the most severe matched rule's severity
+func (l Log) Severity() string {
+	msg := l.mostSevere()
+	if msg == nil {
+		return "unknown"
+	}
+	return msg.Data.Severity.String()
+}
+
+// mostSevere returns the first of the most severe matched rules, nil if no rule matched
+func (l Log) mostSevere() *Message {
+	var highest *Message
+	for i, msg := range l.Messages {
+		// Lower values are more severe, emergency being 0
+		if highest == nil || msg.Data.Severity < highest.Data.Severity {
+			highest = &l.Messages[i]
+		}
+	}
+	return highest
+}
+
+// RuleFamily buckets a rule ID by its thousands, e.g. 942100 is in family 942xxx, the CRS SQL injection rules
+func RuleFamily(id int) string {
+	return fmt.Sprintf("%dxxx", id/1000)
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type FluentOptions struct {
+	// Address is the host:port of the Fluentd or Fluent Bit forward input
+	Address string
+	// Tag routes the events in the Fluent pipeline
+	Tag string
+	// Ack waits for the receiver to acknowledge each batch, so batches lost with a connection are resent
+	Ack bool
+	// BatchSize sends the buffered events as soon as this many are waiting, rather than at the next flush
+	BatchSize int
+	// Timeout bounds dialing, writing a batch and waiting for its ack
+	Timeout time.Duration
+	Retry   RetryOptions
+}
+
+// FluentSink sends violation events to Fluentd or Fluent Bit over the forward protocol, in Forward mode: each batch
+// is one msgpack message carrying the tag and its entries. The connection is kept open between batches.
+type FluentSink struct {
+	options FluentOptions
+	dial    func() (net.Conn, error)
+
+	mu      sync.Mutex
+	pending []Log
+
+	// connMu serializes batches on the connection
+	connMu sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewFluentSink(options FluentOptions) *FluentSink {
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	return &FluentSink{
+		options: options,
+		dial:    func() (net.Conn, error) { return dialer.Dial("tcp", options.Address) },
+	}
+}
+
+func (s *FluentSink) Name() string {
+	return "fluent"
+}
+
+func (s *FluentSink) Send(log Log) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, log)
+	if len(s.pending) < s.options.BatchSize {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	return s.forward(batch)
+}
+
+func (s *FluentSink) Flush(force bool) error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.forward(batch)
+}
+
+func (s *FluentSink) forward(batch []Log) error {
+	message, chunk, err := s.message(batch)
+	if err != nil {
+		return err
+	}
+	return deliver(s.Name(), len(batch), s.options.Retry, func() error {
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
+		if err := s.write(message, chunk); err != nil {
+			// The stream is in an unknown state, so the next attempt starts on a fresh connection
+			s.closeConn()
+			return err
+		}
+		return nil
+	})
+}
+
+// message encodes the batch as [tag, [[time, record], ...], {"chunk": id, "size": n}]
+func (s *FluentSink) message(batch []Log) ([]byte, string, error) {
+	entries := make([]any, 0, len(batch))
+	for _, log := range batch {
+		record, err := fluentRecord(NewEvent(log))
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, []any{eventTime(log.Time()), record})
+	}
+
+	option := map[string]any{"size": len(batch)}
+	chunk := ""
+	if s.options.Ack {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+	message, err := appendMsgpack(nil, []any{s.options.Tag, entries, option})
+	return message, chunk, err
+}
+
+// fluentRecord converts the event to the generic map the msgpack encoder handles
+func fluentRecord(event Event) (map[string]any, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var record map[string]any
+	err = decoder.Decode(&record)
+	return record, err
+}
+
+func (s *FluentSink) write(message []byte, chunk string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", s.options.Address, err)
+		}
+		s.conn = conn
+		s.reader = bufio.NewReader(conn)
+	}
+
+	s.conn.SetDeadline(time.Now().Add(s.options.Timeout))
+	if _, err := s.conn.Write(message); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.options.Address, err)
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	response, err := readMsgpack(s.reader)
+	if err != nil {
+		return fmt.Errorf("failed to read ack from %s: %w", s.options.Address, err)
+	}
+	if ack, ok := response.(map[string]any); !ok || ack["ack"] != chunk {
+		return fmt.Errorf("unexpected ack from %s: %v", s.options.Address, response)
+	}
+	return nil
+}
+
+func (s *FluentSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.reader = nil
+	}
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fluentReceiver accepts forward protocol messages, acking them unless dropNext is set
+type fluentReceiver struct {
+	listener net.Listener
+	messages chan []any
+	dropNext chan bool
+}
+
+func newFluentReceiver(t *testing.T) *fluentReceiver {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	r := &fluentReceiver{listener: listener, messages: make(chan []any, 10), dropNext: make(chan bool, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fluentReceiver) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		value, err := readMsgpack(reader)
+		if err != nil {
+			return
+		}
+		select {
+		case <-r.dropNext:
+			return
+		default:
+		}
+		message := value.([]any)
+		r.messages <- message
+		if chunk, ok := message[2].(map[string]any)["chunk"]; ok {
+			ack, _ := appendMsgpack(nil, map[string]any{"ack": chunk})
+			conn.Write(ack)
+		}
+	}
+}
+
+func TestFluentSink(t *testing.T) {
+	receiver := newFluentReceiver(t)
+	sink := NewFluentSink(FluentOptions{
+		Address:   receiver.listener.Addr().String(),
+		Tag:       "coraza.violations",
+		Ack:       true,
+		BatchSize: 10,
+		Timeout:   time.Second,
+		Retry:     RetryOptions{Attempts: 2, Backoff: time.Millisecond},
+	})
+
+	t.Run("Should forward buffered events in one acknowledged message", func(t *testing.T) {
+		assert.NoError(t, sink.Send(timedViolation("a", 1756217654993576518, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Send(timedViolation("b", 1756217654993576518, "example.com", 941100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Flush(false))
+
+		message := <-receiver.messages
+		assert.Equal(t, "coraza.violations", message[0])
+		entries := message[1].([]any)
+		assert.Len(t, entries, 2)
+
+		entry := entries[0].([]any)
+		assert.Equal(t, time.Unix(0, 1756217654993576518), entry[0])
+		record := entry[1].(map[string]any)
+		assert.Equal(t, "a", record["id"])
+		assert.Equal(t, "critical", record["severity"])
+		assert.Equal(t, int64(942100), record["rules"].([]any)[0].(map[string]any)["id"])
+		assert.Equal(t, int64(2), message[2].(map[string]any)["size"])
+	})
+
+	t.Run("Should resend a batch on a new connection when it is not acknowledged", func(t *testing.T) {
+		receiver.dropNext <- true
+		assert.NoError(t, sink.Send(timedViolation("c", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Flush(true))
+
+		message := <-receiver.messages
+		assert.Equal(t, "c", message[1].([]any)[0].([]any)[1].(map[string]any)["id"])
+	})
+
+	t.Run("Should drop the batch when the receiver is unreachable", func(t *testing.T) {
+		address := receiver.listener.Addr().String()
+		receiver.listener.Close()
+		sink := NewFluentSink(FluentOptions{Address: address, BatchSize: 10, Timeout: time.Second, Retry: RetryOptions{Attempts: 1}})
+		assert.NoError(t, sink.Send(timedViolation("d", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.ErrorContains(t, sink.Flush(true), "dropped 1 events")
+	})
+}
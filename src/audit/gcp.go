@@ -0,0 +1,221 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/gcp"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// gcpMaxBatch is the most entries or messages sent in one request, the limit of Pub/Sub publish calls
+const gcpMaxBatch = 1000
+
+type GoogleCloudOptions struct {
+	// ProjectID owns the log and the topic. Empty uses the project of the Application Default Credentials.
+	ProjectID string
+	// LogName enables the Cloud Logging sink, writing to projects/<ProjectID>/logs/<LogName>
+	LogName string
+	// Topic enables the Pub/Sub sink, either a topic ID in ProjectID or a full projects/<project>/topics/<topic> name
+	Topic string
+	// BatchSize sends the buffered events as soon as this many are waiting, rather than at the next flush
+	BatchSize int
+	Timeout   time.Duration
+	Retry     RetryOptions
+	// Transport carries the requests. Nil uses the default transport.
+	Transport http.RoundTripper
+	// Tokens authenticate the requests. Nil uses Application Default Credentials.
+	Tokens *gcp.TokenSource
+	// LoggingEndpoint and PubSubEndpoint override the API endpoints, e.g. for the Pub/Sub emulator
+	LoggingEndpoint string
+	PubSubEndpoint  string
+}
+
+// googleCloudSink buffers events and sends them in batches to a Google Cloud API. The Cloud Logging and Pub/Sub
+// sinks differ in how a batch is encoded.
+type googleCloudSink struct {
+	name    string
+	options GoogleCloudOptions
+	client  *http.Client
+	// request builds the URL and body of a batch in the given project
+	request func(project string, batch []Log) (string, any)
+
+	mu      sync.Mutex
+	pending []Log
+}
+
+// NewCloudLoggingSink writes violation events to Cloud Logging as structured entries, with the most severe rule's
+// severity as the entry severity and the request as the entry's httpRequest
+func NewCloudLoggingSink(options GoogleCloudOptions) Sink {
+	if options.LoggingEndpoint == "" {
+		options.LoggingEndpoint = "https://logging.googleapis.com"
+	}
+	sink := newGoogleCloudSink("cloud_logging", options)
+	sink.request = func(project string, batch []Log) (string, any) {
+		logName := "projects/" + project + "/logs/" + url.PathEscape(options.LogName)
+		entries := make([]map[string]any, 0, len(batch))
+		for _, log := range batch {
+			entries = append(entries, cloudLoggingEntry(logName, project, log))
+		}
+		return options.LoggingEndpoint + "/v2/entries:write", map[string]any{"entries": entries, "partialSuccess": true}
+	}
+	return sink
+}
+
+// NewPubSubSink publishes violation events to a Pub/Sub topic, with the severity, host and rule family of each
+// event as message attributes for subscription filters
+func NewPubSubSink(options GoogleCloudOptions) Sink {
+	if options.PubSubEndpoint == "" {
+		options.PubSubEndpoint = "https://pubsub.googleapis.com"
+	}
+	sink := newGoogleCloudSink("pubsub", options)
+	sink.request = func(project string, batch []Log) (string, any) {
+		topic := options.Topic
+		if !strings.HasPrefix(topic, "projects/") {
+			topic = "projects/" + project + "/topics/" + topic
+		}
+		messages := make([]map[string]any, 0, len(batch))
+		for _, log := range batch {
+			data, err := json.Marshal(NewEvent(log))
+			if err != nil {
+				continue
+			}
+			attributes := map[string]string{"severity": log.Severity(), "host": log.Host()}
+			if msg := log.mostSevere(); msg != nil {
+				attributes["rule_family"] = RuleFamily(msg.Data.ID)
+			}
+			// data is a bytes field, which encoding/json encodes as base64
+			messages = append(messages, map[string]any{"data": data, "attributes": attributes})
+		}
+		return options.PubSubEndpoint + "/v1/" + topic + ":publish", map[string]any{"messages": messages}
+	}
+	return sink
+}
+
+func newGoogleCloudSink(name string, options GoogleCloudOptions) *googleCloudSink {
+	client := outbound.Client(options.Transport, name, options.Timeout)
+	if options.Tokens == nil {
+		options.Tokens = gcp.NewTokenSource(client)
+	}
+	return &googleCloudSink{name: name, options: options, client: client}
+}
+
+func (s *googleCloudSink) Name() string {
+	return s.name
+}
+
+func (s *googleCloudSink) Send(log Log) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, log)
+	if len(s.pending) < s.options.BatchSize {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	return s.push(batch)
+}
+
+func (s *googleCloudSink) Flush(force bool) error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.push(batch)
+}
+
+func (s *googleCloudSink) push(batch []Log) error {
+	project := s.options.ProjectID
+	if project == "" {
+		var err error
+		if project, err = s.options.Tokens.ProjectID(); err != nil {
+			metricSinkEvents.WithLabelValues(s.name, "dropped").Add(float64(len(batch)))
+			return fmt.Errorf("dropped %d events: %w", len(batch), err)
+		}
+	}
+	slices.SortStableFunc(batch, func(a, b Log) int { return a.Time().Compare(b.Time()) })
+
+	var errs []error
+	for chunk := range slices.Chunk(batch, gcpMaxBatch) {
+		endpoint, payload := s.request(project, chunk)
+		body, err := json.Marshal(payload)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, deliver(s.name, len(chunk), s.options.Retry, func() error {
+			return s.post(endpoint, body)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *googleCloudSink) post(endpoint string, body []byte) error {
+	token, err := s.options.Tokens.Token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s responded %d: %s", s.name, resp.StatusCode, bytes.TrimSpace(message))
+	// Other client errors mean the request was rejected, e.g. a missing permission or topic
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// cloudLoggingEntry builds the LogEntry of a violation. The rule severities are named like Cloud Logging's
+// LogSeverity values, so they map directly.
+func cloudLoggingEntry(logName string, project string, log Log) map[string]any {
+	event := NewEvent(log)
+	severity := strings.ToUpper(event.Severity)
+	if event.Severity == "unknown" {
+		severity = "DEFAULT"
+	}
+	entry := map[string]any{
+		"logName":     logName,
+		"resource":    map[string]any{"type": "global", "labels": map[string]string{"project_id": project}},
+		"timestamp":   event.Time.UTC().Format(time.RFC3339Nano),
+		"severity":    severity,
+		"insertId":    event.ID,
+		"jsonPayload": event,
+	}
+	if msg := log.mostSevere(); msg != nil {
+		entry["labels"] = map[string]string{"rule_family": RuleFamily(msg.Data.ID)}
+	}
+	if event.Method != "" {
+		httpRequest := map[string]any{"requestMethod": event.Method, "requestUrl": event.URI, "remoteIp": event.ClientIP}
+		if event.Status != 0 {
+			httpRequest["status"] = event.Status
+		}
+		entry["httpRequest"] = httpRequest
+	}
+	return entry
+}
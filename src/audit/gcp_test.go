@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGoogleCloud serves the OAuth2 token endpoint and records the API requests it receives
+type fakeGoogleCloud struct {
+	url      string
+	mu       sync.Mutex
+	requests []map[string]any
+	paths    []string
+	statuses []int
+}
+
+func newFakeGoogleCloud(t *testing.T) *fakeGoogleCloud {
+	t.Helper()
+	fake := &fakeGoogleCloud{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+			return
+		}
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if len(fake.statuses) > 0 {
+			status := fake.statuses[0]
+			fake.statuses = fake.statuses[1:]
+			w.WriteHeader(status)
+			return
+		}
+		var request map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		fake.requests = append(fake.requests, request)
+		fake.paths = append(fake.paths, r.URL.Path)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+
+	// Application Default Credentials of a service account whose tokens are issued by the fake
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "waf-project",
+		"client_email": "waf@waf-project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	assert.NoError(t, os.WriteFile(file, credentials, 0o600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+	return fake
+}
+
+func TestCloudLoggingSink(t *testing.T) {
+	fake := newFakeGoogleCloud(t)
+	sink := NewCloudLoggingSink(GoogleCloudOptions{
+		LogName:         "coraza-waf",
+		BatchSize:       100,
+		Timeout:         time.Second,
+		Retry:           RetryOptions{Attempts: 2, Backoff: time.Millisecond},
+		LoggingEndpoint: fake.url,
+	})
+
+	t.Run("Should write entries with the rule severity", func(t *testing.T) {
+		blocked := timedViolation("b", int64(2*time.Second), "example.com", 942100, types.RuleSeverityCritical)
+		blocked.Transaction.Response = &TransactionResponse{Status: 403}
+		assert.NoError(t, sink.Send(blocked))
+		assert.NoError(t, sink.Send(timedViolation("a", int64(time.Second), "example.com", 920100, types.RuleSeverityWarning)))
+		assert.NoError(t, sink.Flush(false))
+
+		assert.Equal(t, []string{"/v2/entries:write"}, fake.paths)
+		entries := fake.requests[0]["entries"].([]any)
+		assert.Len(t, entries, 2)
+		first, second := entries[0].(map[string]any), entries[1].(map[string]any)
+		assert.Equal(t, "projects/waf-project/logs/coraza-waf", first["logName"])
+		assert.Equal(t, "WARNING", first["severity"], "Expected entries in time order")
+		assert.Equal(t, "1970-01-01T00:00:01Z", first["timestamp"])
+		assert.Equal(t, "CRITICAL", second["severity"])
+		assert.Equal(t, "b", second["insertId"])
+		assert.Equal(t, map[string]any{"rule_family": "942xxx"}, second["labels"])
+		assert.Equal(t, map[string]any{"requestMethod": "GET", "requestUrl": "http://example.com/search?q=1", "remoteIp": "192.0.2.1", "status": float64(403)}, second["httpRequest"])
+		assert.NotContains(t, second["jsonPayload"], "body")
+	})
+
+	t.Run("Should retry server errors but drop rejected entries", func(t *testing.T) {
+		fake.requests, fake.paths = nil, nil
+		fake.statuses = []int{http.StatusServiceUnavailable}
+		assert.NoError(t, sink.Send(timedViolation("c", int64(time.Second), "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Flush(false))
+		assert.Len(t, fake.requests, 1)
+
+		fake.statuses = []int{http.StatusForbidden, http.StatusOK}
+		assert.NoError(t, sink.Send(timedViolation("d", int64(time.Second), "example.com", 942100, types.RuleSeverityCritical)))
+		assert.ErrorContains(t, sink.Flush(true), "cloud_logging responded 403")
+	})
+}
+
+func TestPubSubSink(t *testing.T) {
+	fake := newFakeGoogleCloud(t)
+	sink := NewPubSubSink(GoogleCloudOptions{
+		ProjectID:      "other-project",
+		Topic:          "waf-violations",
+		BatchSize:      1,
+		Timeout:        time.Second,
+		Retry:          RetryOptions{Attempts: 1},
+		PubSubEndpoint: fake.url,
+	})
+
+	assert.NoError(t, sink.Send(timedViolation("a", int64(time.Second), "example.com", 942100, types.RuleSeverityCritical)))
+	assert.Equal(t, []string{"/v1/projects/other-project/topics/waf-violations:publish"}, fake.paths)
+	message := fake.requests[0]["messages"].([]any)[0].(map[string]any)
+	assert.Equal(t, map[string]any{"severity": "critical", "host": "example.com", "rule_family": "942xxx"}, message["attributes"])
+	data, err := base64.StdEncoding.DecodeString(message["data"].(string))
+	assert.NoError(t, err)
+	var event Event
+	assert.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, "a", event.ID)
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// heatmapHours is the number of hourly buckets kept by the heatmap
+const heatmapHours = 24
+
+// RuleHeatmap is a sink that counts rule hits per hour so scheduled scanner noise can be told apart from attack surges
+type RuleHeatmap struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[int64]map[int]int
+}
+
+// Heatmap is a rule by hour matrix of hit counts, oldest hour first
+type Heatmap struct {
+	Hours []time.Time    `json:"hours"`
+	Rules []HeatmapEntry `json:"rules"`
+}
+
+type HeatmapEntry struct {
+	RuleID int   `json:"rule_id"`
+	Total  int   `json:"total"`
+	Counts []int `json:"counts"`
+}
+
+func NewRuleHeatmap() *RuleHeatmap {
+	return &RuleHeatmap{
+		now:     time.Now,
+		buckets: map[int64]map[int]int{},
+	}
+}
+
+func (h *RuleHeatmap) Name() string {
+	return "heatmap"
+}
+
+func (h *RuleHeatmap) Send(log Log) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hour := timeOf(log, h.now).Truncate(time.Hour).Unix()
+	bucket, ok := h.buckets[hour]
+	if !ok {
+		bucket = map[int]int{}
+		h.buckets[hour] = bucket
+	}
+	for _, msg := range log.Messages {
+		bucket[msg.Data.ID]++
+	}
+	return nil
+}
+
+// Flush drops buckets that have fallen outside of the heatmap
+func (h *RuleHeatmap) Flush(force bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := h.now().Truncate(time.Hour).Add(-(heatmapHours - 1) * time.Hour).Unix()
+	for hour := range h.buckets {
+		if hour < cutoff {
+			delete(h.buckets, hour)
+		}
+	}
+	return nil
+}
+
+// Heatmap returns the hourly hit counts of every rule seen in the last 24 hours, busiest rule first
+func (h *RuleHeatmap) Heatmap() Heatmap {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	current := h.now().Truncate(time.Hour)
+	heatmap := Heatmap{Hours: make([]time.Time, heatmapHours)}
+	rules := map[int]*HeatmapEntry{}
+	for i := range heatmapHours {
+		hour := current.Add(-time.Duration(heatmapHours-1-i) * time.Hour)
+		heatmap.Hours[i] = hour.UTC()
+		for ruleID, count := range h.buckets[hour.Unix()] {
+			entry, ok := rules[ruleID]
+			if !ok {
+				entry = &HeatmapEntry{RuleID: ruleID, Counts: make([]int, heatmapHours)}
+				rules[ruleID] = entry
+			}
+			entry.Counts[i] = count
+			entry.Total += count
+		}
+	}
+
+	heatmap.Rules = make([]HeatmapEntry, 0, len(rules))
+	for _, entry := range rules {
+		heatmap.Rules = append(heatmap.Rules, *entry)
+	}
+	sort.Slice(heatmap.Rules, func(i, j int) bool {
+		if heatmap.Rules[i].Total != heatmap.Rules[j].Total {
+			return heatmap.Rules[i].Total > heatmap.Rules[j].Total
+		}
+		return heatmap.Rules[i].RuleID < heatmap.Rules[j].RuleID
+	})
+	return heatmap
+}
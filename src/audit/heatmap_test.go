@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleHeatmap(t *testing.T) {
+	heatmap := NewRuleHeatmap()
+
+	now := time.Unix(1700000000, 0)
+	heatmap.now = func() time.Time { return now }
+
+	assert.NoError(t, heatmap.Send(violation("192.0.2.1", "/", 913100)))
+
+	now = now.Add(3 * time.Hour)
+	assert.NoError(t, heatmap.Send(violation("192.0.2.1", "/", 942100)))
+	assert.NoError(t, heatmap.Send(violation("192.0.2.2", "/", 942100)))
+	assert.NoError(t, heatmap.Send(violation("192.0.2.2", "/", 913100)))
+
+	result := heatmap.Heatmap()
+	assert.Len(t, result.Hours, heatmapHours)
+	assert.Equal(t, now.Truncate(time.Hour).UTC(), result.Hours[heatmapHours-1])
+	assert.Len(t, result.Rules, 2)
+	assert.Equal(t, 913100, result.Rules[0].RuleID)
+	assert.Equal(t, 2, result.Rules[0].Total)
+	assert.Equal(t, 1, result.Rules[0].Counts[heatmapHours-4])
+	assert.Equal(t, 1, result.Rules[0].Counts[heatmapHours-1])
+	assert.Equal(t, 942100, result.Rules[1].RuleID)
+	assert.Equal(t, 2, result.Rules[1].Counts[heatmapHours-1])
+
+	// Hours older than the heatmap should be dropped on flush
+	now = now.Add(22 * time.Hour)
+	assert.NoError(t, heatmap.Flush(false))
+	assert.Len(t, heatmap.buckets, 1)
+	assert.Len(t, heatmap.Heatmap().Rules, 2)
+}
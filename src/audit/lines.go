@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultMaxLineBytes is the size above which an audit log line is truncated
+const DefaultMaxLineBytes = 1 << 20
+
+// truncatedStringBytes is how much of each JSON string is kept once a line is over the maximum size
+const truncatedStringBytes = 256
+
+// lineReader reads audit log lines of any size in bounded memory. Lines over the maximum size are truncated into
+// valid JSON rather than dropped: the rest of the line is kept with every string shortened, so the messages that
+// follow a huge captured body still get processed.
+type lineReader struct {
+	reader *bufio.Reader
+	max    int
+	buf    []byte
+}
+
+func newLineReader(r io.Reader, max int, buf []byte) *lineReader {
+	if max <= 0 {
+		max = DefaultMaxLineBytes
+	}
+	return &lineReader{reader: bufio.NewReaderSize(r, 64*1024), max: max, buf: buf}
+}
+
+// next returns the next line without its line ending, and whether it was truncated. It returns io.EOF after the
+// last line. The line is only valid until the next call.
+func (l *lineReader) next() ([]byte, bool, error) {
+	l.buf = l.buf[:0]
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		if len(l.buf)+len(chunk) > l.max {
+			return l.truncate(chunk, err)
+		}
+		l.buf = append(l.buf, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || len(l.buf) == 0) {
+			return nil, false, err
+		}
+		return trimLineEnding(l.buf), false, nil
+	}
+}
+
+// truncate shrinks the rest of an oversized line, of which chunk has been read
+func (l *lineReader) truncate(chunk []byte, err error) ([]byte, bool, error) {
+	s := shrinker{out: l.buf, limit: 2 * l.max}
+	s.scan(l.buf)
+	for {
+		s.feed(trimLineEnding(chunk))
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			break
+		}
+		chunk, err = l.reader.ReadSlice('\n')
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	l.buf = s.close()
+	return l.buf, true, nil
+}
+
+func trimLineEnding(line []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+}
+
+// shrinker appends JSON to out, shortening every string to truncatedStringBytes, and closes it into a valid
+// document. Once out reaches the limit, the rest of the input is dropped.
+type shrinker struct {
+	out   []byte
+	limit int
+
+	// containers holds the open objects and arrays
+	containers []byte
+	expectKey  bool
+	afterKey   bool
+
+	inString    bool
+	isKey       bool
+	stringBytes int
+	dropping    bool
+	// escapeFirst is set after the backslash of an escape sequence, and escapeLeft counts the hex digits left
+	escapeFirst bool
+	escapeLeft  int
+}
+
+// scan updates the state with JSON already in out
+func (s *shrinker) scan(data []byte) {
+	for _, c := range data {
+		s.step(c, false)
+	}
+}
+
+// feed appends data, shortened
+func (s *shrinker) feed(data []byte) {
+	for _, c := range data {
+		if len(s.out) >= s.limit {
+			return
+		}
+		s.step(c, true)
+	}
+}
+
+func (s *shrinker) step(c byte, write bool) {
+	emit := func(c byte) {
+		if write {
+			s.out = append(s.out, c)
+		}
+	}
+
+	if s.inString {
+		switch {
+		case s.escapeFirst:
+			s.escapeFirst = false
+			s.escapeLeft = 0
+			if c == 'u' {
+				s.escapeLeft = 4
+			}
+		case s.escapeLeft > 0:
+			s.escapeLeft--
+		case c == '"':
+			s.inString = false
+			s.afterKey = s.isKey
+			emit(c)
+			return
+		default:
+			// Only cut between characters, since an incomplete escape sequence would make the document invalid
+			if write && s.stringBytes >= truncatedStringBytes {
+				s.dropping = true
+			}
+			s.escapeFirst = c == '\\'
+		}
+		s.stringBytes++
+		if !s.dropping {
+			emit(c)
+		}
+		return
+	}
+
+	switch c {
+	case '"':
+		s.inString, s.stringBytes, s.dropping = true, 0, false
+		s.isKey = s.expectKey && s.top() == '{'
+	case '{', '[':
+		s.containers = append(s.containers, c)
+		s.expectKey = c == '{'
+	case '}', ']':
+		if len(s.containers) > 0 {
+			s.containers = s.containers[:len(s.containers)-1]
+		}
+		s.expectKey = false
+	case ',':
+		s.expectKey = s.top() == '{'
+	case ':':
+		s.expectKey, s.afterKey = false, false
+	case ' ', '\t', '\r', '\n':
+		if write {
+			return
+		}
+	}
+	emit(c)
+}
+
+func (s *shrinker) top() byte {
+	if len(s.containers) == 0 {
+		return 0
+	}
+	return s.containers[len(s.containers)-1]
+}
+
+// close ends the string, member and containers left open, and returns the document
+func (s *shrinker) close() []byte {
+	if s.inString {
+		if !s.dropping && (s.escapeFirst || s.escapeLeft > 0) {
+			// Drop the incomplete escape sequence
+			if i := bytes.LastIndexByte(s.out, '\\'); i >= 0 {
+				s.out = s.out[:i]
+			}
+		}
+		s.out = append(s.out, '"')
+		s.afterKey = s.isKey
+	}
+	s.out = bytes.TrimRight(s.out, " \t\r\n")
+	if !s.inString && len(s.containers) > 0 && len(s.out) >= s.limit {
+		// Drop the number or literal the limit may have cut, such as "fals"
+		s.out = bytes.TrimRight(s.out, "0123456789+-.eEtruefalsn")
+	}
+	switch {
+	case s.afterKey:
+		s.out = append(s.out, ":null"...)
+	case len(s.out) > 0 && s.out[len(s.out)-1] == ',':
+		s.out = s.out[:len(s.out)-1]
+	case len(s.out) > 0 && s.out[len(s.out)-1] == ':':
+		s.out = append(s.out, "null"...)
+	}
+	for i := len(s.containers) - 1; i >= 0; i-- {
+		if s.containers[i] == '{' {
+			s.out = append(s.out, '}')
+		} else {
+			s.out = append(s.out, ']')
+		}
+	}
+	return s.out
+}
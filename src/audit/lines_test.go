@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readLines returns every line read with the maximum size, and whether each was truncated
+func readLines(t *testing.T, input string, max int) ([]string, []bool) {
+	lines := newLineReader(strings.NewReader(input), max, nil)
+	var read []string
+	var truncated []bool
+	for {
+		line, wasTruncated, err := lines.next()
+		if err == io.EOF {
+			return read, truncated
+		}
+		require.NoError(t, err)
+		read = append(read, string(line))
+		truncated = append(truncated, wasTruncated)
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	t.Run("reads lines", func(t *testing.T) {
+		lines, truncated := readLines(t, "{\"a\":1}\r\n{\"b\":2}\n{\"c\":3}", 100)
+		assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}, lines)
+		assert.Equal(t, []bool{false, false, false}, truncated)
+
+		lines, _ = readLines(t, "", 100)
+		assert.Empty(t, lines)
+	})
+
+	t.Run("keeps the messages after a huge body", func(t *testing.T) {
+		body := strings.Repeat("A", 200*1024)
+		line := `{"transaction":{"id":"abc","request":{"body":"` + body + `","headers":{"x":["` + body + `"]}}},"messages":[{"message":"SQL injection","data":{"id":942100,"msg":"` + strings.Repeat("m", 300) + `"}}]}`
+		lines, truncated := readLines(t, line+"\n"+`{"next":true}`+"\n", 64*1024)
+		require.Len(t, lines, 2)
+		assert.Equal(t, []bool{true, false}, truncated)
+		assert.Less(t, len(lines[0]), 64*1024+2*truncatedStringBytes+200)
+
+		var log Log
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &log))
+		assert.Equal(t, "abc", log.Transaction.ID)
+		require.Len(t, log.Messages, 1)
+		assert.Equal(t, 942100, log.Messages[0].Data.ID)
+		assert.Len(t, log.Messages[0].Data.Msg, truncatedStringBytes)
+		assert.Equal(t, `{"next":true}`, lines[1])
+	})
+
+	t.Run("closes a document cut at the limit", func(t *testing.T) {
+		var items []string
+		for range 10000 {
+			items = append(items, `{"k":"v","n":[1,2]}`)
+		}
+		lines, truncated := readLines(t, `{"items":[`+strings.Join(items, ",")+`]}`, 1000)
+		assert.Equal(t, []bool{true}, truncated)
+		assert.True(t, json.Valid([]byte(lines[0])), lines[0])
+	})
+
+	t.Run("keeps escape sequences whole", func(t *testing.T) {
+		for _, escape := range []string{`é`, `\u00e9`, `\"`, `\\`} {
+			lines, _ := readLines(t, `{"key":"`+strings.Repeat(escape, 1000)+`","other":"`+strings.Repeat(escape, 1000)+`"}`, 1001)
+			assert.True(t, json.Valid([]byte(lines[0])), lines[0])
+		}
+	})
+
+	t.Run("closes open keys and members", func(t *testing.T) {
+		for input, expected := range map[string]string{
+			`{"a":"x","b`:  `{"a":"x","b":null}`,
+			`{"a":"x",`:    `{"a":"x"}`,
+			`{"a":`:        `{"a":null}`,
+			`{"a":["x\u00`: `{"a":["x"]}`,
+		} {
+			s := shrinker{limit: 100}
+			s.feed([]byte(input))
+			assert.Equal(t, expected, string(s.close()))
+		}
+	})
+
+	t.Run("drops a literal cut at the limit", func(t *testing.T) {
+		for input, expected := range map[string]string{
+			`{"a":1,"b":false}`: `{"a":1,"b":null}`,
+			`{"a":1,"b":12345}`: `{"a":1,"b":null}`,
+			`{"a":1,"b":[true]`: `{"a":1,"b":[]}`,
+		} {
+			s := shrinker{limit: len(input) - 2}
+			s.feed([]byte(input))
+			assert.Equal(t, expected, string(s.close()))
+		}
+	})
+}
+
+// FuzzLineReader checks that every line is read back, unchanged when it fits and still valid JSON when it was
+func FuzzLineReader(f *testing.F) {
+	seed, err := os.ReadFile("testdata/audit.log")
+	require.NoError(f, err)
+	first, _, _ := bytes.Cut(seed, []byte("\n"))
+	f.Add(first, 64)
+	f.Add([]byte("{\"a\":\"\\u00e9\\n\"}\r\n[1,{\"b\":[]}]\n"), 8)
+	f.Add([]byte(`{"a":"`+strings.Repeat("x", 300)+`","b":{"c":[true,null]}}`), 16)
+
+	f.Fuzz(func(t *testing.T, input []byte, max int) {
+		max = 8 + abs(max%512)
+		lines, truncated := readLines(t, string(input), max)
+
+		want := strings.SplitAfter(string(input), "\n")
+		if want[len(want)-1] == "" {
+			want = want[:len(want)-1]
+		}
+		require.Len(t, lines, len(want))
+		for i, line := range lines {
+			original := string(trimLineEnding([]byte(want[i])))
+			if !truncated[i] {
+				assert.Equal(t, original, line)
+				continue
+			}
+			assert.Greater(t, len(want[i]), max, "Only lines over the maximum, with their line ending, are truncated")
+			if json.Valid([]byte(original)) {
+				assert.True(t, json.Valid([]byte(line)), "%q truncated into %q", original, line)
+			}
+		}
+	})
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -1,12 +1,32 @@
 package audit
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/corazawaf/coraza/v3/types"
 )
 
 type Log struct {
 	Transaction Transaction `json:"transaction"`
 	Messages    []Message   `json:"messages,omitempty"`
+	// Aggregation is set when the log represents a group of identical violations
+	Aggregation *Aggregation `json:"aggregation,omitempty"`
+	// Truncated is set when the audit log line was over the maximum size, so its strings were shortened
+	Truncated bool `json:"truncated,omitempty"`
+	// ClientHostname is the reverse DNS name of the client IP, set when the processor resolves clients
+	ClientHostname string `json:"client_hostname,omitempty"`
+	// VerifiedCrawler names the crawler the client IP was verified as, e.g. googlebot
+	VerifiedCrawler string `json:"verified_crawler,omitempty"`
+}
+
+type Aggregation struct {
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// received is when the group was started, which the window is measured from. The times seen are event times,
+	// which can be long past when processing is behind.
+	received time.Time
 }
 
 type Message struct {
@@ -29,18 +49,58 @@ type MessageData struct {
 	Raw      string             `json:"raw"`
 }
 
+// StatusClientClosedRequest is recorded as the response status of transactions whose forward-auth call was
+// cancelled before the WAF answered
+const StatusClientClosedRequest = 499
+
+// ClientCancelled reports whether Traefik gave up on the request before the WAF answered
+func (l Log) ClientCancelled() bool {
+	return l.Transaction.Response != nil && l.Transaction.Response.Status == StatusClientClosedRequest
+}
+
 type Transaction struct {
-	// Timestamp "02/Jan/2006:15:04:20 -0700" format
-	Timestamp     string               `json:"timestamp"`
-	UnixTimestamp int64                `json:"unix_timestamp"`
-	ID            string               `json:"id"`
-	ClientIP      string               `json:"client_ip"`
-	ClientPort    int                  `json:"client_port"`
-	HostIP        string               `json:"host_ip"`
-	HostPort      int                  `json:"host_port"`
-	ServerID      string               `json:"server_id"`
-	Request       *TransactionRequest  `json:"request,omitempty"`
-	Response      *TransactionResponse `json:"response,omitempty"`
+	// Timestamp is "2006/01/02 15:04:05" in the local time of the WAF, without a zone. Use Time instead.
+	Timestamp string `json:"timestamp"`
+	// UnixTimestamp is in nanoseconds. Use Time instead.
+	UnixTimestamp int64 `json:"unix_timestamp"`
+	// Time is when the transaction started, parsed from the timestamps. It is zero when the log has none.
+	Time       time.Time            `json:"-"`
+	ID         string               `json:"id"`
+	ClientIP   string               `json:"client_ip"`
+	ClientPort int                  `json:"client_port"`
+	HostIP     string               `json:"host_ip"`
+	HostPort   int                  `json:"host_port"`
+	ServerID   string               `json:"server_id"`
+	Request    *TransactionRequest  `json:"request,omitempty"`
+	Response   *TransactionResponse `json:"response,omitempty"`
+}
+
+// timestampLayouts are the layouts of Timestamp tried when there is no UnixTimestamp: Coraza's, ModSecurity's and
+// RFC 3339
+var timestampLayouts = []string{"2006/01/02 15:04:05", "02/Jan/2006:15:04:05 -0700", time.RFC3339Nano}
+
+// UnmarshalJSON parses the timestamps into Time
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type transaction Transaction
+	if err := json.Unmarshal(data, (*transaction)(t)); err != nil {
+		return err
+	}
+	t.Time = t.parseTime()
+	return nil
+}
+
+// parseTime returns the time of the transaction, preferring UnixTimestamp as it does not depend on the time zone.
+// A Timestamp without a zone is in the local time of the process, like the WAF writing it.
+func (t Transaction) parseTime() time.Time {
+	if t.UnixTimestamp != 0 {
+		return time.Unix(0, t.UnixTimestamp)
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.ParseInLocation(layout, t.Timestamp, time.Local); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
 }
 
 type TransactionRequest struct {
@@ -2,19 +2,25 @@ package audit
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
-	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/pool"
 	"github.com/corazawaf/coraza/v3"
 )
 
@@ -25,61 +31,242 @@ import (
 type LogProcessor struct {
 	auditLogDir  string
 	auditLogFile string
-	logger       *slog.Logger
-	logHandler   func(log Log) error
+	// memory holds the audit log when it is kept in memory, nil when it is kept in a file
+	memory *memoryLog
+	// async queues the audit log lines written in the background, nil when Coraza writes the file itself
+	async *asyncLog
+	// source is where audit logs are read from: the audit log file, the in-memory log or a source of the caller
+	source     Source
+	clock      Clock
+	fs         FS
+	logger     *slog.Logger
+	logHandler func(log Log) error
+	sinks      []Sink
+	signer     *backupSigner
+	encrypter  *backupEncrypter
 
 	processingDone chan struct{}
 	expirationDone chan struct{}
+	diskGuardDone  chan struct{}
 	stopSignal     chan struct{}
+	// rotateNow makes the processing job rotate the audit log before its next run
+	rotateNow chan struct{}
+
+	diskGuard DiskGuardOptions
+	diskSpace func(dir string) (free uint64, total uint64, err error)
+	pressure  atomic.Bool
+	leader    func() bool
+
+	// replicas recognizes the audit logs of the other replicas, nil when the audit log is not named after an instance
+	replicas    *replicaNames
+	orphanAfter time.Duration
+	// resolveClient enriches the logs sent to the sinks, nil when clients are not resolved
+	resolveClient func(ip string) (string, string)
 
 	ProcessingJobInterval time.Duration
 	ExpirationJobInterval time.Duration
 	LogExpiration         time.Duration
 	Lock                  *sync.Mutex
+
+	watch        bool
+	watchDelay   time.Duration
+	chunkLines   int
+	chunkPause   time.Duration
+	maxReadRate  int64
+	maxLineBytes int
 }
 
 type AuditLogProcessorOptions struct {
-	AuditLogPath          string
+	AuditLogPath string
+	// Storage is where the audit log is kept: StorageAuto (the default), StorageFile or StorageMemory
+	Storage string
+	// DirMode is the permissions of the audit log directory when it is created. Zero uses DefaultDirMode.
+	DirMode os.FileMode
+	// DirOwner is the "user[:group]" owning the audit log directory when it is created. Empty keeps the process owner.
+	DirOwner              string
 	ProcessingJobInterval time.Duration
 	ExpirationJobInterval time.Duration
 	LogExpiration         time.Duration
+	// Sinks receive every log containing rule violations. Defaults to a LogSink writing to the default logger.
+	Sinks []Sink
+	// SigningKey signs every rotated backup into a hash chain that VerifyBackups can check. Empty disables signing.
+	SigningKey []byte
+	// Watch processes the audit log as soon as it is written to, instead of waiting for the next processing run.
+	// Processing still runs every ProcessingJobInterval, which is all there is when the log cannot be watched.
+	Watch bool
+	// WatchDelay is how long to wait after a write before processing, so a burst of transactions is processed at once
+	WatchDelay time.Duration
+	// ChunkLines is the number of lines processed between yield points. Zero uses DefaultChunkLines.
+	ChunkLines int
+	// ChunkPause is how long to pause between chunks
+	ChunkPause time.Duration
+	// MaxReadRate limits how many bytes per second are processed. Zero is unlimited.
+	MaxReadRate int64
+	// MaxLineBytes is the size above which an audit log line is truncated before processing. Zero uses
+	// DefaultMaxLineBytes.
+	MaxLineBytes int
+	// EncryptionKey is an AES-128, AES-192 or AES-256 key that backups are encrypted with once processed. Empty
+	// leaves backups in plaintext.
+	EncryptionKey []byte
+	// AsyncBufferLines queues up to this many audit log lines in memory, written to the file by a background
+	// goroutine so a slow disk does not delay requests. The oldest lines are dropped when the queue is full. Zero
+	// writes every line before the request completes.
+	AsyncBufferLines int
+	// DiskGuard rotates and compresses audit logs early when the audit log directory runs out of disk space
+	DiskGuard DiskGuardOptions
+	// Leader reports whether this replica expires the backups and sink data, which replicas sharing a volume do once.
+	// Nil expires them on every replica.
+	Leader func() bool
+	// Instance names the audit log and backups of this replica after it, see InstancePath, so replicas sharing a
+	// volume never rotate each other's audit log. Empty uses AuditLogPath as it is.
+	Instance string
+	// OrphanAfter is how long the audit log of another replica holds unrotated lines before this replica adopts and
+	// processes it, as the replica is gone. Zero never adopts them. Only used with an Instance.
+	OrphanAfter time.Duration
+	// ResolveClient returns the reverse DNS name of a client IP and the crawler it was verified as, added to the logs
+	// sent to the sinks. Nil leaves the logs without them.
+	ResolveClient func(ip string) (hostname string, crawler string)
 }
 
-func NewLogProcessor(options AuditLogProcessorOptions) *LogProcessor {
+// NewLogProcessor returns a processor of the audit log at the path of the options, which it rotates into backups
+func NewLogProcessor(options AuditLogProcessorOptions, opts ...Option) *LogProcessor {
+	location, err := ResolveStorage(options)
+	if err != nil {
+		slog.Error("Failed to prepare audit log storage", "error", err, "path", options.AuditLogPath)
+		location = Location{Path: options.AuditLogPath}
+	}
+	if location.Path != InstancePath(options.AuditLogPath, options.Instance) || location.Memory && options.Storage != StorageMemory {
+		slog.Warn("Audit log path is not writable, using fallback", "path", options.AuditLogPath, "fallback", location.String())
+	}
+
 	processor := &LogProcessor{
-		auditLogDir:  path.Dir(options.AuditLogPath),
-		auditLogFile: path.Base(options.AuditLogPath),
+		auditLogDir:  filepath.Dir(location.Path),
+		auditLogFile: filepath.Base(location.Path),
+		clock:        systemClock{},
+		fs:           OSFS{},
 		logger:       slog.Default(),
+		sinks:        options.Sinks,
 
 		stopSignal: make(chan struct{}),
+		rotateNow:  make(chan struct{}, 1),
 
 		ProcessingJobInterval: options.ProcessingJobInterval,
 		ExpirationJobInterval: options.ExpirationJobInterval,
 		LogExpiration:         options.LogExpiration,
 		Lock:                  &sync.Mutex{},
+
+		watch:        options.Watch,
+		watchDelay:   options.WatchDelay,
+		chunkLines:   options.ChunkLines,
+		chunkPause:   options.ChunkPause,
+		maxReadRate:  options.MaxReadRate,
+		maxLineBytes: options.MaxLineBytes,
+
+		diskGuard: options.DiskGuard,
+		diskSpace: diskSpace,
+		leader:    options.Leader,
+
+		resolveClient: options.ResolveClient,
+	}
+	if options.Instance != "" && !location.Memory {
+		processor.replicas = newReplicaNames(filepath.Base(options.AuditLogPath))
+		processor.orphanAfter = options.OrphanAfter
+	}
+
+	if location.Memory {
+		processor.memory = openMemoryLog(location.Path)
+		processor.source = &memorySource{p: processor, log: processor.memory}
+	} else {
+		processor.source = &fileSource{p: processor}
+		if options.AsyncBufferLines > 0 {
+			processor.async = openAsyncLog(location.Path, options.AsyncBufferLines)
+		}
+	}
+	processor.apply(opts)
+
+	if len(options.SigningKey) > 0 && processor.keepsBackups() {
+		backups, err := processor.backupFiles()
+		if err != nil {
+			processor.logger.Warn("Failed to find signed audit log backups, starting a new chain", "error", err)
+		}
+		processor.signer = newBackupSigner(processor.fs, options.SigningKey, backups)
+	}
+	if len(options.EncryptionKey) > 0 && processor.keepsBackups() {
+		encrypter, err := newBackupEncrypter(processor.fs, options.EncryptionKey)
+		if err != nil {
+			processor.logger.Error("Failed to set up audit log backup encryption, backups stay in plaintext", "error", err)
+		}
+		processor.encrypter = encrypter
 	}
+	return processor
+}
+
+// NewProcessor returns a processor of the audit logs of any source, for Coraza deployments other than this
+// middleware. There are no backups to rotate, sign, encrypt or expire; the expiration job only expires sink data.
+func NewProcessor(source Source, opts ...Option) *LogProcessor {
+	processor := &LogProcessor{
+		source: source,
+		clock:  systemClock{},
+		fs:     OSFS{},
+		logger: slog.Default(),
+
+		stopSignal: make(chan struct{}),
+		rotateNow:  make(chan struct{}, 1),
+
+		ProcessingJobInterval: 10 * time.Second,
+		ExpirationJobInterval: time.Hour,
+		Lock:                  &sync.Mutex{},
 
-	processor.logHandler = processor.defaultLogHandler
+		watch: true,
+	}
+	processor.apply(opts)
 	return processor
 }
 
+// apply applies the options and the defaults of what they left unset
+func (p *LogProcessor) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.maxLineBytes <= 0 {
+		p.maxLineBytes = DefaultMaxLineBytes
+	}
+	if len(p.sinks) == 0 {
+		p.sinks = []Sink{NewLogSink(p.logger)}
+	}
+	p.logHandler = p.defaultLogHandler
+}
+
+// keepsBackups reports whether the audit log is a file rotated into backups
+func (p *LogProcessor) keepsBackups() bool {
+	_, ok := p.source.(*fileSource)
+	return ok
+}
+
+// CheckWritable reports whether audit logs can still be written, so a directory removed or remounted read-only
+// after startup does not go unnoticed
+func (p *LogProcessor) CheckWritable() error {
+	if !p.keepsBackups() {
+		return nil
+	}
+	return checkWritable(p.auditLogDir)
+}
+
 // SetAuditLogDirectives configures the WAF to use the audit log settings required for processing
 func (p *LogProcessor) SetAuditLogDirectives(cfg coraza.WAFConfig) coraza.WAFConfig {
-	auditLogDirectives := fmt.Sprintf(`
-	  SecAuditLog %s
-		SecAuditLogParts AFHKZ
-		SecAuditLogFormat JSON
-		SecAuditLogType Serial
-		SecAuditEngine On`, path.Join(p.auditLogDir, p.auditLogFile))
-
-	return cfg.WithDirectives(auditLogDirectives)
+	directives := p.source.Directives()
+	if directives == "" {
+		return cfg
+	}
+	return cfg.WithDirectives(directives)
 }
 
 // StartProcessingJob begins the log processing loop
 func (p *LogProcessor) StartProcessingJob() {
-	p.logger.Info("Starting audit log processing job", "interval", p.ProcessingJobInterval.String())
+	changes := p.watchChanges()
+	p.logger.Info("Starting audit log processing job", "interval", p.ProcessingJobInterval.String(), "watch", changes != nil)
 
-	ticker := time.NewTicker(p.ProcessingJobInterval)
+	ticker := p.clock.NewTicker(p.ProcessingJobInterval)
 	defer ticker.Stop()
 
 	p.processingDone = make(chan struct{})
@@ -89,29 +276,69 @@ func (p *LogProcessor) StartProcessingJob() {
 		select {
 		case <-p.stopSignal:
 			return
-		case <-ticker.C:
-			exist, err := p.checkIfLogsExist()
-			if err != nil {
-				p.logger.Error("Failed to check for audit logs", "error", err)
-				continue
+		case <-ticker.C():
+			p.flushSinks(false)
+		case <-p.rotateNow:
+		case <-changes:
+			metricWatchWakeups.Inc()
+			// Let the burst of writes that woke us up finish
+			select {
+			case <-p.stopSignal:
+				return
+			case <-p.clock.After(p.watchDelay):
 			}
+		}
+		p.processPending()
+		if p.replicas != nil && p.orphanAfter > 0 {
+			p.adoptOrphans()
+		}
+	}
+}
 
-			if !exist {
-				continue
-			}
+// watchChanges returns a channel receiving a value when the audit log is written to, or nil when the processor
+// does not watch the log and only polls
+func (p *LogProcessor) watchChanges() <-chan struct{} {
+	notifier, ok := p.source.(Notifier)
+	if !p.watch || !ok {
+		return nil
+	}
+	return notifier.Changes(p.stopSignal)
+}
 
-			p.logger.Info("Detected audit log data, starting processing")
+// processPending processes the audit log written since the last run, if any
+func (p *LogProcessor) processPending() {
+	r, size, err := p.source.Read()
+	if err != nil {
+		p.logger.Error("Failed to read audit log", "error", err)
+		return
+	}
+	if r == nil {
+		return
+	}
+	defer r.Close()
 
-			filename, err := p.rotateLogs()
-			if err != nil {
-				p.logger.Error("Failed to rotate audit log", "error", err)
-				continue
-			}
+	p.logger.Info("Detected audit log data, starting processing")
+	if err := p.processLogs(r, size); err != nil {
+		p.logger.Error("Failed to process audit log", "error", err)
+	}
+}
 
-			if err = p.ProcessLogFile(filename); err != nil {
-				p.logger.Error("Failed to process audit log file", "error", err, "file", filename)
-				continue
-			}
+// sealBackup compresses under disk pressure, encrypts and then signs a processed backup, so the signature covers the
+// file as it sits on disk
+func (p *LogProcessor) sealBackup(filename string) {
+	if p.pressure.Load() {
+		if err := compressBackup(p.fs, filename); err != nil {
+			p.logger.Error("Failed to compress audit log backup", "error", err, "file", filename)
+		}
+	}
+	if p.encrypter != nil {
+		if err := p.encrypter.encrypt(filename); err != nil {
+			p.logger.Error("Failed to encrypt audit log backup", "error", err, "file", filename)
+		}
+	}
+	if p.signer != nil {
+		if err := p.signer.sign(filename); err != nil {
+			p.logger.Error("Failed to sign audit log backup", "error", err, "file", filename)
 		}
 	}
 }
@@ -120,7 +347,7 @@ func (p *LogProcessor) StartProcessingJob() {
 func (p *LogProcessor) StartExpirationJob() {
 	p.logger.Info("Starting audit log expiration job", "interval", p.ExpirationJobInterval.String(), "expiration", p.LogExpiration.String())
 
-	ticker := time.NewTicker(p.ExpirationJobInterval)
+	ticker := p.clock.NewTicker(p.ExpirationJobInterval)
 	defer ticker.Stop()
 
 	p.expirationDone = make(chan struct{})
@@ -130,10 +357,14 @@ func (p *LogProcessor) StartExpirationJob() {
 		select {
 		case <-p.stopSignal:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
+			if p.leader != nil && !p.leader() {
+				continue
+			}
 			if err := p.expireBackupLogFiles(); err != nil {
 				p.logger.Error("Failed to expire backup log files", "error", err)
 			}
+			p.expireSinks(p.clock.Now())
 		}
 	}
 }
@@ -152,6 +383,9 @@ func (p *LogProcessor) Stop(ctx context.Context) error {
 		if p.expirationDone != nil {
 			<-p.expirationDone
 		}
+		if p.diskGuardDone != nil {
+			<-p.diskGuardDone
+		}
 		close(jobsDone)
 	}()
 
@@ -159,33 +393,114 @@ func (p *LogProcessor) Stop(ctx context.Context) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-jobsDone:
+		if p.async != nil {
+			if err := p.async.flush(); err != nil {
+				p.logger.Error("Failed to write queued audit log lines", "error", err)
+			}
+		}
+		p.flushSinks(true)
 		p.logger.Info("Audit log processor stopped gracefully")
 		return nil
 	}
 }
 
+// expireSinks deletes the expired data kept by the sinks
+func (p *LogProcessor) expireSinks(now time.Time) {
+	for _, sink := range p.sinks {
+		if expirer, ok := sink.(Expirer); ok {
+			if err := expirer.Expire(now); err != nil {
+				p.logger.Error("Failed to expire audit log sink data", "sink", sink.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// flushSinks emits any logs buffered by the sinks
+func (p *LogProcessor) flushSinks(force bool) {
+	for _, sink := range p.sinks {
+		if err := sink.Flush(force); err != nil {
+			p.logger.Error("Failed to flush audit log sink", "sink", sink.Name(), "error", err)
+		}
+	}
+}
+
+// scanBuffers are reused by every processing run to read audit log lines
+var scanBuffers = pool.New("audit_scan",
+	func() *[]byte { buf := make([]byte, 0, 64*1024); return &buf },
+	func(buf *[]byte) { *buf = (*buf)[:0] },
+)
+
 func (p *LogProcessor) ProcessLogFile(filename string) error {
 	p.logger.Info("Processing audit log file", "file", filename)
 
-	file, err := os.Open(filename)
+	file, err := p.fs.Open(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open log file: %w", err)
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	var r io.Reader = bufio.NewReader(file)
+	if start, _ := r.(*bufio.Reader).Peek(len(gzipMagic)); bytes.Equal(start, gzipMagic) {
+		// A backup compressed under disk pressure
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to read compressed log file: %w", err)
+		}
+		r, size = zr, 0
+	}
+	if err := p.processLogs(r, size); err != nil {
+		return err
+	}
+
+	p.logger.Info("Completed processing audit log file", "file", filename)
+	return nil
+}
+
+// processLogs handles every audit log line read from r, in chunks. size is the number of bytes expected, used to
+// report progress.
+func (p *LogProcessor) processLogs(r io.Reader, size int64) error {
+	chunks, r := p.newChunker(r, size)
+	defer chunks.done()
+
+	buf := scanBuffers.Get()
+	defer scanBuffers.Put(buf)
+	lines := newLineReader(r, p.maxLineBytes, *buf)
+	defer func() {
+		// Keep the grown buffer for the next run, unless an oversized line made it huge
+		if cap(lines.buf) <= 1<<20 {
+			*buf = lines.buf
+		}
+	}()
 	processingErrors := false
 
-	for scanner.Scan() {
+	for {
+		line, truncated, err := lines.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+		chunks.line()
 		var logEntry Log
-		line := scanner.Text()
-		p.logger.Debug("Processing audit log entry", "line", line)
+		if p.logger.Enabled(context.Background(), slog.LevelDebug) {
+			p.logger.Debug("Processing audit log entry", "line", string(line))
+		}
+		if truncated {
+			metricOversizedLines.Inc()
+			p.logger.Warn("Audit log entry is over the maximum line size, processing it truncated", "max_bytes", p.maxLineBytes)
+		}
 
-		if err := json.Unmarshal([]byte(line), &logEntry); err != nil {
-			p.logger.Warn("Failed to parse log entry, skipping", "error", err, "line", line)
+		if err := json.Unmarshal(line, &logEntry); err != nil {
+			p.logger.Warn("Failed to parse log entry, skipping", "error", err, "line", string(line))
 			processingErrors = true
 			continue
 		}
+		logEntry.Truncated = truncated
 
 		if err := p.logHandler(logEntry); err != nil {
 			p.logger.Warn("Failed to process log entry", "error", err)
@@ -196,30 +511,30 @@ func (p *LogProcessor) ProcessLogFile(filename string) error {
 	if processingErrors {
 		return errors.New("errors occurred during log processing")
 	}
-
-	if err = scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read log file: %w", err)
-	}
-
-	p.logger.Info("Completed processing audit log file", "file", filename)
 	return nil
 }
 
 func (p *LogProcessor) rotateLogs() (filename string, err error) {
-	logPath := path.Join(p.auditLogDir, p.auditLogFile)
+	logPath := filepath.Join(p.auditLogDir, p.auditLogFile)
 
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
+	if p.async != nil {
+		// Requests hold the lock, so the queue stays empty until the log has been rotated
+		if err := p.async.flush(); err != nil {
+			return "", fmt.Errorf("failed to write queued audit log lines: %w", err)
+		}
+	}
 
 	// Open for reading to copy content
-	auditLog, err := os.Open(logPath)
+	auditLog, err := p.fs.Open(logPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open audit log: %w", err)
 	}
 	defer auditLog.Close()
 
-	copyName := p.generateNewBackupFilename(time.Now())
-	copyFile, err := os.Create(copyName)
+	copyName := p.generateNewBackupFilename(p.clock.Now())
+	copyFile, err := p.fs.Create(copyName)
 	if err != nil {
 		return "", fmt.Errorf("failed to create copy of audit log: %w", err)
 	}
@@ -231,7 +546,7 @@ func (p *LogProcessor) rotateLogs() (filename string, err error) {
 
 	auditLog.Close()
 
-	if err := os.Truncate(logPath, 0); err != nil {
+	if err := p.fs.Truncate(logPath, 0); err != nil {
 		return "", fmt.Errorf("failed to truncate audit log: %w", err)
 	}
 
@@ -239,46 +554,106 @@ func (p *LogProcessor) rotateLogs() (filename string, err error) {
 }
 
 func (p *LogProcessor) expireBackupLogFiles() error {
+	if !p.keepsBackups() {
+		return nil
+	}
 	p.logger.Info("Checking for expired audit log files to delete", "expiration", p.LogExpiration.String())
 
-	files, err := os.ReadDir(p.auditLogDir)
+	files, err := p.fs.ReadDir(p.auditLogDir)
 	if err != nil {
 		return fmt.Errorf("failed to read audit log directory: %w", err)
 	}
 
-	now := time.Now()
+	now := p.clock.Now()
 	for _, file := range files {
 		if file.IsDir() || !file.Type().IsRegular() {
 			continue
 		}
 
-		if !p.isBackupFile(file.Name()) {
-			continue
-		}
-
-		timestamp, err := p.parseTimestampFromBackupFilename(file.Name())
-		if err != nil {
-			p.logger.Warn("Failed to parse timestamp from backup log filename, skipping", "file", file.Name(), "error", err)
+		timestamp, ok := p.backupTimestamp(file.Name())
+		if !ok {
 			continue
 		}
 
 		if now.Sub(timestamp) > p.LogExpiration {
-			fullPath := path.Join(p.auditLogDir, file.Name())
-			if err := os.Remove(fullPath); err != nil {
+			fullPath := filepath.Join(p.auditLogDir, file.Name())
+			if err := p.fs.Remove(fullPath); errors.Is(err, fs.ErrNotExist) {
+				// Expired by another replica sharing the directory
+				continue
+			} else if err != nil {
 				p.logger.Warn("Failed to delete expired audit log file", "file", fullPath, "error", err)
 			} else {
 				p.logger.Info("Deleted expired audit log file", "file", fullPath)
 			}
+			if err := p.fs.Remove(fullPath + signatureSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				p.logger.Warn("Failed to delete expired audit log signature", "file", fullPath+signatureSuffix, "error", err)
+			}
 		}
 	}
 
 	return nil
 }
 
+// backupFiles returns the paths of the rotated backups, oldest first
+func (p *LogProcessor) backupFiles() ([]string, error) {
+	files, err := p.fs.ReadDir(p.auditLogDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log directory: %w", err)
+	}
+
+	type backup struct {
+		path      string
+		timestamp time.Time
+	}
+	var backups []backup
+	for _, file := range files {
+		if !file.Type().IsRegular() || !p.isBackupFile(file.Name()) {
+			continue
+		}
+		timestamp, _ := p.parseTimestampFromBackupFilename(file.Name())
+		backups = append(backups, backup{filepath.Join(p.auditLogDir, file.Name()), timestamp})
+	}
+	slices.SortFunc(backups, func(a, b backup) int { return a.timestamp.Compare(b.timestamp) })
+
+	paths := make([]string, len(backups))
+	for i, b := range backups {
+		paths[i] = b.path
+	}
+	return paths, nil
+}
+
+// DiskUsage returns the bytes the audit log and its backups, signatures included, take up in the audit log directory.
+// It is zero when the audit log is kept in memory.
+func (p *LogProcessor) DiskUsage() (int64, error) {
+	if p.memory != nil {
+		return 0, nil
+	}
+	files, err := p.fs.ReadDir(p.auditLogDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read audit log directory: %w", err)
+	}
+	var usage int64
+	for _, file := range files {
+		if !file.Type().IsRegular() || file.Name() != p.auditLogFile && !strings.HasPrefix(file.Name(), p.auditLogFile+".") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// Expired or rotated since the directory was read
+			continue
+		}
+		usage += info.Size()
+	}
+	return usage, nil
+}
+
 func (p *LogProcessor) checkIfLogsExist() (bool, error) {
-	logPath := path.Join(p.auditLogDir, p.auditLogFile)
-	info, err := os.Stat(logPath)
-	if os.IsNotExist(err) {
+	if p.memory != nil {
+		return p.memory.len() > 0, nil
+	}
+	logPath := filepath.Join(p.auditLogDir, p.auditLogFile)
+	info, err := p.fs.Stat(logPath)
+	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil
 	}
 	if err != nil {
@@ -298,42 +673,31 @@ func (p *LogProcessor) defaultLogHandler(log Log) error {
 		return nil
 	}
 
-	logFields := []any{
-		"id", log.Transaction.ID,
-		"client_ip", log.Transaction.ClientIP,
+	sendTransactionMetrics(log)
+	sendRuleViolationMetrics(log)
+	if at, ok := log.EventTime(); ok {
+		metricProcessingLag.Set(max(p.clock.Now().Sub(at).Seconds(), 0))
 	}
-
-	request := log.Transaction.Request
-	if request != nil {
-		logFields = append(logFields,
-			"method", request.Method,
-			"uri", request.URI,
-			"protocol", request.Protocol,
-		)
+	if p.resolveClient != nil && log.Transaction.ClientIP != "" {
+		log.ClientHostname, log.VerifiedCrawler = p.resolveClient(log.Transaction.ClientIP)
 	}
 
-	rules := make([]string, 0, len(log.Messages))
-	for _, msg := range log.Messages {
-		rules = append(rules,
-			"rule_id", fmt.Sprintf("%s-%d", msg.Data.File, msg.Data.ID),
-			"message", msg.Data.Msg,
-		)
+	var errs []error
+	for _, sink := range p.sinks {
+		if err := sink.Send(log); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", sink.Name(), err))
+		}
 	}
-	logFields = append(logFields, "rules", rules)
-	p.logger.Warn("Rule violations", logFields...)
-
-	sendTransactionMetrics(log)
-	sendRuleViolationMetrics(log)
-	return nil
+	return errors.Join(errs...)
 }
 
 func (p *LogProcessor) generateNewBackupFilename(timestamp time.Time) string {
 	timestampStr := strconv.FormatInt(timestamp.Unix(), 10)
-	return path.Join(p.auditLogDir, fmt.Sprintf("%s.%s", p.auditLogFile, timestampStr))
+	return filepath.Join(p.auditLogDir, fmt.Sprintf("%s.%s", p.auditLogFile, timestampStr))
 }
 
 func (p *LogProcessor) parseTimestampFromBackupFilename(filename string) (time.Time, error) {
-	base := path.Base(filename)
+	base := filepath.Base(filename)
 	timestampStr := strings.TrimPrefix(base, p.auditLogFile+".")
 	timestampInt, err := strconv.ParseInt(timestampStr, 10, 64)
 	if err != nil {
@@ -343,8 +707,22 @@ func (p *LogProcessor) parseTimestampFromBackupFilename(filename string) (time.T
 	return time.Unix(timestampInt, 0), nil
 }
 
+// backupTimestamp returns when the backup was rotated. Backups of the other replicas are included, so the backups of
+// replicas that are gone expire too.
+func (p *LogProcessor) backupTimestamp(filename string) (time.Time, bool) {
+	if p.isBackupFile(filename) {
+		timestamp, _ := p.parseTimestampFromBackupFilename(filename)
+		return timestamp, true
+	}
+	if p.replicas == nil {
+		return time.Time{}, false
+	}
+	_, timestamp, ok := p.replicas.backup(filepath.Base(filename))
+	return timestamp, ok
+}
+
 func (p *LogProcessor) isBackupFile(filename string) bool {
-	base := path.Base(filename)
+	base := filepath.Base(filename)
 	if !strings.HasPrefix(base, p.auditLogFile+".") {
 		return false
 	}
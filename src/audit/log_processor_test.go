@@ -2,50 +2,60 @@ package audit
 
 import (
 	"context"
+	"log/slog"
 	"os"
-	"path"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLogProcessor(t *testing.T) {
-	tempDir := t.TempDir()
-	logFile := path.Join(tempDir, "audit.log")
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	fsys := newMemFS()
+	clock := newFakeClock(time.Unix(1700000000, 0))
 
+	var mu sync.Mutex
 	logs := make([]Log, 0)
 	handler := func(l Log) error {
+		mu.Lock()
+		defer mu.Unlock()
 		logs = append(logs, l)
 		return nil
 	}
 
 	processor := NewLogProcessor(AuditLogProcessorOptions{
 		AuditLogPath:          logFile,
+		Storage:               StorageFile,
 		ProcessingJobInterval: time.Second,
-	})
+	}, WithFS(fsys), WithClock(clock))
 	processor.logHandler = handler
 
-	// Copy testdata/audit.log to the temp directory
 	data, err := os.ReadFile("testdata/audit.log")
-	assert.NoError(t, err)
-	err = os.WriteFile(logFile, data, 0644)
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	require.NoError(t, fsys.WriteFile(logFile, data, 0644))
 
 	go processor.StartProcessingJob()
+	clock.waitForTickers(t, 1)
+	clock.Advance(time.Second)
 
-	time.Sleep(2 * time.Second) // Give it time to process
-
-	err = processor.Stop(context.Background())
-	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(logs) == 4
+	}, 2*time.Second, time.Millisecond, "Expected four logs to be processed")
+	require.NoError(t, processor.Stop(context.Background()))
 
-	assert.Len(t, logs, 4, "Expected four logs to be processed")
 	assert.Equal(t, "EcNxIrskXYJttXoioLH", logs[0].Transaction.ID)
+	assert.True(t, fsys.exists(logFile+".1700000001"), "Expected the log to be rotated into a backup named by the clock")
 }
 
 func TestRotateAuditLogs(t *testing.T) {
 	tempDir := t.TempDir()
-	logFile := path.Join(tempDir, "audit.log")
+	logFile := filepath.Join(tempDir, "audit.log")
 
 	processor := NewLogProcessor(AuditLogProcessorOptions{
 		AuditLogPath: logFile,
@@ -57,7 +67,7 @@ func TestRotateAuditLogs(t *testing.T) {
 	assert.False(t, exist, "Expected no audit logs initially")
 
 	// Create a dummy audit log file to simulate existing logs
-	logPath := path.Join(tempDir, "audit.log")
+	logPath := filepath.Join(tempDir, "audit.log")
 	err = os.WriteFile(logPath, []byte("dummy log content"), 0644)
 	assert.NoError(t, err)
 
@@ -81,9 +91,19 @@ func TestRotateAuditLogs(t *testing.T) {
 	assert.Equal(t, int64(0), info.Size(), "Expected original log file to be truncated")
 }
 
+func TestRotateAuditLogsWithClock(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("dummy log content"), 0644))
+
+	processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile}, WithClock(newFakeClock(time.Unix(1700000000, 0))))
+	rotatedLogPath, err := processor.rotateLogs()
+	require.NoError(t, err)
+	assert.Equal(t, logFile+".1700000000", rotatedLogPath)
+}
+
 func TestRotateAuditLogsConcurrently(t *testing.T) {
 	tempDir := t.TempDir()
-	logFile := path.Join(tempDir, "audit.log")
+	logFile := filepath.Join(tempDir, "audit.log")
 
 	// Create a dummy audit log file to simulate existing logs
 	err := os.WriteFile(logFile, []byte("dummy log content"), 0644)
@@ -115,35 +135,130 @@ func TestRotateAuditLogsConcurrently(t *testing.T) {
 }
 
 func TestLogExpiration(t *testing.T) {
-	tempDir := t.TempDir()
-	logFile := path.Join(tempDir, "audit.log")
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	fsys := newMemFS()
+	clock := newFakeClock(time.Unix(1700000000, 0))
 
 	processor := NewLogProcessor(AuditLogProcessorOptions{
 		AuditLogPath:          logFile,
+		Storage:               StorageFile,
 		ExpirationJobInterval: time.Second,
 		LogExpiration:         time.Minute,
-	})
+	}, WithFS(fsys), WithClock(clock))
 
-	// Create dummy log files with different modification times
-	oldBackupFilename := processor.generateNewBackupFilename(time.Now().Add(-1 * time.Hour))
-	recentBackupFilename := processor.generateNewBackupFilename(time.Now())
-	err := os.WriteFile(oldBackupFilename, []byte("old log content"), 0644)
-	assert.NoError(t, err)
-	err = os.WriteFile(recentBackupFilename, []byte("recent log content"), 0644)
-	assert.NoError(t, err)
+	// Create backups of different ages, with the signature of the old one
+	oldBackupFilename := processor.generateNewBackupFilename(clock.Now().Add(-1 * time.Hour))
+	recentBackupFilename := processor.generateNewBackupFilename(clock.Now())
+	require.NoError(t, fsys.WriteFile(oldBackupFilename, []byte("old log content"), 0644))
+	require.NoError(t, fsys.WriteFile(oldBackupFilename+signatureSuffix, []byte("{}"), 0644))
+	require.NoError(t, fsys.WriteFile(recentBackupFilename, []byte("recent log content"), 0644))
 
 	go processor.StartExpirationJob()
+	clock.waitForTickers(t, 1)
+	clock.Advance(time.Second)
 
-	time.Sleep(2 * time.Second) // Give it time to expire old logs
+	assert.Eventually(t, func() bool {
+		return !fsys.exists(oldBackupFilename)
+	}, 2*time.Second, time.Millisecond, "Expected old log file to be deleted")
+	require.NoError(t, processor.Stop(context.Background()))
 
-	err = processor.Stop(context.Background())
-	assert.NoError(t, err)
+	assert.False(t, fsys.exists(oldBackupFilename+signatureSuffix), "Expected old signature to be deleted")
+	assert.True(t, fsys.exists(recentBackupFilename), "Expected recent log file to still exist")
+
+	// The recent backup expires once the clock has moved past the expiration
+	clock.Advance(2 * time.Minute)
+	require.NoError(t, processor.expireBackupLogFiles())
+	assert.False(t, fsys.exists(recentBackupFilename))
+}
+
+func TestLogExpirationFollower(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	fsys := newMemFS()
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	asked := make(chan struct{}, 1)
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath:          logFile,
+		Storage:               StorageFile,
+		ExpirationJobInterval: time.Second,
+		LogExpiration:         time.Minute,
+		Leader: func() bool {
+			asked <- struct{}{}
+			return false
+		},
+	}, WithFS(fsys), WithClock(clock))
+	oldBackupFilename := processor.generateNewBackupFilename(clock.Now().Add(-1 * time.Hour))
+	require.NoError(t, fsys.WriteFile(oldBackupFilename, []byte("old log content"), 0644))
+
+	go processor.StartExpirationJob()
+	clock.waitForTickers(t, 1)
+	clock.Advance(time.Second)
+	<-asked
+	require.NoError(t, processor.Stop(context.Background()))
+
+	assert.True(t, fsys.exists(oldBackupFilename), "Expected only the leader to expire backups")
+}
+
+func TestDiskUsage(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	fsys := newMemFS()
+	processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageFile}, WithFS(fsys))
 
-	// Verify that the old log file has been deleted
-	_, err = os.Stat(oldBackupFilename)
-	assert.True(t, os.IsNotExist(err), "Expected old log file to be deleted")
+	require.NoError(t, fsys.WriteFile(logFile, []byte("current"), 0644))
+	require.NoError(t, fsys.WriteFile(logFile+".1700000000", []byte("backup"), 0644))
+	require.NoError(t, fsys.WriteFile(logFile+".1700000000"+signatureSuffix, []byte("{}"), 0644))
+	require.NoError(t, fsys.WriteFile(filepath.Join(filepath.Dir(logFile), "other.log"), []byte("not ours"), 0644))
+
+	usage, err := processor.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("current")+len("backup")+len("{}")), usage)
+
+	memory := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageMemory})
+	usage, err = memory.DiskUsage()
+	require.NoError(t, err)
+	assert.Zero(t, usage)
+}
+
+// expiringSink records when it was asked to expire its data
+type expiringSink struct {
+	LogSink
+	expired []time.Time
+}
+
+func (s *expiringSink) Expire(now time.Time) error {
+	s.expired = append(s.expired, now)
+	return nil
+}
+
+func TestExpireSinks(t *testing.T) {
+	sink := &expiringSink{}
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+		Sinks:        []Sink{NewLogSink(slog.Default()), sink},
+	})
+
+	now := time.Now()
+	processor.expireSinks(now)
+	assert.Equal(t, []time.Time{now}, sink.expired)
+}
+
+func TestResolveClient(t *testing.T) {
+	sink := &recordingSink{}
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+		Sinks:        []Sink{sink},
+		ResolveClient: func(ip string) (string, string) {
+			if ip == "66.249.66.1" {
+				return "crawl-66-249-66-1.googlebot.com", "googlebot"
+			}
+			return "", ""
+		},
+	})
 
-	// Verify that the recent log file still exists
-	_, err = os.Stat(recentBackupFilename)
-	assert.NoError(t, err, "Expected recent log file to still exist")
+	require.NoError(t, processor.defaultLogHandler(violation("66.249.66.1", "/", 942100)))
+	require.NoError(t, processor.defaultLogHandler(violation("192.0.2.1", "/", 942100)))
+	require.Len(t, sink.logs, 2)
+	assert.Equal(t, "crawl-66-249-66-1.googlebot.com", sink.logs[0].ClientHostname)
+	assert.Equal(t, "googlebot", sink.logs[0].VerifiedCrawler)
+	assert.Equal(t, "googlebot", NewEvent(sink.logs[0]).VerifiedCrawler)
+	assert.Empty(t, sink.logs[1].ClientHostname)
 }
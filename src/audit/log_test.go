@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected time.Time
+	}{
+		{
+			name:     "unix timestamp",
+			json:     `{"timestamp":"2023/11/14 22:13:20","unix_timestamp":1700000000123456789}`,
+			expected: time.Unix(0, 1700000000123456789),
+		},
+		{
+			name:     "local timestamp",
+			json:     `{"timestamp":"2023/11/14 22:13:20"}`,
+			expected: time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local),
+		},
+		{
+			name:     "timestamp with a zone",
+			json:     `{"timestamp":"14/Nov/2023:22:13:20 +0100"}`,
+			expected: time.Date(2023, 11, 14, 21, 13, 20, 0, time.UTC),
+		},
+		{
+			name:     "RFC 3339 timestamp",
+			json:     `{"timestamp":"2023-11-14T22:13:20.5Z"}`,
+			expected: time.Date(2023, 11, 14, 22, 13, 20, 500000000, time.UTC),
+		},
+		{
+			name: "no timestamp",
+			json: `{"id":"abc"}`,
+		},
+		{
+			name: "invalid timestamp",
+			json: `{"timestamp":"yesterday"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transaction Transaction
+			require.NoError(t, json.Unmarshal([]byte(tt.json), &transaction))
+			assert.True(t, tt.expected.Equal(transaction.Time), "expected %s, got %s", tt.expected, transaction.Time)
+		})
+	}
+}
+
+func TestLogTime(t *testing.T) {
+	at := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	log := Log{Transaction: Transaction{Time: at}}
+	eventTime, ok := log.EventTime()
+	assert.True(t, ok)
+	assert.Equal(t, at, eventTime)
+	assert.Equal(t, at, log.Time())
+
+	_, ok = Log{}.EventTime()
+	assert.False(t, ok)
+	assert.WithinDuration(t, time.Now(), Log{}.Time(), time.Minute)
+}
+
+// FuzzLogUnmarshal checks that any audit log line the WAF could have written, including the attacker-controlled
+// request it records, is decoded or rejected without a panic, and that a decoded log gives an event
+func FuzzLogUnmarshal(f *testing.F) {
+	seed, err := os.ReadFile("testdata/audit.log")
+	require.NoError(f, err)
+	for _, line := range bytes.Split(seed, []byte("\n")) {
+		f.Add(line)
+	}
+	f.Add([]byte(`{"transaction":{"timestamp":"02/Jan/2006:15:04:05 -0700","request":{"uri":"//[::1"}},"messages":[{"data":{"severity":9}}]}`))
+	f.Add([]byte(`{"transaction":{"unix_timestamp":-9223372036854775808,"request":null},"aggregation":{"count":-1}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var log Log
+		if json.Unmarshal(data, &log) != nil {
+			return
+		}
+		event := NewEvent(log)
+		assert.Len(t, event.Rules, len(log.Messages))
+		_, err := json.Marshal(event)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// Labels a LokiSink can derive from each event
+const (
+	LokiLabelHost       = "host"
+	LokiLabelRuleFamily = "rule_family"
+	LokiLabelSeverity   = "severity"
+)
+
+// ValidateLokiLabel reports whether name is a label a LokiSink can derive from events
+func ValidateLokiLabel(name string) error {
+	switch name {
+	case LokiLabelHost, LokiLabelRuleFamily, LokiLabelSeverity:
+		return nil
+	}
+	return fmt.Errorf("unknown Loki label %q, expected %s, %s or %s", name, LokiLabelHost, LokiLabelRuleFamily, LokiLabelSeverity)
+}
+
+type LokiOptions struct {
+	// URL is Loki's base URL, e.g. http://loki:3100
+	URL string
+	// Labels are derived from each event and added to its stream, see ValidateLokiLabel
+	Labels []string
+	// StaticLabels are added to every stream, e.g. job=coraza
+	StaticLabels map[string]string
+	// TenantID is sent as X-Scope-OrgID to multi-tenant Loki installations
+	TenantID string
+	// BatchSize pushes the buffered events as soon as this many are waiting, rather than at the next flush
+	BatchSize int
+	Timeout   time.Duration
+	Retry     RetryOptions
+	// Transport carries the pushes. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// LokiSink pushes violation events to Grafana Loki. Events are buffered and pushed in batches on every flush.
+type LokiSink struct {
+	options LokiOptions
+	client  *http.Client
+
+	mu      sync.Mutex
+	pending []Log
+}
+
+func NewLokiSink(options LokiOptions) *LokiSink {
+	return &LokiSink{
+		options: options,
+		client:  outbound.Client(options.Transport, "loki", options.Timeout),
+	}
+}
+
+func (s *LokiSink) Name() string {
+	return "loki"
+}
+
+func (s *LokiSink) Send(log Log) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, log)
+	if len(s.pending) < s.options.BatchSize {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	return s.push(batch)
+}
+
+func (s *LokiSink) Flush(force bool) error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.push(batch)
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *LokiSink) push(batch []Log) error {
+	body, err := json.Marshal(map[string][]*lokiStream{"streams": s.streams(batch)})
+	if err != nil {
+		return err
+	}
+	return deliver(s.Name(), len(batch), s.options.Retry, func() error {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.options.URL, "/")+"/loki/api/v1/push", bytes.NewReader(body))
+		if err != nil {
+			return permanentError{err}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.options.TenantID != "" {
+			req.Header.Set("X-Scope-OrgID", s.options.TenantID)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("loki responded %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+		// Other client errors mean Loki rejected the payload, e.g. entries that are too old
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+// streams groups the batch by label set, each stream's entries in time order
+func (s *LokiSink) streams(batch []Log) []*lokiStream {
+	slices.SortStableFunc(batch, func(a, b Log) int { return a.Time().Compare(b.Time()) })
+
+	streams := map[string]*lokiStream{}
+	var result []*lokiStream
+	for _, log := range batch {
+		labels := s.labels(log)
+		key := fmt.Sprint(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			result = append(result, stream)
+		}
+		event := NewEvent(log)
+		line, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(event.Time.UnixNano(), 10), string(line)})
+	}
+	return result
+}
+
+func (s *LokiSink) labels(log Log) map[string]string {
+	labels := make(map[string]string, len(s.options.StaticLabels)+len(s.options.Labels))
+	for name, value := range s.options.StaticLabels {
+		labels[name] = value
+	}
+	for _, name := range s.options.Labels {
+		switch name {
+		case LokiLabelHost:
+			labels[name] = log.Host()
+		case LokiLabelSeverity:
+			labels[name] = log.Severity()
+		case LokiLabelRuleFamily:
+			if msg := log.mostSevere(); msg != nil {
+				labels[name] = RuleFamily(msg.Data.ID)
+			}
+		}
+		if labels[name] == "" {
+			labels[name] = "unknown"
+		}
+	}
+	return labels
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func timedViolation(id string, timestamp int64, host string, ruleID int, severity types.RuleSeverity) Log {
+	return Log{
+		Transaction: Transaction{
+			ID:            id,
+			UnixTimestamp: timestamp,
+			ClientIP:      "192.0.2.1",
+			Request:       &TransactionRequest{Method: "GET", URI: "http://" + host + "/search?q=1", Body: "secret"},
+		},
+		Messages: []Message{{Data: MessageData{ID: ruleID, File: "REQUEST-942-APPLICATION-ATTACK-SQLI.conf", Msg: "SQL Injection", Severity: severity}}},
+	}
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+func TestLokiSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		pushes   []lokiPush
+		statuses []int
+		tenant   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		mu.Lock()
+		defer mu.Unlock()
+		tenant = r.Header.Get("X-Scope-OrgID")
+		if len(statuses) > 0 {
+			status := statuses[0]
+			statuses = statuses[1:]
+			if status != http.StatusNoContent {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		var push lokiPush
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewLokiSink(LokiOptions{
+		URL:          server.URL,
+		Labels:       []string{LokiLabelHost, LokiLabelRuleFamily, LokiLabelSeverity},
+		StaticLabels: map[string]string{"job": "coraza-waf"},
+		TenantID:     "team-a",
+		BatchSize:    3,
+		Timeout:      time.Second,
+		Retry:        RetryOptions{Attempts: 2, Backoff: time.Millisecond},
+	})
+
+	t.Run("Should push buffered events grouped into streams on flush", func(t *testing.T) {
+		assert.NoError(t, sink.Send(timedViolation("b", 2000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Send(timedViolation("a", 1000, "example.com", 942200, types.RuleSeverityCritical)))
+		assert.Empty(t, pushes)
+
+		assert.NoError(t, sink.Flush(false))
+		assert.Len(t, pushes, 1)
+		assert.Equal(t, "team-a", tenant)
+		stream := pushes[0].Streams[0]
+		assert.Equal(t, map[string]string{"job": "coraza-waf", "host": "example.com", "rule_family": "942xxx", "severity": "critical"}, stream.Stream)
+		assert.Equal(t, "1000", stream.Values[0][0], "Expected entries in time order")
+		assert.Equal(t, "2000", stream.Values[1][0])
+
+		var event Event
+		assert.NoError(t, json.Unmarshal([]byte(stream.Values[0][1]), &event))
+		assert.Equal(t, "a", event.ID)
+		assert.NotContains(t, stream.Values[0][1], "secret", "Expected request bodies to be left out")
+	})
+
+	t.Run("Should push as soon as a batch is full", func(t *testing.T) {
+		pushes = nil
+		assert.NoError(t, sink.Send(timedViolation("a", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Send(timedViolation("b", 1000, "example.org", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Send(timedViolation("c", 1000, "example.com", 930100, types.RuleSeverityWarning)))
+		assert.Len(t, pushes, 1)
+		assert.Len(t, pushes[0].Streams, 3)
+	})
+
+	t.Run("Should retry failed pushes", func(t *testing.T) {
+		pushes = nil
+		statuses = []int{http.StatusServiceUnavailable}
+		assert.NoError(t, sink.Send(timedViolation("a", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.NoError(t, sink.Flush(true))
+		assert.Len(t, pushes, 1)
+	})
+
+	t.Run("Should drop batches that fail permanently or exhaust the retries", func(t *testing.T) {
+		pushes = nil
+		statuses = []int{http.StatusBadRequest}
+		assert.NoError(t, sink.Send(timedViolation("a", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.ErrorContains(t, sink.Flush(true), "dropped 1 events")
+		assert.Empty(t, statuses, "Expected no retry of a rejected payload")
+
+		statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError}
+		assert.NoError(t, sink.Send(timedViolation("a", 1000, "example.com", 942100, types.RuleSeverityCritical)))
+		assert.Error(t, sink.Flush(true))
+		assert.Empty(t, pushes)
+		assert.NoError(t, sink.Flush(true), "Expected dropped events not to be pushed again")
+	})
+}
+
+func TestLokiLabels(t *testing.T) {
+	assert.NoError(t, ValidateLokiLabel(LokiLabelRuleFamily))
+	assert.Error(t, ValidateLokiLabel("client_ip"))
+
+	sink := NewLokiSink(LokiOptions{Labels: []string{LokiLabelHost, LokiLabelRuleFamily}})
+	log := timedViolation("a", 0, "", 0, types.RuleSeverityCritical)
+	log.Transaction.Request.URI = "/search"
+	log.Transaction.Request.Headers = map[string][]string{"host": {"example.com"}}
+	log.Messages = append(log.Messages, Message{Data: MessageData{ID: 941100, Severity: types.RuleSeverityEmergency}})
+	assert.Equal(t, map[string]string{"host": "example.com", "rule_family": "941xxx"}, sink.labels(log))
+
+	log.Messages = nil
+	log.Transaction.Request = nil
+	assert.Equal(t, map[string]string{"host": "unknown", "rule_family": "unknown"}, sink.labels(log))
+}
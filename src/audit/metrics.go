@@ -5,15 +5,13 @@ import (
 	"net/url"
 	"strconv"
 
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var metricAuditLogTransactionsCount = promauto.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "audit_log_transactions",
-		Help: "The total number of audit log transactions processed",
-	},
+var metricAuditLogTransactionsCount = metrics.NewCounterVec(
+	"audit_log_transactions",
+	"The total number of audit log transactions processed",
 	[]string{"status_code", "method", "host", "path"},
 )
 
@@ -37,14 +35,15 @@ func sendTransactionMetrics(log Log) {
 			path = uri.Path
 		}
 	}
-	metricAuditLogTransactionsCount.WithLabelValues(statusCode, method, host, path).Inc()
+	metrics.IncWithExemplar(
+		metricAuditLogTransactionsCount.WithLabelValues(statusCode, method, host, path),
+		prometheus.Labels{"transaction_id": log.Transaction.ID},
+	)
 }
 
-var metricAuditLogRuleViolations = promauto.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "audit_log_rule_violations",
-		Help: "The total number of audit log rule violations",
-	},
+var metricAuditLogRuleViolations = metrics.NewCounterVec(
+	"audit_log_rule_violations",
+	"The total number of audit log rule violations",
 	[]string{"rule_id", "method", "host", "path"},
 )
 
@@ -65,6 +64,73 @@ func sendRuleViolationMetrics(log Log) {
 
 	for _, msg := range log.Messages {
 		ruleID := fmt.Sprintf("%s-%d", msg.Data.File, msg.Data.ID)
-		metricAuditLogRuleViolations.WithLabelValues(ruleID, method, host, path).Inc()
+		metrics.IncWithExemplar(
+			metricAuditLogRuleViolations.WithLabelValues(ruleID, method, host, path),
+			prometheus.Labels{"transaction_id": log.Transaction.ID},
+		)
 	}
 }
+
+var metricSinkEvents = metrics.NewCounterVec(
+	"waf_audit_sink_events_total",
+	"The total number of violation events delivered to or dropped by remote audit sinks",
+	[]string{"sink", "result"},
+)
+
+var metricWatchWakeups = metrics.NewCounter(
+	"waf_audit_log_watch_wakeups_total",
+	"The total number of processing runs started by a write to the watched audit log rather than the polling interval",
+)
+
+var metricProcessingProgress = metrics.NewGauge(
+	"waf_audit_log_processing_progress_ratio",
+	"The share of the audit log being processed that has been read, 1 when processing is idle",
+)
+
+var metricProcessingRemaining = metrics.NewGauge(
+	"waf_audit_log_processing_remaining_bytes",
+	"The bytes of the audit log being processed that are left to read",
+)
+
+var metricProcessingChunks = metrics.NewCounter(
+	"waf_audit_log_processing_chunks_total",
+	"The total number of audit log chunks processed, each followed by a yield point",
+)
+
+var metricOversizedLines = metrics.NewCounter(
+	"waf_audit_log_oversized_lines_total",
+	"The total number of audit log lines over the maximum line size, processed truncated",
+)
+
+var metricOrphansAdopted = metrics.NewCounter(
+	"waf_audit_log_orphans_adopted_total",
+	"The total number of audit logs left behind by other replicas that were adopted and processed",
+	metrics.WithAlertAbove(0),
+)
+
+var metricProcessingLag = metrics.NewGauge(
+	"waf_audit_log_processing_lag_seconds",
+	"How long before it was processed the last audit log entry with violations was written",
+)
+
+var metricDiskPressure = metrics.NewGauge(
+	"waf_audit_disk_pressure",
+	"1 while the audit log directory is over its disk usage or free space limits, 0 otherwise",
+)
+
+var metricDiskGuardActions = metrics.NewCounterVec(
+	"waf_audit_disk_guard_actions_total",
+	"The total number of actions taken to relieve disk pressure on the audit log directory by action (rotate, compress)",
+	[]string{"action"},
+)
+
+var metricAsyncWriteQueued = metrics.NewGauge(
+	"waf_audit_log_write_queue_lines",
+	"The audit log lines queued to be written in the background",
+)
+
+var metricAsyncWriteDropped = metrics.NewCounterVec(
+	"waf_audit_log_write_dropped_total",
+	"The total number of audit log lines dropped by the background writer by reason (queue_full, write_failed)",
+	[]string{"reason"},
+)
@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// This file implements the subset of MessagePack needed by the Fluent forward protocol: nil, booleans, integers,
+// floats, strings, arrays, string-keyed maps and the Fluent EventTime extension.
+
+// eventTime is encoded as the Fluent EventTime extension, which keeps nanosecond precision
+type eventTime time.Time
+
+func appendMsgpack(buf []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(buf, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(v)), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpack(buf, f)
+	case string:
+		return appendMsgpackString(buf, v), nil
+	case []any:
+		buf = appendMsgpackHeader(buf, len(v), 0x90, 0xdd)
+		for _, item := range v {
+			var err error
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		buf = appendMsgpackHeader(buf, len(v), 0x80, 0xdf)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			buf = appendMsgpackString(buf, key)
+			var err error
+			if buf, err = appendMsgpack(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case eventTime:
+		t := time.Time(v)
+		buf = append(buf, 0xd7, 0x00)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(t.Unix()))
+		return binary.BigEndian.AppendUint32(buf, uint32(t.Nanosecond())), nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", value)
+}
+
+func appendMsgpackInt(buf []byte, v int64) []byte {
+	if v >= 0 && v <= 127 {
+		return append(buf, byte(v))
+	}
+	if v < 0 && v >= -32 {
+		return append(buf, byte(v))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(v))
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		buf = append(buf, 0xa0|byte(len(s)))
+	case len(s) <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(len(s)))
+	case len(s) <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(len(s)))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(len(s)))
+	}
+	return append(buf, s...)
+}
+
+// appendMsgpackHeader writes an array or map header: the fix form for up to 15 items, else the 32-bit form
+func appendMsgpackHeader(buf []byte, n int, fix byte, long byte) []byte {
+	if n < 16 {
+		return append(buf, fix|byte(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, long), uint32(n))
+}
+
+// readMsgpack decodes one value written by appendMsgpack. EventTime values decode to time.Time.
+func readMsgpack(r *bufio.Reader) (any, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f))
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2, 0xc3:
+		return b == 0xc3, nil
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xd3:
+		n, err := readMsgpackUint(r, 8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackUint(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n))
+	case 0xd7:
+		var ext [9]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		if ext[0] != 0x00 {
+			return nil, fmt.Errorf("msgpack: unsupported extension type %d", ext[0])
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(ext[1:5])), int64(binary.BigEndian.Uint32(ext[5:]))), nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type byte 0x%x", b)
+}
+
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readMsgpackString(r *bufio.Reader, n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func readMsgpackArray(r *bufio.Reader, n int) ([]any, error) {
+	items := make([]any, 0, n)
+	for range n {
+		item, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n int) (map[string]any, error) {
+	entries := make(map[string]any, n)
+	for range n {
+		key, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: unsupported map key %T", key)
+		}
+		if entries[name], err = readMsgpack(r); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
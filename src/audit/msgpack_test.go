@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgpack(t *testing.T) {
+	value := map[string]any{
+		"nil":    nil,
+		"bool":   true,
+		"small":  int64(7),
+		"neg":    int64(-3),
+		"large":  int64(1 << 40),
+		"float":  1.5,
+		"string": string(make([]byte, 300)),
+		"array":  []any{"a", int64(1)},
+	}
+	encoded, err := appendMsgpack(nil, value)
+	assert.NoError(t, err)
+	decoded, err := readMsgpack(bufio.NewReader(bytes.NewReader(encoded)))
+	assert.NoError(t, err)
+	assert.Equal(t, value, decoded)
+
+	_, err = appendMsgpack(nil, struct{}{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Option configures a LogProcessor beyond its AuditLogProcessorOptions
+type Option func(*LogProcessor)
+
+// Clock tells a LogProcessor the time, which names rotated backups and decides when they expire, and schedules its
+// processing and expiration jobs
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker delivers ticks like a time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// FS is the filesystem a LogProcessor keeps the audit log and its backups on. Paths are operating system paths, as
+// passed in AuditLogPath.
+type FS interface {
+	Open(name string) (fs.File, error)
+	Create(name string) (io.WriteCloser, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Truncate(name string, size int64) error
+	Rename(oldName string, newName string) error
+	Remove(name string) error
+}
+
+// OSFS is the FS of the operating system
+type OSFS struct{}
+
+func (OSFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (OSFS) Rename(oldName string, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// readFile reads a whole file from fsys
+func readFile(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// WithClock sets the clock, time.Now by default
+func WithClock(clock Clock) Option {
+	return func(p *LogProcessor) { p.clock = clock }
+}
+
+// WithFS sets the filesystem of the audit log and its backups, OSFS by default
+func WithFS(fsys FS) Option {
+	return func(p *LogProcessor) { p.fs = fsys }
+}
+
+// WithSinks sets the sinks receiving every log containing rule violations
+func WithSinks(sinks ...Sink) Option {
+	return func(p *LogProcessor) { p.sinks = sinks }
+}
+
+// WithLogger sets the logger of the processor, slog.Default() by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *LogProcessor) { p.logger = logger }
+}
+
+// WithProcessingInterval sets how often the source is checked for new audit logs
+func WithProcessingInterval(interval time.Duration) Option {
+	return func(p *LogProcessor) { p.ProcessingJobInterval = interval }
+}
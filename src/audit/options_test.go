@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock only moves when advanced, firing the tickers and timers that are due
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, period: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t.c
+}
+
+// Advance moves the clock forward, firing the due tickers once each like a time.Ticker dropping ticks for a
+// slow receiver
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.tickers[:0]
+	for _, t := range c.tickers {
+		if !t.next.After(c.now) {
+			select {
+			case t.c <- c.now:
+			default:
+			}
+			if t.period == 0 {
+				continue
+			}
+			for !t.next.After(c.now) {
+				t.next = t.next.Add(t.period)
+			}
+		}
+		kept = append(kept, t)
+	}
+	c.tickers = kept
+}
+
+// waitForTickers waits until n tickers or timers are pending, so an advance is not missed by a job still starting
+func (c *fakeClock) waitForTickers(t *testing.T, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.tickers) >= n
+	}, 2*time.Second, time.Millisecond)
+}
+
+type fakeTicker struct {
+	clock *fakeClock
+	// period is zero for a timer, which fires once
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// memFS keeps files in memory, for tests that should not touch the disk
+type memFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: fstest.MapFS{}}
+}
+
+// key is the name of the file in the map, which has no leading slash
+func (m *memFS) key(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(name), "/")
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.Open(m.key(name))
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fs: m, name: name}, nil
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[m.key(name)] = &fstest.MapFile{Data: bytes.Clone(data), Mode: perm}
+	return nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fs.ReadDir(m.files, m.key(name))
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fs.Stat(m.files, m.key(name))
+}
+
+func (m *memFS) Truncate(name string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, ok := m.files[m.key(name)]
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	file.Data = file.Data[:min(size, int64(len(file.Data)))]
+	return nil
+}
+
+func (m *memFS) Rename(oldName string, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, ok := m.files[m.key(oldName)]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, m.key(oldName))
+	m.files[m.key(newName)] = file
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[m.key(name)]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, m.key(name))
+	return nil
+}
+
+// exists reports whether the file is in the filesystem
+func (m *memFS) exists(name string) bool {
+	_, err := m.Stat(name)
+	return err == nil
+}
+
+// memFile is a file being written to a memFS, which appears once closed
+type memFile struct {
+	fs   *memFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	return f.fs.WriteFile(f.name, f.buf.Bytes(), 0o644)
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	ticker := clock.NewTicker(time.Minute)
+	timer := clock.After(90 * time.Second)
+
+	clock.Advance(30 * time.Second)
+	assert.Empty(t, ticker.C())
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, time.Unix(1700000060, 0), <-ticker.C())
+	assert.Empty(t, timer)
+
+	clock.Advance(time.Minute)
+	assert.Len(t, ticker.C(), 1)
+	assert.Len(t, timer, 1)
+
+	ticker.Stop()
+	<-ticker.C()
+	clock.Advance(time.Hour)
+	assert.Empty(t, ticker.C())
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := newMemFS()
+	file, err := fsys.Create("/var/log/audit.log")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("content"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	data, err := readFile(fsys, "/var/log/audit.log")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	require.NoError(t, fsys.Truncate("/var/log/audit.log", 0))
+	info, err := fsys.Stat("/var/log/audit.log")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	require.NoError(t, fsys.Rename("/var/log/audit.log", "/var/log/audit.log.1"))
+	entries, err := fsys.ReadDir("/var/log")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "audit.log.1", entries[0].Name())
+
+	require.NoError(t, fsys.Remove("/var/log/audit.log.1"))
+	assert.False(t, fsys.exists("/var/log/audit.log.1"))
+}
@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstancePath returns the audit log path of a replica, with the instance inserted before the extension, e.g.
+// /var/log/coraza-audit.waf-0.log for /var/log/coraza-audit.log. An empty instance leaves the path as it is.
+func InstancePath(auditLogPath string, instance string) string {
+	if instance == "" {
+		return auditLogPath
+	}
+	ext := filepath.Ext(auditLogPath)
+	return strings.TrimSuffix(auditLogPath, ext) + "." + instance + ext
+}
+
+// ReplicaLogPaths returns the audit log path of every replica with backups next to the audit log, sorted
+func ReplicaLogPaths(auditLogPath string) ([]string, error) {
+	dir := filepath.Dir(auditLogPath)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log directory: %w", err)
+	}
+	names := newReplicaNames(filepath.Base(auditLogPath))
+	var paths []string
+	for _, file := range files {
+		if live, _, ok := names.backup(file.Name()); ok && !slices.Contains(paths, filepath.Join(dir, live)) {
+			paths = append(paths, filepath.Join(dir, live))
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// replicaNames recognizes the audit logs and backups of every replica writing next to each other in a directory
+type replicaNames struct {
+	stem string
+	ext  string
+}
+
+func newReplicaNames(auditLogFile string) *replicaNames {
+	ext := filepath.Ext(auditLogFile)
+	return &replicaNames{stem: strings.TrimSuffix(auditLogFile, ext) + ".", ext: ext}
+}
+
+// live returns the instance of the audit log of a replica
+func (n *replicaNames) live(name string) (string, bool) {
+	if len(name) <= len(n.stem)+len(n.ext) || !strings.HasPrefix(name, n.stem) || !strings.HasSuffix(name, n.ext) {
+		return "", false
+	}
+	return name[len(n.stem) : len(name)-len(n.ext)], true
+}
+
+// backup returns the audit log and rotation time of a backup of a replica
+func (n *replicaNames) backup(name string) (string, time.Time, bool) {
+	live, timestampStr, ok := cutLast(name, ".")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	if _, ok := n.live(live); !ok {
+		return "", time.Time{}, false
+	}
+	return live, time.Unix(timestamp, 0), true
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// adoptOrphans processes the audit logs left behind by replicas that are gone: the audit logs of other replicas
+// holding lines that were not rotated for orphanAfter. Each is renamed to a backup of this replica first, so only
+// one replica adopts it.
+func (p *LogProcessor) adoptOrphans() {
+	files, err := p.fs.ReadDir(p.auditLogDir)
+	if err != nil {
+		p.logger.Error("Failed to look for the audit logs of other replicas", "error", err)
+		return
+	}
+	now := p.clock.Now()
+	for _, file := range files {
+		instance, ok := p.replicas.live(file.Name())
+		if !ok || file.Name() == p.auditLogFile || !file.Type().IsRegular() || strings.HasSuffix(file.Name(), signatureSuffix) {
+			continue
+		}
+		if _, _, isBackup := p.replicas.backup(file.Name()); isBackup {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || info.Size() == 0 || now.Sub(info.ModTime()) < p.orphanAfter {
+			continue
+		}
+
+		orphan := filepath.Join(p.auditLogDir, file.Name())
+		backup := p.generateNewBackupFilename(now)
+		if _, err := p.fs.Stat(backup); err == nil {
+			// Rotated this second, adopt it on the next run
+			continue
+		}
+		if err := p.fs.Rename(orphan, backup); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				p.logger.Warn("Failed to adopt the audit log of another replica", "file", orphan, "error", err)
+			}
+			continue
+		}
+		metricOrphansAdopted.Inc()
+		p.logger.Warn("Adopted the audit log of a replica that is gone", "replica", instance, "file", orphan, "backup", backup, "modified", info.ModTime())
+		if err := p.ProcessLogFile(backup); err != nil {
+			p.logger.Error("Failed to process adopted audit log", "error", err, "file", backup)
+		}
+		p.sealBackup(backup)
+	}
+}
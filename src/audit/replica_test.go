@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstancePath(t *testing.T) {
+	assert.Equal(t, "/var/log/coraza-audit.waf-0.log", InstancePath("/var/log/coraza-audit.log", "waf-0"))
+	assert.Equal(t, "/var/log/audit.waf-0", InstancePath("/var/log/audit", "waf-0"))
+	assert.Equal(t, "/var/log/coraza-audit.log", InstancePath("/var/log/coraza-audit.log", ""))
+}
+
+func TestReplicaNames(t *testing.T) {
+	names := newReplicaNames("coraza-audit.log")
+
+	instance, ok := names.live("coraza-audit.waf-1.log")
+	assert.True(t, ok)
+	assert.Equal(t, "waf-1", instance)
+	_, ok = names.live("coraza-audit.log")
+	assert.False(t, ok, "Expected the shared audit log not to belong to a replica")
+
+	live, timestamp, ok := names.backup("coraza-audit.waf-1.log.1700000000")
+	assert.True(t, ok)
+	assert.Equal(t, "coraza-audit.waf-1.log", live)
+	assert.Equal(t, time.Unix(1700000000, 0), timestamp)
+	_, _, ok = names.backup("coraza-audit.log.1700000000")
+	assert.False(t, ok)
+	_, _, ok = names.backup("coraza-audit.waf-1.log.1700000000.sig")
+	assert.False(t, ok)
+}
+
+func TestReplicaAuditLogs(t *testing.T) {
+	dir := t.TempDir()
+	auditLogPath := filepath.Join(dir, "audit.log")
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath:  auditLogPath,
+		Storage:       StorageFile,
+		Instance:      "waf-0",
+		OrphanAfter:   time.Minute,
+		LogExpiration: time.Hour,
+	}, WithClock(clock))
+	var logs []Log
+	processor.logHandler = func(l Log) error {
+		logs = append(logs, l)
+		return nil
+	}
+	data, err := os.ReadFile("testdata/audit.log")
+	require.NoError(t, err)
+
+	t.Run("Should name the audit log after the instance", func(t *testing.T) {
+		assert.Equal(t, "audit.waf-0.log", processor.auditLogFile)
+		assert.Contains(t, processor.source.Directives(), filepath.Join(dir, "audit.waf-0.log"))
+	})
+
+	t.Run("Should adopt the unrotated audit log of a replica that is gone", func(t *testing.T) {
+		orphan := filepath.Join(dir, "audit.waf-1.log")
+		live := filepath.Join(dir, "audit.waf-2.log")
+		require.NoError(t, os.WriteFile(orphan, data, 0o644))
+		require.NoError(t, os.WriteFile(live, data, 0o644))
+		require.NoError(t, os.Chtimes(orphan, clock.Now().Add(-2*time.Minute), clock.Now().Add(-2*time.Minute)))
+		require.NoError(t, os.Chtimes(live, clock.Now(), clock.Now()))
+
+		processor.adoptOrphans()
+		assert.Len(t, logs, 4)
+		assert.NoFileExists(t, orphan)
+		assert.FileExists(t, filepath.Join(dir, "audit.waf-0.log.1700000000"), "Expected the orphan to become a backup of this replica")
+		assert.FileExists(t, live, "Expected the audit log of a running replica to be left alone")
+	})
+
+	t.Run("Should expire the backups of every replica", func(t *testing.T) {
+		gone := filepath.Join(dir, "audit.waf-1.log.1699990000")
+		recent := filepath.Join(dir, "audit.waf-2.log.1700000000")
+		require.NoError(t, os.WriteFile(gone, data, 0o644))
+		require.NoError(t, os.WriteFile(recent, data, 0o644))
+
+		require.NoError(t, processor.expireBackupLogFiles())
+		assert.NoFileExists(t, gone)
+		assert.FileExists(t, recent)
+		assert.FileExists(t, filepath.Join(dir, "audit.waf-2.log"), "Expected audit logs never to expire")
+
+		paths, err := ReplicaLogPaths(auditLogPath)
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "audit.waf-0.log"), filepath.Join(dir, "audit.waf-2.log")}, paths)
+	})
+}
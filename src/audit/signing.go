@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// signatureSuffix is appended to a backup's name to form the name of its signature file
+const signatureSuffix = ".sig"
+
+// BackupSignature proves a rotated audit log backup is unmodified. Each signature covers the previous one, so the
+// backups form a hash chain: removing, reordering or replacing a backup breaks the chain at that point.
+type BackupSignature struct {
+	// File is the name of the backup, without its directory
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	// Previous is the signature of the preceding backup, empty for the first backup of a chain
+	Previous  string `json:"previous"`
+	Signature string `json:"signature"`
+}
+
+// backupSigner signs rotated backups, continuing the chain from the newest existing signature
+type backupSigner struct {
+	fs  FS
+	key []byte
+
+	mu       sync.Mutex
+	previous string
+}
+
+func newBackupSigner(fsys FS, key []byte, backups []string) *backupSigner {
+	s := &backupSigner{fs: fsys, key: key}
+	// Continue the chain of the newest signed backup so restarts do not start a new chain
+	for _, backup := range slices.Backward(backups) {
+		if signature, err := readSignature(fsys, backup); err == nil {
+			s.previous = signature.Signature
+			break
+		}
+	}
+	return s
+}
+
+// sign writes the signature file of the backup at backupPath
+func (s *backupSigner) sign(backupPath string) error {
+	digest, err := fileDigest(s.fs, backupPath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	signature := BackupSignature{File: filepath.Base(backupPath), SHA256: digest, Previous: s.previous}
+	signature.Signature = signatureMAC(s.key, signature)
+	data, err := json.Marshal(signature)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.WriteFile(backupPath+signatureSuffix, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	s.previous = signature.Signature
+	return nil
+}
+
+// BackupVerification is the outcome of verifying one backup
+type BackupVerification struct {
+	File string `json:"file"`
+	OK   bool   `json:"ok"`
+	// Error explains why verification failed
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyBackups checks the signatures of the rotated backups of the audit log at auditLogPath, oldest first.
+// The first backup's link to its predecessor cannot be checked, since that backup may have expired.
+func VerifyBackups(auditLogPath string, key []byte) ([]BackupVerification, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a signing key is required")
+	}
+	p := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: auditLogPath, Storage: StorageFile})
+	backups, err := p.backupFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BackupVerification, 0, len(backups))
+	previous := ""
+	for i, backup := range backups {
+		result := BackupVerification{File: filepath.Base(backup)}
+		signature, err := verifyBackup(p.fs, backup, key)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case i > 0 && signature.Previous != previous:
+			result.Error = "chain is broken: the preceding backup was removed, replaced or reordered"
+		default:
+			result.OK = true
+		}
+		previous = signature.Signature
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func verifyBackup(fsys FS, backupPath string, key []byte) (BackupSignature, error) {
+	signature, err := readSignature(fsys, backupPath)
+	if err != nil {
+		return BackupSignature{}, err
+	}
+	if signature.File != filepath.Base(backupPath) {
+		return signature, fmt.Errorf("signature is for %s", signature.File)
+	}
+	if !hmac.Equal([]byte(signature.Signature), []byte(signatureMAC(key, signature))) {
+		return signature, errors.New("signature is invalid")
+	}
+	digest, err := fileDigest(fsys, backupPath)
+	if err != nil {
+		return signature, err
+	}
+	if digest != signature.SHA256 {
+		return signature, errors.New("content was modified after signing")
+	}
+	return signature, nil
+}
+
+func readSignature(fsys FS, backupPath string) (BackupSignature, error) {
+	var signature BackupSignature
+	data, err := readFile(fsys, backupPath+signatureSuffix)
+	if err != nil {
+		return signature, fmt.Errorf("failed to read signature: %w", err)
+	}
+	if err := json.Unmarshal(data, &signature); err != nil {
+		return signature, fmt.Errorf("invalid signature: %w", err)
+	}
+	return signature, nil
+}
+
+func signatureMAC(key []byte, signature BackupSignature) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signature.File + "\n" + signature.SHA256 + "\n" + signature.Previous))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func fileDigest(fsys FS, filePath string) (string, error) {
+	file, err := fsys.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read backup: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupSigning(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "audit.log")
+	key := []byte("secret")
+
+	backup := func(timestamp int) string {
+		name := fmt.Sprintf("%s.%d", logFile, timestamp)
+		assert.NoError(t, os.WriteFile(name, []byte(fmt.Sprintf(`{"transaction":{"id":"%d"}}`, timestamp)), 0o644))
+		return name
+	}
+	signer := newBackupSigner(OSFS{}, key, nil)
+	for _, timestamp := range []int{1000, 2000} {
+		assert.NoError(t, signer.sign(backup(timestamp)))
+	}
+
+	t.Run("Should continue the chain after a restart", func(t *testing.T) {
+		processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, SigningKey: key})
+		assert.NoError(t, processor.signer.sign(backup(3000)))
+
+		results, err := VerifyBackups(logFile, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []BackupVerification{
+			{File: "audit.log.1000", OK: true},
+			{File: "audit.log.2000", OK: true},
+			{File: "audit.log.3000", OK: true},
+		}, results)
+	})
+
+	t.Run("Should reject a wrong key", func(t *testing.T) {
+		results, err := VerifyBackups(logFile, []byte("other"))
+		assert.NoError(t, err)
+		assert.Equal(t, "signature is invalid", results[0].Error)
+
+		_, err = VerifyBackups(logFile, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Should detect modified and removed backups", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(logFile+".3000", []byte(`{"transaction":{"id":"forged"}}`), 0o644))
+		assert.NoError(t, os.Remove(logFile+".1000"))
+		assert.NoError(t, os.Remove(logFile+".1000"+signatureSuffix))
+
+		results, err := VerifyBackups(logFile, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []BackupVerification{
+			// The first remaining backup's predecessor may simply have expired
+			{File: "audit.log.2000", OK: true},
+			{File: "audit.log.3000", Error: "content was modified after signing"},
+		}, results)
+
+		assert.NoError(t, os.Remove(logFile+".2000"+signatureSuffix))
+		results, err = VerifyBackups(logFile, key)
+		assert.NoError(t, err)
+		assert.False(t, results[0].OK)
+		assert.Contains(t, results[0].Error, "failed to read signature")
+	})
+}
+
+func TestExpirationRemovesSignatures(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "audit.log")
+	processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: logFile, SigningKey: []byte("secret")})
+
+	backup := logFile + ".1000"
+	assert.NoError(t, os.WriteFile(backup, []byte("{}"), 0o644))
+	assert.NoError(t, processor.signer.sign(backup))
+	assert.NoError(t, processor.expireBackupLogFiles())
+
+	assert.NoFileExists(t, backup)
+	assert.NoFileExists(t, backup+signatureSuffix)
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/pool"
+)
+
+// Sink receives processed audit logs that contain rule violations
+type Sink interface {
+	Name() string
+	Send(log Log) error
+	// Flush emits any buffered logs. When force is true everything is emitted regardless of buffering windows.
+	Flush(force bool) error
+}
+
+// Expirer is implemented by sinks that keep data of their own. The expiration job asks them to delete what has
+// expired.
+type Expirer interface {
+	Expire(now time.Time) error
+}
+
+// logFieldSlices hold the attributes of a violation log line while it is written
+var logFieldSlices = pool.New("log_fields",
+	func() *[]any { fields := make([]any, 0, 32); return &fields },
+	func(fields *[]any) { clear(*fields); *fields = (*fields)[:0] },
+)
+
+// LogSink writes rule violations to the application log
+type LogSink struct {
+	logger *slog.Logger
+}
+
+func NewLogSink(logger *slog.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Send(log Log) error {
+	pooled := logFieldSlices.Get()
+	defer logFieldSlices.Put(pooled)
+	logFields := append(*pooled,
+		"id", log.Transaction.ID,
+		"client_ip", log.Transaction.ClientIP,
+	)
+	if log.ClientHostname != "" {
+		logFields = append(logFields, "client_hostname", log.ClientHostname)
+	}
+	if log.VerifiedCrawler != "" {
+		logFields = append(logFields, "verified_crawler", log.VerifiedCrawler)
+	}
+
+	request := log.Transaction.Request
+	if request != nil {
+		logFields = append(logFields,
+			"method", request.Method,
+			"uri", request.URI,
+			"protocol", request.Protocol,
+		)
+	}
+
+	if log.ClientCancelled() {
+		logFields = append(logFields, "client_cancelled", true)
+	}
+
+	rules := make([]string, 0, len(log.Messages))
+	for _, msg := range log.Messages {
+		rules = append(rules,
+			"rule_id", fmt.Sprintf("%s-%d", msg.Data.File, msg.Data.ID),
+			"message", msg.Data.Msg,
+		)
+	}
+	logFields = append(logFields, "rules", rules)
+
+	if log.Aggregation != nil {
+		logFields = append(logFields,
+			"count", log.Aggregation.Count,
+			"first_seen", log.Aggregation.FirstSeen,
+			"last_seen", log.Aggregation.LastSeen,
+		)
+	}
+
+	s.logger.Warn("Rule violations", logFields...)
+	*pooled = logFields
+	return nil
+}
+
+func (s *LogSink) Flush(force bool) error {
+	return nil
+}
+
+// RetryOptions controls how remote sinks retry failed deliveries
+type RetryOptions struct {
+	// Attempts is the number of tries before a batch is dropped, at least 1
+	Attempts int
+	// Backoff is the wait before the first retry, doubled after each retry
+	Backoff time.Duration
+}
+
+// permanentError marks a delivery failure that retrying cannot fix, such as a rejected payload
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// deliver calls send until it succeeds, fails permanently or runs out of attempts, counting the outcome of the
+// count events it carries
+func deliver(sink string, count int, retry RetryOptions, send func() error) error {
+	backoff := retry.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = send(); err == nil {
+			metricSinkEvents.WithLabelValues(sink, "sent").Add(float64(count))
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) || attempt >= retry.Attempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	metricSinkEvents.WithLabelValues(sink, "dropped").Add(float64(count))
+	return fmt.Errorf("dropped %d events: %w", count, err)
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// Source supplies Coraza JSON audit logs, one per line, to a LogProcessor
+type Source interface {
+	// Directives are the Coraza directives writing the audit log to the source, or empty when the WAF is
+	// configured some other way
+	Directives() string
+	// Read returns the audit log written since the last call, or nil when nothing was, and its size in bytes for
+	// progress reporting (zero when unknown). The reader is closed once it has been processed.
+	Read() (r io.ReadCloser, size int64, err error)
+}
+
+// Notifier is implemented by sources that can tell when they are written to, so the processor does not have to wait
+// for the next processing run. The channel is nil when changes cannot be watched.
+type Notifier interface {
+	Changes(stop <-chan struct{}) <-chan struct{}
+}
+
+// NewReaderSource returns a source reading the audit logs of r once, such as a saved Coraza audit log or the
+// standard input
+func NewReaderSource(r io.Reader) Source {
+	return &readerSource{reader: r}
+}
+
+type readerSource struct {
+	mu     sync.Mutex
+	reader io.Reader
+}
+
+func (s *readerSource) Directives() string {
+	return ""
+}
+
+func (s *readerSource) Read() (io.ReadCloser, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader == nil {
+		return nil, 0, nil
+	}
+	r := s.reader
+	s.reader = nil
+	var size int64
+	if sized, ok := r.(interface{ Len() int }); ok {
+		size = int64(sized.Len())
+	}
+	return io.NopCloser(r), size, nil
+}
+
+// auditLogDirectives writes the JSON audit log the processor reads to target, with a writer of the log type
+func auditLogDirectives(target string, logType string) string {
+	return fmt.Sprintf(`
+	  SecAuditLog %s
+		SecAuditLogParts AFHKZ
+		SecAuditLogFormat JSON
+		SecAuditLogType %s
+		SecAuditEngine On`, target, logType)
+}
+
+// fileSource is the audit log file of a processor, which is rotated into a backup to be read. The backup is sealed
+// once processed.
+type fileSource struct {
+	p *LogProcessor
+}
+
+func (s *fileSource) Directives() string {
+	logType := "Serial"
+	if s.p.async != nil {
+		logType = asyncWriterName
+	}
+	return auditLogDirectives(filepath.Join(s.p.auditLogDir, s.p.auditLogFile), logType)
+}
+
+func (s *fileSource) Read() (io.ReadCloser, int64, error) {
+	if s.p.async != nil {
+		// Lines still queued would otherwise wait for the next run
+		if err := s.p.async.flush(); err != nil {
+			s.p.logger.Error("Failed to write queued audit log lines", "error", err)
+		}
+	}
+	exist, err := s.p.checkIfLogsExist()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to check for audit logs: %w", err)
+	}
+	if !exist {
+		return nil, 0, nil
+	}
+
+	filename, err := s.p.rotateLogs()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	file, err := s.p.fs.Open(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return &backupReader{file: file, seal: func() { s.p.sealBackup(filename) }}, size, nil
+}
+
+func (s *fileSource) Changes(stop <-chan struct{}) <-chan struct{} {
+	watcher, err := watchFile(s.p.auditLogDir, s.p.auditLogFile)
+	if err != nil {
+		s.p.logger.Warn("Failed to watch the audit log, falling back to polling", "error", err, "interval", s.p.ProcessingJobInterval.String())
+		return nil
+	}
+	go func() {
+		<-stop
+		watcher.Close()
+	}()
+	return watcher.Changes()
+}
+
+// backupReader seals the backup it reads once closed
+type backupReader struct {
+	file fs.File
+	seal func()
+}
+
+func (r *backupReader) Read(b []byte) (int, error) {
+	return r.file.Read(b)
+}
+
+func (r *backupReader) Close() error {
+	err := r.file.Close()
+	r.seal()
+	return err
+}
+
+// memorySource is the in-memory audit log of a processor
+type memorySource struct {
+	p   *LogProcessor
+	log *memoryLog
+}
+
+func (s *memorySource) Directives() string {
+	return auditLogDirectives(filepath.Join(s.p.auditLogDir, s.p.auditLogFile), memoryWriterName)
+}
+
+func (s *memorySource) Read() (io.ReadCloser, int64, error) {
+	if s.log.len() == 0 {
+		return nil, 0, nil
+	}
+	// Hold the lock so no transaction is logged half-way
+	s.p.Lock.Lock()
+	data := s.log.drain()
+	s.p.Lock.Unlock()
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (s *memorySource) Changes(stop <-chan struct{}) <-chan struct{} {
+	return s.log.written
+}
@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProcessor(t *testing.T) {
+	data, err := os.ReadFile("testdata/audit.log")
+	require.NoError(t, err)
+
+	sink := &recordingSink{}
+	processor := NewProcessor(NewReaderSource(bytes.NewReader(data)), WithSinks(sink))
+
+	// A reader source leaves the audit log configuration of the WAF to the caller
+	_, err = coraza.NewWAF(processor.SetAuditLogDirectives(coraza.NewWAFConfig()))
+	require.NoError(t, err)
+	assert.NoError(t, processor.CheckWritable())
+
+	processor.processPending()
+	processor.processPending()
+	require.NoError(t, processor.Stop(context.Background()))
+
+	require.Len(t, sink.logs, 4)
+	assert.Equal(t, "EcNxIrskXYJttXoioLH", sink.logs[0].Transaction.ID)
+}
+
+func TestReaderSource(t *testing.T) {
+	source := NewReaderSource(bytes.NewReader([]byte("line\n")))
+
+	r, size, err := source.Read()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, int64(5), size)
+	assert.NoError(t, r.Close())
+
+	// The reader is only read once
+	r, _, err = source.Read()
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
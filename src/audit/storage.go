@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+)
+
+// Where the audit log is kept
+const (
+	// StorageAuto uses the audit log path, falling back to the temporary directory (usually a tmpfs in containers)
+	// and then to memory when neither is writable
+	StorageAuto = "auto"
+	// StorageFile only uses the audit log path
+	StorageFile = "file"
+	// StorageMemory keeps the audit log in memory between processing runs. There are no backups to sign, encrypt or
+	// expire.
+	StorageMemory = "memory"
+)
+
+// DefaultDirMode is the permissions of a created audit log directory
+const DefaultDirMode os.FileMode = 0o750
+
+// memoryWriterName is the SecAuditLogType of the in-memory audit log
+const memoryWriterName = "coraza_traefik_memory"
+
+// Location is where the audit log is kept
+type Location struct {
+	// Path is the audit log file, or the name of the in-memory log
+	Path string
+	// Memory is set when the audit log is kept in memory
+	Memory bool
+}
+
+// String describes the location for the startup report
+func (l Location) String() string {
+	if l.Memory {
+		return "memory"
+	}
+	return l.Path
+}
+
+// ValidationDirectives returns the audit log directives a processor of the location sets, written to memory so that
+// compiling them, e.g. in a dry run, neither opens the audit log file nor needs the processor
+func (l Location) ValidationDirectives() string {
+	return auditLogDirectives(l.Path, memoryWriterName)
+}
+
+// ResolveStorage returns where the audit log of the options is kept, creating its directory when needed
+func ResolveStorage(options AuditLogProcessorOptions) (Location, error) {
+	dirOptions, err := newDirOptions(options.DirMode, options.DirOwner)
+	if err != nil {
+		return Location{}, err
+	}
+	auditLogPath := InstancePath(options.AuditLogPath, options.Instance)
+	switch options.Storage {
+	case StorageMemory:
+		return Location{Path: auditLogPath, Memory: true}, nil
+	case StorageFile:
+		if err := dirOptions.prepare(filepath.Dir(auditLogPath)); err != nil {
+			return Location{}, err
+		}
+		return Location{Path: auditLogPath}, nil
+	case StorageAuto, "":
+		if dirOptions.prepare(filepath.Dir(auditLogPath)) == nil {
+			return Location{Path: auditLogPath}, nil
+		}
+		fallback := filepath.Join(os.TempDir(), "coraza-audit", filepath.Base(auditLogPath))
+		if dirOptions.prepare(filepath.Dir(fallback)) == nil {
+			return Location{Path: fallback}, nil
+		}
+		return Location{Path: auditLogPath, Memory: true}, nil
+	default:
+		return Location{}, fmt.Errorf("unknown audit log storage %q, expected auto, file or memory", options.Storage)
+	}
+}
+
+// dirOptions are applied to the audit log directory when it is created
+type dirOptions struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+// newDirOptions parses the owner, "user[:group]" with names or numeric IDs. Empty keeps the process owner.
+func newDirOptions(mode os.FileMode, owner string) (dirOptions, error) {
+	options := dirOptions{mode: mode, uid: -1, gid: -1}
+	if options.mode == 0 {
+		options.mode = DefaultDirMode
+	}
+	if owner == "" {
+		return options, nil
+	}
+	userName, groupName, _ := strings.Cut(owner, ":")
+	var err error
+	if options.uid, err = lookUpID(userName, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}); err != nil {
+		return dirOptions{}, fmt.Errorf("invalid audit log directory owner %q: %w", owner, err)
+	}
+	if groupName == "" {
+		return options, nil
+	}
+	if options.gid, err = lookUpID(groupName, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	}); err != nil {
+		return dirOptions{}, fmt.Errorf("invalid audit log directory group %q: %w", owner, err)
+	}
+	return options, nil
+}
+
+// lookUpID returns a numeric ID as is, or looks up a name
+func lookUpID(value string, lookUp func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := lookUp(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// prepare creates the directory with the mode and owner if needed, and checks that it is writable. An existing
+// directory is left as it is.
+func (o dirOptions) prepare(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, o.mode); err != nil {
+			return fmt.Errorf("failed to create audit log directory: %w", err)
+		}
+		// MkdirAll applies the umask
+		if err := os.Chmod(dir, o.mode); err != nil {
+			return fmt.Errorf("failed to set audit log directory permissions: %w", err)
+		}
+		if o.uid != -1 || o.gid != -1 {
+			if err := os.Chown(dir, o.uid, o.gid); err != nil {
+				return fmt.Errorf("failed to set audit log directory owner: %w", err)
+			}
+		}
+	}
+	return checkWritable(dir)
+}
+
+// checkWritable creates and removes a file in the directory
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("audit log directory is not writable: %w", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// memoryLog holds the audit log lines written since the last processing run
+type memoryLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// written receives a value after a write, like a fileWatcher
+	written chan struct{}
+}
+
+func (m *memoryLog) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.Len()
+}
+
+// drain returns the buffered lines and empties the log
+func (m *memoryLog) drain() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := bytes.Clone(m.buf.Bytes())
+	m.buf.Reset()
+	return data
+}
+
+// memoryLogs are the in-memory audit logs by name. Coraza creates its writers by type, so this is how a writer finds
+// the log of its processor.
+var memoryLogs sync.Map
+
+func openMemoryLog(name string) *memoryLog {
+	log, _ := memoryLogs.LoadOrStore(name, &memoryLog{written: make(chan struct{}, 1)})
+	return log.(*memoryLog)
+}
+
+func init() {
+	plugins.RegisterAuditLogWriter(memoryWriterName, func() plugintypes.AuditLogWriter { return &memoryWriter{} })
+}
+
+// memoryWriter is the Coraza audit log writer appending formatted logs to an in-memory log
+type memoryWriter struct {
+	log       *memoryLog
+	formatter plugintypes.AuditLogFormatter
+}
+
+func (w *memoryWriter) Init(config plugintypes.AuditLogConfig) error {
+	if config.Formatter == nil {
+		return errors.New("in-memory audit log requires a format")
+	}
+	w.log = openMemoryLog(config.Target)
+	w.formatter = config.Formatter
+	return nil
+}
+
+func (w *memoryWriter) Write(log plugintypes.AuditLog) error {
+	data, err := w.formatter.Format(log)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	w.log.mu.Lock()
+	w.log.buf.Write(data)
+	w.log.buf.WriteByte('\n')
+	w.log.mu.Unlock()
+	select {
+	case w.log.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
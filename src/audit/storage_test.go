@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveStorage(t *testing.T) {
+	t.Run("creates the directory", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "nested", "audit.log")
+		location, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageAuto})
+		require.NoError(t, err)
+		assert.Equal(t, Location{Path: logFile}, location)
+		info, err := os.Stat(filepath.Dir(logFile))
+		require.NoError(t, err)
+		assert.Equal(t, DefaultDirMode, info.Mode().Perm())
+	})
+
+	t.Run("creates the directory with the mode and owner", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "audit", "audit.log")
+		owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+		_, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, DirMode: 0o700, DirOwner: owner})
+		require.NoError(t, err)
+		info, err := os.Stat(filepath.Dir(logFile))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	})
+
+	t.Run("invalid owner", func(t *testing.T) {
+		_, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: filepath.Join(t.TempDir(), "audit.log"), DirOwner: "no-such-user-here"})
+		assert.Error(t, err)
+	})
+
+	// A directory below a regular file can never be created, whatever the permissions of the test user
+	blocked := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(blocked, nil, 0o600))
+	logFile := filepath.Join(blocked, "audit.log")
+
+	t.Run("falls back to the temporary directory", func(t *testing.T) {
+		location, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageAuto})
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(os.TempDir(), "coraza-audit", "audit.log"), location.Path)
+		assert.False(t, location.Memory)
+	})
+
+	t.Run("falls back to memory", func(t *testing.T) {
+		t.Setenv("TMPDIR", blocked)
+		t.Setenv("TMP", blocked)
+		location, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageAuto})
+		require.NoError(t, err)
+		assert.True(t, location.Memory)
+		assert.Equal(t, "memory", location.String())
+	})
+
+	t.Run("file storage does not fall back", func(t *testing.T) {
+		_, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: StorageFile})
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown storage", func(t *testing.T) {
+		_, err := ResolveStorage(AuditLogProcessorOptions{AuditLogPath: logFile, Storage: "disk"})
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audit")
+	processor := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: filepath.Join(dir, "audit.log"), Storage: StorageFile})
+	assert.NoError(t, processor.CheckWritable())
+
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, processor.CheckWritable())
+
+	memory := NewLogProcessor(AuditLogProcessorOptions{AuditLogPath: filepath.Join(dir, "audit.log"), Storage: StorageMemory})
+	assert.NoError(t, memory.CheckWritable())
+}
+
+func TestMemoryStorage(t *testing.T) {
+	sink := &recordingSink{}
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath:          filepath.Join(t.TempDir(), "memory.log"),
+		Storage:               StorageMemory,
+		ProcessingJobInterval: 100 * time.Millisecond,
+		Sinks:                 []Sink{sink},
+	})
+
+	waf, err := coraza.NewWAF(processor.SetAuditLogDirectives(coraza.NewWAFConfig().WithDirectives(`
+		SecRuleEngine On
+		SecRule ARGS:attack "@streq 1" "id:1,phase:1,deny,status:403,log"`)))
+	require.NoError(t, err)
+
+	tx := waf.NewTransaction()
+	tx.ProcessURI("/?attack=1", "GET", "HTTP/1.1")
+	tx.ProcessRequestHeaders()
+	tx.ProcessLogging()
+	tx.Close()
+
+	exist, err := processor.checkIfLogsExist()
+	require.NoError(t, err)
+	assert.True(t, exist)
+	files, err := os.ReadDir(processor.auditLogDir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "Expected nothing to be written to disk")
+
+	go processor.StartProcessingJob()
+	assert.Eventually(t, func() bool {
+		exist, _ := processor.checkIfLogsExist()
+		return !exist
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, processor.Stop(context.Background()))
+
+	require.Len(t, sink.logs, 1)
+	assert.Equal(t, 1, sink.logs[0].Messages[0].Data.ID)
+}
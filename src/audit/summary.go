@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxSummaryWindow is the longest window a summary can cover, as counts are only kept that long
+const MaxSummaryWindow = 24 * time.Hour
+
+// Summarizer is a sink that keeps per-minute counts of violations so that top attackers,
+// targeted paths and rules can be reported without an external analytics system
+type Summarizer struct {
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[int64]*summaryBucket
+
+	stopSignal chan struct{}
+	jobDone    chan struct{}
+}
+
+type summaryBucket struct {
+	clients map[string]int
+	paths   map[string]int
+	rules   map[string]int
+}
+
+// Summary is a top-N report over a window of processed violations
+type Summary struct {
+	Window       string         `json:"window"`
+	Violations   int            `json:"violations"`
+	TopAttackers []SummaryEntry `json:"top_attackers"`
+	TopPaths     []SummaryEntry `json:"top_paths"`
+	TopRules     []SummaryEntry `json:"top_rules"`
+}
+
+type SummaryEntry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+func NewSummarizer(logger *slog.Logger) *Summarizer {
+	return &Summarizer{
+		logger:     logger,
+		now:        time.Now,
+		buckets:    map[int64]*summaryBucket{},
+		stopSignal: make(chan struct{}),
+	}
+}
+
+func (s *Summarizer) Name() string {
+	return "summary"
+}
+
+func (s *Summarizer) Send(log Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	minute := timeOf(log, s.now).Truncate(time.Minute).Unix()
+	bucket, ok := s.buckets[minute]
+	if !ok {
+		bucket = &summaryBucket{clients: map[string]int{}, paths: map[string]int{}, rules: map[string]int{}}
+		s.buckets[minute] = bucket
+	}
+
+	bucket.clients[log.Transaction.ClientIP]++
+	if request := log.Transaction.Request; request != nil {
+		path := request.URI
+		if uri, err := url.Parse(request.URI); err == nil {
+			path = uri.Path
+		}
+		bucket.paths[path]++
+	}
+	for _, msg := range log.Messages {
+		bucket.rules[strconv.Itoa(msg.Data.ID)]++
+	}
+	return nil
+}
+
+// Flush drops buckets that have fallen outside of the longest summary window
+func (s *Summarizer) Flush(force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-MaxSummaryWindow).Unix()
+	for minute := range s.buckets {
+		if minute < cutoff {
+			delete(s.buckets, minute)
+		}
+	}
+	return nil
+}
+
+// Summary reports the n most frequent attackers, paths and rules over the window
+func (s *Summarizer) Summary(window time.Duration, n int) Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients, paths, rules := map[string]int{}, map[string]int{}, map[string]int{}
+	violations := 0
+	cutoff := s.now().Add(-window).Unix()
+	for minute, bucket := range s.buckets {
+		if minute < cutoff {
+			continue
+		}
+		for k, v := range bucket.clients {
+			clients[k] += v
+			violations += v
+		}
+		for k, v := range bucket.paths {
+			paths[k] += v
+		}
+		for k, v := range bucket.rules {
+			rules[k] += v
+		}
+	}
+
+	return Summary{
+		Window:       window.String(),
+		Violations:   violations,
+		TopAttackers: topN(clients, n),
+		TopPaths:     topN(paths, n),
+		TopRules:     topN(rules, n),
+	}
+}
+
+// StartReportJob periodically logs a summary for each window. Only the leader logs them, or every replica when
+// leader is nil.
+func (s *Summarizer) StartReportJob(interval time.Duration, windows []time.Duration, n int, leader func() bool) {
+	s.logger.Info("Starting audit summary job", "interval", interval.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.jobDone = make(chan struct{})
+	defer close(s.jobDone)
+
+	for {
+		select {
+		case <-s.stopSignal:
+			return
+		case <-ticker.C:
+			if leader != nil && !leader() {
+				continue
+			}
+			for _, window := range windows {
+				summary := s.Summary(window, n)
+				s.logger.Info("Audit summary",
+					"window", summary.Window,
+					"violations", summary.Violations,
+					"top_attackers", summary.TopAttackers,
+					"top_paths", summary.TopPaths,
+					"top_rules", summary.TopRules,
+				)
+			}
+		}
+	}
+}
+
+// Stop stops the report job and waits for it to finish
+func (s *Summarizer) Stop(ctx context.Context) error {
+	close(s.stopSignal)
+	if s.jobDone == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.jobDone:
+		return nil
+	}
+}
+
+func topN(counts map[string]int, n int) []SummaryEntry {
+	entries := make([]SummaryEntry, 0, len(counts))
+	for k, v := range counts {
+		entries = append(entries, SummaryEntry{Key: k, Count: v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizer(t *testing.T) {
+	summarizer := NewSummarizer(slog.Default())
+
+	now := time.Unix(1700000000, 0)
+	summarizer.now = func() time.Time { return now }
+
+	// An old violation that should only appear in the 24h window
+	assert.NoError(t, summarizer.Send(violation("198.51.100.7", "/old", 913100)))
+
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, summarizer.Send(violation("192.0.2.1", "/login", 942100)))
+	assert.NoError(t, summarizer.Send(violation("192.0.2.1", "/login", 942100)))
+	assert.NoError(t, summarizer.Send(violation("192.0.2.2", "/search", 941100)))
+
+	hourly := summarizer.Summary(time.Hour, 1)
+	assert.Equal(t, 3, hourly.Violations)
+	assert.Equal(t, []SummaryEntry{{Key: "192.0.2.1", Count: 2}}, hourly.TopAttackers)
+	assert.Equal(t, []SummaryEntry{{Key: "/login", Count: 2}}, hourly.TopPaths)
+	assert.Equal(t, []SummaryEntry{{Key: "942100", Count: 2}}, hourly.TopRules)
+
+	daily := summarizer.Summary(24*time.Hour, 10)
+	assert.Equal(t, 4, daily.Violations)
+	assert.Len(t, daily.TopAttackers, 3)
+
+	// Buckets older than the longest window should be dropped on flush
+	now = now.Add(24 * time.Hour)
+	assert.NoError(t, summarizer.Flush(false))
+	assert.Empty(t, summarizer.buckets)
+}
+
+func TestSummarizerUsesEventTime(t *testing.T) {
+	summarizer := NewSummarizer(slog.Default())
+
+	now := time.Unix(1700000000, 0)
+	summarizer.now = func() time.Time { return now }
+
+	// Processed now, but written three hours ago while processing was behind
+	log := violation("192.0.2.1", "/login", 942100)
+	log.Transaction.Time = now.Add(-3 * time.Hour)
+	assert.NoError(t, summarizer.Send(log))
+
+	assert.Equal(t, 0, summarizer.Summary(time.Hour, 1).Violations)
+	assert.Equal(t, 1, summarizer.Summary(24*time.Hour, 1).Violations)
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// fileWatcher signals writes to a file through inotify. The directory is watched rather than the file, so the file
+// may be created after the watch starts.
+type fileWatcher struct {
+	inotify *os.File
+	changes chan struct{}
+}
+
+func watchFile(dir string, name string) (*fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_MODIFY|syscall.IN_CREATE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	// A non-blocking descriptor is read through the runtime poller, so closing the file ends a pending read
+	w := &fileWatcher{inotify: os.NewFile(uintptr(fd), "inotify"), changes: make(chan struct{}, 1)}
+	go w.read([]byte(name))
+	return w, nil
+}
+
+func (w *fileWatcher) read(name []byte) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.inotify.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			if offset > n {
+				break
+			}
+			if bytes.Equal(bytes.TrimRight(buf[start:offset], "\x00"), name) {
+				select {
+				case w.changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Changes receives a value after the file has been written to. Writes made before the value is received are coalesced.
+func (w *fileWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *fileWatcher) Close() error {
+	return w.inotify.Close()
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFile(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := watchFile(dir, "audit.log")
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "audit.log"), []byte("x"), 0o600))
+	select {
+	case <-watcher.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a change for the watched file")
+	}
+
+	// Let the events of the write above arrive before draining them
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-watcher.Changes():
+	default:
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.log"), []byte("x"), 0o600))
+	select {
+	case <-watcher.Changes():
+		t.Fatal("Expected writes to other files to be ignored")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLogProcessorWatch(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "audit.log")
+	processor := NewLogProcessor(AuditLogProcessorOptions{
+		AuditLogPath: logFile,
+		// Long enough that only the watch can trigger processing during the test
+		ProcessingJobInterval: time.Hour,
+		Watch:                 true,
+		WatchDelay:            10 * time.Millisecond,
+	})
+	processed := make(chan struct{}, 10)
+	processor.logHandler = func(l Log) error {
+		processed <- struct{}{}
+		return nil
+	}
+	go processor.StartProcessingJob()
+	defer processor.Stop(context.Background())
+
+	data, err := os.ReadFile("testdata/audit.log")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		// The watch starts in the background, so write until it has seen a write
+		os.WriteFile(logFile, data, 0o600)
+		select {
+		case <-processed:
+			return true
+		default:
+			return false
+		}
+	}, 2*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package audit
+
+import "errors"
+
+// fileWatcher is only implemented with inotify; elsewhere the processing job polls
+type fileWatcher struct{}
+
+func watchFile(dir string, name string) (*fileWatcher, error) {
+	return nil, errors.New("watching the audit log is only supported on Linux")
+}
+
+func (w *fileWatcher) Changes() <-chan struct{} {
+	return nil
+}
+
+func (w *fileWatcher) Close() error {
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package aws signs requests to AWS APIs with credentials from the standard sources, without the AWS SDK
+package aws
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials sign requests to AWS
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is when temporary credentials expire, zero for long-lived credentials
+	Expires time.Time
+}
+
+// expiryWindow refreshes temporary credentials this long before they expire
+const expiryWindow = 5 * time.Minute
+
+// Region returns the region configured by AWS_REGION or AWS_DEFAULT_REGION
+func Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// CredentialsProvider resolves credentials from the same sources as the AWS SDKs, in order:
+//   - AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+//   - a web identity token, as set up by IAM roles for service accounts (IRSA) on EKS
+//   - the container credentials endpoint of ECS tasks and EKS Pod Identity
+//
+// Temporary credentials are cached until shortly before they expire.
+type CredentialsProvider struct {
+	client *http.Client
+	getenv func(string) string
+	now    func() time.Time
+	// stsEndpoint and containerEndpoint are overridden in tests
+	stsEndpoint       string
+	containerEndpoint string
+
+	mu     sync.Mutex
+	cached Credentials
+}
+
+func NewCredentialsProvider(client *http.Client) *CredentialsProvider {
+	return &CredentialsProvider{
+		client:            client,
+		getenv:            os.Getenv,
+		now:               time.Now,
+		containerEndpoint: "http://169.254.170.2",
+	}
+}
+
+// Retrieve returns valid credentials, refreshing them if needed
+func (p *CredentialsProvider) Retrieve() (Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cached.AccessKeyID != "" && (p.cached.Expires.IsZero() || p.now().Before(p.cached.Expires.Add(-expiryWindow))) {
+		return p.cached, nil
+	}
+
+	credentials, err := p.resolve()
+	if err != nil {
+		return Credentials{}, err
+	}
+	p.cached = credentials
+	return credentials, nil
+}
+
+func (p *CredentialsProvider) resolve() (Credentials, error) {
+	if id, secret := p.getenv("AWS_ACCESS_KEY_ID"), p.getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: p.getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if tokenFile, role := p.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), p.getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+		return p.assumeRoleWithWebIdentity(tokenFile, role)
+	}
+	if p.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return p.containerCredentials()
+	}
+	return Credentials{}, errors.New("no AWS credentials found: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or run with an IAM role for service accounts or a task role")
+}
+
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// assumeRoleWithWebIdentity exchanges the projected service account token for role credentials. The call to STS
+// is authenticated by the token itself, so it is not signed.
+func (p *CredentialsProvider) assumeRoleWithWebIdentity(tokenFile string, role string) (Credentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+	sessionName := p.getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "coraza-traefik-middleware"
+	}
+	endpoint := p.stsEndpoint
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com"
+		if region := p.getenv("AWS_REGION"); region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com"
+		}
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := p.client.PostForm(endpoint, form)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to assume role %s: %w", role, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return Credentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("failed to assume role %s: STS responded %d: %s", role, resp.StatusCode, body)
+	}
+	var parsed assumeRoleResponse
+	if err := xml.Unmarshal(body, &parsed); err != nil {
+		return Credentials{}, fmt.Errorf("invalid STS response: %w", err)
+	}
+	c := parsed.Credentials
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+type containerCredentialsResponse struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// containerCredentials fetches the task role credentials of ECS, or the Pod Identity credentials of EKS
+func (p *CredentialsProvider) containerCredentials() (Credentials, error) {
+	endpoint := p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := p.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = p.containerEndpoint + relative
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	authorization := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to fetch container credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("failed to fetch container credentials: endpoint responded %d", resp.StatusCode)
+	}
+	var parsed containerCredentialsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return Credentials{}, fmt.Errorf("invalid container credentials: %w", err)
+	}
+	return Credentials{AccessKeyID: parsed.AccessKeyID, SecretAccessKey: parsed.SecretAccessKey, SessionToken: parsed.Token, Expires: parsed.Expiration}, nil
+}
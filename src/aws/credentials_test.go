@@ -0,0 +1,80 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsProvider(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	provider := func(env map[string]string) *CredentialsProvider {
+		p := NewCredentialsProvider(http.DefaultClient)
+		p.getenv = func(name string) string { return env[name] }
+		p.now = func() time.Time { return now }
+		return p
+	}
+
+	t.Run("Should prefer credentials from the environment", func(t *testing.T) {
+		p := provider(map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "token", "AWS_ROLE_ARN": "arn"})
+		credentials, err := p.Retrieve()
+		assert.NoError(t, err)
+		assert.Equal(t, Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}, credentials)
+	})
+
+	t.Run("Should assume the role of a web identity and refresh before expiry", func(t *testing.T) {
+		calls := 0
+		sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "AssumeRoleWithWebIdentity", r.Form.Get("Action"))
+			assert.Equal(t, "arn:aws:iam::123456789012:role/waf", r.Form.Get("RoleArn"))
+			assert.Equal(t, "jwt", r.Form.Get("WebIdentityToken"))
+			fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+				<AccessKeyId>ASIA%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+				<Expiration>2025-01-01T13:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`, calls)
+		}))
+		defer sts.Close()
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		assert.NoError(t, os.WriteFile(tokenFile, []byte("jwt\n"), 0o600))
+
+		p := provider(map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile, "AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/waf"})
+		p.stsEndpoint = sts.URL
+		credentials, err := p.Retrieve()
+		assert.NoError(t, err)
+		assert.Equal(t, Credentials{AccessKeyID: "ASIA1", SecretAccessKey: "secret", SessionToken: "token", Expires: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)}, credentials)
+
+		credentials, _ = p.Retrieve()
+		assert.Equal(t, "ASIA1", credentials.AccessKeyID, "Expected cached credentials")
+		now = now.Add(56 * time.Minute)
+		credentials, _ = p.Retrieve()
+		assert.Equal(t, "ASIA2", credentials.AccessKeyID, "Expected credentials to be refreshed before they expire")
+	})
+
+	t.Run("Should fetch container credentials", func(t *testing.T) {
+		endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v2/credentials/abc", r.URL.Path)
+			assert.Equal(t, "auth", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"token","Expiration":"2025-01-01T18:00:00Z"}`)
+		}))
+		defer endpoint.Close()
+
+		p := provider(map[string]string{"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v2/credentials/abc", "AWS_CONTAINER_AUTHORIZATION_TOKEN": "auth"})
+		p.containerEndpoint = endpoint.URL
+		credentials, err := p.Retrieve()
+		assert.NoError(t, err)
+		assert.Equal(t, "ASIA", credentials.AccessKeyID)
+		assert.Equal(t, "token", credentials.SessionToken)
+	})
+
+	t.Run("Should fail without credentials", func(t *testing.T) {
+		_, err := provider(map[string]string{}).Retrieve()
+		assert.ErrorContains(t, err, "no AWS credentials found")
+	})
+}
@@ -0,0 +1,34 @@
+package aws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// The get-vanilla and get-vanilla-query-order-key-case cases of the AWS Signature Version 4 test suite
+	credentials := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	Sign(req, nil, credentials, "us-east-1", "service", now)
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+
+	req, _ = http.NewRequest("GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", nil)
+	Sign(req, nil, credentials, "us-east-1", "service", now)
+	assert.Contains(t, req.Header.Get("Authorization"), "Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500")
+
+	t.Run("Should sign the session token", func(t *testing.T) {
+		credentials.SessionToken = "token"
+		req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+		Sign(req, nil, credentials, "us-east-1", "service", now)
+		assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+		assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+	})
+}
@@ -0,0 +1,114 @@
+package ban
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is a banned client
+type Entry struct {
+	IP      string    `json:"ip"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+// List holds temporarily banned client IPs. Bans are kept in memory and expire on their own.
+type List struct {
+	now func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]Entry
+}
+
+func New() *List {
+	return &List{
+		now:     time.Now,
+		entries: map[string]Entry{},
+	}
+}
+
+// Add bans ip for duration, extending any existing ban
+func (l *List) Add(ip string, reason string, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := l.now().Add(duration)
+	if existing, ok := l.entries[ip]; ok && existing.Expires.After(expires) {
+		expires = existing.Expires
+	}
+	l.entries[ip] = Entry{IP: ip, Reason: reason, Expires: expires}
+}
+
+// AddEntries bans the IPs of the entries until they expire, extending any existing ban. Expired entries are skipped.
+func (l *List) AddEntries(entries []Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.addEntries(entries)
+}
+
+// Replace lifts every ban and bans the IPs of the entries until they expire. Expired entries are skipped.
+func (l *List) Replace(entries []Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = map[string]Entry{}
+	l.addEntries(entries)
+}
+
+// addEntries adds the entries that have not expired. The caller must hold the write lock.
+func (l *List) addEntries(entries []Entry) {
+	now := l.now()
+	for _, entry := range entries {
+		if !now.Before(entry.Expires) {
+			continue
+		}
+		if existing, ok := l.entries[entry.IP]; ok && existing.Expires.After(entry.Expires) {
+			entry.Expires = existing.Expires
+		}
+		l.entries[entry.IP] = entry
+	}
+}
+
+// Banned reports whether ip is currently banned
+func (l *List) Banned(ip string) (Entry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.entries[ip]
+	if !ok || !l.now().Before(entry.Expires) {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Remove lifts the ban on ip, reporting whether it was banned
+func (l *List) Remove(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.entries[ip]
+	delete(l.entries, ip)
+	return ok
+}
+
+// Entries returns the active bans ordered by IP, dropping expired ones
+func (l *List) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	entries := make([]Entry, 0, len(l.entries))
+	for ip, entry := range l.entries {
+		if !now.Before(entry.Expires) {
+			delete(l.entries, ip)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].IP < entries[j].IP
+	})
+	return entries
+}
@@ -0,0 +1,66 @@
+package ban
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	list := New()
+	now := time.Unix(1700000000, 0)
+	list.now = func() time.Time { return now }
+
+	list.Add("192.0.2.1", "honeypot:/.env", time.Hour)
+	list.Add("192.0.2.2", "honeypot:/.env", 10*time.Minute)
+
+	entry, ok := list.Banned("192.0.2.1")
+	assert.True(t, ok)
+	assert.Equal(t, "honeypot:/.env", entry.Reason)
+	_, ok = list.Banned("192.0.2.3")
+	assert.False(t, ok)
+
+	t.Run("Should not shorten an existing ban", func(t *testing.T) {
+		list.Add("192.0.2.1", "honeypot:/wp-login.php", time.Minute)
+		entry, _ := list.Banned("192.0.2.1")
+		assert.Equal(t, now.Add(time.Hour), entry.Expires)
+	})
+
+	t.Run("Should expire bans", func(t *testing.T) {
+		now = now.Add(30 * time.Minute)
+		_, ok := list.Banned("192.0.2.2")
+		assert.False(t, ok)
+		assert.Len(t, list.Entries(), 1)
+	})
+
+	t.Run("Should remove bans", func(t *testing.T) {
+		assert.True(t, list.Remove("192.0.2.1"))
+		assert.False(t, list.Remove("192.0.2.1"))
+		assert.Empty(t, list.Entries())
+	})
+}
+
+func TestListEntries(t *testing.T) {
+	list := New()
+	now := time.Unix(1700000000, 0)
+	list.now = func() time.Time { return now }
+	list.Add("192.0.2.1", "honeypot:/.env", time.Hour)
+
+	t.Run("Should add entries without shortening existing bans", func(t *testing.T) {
+		list.AddEntries([]Entry{
+			{IP: "192.0.2.1", Reason: "soar", Expires: now.Add(time.Minute)},
+			{IP: "192.0.2.2", Reason: "soar", Expires: now.Add(time.Minute)},
+			{IP: "192.0.2.3", Reason: "soar", Expires: now.Add(-time.Minute)},
+		})
+		assert.Equal(t, []Entry{
+			{IP: "192.0.2.1", Reason: "soar", Expires: now.Add(time.Hour)},
+			{IP: "192.0.2.2", Reason: "soar", Expires: now.Add(time.Minute)},
+		}, list.Entries())
+	})
+
+	t.Run("Should replace every ban", func(t *testing.T) {
+		list.Replace([]Entry{{IP: "192.0.2.4", Reason: "soar", Expires: now.Add(time.Minute)}})
+		assert.Equal(t, []Entry{{IP: "192.0.2.4", Reason: "soar", Expires: now.Add(time.Minute)}}, list.Entries())
+	})
+}
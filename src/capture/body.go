@@ -0,0 +1,34 @@
+package capture
+
+import "io"
+
+// Body keeps a copy of the first bytes read from a request body
+type Body struct {
+	io.ReadCloser
+	limit     int64
+	data      []byte
+	truncated bool
+}
+
+// NewBody records up to limit bytes of what is read from body
+func NewBody(body io.ReadCloser, limit int64) *Body {
+	return &Body{ReadCloser: body, limit: limit}
+}
+
+func (b *Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := b.limit - int64(len(b.data)); room < int64(n) {
+			b.data = append(b.data, p[:max(room, 0)]...)
+			b.truncated = true
+		} else {
+			b.data = append(b.data, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+// Bytes returns the recorded bytes and whether the body read was longer
+func (b *Body) Bytes() ([]byte, bool) {
+	return b.data, b.truncated
+}
@@ -0,0 +1,359 @@
+// Package capture stores full snapshots of selected requests as the WAF saw them, so false positives can be
+// reproduced exactly offline.
+//
+// Snapshots are kept in hourly JSON lines files rather than a database, so the WAF stays a single static binary
+// without an embedded SQL engine. They are only looked up by transaction ID and listed with a few filters, which an
+// in-memory index of the hours within the retention answers, and expire by whole hours, which deleting a file does.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// segmentLayout names the file holding an hour of snapshots
+const segmentLayout = "20060102T15"
+
+type Options struct {
+	// Dir holds the snapshot files. An empty directory disables capture.
+	Dir string
+	// Retention is how long snapshots are kept
+	Retention time.Duration
+	// SampleRate is the fraction of the requests selected by the policy that are captured, from 0 to 1
+	SampleRate float64
+	// Decisions restricts capture to requests with these decisions. Empty captures every decision.
+	Decisions []string
+	// RuleIDs restricts capture to requests that matched one of these rules. Empty captures regardless of rules.
+	RuleIDs []int
+	// MaxBodySize bounds the request body bytes kept per snapshot
+	MaxBodySize int64
+}
+
+// Snapshot is a request as the WAF evaluated it, after header transforms and URI canonicalization
+type Snapshot struct {
+	TransactionID string      `json:"transaction_id"`
+	Time          time.Time   `json:"time"`
+	ClientIP      string      `json:"client_ip"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	Proto         string      `json:"proto"`
+	Host          string      `json:"host"`
+	Headers       http.Header `json:"headers"`
+	// Body is base64 encoded in JSON
+	Body          []byte `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+	Decision      string `json:"decision"`
+	Status        int    `json:"status,omitempty"`
+	RuleIDs       []int  `json:"rule_ids,omitempty"`
+}
+
+// Summary describes a stored snapshot without its headers and body
+type Summary struct {
+	TransactionID string    `json:"transaction_id"`
+	Time          time.Time `json:"time"`
+	ClientIP      string    `json:"client_ip"`
+	Method        string    `json:"method"`
+	URI           string    `json:"uri"`
+	Decision      string    `json:"decision"`
+	RuleIDs       []int     `json:"rule_ids,omitempty"`
+}
+
+// Filter selects the snapshots listed. Empty fields match every snapshot.
+type Filter struct {
+	ClientIP string
+	Decision string
+	RuleID   int
+	Limit    int
+}
+
+type entry struct {
+	summary Summary
+	segment time.Time
+	offset  int64
+	length  int64
+}
+
+// Store appends snapshots to hourly JSON lines files and indexes them in memory. Whole hours are deleted once they
+// fall out of the retention.
+type Store struct {
+	options Options
+	random  func() float64
+	now     func() time.Time
+	paused  atomic.Bool
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	file    *os.File
+	segment time.Time
+	size    int64
+}
+
+// Open opens the store in the options' directory, indexing the snapshots kept from previous runs. Hours already out
+// of the retention are deleted without being read.
+func Open(options Options) (*Store, error) {
+	if err := os.MkdirAll(options.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	s := &Store{options: options, random: rand.Float64, now: time.Now, entries: map[string]*entry{}}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := s.now().Add(-options.Retention)
+	for _, segment := range segments {
+		if !segment.Add(time.Hour).After(cutoff) {
+			continue
+		}
+		if err := s.index(segment); err != nil {
+			return nil, err
+		}
+	}
+	return s, s.expire()
+}
+
+func (s *Store) path(segment time.Time) string {
+	return filepath.Join(s.options.Dir, segment.Format(segmentLayout)+".jsonl")
+}
+
+// segments lists the hours with a snapshot file, oldest first
+func (s *Store) segments() ([]time.Time, error) {
+	files, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list capture directory: %w", err)
+	}
+	var segments []time.Time
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".jsonl")
+		if !ok || file.IsDir() {
+			continue
+		}
+		segment, err := time.Parse(segmentLayout, name)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	slices.SortFunc(segments, func(a, b time.Time) int { return a.Compare(b) })
+	return segments, nil
+}
+
+// index adds the snapshots of a segment file to the index
+func (s *Store) index(segment time.Time) error {
+	file, err := os.Open(s.path(segment))
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var snapshot Snapshot
+			if jsonErr := json.Unmarshal(line, &snapshot); jsonErr == nil {
+				s.entries[snapshot.TransactionID] = &entry{summary: summarize(snapshot), segment: segment, offset: offset, length: int64(len(line))}
+			}
+			offset += int64(len(line))
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read capture file: %w", err)
+		}
+	}
+}
+
+func summarize(snapshot Snapshot) Summary {
+	return Summary{
+		TransactionID: snapshot.TransactionID,
+		Time:          snapshot.Time,
+		ClientIP:      snapshot.ClientIP,
+		Method:        snapshot.Method,
+		URI:           snapshot.URI,
+		Decision:      snapshot.Decision,
+		RuleIDs:       snapshot.RuleIDs,
+	}
+}
+
+// Pause stops or resumes capturing, e.g. while the disk is running out of space
+func (s *Store) Pause(paused bool) {
+	s.paused.Store(paused)
+}
+
+// Wants reports whether a request with the decision and matched rules should be captured
+func (s *Store) Wants(decision string, ruleIDs []int) bool {
+	if s.paused.Load() {
+		return false
+	}
+	if len(s.options.Decisions) > 0 && !slices.Contains(s.options.Decisions, decision) {
+		return false
+	}
+	if len(s.options.RuleIDs) > 0 && !slices.ContainsFunc(ruleIDs, func(id int) bool { return slices.Contains(s.options.RuleIDs, id) }) {
+		return false
+	}
+	return s.random() < s.options.SampleRate
+}
+
+// MaxBodySize is the number of request body bytes kept per snapshot
+func (s *Store) MaxBodySize() int64 {
+	return s.options.MaxBodySize
+}
+
+// Record appends a snapshot to the file of its hour
+func (s *Store) Record(snapshot Snapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		metricCaptures.WithLabelValues("failed").Inc()
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rotate(snapshot.Time.UTC().Truncate(time.Hour)); err != nil {
+		metricCaptures.WithLabelValues("failed").Inc()
+		return err
+	}
+	if _, err := s.file.Write(line); err != nil {
+		metricCaptures.WithLabelValues("failed").Inc()
+		return fmt.Errorf("failed to write capture file: %w", err)
+	}
+	s.entries[snapshot.TransactionID] = &entry{summary: summarize(snapshot), segment: s.segment, offset: s.size, length: int64(len(line))}
+	s.size += int64(len(line))
+	metricCaptures.WithLabelValues("stored").Inc()
+	return nil
+}
+
+// rotate switches to the file of the segment, expiring old segments when a new hour starts
+func (s *Store) rotate(segment time.Time) error {
+	if s.file != nil && segment.Equal(s.segment) {
+		return nil
+	}
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			slog.Warn("Failed to close capture file", "error", err)
+		}
+		s.file = nil
+	}
+	file, err := os.OpenFile(s.path(segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	s.file, s.segment, s.size = file, segment, info.Size()
+	return s.expireLocked()
+}
+
+// Get returns the snapshot of a transaction
+func (s *Store) Get(transactionID string) (Snapshot, bool, error) {
+	s.mu.Lock()
+	e, ok := s.entries[transactionID]
+	s.mu.Unlock()
+	if !ok {
+		return Snapshot{}, false, nil
+	}
+
+	file, err := os.Open(s.path(e.segment))
+	if errors.Is(err, os.ErrNotExist) {
+		// Expired since it was looked up
+		return Snapshot{}, false, nil
+	}
+	if err != nil {
+		return Snapshot{}, false, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+	line := make([]byte, e.length)
+	if _, err := file.ReadAt(line, e.offset); err != nil {
+		return Snapshot{}, false, fmt.Errorf("failed to read capture file: %w", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(line, &snapshot); err != nil {
+		return Snapshot{}, false, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return snapshot, true, nil
+}
+
+// List returns the summaries of the snapshots matching the filter, newest first
+func (s *Store) List(filter Filter) []Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := []Summary{}
+	for _, e := range s.entries {
+		summary := e.summary
+		if filter.ClientIP != "" && summary.ClientIP != filter.ClientIP ||
+			filter.Decision != "" && summary.Decision != filter.Decision ||
+			filter.RuleID != 0 && !slices.Contains(summary.RuleIDs, filter.RuleID) {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Time.After(summaries[j].Time) })
+	if filter.Limit > 0 && len(summaries) > filter.Limit {
+		summaries = summaries[:filter.Limit]
+	}
+	return summaries
+}
+
+func (s *Store) expire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expireLocked()
+}
+
+// expireLocked deletes the segments whose whole hour is older than the retention
+func (s *Store) expireLocked() error {
+	cutoff := s.now().Add(-s.options.Retention)
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	expired := map[time.Time]bool{}
+	for _, segment := range segments {
+		if segment.Add(time.Hour).After(cutoff) || (s.file != nil && segment.Equal(s.segment)) {
+			continue
+		}
+		if err := os.Remove(s.path(segment)); err != nil {
+			return fmt.Errorf("failed to delete capture file: %w", err)
+		}
+		expired[segment] = true
+	}
+	for id, e := range s.entries {
+		if expired[e.segment] {
+			delete(s.entries, id)
+		}
+	}
+	return nil
+}
+
+// Close closes the file being appended to
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,96 @@
+package capture
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	options := Options{Dir: dir, Retention: 2 * time.Hour, SampleRate: 1}
+	store, err := Open(options)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Record(Snapshot{TransactionID: "old", Time: now.Add(-3 * time.Hour), ClientIP: "192.0.2.1", Decision: "deny"}))
+	require.NoError(t, store.Record(Snapshot{TransactionID: "recent", Time: now.Add(-time.Hour), ClientIP: "192.0.2.1", Decision: "deny", RuleIDs: []int{942100}}))
+	require.NoError(t, store.Record(Snapshot{TransactionID: "current", Time: now, ClientIP: "192.0.2.2", Decision: "allow", Body: []byte("a=1")}))
+
+	t.Run("Should get a snapshot by transaction ID", func(t *testing.T) {
+		snapshot, ok, err := store.Get("current")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "a=1", string(snapshot.Body))
+		assert.Equal(t, "192.0.2.2", snapshot.ClientIP)
+	})
+
+	t.Run("Should delete the hours older than the retention", func(t *testing.T) {
+		_, ok, err := store.Get("old")
+		require.NoError(t, err)
+		assert.False(t, ok)
+		_, err = os.Stat(store.path(now.Add(-3 * time.Hour).Truncate(time.Hour)))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Should list summaries matching the filter, newest first", func(t *testing.T) {
+		assert.Equal(t, []string{"current", "recent"}, ids(store.List(Filter{})))
+		assert.Equal(t, []string{"recent"}, ids(store.List(Filter{ClientIP: "192.0.2.1"})))
+		assert.Equal(t, []string{"recent"}, ids(store.List(Filter{RuleID: 942100})))
+		assert.Equal(t, []string{"current"}, ids(store.List(Filter{Decision: "allow"})))
+		assert.Equal(t, []string{"current"}, ids(store.List(Filter{Limit: 1})))
+	})
+
+	t.Run("Should index the snapshots of previous runs", func(t *testing.T) {
+		require.NoError(t, store.Close())
+		reopened, err := Open(Options{Dir: dir, Retention: 24 * time.Hour, SampleRate: 1})
+		require.NoError(t, err)
+		defer reopened.Close()
+		snapshot, ok, err := reopened.Get("recent")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, []int{942100}, snapshot.RuleIDs)
+		assert.Len(t, reopened.List(Filter{}), 2)
+	})
+}
+
+func ids(summaries []Summary) []string {
+	var ids []string
+	for _, summary := range summaries {
+		ids = append(ids, summary.TransactionID)
+	}
+	return ids
+}
+
+func TestStoreWants(t *testing.T) {
+	store := &Store{options: Options{Decisions: []string{"deny"}, RuleIDs: []int{942100, 941100}, SampleRate: 0.5}}
+	store.random = func() float64 { return 0.2 }
+	assert.True(t, store.Wants("deny", []int{920350, 942100}))
+	assert.False(t, store.Wants("allow", []int{942100}), "Expected other decisions to be skipped")
+	assert.False(t, store.Wants("deny", []int{920350}), "Expected requests without a listed rule to be skipped")
+
+	store.random = func() float64 { return 0.7 }
+	assert.False(t, store.Wants("deny", []int{942100}), "Expected requests outside the sample to be skipped")
+
+	store.random = func() float64 { return 0.2 }
+	store.Pause(true)
+	assert.False(t, store.Wants("deny", []int{942100}), "Expected nothing to be captured while paused")
+	store.Pause(false)
+	assert.True(t, store.Wants("deny", []int{942100}))
+}
+
+func TestBody(t *testing.T) {
+	body := NewBody(io.NopCloser(strings.NewReader("0123456789")), 4)
+	read, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(read), "Expected the reader to pass the whole body through")
+
+	data, truncated := body.Bytes()
+	assert.Equal(t, "0123", string(data))
+	assert.True(t, truncated)
+}
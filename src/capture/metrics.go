@@ -0,0 +1,9 @@
+package capture
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricCaptures = metrics.NewCounterVec(
+	"waf_captures_total",
+	"The total number of request snapshots captured by result (stored, failed)",
+	[]string{"result"},
+)
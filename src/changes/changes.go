@@ -0,0 +1,119 @@
+package changes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecent is the number of changes kept in memory for the admin API
+const maxRecent = 1000
+
+// Change is a single runtime configuration mutation
+type Change struct {
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// Trail records configuration changes to an append-only JSON lines file for change-management compliance.
+// A trail without a path keeps the recent changes in memory only.
+type Trail struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	recent []Change
+}
+
+// Open opens the trail at path, loading the most recent changes already recorded
+func Open(path string) (*Trail, error) {
+	t := &Trail{now: time.Now}
+	if path == "" {
+		return t, nil
+	}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10<<20)
+		for scanner.Scan() {
+			var change Change
+			if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+				continue
+			}
+			t.remember(change)
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read change trail: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read change trail: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change trail: %w", err)
+	}
+	t.file = file
+	return t, nil
+}
+
+// Record appends the change to the trail, stamping it with the current time
+func (t *Trail) Record(change Change) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	change.Time = t.now().UTC()
+	t.remember(change)
+	if t.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if _, err := t.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write change trail: %w", err)
+	}
+	return t.file.Sync()
+}
+
+// Recent returns up to n of the most recent changes, newest first
+func (t *Trail) Recent(n int) []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n = min(n, len(t.recent))
+	recent := make([]Change, 0, n)
+	for i := len(t.recent) - 1; i >= len(t.recent)-n; i-- {
+		recent = append(recent, t.recent[i])
+	}
+	return recent
+}
+
+// Close closes the trail file
+func (t *Trail) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+func (t *Trail) remember(change Change) {
+	t.recent = append(t.recent, change)
+	if len(t.recent) > maxRecent {
+		t.recent = t.recent[len(t.recent)-maxRecent:]
+	}
+}
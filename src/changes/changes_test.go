@@ -0,0 +1,48 @@
+package changes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.log")
+
+	trail, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, trail.Record(Change{Actor: "alice", Action: "objects.put", Target: "iplists/office", After: json.RawMessage(`["192.0.2.0/24"]`)}))
+	require.NoError(t, trail.Record(Change{Actor: "bob", Action: "objects.delete", Target: "iplists/office", Before: json.RawMessage(`["192.0.2.0/24"]`)}))
+	require.NoError(t, trail.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 2)
+
+	t.Run("Should reload recorded changes and keep appending", func(t *testing.T) {
+		trail, err := Open(path)
+		require.NoError(t, err)
+		defer trail.Close()
+
+		require.NoError(t, trail.Record(Change{Actor: "carol", Action: "bans.delete", Target: "192.0.2.1"}))
+
+		recent := trail.Recent(2)
+		require.Len(t, recent, 2)
+		assert.Equal(t, "carol", recent[0].Actor)
+		assert.Equal(t, "bob", recent[1].Actor)
+		assert.Len(t, trail.Recent(10), 3)
+	})
+
+	t.Run("Should keep changes in memory without a path", func(t *testing.T) {
+		trail, err := Open("")
+		require.NoError(t, err)
+		require.NoError(t, trail.Record(Change{Action: "directives.rollback"}))
+		assert.Len(t, trail.Recent(10), 1)
+		assert.False(t, trail.Recent(10)[0].Time.IsZero())
+	})
+}
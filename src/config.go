@@ -0,0 +1,1338 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/admin"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/alert"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/aws"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/leader"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/listener"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/mirror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/nats"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/oidc"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rdns"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/report"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/sentry"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+)
+
+var (
+	expirationStr            = getEnvOrDefault("AUDIT_LOG_EXPIRATION", "24h")
+	expirationJobIntervalStr = getEnvOrDefault("AUDIT_LOG_EXPIRATION_JOB_INTERVAL", "1h")
+	processingJobIntervalStr = getEnvOrDefault("AUDIT_LOG_PROCESSING_JOB_INTERVAL", "10s")
+	auditLogPath             = getEnvOrDefault("AUDIT_LOG_PATH", "/var/log/coraza-audit.log")
+	auditLogStorage          = getEnvOrDefault("AUDIT_LOG_STORAGE", audit.StorageAuto)
+	auditLogDirModeStr       = getEnvOrDefault("AUDIT_LOG_DIR_MODE", "0750")
+	auditLogWatchStr         = getEnvOrDefault("AUDIT_LOG_WATCH", "true")
+	auditLogWatchDelayStr    = getEnvOrDefault("AUDIT_LOG_WATCH_DELAY", "200ms")
+	auditLogChunkLinesStr    = getEnvOrDefault("AUDIT_LOG_PROCESSING_CHUNK_LINES", "1000")
+	auditLogChunkPauseStr    = getEnvOrDefault("AUDIT_LOG_PROCESSING_CHUNK_PAUSE", "0s")
+	auditLogMaxReadRateStr   = getEnvOrDefault("AUDIT_LOG_PROCESSING_MAX_BYTES_PER_SECOND", "0")
+	auditLogMaxLineBytesStr  = getEnvOrDefault("AUDIT_LOG_MAX_LINE_BYTES", "1048576")
+	auditLogDirOwner         = getEnvOrDefault("AUDIT_LOG_DIR_OWNER", "")
+	auditLogSigningKey       = getEnvOrDefault("AUDIT_LOG_SIGNING_KEY", "")
+	auditLogEncryptionKey    = getEnvOrDefault("AUDIT_LOG_ENCRYPTION_KEY", "")
+	auditLogAsyncBufferStr   = getEnvOrDefault("AUDIT_LOG_ASYNC_BUFFER_LINES", "0")
+	auditLogPerReplicaStr    = getEnvOrDefault("AUDIT_LOG_PER_REPLICA", "false")
+	auditLogOrphanAfterStr   = getEnvOrDefault("AUDIT_LOG_ORPHAN_AFTER", "15m")
+	auditDiskGuardMaxBytes   = getEnvOrDefault("AUDIT_DISK_GUARD_MAX_BYTES", "0")
+	auditDiskGuardMinFree    = getEnvOrDefault("AUDIT_DISK_GUARD_MIN_FREE_RATIO", "0")
+	auditDiskGuardInterval   = getEnvOrDefault("AUDIT_DISK_GUARD_INTERVAL", "30s")
+	logLevel                 = getEnvOrDefault("LOG_LEVEL", "info")
+	logLevelRevertAfterStr   = getEnvOrDefault("LOG_LEVEL_REVERT_AFTER", "15m")
+	logOutput                = getEnvOrDefault("LOG_OUTPUT", "stdout")
+	logFormat                = getEnvOrDefault("LOG_FORMAT", "json")
+	accessLogOutput          = getEnvOrDefault("ACCESS_LOG_OUTPUT", "")
+	accessLogFormat          = getEnvOrDefault("ACCESS_LOG_FORMAT", "json")
+	accessLogLevelStr        = getEnvOrDefault("ACCESS_LOG_LEVEL", "debug")
+	accessLogTXFieldsStr     = getEnvOrDefault("ACCESS_LOG_TX_FIELDS", "")
+	requestTagPrefix         = getEnvOrDefault("REQUEST_TAG_PREFIX", "request-tag:")
+	requestTagHeader         = getEnvOrDefault("REQUEST_TAG_HEADER", "X-Waf-Tags")
+	securityLogOutput        = getEnvOrDefault("SECURITY_LOG_OUTPUT", "")
+	securityLogFormat        = getEnvOrDefault("SECURITY_LOG_FORMAT", "json")
+	securityLogLevelStr      = getEnvOrDefault("SECURITY_LOG_LEVEL", "info")
+	wafPort                  = getEnvOrDefault("WAF_PORT", "8080")
+	wafListenersStr          = getEnvOrDefault("WAF_LISTENERS", "")
+	adminPort                = getEnvOrDefault("ADMIN_PORT", "8081")
+	failureModeStr           = getEnvOrDefault("FAILURE_MODE", "closed")
+	failureModePanicStr      = getEnvOrDefault("FAILURE_MODE_PANIC", "")
+	failureModeBodyReadStr   = getEnvOrDefault("FAILURE_MODE_BODY_READ", "")
+	failureModeWebhookStr    = getEnvOrDefault("FAILURE_MODE_DECISION_WEBHOOK", "")
+	failureModeScoringStr    = getEnvOrDefault("FAILURE_MODE_SCORING", "")
+	failureModeOPAStr        = getEnvOrDefault("FAILURE_MODE_OPA", "")
+	failureModeScriptStr     = getEnvOrDefault("FAILURE_MODE_SCRIPT", "")
+	failureModeDeadlineStr   = getEnvOrDefault("FAILURE_MODE_DEADLINE", "")
+	deadlineHeader           = getEnvOrDefault("DEADLINE_HEADER", "")
+	deadlineDefaultStr       = getEnvOrDefault("DEADLINE_DEFAULT", "0s")
+	deadlineMarginStr        = getEnvOrDefault("DEADLINE_MARGIN", "50ms")
+	headersRemoveStr         = getEnvOrDefault("HEADERS_REMOVE", "")
+	headersRenameStr         = getEnvOrDefault("HEADERS_RENAME", "")
+	headersSetStr            = getEnvOrDefault("HEADERS_SET", "")
+	canonicalizeModeStr      = getEnvOrDefault("CANONICALIZE_MODE", "off")
+	allowedMethodsStr        = getEnvOrDefault("ALLOWED_METHODS", "")
+	allowedMethodRoutesStr   = getEnvOrDefault("ALLOWED_METHODS_ROUTES", "")
+	allowedHTTPVersionsStr   = getEnvOrDefault("ALLOWED_HTTP_VERSIONS", "")
+	maxURLLengthStr          = getEnvOrDefault("MAX_URL_LENGTH", "8192")
+	maxHeaderCountStr        = getEnvOrDefault("MAX_HEADER_COUNT", "100")
+	maxHeaderBytesStr        = getEnvOrDefault("MAX_HEADER_BYTES", "65536")
+	maxCookieCountStr        = getEnvOrDefault("MAX_COOKIE_COUNT", "100")
+	maxQueryParamsStr        = getEnvOrDefault("MAX_QUERY_PARAMS", "512")
+	minReadRateStr           = getEnvOrDefault("MIN_READ_RATE", "0")
+	readRateGracePeriodStr   = getEnvOrDefault("MIN_READ_RATE_GRACE_PERIOD", "5s")
+	storePath                = getEnvOrDefault("STORE_PATH", "")
+	oidcIssuerURL            = getEnvOrDefault("OIDC_ISSUER_URL", "")
+	oidcClientID             = getEnvOrDefault("OIDC_CLIENT_ID", "")
+	oidcClientSecret         = getEnvOrDefault("OIDC_CLIENT_SECRET", "")
+	oidcRedirectURL          = getEnvOrDefault("OIDC_REDIRECT_URL", "")
+	oidcScopesStr            = getEnvOrDefault("OIDC_SCOPES", "email,profile")
+	oidcGroupsClaim          = getEnvOrDefault("OIDC_GROUPS_CLAIM", "groups")
+	oidcViewerGroupsStr      = getEnvOrDefault("OIDC_VIEWER_GROUPS", "")
+	oidcOperatorGroupsStr    = getEnvOrDefault("OIDC_OPERATOR_GROUPS", "")
+	oidcAdminGroupsStr       = getEnvOrDefault("OIDC_ADMIN_GROUPS", "")
+	oidcSessionKey           = getEnvOrDefault("OIDC_SESSION_KEY", "")
+	oidcSessionTTLStr        = getEnvOrDefault("OIDC_SESSION_TTL", "8h")
+	adminRoutePolicyStr      = getEnvOrDefault("ADMIN_ROUTE_POLICY", "")
+	adminAnonymousRoleStr    = getEnvOrDefault("ADMIN_ANONYMOUS_ROLE", "admin")
+	adminRateLimitStr        = getEnvOrDefault("ADMIN_RATE_LIMIT", "1")
+	adminRateLimitBurstStr   = getEnvOrDefault("ADMIN_RATE_LIMIT_BURST", "10")
+	adminLockoutFailuresStr  = getEnvOrDefault("ADMIN_LOCKOUT_FAILURES", "10")
+	adminLockoutWindowStr    = getEnvOrDefault("ADMIN_LOCKOUT_WINDOW", "5m")
+	adminLockoutDurationStr  = getEnvOrDefault("ADMIN_LOCKOUT_DURATION", "15m")
+	logSinkAggregationStr    = getEnvOrDefault("AUDIT_LOG_SINK_AGGREGATION_WINDOW", "0s")
+	summaryJobIntervalStr    = getEnvOrDefault("SUMMARY_JOB_INTERVAL", "1h")
+	summaryWindowsStr        = getEnvOrDefault("SUMMARY_WINDOWS", "1h,24h")
+	summaryTopNStr           = getEnvOrDefault("SUMMARY_TOP_N", "10")
+	ftwTestsDir              = getEnvOrDefault("FTW_TESTS_DIR", "")
+	changeLogPath            = getEnvOrDefault("CHANGE_LOG_PATH", "")
+	adminAuditLogPath        = getEnvOrDefault("ADMIN_AUDIT_LOG_PATH", "")
+	adminAuditLogKey         = getEnvOrDefault("ADMIN_AUDIT_LOG_KEY", "")
+	directivesHistoryDir     = getEnvOrDefault("DIRECTIVES_HISTORY_DIR", "")
+	directivesHistorySizeStr = getEnvOrDefault("DIRECTIVES_HISTORY_SIZE", "10")
+	denyStatusStr            = getEnvOrDefault("DENY_STATUS", "0")
+	denyHeadersStr           = getEnvOrDefault("DENY_HEADERS", "")
+	denyStatusMapStr         = getEnvOrDefault("DENY_STATUS_MAP", "")
+	denyActionMapStr         = getEnvOrDefault("DENY_ACTION_MAP", "")
+	denyTarpitDelayStr       = getEnvOrDefault("DENY_TARPIT_DELAY", "10s")
+	denyDecoyStatusStr       = getEnvOrDefault("DENY_DECOY_STATUS", "404")
+	denyRedirectURL          = getEnvOrDefault("DENY_REDIRECT_URL", "")
+	denyAppealURL            = getEnvOrDefault("DENY_APPEAL_URL", "")
+	denyAppealEmail          = getEnvOrDefault("DENY_APPEAL_EMAIL", "")
+	cookieSecret             = getEnvOrDefault("COOKIE_INTEGRITY_SECRET", "")
+	cookieNamesStr           = getEnvOrDefault("COOKIE_INTEGRITY_COOKIES", "")
+	cookieModeStr            = getEnvOrDefault("COOKIE_INTEGRITY_MODE", "flag")
+	csrfPathsStr             = getEnvOrDefault("CSRF_PATHS", "")
+	csrfExemptMethodsStr     = getEnvOrDefault("CSRF_EXEMPT_METHODS", "GET,HEAD,OPTIONS,TRACE")
+	csrfCookieName           = getEnvOrDefault("CSRF_COOKIE_NAME", "csrf_token")
+	csrfHeaderName           = getEnvOrDefault("CSRF_HEADER_NAME", "X-CSRF-Token")
+	honeypotPathsStr         = getEnvOrDefault("HONEYPOT_PATHS", "")
+	honeypotBanDurationStr   = getEnvOrDefault("HONEYPOT_BAN_DURATION", "1h")
+	mirrorURL                = getEnvOrDefault("MIRROR_URL", "")
+	mirrorPercentStr         = getEnvOrDefault("MIRROR_PERCENT", "100")
+	mirrorTimeoutStr         = getEnvOrDefault("MIRROR_TIMEOUT", "5s")
+	mirrorStripHeadersStr    = getEnvOrDefault("MIRROR_STRIP_HEADERS", "")
+	debugIPsStr              = getEnvOrDefault("DEBUG_IPS", "")
+	debugSecret              = getEnvOrDefault("DEBUG_SECRET", "")
+	debugHeader              = getEnvOrDefault("DEBUG_HEADER", "X-WAF-Debug")
+	debugTraceSizeStr        = getEnvOrDefault("DEBUG_TRACE_SIZE", "100")
+	debugLogInitialStr       = getEnvOrDefault("DEBUG_LOG_SAMPLE_INITIAL", "100")
+	debugLogThereafterStr    = getEnvOrDefault("DEBUG_LOG_SAMPLE_THEREAFTER", "100")
+	captureDir               = getEnvOrDefault("CAPTURE_DIR", "")
+	captureRetentionStr      = getEnvOrDefault("CAPTURE_RETENTION", "24h")
+	captureSampleRateStr     = getEnvOrDefault("CAPTURE_SAMPLE_RATE", "1")
+	captureDecisionsStr      = getEnvOrDefault("CAPTURE_DECISIONS", "deny")
+	captureRuleIDsStr        = getEnvOrDefault("CAPTURE_RULE_IDS", "")
+	captureMaxBodySizeStr    = getEnvOrDefault("CAPTURE_MAX_BODY_SIZE", "65536")
+	eventStoreDir            = getEnvOrDefault("EVENT_STORE_DIR", "")
+	tenantHeader             = getEnvOrDefault("TENANT_HEADER", "")
+	tenantMonthlyQuotasStr   = getEnvOrDefault("TENANT_MONTHLY_QUOTAS", "")
+	tenantMaxStr             = getEnvOrDefault("TENANT_MAX", "100")
+	tenantUsagePath          = getEnvOrDefault("TENANT_USAGE_PATH", "")
+	tenantSaveIntervalStr    = getEnvOrDefault("TENANT_USAGE_SAVE_INTERVAL", "1m")
+	reverseDNSStr            = getEnvOrDefault("REVERSE_DNS", "false")
+	reverseDNSTimeoutStr     = getEnvOrDefault("REVERSE_DNS_TIMEOUT", "2s")
+	reverseDNSCacheTTLStr    = getEnvOrDefault("REVERSE_DNS_CACHE_TTL", "1h")
+	reverseDNSNegativeTTLStr = getEnvOrDefault("REVERSE_DNS_NEGATIVE_CACHE_TTL", "5m")
+	reverseDNSCacheSizeStr   = getEnvOrDefault("REVERSE_DNS_CACHE_SIZE", "10000")
+	reverseDNSConcurrencyStr = getEnvOrDefault("REVERSE_DNS_CONCURRENCY", "16")
+	verifiedCrawlerHeader    = getEnvOrDefault("VERIFIED_CRAWLER_HEADER", middleware.DefaultVerifiedCrawlerHeader)
+	asnDatabase              = getEnvOrDefault("ASN_DATABASE", "")
+	asnPoliciesStr           = getEnvOrDefault("ASN_POLICIES", "")
+	webhookEndpointsStr      = getEnvOrDefault("WEBHOOK_ENDPOINTS", "")
+	threatIntelFeedsStr      = getEnvOrDefault("THREAT_INTEL_FEEDS", "")
+	threatIntelIntervalStr   = getEnvOrDefault("THREAT_INTEL_INTERVAL", "15m")
+	threatIntelMaxAgeStr     = getEnvOrDefault("THREAT_INTEL_MAX_AGE", "720h")
+	threatIntelTimeoutStr    = getEnvOrDefault("THREAT_INTEL_TIMEOUT", "30s")
+	threatIntelHeader        = getEnvOrDefault("THREAT_INTEL_HEADER", middleware.DefaultThreatIntelHeader)
+	webhookToleranceStr      = getEnvOrDefault("WEBHOOK_TIMESTAMP_TOLERANCE", "5m")
+	webhookNonceCacheSizeStr = getEnvOrDefault("WEBHOOK_NONCE_CACHE_SIZE", "10000")
+	webhookMaxBodySizeStr    = getEnvOrDefault("WEBHOOK_MAX_BODY_SIZE", "1048576")
+	eventStoreRetentionStr   = getEnvOrDefault("EVENT_STORE_RETENTION", "720h")
+	eventStoreClassRetention = getEnvOrDefault("EVENT_STORE_CLASS_RETENTION", "")
+	eventStoreMaxEventsStr   = getEnvOrDefault("EVENT_STORE_MAX_EVENTS", strconv.Itoa(events.DefaultMaxEvents))
+	warmupRoundsStr          = getEnvOrDefault("WARMUP_ROUNDS", "3")
+	decisionWebhookURL       = getEnvOrDefault("DECISION_WEBHOOK_URL", "")
+	decisionWebhookTimeout   = getEnvOrDefault("DECISION_WEBHOOK_TIMEOUT", "1s")
+	decisionWebhookHeaders   = getEnvOrDefault("DECISION_WEBHOOK_HEADERS", "")
+	opaURL                   = getEnvOrDefault("OPA_URL", "")
+	opaTimeoutStr            = getEnvOrDefault("OPA_TIMEOUT", "500ms")
+	opaHeadersStr            = getEnvOrDefault("OPA_HEADERS", "")
+	scoringURL               = getEnvOrDefault("SCORING_URL", "")
+	scoringTimeoutStr        = getEnvOrDefault("SCORING_TIMEOUT", "200ms")
+	scoringWeightStr         = getEnvOrDefault("SCORING_WEIGHT", "0.5")
+	scoringAnomalyThreshold  = getEnvOrDefault("SCORING_ANOMALY_THRESHOLD", "5")
+	scoringThresholdStr      = getEnvOrDefault("SCORING_THRESHOLD", "0.8")
+	scoringShadowStr         = getEnvOrDefault("SCORING_SHADOW", "true")
+	scriptPath               = getEnvOrDefault("SCRIPT_PATH", "")
+	scriptTimeoutStr         = getEnvOrDefault("SCRIPT_TIMEOUT", "50ms")
+	scriptMaxStackSizeStr    = getEnvOrDefault("SCRIPT_MAX_STACK_SIZE", "65536")
+	outboundMaxIdleStr       = getEnvOrDefault("OUTBOUND_MAX_IDLE_CONNS", "100")
+	outboundMaxIdleHostStr   = getEnvOrDefault("OUTBOUND_MAX_IDLE_CONNS_PER_HOST", "32")
+	outboundMaxConnsHostStr  = getEnvOrDefault("OUTBOUND_MAX_CONNS_PER_HOST", "0")
+	outboundIdleTimeoutStr   = getEnvOrDefault("OUTBOUND_IDLE_CONN_TIMEOUT", "90s")
+	outboundCAFile           = getEnvOrDefault("OUTBOUND_CA_FILE", "")
+	outboundHTTP2Str         = getEnvOrDefault("OUTBOUND_HTTP2", "true")
+	lokiURL                  = getEnvOrDefault("LOKI_URL", "")
+	lokiLabelsStr            = getEnvOrDefault("LOKI_LABELS", "host,rule_family,severity")
+	lokiStaticLabelsStr      = getEnvOrDefault("LOKI_STATIC_LABELS", "job=coraza-waf")
+	lokiTenantID             = getEnvOrDefault("LOKI_TENANT_ID", "")
+	lokiBatchSizeStr         = getEnvOrDefault("LOKI_BATCH_SIZE", "500")
+	lokiTimeoutStr           = getEnvOrDefault("LOKI_TIMEOUT", "10s")
+	lokiRetryAttemptsStr     = getEnvOrDefault("LOKI_RETRY_ATTEMPTS", "3")
+	lokiRetryBackoffStr      = getEnvOrDefault("LOKI_RETRY_BACKOFF", "1s")
+	fluentAddress            = getEnvOrDefault("FLUENT_ADDRESS", "")
+	fluentTag                = getEnvOrDefault("FLUENT_TAG", "coraza.violations")
+	fluentAckStr             = getEnvOrDefault("FLUENT_ACK", "true")
+	fluentBatchSizeStr       = getEnvOrDefault("FLUENT_BATCH_SIZE", "500")
+	fluentTimeoutStr         = getEnvOrDefault("FLUENT_TIMEOUT", "10s")
+	fluentRetryAttemptsStr   = getEnvOrDefault("FLUENT_RETRY_ATTEMPTS", "3")
+	fluentRetryBackoffStr    = getEnvOrDefault("FLUENT_RETRY_BACKOFF", "1s")
+	cloudWatchLogGroup       = getEnvOrDefault("CLOUDWATCH_LOG_GROUP", "")
+	cloudWatchLogStream      = getEnvOrDefault("CLOUDWATCH_LOG_STREAM", hostname())
+	cloudWatchNamespace      = getEnvOrDefault("CLOUDWATCH_METRICS_NAMESPACE", "CorazaWAF")
+	cloudWatchRegion         = getEnvOrDefault("CLOUDWATCH_REGION", aws.Region())
+	cloudWatchEndpoint       = getEnvOrDefault("CLOUDWATCH_ENDPOINT", "")
+	cloudWatchBatchSizeStr   = getEnvOrDefault("CLOUDWATCH_BATCH_SIZE", "1000")
+	cloudWatchTimeoutStr     = getEnvOrDefault("CLOUDWATCH_TIMEOUT", "10s")
+	cloudWatchRetryAttempts  = getEnvOrDefault("CLOUDWATCH_RETRY_ATTEMPTS", "3")
+	cloudWatchRetryBackoff   = getEnvOrDefault("CLOUDWATCH_RETRY_BACKOFF", "1s")
+	gcpProjectID             = getEnvOrDefault("GCP_PROJECT_ID", "")
+	gcpLogName               = getEnvOrDefault("GCP_LOG_NAME", "")
+	gcpPubSubTopic           = getEnvOrDefault("GCP_PUBSUB_TOPIC", "")
+	gcpBatchSizeStr          = getEnvOrDefault("GCP_BATCH_SIZE", "500")
+	gcpTimeoutStr            = getEnvOrDefault("GCP_TIMEOUT", "10s")
+	gcpRetryAttemptsStr      = getEnvOrDefault("GCP_RETRY_ATTEMPTS", "3")
+	gcpRetryBackoffStr       = getEnvOrDefault("GCP_RETRY_BACKOFF", "1s")
+	sentryDSN                = getEnvOrDefault("SENTRY_DSN", "")
+	sentryEnvironment        = getEnvOrDefault("SENTRY_ENVIRONMENT", "")
+	sentryRelease            = getEnvOrDefault("SENTRY_RELEASE", "")
+	sentryTimeoutStr         = getEnvOrDefault("SENTRY_TIMEOUT", "5s")
+	sentryQueueSizeStr       = getEnvOrDefault("SENTRY_QUEUE_SIZE", "100")
+	alertRulesFile           = getEnvOrDefault("ALERT_RULES_FILE", "")
+	alertTimeoutStr          = getEnvOrDefault("ALERT_TIMEOUT", "10s")
+	reportSchedule           = getEnvOrDefault("REPORT_SCHEDULE", "")
+	reportTimeStr            = getEnvOrDefault("REPORT_TIME", "08:00")
+	reportTopNStr            = getEnvOrDefault("REPORT_TOP_N", "10")
+	reportWebhookURL         = getEnvOrDefault("REPORT_WEBHOOK_URL", "")
+	reportSMTPAddress        = getEnvOrDefault("REPORT_SMTP_ADDRESS", "")
+	reportSMTPUsername       = getEnvOrDefault("REPORT_SMTP_USERNAME", "")
+	reportSMTPPassword       = getEnvOrDefault("REPORT_SMTP_PASSWORD", "")
+	reportEmailFrom          = getEnvOrDefault("REPORT_EMAIL_FROM", "")
+	reportEmailToStr         = getEnvOrDefault("REPORT_EMAIL_TO", "")
+	reportTimeoutStr         = getEnvOrDefault("REPORT_TIMEOUT", "10s")
+	leaderElection           = getEnvOrDefault("LEADER_ELECTION", "")
+	leaderElectionName       = getEnvOrDefault("LEADER_ELECTION_NAME", leader.DefaultName)
+	leaderElectionNamespace  = getEnvOrDefault("LEADER_ELECTION_NAMESPACE", "")
+	leaderElectionRedisURL   = getEnvOrDefault("LEADER_ELECTION_REDIS_URL", "")
+	leaderLeaseDurationStr   = getEnvOrDefault("LEADER_ELECTION_LEASE_DURATION", "15s")
+	leaderRenewIntervalStr   = getEnvOrDefault("LEADER_ELECTION_RENEW_INTERVAL", "5s")
+	natsURL                  = getEnvOrDefault("NATS_URL", "")
+	natsToken                = getEnvOrDefault("NATS_TOKEN", "")
+	natsAuditSubject         = getEnvOrDefault("NATS_AUDIT_SUBJECT", "waf.audit")
+	natsDecisionSubject      = getEnvOrDefault("NATS_DECISION_SUBJECT", "")
+	natsJetStreamStr         = getEnvOrDefault("NATS_JETSTREAM", "false")
+	natsTimeoutStr           = getEnvOrDefault("NATS_TIMEOUT", "5s")
+	natsQueueSizeStr         = getEnvOrDefault("NATS_QUEUE_SIZE", "10000")
+)
+
+// config is the fully parsed application configuration
+type config struct {
+	WAFPort string
+	// WAFListeners are additional WAF listeners, each with its own directives
+	WAFListeners []wafListener
+	AdminPort    string
+	LogLevel     slog.Level
+	// LogLevelRevertAfter is how long a log level changed at runtime lasts. Zero keeps it until reset.
+	LogLevelRevertAfter time.Duration
+	// Log is the application log. Its level is set at runtime from LogLevel.
+	Log logging.StreamOptions
+	// AccessLog and SecurityLog write to the application log when their output is empty
+	AccessLog         logging.StreamOptions
+	SecurityLog       logging.StreamOptions
+	AuditLogProcessor audit.AuditLogProcessorOptions
+	WAFHandler        coraza.WAFHandlerOptions
+	Guard             listener.GuardOptions
+	StorePath         string
+	// OIDC requires a login for the admin API when its issuer is set
+	OIDC oidc.Options
+	// AdminRoutePolicy overrides the role required by admin API routes
+	AdminRoutePolicy rbac.Policy
+	// AdminAnonymousRole is the role of admin API callers when there is no login
+	AdminAnonymousRole rbac.Role
+	// AdminRateLimit limits the mutating admin API calls of each client and locks out clients failing to authenticate
+	AdminRateLimit admin.RateLimitOptions
+	// LogSinkAggregationWindow groups identical violations written to the application log. Zero disables aggregation.
+	LogSinkAggregationWindow time.Duration
+	SummaryJobInterval       time.Duration
+	SummaryWindows           []time.Duration
+	SummaryTopN              int
+	// FTWTestsDir overrides the embedded CRS regression tests run by the FTW endpoint
+	FTWTestsDir string
+	// ChangeLogPath is the append-only file recording configuration changes made through the admin API
+	ChangeLogPath string
+	// AdminAuditLogPath is the hash-chained file recording every admin API call, keyed with AdminAuditLogKey
+	AdminAuditLogPath string
+	AdminAuditLogKey  []byte
+	Mirror            mirror.MirrorOptions
+	Debug             coraza.DebugOptions
+	// Capture stores snapshots of selected requests when its directory is set
+	Capture capture.Options
+	// Tenants accounts the requests of each tenant when its header is set
+	Tenants middleware.TenantOptions
+	// ReverseDNS resolves client IPs, adding their hostname to audit events and verifying crawlers, when enabled
+	ReverseDNS        rdns.Options
+	ReverseDNSEnabled bool
+	// ASNDatabase is the MaxMind DB file the ASN policies look clients up in
+	ASNDatabase string
+	ASNPolicies []middleware.ASNPolicy
+	// Webhooks verifies the signatures of calls to the webhook endpoints, when there are any
+	Webhooks middleware.WebhookOptions
+	// ThreatIntel pulls the indicators requests are matched against, when there are feeds
+	ThreatIntel threatintel.Options
+	// Events stores the processed violation events for the admin API when its directory is set
+	Events   events.Options
+	Script   script.Options
+	Outbound outbound.Options
+	// Loki ships violation events to Grafana Loki when its URL is set
+	Loki audit.LokiOptions
+	// Fluent forwards violation events to Fluentd or Fluent Bit when its address is set
+	Fluent audit.FluentOptions
+	// CloudWatch ships violation events and metrics to CloudWatch Logs when its log group is set
+	CloudWatch audit.CloudWatchOptions
+	// GoogleCloud writes violation events to Cloud Logging and Pub/Sub when a log name or topic is set
+	GoogleCloud audit.GoogleCloudOptions
+	// NATS publishes violation events and decisions when its URL is set
+	NATS nats.Options
+	// Sentry receives the errors of the WAF itself when its DSN is set
+	Sentry sentry.Options
+	// Alert evaluates threshold rules over violations when a rules file is set
+	Alert alert.Options
+	// Report sends a daily or weekly summary by email or webhook when its schedule is set
+	Report report.Options
+	// Leader elects the replica running the cluster-wide jobs when its backend is set
+	Leader leader.Options
+}
+
+func getEnvOrDefault(envVar string, defaultValue string) string {
+	if value := os.Getenv(envVar); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// hostname identifies this replica, e.g. the pod name on Kubernetes
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// loadConfig parses the environment into a config, collecting every invalid setting rather than stopping at the first
+func loadConfig() (config, error) {
+	p := &configParser{}
+
+	cfg := config{
+		WAFPort:             wafPort,
+		WAFListeners:        p.wafListeners("WAF_LISTENERS", wafListenersStr),
+		AdminPort:           adminPort,
+		LogLevel:            getLogLevel(),
+		LogLevelRevertAfter: p.duration("LOG_LEVEL_REVERT_AFTER", logLevelRevertAfterStr),
+		Log:                 p.logStream("LOG", logOutput, logFormat, ""),
+		AccessLog:           p.logStream("ACCESS_LOG", accessLogOutput, accessLogFormat, accessLogLevelStr),
+		SecurityLog:         p.logStream("SECURITY_LOG", securityLogOutput, securityLogFormat, securityLogLevelStr),
+		AuditLogProcessor: audit.AuditLogProcessorOptions{
+			AuditLogPath:          auditLogPath,
+			Storage:               auditLogStorage,
+			DirMode:               p.fileMode("AUDIT_LOG_DIR_MODE", auditLogDirModeStr),
+			DirOwner:              auditLogDirOwner,
+			SigningKey:            []byte(auditLogSigningKey),
+			EncryptionKey:         p.encryptionKey("AUDIT_LOG_ENCRYPTION_KEY", auditLogEncryptionKey),
+			LogExpiration:         p.duration("AUDIT_LOG_EXPIRATION", expirationStr),
+			ExpirationJobInterval: p.duration("AUDIT_LOG_EXPIRATION_JOB_INTERVAL", expirationJobIntervalStr),
+			ProcessingJobInterval: p.duration("AUDIT_LOG_PROCESSING_JOB_INTERVAL", processingJobIntervalStr),
+			Watch:                 p.boolean("AUDIT_LOG_WATCH", auditLogWatchStr),
+			WatchDelay:            p.duration("AUDIT_LOG_WATCH_DELAY", auditLogWatchDelayStr),
+			ChunkLines:            p.integer("AUDIT_LOG_PROCESSING_CHUNK_LINES", auditLogChunkLinesStr),
+			ChunkPause:            p.duration("AUDIT_LOG_PROCESSING_CHUNK_PAUSE", auditLogChunkPauseStr),
+			MaxReadRate:           int64(p.integer("AUDIT_LOG_PROCESSING_MAX_BYTES_PER_SECOND", auditLogMaxReadRateStr)),
+			MaxLineBytes:          p.integer("AUDIT_LOG_MAX_LINE_BYTES", auditLogMaxLineBytesStr),
+			AsyncBufferLines:      p.integer("AUDIT_LOG_ASYNC_BUFFER_LINES", auditLogAsyncBufferStr),
+			OrphanAfter:           p.duration("AUDIT_LOG_ORPHAN_AFTER", auditLogOrphanAfterStr),
+			DiskGuard: audit.DiskGuardOptions{
+				MaxBytes:     int64(p.integer("AUDIT_DISK_GUARD_MAX_BYTES", auditDiskGuardMaxBytes)),
+				MinFreeRatio: p.float("AUDIT_DISK_GUARD_MIN_FREE_RATIO", auditDiskGuardMinFree),
+				Interval:     p.duration("AUDIT_DISK_GUARD_INTERVAL", auditDiskGuardInterval),
+			},
+		},
+		WAFHandler: coraza.WAFHandlerOptions{
+			FailurePolicy: middleware.FailurePolicy{
+				Default:   p.failureMode("FAILURE_MODE", failureModeStr),
+				Overrides: map[middleware.FailureClass]middleware.FailureMode{},
+			},
+			RequestLimits: middleware.RequestLimits{
+				MaxURLLength:   p.integer("MAX_URL_LENGTH", maxURLLengthStr),
+				MaxHeaderCount: p.integer("MAX_HEADER_COUNT", maxHeaderCountStr),
+				MaxHeaderBytes: p.integer("MAX_HEADER_BYTES", maxHeaderBytesStr),
+				MaxCookieCount: p.integer("MAX_COOKIE_COUNT", maxCookieCountStr),
+				MaxQueryParams: p.integer("MAX_QUERY_PARAMS", maxQueryParamsStr),
+			},
+			Headers: middleware.HeaderTransform{
+				Remove: splitList(headersRemoveStr),
+				Rename: p.pairs("HEADERS_RENAME", headersRenameStr),
+				Set:    p.headers("HEADERS_SET", headersSetStr),
+			},
+			AccessLogFields: splitList(accessLogTXFieldsStr),
+			RequestTags: coraza.RequestTagOptions{
+				Prefix: requestTagPrefix,
+				Header: requestTagHeader,
+			},
+			Canonicalize: p.canonicalizeMode("CANONICALIZE_MODE", canonicalizeModeStr),
+			Deadline: middleware.DeadlineOptions{
+				Header:  deadlineHeader,
+				Default: p.duration("DEADLINE_DEFAULT", deadlineDefaultStr),
+				Margin:  p.duration("DEADLINE_MARGIN", deadlineMarginStr),
+			},
+			Protocol: middleware.ProtocolPolicy{
+				Methods:  splitList(strings.ToUpper(allowedMethodsStr)),
+				Routes:   p.routeMethods("ALLOWED_METHODS_ROUTES", allowedMethodRoutesStr),
+				Versions: p.httpVersions("ALLOWED_HTTP_VERSIONS", allowedHTTPVersionsStr),
+			},
+			DirectiveHistory: coraza.DirectiveHistoryOptions{
+				Dir:  directivesHistoryDir,
+				Size: p.integer("DIRECTIVES_HISTORY_SIZE", directivesHistorySizeStr),
+			},
+			DenyResponse: coraza.DenyResponse{
+				Status:      p.integer("DENY_STATUS", denyStatusStr),
+				StatusMap:   p.statusMap("DENY_STATUS_MAP", denyStatusMapStr),
+				Headers:     p.headers("DENY_HEADERS", denyHeadersStr),
+				Actions:     p.actionMap("DENY_ACTION_MAP", denyActionMapStr),
+				TarpitDelay: p.duration("DENY_TARPIT_DELAY", denyTarpitDelayStr),
+				DecoyStatus: p.integer("DENY_DECOY_STATUS", denyDecoyStatusStr),
+				RedirectURL: p.redirectURL("DENY_REDIRECT_URL", denyRedirectURL),
+				AppealURL:   p.redirectURL("DENY_APPEAL_URL", denyAppealURL),
+				AppealEmail: denyAppealEmail,
+			},
+			CookieIntegrity: middleware.CookieIntegrity{
+				Secret:  []byte(cookieSecret),
+				Cookies: splitList(cookieNamesStr),
+				Mode:    p.cookieIntegrityMode("COOKIE_INTEGRITY_MODE", cookieModeStr),
+			},
+			CSRF: middleware.CSRFOptions{
+				Paths:         splitList(csrfPathsStr),
+				ExemptMethods: splitList(strings.ToUpper(csrfExemptMethodsStr)),
+				CookieName:    csrfCookieName,
+				HeaderName:    csrfHeaderName,
+			},
+			DebugLog: coraza.DebugLogOptions{
+				Initial:    p.integer("DEBUG_LOG_SAMPLE_INITIAL", debugLogInitialStr),
+				Thereafter: p.integer("DEBUG_LOG_SAMPLE_THEREAFTER", debugLogThereafterStr),
+			},
+			Honeypot: middleware.HoneypotOptions{
+				Paths:       splitList(honeypotPathsStr),
+				BanDuration: p.duration("HONEYPOT_BAN_DURATION", honeypotBanDurationStr),
+			},
+			VerifiedCrawlers: middleware.VerifiedCrawlerOptions{
+				Header: verifiedCrawlerHeader,
+			},
+			ThreatIntel: middleware.ThreatIntelOptions{
+				Header: threatIntelHeader,
+			},
+			DecisionWebhook: coraza.DecisionWebhookOptions{
+				URL:     decisionWebhookURL,
+				Timeout: p.duration("DECISION_WEBHOOK_TIMEOUT", decisionWebhookTimeout),
+				Headers: splitList(decisionWebhookHeaders),
+			},
+			OPA: coraza.OPAOptions{
+				URL:     opaURL,
+				Timeout: p.duration("OPA_TIMEOUT", opaTimeoutStr),
+				Headers: splitList(opaHeadersStr),
+			},
+			Scoring: coraza.ScoringOptions{
+				URL:              scoringURL,
+				Timeout:          p.duration("SCORING_TIMEOUT", scoringTimeoutStr),
+				Weight:           p.float("SCORING_WEIGHT", scoringWeightStr),
+				AnomalyThreshold: p.integer("SCORING_ANOMALY_THRESHOLD", scoringAnomalyThreshold),
+				Threshold:        p.float("SCORING_THRESHOLD", scoringThresholdStr),
+				Shadow:           p.boolean("SCORING_SHADOW", scoringShadowStr),
+			},
+			WarmupRounds: p.integer("WARMUP_ROUNDS", warmupRoundsStr),
+		},
+		Guard: listener.GuardOptions{
+			MinReadRate:         p.integer("MIN_READ_RATE", minReadRateStr),
+			ReadRateGracePeriod: p.duration("MIN_READ_RATE_GRACE_PERIOD", readRateGracePeriodStr),
+		},
+		StorePath:                storePath,
+		LogSinkAggregationWindow: p.duration("AUDIT_LOG_SINK_AGGREGATION_WINDOW", logSinkAggregationStr),
+		SummaryJobInterval:       p.duration("SUMMARY_JOB_INTERVAL", summaryJobIntervalStr),
+		SummaryWindows:           p.durations("SUMMARY_WINDOWS", summaryWindowsStr),
+		SummaryTopN:              p.integer("SUMMARY_TOP_N", summaryTopNStr),
+		FTWTestsDir:              ftwTestsDir,
+		ChangeLogPath:            changeLogPath,
+		AdminAuditLogPath:        adminAuditLogPath,
+		AdminAuditLogKey:         []byte(adminAuditLogKey),
+		Mirror: mirror.MirrorOptions{
+			URL:     mirrorURL,
+			Percent: p.integer("MIRROR_PERCENT", mirrorPercentStr),
+			Timeout: p.duration("MIRROR_TIMEOUT", mirrorTimeoutStr),
+			// The CSRF header carries the client's token whatever it is named
+			StripHeaders: append(splitList(mirrorStripHeadersStr), csrfHeaderName),
+		},
+		Debug: coraza.DebugOptions{
+			IPs:    splitList(debugIPsStr),
+			Secret: []byte(debugSecret),
+			Header: debugHeader,
+			Size:   p.integer("DEBUG_TRACE_SIZE", debugTraceSizeStr),
+		},
+		Capture: capture.Options{
+			Dir:         captureDir,
+			Retention:   p.duration("CAPTURE_RETENTION", captureRetentionStr),
+			SampleRate:  p.float("CAPTURE_SAMPLE_RATE", captureSampleRateStr),
+			Decisions:   splitList(captureDecisionsStr),
+			RuleIDs:     p.integers("CAPTURE_RULE_IDS", captureRuleIDsStr),
+			MaxBodySize: int64(p.integer("CAPTURE_MAX_BODY_SIZE", captureMaxBodySizeStr)),
+		},
+		Tenants: middleware.TenantOptions{
+			Header:        tenantHeader,
+			MonthlyQuotas: p.quotas("TENANT_MONTHLY_QUOTAS", tenantMonthlyQuotasStr),
+			MaxTenants:    p.integer("TENANT_MAX", tenantMaxStr),
+			UsagePath:     tenantUsagePath,
+			SaveInterval:  p.duration("TENANT_USAGE_SAVE_INTERVAL", tenantSaveIntervalStr),
+		},
+		ReverseDNS: rdns.Options{
+			Timeout:     p.duration("REVERSE_DNS_TIMEOUT", reverseDNSTimeoutStr),
+			CacheTTL:    p.duration("REVERSE_DNS_CACHE_TTL", reverseDNSCacheTTLStr),
+			NegativeTTL: p.duration("REVERSE_DNS_NEGATIVE_CACHE_TTL", reverseDNSNegativeTTLStr),
+			CacheSize:   p.integer("REVERSE_DNS_CACHE_SIZE", reverseDNSCacheSizeStr),
+			Concurrency: p.integer("REVERSE_DNS_CONCURRENCY", reverseDNSConcurrencyStr),
+		},
+		ReverseDNSEnabled: p.boolean("REVERSE_DNS", reverseDNSStr),
+		ASNDatabase:       asnDatabase,
+		ASNPolicies:       p.asnPolicies("ASN_POLICIES", asnPoliciesStr),
+		Webhooks: middleware.WebhookOptions{
+			Endpoints:      p.webhookEndpoints("WEBHOOK_ENDPOINTS", webhookEndpointsStr),
+			Tolerance:      p.duration("WEBHOOK_TIMESTAMP_TOLERANCE", webhookToleranceStr),
+			NonceCacheSize: p.integer("WEBHOOK_NONCE_CACHE_SIZE", webhookNonceCacheSizeStr),
+			MaxBodySize:    int64(p.integer("WEBHOOK_MAX_BODY_SIZE", webhookMaxBodySizeStr)),
+		},
+		ThreatIntel: threatintel.Options{
+			Feeds:    p.threatIntelFeeds("THREAT_INTEL_FEEDS", threatIntelFeedsStr),
+			Interval: p.duration("THREAT_INTEL_INTERVAL", threatIntelIntervalStr),
+			MaxAge:   p.duration("THREAT_INTEL_MAX_AGE", threatIntelMaxAgeStr),
+			Timeout:  p.duration("THREAT_INTEL_TIMEOUT", threatIntelTimeoutStr),
+		},
+		OIDC: oidc.Options{
+			IssuerURL:      oidcIssuerURL,
+			ClientID:       oidcClientID,
+			ClientSecret:   oidcClientSecret,
+			RedirectURL:    oidcRedirectURL,
+			Scopes:         splitList(oidcScopesStr),
+			GroupsClaim:    oidcGroupsClaim,
+			ViewerGroups:   splitList(oidcViewerGroupsStr),
+			OperatorGroups: splitList(oidcOperatorGroupsStr),
+			AdminGroups:    splitList(oidcAdminGroupsStr),
+			SessionKey:     []byte(oidcSessionKey),
+			SessionTTL:     p.duration("OIDC_SESSION_TTL", oidcSessionTTLStr),
+		},
+		AdminRoutePolicy:   p.routePolicy("ADMIN_ROUTE_POLICY", adminRoutePolicyStr),
+		AdminAnonymousRole: p.role("ADMIN_ANONYMOUS_ROLE", adminAnonymousRoleStr),
+		AdminRateLimit: admin.RateLimitOptions{
+			Rate:              p.float("ADMIN_RATE_LIMIT", adminRateLimitStr),
+			Burst:             p.integer("ADMIN_RATE_LIMIT_BURST", adminRateLimitBurstStr),
+			MaxAuthFailures:   p.integer("ADMIN_LOCKOUT_FAILURES", adminLockoutFailuresStr),
+			AuthFailureWindow: p.duration("ADMIN_LOCKOUT_WINDOW", adminLockoutWindowStr),
+			LockoutDuration:   p.duration("ADMIN_LOCKOUT_DURATION", adminLockoutDurationStr),
+		},
+		Events: events.Options{
+			Dir:            eventStoreDir,
+			Retention:      p.duration("EVENT_STORE_RETENTION", eventStoreRetentionStr),
+			ClassRetention: p.classRetention("EVENT_STORE_CLASS_RETENTION", eventStoreClassRetention),
+			MaxEvents:      p.integer("EVENT_STORE_MAX_EVENTS", eventStoreMaxEventsStr),
+		},
+		Script: script.Options{
+			Path:         scriptPath,
+			Timeout:      p.duration("SCRIPT_TIMEOUT", scriptTimeoutStr),
+			MaxStackSize: p.integer("SCRIPT_MAX_STACK_SIZE", scriptMaxStackSizeStr),
+		},
+		Outbound: outbound.Options{
+			MaxIdleConns:        p.integer("OUTBOUND_MAX_IDLE_CONNS", outboundMaxIdleStr),
+			MaxIdleConnsPerHost: p.integer("OUTBOUND_MAX_IDLE_CONNS_PER_HOST", outboundMaxIdleHostStr),
+			MaxConnsPerHost:     p.integer("OUTBOUND_MAX_CONNS_PER_HOST", outboundMaxConnsHostStr),
+			IdleConnTimeout:     p.duration("OUTBOUND_IDLE_CONN_TIMEOUT", outboundIdleTimeoutStr),
+			CAFile:              outboundCAFile,
+			HTTP2:               p.boolean("OUTBOUND_HTTP2", outboundHTTP2Str),
+		},
+		Loki: audit.LokiOptions{
+			URL:          lokiURL,
+			Labels:       p.lokiLabels("LOKI_LABELS", lokiLabelsStr),
+			StaticLabels: p.pairs("LOKI_STATIC_LABELS", lokiStaticLabelsStr),
+			TenantID:     lokiTenantID,
+			BatchSize:    p.integer("LOKI_BATCH_SIZE", lokiBatchSizeStr),
+			Timeout:      p.duration("LOKI_TIMEOUT", lokiTimeoutStr),
+			Retry:        p.retry("LOKI", lokiRetryAttemptsStr, lokiRetryBackoffStr),
+		},
+		Fluent: audit.FluentOptions{
+			Address:   fluentAddress,
+			Tag:       fluentTag,
+			Ack:       p.boolean("FLUENT_ACK", fluentAckStr),
+			BatchSize: p.integer("FLUENT_BATCH_SIZE", fluentBatchSizeStr),
+			Timeout:   p.duration("FLUENT_TIMEOUT", fluentTimeoutStr),
+			Retry:     p.retry("FLUENT", fluentRetryAttemptsStr, fluentRetryBackoffStr),
+		},
+		CloudWatch: audit.CloudWatchOptions{
+			LogGroup:         cloudWatchLogGroup,
+			LogStream:        cloudWatchLogStream,
+			MetricsNamespace: cloudWatchNamespace,
+			Region:           cloudWatchRegion,
+			Endpoint:         cloudWatchEndpoint,
+			BatchSize:        p.integer("CLOUDWATCH_BATCH_SIZE", cloudWatchBatchSizeStr),
+			Timeout:          p.duration("CLOUDWATCH_TIMEOUT", cloudWatchTimeoutStr),
+			Retry:            p.retry("CLOUDWATCH", cloudWatchRetryAttempts, cloudWatchRetryBackoff),
+		},
+		GoogleCloud: audit.GoogleCloudOptions{
+			ProjectID: gcpProjectID,
+			LogName:   gcpLogName,
+			Topic:     gcpPubSubTopic,
+			BatchSize: p.integer("GCP_BATCH_SIZE", gcpBatchSizeStr),
+			Timeout:   p.duration("GCP_TIMEOUT", gcpTimeoutStr),
+			Retry:     p.retry("GCP", gcpRetryAttemptsStr, gcpRetryBackoffStr),
+		},
+		NATS: nats.Options{
+			URL:             natsURL,
+			Token:           natsToken,
+			AuditSubject:    natsAuditSubject,
+			DecisionSubject: natsDecisionSubject,
+			JetStream:       p.boolean("NATS_JETSTREAM", natsJetStreamStr),
+			Timeout:         p.duration("NATS_TIMEOUT", natsTimeoutStr),
+			QueueSize:       p.integer("NATS_QUEUE_SIZE", natsQueueSizeStr),
+		},
+		Alert: alert.Options{
+			Path:    alertRulesFile,
+			Timeout: p.duration("ALERT_TIMEOUT", alertTimeoutStr),
+		},
+		Report: report.Options{
+			Schedule:   reportSchedule,
+			At:         p.timeOfDay("REPORT_TIME", reportTimeStr),
+			TopN:       p.integer("REPORT_TOP_N", reportTopNStr),
+			WebhookURL: reportWebhookURL,
+			SMTP: report.SMTPOptions{
+				Address:  reportSMTPAddress,
+				Username: reportSMTPUsername,
+				Password: reportSMTPPassword,
+				From:     reportEmailFrom,
+				To:       splitList(reportEmailToStr),
+			},
+			Timeout: p.duration("REPORT_TIMEOUT", reportTimeoutStr),
+		},
+		Leader: leader.Options{
+			Backend:       leaderElection,
+			Name:          leaderElectionName,
+			Identity:      hostname(),
+			LeaseDuration: p.duration("LEADER_ELECTION_LEASE_DURATION", leaderLeaseDurationStr),
+			RenewInterval: p.duration("LEADER_ELECTION_RENEW_INTERVAL", leaderRenewIntervalStr),
+			Namespace:     leaderElectionNamespace,
+			RedisURL:      leaderElectionRedisURL,
+		},
+		Sentry: sentry.Options{
+			DSN:         sentryDSN,
+			Environment: sentryEnvironment,
+			Release:     sentryRelease,
+			ServerName:  hostname(),
+			Timeout:     p.duration("SENTRY_TIMEOUT", sentryTimeoutStr),
+			QueueSize:   p.integer("SENTRY_QUEUE_SIZE", sentryQueueSizeStr),
+		},
+	}
+
+	failureModeOverrides := map[middleware.FailureClass]string{
+		middleware.FailureClassPanic:           failureModePanicStr,
+		middleware.FailureClassBodyRead:        failureModeBodyReadStr,
+		middleware.FailureClassDecisionWebhook: failureModeWebhookStr,
+		middleware.FailureClassOPA:             failureModeOPAStr,
+		middleware.FailureClassScript:          failureModeScriptStr,
+		middleware.FailureClassScoring:         failureModeScoringStr,
+		middleware.FailureClassDeadline:        failureModeDeadlineStr,
+	}
+	if p.boolean("AUDIT_LOG_PER_REPLICA", auditLogPerReplicaStr) {
+		cfg.AuditLogProcessor.Instance = hostname()
+	}
+	for class, modeStr := range failureModeOverrides {
+		if modeStr != "" {
+			cfg.WAFHandler.FailurePolicy.Overrides[class] = p.failureMode("FAILURE_MODE_"+strings.ToUpper(string(class)), modeStr)
+		}
+	}
+
+	if cfg.OIDC.IssuerURL != "" && (cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "") {
+		p.errs = append(p.errs, errors.New("OIDC_ISSUER_URL: OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required"))
+	}
+
+	for _, window := range cfg.SummaryWindows {
+		if window <= 0 || window > audit.MaxSummaryWindow {
+			p.errs = append(p.errs, fmt.Errorf("SUMMARY_WINDOWS: %s must be positive and at most %s", window, audit.MaxSummaryWindow))
+		}
+	}
+
+	if cfg.Events.MaxEvents < 1 {
+		p.errs = append(p.errs, errors.New("EVENT_STORE_MAX_EVENTS: must be at least 1"))
+	}
+
+	if cfg.AdminRateLimit.Rate > 0 && cfg.AdminRateLimit.Burst < 1 {
+		p.errs = append(p.errs, errors.New("ADMIN_RATE_LIMIT_BURST: must be at least 1 when ADMIN_RATE_LIMIT is set"))
+	}
+
+	return cfg, errors.Join(p.errs...)
+}
+
+// redacted replaces secret settings in the config report
+const redacted = "[redacted]"
+
+// settings reports the effective value of every setting keyed by its environment variable, for the admin config endpoint.
+// Secrets are redacted and durations are shown as parsed.
+func (c config) settings() map[string]string {
+	wh := c.WAFHandler
+	return map[string]string{
+		"AUDIT_LOG_EXPIRATION":                      c.AuditLogProcessor.LogExpiration.String(),
+		"AUDIT_LOG_EXPIRATION_JOB_INTERVAL":         c.AuditLogProcessor.ExpirationJobInterval.String(),
+		"AUDIT_LOG_PROCESSING_JOB_INTERVAL":         c.AuditLogProcessor.ProcessingJobInterval.String(),
+		"AUDIT_LOG_WATCH":                           strconv.FormatBool(c.AuditLogProcessor.Watch),
+		"AUDIT_LOG_WATCH_DELAY":                     c.AuditLogProcessor.WatchDelay.String(),
+		"AUDIT_LOG_PROCESSING_CHUNK_LINES":          strconv.Itoa(c.AuditLogProcessor.ChunkLines),
+		"AUDIT_LOG_PROCESSING_CHUNK_PAUSE":          c.AuditLogProcessor.ChunkPause.String(),
+		"AUDIT_LOG_PROCESSING_MAX_BYTES_PER_SECOND": strconv.FormatInt(c.AuditLogProcessor.MaxReadRate, 10),
+		"AUDIT_LOG_MAX_LINE_BYTES":                  strconv.Itoa(c.AuditLogProcessor.MaxLineBytes),
+		"AUDIT_LOG_ASYNC_BUFFER_LINES":              strconv.Itoa(c.AuditLogProcessor.AsyncBufferLines),
+		"AUDIT_LOG_PER_REPLICA":                     strconv.FormatBool(c.AuditLogProcessor.Instance != ""),
+		"AUDIT_LOG_ORPHAN_AFTER":                    c.AuditLogProcessor.OrphanAfter.String(),
+		"AUDIT_LOG_PATH":                            c.AuditLogProcessor.AuditLogPath,
+		"AUDIT_LOG_STORAGE":                         c.AuditLogProcessor.Storage,
+		"AUDIT_LOG_DIR_MODE":                        fmt.Sprintf("%#o", c.AuditLogProcessor.DirMode),
+		"AUDIT_LOG_DIR_OWNER":                       c.AuditLogProcessor.DirOwner,
+		"AUDIT_LOG_ENCRYPTION_KEY":                  redact(c.AuditLogProcessor.EncryptionKey),
+		"AUDIT_LOG_SIGNING_KEY":                     redact(c.AuditLogProcessor.SigningKey),
+		"AUDIT_LOG_SINK_AGGREGATION_WINDOW":         c.LogSinkAggregationWindow.String(),
+		"AUDIT_DISK_GUARD_MAX_BYTES":                strconv.FormatInt(c.AuditLogProcessor.DiskGuard.MaxBytes, 10),
+		"AUDIT_DISK_GUARD_MIN_FREE_RATIO":           strconv.FormatFloat(c.AuditLogProcessor.DiskGuard.MinFreeRatio, 'g', -1, 64),
+		"AUDIT_DISK_GUARD_INTERVAL":                 c.AuditLogProcessor.DiskGuard.Interval.String(),
+		"LOG_LEVEL":                                 strings.ToLower(c.LogLevel.String()),
+		"LOG_LEVEL_REVERT_AFTER":                    c.LogLevelRevertAfter.String(),
+		"LOG_OUTPUT":                                c.Log.Output,
+		"LOG_FORMAT":                                c.Log.Format,
+		"ACCESS_LOG_OUTPUT":                         c.AccessLog.Output,
+		"ACCESS_LOG_FORMAT":                         c.AccessLog.Format,
+		"ACCESS_LOG_LEVEL":                          strings.ToLower(c.AccessLog.Level.Level().String()),
+		"ACCESS_LOG_TX_FIELDS":                      strings.Join(c.WAFHandler.AccessLogFields, ","),
+		"REQUEST_TAG_PREFIX":                        c.WAFHandler.RequestTags.Prefix,
+		"REQUEST_TAG_HEADER":                        c.WAFHandler.RequestTags.Header,
+		"SECURITY_LOG_OUTPUT":                       c.SecurityLog.Output,
+		"SECURITY_LOG_FORMAT":                       c.SecurityLog.Format,
+		"SECURITY_LOG_LEVEL":                        strings.ToLower(c.SecurityLog.Level.Level().String()),
+		"WAF_PORT":                                  c.WAFPort,
+		"WAF_LISTENERS":                             wafListenersStr,
+		"ADMIN_PORT":                                c.AdminPort,
+		"FAILURE_MODE":                              string(wh.FailurePolicy.Default),
+		"FAILURE_MODE_PANIC":                        string(wh.FailurePolicy.Mode(middleware.FailureClassPanic)),
+		"FAILURE_MODE_BODY_READ":                    string(wh.FailurePolicy.Mode(middleware.FailureClassBodyRead)),
+		"FAILURE_MODE_DECISION_WEBHOOK":             string(wh.FailurePolicy.Mode(middleware.FailureClassDecisionWebhook)),
+		"FAILURE_MODE_OPA":                          string(wh.FailurePolicy.Mode(middleware.FailureClassOPA)),
+		"FAILURE_MODE_SCRIPT":                       string(wh.FailurePolicy.Mode(middleware.FailureClassScript)),
+		"FAILURE_MODE_SCORING":                      string(wh.FailurePolicy.Mode(middleware.FailureClassScoring)),
+		"FAILURE_MODE_DEADLINE":                     string(wh.FailurePolicy.Mode(middleware.FailureClassDeadline)),
+		"DEADLINE_HEADER":                           wh.Deadline.Header,
+		"DEADLINE_DEFAULT":                          wh.Deadline.Default.String(),
+		"DEADLINE_MARGIN":                           wh.Deadline.Margin.String(),
+		"HEADERS_REMOVE":                            strings.Join(wh.Headers.Remove, ","),
+		"HEADERS_RENAME":                            headersRenameStr,
+		"HEADERS_SET":                               headersSetStr,
+		"CANONICALIZE_MODE":                         string(wh.Canonicalize),
+		"ALLOWED_METHODS":                           strings.Join(wh.Protocol.Methods, ","),
+		"ALLOWED_METHODS_ROUTES":                    allowedMethodRoutesStr,
+		"ALLOWED_HTTP_VERSIONS":                     strings.Join(wh.Protocol.Versions, ","),
+		"MAX_URL_LENGTH":                            strconv.Itoa(wh.RequestLimits.MaxURLLength),
+		"MAX_HEADER_COUNT":                          strconv.Itoa(wh.RequestLimits.MaxHeaderCount),
+		"MAX_HEADER_BYTES":                          strconv.Itoa(wh.RequestLimits.MaxHeaderBytes),
+		"MAX_COOKIE_COUNT":                          strconv.Itoa(wh.RequestLimits.MaxCookieCount),
+		"MAX_QUERY_PARAMS":                          strconv.Itoa(wh.RequestLimits.MaxQueryParams),
+		"MIN_READ_RATE":                             strconv.Itoa(c.Guard.MinReadRate),
+		"MIN_READ_RATE_GRACE_PERIOD":                c.Guard.ReadRateGracePeriod.String(),
+		"STORE_PATH":                                c.StorePath,
+		"OIDC_ISSUER_URL":                           c.OIDC.IssuerURL,
+		"OIDC_CLIENT_ID":                            c.OIDC.ClientID,
+		"OIDC_CLIENT_SECRET":                        redact([]byte(c.OIDC.ClientSecret)),
+		"OIDC_REDIRECT_URL":                         c.OIDC.RedirectURL,
+		"OIDC_SCOPES":                               strings.Join(c.OIDC.Scopes, ","),
+		"OIDC_GROUPS_CLAIM":                         c.OIDC.GroupsClaim,
+		"OIDC_VIEWER_GROUPS":                        strings.Join(c.OIDC.ViewerGroups, ","),
+		"OIDC_OPERATOR_GROUPS":                      strings.Join(c.OIDC.OperatorGroups, ","),
+		"OIDC_ADMIN_GROUPS":                         strings.Join(c.OIDC.AdminGroups, ","),
+		"OIDC_SESSION_KEY":                          redact(c.OIDC.SessionKey),
+		"OIDC_SESSION_TTL":                          c.OIDC.SessionTTL.String(),
+		"ADMIN_ROUTE_POLICY":                        adminRoutePolicyStr,
+		"ADMIN_ANONYMOUS_ROLE":                      c.AdminAnonymousRole.String(),
+		"ADMIN_RATE_LIMIT":                          strconv.FormatFloat(c.AdminRateLimit.Rate, 'g', -1, 64),
+		"ADMIN_RATE_LIMIT_BURST":                    strconv.Itoa(c.AdminRateLimit.Burst),
+		"ADMIN_LOCKOUT_FAILURES":                    strconv.Itoa(c.AdminRateLimit.MaxAuthFailures),
+		"ADMIN_LOCKOUT_WINDOW":                      c.AdminRateLimit.AuthFailureWindow.String(),
+		"ADMIN_LOCKOUT_DURATION":                    c.AdminRateLimit.LockoutDuration.String(),
+		"SUMMARY_JOB_INTERVAL":                      c.SummaryJobInterval.String(),
+		"SUMMARY_WINDOWS":                           joinDurations(c.SummaryWindows),
+		"SUMMARY_TOP_N":                             strconv.Itoa(c.SummaryTopN),
+		"FTW_TESTS_DIR":                             c.FTWTestsDir,
+		"CHANGE_LOG_PATH":                           c.ChangeLogPath,
+		"ADMIN_AUDIT_LOG_PATH":                      c.AdminAuditLogPath,
+		"ADMIN_AUDIT_LOG_KEY":                       redact(c.AdminAuditLogKey),
+		"DIRECTIVES_HISTORY_DIR":                    wh.DirectiveHistory.Dir,
+		"DIRECTIVES_HISTORY_SIZE":                   strconv.Itoa(wh.DirectiveHistory.Size),
+		"DENY_STATUS":                               strconv.Itoa(wh.DenyResponse.Status),
+		"DENY_STATUS_MAP":                           denyStatusMapStr,
+		"DENY_HEADERS":                              denyHeadersStr,
+		"DENY_ACTION_MAP":                           denyActionMapStr,
+		"DENY_TARPIT_DELAY":                         wh.DenyResponse.TarpitDelay.String(),
+		"DENY_DECOY_STATUS":                         strconv.Itoa(wh.DenyResponse.DecoyStatus),
+		"DENY_REDIRECT_URL":                         redactURL(denyRedirectURL),
+		"DENY_APPEAL_URL":                           redactURL(denyAppealURL),
+		"DENY_APPEAL_EMAIL":                         denyAppealEmail,
+		"COOKIE_INTEGRITY_SECRET":                   redact(wh.CookieIntegrity.Secret),
+		"COOKIE_INTEGRITY_COOKIES":                  strings.Join(wh.CookieIntegrity.Cookies, ","),
+		"COOKIE_INTEGRITY_MODE":                     string(wh.CookieIntegrity.Mode),
+		"CSRF_PATHS":                                strings.Join(wh.CSRF.Paths, ","),
+		"CSRF_EXEMPT_METHODS":                       strings.Join(wh.CSRF.ExemptMethods, ","),
+		"CSRF_COOKIE_NAME":                          wh.CSRF.CookieName,
+		"CSRF_HEADER_NAME":                          wh.CSRF.HeaderName,
+		"HONEYPOT_PATHS":                            strings.Join(wh.Honeypot.Paths, ","),
+		"HONEYPOT_BAN_DURATION":                     wh.Honeypot.BanDuration.String(),
+		"MIRROR_URL":                                redactURL(c.Mirror.URL),
+		"MIRROR_PERCENT":                            strconv.Itoa(c.Mirror.Percent),
+		"MIRROR_TIMEOUT":                            c.Mirror.Timeout.String(),
+		"MIRROR_STRIP_HEADERS":                      mirrorStripHeadersStr,
+		"DEBUG_IPS":                                 strings.Join(c.Debug.IPs, ","),
+		"DEBUG_SECRET":                              redact(c.Debug.Secret),
+		"DEBUG_HEADER":                              c.Debug.Header,
+		"DEBUG_TRACE_SIZE":                          strconv.Itoa(c.Debug.Size),
+		"DEBUG_LOG_SAMPLE_INITIAL":                  strconv.Itoa(wh.DebugLog.Initial),
+		"DEBUG_LOG_SAMPLE_THEREAFTER":               strconv.Itoa(wh.DebugLog.Thereafter),
+		"CAPTURE_DIR":                               c.Capture.Dir,
+		"CAPTURE_RETENTION":                         c.Capture.Retention.String(),
+		"CAPTURE_SAMPLE_RATE":                       strconv.FormatFloat(c.Capture.SampleRate, 'g', -1, 64),
+		"CAPTURE_DECISIONS":                         strings.Join(c.Capture.Decisions, ","),
+		"CAPTURE_RULE_IDS":                          captureRuleIDsStr,
+		"CAPTURE_MAX_BODY_SIZE":                     strconv.FormatInt(c.Capture.MaxBodySize, 10),
+		"TENANT_HEADER":                             c.Tenants.Header,
+		"TENANT_MONTHLY_QUOTAS":                     tenantMonthlyQuotasStr,
+		"TENANT_MAX":                                strconv.Itoa(c.Tenants.MaxTenants),
+		"TENANT_USAGE_PATH":                         c.Tenants.UsagePath,
+		"TENANT_USAGE_SAVE_INTERVAL":                c.Tenants.SaveInterval.String(),
+		"REVERSE_DNS":                               strconv.FormatBool(c.ReverseDNSEnabled),
+		"REVERSE_DNS_TIMEOUT":                       c.ReverseDNS.Timeout.String(),
+		"REVERSE_DNS_CACHE_TTL":                     c.ReverseDNS.CacheTTL.String(),
+		"REVERSE_DNS_NEGATIVE_CACHE_TTL":            c.ReverseDNS.NegativeTTL.String(),
+		"REVERSE_DNS_CACHE_SIZE":                    strconv.Itoa(c.ReverseDNS.CacheSize),
+		"REVERSE_DNS_CONCURRENCY":                   strconv.Itoa(c.ReverseDNS.Concurrency),
+		"VERIFIED_CRAWLER_HEADER":                   wh.VerifiedCrawlers.Header,
+		"ASN_DATABASE":                              c.ASNDatabase,
+		"ASN_POLICIES":                              asnPoliciesStr,
+		"WEBHOOK_ENDPOINTS":                         webhookEndpointsStr,
+		"WEBHOOK_TIMESTAMP_TOLERANCE":               c.Webhooks.Tolerance.String(),
+		"WEBHOOK_NONCE_CACHE_SIZE":                  strconv.Itoa(c.Webhooks.NonceCacheSize),
+		"WEBHOOK_MAX_BODY_SIZE":                     strconv.FormatInt(c.Webhooks.MaxBodySize, 10),
+		"THREAT_INTEL_FEEDS":                        redactFeeds(c.ThreatIntel.Feeds),
+		"THREAT_INTEL_INTERVAL":                     c.ThreatIntel.Interval.String(),
+		"THREAT_INTEL_MAX_AGE":                      c.ThreatIntel.MaxAge.String(),
+		"THREAT_INTEL_TIMEOUT":                      c.ThreatIntel.Timeout.String(),
+		"THREAT_INTEL_HEADER":                       wh.ThreatIntel.Header,
+		"EVENT_STORE_DIR":                           c.Events.Dir,
+		"EVENT_STORE_RETENTION":                     c.Events.Retention.String(),
+		"EVENT_STORE_CLASS_RETENTION":               eventStoreClassRetention,
+		"EVENT_STORE_MAX_EVENTS":                    strconv.Itoa(c.Events.MaxEvents),
+		"WARMUP_ROUNDS":                             strconv.Itoa(wh.WarmupRounds),
+		"DECISION_WEBHOOK_URL":                      redactURL(wh.DecisionWebhook.URL),
+		"DECISION_WEBHOOK_TIMEOUT":                  wh.DecisionWebhook.Timeout.String(),
+		"DECISION_WEBHOOK_HEADERS":                  strings.Join(wh.DecisionWebhook.Headers, ","),
+		"OPA_URL":                                   redactURL(wh.OPA.URL),
+		"OPA_TIMEOUT":                               wh.OPA.Timeout.String(),
+		"OPA_HEADERS":                               strings.Join(wh.OPA.Headers, ","),
+		"SCORING_URL":                               redactURL(wh.Scoring.URL),
+		"SCORING_TIMEOUT":                           wh.Scoring.Timeout.String(),
+		"SCORING_WEIGHT":                            strconv.FormatFloat(wh.Scoring.Weight, 'g', -1, 64),
+		"SCORING_ANOMALY_THRESHOLD":                 strconv.Itoa(wh.Scoring.AnomalyThreshold),
+		"SCORING_THRESHOLD":                         strconv.FormatFloat(wh.Scoring.Threshold, 'g', -1, 64),
+		"SCORING_SHADOW":                            strconv.FormatBool(wh.Scoring.Shadow),
+		"SCRIPT_PATH":                               c.Script.Path,
+		"SCRIPT_TIMEOUT":                            c.Script.Timeout.String(),
+		"SCRIPT_MAX_STACK_SIZE":                     strconv.Itoa(c.Script.MaxStackSize),
+		"OUTBOUND_MAX_IDLE_CONNS":                   strconv.Itoa(c.Outbound.MaxIdleConns),
+		"OUTBOUND_MAX_IDLE_CONNS_PER_HOST":          strconv.Itoa(c.Outbound.MaxIdleConnsPerHost),
+		"OUTBOUND_MAX_CONNS_PER_HOST":               strconv.Itoa(c.Outbound.MaxConnsPerHost),
+		"OUTBOUND_IDLE_CONN_TIMEOUT":                c.Outbound.IdleConnTimeout.String(),
+		"OUTBOUND_CA_FILE":                          c.Outbound.CAFile,
+		"OUTBOUND_HTTP2":                            strconv.FormatBool(c.Outbound.HTTP2),
+		"LOKI_URL":                                  redactURL(c.Loki.URL),
+		"LOKI_LABELS":                               strings.Join(c.Loki.Labels, ","),
+		"LOKI_STATIC_LABELS":                        lokiStaticLabelsStr,
+		"LOKI_TENANT_ID":                            c.Loki.TenantID,
+		"LOKI_BATCH_SIZE":                           strconv.Itoa(c.Loki.BatchSize),
+		"LOKI_TIMEOUT":                              c.Loki.Timeout.String(),
+		"LOKI_RETRY_ATTEMPTS":                       strconv.Itoa(c.Loki.Retry.Attempts),
+		"LOKI_RETRY_BACKOFF":                        c.Loki.Retry.Backoff.String(),
+		"FLUENT_ADDRESS":                            c.Fluent.Address,
+		"FLUENT_TAG":                                c.Fluent.Tag,
+		"FLUENT_ACK":                                strconv.FormatBool(c.Fluent.Ack),
+		"FLUENT_BATCH_SIZE":                         strconv.Itoa(c.Fluent.BatchSize),
+		"FLUENT_TIMEOUT":                            c.Fluent.Timeout.String(),
+		"FLUENT_RETRY_ATTEMPTS":                     strconv.Itoa(c.Fluent.Retry.Attempts),
+		"FLUENT_RETRY_BACKOFF":                      c.Fluent.Retry.Backoff.String(),
+		"CLOUDWATCH_LOG_GROUP":                      c.CloudWatch.LogGroup,
+		"CLOUDWATCH_LOG_STREAM":                     c.CloudWatch.LogStream,
+		"CLOUDWATCH_METRICS_NAMESPACE":              c.CloudWatch.MetricsNamespace,
+		"CLOUDWATCH_REGION":                         c.CloudWatch.Region,
+		"CLOUDWATCH_ENDPOINT":                       c.CloudWatch.Endpoint,
+		"CLOUDWATCH_BATCH_SIZE":                     strconv.Itoa(c.CloudWatch.BatchSize),
+		"CLOUDWATCH_TIMEOUT":                        c.CloudWatch.Timeout.String(),
+		"CLOUDWATCH_RETRY_ATTEMPTS":                 strconv.Itoa(c.CloudWatch.Retry.Attempts),
+		"CLOUDWATCH_RETRY_BACKOFF":                  c.CloudWatch.Retry.Backoff.String(),
+		"GCP_PROJECT_ID":                            c.GoogleCloud.ProjectID,
+		"GCP_LOG_NAME":                              c.GoogleCloud.LogName,
+		"GCP_PUBSUB_TOPIC":                          c.GoogleCloud.Topic,
+		"GCP_BATCH_SIZE":                            strconv.Itoa(c.GoogleCloud.BatchSize),
+		"GCP_TIMEOUT":                               c.GoogleCloud.Timeout.String(),
+		"GCP_RETRY_ATTEMPTS":                        strconv.Itoa(c.GoogleCloud.Retry.Attempts),
+		"GCP_RETRY_BACKOFF":                         c.GoogleCloud.Retry.Backoff.String(),
+		"NATS_URL":                                  redactURL(c.NATS.URL),
+		"NATS_TOKEN":                                redact([]byte(c.NATS.Token)),
+		"NATS_AUDIT_SUBJECT":                        c.NATS.AuditSubject,
+		"NATS_DECISION_SUBJECT":                     c.NATS.DecisionSubject,
+		"NATS_JETSTREAM":                            strconv.FormatBool(c.NATS.JetStream),
+		"NATS_TIMEOUT":                              c.NATS.Timeout.String(),
+		"NATS_QUEUE_SIZE":                           strconv.Itoa(c.NATS.QueueSize),
+		"ALERT_RULES_FILE":                          c.Alert.Path,
+		"ALERT_TIMEOUT":                             c.Alert.Timeout.String(),
+		"REPORT_SCHEDULE":                           c.Report.Schedule,
+		"REPORT_TIME":                               reportTimeStr,
+		"REPORT_TOP_N":                              strconv.Itoa(c.Report.TopN),
+		"REPORT_WEBHOOK_URL":                        redactURL(c.Report.WebhookURL),
+		"REPORT_SMTP_ADDRESS":                       c.Report.SMTP.Address,
+		"REPORT_SMTP_USERNAME":                      c.Report.SMTP.Username,
+		"REPORT_SMTP_PASSWORD":                      redact([]byte(c.Report.SMTP.Password)),
+		"REPORT_EMAIL_FROM":                         c.Report.SMTP.From,
+		"REPORT_EMAIL_TO":                           strings.Join(c.Report.SMTP.To, ","),
+		"REPORT_TIMEOUT":                            c.Report.Timeout.String(),
+		"LEADER_ELECTION":                           c.Leader.Backend,
+		"LEADER_ELECTION_NAME":                      c.Leader.Name,
+		"LEADER_ELECTION_NAMESPACE":                 c.Leader.Namespace,
+		"LEADER_ELECTION_REDIS_URL":                 redactURL(c.Leader.RedisURL),
+		"LEADER_ELECTION_LEASE_DURATION":            c.Leader.LeaseDuration.String(),
+		"LEADER_ELECTION_RENEW_INTERVAL":            c.Leader.RenewInterval.String(),
+		"SENTRY_DSN":                                redact([]byte(c.Sentry.DSN)),
+		"SENTRY_ENVIRONMENT":                        c.Sentry.Environment,
+		"SENTRY_RELEASE":                            c.Sentry.Release,
+		"SENTRY_TIMEOUT":                            c.Sentry.Timeout.String(),
+		"SENTRY_QUEUE_SIZE":                         strconv.Itoa(c.Sentry.QueueSize),
+	}
+}
+
+// redactURL hides the password of a URL with credentials
+func redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Redacted()
+	}
+	return rawURL
+}
+
+// redactFeeds renders the threat intel feeds one per line, as in THREAT_INTEL_FEEDS, with their URLs redacted
+func redactFeeds(feeds []threatintel.Feed) string {
+	lines := make([]string, len(feeds))
+	for i, feed := range feeds {
+		lines[i] = feed.Name + " " + feed.Action + " " + redactURL(feed.CollectionURL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func redact(secret []byte) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return redacted
+}
+
+func joinDurations(durations []time.Duration) string {
+	items := make([]string, len(durations))
+	for i, d := range durations {
+		items[i] = d.String()
+	}
+	return strings.Join(items, ",")
+}
+
+func getLogLevel() slog.Level {
+	switch logLevel {
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "debug":
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configParser converts environment values, recording an error for each one that is invalid
+type configParser struct {
+	errs []error
+}
+
+func (p *configParser) duration(envVar string, value string) time.Duration {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) durations(envVar string, value string) []time.Duration {
+	var parsed []time.Duration
+	for _, item := range splitList(value) {
+		parsed = append(parsed, p.duration(envVar, item))
+	}
+	return parsed
+}
+
+func (p *configParser) integer(envVar string, value string) int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+// fileMode parses octal permissions such as 0750
+func (p *configParser) fileMode(envVar string, value string) os.FileMode {
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err == nil && parsed > 0o777 {
+		err = errors.New("permissions must be between 0 and 0777")
+	}
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return os.FileMode(parsed)
+}
+
+func (p *configParser) integers(envVar string, value string) []int {
+	var parsed []int
+	for _, item := range splitList(value) {
+		parsed = append(parsed, p.integer(envVar, item))
+	}
+	return parsed
+}
+
+func (p *configParser) float(envVar string, value string) float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) boolean(envVar string, value string) bool {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) statusMap(envVar string, value string) []coraza.StatusMapping {
+	parsed, err := coraza.ParseStatusMap(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) actionMap(envVar string, value string) []coraza.ActionMapping {
+	parsed, err := coraza.ParseActionMap(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+// redirectURL parses an absolute http or https URL, returning nil for an empty value
+func (p *configParser) redirectURL(envVar string, value string) *url.URL {
+	if value == "" {
+		return nil
+	}
+	parsed, err := url.Parse(value)
+	if err == nil && (parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "") {
+		err = errors.New("expected an absolute http or https URL")
+	}
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+		return nil
+	}
+	return parsed
+}
+
+// headers parses one "Name: value" header per line
+func (p *configParser) headers(envVar string, value string) http.Header {
+	parsed := http.Header{}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, headerValue, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			p.errs = append(p.errs, fmt.Errorf("%s: invalid header %q, expected \"Name: value\"", envVar, line))
+			continue
+		}
+		parsed.Add(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+	}
+	return parsed
+}
+
+// pairs parses comma-separated "key=value" pairs, such as header renames or labels
+func (p *configParser) pairs(envVar string, value string) map[string]string {
+	parsed := map[string]string{}
+	for _, item := range splitList(value) {
+		key, pairValue, ok := strings.Cut(item, "=")
+		if key, pairValue = strings.TrimSpace(key), strings.TrimSpace(pairValue); !ok || key == "" || pairValue == "" {
+			p.errs = append(p.errs, fmt.Errorf("%s: invalid pair %q, expected \"key=value\"", envVar, item))
+			continue
+		}
+		parsed[key] = pairValue
+	}
+	return parsed
+}
+
+// routeMethods parses one "/prefix: METHOD, METHOD" route per line
+func (p *configParser) routeMethods(envVar string, value string) []middleware.RouteMethods {
+	var parsed []middleware.RouteMethods
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		prefix, methods, ok := strings.Cut(line, ":")
+		if prefix = strings.TrimSpace(prefix); !ok || !strings.HasPrefix(prefix, "/") {
+			p.errs = append(p.errs, fmt.Errorf("%s: invalid route %q, expected \"/prefix: METHOD, METHOD\"", envVar, line))
+			continue
+		}
+		parsed = append(parsed, middleware.RouteMethods{Prefix: prefix, Methods: splitList(strings.ToUpper(methods))})
+	}
+	return parsed
+}
+
+func (p *configParser) wafListeners(envVar string, value string) []wafListener {
+	parsed, err := parseWAFListeners(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+// httpVersions parses a comma-separated list of protocol versions such as HTTP/1.1
+func (p *configParser) httpVersions(envVar string, value string) []string {
+	versions := splitList(strings.ToUpper(value))
+	for _, version := range versions {
+		if _, _, ok := http.ParseHTTPVersion(version); !ok {
+			p.errs = append(p.errs, fmt.Errorf("%s: invalid HTTP version %q", envVar, version))
+		}
+	}
+	return versions
+}
+
+// logStream parses the <prefix>_OUTPUT, <prefix>_FORMAT and <prefix>_LEVEL settings of a log stream.
+// An empty output is allowed and shares the application log.
+func (p *configParser) logStream(prefix string, output string, format string, level string) logging.StreamOptions {
+	stream := logging.StreamOptions{Output: output, Format: format}
+	if output != "" {
+		if err := logging.ValidateOutput(output); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s_OUTPUT: %w", prefix, err))
+		}
+	}
+	if err := logging.ValidateFormat(format); err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s_FORMAT: %w", prefix, err))
+	}
+	if level != "" {
+		var parsed slog.Level
+		if err := parsed.UnmarshalText([]byte(level)); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s_LEVEL: %w", prefix, err))
+		}
+		stream.Level = parsed
+	}
+	return stream
+}
+
+func (p *configParser) failureMode(envVar string, value string) middleware.FailureMode {
+	parsed, err := middleware.ParseFailureMode(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) cookieIntegrityMode(envVar string, value string) middleware.CookieIntegrityMode {
+	parsed, err := middleware.ParseCookieIntegrityMode(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+func (p *configParser) canonicalizeMode(envVar string, value string) middleware.CanonicalizeMode {
+	parsed, err := middleware.ParseCanonicalizeMode(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+// encryptionKey decodes a base64 AES key, e.g. from `openssl rand -base64 32`
+func (p *configParser) encryptionKey(envVar string, value string) []byte {
+	if value == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+		return nil
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		p.errs = append(p.errs, fmt.Errorf("%s: key must decode to 16, 24 or 32 bytes, got %d", envVar, len(key)))
+		return nil
+	}
+	return key
+}
+
+func (p *configParser) lokiLabels(envVar string, value string) []string {
+	labels := splitList(value)
+	for _, label := range labels {
+		if err := audit.ValidateLokiLabel(label); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+		}
+	}
+	return labels
+}
+
+// classRetention parses comma-separated "class=duration" pairs
+func (p *configParser) classRetention(envVar string, value string) map[string]time.Duration {
+	parsed := map[string]time.Duration{}
+	for class, retention := range p.pairs(envVar, value) {
+		parsed[strings.ToLower(class)] = p.duration(envVar, retention)
+	}
+	return parsed
+}
+
+// quotas parses "tenant=requests" pairs
+func (p *configParser) quotas(envVar string, value string) map[string]int64 {
+	parsed := map[string]int64{}
+	for tenant, quota := range p.pairs(envVar, value) {
+		parsed[tenant] = int64(p.integer(envVar, quota))
+	}
+	return parsed
+}
+
+func (p *configParser) routePolicy(envVar string, value string) rbac.Policy {
+	policy, err := rbac.ParsePolicy(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return policy
+}
+
+func (p *configParser) role(envVar string, value string) rbac.Role {
+	role, err := rbac.ParseRole(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return role
+}
+
+func (p *configParser) asnPolicies(envVar string, value string) []middleware.ASNPolicy {
+	policies, err := middleware.ParseASNPolicies(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return policies
+}
+
+func (p *configParser) webhookEndpoints(envVar string, value string) []middleware.WebhookEndpoint {
+	endpoints, err := middleware.ParseWebhookEndpoints(value, os.Getenv)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return endpoints
+}
+
+func (p *configParser) threatIntelFeeds(envVar string, value string) []threatintel.Feed {
+	feeds, err := threatintel.ParseFeeds(value, os.Getenv)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return feeds
+}
+
+func (p *configParser) timeOfDay(envVar string, value string) time.Duration {
+	parsed, err := report.ParseTimeOfDay(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", envVar, err))
+	}
+	return parsed
+}
+
+// retry parses the <prefix>_RETRY_ATTEMPTS and <prefix>_RETRY_BACKOFF settings of a remote audit sink
+func (p *configParser) retry(prefix string, attempts string, backoff string) audit.RetryOptions {
+	options := audit.RetryOptions{
+		Attempts: p.integer(prefix+"_RETRY_ATTEMPTS", attempts),
+		Backoff:  p.duration(prefix+"_RETRY_BACKOFF", backoff),
+	}
+	if options.Attempts < 1 {
+		p.errs = append(p.errs, fmt.Errorf("%s_RETRY_ATTEMPTS: must be at least 1", prefix))
+	}
+	return options
+}
@@ -0,0 +1,99 @@
+package coraza
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// Transaction fields that can be added to the access log
+const (
+	// AccessLogAnomalyScore is the CRS inbound anomaly score
+	AccessLogAnomalyScore = "anomaly_score"
+	// AccessLogMatchedVars are the variables that matched rules logging a message, e.g. ARGS:id
+	AccessLogMatchedVars = "matched_vars"
+	// AccessLogAttackTypes are the attack-* tags of the matched rules without their prefix, e.g. sqli
+	AccessLogAttackTypes = "attack_types"
+	// AccessLogTXPrefix adds any TX variable, e.g. tx.inbound_anomaly_score_pl1
+	AccessLogTXPrefix = "tx."
+)
+
+// ValidAccessLogField reports whether a transaction field can be added to the access log
+func ValidAccessLogField(field string) bool {
+	switch field {
+	case AccessLogAnomalyScore, AccessLogMatchedVars, AccessLogAttackTypes:
+		return true
+	}
+	name, ok := strings.CutPrefix(field, AccessLogTXPrefix)
+	return ok && name != ""
+}
+
+// transactionAttrs returns the access log attributes of the transaction's fields, leaving out empty ones
+func transactionAttrs(tx types.Transaction, fields []string) []slog.Attr {
+	var attrs []slog.Attr
+	for _, field := range fields {
+		switch field {
+		case AccessLogAnomalyScore:
+			attrs = append(attrs, slog.Int(field, anomalyScore(tx)))
+		case AccessLogMatchedVars:
+			if vars := matchedVars(tx.MatchedRules()); len(vars) > 0 {
+				attrs = append(attrs, slog.Any(field, vars))
+			}
+		case AccessLogAttackTypes:
+			if types := attackTypes(tx.MatchedRules()); len(types) > 0 {
+				attrs = append(attrs, slog.Any(field, types))
+			}
+		default:
+			if value := txVariable(tx, strings.TrimPrefix(field, AccessLogTXPrefix)); value != "" {
+				attrs = append(attrs, slog.String(field, value))
+			}
+		}
+	}
+	return attrs
+}
+
+// matchedVars returns the distinct variables that matched rules logging a message
+func matchedVars(matched []types.MatchedRule) []string {
+	var vars []string
+	for _, rule := range matched {
+		if rule.Message() == "" {
+			continue
+		}
+		for _, data := range rule.MatchedDatas() {
+			name := data.Variable().Name()
+			if data.Key() != "" {
+				name += ":" + data.Key()
+			}
+			if !slices.Contains(vars, name) {
+				vars = append(vars, name)
+			}
+		}
+	}
+	return vars
+}
+
+// attackTypes returns the CRS attack categories of the matched rules, e.g. sqli for attack-sqli
+func attackTypes(matched []types.MatchedRule) []string {
+	var attacks []string
+	for _, tag := range matchedTags(matched) {
+		if attack, ok := strings.CutPrefix(tag, "attack-"); ok {
+			attacks = append(attacks, attack)
+		}
+	}
+	return attacks
+}
+
+// txVariable returns the first value of a TX variable
+func txVariable(tx types.Transaction, name string) string {
+	state, ok := tx.(plugintypes.TransactionState)
+	if !ok {
+		return ""
+	}
+	if values := state.Variables().TX().Get(strings.ToLower(name)); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package coraza
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+var blockPageTemplate = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Request blocked</title>
+</head>
+<body style="font-family: sans-serif; color: #222; max-width: 40em; margin: 4em auto; padding: 0 1em;">
+<h1>Request blocked</h1>
+<p>This request was blocked by the web application firewall because it looked like an attack.</p>
+<p>If you think this is a mistake, please get in touch and quote this reference:</p>
+<p><code style="font-size: 1.2em;">{{.TransactionID}}</code></p>
+{{- if .AppealURL}}
+<p><a href="{{.AppealURL}}">Ask for this block to be reviewed</a></p>
+{{- end}}
+{{- if .AppealEmail}}
+<p>You can also email <a href="{{.AppealMailto}}">{{.AppealEmail}}</a>.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// blockPageData is rendered into the embedded block page
+type blockPageData struct {
+	TransactionID string
+	AppealURL     string
+	AppealEmail   string
+	AppealMailto  string
+}
+
+func (d DenyResponse) blockPageData(transactionID string) blockPageData {
+	data := blockPageData{TransactionID: transactionID, AppealEmail: d.AppealEmail}
+	if d.AppealURL != nil {
+		data.AppealURL = withTransactionID(*d.AppealURL, transactionID).String()
+	}
+	if d.AppealEmail != "" {
+		mailto := url.URL{Scheme: "mailto", Opaque: d.AppealEmail, RawQuery: "subject=" + url.PathEscape("Blocked request "+transactionID)}
+		data.AppealMailto = mailto.String()
+	}
+	return data
+}
+
+// withTransactionID adds the transaction ID to the query of a block or appeal page URL
+func withTransactionID(location url.URL, transactionID string) *url.URL {
+	query := location.Query()
+	query.Set("transaction_id", transactionID)
+	location.RawQuery = query.Encode()
+	return &location
+}
+
+// writeBlockPage answers a browser with the embedded block page
+func writeBlockPage(w http.ResponseWriter, status int, page blockPageData) {
+	var buf bytes.Buffer
+	if err := blockPageTemplate.Execute(&buf, page); err != nil {
+		slog.Warn("Failed to render block page", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Warn("Failed to write block page", "error", err)
+	}
+}
+
+// redirectToBlockPage sends a browser to the block page hosted at location, passing the transaction ID along
+func redirectToBlockPage(w http.ResponseWriter, location url.URL, transactionID string) {
+	w.Header().Set("Location", withTransactionID(location, transactionID).String())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusFound)
+}
@@ -0,0 +1,49 @@
+package coraza
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// recordBody makes the request body readable for a snapshot, returning nil when the request has no body
+func recordBody(store *capture.Store, r *http.Request) *capture.Body {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := capture.NewBody(r.Body, store.MaxBodySize())
+	r.Body = body
+	return body
+}
+
+// captureRequest stores a snapshot of the request when the capture policy selects it
+func captureRequest(store *capture.Store, tx types.Transaction, r *http.Request, body *capture.Body, decision string, status int) {
+	ruleIDs := MatchedRuleIDs(tx)
+	if !store.Wants(decision, ruleIDs) {
+		return
+	}
+	snapshot := capture.Snapshot{
+		TransactionID: tx.ID(),
+		Time:          time.Now().UTC(),
+		ClientIP:      middleware.ClientIP(r),
+		RemoteAddr:    r.RemoteAddr,
+		Method:        r.Method,
+		URI:           r.URL.String(),
+		Proto:         r.Proto,
+		Host:          r.Host,
+		Headers:       r.Header.Clone(),
+		Decision:      decision,
+		Status:        status,
+		RuleIDs:       ruleIDs,
+	}
+	if body != nil {
+		snapshot.Body, snapshot.BodyTruncated = body.Bytes()
+	}
+	if err := store.Record(snapshot); err != nil {
+		slog.Error("Failed to capture request", "error", err, "id", tx.ID())
+	}
+}
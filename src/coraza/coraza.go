@@ -7,69 +7,477 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
 	coreruleset "github.com/corazawaf/coraza-coreruleset/v4"
 	"github.com/corazawaf/coraza/v3"
-	txhttp "github.com/corazawaf/coraza/v3/http"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func NewCorazaWAFHandler(auditLogProcessor *audit.LogProcessor) http.Handler {
-	// Create the WAF configuration
-	cfg := coraza.NewWAFConfig().
-		WithRootFS(coreruleset.FS) // Use the embedded Core Rule Set
+type WAFHandlerOptions struct {
+	// FailurePolicy determines how requests are answered when the WAF fails to evaluate them
+	FailurePolicy middleware.FailurePolicy
+	// RequestLimits are enforced before the request is handed to Coraza
+	RequestLimits middleware.RequestLimits
+	// Headers are rewritten before the request is handed to Coraza and the script hooks
+	Headers middleware.HeaderTransform
+	// Protocol restricts request methods and HTTP versions before the request is handed to Coraza
+	Protocol middleware.ProtocolPolicy
+	// Deadline bounds how long a request may take to evaluate
+	Deadline middleware.DeadlineOptions
+	// Canonicalize determines whether the URI is canonicalized before the request is handed to Coraza
+	Canonicalize middleware.CanonicalizeMode
+	// DenyResponse determines the status and headers returned for denied requests
+	DenyResponse DenyResponse
+	// CookieIntegrity verifies HMAC-signed cookies before the request is handed to Coraza
+	CookieIntegrity middleware.CookieIntegrity
+	// CSRF is enforced before the request is handed to Coraza
+	CSRF middleware.CSRFOptions
+	// Webhooks verifies the signatures of webhook calls, which a rule added to the directives denies when they fail.
+	// Nil disables verification.
+	Webhooks *middleware.WebhookVerifier
+	// Honeypot configures trap paths that ban the requesting client
+	Honeypot middleware.HoneypotOptions
+	// Bans are rejected before the request is handed to Coraza. Nil disables banning and the honeypot.
+	Bans *ban.List
+	// Objects are the IP lists, rule exclusions and bypass tokens managed through the admin API. Nil disables them.
+	Objects *store.Store
+	// ASNPolicies allow, deny or rate limit requests by the autonomous system of the client. Nil disables them.
+	ASNPolicies *middleware.ASNPolicies
+	// ThreatIntel blocks or flags requests matching threat intel indicators. A nil Matcher disables it.
+	ThreatIntel middleware.ThreatIntelOptions
+	// VerifiedCrawlers sets a header naming the crawler a request was verified to come from. A nil Resolver
+	// disables it.
+	VerifiedCrawlers middleware.VerifiedCrawlerOptions
+	// Tenants counts the requests of each tenant and enforces their quotas. Nil disables tenant accounting.
+	Tenants *middleware.TenantAccounting
+	// DirectivesVar names the environment variable holding the directives, DIRECTIVES when empty
+	DirectivesVar string
+	// DirectiveHistory keeps previously loaded directive sets for rollback
+	DirectiveHistory DirectiveHistoryOptions
+	// Debug captures the Coraza debug log of selected requests. Nil disables capture.
+	Debug *DebugCapture
+	// Capture stores snapshots of the requests selected by its policy. Nil disables it.
+	Capture *capture.Store
+	// DebugLog samples the Coraza debug log written to the application log
+	DebugLog DebugLogOptions
+	// AccessLog receives a line per request. Nil uses the application log.
+	AccessLog *slog.Logger
+	// AccessLogFields are the transaction fields added to the access log, see ValidAccessLogField
+	AccessLogFields []string
+	// RequestTags are attached to requests by rules and surfaced as a response header and metric labels
+	RequestTags RequestTagOptions
+	// OnDecision is told the rule engine's decision on every request. Nil disables it.
+	OnDecision DecisionObserver
+	// DecisionWebhook is consulted for every request the WAF allows
+	DecisionWebhook DecisionWebhookOptions
+	// OPA evaluates a Rego policy for every request the WAF allows
+	OPA OPAOptions
+	// Scoring blends the score of an external scoring service into the decision on every request the WAF allows
+	Scoring ScoringOptions
+	// Script runs operator-provided Lua hooks. Nil disables scripting.
+	Script *script.Engine
+	// WarmupRounds is the number of times the warm-up transactions are run through newly compiled directives.
+	// Zero disables warm-up.
+	WarmupRounds int
+}
+
+// WAFHandler is the forward-auth handler. Its directives can be rolled back to a previously loaded version at runtime.
+type WAFHandler struct {
+	http.Handler
+
+	auditLogProcessor *audit.LogProcessor
+	waf               atomic.Pointer[coraza.WAF]
+	history           *directiveHistory
+	debug             *DebugCapture
+	debugLog          DebugLogOptions
+	warmupRounds      int
+	// webhooks adds the rule denying webhook calls that fail verification
+	webhooks bool
+	warmup   atomic.Pointer[WarmupReport]
+	rules    atomic.Pointer[[]RuleInfo]
+	// reloadMu serializes rollbacks
+	reloadMu sync.Mutex
+}
 
-	directivesFromEnv, err := loadDirectivesFromEnv()
+func NewCorazaWAFHandler(auditLogProcessor *audit.LogProcessor, options WAFHandlerOptions) *WAFHandler {
+	directivesFromEnv, err := loadDirectivesFromEnv(options.DirectivesVar)
 	if err != nil {
 		slog.Error("Failed to load WAF directives", "error", err)
 		log.Fatal(err)
 	}
-	if len(directivesFromEnv) > 0 {
-		cfg = cfg.WithDirectives(directivesFromEnv)
-	}
 
-	slog.Info("Setting audit log directives to support log processing")
-	cfg = auditLogProcessor.SetAuditLogDirectives(cfg)
+	h := &WAFHandler{
+		auditLogProcessor: auditLogProcessor,
+		debug:             options.Debug,
+		debugLog:          options.DebugLog,
+		warmupRounds:      options.WarmupRounds,
+		webhooks:          options.Webhooks != nil,
+	}
+	h.history, err = newDirectiveHistory(options.DirectiveHistory)
+	if err != nil {
+		slog.Error("Failed to open directive history", "error", err)
+		log.Fatal(err)
+	}
 
 	// Create the WAF instance
-	waf, err := coraza.NewWAF(cfg)
+	waf, err := h.newWAF(directivesFromEnv)
 	if err != nil {
 		slog.Error("Failed to create WAF instance", "error", err)
 		log.Fatal(err)
 	}
+	h.waf.Store(&waf)
+	if _, err := h.history.record(directivesFromEnv); err != nil {
+		slog.Error("Failed to record directive version", "error", err)
+	}
 
-	slog.Info("WAF client initialized successfully")
+	slog.Info("WAF client initialized successfully", "warmup_duration", h.Warmup().Duration)
 
 	mux := http.NewServeMux()
 	// Configure the WAF HTTP handler with proxy header middleware
-	handler := wafHandler(waf, auditLogProcessor)
+	handler := wafHandler(h.currentWAF, auditLogProcessor, options)
+	// Scripts are consulted first, then OPA, then the decision webhook, then the scoring service
+	if options.Script != nil && options.Script.Has(script.HookDecision) {
+		handler = decisionMiddleware(handler, scriptStage(options.Script), options.FailurePolicy)
+	}
+	if options.OPA.URL != "" {
+		handler = decisionMiddleware(handler, opaStage(options.OPA), options.FailurePolicy)
+	}
+	if options.DecisionWebhook.URL != "" {
+		handler = decisionMiddleware(handler, decisionWebhookStage(options.DecisionWebhook), options.FailurePolicy)
+	}
+	if options.Scoring.URL != "" {
+		handler = decisionMiddleware(handler, scoringStage(options.Scoring), options.FailurePolicy)
+	}
+	if options.Webhooks != nil {
+		handler = middleware.WebhookSignatureMiddleware(handler, options.Webhooks)
+	}
+	if len(options.CSRF.Paths) > 0 {
+		handler = middleware.CSRFMiddleware(handler, options.CSRF)
+	}
+	if len(options.CookieIntegrity.Cookies) > 0 {
+		handler = middleware.CookieIntegrityMiddleware(handler, options.CookieIntegrity)
+	}
+	if options.ASNPolicies != nil {
+		handler = middleware.ASNPolicyMiddleware(handler, options.ASNPolicies)
+	}
+	if options.ThreatIntel.Matcher != nil {
+		handler = middleware.ThreatIntelMiddleware(handler, options.ThreatIntel)
+	}
+	if options.Objects != nil {
+		handler = middleware.StoredObjectsMiddleware(handler, options.Objects)
+	}
+	if options.Bans != nil {
+		if len(options.Honeypot.Paths) > 0 {
+			handler = middleware.HoneypotMiddleware(handler, options.Honeypot, options.Bans)
+		}
+		handler = middleware.BanMiddleware(handler, options.Bans)
+	}
+	if !options.Protocol.Empty() {
+		handler = middleware.ProtocolPolicyMiddleware(handler, options.Protocol)
+	}
+	// Tenants are identified after the request script, which may set the tenant header
+	if options.Tenants != nil {
+		handler = middleware.TenantMiddleware(handler, options.Tenants)
+	}
+	if options.Script != nil && options.Script.Has(script.HookRequest) {
+		handler = scriptRequestMiddleware(handler, options.Script, options.FailurePolicy)
+	}
+	if options.Canonicalize != "" && options.Canonicalize != middleware.CanonicalizeOff {
+		handler = middleware.CanonicalizeMiddleware(handler, options.Canonicalize)
+	}
+	if !options.Headers.Empty() {
+		handler = middleware.HeaderTransformMiddleware(handler, options.Headers)
+	}
+	// The crawler is verified from the client IP, after the proxy headers are applied
+	if options.VerifiedCrawlers.Resolver != nil {
+		handler = middleware.VerifiedCrawlerMiddleware(handler, options.VerifiedCrawlers)
+	}
+	// Limits are enforced on the forwarded request before any other stage parses or rewrites it
+	handler = middleware.RequestLimitsMiddleware(handler, options.RequestLimits)
 	handler = middleware.ProxyHeaderMiddleware(handler)
-	handler = middleware.LoggingMiddleware(handler, slog.LevelDebug)
-	handler = middleware.PanicMiddleware(handler)
+	if options.Deadline.Header != "" || options.Deadline.Default > 0 {
+		handler = middleware.DeadlineMiddleware(handler, options.Deadline, options.FailurePolicy)
+	}
+	if len(options.DenyResponse.Actions) > 0 {
+		handler = tarpitMiddleware(handler)
+	}
+	accessLog := options.AccessLog
+	if accessLog == nil {
+		accessLog = slog.Default()
+	}
+	handler = middleware.LoggingMiddleware(handler, accessLog, slog.LevelDebug)
+	handler = middleware.FailurePolicyMiddleware(handler, options.FailurePolicy)
 	mux.Handle("/", handler)
-	return mux
+	h.Handler = mux
+	return h
 }
 
-func wafHandler(waf coraza.WAF, auditLogProcessor *audit.LogProcessor) http.Handler {
-	handler := txhttp.WrapHandler(waf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	}))
+// Warmup reports the warm-up of the active directives
+func (h *WAFHandler) Warmup() WarmupReport {
+	return *h.warmup.Load()
+}
+
+// DirectiveHistory lists the directive sets loaded into the WAF, most recent first
+func (h *WAFHandler) DirectiveHistory() []DirectiveVersion {
+	return h.history.list()
+}
+
+// RollbackDirectives compiles a previously loaded directive set and swaps it in without dropping requests.
+// The current directives stay active if the version cannot be compiled.
+func (h *WAFHandler) RollbackDirectives(hash string) error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	directives, err := h.history.get(hash)
+	if err != nil {
+		return err
+	}
+	waf, err := h.newWAF(directives)
+	if err != nil {
+		metricDirectiveReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	h.waf.Store(&waf)
+	metricDirectiveReloads.WithLabelValues("success").Inc()
+	slog.Info("Rolled back WAF directives", "hash", hash)
+	if _, err := h.history.record(directives); err != nil {
+		slog.Error("Failed to record directive version", "error", err)
+	}
+	return nil
+}
+
+func (h *WAFHandler) currentWAF() coraza.WAF {
+	return *h.waf.Load()
+}
+
+// RuleEngineOff reports whether the active directives switch the rule engine off, letting every request through
+// without inspection
+func (h *WAFHandler) RuleEngineOff() bool {
+	tx := h.currentWAF().NewTransaction()
+	defer tx.Close()
+	return tx.IsRuleEngineOff()
+}
+
+// WebhookSignatureRuleID is the ID of the rule denying webhook calls that fail signature verification
+const WebhookSignatureRuleID = 1100000
 
+// webhookSignatureDirective denies the webhook calls WebhookSignatureMiddleware marked, so they are audited like any
+// other violation
+var webhookSignatureDirective = fmt.Sprintf(`SecRule REQUEST_HEADERS:%s "@rx ." "id:%d,phase:1,deny,status:401,log,msg:'Webhook signature verification failed',logdata:'%%{MATCHED_VAR}',severity:'CRITICAL',tag:'webhook-signature'"`,
+	middleware.WebhookSignatureHeader, WebhookSignatureRuleID)
+
+// wafConfig returns the configuration of the directives, with the webhook signature rule when webhooks are verified
+func wafConfig(directives string, webhooks bool) coraza.WAFConfig {
+	cfg := coraza.NewWAFConfig().
+		WithRootFS(coreruleset.FS) // Use the embedded Core Rule Set
+	if len(directives) > 0 {
+		cfg = cfg.WithDirectives(directives)
+	}
+	if webhooks {
+		cfg = cfg.WithDirectives(webhookSignatureDirective)
+	}
+	return cfg
+}
+
+func (h *WAFHandler) newWAF(directives string) (coraza.WAF, error) {
+	// Create the WAF configuration
+	cfg := wafConfig(directives, h.webhooks)
+	cfg = cfg.WithDebugLogger(newSlogDebugLogger(h.debugLog, h.debug))
+
+	slog.Info("Setting audit log directives to support log processing")
+	cfg = h.auditLogProcessor.SetAuditLogDirectives(cfg)
+
+	waf, err := coraza.NewWAF(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile directives: %w", err)
+	}
+
+	// Warm up before the WAF is swapped in so the first real requests don't pay for lazy initialization
+	warmup := warmUp(waf, h.warmupRounds)
+	h.warmup.Store(&warmup)
+
+	sources := []string{directives}
+	if h.webhooks {
+		sources = append(sources, webhookSignatureDirective)
+	}
+	rules, err := listRules(coreruleset.FS, sources...)
+	if err != nil {
+		slog.Warn("Failed to list the rules of the directives", "error", err)
+		rules = []RuleInfo{}
+	}
+	h.rules.Store(&rules)
+	return waf, nil
+}
+
+// ValidateOptions configures how directives are validated
+type ValidateOptions struct {
+	// Compile compiles the directives rather than only loading them
+	Compile bool
+	// Webhooks adds the webhook signature rule, as when webhook endpoints are configured
+	Webhooks bool
+	// AuditLogDirectives are the audit log directives the WAF is built with
+	AuditLogDirectives string
+}
+
+// ValidateDirectives loads the directives in the environment variable and, when compiling, compiles them with the
+// directives the WAF handler adds, without creating it. An empty name validates DIRECTIVES.
+func ValidateDirectives(name string, options ValidateOptions) error {
+	directives, err := loadDirectivesFromEnv(name)
+	if err != nil || !options.Compile {
+		return err
+	}
+
+	cfg := wafConfig(directives, options.Webhooks)
+	if options.AuditLogDirectives != "" {
+		cfg = cfg.WithDirectives(options.AuditLogDirectives)
+	}
+	if _, err := coraza.NewWAF(cfg); err != nil {
+		return fmt.Errorf("failed to compile directives: %w", err)
+	}
+	return nil
+}
+
+func wafHandler(currentWAF func() coraza.WAF, auditLogProcessor *audit.LogProcessor, options WAFHandlerOptions) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Ensure the audit log hasn't been locked by the log processor
 		auditLogProcessor.Lock.Lock()
 		defer auditLogProcessor.Lock.Unlock()
+		if r.Context().Err() != nil {
+			// Traefik gave up while the request waited for the lock, so nobody is waiting for the verdict
+			metricCancelledRequests.WithLabelValues("queued").Inc()
+			return
+		}
+
+		start := time.Now()
+		// Synthetic requests are evaluated but leave no trace in the audit log, metrics, captures or decision observers
+		synthetic := middleware.IsSynthetic(r)
+		decision := "error"
+		status := 0
+		var tags []string
+		tx := currentWAF().NewTransaction()
+		if options.Objects != nil {
+			excludeRules(tx, options.Objects.Enforced().Excluded(r.URL.Path))
+		}
+		if options.Debug != nil && !synthetic {
+			if trigger := options.Debug.trigger(r); trigger != "" {
+				options.Debug.begin(tx, r, trigger)
+			}
+		}
+		var body *capture.Body
+		if options.Capture != nil && !synthetic {
+			body = recordBody(options.Capture, r)
+		}
+		defer func() {
+			if observe, ok := r.Context().Value(transactionObserverKey{}).(TransactionObserver); ok {
+				observe(tx)
+			}
+			if !synthetic {
+				metrics.ObserveWithExemplar(
+					metricRequestDuration.WithLabelValues(decision),
+					time.Since(start).Seconds(),
+					requestExemplar(tx.ID(), r),
+				)
+
+				// Run the logging phase and write the audit log
+				tx.ProcessLogging()
+				for _, tag := range tags {
+					metricRequestTags.WithLabelValues(tag, decision).Inc()
+				}
+				if len(options.AccessLogFields) > 0 {
+					middleware.AddAccessLogAttrs(r, transactionAttrs(tx, options.AccessLogFields)...)
+				}
+				if options.OnDecision != nil {
+					options.OnDecision(newDecision(tx, r, decision, status))
+				}
+				if options.Debug != nil {
+					options.Debug.finish(tx, decision)
+				}
+				if options.Capture != nil {
+					captureRequest(options.Capture, tx, r, body, decision, status)
+				}
+			}
+			if err := tx.Close(); err != nil {
+				slog.Error("Failed to close WAF transaction", "error", err, "id", tx.ID())
+			}
+		}()
+
+		if tx.IsRuleEngineOff() {
+			decision, status = "allow", http.StatusOK
+			middleware.MarkBypassed(r, middleware.BypassRuleEngineOff)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		it, err := processRequest(tx, r)
+		if r.Context().Err() != nil && (err != nil || it == nil) {
+			decision = "cancelled"
+			metricCancelledRequests.WithLabelValues("evaluation").Inc()
+			// Record the cancellation as the response so it shows up in the audit log
+			tx.ProcessResponseHeaders(audit.StatusClientClosedRequest, r.Proto)
+			return
+		}
+		if err != nil {
+			options.FailurePolicy.Fail(w, r, middleware.FailureClassBodyRead, err)
+			return
+		}
+		if it == nil {
+			// Record the forward-auth response so it shows up in the audit log
+			it = tx.ProcessResponseHeaders(http.StatusOK, r.Proto)
+		}
+		tags = requestTags(tx.MatchedRules(), options.RequestTags.Prefix)
+		tagRequest(w, r, tags, options.RequestTags)
+		if it != nil {
+			decision = "allow"
+			if status = options.DenyResponse.write(w, r, tx, it); status != http.StatusOK {
+				decision = "deny"
+			}
+			return
+		}
 
-		handler.ServeHTTP(w, r)
+		decision, status = "allow", http.StatusOK
+		if tx.IsRuleEngineOff() {
+			// A rule switched the engine off for this request
+			middleware.MarkBypassed(r, middleware.BypassPolicyExemption)
+		}
+		w.WriteHeader(http.StatusOK)
 	})
 }
 
-func loadDirectivesFromEnv() (string, error) {
-	directives := os.Getenv("DIRECTIVES")
+// requestExemplar links a metric sample to the transaction ID in the audit log and, when the
+// request carries a W3C traceparent header, to its trace
+func requestExemplar(transactionID string, r *http.Request) prometheus.Labels {
+	exemplar := prometheus.Labels{"transaction_id": transactionID}
+	// traceparent is version-traceid-parentid-flags
+	if _, rest, ok := strings.Cut(r.Header.Get("Traceparent"), "-"); ok {
+		if traceID, rest, ok := strings.Cut(rest, "-"); ok && strings.Count(rest, "-") == 1 {
+			exemplar["trace_id"] = traceID
+		}
+	}
+	return exemplar
+}
+
+// DefaultDirectivesVar is the environment variable holding the directives of the main WAF listener
+const DefaultDirectivesVar = "DIRECTIVES"
+
+func loadDirectivesFromEnv(name string) (string, error) {
+	if name == "" {
+		name = DefaultDirectivesVar
+	}
+	directives := os.Getenv(name)
 
 	if directives == "" {
-		return "", fmt.Errorf("DIRECTIVES environment variable is required but not set")
+		return "", fmt.Errorf("%s environment variable is required but not set", name)
 	}
 
 	// Basic validation - check for required directives
@@ -80,6 +488,11 @@ func loadDirectivesFromEnv() (string, error) {
 		}
 	}
 
-	slog.Info("Loaded WAF directives from environment", "length", len(directives))
+	directives, err := renderDirectives(directives)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Loaded WAF directives from environment", "variable", name, "length", len(directives))
 	return directives, nil
 }
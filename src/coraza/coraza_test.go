@@ -1,12 +1,33 @@
 package coraza
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
-	"path"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+	coreruleset "github.com/corazawaf/coraza-coreruleset/v4"
+	"github.com/corazawaf/coraza/v3/debuglog"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,13 +44,13 @@ SecRuleEngine On`
 func TestCorazaWAFHandler(t *testing.T) {
 	tempDir := t.TempDir()
 	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
-		AuditLogPath: path.Join(tempDir, "audit.log"),
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
 	})
 
 	t.Setenv("DIRECTIVES", mockDirectives)
 
 	// Create test handler for WAF
-	wafHandler := NewCorazaWAFHandler(auditLogProcessor)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
 	if wafHandler == nil {
 		t.Fatal("Expected WAF handler to be non-nil")
 	}
@@ -58,11 +79,120 @@ func TestCorazaWAFHandler(t *testing.T) {
 	})
 }
 
+func TestCapture(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", mockDirectives)
+	captures, err := capture.Open(capture.Options{Dir: t.TempDir(), Retention: time.Hour, SampleRate: 1, Decisions: []string{"deny"}, MaxBodySize: 1024})
+	assert.NoError(t, err)
+	defer captures.Close()
+	wafServer := httptest.NewServer(NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{Capture: captures}))
+	defer wafServer.Close()
+
+	for _, query := range []string{"", "?file=../../etc/passwd"} {
+		req, err := http.NewRequest(http.MethodPost, wafServer.URL+"/upload"+query, strings.NewReader("name=report"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	summaries := captures.List(capture.Filter{})
+	if assert.Len(t, summaries, 1, "Expected only the denied request to be captured") {
+		snapshot, ok, err := captures.Get(summaries[0].TransactionID)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "/upload?file=../../etc/passwd", snapshot.URI)
+		assert.Equal(t, "application/x-www-form-urlencoded", snapshot.Headers.Get("Content-Type"))
+		assert.Equal(t, "name=report", string(snapshot.Body))
+		assert.Equal(t, "deny", snapshot.Decision)
+		assert.Equal(t, http.StatusForbidden, snapshot.Status)
+		assert.NotEmpty(t, snapshot.RuleIDs)
+	}
+}
+
+func TestAccessLogFields(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", mockDirectives)
+	var logs bytes.Buffer
+	wafServer := httptest.NewServer(NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		AccessLog:       slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		AccessLogFields: []string{AccessLogAnomalyScore, AccessLogMatchedVars, AccessLogAttackTypes, "tx.blocking_paranoia_level"},
+	}))
+	defer wafServer.Close()
+
+	resp, err := http.Get(wafServer.URL + "/?file=../../etc/passwd")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.Greater(t, line["anomaly_score"], float64(0))
+	assert.Contains(t, line["matched_vars"], "ARGS:file")
+	assert.Contains(t, line["attack_types"], "lfi")
+	assert.Equal(t, "1", line["tx.blocking_paranoia_level"])
+
+	t.Run("Should validate field names", func(t *testing.T) {
+		assert.True(t, ValidAccessLogField("tx.inbound_anomaly_score_pl1"))
+		assert.False(t, ValidAccessLogField("tx."))
+		assert.False(t, ValidAccessLogField("score"))
+	})
+}
+
+func TestRequestTags(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", `
+SecRule REQUEST_HEADERS:User-Agent "@contains bot" "id:1001,phase:1,pass,nolog,tag:'request-tag:bot:unverified',tag:'request-tag:suspicious'"
+SecRule ARGS:block "@streq yes" "id:1002,phase:1,deny,status:403,log,msg:'Blocked',tag:'request-tag:suspicious'"
+SecRuleEngine On`)
+	wafServer := httptest.NewServer(NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		RequestTags: RequestTagOptions{Prefix: "request-tag:", Header: "X-Waf-Tags"},
+	}))
+	defer wafServer.Close()
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		decision string
+	}{
+		{name: "Should tag allowed requests", status: http.StatusOK, decision: "allow"},
+		{name: "Should tag denied requests", query: "?block=yes", status: http.StatusForbidden, decision: "deny"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metricRequestTags.WithLabelValues("suspicious", test.decision))
+			req, err := http.NewRequest(http.MethodGet, wafServer.URL+"/"+test.query, nil)
+			assert.NoError(t, err)
+			req.Header.Set("User-Agent", "crawlbot")
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.status, resp.StatusCode)
+			assert.Equal(t, "bot:unverified,suspicious", resp.Header.Get("X-Waf-Tags"))
+			assert.Equal(t, before+1, testutil.ToFloat64(metricRequestTags.WithLabelValues("suspicious", test.decision)))
+		})
+	}
+
+	t.Run("Should leave untagged requests alone", func(t *testing.T) {
+		resp, err := http.Get(wafServer.URL + "/")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Empty(t, resp.Header.Values("X-Waf-Tags"))
+	})
+}
+
 func TestLoadDirectivesFromEnv(t *testing.T) {
 	// Set an environment variable for testing
 	t.Setenv("DIRECTIVES", "SecDebugLog /dev/stdout\nSecDebugLogLevel 9")
 
-	directives, err := loadDirectivesFromEnv()
+	directives, err := loadDirectivesFromEnv("")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -71,18 +201,129 @@ func TestLoadDirectivesFromEnv(t *testing.T) {
 	if directives != expected {
 		t.Errorf("Expected directives to be %q, got %q", expected, directives)
 	}
+
+	t.Run("Should load the directives of other listeners from their own variable", func(t *testing.T) {
+		t.Setenv("DIRECTIVES_INTERNAL", "SecRuleEngine DetectionOnly")
+		directives, err := loadDirectivesFromEnv("DIRECTIVES_INTERNAL")
+		assert.NoError(t, err)
+		assert.Equal(t, "SecRuleEngine DetectionOnly", directives)
+
+		_, err = loadDirectivesFromEnv("DIRECTIVES_MISSING")
+		assert.ErrorContains(t, err, "DIRECTIVES_MISSING")
+	})
+}
+
+func TestValidateDirectives(t *testing.T) {
+	t.Setenv("DIRECTIVES", `SecRule ARGS "@rx attack" "id:1100000,phase:1,deny,status:403"`)
+
+	t.Run("Should only load the directives unless compiling", func(t *testing.T) {
+		assert.NoError(t, ValidateDirectives("", ValidateOptions{Webhooks: true}))
+	})
+
+	t.Run("Should compile the directives with the rules the WAF handler adds", func(t *testing.T) {
+		auditLogDirectives := audit.Location{Path: filepath.Join(t.TempDir(), "audit.log")}.ValidationDirectives()
+		assert.NoError(t, ValidateDirectives("", ValidateOptions{Compile: true, AuditLogDirectives: auditLogDirectives}))
+		assert.Error(t, ValidateDirectives("", ValidateOptions{Compile: true, Webhooks: true, AuditLogDirectives: auditLogDirectives}),
+			"Expected the rule ID to clash with the webhook signature rule")
+	})
+}
+
+func TestRollbackDirectives(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+	historyOptions := DirectiveHistoryOptions{Dir: filepath.Join(tempDir, "history"), Size: 2}
+
+	attack := func(handler http.Handler) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/?file=../../etc/passwd", nil))
+		return w.Code
+	}
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	blocking := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{DirectiveHistory: historyOptions})
+	blockingHash := blocking.DirectiveHistory()[0].Hash
+
+	t.Setenv("DIRECTIVES", "SecRuleEngine Off")
+	handler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{DirectiveHistory: historyOptions})
+	assert.Equal(t, http.StatusOK, attack(handler))
+
+	history := handler.DirectiveHistory()
+	assert.Len(t, history, 2, "History should survive restarts")
+	assert.True(t, history[0].Active)
+	assert.Equal(t, blockingHash, history[1].Hash)
+
+	t.Run("Should swap in a previous version", func(t *testing.T) {
+		assert.NoError(t, handler.RollbackDirectives(blockingHash))
+		assert.Equal(t, http.StatusForbidden, attack(handler))
+		assert.Equal(t, blockingHash, handler.DirectiveHistory()[0].Hash)
+	})
+
+	t.Run("Should reject unknown versions", func(t *testing.T) {
+		assert.ErrorIs(t, handler.RollbackDirectives("unknown"), ErrUnknownDirectiveVersion)
+	})
+
+	t.Run("Should keep only the configured number of versions", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", "SecRuleEngine DetectionOnly")
+		handler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{DirectiveHistory: historyOptions})
+		assert.Len(t, handler.DirectiveHistory(), 2)
+
+		files, err := filepath.Glob(filepath.Join(historyOptions.Dir, "*.conf"))
+		assert.NoError(t, err)
+		assert.Len(t, files, 2)
+	})
+}
+
+func TestRenderDirectives(t *testing.T) {
+	t.Setenv("API_HOSTS", "api.example.com, admin.example.com")
+	t.Setenv("ENGINE_MODE", "")
+
+	t.Run("Should interpolate and expand environment variables", func(t *testing.T) {
+		rendered, err := renderDirectives(`{{ range split .Env.API_HOSTS }}# {{ . }}
+{{ end }}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "# api.example.com\n# admin.example.com\n", rendered)
+
+		rendered, err = renderDirectives(`SecRuleEngine {{ default "On" .Env.ENGINE_MODE }}
+SecRule REQUEST_HEADERS:Host "@pm {{ split .Env.API_HOSTS | join " " }}" "id:1000,phase:1,pass,nolog"`)
+		assert.NoError(t, err)
+		assert.Equal(t, `SecRuleEngine On
+SecRule REQUEST_HEADERS:Host "@pm api.example.com admin.example.com" "id:1000,phase:1,pass,nolog"`, rendered)
+	})
+
+	t.Run("Should reject unset environment variables", func(t *testing.T) {
+		_, err := renderDirectives(`SecRuleEngine {{ .Env.UNSET_DIRECTIVES_VARIABLE }}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("Should default unset environment variables read with env", func(t *testing.T) {
+		rendered, err := renderDirectives(`SecRuleEngine {{ env "UNSET_DIRECTIVES_VARIABLE" | default "On" }}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "SecRuleEngine On", rendered)
+
+		rendered, err = renderDirectives(`SecRuleEngine {{ default "Off" (env "API_HOSTS") }}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "SecRuleEngine api.example.com, admin.example.com", rendered)
+	})
+
+	t.Run("Should leave directives without templates untouched", func(t *testing.T) {
+		rendered, err := renderDirectives(mockDirectives)
+		assert.NoError(t, err)
+		assert.Equal(t, mockDirectives, rendered)
+	})
 }
 
 func TestProxyHeaderIntegrationWithWAF(t *testing.T) {
 	tempDir := t.TempDir()
 	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
-		AuditLogPath: path.Join(tempDir, "audit.log"),
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
 	})
 
 	t.Setenv("DIRECTIVES", mockDirectives)
 
 	// Create WAF handler with proxy header middleware
-	wafHandler := NewCorazaWAFHandler(auditLogProcessor)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
 	server := httptest.NewServer(wafHandler)
 	defer server.Close()
 
@@ -119,3 +360,933 @@ func TestProxyHeaderIntegrationWithWAF(t *testing.T) {
 		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "WAF should block malicious request from real client IP")
 	})
 }
+
+func TestDenyResponse(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		DenyResponse: DenyResponse{
+			Status:  http.StatusUnauthorized,
+			Headers: http.Header{"Www-Authenticate": {`Bearer realm="api"`}},
+		},
+	})
+
+	t.Run("Should answer denied requests with the configured status and headers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("GET", "/?file=../../etc/passwd", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Bearer realm="api"`, w.Header().Get("WWW-Authenticate"))
+
+		var body httperror.Response
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, httperror.CodeBlocked, body.Error.Code)
+		assert.Contains(t, body.Error.RuleIDs, 930100)
+		assert.NotEmpty(t, body.Error.TransactionID)
+	})
+
+	t.Run("Should not add headers to allowed requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Should map matched rule tags and IDs to a status", func(t *testing.T) {
+		statusMap, err := ParseStatusMap("id:1=418, tag:attack-lfi=404, tag:attack-xss=400")
+		assert.NoError(t, err)
+
+		mappedHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			DenyResponse: DenyResponse{Status: http.StatusUnauthorized, StatusMap: statusMap},
+		})
+
+		w := httptest.NewRecorder()
+		mappedHandler.ServeHTTP(w, httptest.NewRequest("GET", "/?file=../../etc/passwd", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Should answer decoyed requests with an empty body", func(t *testing.T) {
+		decoyHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			DenyResponse: DenyResponse{
+				Headers:     http.Header{"Www-Authenticate": {`Bearer realm="api"`}},
+				Actions:     []ActionMapping{{Tag: "attack-lfi", Action: DenyActionDecoy}},
+				DecoyStatus: http.StatusNotFound,
+			},
+		})
+
+		w := httptest.NewRecorder()
+		decoyHandler.ServeHTTP(w, httptest.NewRequest("GET", "/?file=../../etc/passwd", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Should show browsers a block page", func(t *testing.T) {
+		browser := func() *http.Request {
+			req := httptest.NewRequest("GET", "/?file=../../etc/passwd", nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0")
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+			return req
+		}
+		actions := []ActionMapping{{Tag: "*", Action: DenyActionRedirect}}
+		redirectURL, err := url.Parse("https://example.com/blocked?lang=en")
+		assert.NoError(t, err)
+
+		redirectHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			DenyResponse: DenyResponse{Actions: actions, RedirectURL: redirectURL},
+		})
+		w := httptest.NewRecorder()
+		redirectHandler.ServeHTTP(w, browser())
+		assert.Equal(t, http.StatusFound, w.Code)
+		location, err := url.Parse(w.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "example.com", location.Host)
+		assert.Equal(t, "en", location.Query().Get("lang"))
+		assert.NotEmpty(t, location.Query().Get("transaction_id"))
+
+		appealURL, err := url.Parse("https://example.com/appeal")
+		assert.NoError(t, err)
+		embeddedHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			DenyResponse: DenyResponse{Actions: actions, AppealURL: appealURL, AppealEmail: "security@example.com"},
+		})
+		w = httptest.NewRecorder()
+		embeddedHandler.ServeHTTP(w, browser())
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "Request blocked")
+		assert.Regexp(t, `href="https://example.com/appeal\?transaction_id=\w+"`, w.Body.String())
+		assert.Regexp(t, `href="mailto:security@example.com\?subject=Blocked%20request%20\w+"`, w.Body.String())
+
+		w = httptest.NewRecorder()
+		api := httptest.NewRequest("GET", "/?file=../../etc/passwd", nil)
+		api.Header.Set("Accept", "application/json")
+		redirectHandler.ServeHTTP(w, api)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), "Expected API clients to keep getting JSON")
+	})
+
+	t.Run("Should hold back the response to tarpitted requests", func(t *testing.T) {
+		tarpitServer := httptest.NewServer(NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			DenyResponse: DenyResponse{
+				Actions:     []ActionMapping{{RuleID: 930100, Action: DenyActionTarpit}},
+				TarpitDelay: 200 * time.Millisecond,
+			},
+		}))
+		defer tarpitServer.Close()
+
+		start := time.Now()
+		resp, err := http.Get(tarpitServer.URL + "/?file=../../etc/passwd")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+		start = time.Now()
+		resp, err = http.Get(tarpitServer.URL + "/")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Less(t, time.Since(start), 200*time.Millisecond, "Expected allowed requests not to be delayed")
+	})
+}
+
+func TestParseStatusMap(t *testing.T) {
+	mappings, err := ParseStatusMap("tag:rate-limit=429,id:911100=405")
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusMapping{{Tag: "rate-limit", Status: 429}, {RuleID: 911100, Status: 405}}, mappings)
+
+	for _, invalid := range []string{"tag:rate-limit", "host:example.com=403", "id:abc=403", "tag:x=forbidden"} {
+		_, err := ParseStatusMap(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseActionMap(t *testing.T) {
+	mappings, err := ParseActionMap("tag:attack-reputation-scanner=tarpit, id:930100=decoy")
+	assert.NoError(t, err)
+	assert.Equal(t, []ActionMapping{{Tag: "attack-reputation-scanner", Action: DenyActionTarpit}, {RuleID: 930100, Action: DenyActionDecoy}}, mappings)
+
+	mappings, err = ParseActionMap("*=redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, []ActionMapping{{Tag: "*", Action: DenyActionRedirect}}, mappings)
+
+	for _, invalid := range []string{"tag:attack-lfi", "id:abc=tarpit", "tag:attack-lfi=drop"} {
+		_, err := ParseActionMap(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestBodyReadFailurePolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+
+	t.Run("Should fail closed on body read errors", func(t *testing.T) {
+		wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			FailurePolicy: middleware.FailurePolicy{Default: middleware.FailClosed},
+		})
+
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("POST", "/", failingReader{}))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Expected status code 503 Service Unavailable")
+	})
+
+	t.Run("Should fail open on body read errors", func(t *testing.T) {
+		wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			FailurePolicy: middleware.FailurePolicy{
+				Default:   middleware.FailClosed,
+				Overrides: map[middleware.FailureClass]middleware.FailureMode{middleware.FailureClassBodyRead: middleware.FailOpen},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("POST", "/", failingReader{}))
+
+		assert.Equal(t, http.StatusOK, w.Code, "Expected status code 200 OK")
+	})
+}
+
+// cancellingReader cancels the request context when the WAF reads the body, as if Traefik timed out mid-evaluation
+type cancellingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancellingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return copy(p, "a=1"), io.EOF
+}
+
+func TestContextCancellation(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		FailurePolicy: middleware.FailurePolicy{Default: middleware.FailClosed},
+	})
+
+	t.Run("Should not evaluate requests cancelled before evaluation", func(t *testing.T) {
+		queued := testutil.ToFloat64(metricCancelledRequests.WithLabelValues("queued"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, queued+1, testutil.ToFloat64(metricCancelledRequests.WithLabelValues("queued")))
+	})
+
+	t.Run("Should record requests cancelled during evaluation as client closed", func(t *testing.T) {
+		evaluation := testutil.ToFloat64(metricCancelledRequests.WithLabelValues("evaluation"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var status string
+		ctx = WithTransactionObserver(ctx, func(tx types.Transaction) {
+			status = tx.(plugintypes.TransactionState).Variables().ResponseStatus().Get()
+		})
+
+		req := httptest.NewRequest("POST", "/", cancellingReader{cancel: cancel}).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+
+		assert.NotEqual(t, http.StatusServiceUnavailable, w.Code, "Expected the cancellation not to count as a WAF failure")
+		assert.Equal(t, "499", status)
+		assert.Equal(t, evaluation+1, testutil.ToFloat64(metricCancelledRequests.WithLabelValues("evaluation")))
+	})
+}
+
+func TestBypassedRequests(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", `SecRuleEngine On
+SecRule REQUEST_URI "@beginsWith /internal/" "id:1000,phase:1,pass,nolog,ctl:ruleEngine=Off"
+SecRule ARGS "@contains attack" "id:1001,phase:1,deny,status:403"`)
+	var accessLog bytes.Buffer
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		AccessLog: slog.New(slog.NewJSONHandler(&accessLog, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	w := httptest.NewRecorder()
+	wafHandler.ServeHTTP(w, httptest.NewRequest("GET", "/internal/metrics?q=attack", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, accessLog.String(), `"bypass":"policy_exemption"`)
+
+	accessLog.Reset()
+	w = httptest.NewRecorder()
+	wafHandler.ServeHTTP(w, httptest.NewRequest("GET", "/public?q=attack", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, accessLog.String(), "bypass")
+}
+
+func TestStoredObjects(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", `SecRuleEngine On
+SecRule ARGS "@contains attack" "id:1001,phase:1,deny,status:403"`)
+	objects, err := store.New("")
+	assert.NoError(t, err)
+	assert.NoError(t, objects.Put(store.CollectionPolicies, "upload", json.RawMessage(`{"path":"/upload files","rule_ids":[1001]}`)))
+	assert.NoError(t, objects.Put(store.CollectionIPLists, "abuser", json.RawMessage(`{"action":"deny","cidrs":["198.51.100.7"]}`)))
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{Objects: objects})
+
+	serve := func(target string, remoteAddr string) int {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Should remove the excluded rules on the decoded path", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("/upload%20files?q=attack", "192.0.2.1:1234"))
+		assert.Equal(t, http.StatusForbidden, serve("/other?q=attack", "192.0.2.1:1234"))
+	})
+
+	t.Run("Should reject the clients of deny lists", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve("/", "198.51.100.7:1234"))
+	})
+}
+
+func TestRunSelfTest(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	decisions := 0
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		OnDecision: func(Decision) { decisions++ },
+	})
+
+	report := RunSelfTest(wafHandler)
+	for name, category := range report.Categories {
+		for _, result := range category.Cases {
+			assert.True(t, result.Passed, "Expected %s/%s to %s, got status %d", name, result.Name, result.Expected, result.Status)
+		}
+	}
+	assert.True(t, report.Passed, "Expected the self-test to pass with the Core Rule Set loaded")
+
+	contents, _ := os.ReadFile(filepath.Join(tempDir, "audit.log"))
+	assert.Empty(t, contents, "Self-test transactions should not be audit logged")
+	assert.Zero(t, decisions, "Self-test transactions should not be observed as decisions")
+
+	t.Run("Should fail when rules are not blocking", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", "SecRuleEngine DetectionOnly")
+		detectionOnlyHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
+
+		report := RunSelfTest(detectionOnlyHandler)
+		assert.False(t, report.Passed)
+		assert.True(t, report.Categories["benign"].Passed, "Expected benign requests to still pass")
+		assert.False(t, report.Categories["sqli"].Passed)
+	})
+}
+
+func TestWarmup(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogPath := filepath.Join(tempDir, "audit.log")
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{AuditLogPath: auditLogPath})
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{WarmupRounds: 2})
+
+	warmup := wafHandler.Warmup()
+	assert.Equal(t, 2*len(selfTestCases), warmup.Transactions)
+	assert.Positive(t, warmup.Duration)
+	assert.Equal(t, 6, warmup.Probes)
+	assert.Equal(t, warmup.Probes, warmup.Detected, "Expected every attack probe to match a CRS rule")
+
+	contents, _ := os.ReadFile(auditLogPath)
+	assert.Empty(t, contents, "Warm-up transactions should not be audit logged")
+
+	t.Run("Should warm up rolled back directives", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", "SecRuleEngine DetectionOnly")
+		options := WAFHandlerOptions{WarmupRounds: 1, DirectiveHistory: DirectiveHistoryOptions{Size: 2}}
+		handler := NewCorazaWAFHandler(auditLogProcessor, options)
+		assert.Zero(t, handler.Warmup().Detected, "Expected no rules to be loaded")
+
+		hash, err := handler.history.record(mockDirectives)
+		assert.NoError(t, err)
+		assert.NoError(t, handler.RollbackDirectives(hash))
+		assert.Equal(t, 6, handler.Warmup().Detected)
+	})
+
+	t.Run("Should skip warm-up when disabled", func(t *testing.T) {
+		handler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
+		assert.Equal(t, WarmupReport{}, handler.Warmup())
+	})
+}
+
+func TestDecisionWebhook(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	var received []DecisionRequest
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var decision DecisionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&decision))
+		received = append(received, decision)
+		switch decision.URI {
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/private":
+			json.NewEncoder(w).Encode(DecisionResponse{Status: http.StatusUnauthorized, Reason: "login required"})
+		default:
+			json.NewEncoder(w).Encode(DecisionResponse{Allow: decision.AnomalyScore == 0})
+		}
+	}))
+	defer webhook.Close()
+
+	options := WAFHandlerOptions{
+		DecisionWebhook: DecisionWebhookOptions{URL: webhook.URL, Timeout: time.Second, Headers: []string{"Authorization"}},
+	}
+	serve := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, options)
+
+	t.Run("Should allow when both allow", func(t *testing.T) {
+		received = nil
+		assert.Equal(t, http.StatusOK, serve(wafHandler, "/").Code)
+		assert.Len(t, received, 1)
+		assert.Equal(t, "allow", received[0].Verdict)
+		assert.Equal(t, map[string]string{"Authorization": "Bearer token"}, received[0].Headers)
+		assert.NotEmpty(t, received[0].TransactionID)
+	})
+
+	t.Run("Should deny when the webhook denies", func(t *testing.T) {
+		w := serve(wafHandler, "/private")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		var response httperror.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, httperror.CodeDecisionDenied, response.Error.Code)
+		assert.Equal(t, "login required", response.Error.Message)
+	})
+
+	t.Run("Should not consult the webhook when the WAF denies", func(t *testing.T) {
+		received = nil
+		assert.Equal(t, http.StatusForbidden, serve(wafHandler, "/?file=../../etc/passwd").Code)
+		assert.Empty(t, received)
+	})
+
+	t.Run("Should apply the failure policy when the webhook fails", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, serve(wafHandler, "/broken").Code)
+
+		options := options
+		options.FailurePolicy = middleware.FailurePolicy{
+			Default:   middleware.FailClosed,
+			Overrides: map[middleware.FailureClass]middleware.FailureMode{middleware.FailureClassDecisionWebhook: middleware.FailOpen},
+		}
+		failOpen := NewCorazaWAFHandler(auditLogProcessor, options)
+		assert.Equal(t, http.StatusOK, serve(failOpen, "/broken").Code)
+	})
+
+	t.Run("Should send detections the WAF did not block", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", mockDirectives+"\nSecRuleEngine DetectionOnly")
+		detectionOnly := NewCorazaWAFHandler(auditLogProcessor, options)
+
+		received = nil
+		assert.Equal(t, http.StatusForbidden, serve(detectionOnly, "/?file=../../etc/passwd").Code)
+		assert.Len(t, received, 1)
+		assert.Equal(t, "detect", received[0].Verdict)
+		assert.Positive(t, received[0].AnomalyScore)
+		assert.NotEmpty(t, received[0].MatchedRules)
+		assert.Contains(t, received[0].Tags, "attack-lfi")
+	})
+}
+
+func TestOPA(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/waf/decision", r.URL.Path)
+		var query struct {
+			Input DecisionRequest `json:"input"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		switch query.Input.URI {
+		case "/undefined":
+			w.Write([]byte(`{}`))
+		case "/admin":
+			if query.Input.Headers["X-Group"] == "admin" {
+				w.Write([]byte(`{"result":true}`))
+				return
+			}
+			w.Write([]byte(`{"result":{"allow":false,"status":404,"reason":"admins only"}}`))
+		default:
+			w.Write([]byte(`{"result":true}`))
+		}
+	}))
+	defer opa.Close()
+
+	webhookCalls := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookCalls++
+		w.Write([]byte(`{"allow":true}`))
+	}))
+	defer webhook.Close()
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		OPA:             OPAOptions{URL: opa.URL + "/v1/data/waf/decision", Timeout: time.Second, Headers: []string{"X-Group"}},
+		DecisionWebhook: DecisionWebhookOptions{URL: webhook.URL, Timeout: time.Second},
+	})
+	serve := func(target string, group string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if group != "" {
+			req.Header.Set("X-Group", group)
+		}
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/", "").Code)
+	assert.Equal(t, http.StatusOK, serve("/admin", "admin").Code)
+	assert.Equal(t, 2, webhookCalls)
+
+	t.Run("Should deny with the policy's status and reason", func(t *testing.T) {
+		w := serve("/admin", "users")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		var response httperror.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, httperror.CodePolicyDenied, response.Error.Code)
+		assert.Equal(t, "admins only", response.Error.Message)
+		assert.Equal(t, 2, webhookCalls, "The decision webhook should not be consulted once the policy denies")
+	})
+
+	t.Run("Should fail when the decision is undefined", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, serve("/undefined", "").Code)
+	})
+}
+
+func TestParseOPAResult(t *testing.T) {
+	response, err := parseOPAResult(json.RawMessage(`false`))
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionResponse{}, response)
+
+	response, err = parseOPAResult(json.RawMessage(`{"allow":false,"status":401,"reason":"login required"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionResponse{Status: 401, Reason: "login required"}, response)
+
+	_, err = parseOPAResult(nil)
+	assert.ErrorIs(t, err, errUndefinedDecision)
+	_, err = parseOPAResult(json.RawMessage(`"yes"`))
+	assert.Error(t, err)
+}
+
+func TestScriptHooks(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+	scriptPath := filepath.Join(tempDir, "hooks.lua")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte(`
+		function on_request(req)
+			if req.uri == "/legacy" then return {allow = false, status = 410} end
+			if req.uri == "/rewritten" then req.uri = "/?file=../../etc/passwd" end
+		end
+		function on_decision(d)
+			return d.uri ~= "/maintenance"
+		end`), 0o644))
+	engine, err := script.Load(script.Options{Path: scriptPath, Timeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{Script: engine})
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/").Code)
+	assert.Equal(t, http.StatusGone, serve("/legacy").Code)
+	assert.Equal(t, http.StatusForbidden, serve("/rewritten").Code, "Coraza should evaluate the rewritten request")
+
+	w := serve("/maintenance")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var response httperror.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, httperror.CodeScriptDenied, response.Error.Code)
+}
+
+func TestRequestLimitsOrder(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+	scriptPath := filepath.Join(tempDir, "hooks.lua")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte(`
+		function on_request(req)
+			return {allow = false, status = 410}
+		end`), 0o644))
+	engine, err := script.Load(script.Options{Path: scriptPath, Timeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		Script:        engine,
+		RequestLimits: middleware.RequestLimits{MaxURLLength: 32},
+	})
+	serve := func(uri string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-Uri", uri)
+		wafHandler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Should enforce the limits on the forwarded request before the request script", func(t *testing.T) {
+		assert.Equal(t, http.StatusRequestURITooLong, serve("/"+strings.Repeat("a", 64)))
+		assert.Equal(t, http.StatusGone, serve("/short"))
+	})
+}
+
+func TestDebugCapture(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	secret := []byte("debug-secret")
+	debug := NewDebugCapture(DebugOptions{IPs: []string{"203.0.113.7"}, Secret: secret, Header: "X-WAF-Debug", Size: 2})
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{Debug: debug})
+
+	t.Run("Should capture requests from a debug IP", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?file=../../etc/passwd", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		traces := debug.Traces()
+		assert.Len(t, traces, 1)
+		assert.Equal(t, "ip", traces[0].Trigger)
+		assert.Equal(t, "deny", traces[0].Decision)
+		assert.NotEmpty(t, traces[0].MatchedRules)
+		assert.Empty(t, traces[0].Log, "Expected the listing to omit debug logs")
+
+		trace, ok := debug.Trace(traces[0].TransactionID)
+		assert.True(t, ok)
+		assert.NotEmpty(t, trace.Log)
+	})
+
+	t.Run("Should capture requests with a valid debug header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-WAF-Debug", SignDebugToken(secret, time.Now().Add(time.Minute)))
+		wafHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+		traces := debug.Traces()
+		assert.Len(t, traces, 2)
+		assert.Equal(t, "header", traces[0].Trigger)
+	})
+
+	t.Run("Should ignore expired and forged debug headers", func(t *testing.T) {
+		for _, token := range []string{
+			SignDebugToken(secret, time.Now().Add(-time.Minute)),
+			SignDebugToken([]byte("wrong"), time.Now().Add(time.Minute)),
+		} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-WAF-Debug", token)
+			assert.Empty(t, debug.trigger(req))
+		}
+	})
+
+	t.Run("Should stop capturing a disabled IP", func(t *testing.T) {
+		assert.True(t, debug.DisableIP("203.0.113.7"))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		wafHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "header", debug.Traces()[0].Trigger)
+		assert.Empty(t, debug.IPs())
+	})
+}
+
+func TestSlogDebugLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace})))
+	defer slog.SetDefault(defaultLogger)
+
+	t.Run("Should log structured messages at the configured level", func(t *testing.T) {
+		buf.Reset()
+		logger := newSlogDebugLogger(DebugLogOptions{}, nil).WithLevel(debuglog.LevelInfo).With(debuglog.Str("tx_id", "abc"))
+		logger.Warn().Int("rule_id", 942100).Msg("Rule matched")
+		logger.Debug().Msg("Below the configured level")
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, "Rule matched", record["msg"])
+		assert.Equal(t, "abc", record["tx_id"])
+		assert.Equal(t, float64(942100), record["rule_id"])
+		assert.Equal(t, "coraza", record["source"])
+	})
+
+	t.Run("Should map trace messages below slog debug", func(t *testing.T) {
+		assert.Equal(t, slog.LevelError, slogLevel(debuglog.LevelError))
+		assert.Equal(t, slog.LevelDebug, slogLevel(debuglog.Level(6)))
+		assert.Equal(t, LevelTrace, slogLevel(debuglog.LevelTrace))
+	})
+
+	t.Run("Should sample identical messages", func(t *testing.T) {
+		sampler := newLogSampler(DebugLogOptions{Initial: 2, Thereafter: 3})
+		now := time.Unix(1700000000, 0)
+		sampler.now = func() time.Time { return now }
+
+		var allowed []bool
+		for range 6 {
+			allowed = append(allowed, sampler.allow(debuglog.LevelDebug, "Evaluating rule"))
+		}
+		assert.Equal(t, []bool{true, true, false, false, true, false}, allowed)
+		assert.True(t, sampler.allow(debuglog.LevelDebug, "Another message"))
+
+		now = now.Add(time.Second)
+		assert.True(t, sampler.allow(debuglog.LevelDebug, "Evaluating rule"), "Expected sampling to reset every second")
+	})
+}
+
+func TestOversizedBodyRejectedBeforeReading(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	t.Setenv("DIRECTIVES", "SecRuleEngine On\nSecRequestBodyAccess On\nSecRequestBodyLimit 1024\nSecRequestBodyLimitAction Reject")
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		FailurePolicy: middleware.FailurePolicy{Default: middleware.FailClosed},
+	})
+
+	// The body fails if read, so a 413 proves the declared length was enough to reject it
+	req := httptest.NewRequest("POST", "/", failingReader{})
+	req.ContentLength = 10 << 20
+	w := httptest.NewRecorder()
+	wafHandler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	t.Run("Should stop reading a partially processed body at the limit", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", "SecRuleEngine On\nSecRequestBodyAccess On\nSecRequestBodyLimit 1024\nSecRequestBodyLimitAction ProcessPartial")
+		partialHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
+
+		body := strings.NewReader(strings.Repeat("a", 1<<20))
+		req := httptest.NewRequest("POST", "/", body)
+		w := httptest.NewRecorder()
+		partialHandler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Greater(t, body.Len(), 1<<19, "Expected the rest of the body to be left unread")
+	})
+}
+
+func TestRequestExemplar(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, prometheus.Labels{"transaction_id": "tx1"}, requestExemplar("tx1", req))
+
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, prometheus.Labels{"transaction_id": "tx1", "trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}, requestExemplar("tx1", req))
+
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.NotContains(t, requestExemplar("tx1", req), "trace_id")
+}
+
+func BenchmarkWAFHandler(b *testing.B) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(b.TempDir(), "audit.log"),
+	})
+	b.Setenv("DIRECTIVES", strings.Replace(mockDirectives, "SecDebugLogLevel 3", "SecDebugLogLevel 0", 1))
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.195")
+	req.Header.Set("X-Forwarded-Uri", "/orders/42?page=2")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		req.RemoteAddr = "10.0.0.2:41234"
+		wafHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestWebhookSignatures(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogPath := filepath.Join(tempDir, "audit.log")
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{AuditLogPath: auditLogPath})
+
+	t.Setenv("DIRECTIVES", "SecRuleEngine On")
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+		Webhooks: middleware.NewWebhookVerifier(middleware.WebhookOptions{
+			Endpoints:      []middleware.WebhookEndpoint{{Prefix: "/hooks", Scheme: middleware.WebhookGitHub, Secret: []byte("secret")}},
+			Tolerance:      time.Minute,
+			NonceCacheSize: 10,
+			MaxBodySize:    1024,
+		}),
+	})
+
+	t.Run("Should deny forged webhook calls and audit them", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/hooks", strings.NewReader(`{}`))
+		req.Header.Set("X-Hub-Signature-256", "sha256=00")
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		auditLog, err := os.ReadFile(auditLogPath)
+		assert.NoError(t, err)
+		assert.Contains(t, string(auditLog), `"id":1100000`)
+		assert.Contains(t, string(auditLog), "invalid_signature")
+	})
+
+	t.Run("Should allow signed webhook calls", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/hooks", strings.NewReader(`{}`))
+		req.Header.Set("X-Hub-Signature-256", "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13")
+		req.Header.Set("X-GitHub-Delivery", "d1")
+		w := httptest.NewRecorder()
+		wafHandler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestScoring(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(tempDir, "audit.log"),
+	})
+
+	var features ScoringFeatures
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&features))
+		switch features.Path {
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/evil":
+			w.Write([]byte(`{"score":0.95}`))
+		default:
+			w.Write([]byte(`{"score":0.1}`))
+		}
+	}))
+	defer service.Close()
+
+	t.Setenv("DIRECTIVES", mockDirectives)
+	newHandler := func(shadow bool) http.Handler {
+		return NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{
+			FailurePolicy: middleware.FailurePolicy{Default: middleware.FailClosed},
+			Scoring:       ScoringOptions{URL: service.URL, Timeout: time.Second, Weight: 1, AnomalyThreshold: 5, Threshold: 0.8, Shadow: shadow},
+		})
+	}
+	serve := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("User-Agent", "curl/8.5.0")
+		req.Header.Set("Accept", "*/*")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	enforcing := newHandler(false)
+
+	t.Run("Should send the features of the request", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(enforcing, "/search?q=a&q=b&page=2").Code)
+		assert.Equal(t, "/search", features.Path)
+		assert.Equal(t, 3, features.ParamCount)
+		assert.InDelta(t, entropy("/search"), features.PathEntropy, 0.001)
+		assert.Equal(t, []string{"missing_accept_language", "missing_accept_encoding", "scripted_user_agent"}, features.HeaderAnomalies)
+	})
+
+	t.Run("Should deny requests whose blended score reaches the threshold", func(t *testing.T) {
+		w := serve(enforcing, "/evil")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		var response httperror.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, httperror.CodeScoreDenied, response.Error.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(enforcing, "/broken").Code)
+	})
+
+	t.Run("Should only observe in shadow mode", func(t *testing.T) {
+		shadow := newHandler(true)
+		assert.Equal(t, http.StatusOK, serve(shadow, "/evil").Code)
+		assert.Equal(t, http.StatusOK, serve(shadow, "/broken").Code)
+	})
+}
+
+func TestScoringBlend(t *testing.T) {
+	options := ScoringOptions{Weight: 0.25, AnomalyThreshold: 5}
+	assert.InDelta(t, 0.25*0.8+0.75*0.6, options.blend(0.8, 3), 0.001)
+	assert.InDelta(t, 1.0, options.blend(2, 20), 0.001, "Expected both scores to be bounded to 1")
+	assert.InDelta(t, 0.0, entropy("aaaa"), 0.001)
+	assert.InDelta(t, 2.0, entropy("abcd"), 0.001)
+}
+
+func TestRuleCatalog(t *testing.T) {
+	directives := mockDirectives + `
+SecRule REQUEST_URI "@beginsWith /admin" "id:1001,phase:1,deny,status:403,msg:'Admin, from outside',tag:'custom',severity:'WARNING',chain"
+	SecRule REMOTE_ADDR "!@ipMatch 10.0.0.0/8" "t:none"
+SecRuleRemoveById 942100 920000-920099
+SecRuleRemoveByTag attack-fixation`
+	rules, err := listRules(coreruleset.FS, directives)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	byID := map[int]RuleInfo{}
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+	t.Run("Should list the included CRS rules", func(t *testing.T) {
+		rule, ok := byID[942140]
+		assert.True(t, ok)
+		assert.Equal(t, "@owasp_crs/REQUEST-942-APPLICATION-ATTACK-SQLI.conf", rule.File)
+		assert.Equal(t, "critical", rule.Severity)
+		assert.Equal(t, 2, rule.Phase)
+		assert.Contains(t, rule.Tags, "attack-sqli")
+		assert.NotEmpty(t, rule.Message)
+	})
+
+	t.Run("Should list inline rules without their chained rules", func(t *testing.T) {
+		assert.Equal(t, RuleInfo{ID: 1001, Line: 10, Message: "Admin, from outside", Tags: []string{"custom"}, Severity: "warning", Phase: 1}, byID[1001])
+		assert.Equal(t, "@coraza.conf-recommended", rules[0].File, "Expected the rules in the order they run")
+	})
+
+	t.Run("Should leave out the removed rules", func(t *testing.T) {
+		for _, rule := range rules {
+			assert.NotEqual(t, 942100, rule.ID)
+			assert.False(t, rule.ID >= 920000 && rule.ID <= 920099, rule.ID)
+			assert.NotContains(t, rule.Tags, "attack-fixation")
+		}
+		assert.Contains(t, byID, 920100)
+	})
+}
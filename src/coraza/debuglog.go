@@ -0,0 +1,197 @@
+package coraza
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/debuglog"
+)
+
+// LevelTrace is the slog level Coraza trace messages are logged at, below slog.LevelDebug
+const LevelTrace = slog.LevelDebug - 4
+
+// DebugLogOptions configures how the Coraza debug log is written to the application log
+type DebugLogOptions struct {
+	// Initial is the number of identical messages logged each second before sampling starts. Zero disables sampling.
+	Initial int
+	// Thereafter logs every Nth identical message once Initial is exceeded. Zero drops the rest.
+	Thereafter int
+}
+
+// slogDebugLogger implements Coraza's debug logger on top of slog, so SecDebugLogLevel output is structured and
+// leveled like the rest of the application log. SecDebugLog is ignored. Messages of transactions being debug captured
+// are added to their trace instead.
+type slogDebugLogger struct {
+	level   debuglog.Level
+	attrs   []slog.Attr
+	txID    string
+	sampler *logSampler
+	capture *DebugCapture
+}
+
+func newSlogDebugLogger(options DebugLogOptions, capture *DebugCapture) debuglog.Logger {
+	return slogDebugLogger{level: debuglog.LevelNoLog, sampler: newLogSampler(options), capture: capture}
+}
+
+func (l slogDebugLogger) WithOutput(io.Writer) debuglog.Logger {
+	return l
+}
+
+func (l slogDebugLogger) WithLevel(lvl debuglog.Level) debuglog.Logger {
+	l.level = lvl
+	return l
+}
+
+func (l slogDebugLogger) With(fields ...debuglog.ContextField) debuglog.Logger {
+	e := &slogDebugEvent{}
+	for _, field := range fields {
+		field(e)
+	}
+	for _, attr := range e.attrs {
+		if attr.Key == "tx_id" {
+			l.txID = attr.Value.String()
+		}
+	}
+	l.attrs = append(l.attrs[:len(l.attrs):len(l.attrs)], e.attrs...)
+	return l
+}
+
+func (l slogDebugLogger) Trace() debuglog.Event { return l.event(debuglog.LevelTrace) }
+func (l slogDebugLogger) Debug() debuglog.Event { return l.event(debuglog.LevelDebug) }
+func (l slogDebugLogger) Info() debuglog.Event  { return l.event(debuglog.LevelInfo) }
+func (l slogDebugLogger) Warn() debuglog.Event  { return l.event(debuglog.LevelWarn) }
+func (l slogDebugLogger) Error() debuglog.Event { return l.event(debuglog.LevelError) }
+
+func (l slogDebugLogger) event(lvl debuglog.Level) debuglog.Event {
+	if l.level < lvl {
+		return debuglog.Noop().Error()
+	}
+	return &slogDebugEvent{logger: l, level: lvl, attrs: append([]slog.Attr(nil), l.attrs...)}
+}
+
+func (l slogDebugLogger) write(lvl debuglog.Level, msg string, attrs []slog.Attr) {
+	if l.capture != nil && l.txID != "" && l.capture.capture(l.txID, debugLogLine(lvl, msg, attrs)) {
+		return
+	}
+	if !l.sampler.allow(lvl, msg) {
+		metricDebugLogSampled.Inc()
+		return
+	}
+	slog.Default().LogAttrs(context.Background(), slogLevel(lvl), msg, append(attrs, slog.String("source", "coraza"))...)
+}
+
+// slogLevel maps Coraza debug levels onto slog levels. ModSecurity's debug levels 4 to 8 are all debug.
+func slogLevel(lvl debuglog.Level) slog.Level {
+	switch {
+	case lvl <= debuglog.LevelError:
+		return slog.LevelError
+	case lvl == debuglog.LevelWarn:
+		return slog.LevelWarn
+	case lvl == debuglog.LevelInfo:
+		return slog.LevelInfo
+	case lvl < debuglog.LevelTrace:
+		return slog.LevelDebug
+	default:
+		return LevelTrace
+	}
+}
+
+func debugLogLine(lvl debuglog.Level, msg string, attrs []slog.Attr) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", lvl, msg)
+	for _, attr := range attrs {
+		b.WriteString(" " + attr.String())
+	}
+	return b.String()
+}
+
+type slogDebugEvent struct {
+	logger slogDebugLogger
+	level  debuglog.Level
+	attrs  []slog.Attr
+}
+
+func (e *slogDebugEvent) Msg(msg string) {
+	if msg != "" {
+		e.logger.write(e.level, msg, e.attrs)
+	}
+}
+
+func (e *slogDebugEvent) Str(key, val string) debuglog.Event {
+	e.attrs = append(e.attrs, slog.String(key, val))
+	return e
+}
+
+func (e *slogDebugEvent) Err(err error) debuglog.Event {
+	if err != nil {
+		e.attrs = append(e.attrs, slog.String("error", err.Error()))
+	}
+	return e
+}
+
+func (e *slogDebugEvent) Bool(key string, b bool) debuglog.Event {
+	e.attrs = append(e.attrs, slog.Bool(key, b))
+	return e
+}
+
+func (e *slogDebugEvent) Int(key string, i int) debuglog.Event {
+	e.attrs = append(e.attrs, slog.Int(key, i))
+	return e
+}
+
+func (e *slogDebugEvent) Uint(key string, i uint) debuglog.Event {
+	e.attrs = append(e.attrs, slog.Uint64(key, uint64(i)))
+	return e
+}
+
+func (e *slogDebugEvent) Stringer(key string, val fmt.Stringer) debuglog.Event {
+	if val == nil {
+		e.attrs = append(e.attrs, slog.Any(key, nil))
+		return e
+	}
+	e.attrs = append(e.attrs, slog.String(key, val.String()))
+	return e
+}
+
+func (e *slogDebugEvent) IsEnabled() bool {
+	return true
+}
+
+// logSampler rate-limits identical debug messages: each second the first Initial are logged, then every Thereafter-th
+type logSampler struct {
+	options DebugLogOptions
+	now     func() time.Time
+
+	mu     sync.Mutex
+	second int64
+	counts map[string]int
+}
+
+func newLogSampler(options DebugLogOptions) *logSampler {
+	return &logSampler{options: options, now: time.Now, counts: map[string]int{}}
+}
+
+func (s *logSampler) allow(lvl debuglog.Level, msg string) bool {
+	if s.options.Initial <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if second := s.now().Unix(); second != s.second {
+		s.second = second
+		clear(s.counts)
+	}
+	key := lvl.String() + msg
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.options.Initial {
+		return true
+	}
+	return s.options.Thereafter > 0 && (n-s.options.Initial)%s.options.Thereafter == 0
+}
@@ -0,0 +1,261 @@
+package coraza
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DecisionWebhookOptions configures the external authorizer consulted for requests the WAF allows
+type DecisionWebhookOptions struct {
+	// URL receives a POST with a DecisionRequest. An empty URL disables the webhook.
+	URL string
+	// Timeout for each call to the webhook
+	Timeout time.Duration
+	// Headers are copied from the original request into the DecisionRequest, e.g. Authorization
+	Headers []string
+	// Transport carries the calls. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// DecisionRequest is the WAF's verdict and the signals it extracted, sent to the decision webhook and OPA.
+// The verdict is "allow", or "detect" when attack rules matched without blocking the request.
+type DecisionRequest struct {
+	TransactionID string            `json:"transaction_id"`
+	Verdict       string            `json:"verdict"`
+	ClientIP      string            `json:"client_ip"`
+	Method        string            `json:"method"`
+	Host          string            `json:"host"`
+	URI           string            `json:"uri"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	AnomalyScore  int               `json:"anomaly_score"`
+	MatchedRules  []int             `json:"matched_rules"`
+	Tags          []string          `json:"tags"`
+}
+
+// DecisionResponse is the webhook's answer. A denied request is answered with Status, defaulting to 403.
+type DecisionResponse struct {
+	Allow  bool   `json:"allow"`
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// decideFunc asks an external authorizer about a request the WAF allowed
+type decideFunc func(ctx context.Context, decision *DecisionRequest) (DecisionResponse, error)
+
+// decisionStage is an external authorizer chained after Coraza
+type decisionStage struct {
+	decide decideFunc
+	// headers are copied from the original request into the DecisionRequest
+	headers []string
+	// class is the failure class used when decide fails
+	class middleware.FailureClass
+	// code answers requests the stage denies
+	code   string
+	metric *prometheus.CounterVec
+}
+
+// decisionMiddleware asks the stage about every request the WAF allowed, denying it when either says no. The WAF's
+// own response is held back until the stage has answered. Requests the WAF denied are answered without consulting it.
+func decisionMiddleware(next http.Handler, stage decisionStage, policy middleware.FailurePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var decision *DecisionRequest
+		observe, _ := r.Context().Value(transactionObserverKey{}).(TransactionObserver)
+		ctx := WithTransactionObserver(r.Context(), func(tx types.Transaction) {
+			decision = newDecisionRequest(tx, r, stage.headers)
+			if observe != nil {
+				observe(tx)
+			}
+		})
+
+		held := &allowHoldingWriter{ResponseWriter: w}
+		next.ServeHTTP(held, r.WithContext(ctx))
+		if !held.allowed {
+			return
+		}
+		if decision == nil {
+			// There are no signals to send without a transaction
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		response, err := stage.decide(r.Context(), decision)
+		if err != nil && r.Context().Err() != nil {
+			// Traefik gave up on the request, which is not a failure of the authorizer
+			metricCancelledRequests.WithLabelValues("decision").Inc()
+			return
+		}
+		if err != nil {
+			stage.metric.WithLabelValues("error").Inc()
+			policy.Fail(w, r, stage.class, err)
+			return
+		}
+		if response.Allow {
+			stage.metric.WithLabelValues("allow").Inc()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		stage.metric.WithLabelValues("deny").Inc()
+		httperror.Write(w, r, denyStatus(response.Status), httperror.Body{
+			Code:          stage.code,
+			Message:       response.Reason,
+			TransactionID: decision.TransactionID,
+		})
+	})
+}
+
+// decisionWebhookStage POSTs the DecisionRequest to the webhook, which answers with a DecisionResponse
+func decisionWebhookStage(options DecisionWebhookOptions) decisionStage {
+	client := outbound.Client(options.Transport, "decision_webhook", options.Timeout)
+	return decisionStage{
+		decide: func(ctx context.Context, decision *DecisionRequest) (DecisionResponse, error) {
+			var response DecisionResponse
+			if err := postJSON(ctx, client, options.URL, decision, &response); err != nil {
+				return DecisionResponse{}, fmt.Errorf("decision webhook failed: %w", err)
+			}
+			return response, nil
+		},
+		headers: options.Headers,
+		class:   middleware.FailureClassDecisionWebhook,
+		code:    httperror.CodeDecisionDenied,
+		metric:  metricDecisionWebhook,
+	}
+}
+
+// denyStatus returns the status an external authorizer asked for, defaulting to 403 unless it is an error status
+func denyStatus(status int) int {
+	if status < 400 || status > 599 {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+func newDecisionRequest(tx types.Transaction, r *http.Request, headers []string) *DecisionRequest {
+	decision := &DecisionRequest{
+		TransactionID: tx.ID(),
+		Verdict:       "allow",
+		ClientIP:      middleware.ClientIP(r),
+		Method:        r.Method,
+		Host:          r.Host,
+		URI:           r.URL.RequestURI(),
+		AnomalyScore:  anomalyScore(tx),
+		MatchedRules:  reportedRuleIDs(tx.MatchedRules()),
+		Tags:          matchedTags(tx.MatchedRules()),
+	}
+	if decision.AnomalyScore > 0 {
+		// Rules matched without blocking, e.g. below the anomaly threshold or in DetectionOnly mode
+		decision.Verdict = "detect"
+	}
+	for _, name := range headers {
+		if value := r.Header.Get(name); value != "" {
+			if decision.Headers == nil {
+				decision.Headers = map[string]string{}
+			}
+			decision.Headers[name] = value
+		}
+	}
+	return decision
+}
+
+// anomalyScore returns the CRS inbound anomaly score, which is still computed when the engine is in DetectionOnly mode
+func anomalyScore(tx types.Transaction) int {
+	state, ok := tx.(plugintypes.TransactionState)
+	if !ok {
+		return 0
+	}
+	values := state.Variables().TX().Get("blocking_inbound_anomaly_score")
+	if len(values) == 0 {
+		return 0
+	}
+	score, _ := strconv.Atoi(values[0])
+	return score
+}
+
+// matchedTags returns the distinct tags of the matched rules that log a message
+func matchedTags(matched []types.MatchedRule) []string {
+	var tags []string
+	for _, rule := range matched {
+		if rule.Message() == "" {
+			continue
+		}
+		for _, tag := range rule.Rule().Tags() {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// postJSON sends body to url and decodes the 200 response into response
+func postJSON(ctx context.Context, client *http.Client, url string, body any, response any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// allowHoldingWriter holds back a 200 so the decision webhook can still deny the request, and passes any other
+// response straight through
+type allowHoldingWriter struct {
+	http.ResponseWriter
+	allowed bool
+	written bool
+}
+
+func (w *allowHoldingWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+	w.written = true
+	if status == http.StatusOK {
+		w.allowed = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *allowHoldingWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.allowed {
+		// Allowed forward-auth responses have no body
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,220 @@
+package coraza
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// DenyResponse configures how denied requests are answered, so the same service can guard APIs
+// (e.g. 401 with WWW-Authenticate) and browser apps (e.g. 302 with a Location to a login page)
+type DenyResponse struct {
+	// Status overrides the status set by the denying rule. Zero keeps the rule's status, which defaults to 403.
+	Status int
+	// StatusMap maps matched rule tags or IDs to a status. The first mapping with a matching rule wins over Status.
+	StatusMap []StatusMapping
+	// Headers are added to every deny response
+	Headers http.Header
+	// Actions maps matched rule tags or IDs to a soft-block action. The first mapping with a matching rule wins.
+	Actions []ActionMapping
+	// TarpitDelay is how long the response to a tarpitted request is held back
+	TarpitDelay time.Duration
+	// DecoyStatus answers decoyed requests with an empty body
+	DecoyStatus int
+	// RedirectURL is the block page browsers are redirected to. Empty serves the embedded block page instead.
+	RedirectURL *url.URL
+	// AppealURL is linked from the embedded block page, with the transaction ID added to its query
+	AppealURL *url.URL
+	// AppealEmail is offered on the embedded block page, with the transaction ID in the subject
+	AppealEmail string
+}
+
+// DenyAction answers a denied request without telling the client it was blocked
+type DenyAction string
+
+const (
+	// DenyActionTarpit holds the deny response back for the tarpit delay to slow scanners down
+	DenyActionTarpit DenyAction = "tarpit"
+	// DenyActionDecoy answers with the decoy status and an empty body instead of the WAF error
+	DenyActionDecoy DenyAction = "decoy"
+	// DenyActionRedirect shows browsers a block page, while other clients get the WAF error
+	DenyActionRedirect DenyAction = "redirect"
+)
+
+// ActionMapping answers a denied request with Action when a rule with RuleID or Tag matched
+type ActionMapping struct {
+	RuleID int
+	Tag    string
+	Action DenyAction
+}
+
+// StatusMapping answers a denied request with Status when a rule with RuleID or Tag matched
+type StatusMapping struct {
+	RuleID int
+	Tag    string
+	Status int
+}
+
+// ParseStatusMap parses a comma-separated list of "tag:<tag>=<status>" and "id:<rule id>=<status>" mappings
+func ParseStatusMap(value string) ([]StatusMapping, error) {
+	var mappings []StatusMapping
+	err := parseRuleMap(value, "status", func(ruleID int, tag string, target string) error {
+		status, err := strconv.Atoi(target)
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, StatusMapping{RuleID: ruleID, Tag: tag, Status: status})
+		return nil
+	})
+	return mappings, err
+}
+
+// ParseActionMap parses a comma-separated list of "tag:<tag>=<action>" and "id:<rule id>=<action>" mappings,
+// where the action is tarpit, decoy or redirect
+func ParseActionMap(value string) ([]ActionMapping, error) {
+	var mappings []ActionMapping
+	err := parseRuleMap(value, "action", func(ruleID int, tag string, target string) error {
+		action := DenyAction(target)
+		if action != DenyActionTarpit && action != DenyActionDecoy && action != DenyActionRedirect {
+			return fmt.Errorf("expected tarpit, decoy or redirect")
+		}
+		mappings = append(mappings, ActionMapping{RuleID: ruleID, Tag: tag, Action: action})
+		return nil
+	})
+	return mappings, err
+}
+
+// parseRuleMap calls add for each "tag:<tag>=<value>" and "id:<rule id>=<value>" item of a comma-separated list.
+// A "*=<value>" item applies to every denied request.
+func parseRuleMap(value string, kind string, add func(ruleID int, tag string, target string) error) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		selector, target, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s mapping %q, expected tag:<tag>=<%s> or id:<rule id>=<%s>", kind, item, kind, kind)
+		}
+
+		var ruleID int
+		var tag string
+		selectorKind, selected, _ := strings.Cut(strings.TrimSpace(selector), ":")
+		switch selectorKind {
+		case anyRule:
+			tag = anyRule
+		case "tag":
+			tag = selected
+		case "id":
+			var err error
+			if ruleID, err = strconv.Atoi(selected); err != nil {
+				return fmt.Errorf("invalid rule ID in mapping %q: %w", item, err)
+			}
+		default:
+			return fmt.Errorf("invalid %s mapping %q, expected tag:<tag>=<%s> or id:<rule id>=<%s>", kind, item, kind, kind)
+		}
+		if err := add(ruleID, tag, strings.TrimSpace(target)); err != nil {
+			return fmt.Errorf("invalid %s in mapping %q: %w", kind, item, err)
+		}
+	}
+	return nil
+}
+
+// write answers the request for the interruption, returning the status written
+func (d DenyResponse) write(w http.ResponseWriter, r *http.Request, tx types.Transaction, it *types.Interruption) int {
+	status := interruptionStatus(it)
+	if it.Action != "deny" {
+		w.WriteHeader(status)
+		return status
+	}
+
+	action := d.mappedAction(tx.MatchedRules())
+	blockPage := action == DenyActionRedirect && httperror.IsBrowser(r)
+	switch action {
+	case DenyActionDecoy:
+		metricSoftBlocks.WithLabelValues(string(DenyActionDecoy)).Inc()
+		w.WriteHeader(d.DecoyStatus)
+		return d.DecoyStatus
+	case DenyActionTarpit:
+		metricSoftBlocks.WithLabelValues(string(DenyActionTarpit)).Inc()
+		tarpit(r, d.TarpitDelay)
+	case DenyActionRedirect:
+		if blockPage && d.RedirectURL != nil {
+			metricBlockPages.WithLabelValues("redirect").Inc()
+			redirectToBlockPage(w, *d.RedirectURL, tx.ID())
+			return http.StatusFound
+		}
+	}
+
+	if d.Status != 0 {
+		status = d.Status
+	}
+	if mapped, ok := d.mappedStatus(tx.MatchedRules()); ok {
+		status = mapped
+	}
+	for name, values := range d.Headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	if blockPage {
+		metricBlockPages.WithLabelValues("embedded").Inc()
+		writeBlockPage(w, status, d.blockPageData(tx.ID()))
+		return status
+	}
+	httperror.Write(w, r, status, httperror.Body{
+		Code:          httperror.CodeBlocked,
+		RuleIDs:       reportedRuleIDs(tx.MatchedRules()),
+		TransactionID: tx.ID(),
+	})
+	return status
+}
+
+// reportedRuleIDs returns the IDs of the matched rules that log a message, leaving out CRS bookkeeping rules
+func reportedRuleIDs(matched []types.MatchedRule) []int {
+	var ids []int
+	for _, rule := range matched {
+		if rule.Message() != "" {
+			ids = append(ids, rule.Rule().ID())
+		}
+	}
+	return ids
+}
+
+func (d DenyResponse) mappedStatus(matched []types.MatchedRule) (int, bool) {
+	for _, mapping := range d.StatusMap {
+		if ruleMatched(matched, mapping.RuleID, mapping.Tag) {
+			return mapping.Status, true
+		}
+	}
+	return 0, false
+}
+
+func (d DenyResponse) mappedAction(matched []types.MatchedRule) DenyAction {
+	for _, mapping := range d.Actions {
+		if ruleMatched(matched, mapping.RuleID, mapping.Tag) {
+			return mapping.Action
+		}
+	}
+	return ""
+}
+
+// anyRule selects every denied request in a mapping
+const anyRule = "*"
+
+// ruleMatched reports whether a rule with the ID or tag matched
+func ruleMatched(matched []types.MatchedRule, ruleID int, tag string) bool {
+	if tag == anyRule {
+		return len(matched) > 0
+	}
+	return slices.ContainsFunc(matched, func(rule types.MatchedRule) bool {
+		return (ruleID != 0 && rule.Rule().ID() == ruleID) || (tag != "" && slices.Contains(rule.Rule().Tags(), tag))
+	})
+}
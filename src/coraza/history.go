@@ -0,0 +1,161 @@
+package coraza
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const historyIndexFile = "history.json"
+
+var ErrUnknownDirectiveVersion = errors.New("unknown directive version")
+
+// DirectiveHistoryOptions configures where loaded directive sets are kept
+type DirectiveHistoryOptions struct {
+	// Dir keeps the directive sets on disk so they survive restarts. An empty dir keeps them in memory only.
+	Dir string
+	// Size is the number of directive sets kept
+	Size int
+}
+
+// DirectiveVersion is a directive set that has been loaded into the WAF
+type DirectiveVersion struct {
+	Hash     string    `json:"hash"`
+	LoadedAt time.Time `json:"loaded_at"`
+	Active   bool      `json:"active"`
+}
+
+// directiveHistory records every directive set loaded into the WAF, most recent first
+type directiveHistory struct {
+	options DirectiveHistoryOptions
+
+	mu       sync.Mutex
+	versions []DirectiveVersion
+	content  map[string]string
+}
+
+func newDirectiveHistory(options DirectiveHistoryOptions) (*directiveHistory, error) {
+	h := &directiveHistory{options: options, content: map[string]string{}}
+	if h.options.Size <= 0 {
+		h.options.Size = 1
+	}
+	if options.Dir == "" {
+		return h, nil
+	}
+
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directive history directory: %w", err)
+	}
+	index, err := os.ReadFile(filepath.Join(options.Dir, historyIndexFile))
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directive history: %w", err)
+	}
+	if err := json.Unmarshal(index, &h.versions); err != nil {
+		return nil, fmt.Errorf("failed to parse directive history: %w", err)
+	}
+	return h, nil
+}
+
+func directivesHash(directives string) string {
+	sum := sha256.Sum256([]byte(directives))
+	return hex.EncodeToString(sum[:])
+}
+
+// record marks the directives as the active version, returning its hash
+func (h *directiveHistory) record(directives string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hash := directivesHash(directives)
+	h.content[hash] = directives
+	if h.options.Dir != "" {
+		if err := os.WriteFile(filepath.Join(h.options.Dir, hash+".conf"), []byte(directives), 0o600); err != nil {
+			return "", fmt.Errorf("failed to write directive version: %w", err)
+		}
+	}
+
+	versions := []DirectiveVersion{{Hash: hash, LoadedAt: time.Now().UTC(), Active: true}}
+	for _, v := range h.versions {
+		if v.Hash == hash {
+			continue
+		}
+		v.Active = false
+		versions = append(versions, v)
+	}
+
+	for _, dropped := range versions[min(len(versions), h.options.Size):] {
+		delete(h.content, dropped.Hash)
+		if h.options.Dir != "" {
+			os.Remove(filepath.Join(h.options.Dir, dropped.Hash+".conf"))
+		}
+	}
+	h.versions = versions[:min(len(versions), h.options.Size)]
+
+	return hash, h.persist()
+}
+
+// get returns the directives of a recorded version
+func (h *directiveHistory) get(hash string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	known := false
+	for _, v := range h.versions {
+		if v.Hash == hash {
+			known = true
+		}
+	}
+	if !known {
+		return "", ErrUnknownDirectiveVersion
+	}
+	if directives, ok := h.content[hash]; ok {
+		return directives, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(h.options.Dir, hash+".conf"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read directive version: %w", err)
+	}
+	return string(content), nil
+}
+
+func (h *directiveHistory) list() []DirectiveVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]DirectiveVersion(nil), h.versions...)
+}
+
+// persist writes the history index atomically so a crash never leaves it truncated
+func (h *directiveHistory) persist() error {
+	if h.options.Dir == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(h.versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(h.options.Dir, ".history-*")
+	if err != nil {
+		return fmt.Errorf("failed to write directive history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write directive history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write directive history: %w", err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(h.options.Dir, historyIndexFile))
+}
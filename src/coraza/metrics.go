@@ -0,0 +1,91 @@
+package coraza
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricDirectiveReloads = metrics.NewCounterVec(
+	"waf_directive_reloads_total",
+	"The total number of runtime directive reloads, by result",
+	[]string{"result"},
+)
+
+var metricCancelledRequests = metrics.NewCounterVec(
+	"waf_cancelled_requests_total",
+	"The total number of forward-auth calls cancelled by Traefik before the WAF answered, by the stage they were in",
+	[]string{"stage"},
+)
+
+var metricRequestDuration = metrics.NewHistogramVec(
+	"waf_request_duration_seconds",
+	"The time taken to evaluate a request, by decision. Samples carry the transaction ID as an exemplar.",
+	prometheus.DefBuckets,
+	[]string{"decision"},
+)
+
+var metricDebugLogSampled = metrics.NewCounter(
+	"waf_debug_log_sampled_total",
+	"The total number of Coraza debug log messages dropped by sampling",
+)
+
+var metricDecisionWebhook = metrics.NewCounterVec(
+	"waf_decision_webhook_requests_total",
+	"The total number of calls to the decision webhook, by result",
+	[]string{"result"},
+)
+
+var metricOPADecisions = metrics.NewCounterVec(
+	"waf_opa_decisions_total",
+	"The total number of OPA policy queries, by result",
+	[]string{"result"},
+)
+
+var metricScriptDecisions = metrics.NewCounterVec(
+	"waf_script_decisions_total",
+	"The total number of on_decision script hook calls, by result",
+	[]string{"result"},
+)
+
+var metricScoringDecisions = metrics.NewCounterVec(
+	"waf_scoring_requests_total",
+	"The total number of calls to the scoring service, by result",
+	[]string{"result"},
+)
+
+var metricScoringShadow = metrics.NewCounterVec(
+	"waf_scoring_shadow_total",
+	"The total number of requests the scoring service would have denied, or failed to score, in shadow mode",
+	[]string{"result"},
+)
+
+var metricScores = metrics.NewHistogramVec(
+	"waf_scoring_blended_score",
+	"The blended score of the requests scored by the scoring service",
+	[]float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	nil,
+).WithLabelValues()
+
+var metricScriptDenials = metrics.NewCounterVec(
+	"waf_script_denials_total",
+	"The total number of requests denied by a script hook before WAF evaluation, by hook",
+	[]string{"hook"},
+)
+
+var metricRequestTags = metrics.NewCounterVec(
+	"waf_request_tags_total",
+	"The total number of requests tagged by rules, by tag and decision",
+	[]string{"tag", "decision"},
+)
+
+var metricSoftBlocks = metrics.NewCounterVec(
+	"waf_soft_blocks_total",
+	"The total number of denied requests answered with a soft-block action instead of the WAF error, by action",
+	[]string{"action"},
+)
+
+var metricBlockPages = metrics.NewCounterVec(
+	"waf_block_pages_total",
+	"The total number of denied browser requests shown a block page, by whether they were redirected or served the embedded page",
+	[]string{"type"},
+)
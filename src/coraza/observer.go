@@ -0,0 +1,68 @@
+package coraza
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// TransactionObserver is called with the completed transaction of a request, before the transaction is closed
+type TransactionObserver func(tx types.Transaction)
+
+type transactionObserverKey struct{}
+
+// WithTransactionObserver returns a context that makes the WAF handler report the request's transaction to observe.
+// It lets in-process callers such as test runners inspect which rules matched.
+func WithTransactionObserver(ctx context.Context, observe TransactionObserver) context.Context {
+	return context.WithValue(ctx, transactionObserverKey{}, observe)
+}
+
+// MatchedRuleIDs returns the IDs of the rules matched by the transaction
+func MatchedRuleIDs(tx types.Transaction) []int {
+	matched := tx.MatchedRules()
+	ids := make([]int, 0, len(matched))
+	for _, rule := range matched {
+		ids = append(ids, rule.Rule().ID())
+	}
+	return ids
+}
+
+// Decision is the rule engine's verdict on a request
+type Decision struct {
+	Time          time.Time `json:"time"`
+	TransactionID string    `json:"transaction_id"`
+	// Decision is allow, deny, error or cancelled
+	Decision string `json:"decision"`
+	// Status is the forward-auth response status, zero when the failure policy answered
+	Status   int    `json:"status,omitempty"`
+	ClientIP string `json:"client_ip"`
+	Method   string `json:"method"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	RuleIDs  []int  `json:"rule_ids,omitempty"`
+}
+
+// DecisionObserver is called with the decision on every request evaluated by the rule engine. It runs on the
+// request path, so it must not block.
+type DecisionObserver func(decision Decision)
+
+func newDecision(tx types.Transaction, r *http.Request, decision string, status int) Decision {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	return Decision{
+		Time:          time.Now(),
+		TransactionID: tx.ID(),
+		Decision:      decision,
+		Status:        status,
+		ClientIP:      clientIP,
+		Method:        r.Method,
+		Host:          r.Host,
+		Path:          r.URL.Path,
+		RuleIDs:       MatchedRuleIDs(tx),
+	}
+}
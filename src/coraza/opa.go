@@ -0,0 +1,69 @@
+package coraza
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// OPAOptions configures the Open Policy Agent sidecar that evaluates a Rego policy for requests the WAF allows
+type OPAOptions struct {
+	// URL is the data API URL of the policy decision, e.g. http://localhost:8181/v1/data/waf/decision.
+	// An empty URL disables policy evaluation.
+	URL string
+	// Timeout for each policy query
+	Timeout time.Duration
+	// Headers are copied from the original request into the policy input, e.g. Authorization
+	Headers []string
+	// Transport carries the queries. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// errUndefinedDecision is returned when the queried policy produced no result, usually because the URL is wrong
+var errUndefinedDecision = errors.New("policy decision is undefined")
+
+// opaStage queries OPA with the DecisionRequest as input. The decision is either a boolean or an object shaped
+// like a DecisionResponse.
+func opaStage(options OPAOptions) decisionStage {
+	client := outbound.Client(options.Transport, "opa", options.Timeout)
+	return decisionStage{
+		decide: func(ctx context.Context, decision *DecisionRequest) (DecisionResponse, error) {
+			var response struct {
+				Result json.RawMessage `json:"result"`
+			}
+			input := struct {
+				Input *DecisionRequest `json:"input"`
+			}{decision}
+			if err := postJSON(ctx, client, options.URL, input, &response); err != nil {
+				return DecisionResponse{}, fmt.Errorf("OPA query failed: %w", err)
+			}
+			return parseOPAResult(response.Result)
+		},
+		headers: options.Headers,
+		class:   middleware.FailureClassOPA,
+		code:    httperror.CodePolicyDenied,
+		metric:  metricOPADecisions,
+	}
+}
+
+func parseOPAResult(result json.RawMessage) (DecisionResponse, error) {
+	if len(result) == 0 {
+		return DecisionResponse{}, errUndefinedDecision
+	}
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		return DecisionResponse{Allow: allow}, nil
+	}
+	var response DecisionResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return DecisionResponse{}, fmt.Errorf("policy decision must be a boolean or an object with allow, status and reason: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,255 @@
+package coraza
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// maxRuleIncludes bounds the files included while listing rules, as Coraza does when compiling them
+const maxRuleIncludes = 100
+
+// RuleInfo describes a rule loaded into the WAF
+type RuleInfo struct {
+	ID int `json:"id"`
+	// File is the file the rule was included from, empty for rules written in the directives themselves
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Message  string   `json:"message,omitempty"`
+	Tags     []string `json:"tags"`
+	Severity string   `json:"severity,omitempty"`
+	Phase    int      `json:"phase"`
+}
+
+// Rules lists the rules of the active directives in the order they run
+func (h *WAFHandler) Rules() []RuleInfo {
+	return *h.rules.Load()
+}
+
+// ruleCatalog lists the rules of directives the way Coraza compiles them, following the includes and applying the
+// SecRuleRemoveBy* directives. Coraza does not expose the rules it compiled, only the metadata of those that match.
+type ruleCatalog struct {
+	root     fs.FS
+	rules    []RuleInfo
+	includes int
+	// chained is set when the last rule starts a chain, whose next rule carries no metadata of its own
+	chained bool
+}
+
+// listRules parses each of the directives in turn, resolving includes in root
+func listRules(root fs.FS, directives ...string) ([]RuleInfo, error) {
+	c := &ruleCatalog{root: root, rules: []RuleInfo{}}
+	for _, d := range directives {
+		if err := c.parse(d, ""); err != nil {
+			return nil, err
+		}
+	}
+	return c.rules, nil
+}
+
+func (c *ruleCatalog) parse(data string, file string) error {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	var buffer strings.Builder
+	lineNumber, start := 0, 0
+	inBackticks := false
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if buffer.Len() == 0 {
+			start = lineNumber
+		}
+		if !inBackticks && line[len(line)-1] == '`' {
+			inBackticks = true
+		} else if inBackticks && line[0] == '`' {
+			inBackticks = false
+		}
+		if inBackticks {
+			buffer.WriteString(line + "\n")
+			continue
+		}
+		if trimmed, ok := strings.CutSuffix(line, `\`); ok {
+			buffer.WriteString(trimmed)
+			continue
+		}
+		buffer.WriteString(line)
+		if err := c.evaluate(buffer.String(), file, start); err != nil {
+			return err
+		}
+		buffer.Reset()
+	}
+	return scanner.Err()
+}
+
+func (c *ruleCatalog) evaluate(line string, file string, lineNumber int) error {
+	directive, options, _ := strings.Cut(line, " ")
+	options = strings.TrimSpace(options)
+	switch strings.ToLower(directive) {
+	case "include":
+		return c.include(strings.Trim(options, `"`))
+	case "secrule":
+		args := splitDirectiveArgs(options)
+		actions := ""
+		if len(args) > 2 {
+			actions = args[2]
+		}
+		c.add(actions, file, lineNumber)
+	case "secaction":
+		c.add(strings.Trim(options, `"`), file, lineNumber)
+	case "secruleremovebyid":
+		for _, field := range strings.Fields(strings.Trim(options, `"`)) {
+			first, last, isRange := strings.Cut(field, "-")
+			from, err := strconv.Atoi(first)
+			if err != nil {
+				continue
+			}
+			to := from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					continue
+				}
+			}
+			c.remove(func(rule RuleInfo) bool { return rule.ID >= from && rule.ID <= to })
+		}
+	case "secruleremovebytag":
+		tag := strings.Trim(options, `"`)
+		c.remove(func(rule RuleInfo) bool { return slices.Contains(rule.Tags, tag) })
+	case "secruleremovebymsg":
+		msg := strings.Trim(options, `"`)
+		c.remove(func(rule RuleInfo) bool { return rule.Message == msg })
+	}
+	return nil
+}
+
+// include parses the files matching the pattern, as the Include directive does
+func (c *ruleCatalog) include(pattern string) error {
+	files := []string{pattern}
+	if strings.Contains(pattern, "*") {
+		var err error
+		if files, err = fs.Glob(c.root, pattern); err != nil {
+			return fmt.Errorf("failed to glob %s: %w", pattern, err)
+		}
+	}
+	for _, file := range files {
+		if c.includes >= maxRuleIncludes {
+			return fmt.Errorf("cannot include more than %d files", maxRuleIncludes)
+		}
+		c.includes++
+		data, err := fs.ReadFile(c.root, path.Clean(file))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if err := c.parse(string(data), file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add records the rule with the actions, unless it is chained to the previous rule or has no ID
+func (c *ruleCatalog) add(actions string, file string, lineNumber int) {
+	chained := c.chained
+	rule := RuleInfo{File: file, Line: lineNumber, Tags: []string{}, Phase: int(types.PhaseRequestBody)}
+	c.chained = false
+	for _, action := range parseActions(actions) {
+		key, value := action[0], action[1]
+		switch key {
+		case "id":
+			rule.ID, _ = strconv.Atoi(value)
+		case "msg":
+			rule.Message = value
+		case "tag":
+			rule.Tags = append(rule.Tags, value)
+		case "severity":
+			if severity, err := types.ParseRuleSeverity(value); err == nil {
+				rule.Severity = severity.String()
+			}
+		case "phase":
+			if phase, err := types.ParseRulePhase(value); err == nil {
+				rule.Phase = int(phase)
+			}
+		case "chain":
+			c.chained = true
+		}
+	}
+	if chained || rule.ID == 0 {
+		return
+	}
+	c.rules = append(c.rules, rule)
+}
+
+func (c *ruleCatalog) remove(match func(RuleInfo) bool) {
+	c.rules = slices.DeleteFunc(c.rules, match)
+}
+
+// splitDirectiveArgs splits the arguments of a directive on spaces, keeping double-quoted arguments whole and
+// unquoting them
+func splitDirectiveArgs(options string) []string {
+	var args []string
+	var arg strings.Builder
+	quoted, inArg := false, false
+	for i := 0; i < len(options); i++ {
+		ch := options[i]
+		switch {
+		case ch == '\\' && quoted && i+1 < len(options) && options[i+1] == '"':
+			arg.WriteByte('"')
+			i++
+		case ch == '"':
+			quoted = !quoted
+			inArg = true
+		case (ch == ' ' || ch == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(ch)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// parseActions splits a rule's actions into their lowercase names and unquoted values. Commas within single
+// quotes are part of the value.
+func parseActions(actions string) [][2]string {
+	var parsed [][2]string
+	quoted := false
+	begin := 0
+	for i := 0; i <= len(actions); i++ {
+		if i < len(actions) {
+			switch actions[i] {
+			case '\\':
+				i++
+				continue
+			case '\'':
+				quoted = !quoted
+				continue
+			case ',':
+				if quoted {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(actions[begin:min(i, len(actions))]), ":")
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			parsed = append(parsed, [2]string{key, strings.Trim(strings.TrimSpace(value), "'")})
+		}
+		begin = i + 1
+	}
+	return parsed
+}
@@ -0,0 +1,165 @@
+package coraza
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// ScoringOptions configures the external scoring service, such as a machine learning model, whose score is blended
+// with the CRS anomaly score of requests the WAF allows
+type ScoringOptions struct {
+	// URL receives a POST with the ScoringFeatures of each request. An empty URL disables scoring.
+	URL string
+	// Timeout for each call to the service
+	Timeout time.Duration
+	// Weight is the share of the service's score in the blended score, the rest being the CRS anomaly score
+	Weight float64
+	// AnomalyThreshold is the CRS anomaly score counted as certain, which the anomaly score is divided by
+	AnomalyThreshold int
+	// Threshold denies the requests whose blended score reaches it
+	Threshold float64
+	// Shadow scores the requests without denying any, and without failing them when the service does, to trial a
+	// model alongside CRS
+	Shadow bool
+	// Transport carries the calls. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// ScoringFeatures are the signals extracted from a request for the scoring service
+type ScoringFeatures struct {
+	TransactionID string `json:"transaction_id"`
+	Method        string `json:"method"`
+	Host          string `json:"host"`
+	Path          string `json:"path"`
+	PathLength    int    `json:"path_length"`
+	// PathEntropy is the Shannon entropy of the path's characters, in bits
+	PathEntropy float64 `json:"path_entropy"`
+	ParamCount  int     `json:"param_count"`
+	// HeaderAnomalies name the browser headers the request lacks and clues of a scripted client
+	HeaderAnomalies []string `json:"header_anomalies"`
+	AnomalyScore    int      `json:"anomaly_score"`
+	MatchedRules    []int    `json:"matched_rules"`
+	Tags            []string `json:"tags"`
+}
+
+// ScoringResponse is the service's answer, the likelihood from 0 to 1 that the request is an attack
+type ScoringResponse struct {
+	Score float64 `json:"score"`
+}
+
+// scoringHeaders are copied from the request to find its header anomalies
+var scoringHeaders = []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding"}
+
+// scriptedUserAgents are the user agent prefixes of HTTP libraries and command line clients
+var scriptedUserAgents = []string{"curl/", "wget/", "python-requests/", "python-urllib/", "go-http-client/", "java/", "libwww-perl/", "okhttp/"}
+
+// scoringStage sends the features of the request to the scoring service and denies it when the blend of the
+// service's score and the CRS anomaly score reaches the threshold
+func scoringStage(options ScoringOptions) decisionStage {
+	client := outbound.Client(options.Transport, "scoring", options.Timeout)
+	return decisionStage{
+		decide: func(ctx context.Context, decision *DecisionRequest) (DecisionResponse, error) {
+			var response ScoringResponse
+			if err := postJSON(ctx, client, options.URL, newScoringFeatures(decision), &response); err != nil {
+				if options.Shadow {
+					metricScoringShadow.WithLabelValues("error").Inc()
+					slog.Debug("Scoring service failed in shadow mode", "error", err, "id", decision.TransactionID)
+					return DecisionResponse{Allow: true}, nil
+				}
+				return DecisionResponse{}, fmt.Errorf("scoring service failed: %w", err)
+			}
+
+			score := options.blend(response.Score, decision.AnomalyScore)
+			metricScores.Observe(score)
+			if score < options.Threshold {
+				return DecisionResponse{Allow: true}, nil
+			}
+			if options.Shadow {
+				metricScoringShadow.WithLabelValues("deny").Inc()
+				slog.Info("Scoring would deny the request", "id", decision.TransactionID, "score", score, "service_score", response.Score, "anomaly_score", decision.AnomalyScore)
+				return DecisionResponse{Allow: true}, nil
+			}
+			return DecisionResponse{Reason: fmt.Sprintf("blended anomaly score %.2f reached %.2f", score, options.Threshold)}, nil
+		},
+		headers: scoringHeaders,
+		class:   middleware.FailureClassScoring,
+		code:    httperror.CodeScoreDenied,
+		metric:  metricScoringDecisions,
+	}
+}
+
+// blend weighs the service's score against the CRS anomaly score, both bounded to 0 to 1
+func (o ScoringOptions) blend(serviceScore float64, anomalyScore int) float64 {
+	crs := 0.0
+	if o.AnomalyThreshold > 0 {
+		crs = min(float64(anomalyScore)/float64(o.AnomalyThreshold), 1)
+	}
+	return o.Weight*min(max(serviceScore, 0), 1) + (1-o.Weight)*crs
+}
+
+func newScoringFeatures(decision *DecisionRequest) ScoringFeatures {
+	path, rawQuery, _ := strings.Cut(decision.URI, "?")
+	params, _ := url.ParseQuery(rawQuery)
+	features := ScoringFeatures{
+		TransactionID:   decision.TransactionID,
+		Method:          decision.Method,
+		Host:            decision.Host,
+		Path:            path,
+		PathLength:      len(path),
+		PathEntropy:     entropy(path),
+		HeaderAnomalies: headerAnomalies(decision.Headers),
+		AnomalyScore:    decision.AnomalyScore,
+		MatchedRules:    decision.MatchedRules,
+		Tags:            decision.Tags,
+	}
+	for _, values := range params {
+		features.ParamCount += len(values)
+	}
+	return features
+}
+
+// entropy returns the Shannon entropy of the characters of s in bits
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+	var bits float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+func headerAnomalies(headers map[string]string) []string {
+	anomalies := []string{}
+	for _, name := range scoringHeaders {
+		if headers[name] == "" {
+			anomalies = append(anomalies, "missing_"+strings.ReplaceAll(strings.ToLower(name), "-", "_"))
+		}
+	}
+	userAgent := strings.ToLower(headers["User-Agent"])
+	for _, prefix := range scriptedUserAgents {
+		if strings.HasPrefix(userAgent, prefix) {
+			anomalies = append(anomalies, "scripted_user_agent")
+			break
+		}
+	}
+	return anomalies
+}
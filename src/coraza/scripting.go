@@ -0,0 +1,41 @@
+package coraza
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+)
+
+// scriptRequestMiddleware runs the script's on_request hook before the request is evaluated, letting it rewrite
+// or deny the request
+func scriptRequestMiddleware(next http.Handler, engine *script.Engine, policy middleware.FailurePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := engine.OnRequest(r)
+		if err != nil {
+			policy.Fail(w, r, middleware.FailureClassScript, err)
+			return
+		}
+		if !result.Allow {
+			metricScriptDenials.WithLabelValues(script.HookRequest).Inc()
+			httperror.Write(w, r, denyStatus(result.Status), httperror.Body{Code: httperror.CodeScriptDenied, Message: result.Reason})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scriptStage passes requests the WAF allowed to the script's on_decision hook
+func scriptStage(engine *script.Engine) decisionStage {
+	return decisionStage{
+		decide: func(ctx context.Context, decision *DecisionRequest) (DecisionResponse, error) {
+			result, err := engine.OnDecision(ctx, decision)
+			return DecisionResponse{Allow: result.Allow, Status: result.Status, Reason: result.Reason}, err
+		},
+		class:  middleware.FailureClassScript,
+		code:   httperror.CodeScriptDenied,
+		metric: metricScriptDecisions,
+	}
+}
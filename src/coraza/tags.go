@@ -0,0 +1,47 @@
+package coraza
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// RequestTagOptions configures the tags rules attach to requests for downstream analytics
+type RequestTagOptions struct {
+	// Prefix marks the rule tags that tag the request, e.g. request-tag:bot:verified tags it bot:verified.
+	// An empty prefix disables tagging.
+	Prefix string
+	// Header carries the request's tags, comma separated, in the response to Traefik. Empty disables the header.
+	Header string
+}
+
+// requestTags returns the distinct request tags of every matched rule, including rules that don't log
+func requestTags(matched []types.MatchedRule, prefix string) []string {
+	if prefix == "" {
+		return nil
+	}
+	var tags []string
+	for _, rule := range matched {
+		for _, tag := range rule.Rule().Tags() {
+			if tag, ok := strings.CutPrefix(tag, prefix); ok && tag != "" && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// tagRequest sets the tags header on the response and adds the tags to the access log
+func tagRequest(w http.ResponseWriter, r *http.Request, tags []string, options RequestTagOptions) {
+	if len(tags) == 0 {
+		return
+	}
+	if options.Header != "" {
+		w.Header().Set(options.Header, strings.Join(tags, ","))
+	}
+	middleware.AddAccessLogAttrs(r, slog.Any("request_tags", tags))
+}
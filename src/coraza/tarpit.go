@@ -0,0 +1,71 @@
+package coraza
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type tarpitKey struct{}
+
+// tarpit asks tarpitMiddleware to hold back the response to r for the delay
+func tarpit(r *http.Request, delay time.Duration) {
+	if held, ok := r.Context().Value(tarpitKey{}).(*atomic.Int64); ok {
+		held.Store(int64(delay))
+	}
+}
+
+// tarpitMiddleware holds back the responses of tarpitted requests once the WAF has answered them, so slow clients
+// are kept waiting without holding up the requests queued behind them. The wait ends early when Traefik gives up.
+func tarpitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := &atomic.Int64{}
+		held := &tarpitWriter{ResponseWriter: w, delay: delay}
+		next.ServeHTTP(held, r.WithContext(context.WithValue(r.Context(), tarpitKey{}, delay)))
+		if !held.holding {
+			return
+		}
+
+		timer := time.NewTimer(time.Duration(delay.Load()))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			w.WriteHeader(held.status)
+			w.Write(held.body.Bytes())
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// tarpitWriter buffers the response once a tarpit delay has been set and passes it through otherwise
+type tarpitWriter struct {
+	http.ResponseWriter
+	delay   *atomic.Int64
+	holding bool
+	status  int
+	body    bytes.Buffer
+}
+
+func (w *tarpitWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if w.delay.Load() > 0 {
+		w.holding = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tarpitWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.holding {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
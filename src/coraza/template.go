@@ -0,0 +1,66 @@
+package coraza
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// directiveData is the data directives templates are rendered with
+type directiveData struct {
+	Env map[string]string
+}
+
+var directiveFuncs = template.FuncMap{
+	// split turns a comma-separated value into a list for range, dropping empty items
+	"split": func(value string) []string {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	},
+	"join": func(sep string, items []string) string {
+		return strings.Join(items, sep)
+	},
+	// env returns the value of an environment variable, empty when it is unset, for use with default
+	"env": func(name string) string {
+		return os.Getenv(name)
+	},
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// renderDirectives expands Go template expressions in the directives, such as {{ .Env.API_HOSTS }}
+// or {{ range split .Env.API_HOSTS }}...{{ end }}, so one directives file can serve multiple environments.
+// Referencing an unset environment variable through .Env is an error; the env function returns an empty value instead.
+func renderDirectives(directives string) (string, error) {
+	if !strings.Contains(directives, "{{") {
+		return directives, nil
+	}
+
+	tmpl, err := template.New("directives").Funcs(directiveFuncs).Option("missingkey=error").Parse(directives)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse directives template: %w", err)
+	}
+
+	data := directiveData{Env: map[string]string{}}
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			data.Env[name] = value
+		}
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render directives template: %w", err)
+	}
+	return rendered.String(), nil
+}
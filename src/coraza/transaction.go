@@ -0,0 +1,109 @@
+package coraza
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// processRequest feeds the request through the connection, URI, header and body phases.
+// It is adapted from coraza's http package so that body read errors can be surfaced to the failure policy.
+func processRequest(tx types.Transaction, r *http.Request) (*types.Interruption, error) {
+	var (
+		client string
+		port   int
+	)
+	// RemoteAddr may be missing the port or be an IPv6 address: [2001:db8::1]:8080
+	if idx := strings.LastIndexByte(r.RemoteAddr, ':'); idx != -1 {
+		client = r.RemoteAddr[:idx]
+		port, _ = strconv.Atoi(r.RemoteAddr[idx+1:])
+	}
+
+	tx.ProcessConnection(client, port, "", 0)
+	tx.ProcessURI(r.URL.String(), r.Method, r.Proto)
+	for k, values := range r.Header {
+		for _, v := range values {
+			tx.AddRequestHeader(k, v)
+		}
+	}
+
+	// Host is promoted out of the header map by net/http, so add it back manually
+	if r.Host != "" {
+		tx.AddRequestHeader("Host", r.Host)
+		tx.SetServerName(r.Host)
+	}
+
+	// Transfer-Encoding is also removed by net/http, but CRS rules rely on it (e.g. 920171)
+	if r.TransferEncoding != nil {
+		tx.AddRequestHeader("Transfer-Encoding", r.TransferEncoding[0])
+	}
+
+	if it := tx.ProcessRequestHeaders(); it != nil {
+		return it, nil
+	}
+	// Stop before reading the body if the forward-auth call was cancelled
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	if tx.IsRequestBodyAccessible() && r.Body != nil && r.Body != http.NoBody {
+		var body io.Reader = r.Body
+		if r.ContentLength > 0 {
+			// Coraza checks a reader's declared length against SecRequestBodyLimit before reading, so oversized
+			// bodies are rejected without being read and partially processed bodies stop reading at the limit
+			body = &declaredLengthReader{Reader: r.Body, length: r.ContentLength}
+		}
+		it, _, err := tx.ReadRequestBodyFrom(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if it != nil {
+			return it, nil
+		}
+		// Anything beyond the body limit is left unread: net/http discards a small remainder after the response, or
+		// closes the connection, rather than reading it while the audit log lock is held
+	}
+
+	return tx.ProcessRequestBody()
+}
+
+// declaredLengthReader exposes the request's Content-Length through the Len method Coraza looks for
+type declaredLengthReader struct {
+	io.Reader
+	length int64
+}
+
+func (r *declaredLengthReader) Len() int {
+	return int(r.length)
+}
+
+// interruptionStatus returns the status code for a disruptive interruption, defaulting to 403
+func interruptionStatus(it *types.Interruption) int {
+	if it.Action != "deny" {
+		return http.StatusOK
+	}
+	if it.Status == 0 {
+		return http.StatusForbidden
+	}
+	return it.Status
+}
+
+// ruleRemover is implemented by Coraza's transactions, as used by the ctl:ruleRemoveById action
+type ruleRemover interface {
+	RemoveRuleByID(id int)
+}
+
+// excludeRules stops the rules from running on the transaction
+func excludeRules(tx types.Transaction, ids []int) {
+	remover, ok := tx.(ruleRemover)
+	if !ok {
+		return
+	}
+	for _, id := range ids {
+		remover.RemoveRuleByID(id)
+	}
+}
@@ -0,0 +1,81 @@
+package coraza
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/corazawaf/coraza/v3"
+)
+
+// WarmupReport is the outcome of running the synthetic warm-up transactions through a newly compiled WAF
+type WarmupReport struct {
+	Transactions int           `json:"transactions"`
+	Duration     time.Duration `json:"duration"`
+	// Probes is the number of attack requests sent per round and Detected how many of them matched a rule
+	Probes   int `json:"probes"`
+	Detected int `json:"detected"`
+}
+
+// warmUp runs the self-test requests through the WAF so that regex, transformation and pool initialization is paid
+// for before real traffic arrives. The logging phase is skipped, so warm-up transactions never reach the audit log.
+func warmUp(waf coraza.WAF, rounds int) WarmupReport {
+	report := WarmupReport{}
+	if rounds <= 0 {
+		return report
+	}
+
+	start := time.Now()
+	for round := range rounds {
+		for _, tc := range selfTestCases {
+			matched := warmUpTransaction(waf, tc)
+			report.Transactions++
+			if round == 0 && tc.ExpectBlock {
+				report.Probes++
+				if matched {
+					report.Detected++
+				}
+			}
+		}
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// warmUpTransaction evaluates a single self-test request, reporting whether any rule matched it
+func warmUpTransaction(waf coraza.WAF, tc selfTestCase) bool {
+	req := httptest.NewRequest(tc.Method, tc.Target, strings.NewReader(tc.Body))
+	req.RemoteAddr = "127.0.0.1:0"
+	if tc.Body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", "coraza-traefik-middleware-warmup")
+	req.Header.Set("Accept", "*/*")
+
+	tx := waf.NewTransaction()
+	defer func() {
+		if err := tx.Close(); err != nil {
+			slog.Debug("Failed to close warm-up transaction", "error", err, "id", tx.ID())
+		}
+	}()
+	if tx.IsRuleEngineOff() {
+		return false
+	}
+
+	it, err := processRequest(tx, req)
+	if err != nil {
+		return false
+	}
+	if it == nil {
+		tx.ProcessResponseHeaders(http.StatusOK, req.Proto)
+	}
+	for _, rule := range tx.MatchedRules() {
+		// Rules without a message are CRS bookkeeping such as paranoia level skips
+		if rule.Message() != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package events
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricStoredEvents = metrics.NewCounter(
+	"waf_event_store_events_total",
+	"The total number of violation events written to the event store",
+)
+
+var metricExpiredEvents = metrics.NewCounter(
+	"waf_event_store_expired_total",
+	"The total number of events deleted from the event store once older than their retention",
+)
+
+var metricEvictedEvents = metrics.NewCounter(
+	"waf_event_store_evicted_total",
+	"The total number of events dropped from the event store to stay within EVENT_STORE_MAX_EVENTS",
+)
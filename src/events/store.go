@@ -0,0 +1,603 @@
+// Package events stores processed audit events on local disk and answers queries over them, so small deployments
+// can investigate incidents without an external log system.
+//
+// Events are kept in daily JSON lines files rather than a database, so the WAF stays a single static binary without
+// an embedded SQL engine. The queries it answers (by time, client IP, host and rule) are served from an in-memory
+// index, bounded by Options.MaxEvents.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+)
+
+const (
+	// segmentLayout names the file holding a day of events
+	segmentLayout = "2006-01-02"
+	// DefaultLimit is the page size of queries without a limit
+	DefaultLimit = 100
+	// DefaultMaxEvents bounds the events kept when Options.MaxEvents is zero
+	DefaultMaxEvents = 500000
+)
+
+// Retention classes besides the rule severities
+const (
+	ClassBlocked = "blocked"
+	ClassAllowed = "allowed"
+)
+
+type Options struct {
+	// Dir holds the event files. An empty directory disables the store.
+	Dir string
+	// Retention is how long events are kept when no class retention applies
+	Retention time.Duration
+	// ClassRetention keeps events longer or shorter by class: their severity (critical, warning, ...), blocked or
+	// allowed. An event in several classes is kept for the longest of their retentions.
+	ClassRetention map[string]time.Duration
+	// MaxEvents bounds the events kept, and so the memory of the index. Beyond it the oldest events are dropped.
+	// DefaultMaxEvents when zero.
+	MaxEvents int
+}
+
+// record indexes a stored event
+type record struct {
+	time     time.Time
+	id       string
+	clientIP string
+	host     string
+	ruleIDs  []int
+	severity string
+	blocked  bool
+	segment  time.Time
+	offset   int64
+	length   int64
+}
+
+// before orders records by time, then ID
+func (r *record) before(t time.Time, id string) bool {
+	return r.time.Before(t) || r.time.Equal(t) && r.id < id
+}
+
+// Store appends events to daily JSON lines files and indexes them in memory. It is an audit.Sink, and an
+// audit.Expirer deleting the events older than their retention.
+type Store struct {
+	options Options
+
+	// expireMu keeps a single expiration rewriting the files at a time
+	expireMu sync.Mutex
+
+	mu      sync.RWMutex
+	records []*record
+	file    *os.File
+	segment time.Time
+	size    int64
+	// dirty are the segments whose file holds events no longer indexed, which the next expiration removes
+	dirty map[time.Time]bool
+}
+
+// Open opens the store in the options' directory, indexing the newest events kept from previous runs up to
+// MaxEvents. Older files are left to the next expiration to delete, without being read.
+func Open(options Options) (*Store, error) {
+	if options.MaxEvents <= 0 {
+		options.MaxEvents = DefaultMaxEvents
+	}
+	if err := os.MkdirAll(options.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create event store directory: %w", err)
+	}
+	s := &Store{options: options, dirty: map[time.Time]bool{}}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	// Index the newest days first, stopping once the index is full or the days are past any retention
+	oldest := time.Now().Add(-s.maxRetention() - 24*time.Hour)
+	var indexed [][]*record
+	count := 0
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		if count >= options.MaxEvents || segment.Before(oldest) {
+			s.dirty[segment] = true
+			continue
+		}
+		records, err := s.index(segment)
+		if err != nil {
+			return nil, err
+		}
+		indexed = append(indexed, records)
+		count += len(records)
+	}
+	for i := len(indexed) - 1; i >= 0; i-- {
+		s.records = append(s.records, indexed[i]...)
+	}
+	s.sort()
+	s.evict()
+	return s, nil
+}
+
+func (s *Store) path(segment time.Time) string {
+	return filepath.Join(s.options.Dir, segment.Format(segmentLayout)+".jsonl")
+}
+
+// segments lists the days with an event file, oldest first
+func (s *Store) segments() ([]time.Time, error) {
+	files, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list event store directory: %w", err)
+	}
+	var segments []time.Time
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".jsonl")
+		if !ok || file.IsDir() {
+			continue
+		}
+		segment, err := time.Parse(segmentLayout, name)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	slices.SortFunc(segments, func(a, b time.Time) int { return a.Compare(b) })
+	return segments, nil
+}
+
+// index reads the records of the events in a segment file
+func (s *Store) index(segment time.Time) ([]*record, error) {
+	file, err := os.Open(s.path(segment))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+	defer file.Close()
+
+	var records []*record
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var event audit.Event
+			if jsonErr := json.Unmarshal(line, &event); jsonErr == nil {
+				records = append(records, newRecord(event, segment, offset, int64(len(line))))
+			}
+			offset += int64(len(line))
+		}
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read event file: %w", err)
+		}
+	}
+}
+
+func newRecord(event audit.Event, segment time.Time, offset int64, length int64) *record {
+	r := &record{
+		time:     event.Time,
+		id:       event.ID,
+		clientIP: event.ClientIP,
+		host:     event.Host,
+		severity: event.Severity,
+		blocked:  event.Status >= 400 && !event.ClientCancelled,
+		segment:  segment,
+		offset:   offset,
+		length:   length,
+	}
+	for _, rule := range event.Rules {
+		r.ruleIDs = append(r.ruleIDs, rule.ID)
+	}
+	return r
+}
+
+func (s *Store) sort() {
+	sort.SliceStable(s.records, func(i, j int) bool { return s.records[i].before(s.records[j].time, s.records[j].id) })
+}
+
+// evict drops the oldest records beyond MaxEvents from the index, leaving their files to the next expiration
+func (s *Store) evict() {
+	excess := len(s.records) - s.options.MaxEvents
+	if excess <= 0 {
+		return
+	}
+	for _, r := range s.records[:excess] {
+		s.dirty[r.segment] = true
+	}
+	s.records = slices.Delete(s.records, 0, excess)
+	metricEvictedEvents.Add(float64(excess))
+}
+
+func (s *Store) Name() string {
+	return "event_store"
+}
+
+// Send appends the event of the log to the file of its day
+func (s *Store) Send(log audit.Log) error {
+	event := audit.NewEvent(log)
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rotate(event.Time.UTC().Truncate(24 * time.Hour)); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write event file: %w", err)
+	}
+	r := newRecord(event, s.segment, s.size, int64(len(line)))
+	s.size += int64(len(line))
+	// Events mostly arrive in order, so the search usually ends at the last position
+	i := sort.Search(len(s.records), func(i int) bool { return !s.records[i].before(r.time, r.id) })
+	s.records = slices.Insert(s.records, i, r)
+	s.evict()
+	metricStoredEvents.Inc()
+	return nil
+}
+
+// rotate switches to the file of the segment
+func (s *Store) rotate(segment time.Time) error {
+	if s.file != nil && segment.Equal(s.segment) {
+		return nil
+	}
+	if err := s.closeFile(); err != nil {
+		slog.Warn("Failed to close event file", "error", err)
+	}
+	file, err := os.OpenFile(s.path(segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open event file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open event file: %w", err)
+	}
+	s.file, s.segment, s.size = file, segment, info.Size()
+	return nil
+}
+
+// Flush does nothing, events are written as they are sent
+func (s *Store) Flush(force bool) error {
+	return nil
+}
+
+// retention returns how long the event of a record is kept
+func (s *Store) retention(r *record) time.Duration {
+	class := ClassAllowed
+	if r.blocked {
+		class = ClassBlocked
+	}
+	retention, matched := s.options.Retention, false
+	for _, class := range []string{r.severity, class} {
+		if d, ok := s.options.ClassRetention[class]; ok && (!matched || d > retention) {
+			retention, matched = d, true
+		}
+	}
+	return retention
+}
+
+// maxRetention is the longest any event is kept
+func (s *Store) maxRetention() time.Duration {
+	longest := s.options.Retention
+	for _, d := range s.options.ClassRetention {
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// rewriteTask is a segment file to rewrite with the events of its kept records
+type rewriteTask struct {
+	segment time.Time
+	kept    []*record
+	// size is the size of the file when the task was planned. Events appended beyond it are kept as they are.
+	size int64
+}
+
+// Expire deletes the events older than their retention, and those dropped beyond MaxEvents. Days left without
+// events are deleted, other days with deleted events are rewritten without them. Files are rewritten without
+// holding the lock, so events keep being stored and queried meanwhile.
+func (s *Store) Expire(now time.Time) error {
+	s.expireMu.Lock()
+	defer s.expireMu.Unlock()
+
+	tasks, expired, err := s.planExpiry(now)
+	if err != nil {
+		return err
+	}
+	metricExpiredEvents.Add(float64(expired))
+	for _, task := range tasks {
+		if err := s.rewrite(task); err != nil {
+			s.mu.Lock()
+			s.dirty[task.segment] = true
+			s.mu.Unlock()
+			return fmt.Errorf("failed to expire events of %s: %w", task.segment.Format(segmentLayout), err)
+		}
+	}
+	return nil
+}
+
+// planExpiry drops the expired records from the index and lists the files to rewrite
+func (s *Store) planExpiry(now time.Time) ([]rewriteTask, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+	kept := map[time.Time][]*record{}
+	expired := 0
+	s.records = slices.DeleteFunc(s.records, func(r *record) bool {
+		if r.time.Add(s.retention(r)).After(now) {
+			kept[r.segment] = append(kept[r.segment], r)
+			return false
+		}
+		s.dirty[r.segment] = true
+		expired++
+		return true
+	})
+
+	var tasks []rewriteTask
+	for _, segment := range segments {
+		// Files without any indexed event are dropped once no event could still be kept
+		if !s.dirty[segment] && (len(kept[segment]) > 0 || segment.Add(24*time.Hour+s.maxRetention()).After(now)) {
+			continue
+		}
+		size, err := s.fileSize(segment)
+		if err != nil {
+			return nil, 0, err
+		}
+		delete(s.dirty, segment)
+		tasks = append(tasks, rewriteTask{segment: segment, kept: kept[segment], size: size})
+	}
+	return tasks, expired, nil
+}
+
+// fileSize returns the size of the file of a segment
+func (s *Store) fileSize(segment time.Time) (int64, error) {
+	if s.file != nil && segment.Equal(s.segment) {
+		return s.size, nil
+	}
+	info, err := os.Stat(s.path(segment))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read event file: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// rewrite copies the kept events of a segment to a new file, then swaps it in under the lock along with the events
+// appended meanwhile, updating the offsets of their records
+func (s *Store) rewrite(task rewriteTask) error {
+	slices.SortFunc(task.kept, func(a, b *record) int { return int(a.offset - b.offset) })
+	source, err := os.Open(s.path(task.segment))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.CreateTemp(s.options.Dir, ".rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(target.Name())
+	defer target.Close()
+
+	var size int64
+	offsets := make([]int64, len(task.kept))
+	for i, r := range task.kept {
+		line := make([]byte, r.length)
+		if _, err := source.ReadAt(line, r.offset); err != nil {
+			return err
+		}
+		if _, err := target.Write(line); err != nil {
+			return err
+		}
+		offsets[i] = size
+		size += r.length
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.fileSize(task.segment)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, io.NewSectionReader(source, task.size, current-task.size)); err != nil {
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	if s.file != nil && task.segment.Equal(s.segment) {
+		// The file is reopened by the next event appended to it
+		if err := s.closeFile(); err != nil {
+			slog.Warn("Failed to close event file", "error", err)
+		}
+	}
+	if size == 0 && current == task.size {
+		return os.Remove(s.path(task.segment))
+	}
+	if err := os.Rename(target.Name(), s.path(task.segment)); err != nil {
+		return err
+	}
+	for i, r := range task.kept {
+		r.offset = offsets[i]
+	}
+	for _, r := range s.records {
+		if r.segment.Equal(task.segment) && r.offset >= task.size {
+			r.offset += size - task.size
+		}
+	}
+	return nil
+}
+
+func (s *Store) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Close closes the file being appended to
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFile()
+}
+
+// Query selects events. Zero fields match every event.
+type Query struct {
+	// From and To bound the event time, To being exclusive
+	From     time.Time
+	To       time.Time
+	ClientIP string
+	Host     string
+	RuleID   int
+	// Limit is the page size, DefaultLimit when zero
+	Limit int
+	// Cursor continues from the Next cursor of a previous page
+	Cursor string
+}
+
+// Page is a page of events, newest first
+type Page struct {
+	Events []audit.Event `json:"events"`
+	// Next is the cursor of the following page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// ErrInvalidCursor is returned for a cursor that was not returned by a previous query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(r *record) string {
+	return strconv.FormatInt(r.time.UnixNano(), 10) + "_" + r.id
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	nanos, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parsed, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, parsed), id, nil
+}
+
+func (q Query) matches(r *record) bool {
+	return (q.ClientIP == "" || r.clientIP == q.ClientIP) &&
+		(q.Host == "" || strings.EqualFold(r.host, q.Host)) &&
+		(q.RuleID == 0 || slices.Contains(r.ruleIDs, q.RuleID))
+}
+
+// Get returns the event of a transaction
+func (s *Store) Get(transactionID string) (audit.Event, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.records) - 1; i >= 0; i-- {
+		if r := s.records[i]; r.id == transactionID {
+			event, err := s.read(r)
+			return event, err == nil, err
+		}
+	}
+	return audit.Event{}, false, nil
+}
+
+// read loads the event of a record from its segment file
+func (s *Store) read(r *record) (audit.Event, error) {
+	file, err := os.Open(s.path(r.segment))
+	if err != nil {
+		return audit.Event{}, fmt.Errorf("failed to open event file: %w", err)
+	}
+	defer file.Close()
+	line := make([]byte, r.length)
+	if _, err := file.ReadAt(line, r.offset); err != nil {
+		return audit.Event{}, fmt.Errorf("failed to read event file: %w", err)
+	}
+	var event audit.Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return audit.Event{}, fmt.Errorf("failed to parse event: %w", err)
+	}
+	return event, nil
+}
+
+// Query returns a page of the events matching the query, newest first
+func (s *Store) Query(q Query) (Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Walk back from the newest event before the cursor, or before To
+	end := len(s.records)
+	if q.Cursor != "" {
+		t, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		end = sort.Search(len(s.records), func(i int) bool { return !s.records[i].before(t, id) })
+	}
+	if !q.To.IsZero() {
+		end = min(end, sort.Search(len(s.records), func(i int) bool { return !s.records[i].time.Before(q.To) }))
+	}
+
+	page := Page{Events: []audit.Event{}}
+	var matched []*record
+	for i := end - 1; i >= 0; i-- {
+		r := s.records[i]
+		if !q.From.IsZero() && r.time.Before(q.From) {
+			break
+		}
+		if !q.matches(r) {
+			continue
+		}
+		if len(matched) == q.Limit {
+			page.Next = encodeCursor(matched[len(matched)-1])
+			break
+		}
+		matched = append(matched, r)
+	}
+
+	files := map[time.Time]*os.File{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, r := range matched {
+		file, ok := files[r.segment]
+		if !ok {
+			var err error
+			if file, err = os.Open(s.path(r.segment)); err != nil {
+				return Page{}, fmt.Errorf("failed to open event file: %w", err)
+			}
+			files[r.segment] = file
+		}
+		line := make([]byte, r.length)
+		if _, err := file.ReadAt(line, r.offset); err != nil {
+			return Page{}, fmt.Errorf("failed to read event file: %w", err)
+		}
+		var event audit.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return Page{}, fmt.Errorf("failed to parse event: %w", err)
+		}
+		page.Events = append(page.Events, event)
+	}
+	return page, nil
+}
@@ -0,0 +1,210 @@
+package events
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func violation(id string, clientIP string, host string, ruleID int, at time.Time) audit.Log {
+	return audit.Log{
+		Transaction: audit.Transaction{
+			ID:            id,
+			UnixTimestamp: at.UnixNano(),
+			ClientIP:      clientIP,
+			Request:       &audit.TransactionRequest{Method: "GET", URI: "/", Headers: map[string][]string{"Host": {host}}},
+			Response:      &audit.TransactionResponse{Status: 403},
+		},
+		Messages: []audit.Message{{Data: audit.MessageData{ID: ruleID}}},
+	}
+}
+
+func eventIDs(page Page) []string {
+	var ids []string
+	for _, event := range page.Events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(Options{Dir: dir, Retention: 48 * time.Hour})
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	require.NoError(t, store.Send(violation("a", "192.0.2.1", "shop.example.com", 942100, now.Add(-3*time.Hour))))
+	require.NoError(t, store.Send(violation("c", "192.0.2.2", "api.example.com", 941100, now.Add(-time.Hour))))
+	// Out of order, as audit logs of concurrent requests can be
+	require.NoError(t, store.Send(violation("b", "192.0.2.1", "api.example.com", 942100, now.Add(-2*time.Hour))))
+	require.NoError(t, store.Send(violation("old", "192.0.2.1", "api.example.com", 942100, now.AddDate(0, 0, -5))))
+
+	t.Run("Should return the events matching the query, newest first", func(t *testing.T) {
+		page, err := store.Query(Query{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b", "a", "old"}, eventIDs(page))
+		assert.Empty(t, page.Next)
+
+		page, err = store.Query(Query{ClientIP: "192.0.2.1", RuleID: 942100, Host: "API.example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "old"}, eventIDs(page))
+
+		page, err = store.Query(Query{From: now.Add(-150 * time.Minute), To: now.Add(-time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, eventIDs(page))
+	})
+
+	t.Run("Should get the event of a transaction", func(t *testing.T) {
+		event, ok, err := store.Get("b")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "api.example.com", event.Host)
+
+		_, ok, err = store.Get("missing")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Should page through the events with the cursor", func(t *testing.T) {
+		page, err := store.Query(Query{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b"}, eventIDs(page))
+		require.NotEmpty(t, page.Next)
+
+		page, err = store.Query(Query{Limit: 2, Cursor: page.Next})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "old"}, eventIDs(page))
+		assert.Empty(t, page.Next)
+
+		_, err = store.Query(Query{Cursor: "bogus"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("Should delete the days older than the retention", func(t *testing.T) {
+		require.NoError(t, store.Expire(now))
+		page, err := store.Query(Query{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b", "a"}, eventIDs(page))
+		_, err = os.Stat(store.path(now.AddDate(0, 0, -5).Truncate(24 * time.Hour)))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Should index the events of previous runs", func(t *testing.T) {
+		require.NoError(t, store.Close())
+		reopened, err := Open(Options{Dir: dir, Retention: 48 * time.Hour})
+		require.NoError(t, err)
+		defer reopened.Close()
+		page, err := reopened.Query(Query{RuleID: 941100})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 1)
+		assert.Equal(t, "api.example.com", page.Events[0].Host)
+		assert.Equal(t, 403, page.Events[0].Status)
+	})
+}
+
+func TestStoreClassRetention(t *testing.T) {
+	store, err := Open(Options{
+		Dir:            t.TempDir(),
+		Retention:      30 * 24 * time.Hour,
+		ClassRetention: map[string]time.Duration{ClassBlocked: 90 * 24 * time.Hour, "warning": 7 * 24 * time.Hour},
+	})
+	require.NoError(t, err)
+	defer store.Close()
+	now := time.Now().UTC()
+	day := now.AddDate(0, 0, -10).Truncate(24 * time.Hour).Add(time.Hour)
+
+	blockedWarning := violation("blocked-warning", "192.0.2.1", "example.com", 920350, day)
+	blockedWarning.Messages[0].Data.Severity = types.RuleSeverityWarning
+	allowedWarning := violation("allowed-warning", "192.0.2.1", "example.com", 920350, day.Add(time.Minute))
+	allowedWarning.Messages[0].Data.Severity = types.RuleSeverityWarning
+	allowedWarning.Transaction.Response.Status = 200
+	allowedCritical := violation("allowed-critical", "192.0.2.1", "example.com", 942100, day.Add(2*time.Minute))
+	allowedCritical.Messages[0].Data.Severity = types.RuleSeverityCritical
+	allowedCritical.Transaction.Response.Status = 200
+	for _, log := range []audit.Log{blockedWarning, allowedWarning, allowedCritical} {
+		require.NoError(t, store.Send(log))
+	}
+
+	require.NoError(t, store.Expire(now))
+	page, err := store.Query(Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"allowed-critical", "blocked-warning"}, eventIDs(page), "Expected only the allowed warning to expire")
+
+	require.NoError(t, store.Expire(now.AddDate(0, 0, 30)))
+	page, err = store.Query(Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blocked-warning"}, eventIDs(page), "Expected blocked events to be kept the longest")
+
+	// Events sent after a rewrite are appended to the rewritten file
+	require.NoError(t, store.Send(violation("later", "192.0.2.1", "example.com", 942100, day.Add(time.Hour))))
+	page, err = store.Query(Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"later", "blocked-warning"}, eventIDs(page))
+	assert.Equal(t, 920350, page.Events[1].Rules[0].ID)
+}
+
+func TestStoreMaxEvents(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(Options{Dir: dir, Retention: 48 * time.Hour, MaxEvents: 2})
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	yesterday := now.Add(-24 * time.Hour)
+	require.NoError(t, store.Send(violation("a", "192.0.2.1", "example.com", 942100, yesterday)))
+	require.NoError(t, store.Send(violation("b", "192.0.2.1", "example.com", 942100, now.Add(-2*time.Minute))))
+	require.NoError(t, store.Send(violation("c", "192.0.2.1", "example.com", 942100, now.Add(-time.Minute))))
+
+	t.Run("Should drop the oldest events beyond the maximum", func(t *testing.T) {
+		page, err := store.Query(Query{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b"}, eventIDs(page))
+	})
+
+	t.Run("Should delete the dropped events on expiry", func(t *testing.T) {
+		require.NoError(t, store.Expire(now))
+		_, err := os.Stat(store.path(yesterday.Truncate(24 * time.Hour)))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Should only index the newest events of previous runs", func(t *testing.T) {
+		require.NoError(t, store.Send(violation("d", "192.0.2.1", "example.com", 942100, now)))
+		require.NoError(t, store.Close())
+		reopened, err := Open(Options{Dir: dir, Retention: 48 * time.Hour, MaxEvents: 2})
+		require.NoError(t, err)
+		defer reopened.Close()
+		page, err := reopened.Query(Query{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d", "c"}, eventIDs(page))
+	})
+}
+
+func TestStoreRewriteKeepsAppendedEvents(t *testing.T) {
+	store, err := Open(Options{Dir: t.TempDir(), Retention: time.Hour})
+	require.NoError(t, err)
+	defer store.Close()
+	now := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	require.NoError(t, store.Send(violation("expired", "192.0.2.1", "example.com", 942100, now.Add(-2*time.Hour))))
+	require.NoError(t, store.Send(violation("kept", "192.0.2.1", "example.com", 942100, now.Add(-time.Minute))))
+
+	tasks, expired, err := store.planExpiry(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	require.Len(t, tasks, 1)
+	// An event appended while the file is being rewritten
+	require.NoError(t, store.Send(violation("appended", "192.0.2.1", "example.com", 941100, now)))
+	require.NoError(t, store.rewrite(tasks[0]))
+
+	page, err := store.Query(Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"appended", "kept"}, eventIDs(page))
+	assert.Equal(t, 941100, page.Events[0].Rules[0].ID)
+
+	require.NoError(t, store.Send(violation("later", "192.0.2.1", "example.com", 942100, now.Add(time.Minute))))
+	event, ok, err := store.Get("appended")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "appended", event.ID)
+}
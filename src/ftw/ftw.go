@@ -0,0 +1,253 @@
+package ftw
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/corazawaf/coraza/v3/types"
+	"gopkg.in/yaml.v3"
+)
+
+// testFile is the subset of the go-ftw test format used by the OWASP CRS regression tests
+type testFile struct {
+	RuleID int        `yaml:"rule_id"`
+	Tests  []testCase `yaml:"tests"`
+}
+
+type testCase struct {
+	TestID int     `yaml:"test_id"`
+	Title  string  `yaml:"test_title"`
+	Desc   string  `yaml:"desc"`
+	Stages []stage `yaml:"stages"`
+}
+
+type stage struct {
+	Input  input  `yaml:"input"`
+	Output output `yaml:"output"`
+	// Stage wraps input and output in the legacy format
+	Stage *stage `yaml:"stage"`
+}
+
+type input struct {
+	Method         string            `yaml:"method"`
+	URI            string            `yaml:"uri"`
+	Version        string            `yaml:"version"`
+	Headers        map[string]string `yaml:"headers"`
+	Data           string            `yaml:"data"`
+	EncodedRequest string            `yaml:"encoded_request"`
+}
+
+type output struct {
+	Status        statusList `yaml:"status"`
+	Log           logOutput  `yaml:"log"`
+	LogContains   string     `yaml:"log_contains"`
+	NoLogContains string     `yaml:"no_log_contains"`
+}
+
+type logOutput struct {
+	ExpectIDs   []int `yaml:"expect_ids"`
+	NoExpectIDs []int `yaml:"no_expect_ids"`
+}
+
+// statusList accepts both a single status and a list of statuses
+type statusList []int
+
+func (s *statusList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var list []int
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		*s = list
+		return nil
+	}
+	var single int
+	if err := node.Decode(&single); err != nil {
+		return err
+	}
+	*s = statusList{single}
+	return nil
+}
+
+// Report is the JSON result of an FTW run
+type Report struct {
+	Passed  bool         `json:"passed"`
+	Total   int          `json:"total"`
+	Failed  int          `json:"failed"`
+	Results []TestResult `json:"results"`
+}
+
+type TestResult struct {
+	RuleID int    `json:"rule_id"`
+	TestID int    `json:"test_id"`
+	Title  string `json:"title,omitempty"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+var legacyLogIDPattern = regexp.MustCompile(`id "(\d+)"`)
+
+// Run executes every go-ftw test file in tests whose rule ID starts with ruleFilter against the in-process WAF handler.
+// The test requests are synthetic, so they are not audit logged or counted.
+func Run(handler http.Handler, tests fs.FS, ruleFilter string) (Report, error) {
+	report := Report{Passed: true}
+
+	err := fs.WalkDir(tests, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !(strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+			return nil
+		}
+
+		content, err := fs.ReadFile(tests, path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var file testFile
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if ruleFilter != "" && !strings.HasPrefix(strconv.Itoa(file.RuleID), ruleFilter) {
+			return nil
+		}
+
+		for i, tc := range file.Tests {
+			testID := tc.TestID
+			if testID == 0 {
+				testID = i + 1
+			}
+			result := TestResult{RuleID: file.RuleID, TestID: testID, Title: tc.Title, Passed: true}
+			if result.Title == "" {
+				result.Title = tc.Desc
+			}
+
+			for _, s := range tc.Stages {
+				if s.Stage != nil {
+					s = *s.Stage
+				}
+				if reason := runStage(handler, s); reason != "" {
+					result.Passed = false
+					result.Reason = reason
+					break
+				}
+			}
+
+			report.Total++
+			if !result.Passed {
+				report.Failed++
+				report.Passed = false
+			}
+			report.Results = append(report.Results, result)
+		}
+		return nil
+	})
+
+	sort.SliceStable(report.Results, func(i, j int) bool {
+		return report.Results[i].RuleID < report.Results[j].RuleID
+	})
+	return report, err
+}
+
+// runStage sends the stage's request and returns a failure reason, or an empty string when the expectations are met
+func runStage(handler http.Handler, s stage) string {
+	status := http.StatusBadRequest
+	var matched []int
+
+	req, err := buildRequest(s.Input)
+	if err == nil {
+		ctx := coraza.WithTransactionObserver(middleware.WithSynthetic(req.Context()), func(tx types.Transaction) {
+			matched = coraza.MatchedRuleIDs(tx)
+		})
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		status = w.Code
+	}
+
+	if len(s.Output.Status) > 0 && !slices.Contains(s.Output.Status, status) {
+		return fmt.Sprintf("expected status %v, got %d", []int(s.Output.Status), status)
+	}
+
+	expectIDs := s.Output.Log.ExpectIDs
+	noExpectIDs := s.Output.Log.NoExpectIDs
+	for _, m := range legacyLogIDPattern.FindAllStringSubmatch(s.Output.LogContains, -1) {
+		id, _ := strconv.Atoi(m[1])
+		expectIDs = append(expectIDs, id)
+	}
+	for _, m := range legacyLogIDPattern.FindAllStringSubmatch(s.Output.NoLogContains, -1) {
+		id, _ := strconv.Atoi(m[1])
+		noExpectIDs = append(noExpectIDs, id)
+	}
+
+	for _, id := range expectIDs {
+		if !slices.Contains(matched, id) {
+			return fmt.Sprintf("expected rule %d to match", id)
+		}
+	}
+	for _, id := range noExpectIDs {
+		if slices.Contains(matched, id) {
+			return fmt.Sprintf("expected rule %d not to match", id)
+		}
+	}
+	return ""
+}
+
+// buildRequest serializes the input to raw HTTP and parses it back, so malformed requests behave as they would on the wire
+func buildRequest(in input) (*http.Request, error) {
+	var raw string
+	if in.EncodedRequest != "" {
+		decoded, err := base64.StdEncoding.DecodeString(in.EncodedRequest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encoded request: %w", err)
+		}
+		raw = string(decoded)
+	} else {
+		method := in.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		uri := in.URI
+		if uri == "" {
+			uri = "/"
+		}
+		version := in.Version
+		if version == "" {
+			version = "HTTP/1.1"
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s %s\r\n", method, uri, version)
+		hasContentLength := false
+		for k, v := range in.Headers {
+			if strings.EqualFold(k, "Content-Length") {
+				hasContentLength = true
+			}
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+		if in.Data != "" && !hasContentLength {
+			fmt.Fprintf(&b, "Content-Length: %d\r\n", len(in.Data))
+		}
+		b.WriteString("\r\n")
+		b.WriteString(in.Data)
+		raw = b.String()
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	return req, nil
+}
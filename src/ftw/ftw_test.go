@@ -0,0 +1,109 @@
+package ftw
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleTests = `---
+meta:
+  author: "test"
+rule_id: 930100
+tests:
+  - test_id: 1
+    desc: "Blocked traversal"
+    stages:
+      - input:
+          method: GET
+          uri: "/?file=../../etc/passwd"
+          headers:
+            Host: localhost
+        output:
+          status: 403
+  - test_id: 2
+    desc: "Allowed request"
+    stages:
+      - stage:
+          input:
+            uri: "/"
+            headers:
+              Host: localhost
+          output:
+            status: [200, 204]
+`
+
+func TestRun(t *testing.T) {
+	tests := fstest.MapFS{
+		"REQUEST-930/930100.yaml": {Data: []byte(sampleTests)},
+		"REQUEST-941/941100.yaml": {Data: []byte("rule_id: 941100\ntests: []\n")},
+	}
+
+	blockQueries := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Should pass when the expectations are met", func(t *testing.T) {
+		report, err := Run(blockQueries, tests, "")
+		require.NoError(t, err)
+		assert.True(t, report.Passed)
+		assert.Equal(t, 2, report.Total)
+	})
+
+	t.Run("Should report failures with a reason", func(t *testing.T) {
+		allowAll := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		report, err := Run(allowAll, tests, "930")
+		require.NoError(t, err)
+		assert.False(t, report.Passed)
+		assert.Equal(t, 1, report.Failed)
+		assert.Equal(t, "expected status [403], got 200", report.Results[0].Reason)
+	})
+
+	t.Run("Should mark the test requests as synthetic", func(t *testing.T) {
+		synthetic := true
+		report, err := Run(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			synthetic = synthetic && middleware.IsSynthetic(r)
+			blockQueries.ServeHTTP(w, r)
+		}), tests, "")
+		require.NoError(t, err)
+		assert.True(t, report.Passed)
+		assert.True(t, synthetic)
+	})
+
+	t.Run("Should filter by rule ID prefix", func(t *testing.T) {
+		report, err := Run(blockQueries, tests, "941")
+		require.NoError(t, err)
+		assert.Equal(t, 0, report.Total)
+	})
+
+	t.Run("Should fail rule expectations when the transaction is not observed", func(t *testing.T) {
+		withIDs := fstest.MapFS{"920100.yaml": {Data: []byte(`
+rule_id: 920100
+tests:
+  - test_id: 1
+    stages:
+      - input:
+          uri: "/"
+        output:
+          log:
+            expect_ids: [920100]
+`)}}
+
+		report, err := Run(blockQueries, withIDs, "")
+		require.NoError(t, err)
+		assert.False(t, report.Passed)
+		assert.Equal(t, "expected rule 920100 to match", report.Results[0].Reason)
+	})
+}
@@ -0,0 +1,265 @@
+// Package gcp authenticates requests to Google Cloud APIs with Application Default Credentials, without the
+// Google Cloud client libraries
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope grants access to Cloud Logging, Pub/Sub and the other Google Cloud APIs
+const Scope = "https://www.googleapis.com/auth/cloud-platform"
+
+// expiryWindow refreshes access tokens this long before they expire
+const expiryWindow = 5 * time.Minute
+
+// credentialsFile is the subset of a service account key or gcloud user credentials file that is used
+type credentialsFile struct {
+	Type           string `json:"type"`
+	ProjectID      string `json:"project_id"`
+	QuotaProjectID string `json:"quota_project_id"`
+	// service_account
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenSource issues OAuth2 access tokens from Application Default Credentials, looked up in order:
+//   - the credentials file named by GOOGLE_APPLICATION_CREDENTIALS
+//   - the user credentials written by gcloud auth application-default login
+//   - the metadata server of GCE and GKE, which serves the Workload Identity service account on GKE
+//
+// Tokens are cached until shortly before they expire.
+type TokenSource struct {
+	client *http.Client
+	getenv func(string) string
+	now    func() time.Time
+	// metadataEndpoint and wellKnownFile are overridden in tests
+	metadataEndpoint string
+	wellKnownFile    string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewTokenSource(client *http.Client) *TokenSource {
+	home, _ := os.UserHomeDir()
+	return &TokenSource{
+		client:           client,
+		getenv:           os.Getenv,
+		now:              time.Now,
+		metadataEndpoint: "http://metadata.google.internal",
+		wellKnownFile:    filepath.Join(home, ".config", "gcloud", "application_default_credentials.json"),
+	}
+}
+
+// Token returns a valid access token, refreshing it if needed
+func (s *TokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.now().Before(s.expires.Add(-expiryWindow)) {
+		return s.token, nil
+	}
+
+	file, err := s.credentialsFile()
+	if err != nil {
+		return "", err
+	}
+	var token tokenResponse
+	switch {
+	case file == nil:
+		token, err = s.metadataToken()
+	case file.Type == "service_account":
+		token, err = s.serviceAccountToken(file)
+	case file.Type == "authorized_user":
+		token, err = s.userToken(file)
+	default:
+		err = fmt.Errorf("unsupported credentials type %q", file.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.expires = s.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// ProjectID returns the project of the credentials: GOOGLE_CLOUD_PROJECT, the credentials file's project or the
+// metadata server's project
+func (s *TokenSource) ProjectID() (string, error) {
+	if project := s.getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+		return project, nil
+	}
+	file, err := s.credentialsFile()
+	if err != nil {
+		return "", err
+	}
+	if file != nil {
+		if file.ProjectID != "" {
+			return file.ProjectID, nil
+		}
+		if file.QuotaProjectID != "" {
+			return file.QuotaProjectID, nil
+		}
+	}
+	project, err := s.metadata("/computeMetadata/v1/project/project-id")
+	if err != nil {
+		return "", fmt.Errorf("no Google Cloud project found, set GOOGLE_CLOUD_PROJECT: %w", err)
+	}
+	return string(project), nil
+}
+
+// credentialsFile reads the explicit or gcloud credentials file, nil if neither exists
+func (s *TokenSource) credentialsFile() (*credentialsFile, error) {
+	path := s.getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	data, err := os.ReadFile(path)
+	if path == "" {
+		data, err = os.ReadFile(s.wellKnownFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		path = s.wellKnownFile
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var file credentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// serviceAccountToken exchanges a JWT signed with the service account's key for an access token
+func (s *TokenSource) serviceAccountToken(file *credentialsFile) (tokenResponse, error) {
+	block, _ := pem.Decode([]byte(file.PrivateKey))
+	if block == nil {
+		return tokenResponse{}, errors.New("invalid service account private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("invalid service account private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return tokenResponse{}, errors.New("service account private key is not an RSA key")
+	}
+	tokenURI := file.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	now := s.now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   file.ClientEmail,
+		"scope": Scope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return s.exchange(tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+}
+
+// userToken refreshes the access token of gcloud user credentials
+func (s *TokenSource) userToken(file *credentialsFile) (tokenResponse, error) {
+	return s.exchange("https://oauth2.googleapis.com/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {file.ClientID},
+		"client_secret": {file.ClientSecret},
+		"refresh_token": {file.RefreshToken},
+	})
+}
+
+func (s *TokenSource) exchange(tokenURI string, form url.Values) (tokenResponse, error) {
+	resp, err := s.client.PostForm(tokenURI, form)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeToken(resp)
+}
+
+func (s *TokenSource) metadataToken() (tokenResponse, error) {
+	data, err := s.metadata("/computeMetadata/v1/instance/service-accounts/default/token")
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("no Google Cloud credentials found: set GOOGLE_APPLICATION_CREDENTIALS or run on GCE or GKE: %w", err)
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(data, &token); err != nil {
+		return tokenResponse{}, fmt.Errorf("invalid metadata token: %w", err)
+	}
+	return token, nil
+}
+
+func (s *TokenSource) metadata(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.metadataEndpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server responded %d", resp.StatusCode)
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
+
+func decodeToken(resp *http.Response) (tokenResponse, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return tokenResponse{}, fmt.Errorf("token endpoint responded %d: %s", resp.StatusCode, data)
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return tokenResponse{}, fmt.Errorf("invalid token response: %s", data)
+	}
+	return token, nil
+}
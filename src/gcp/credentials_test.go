@@ -0,0 +1,118 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSource(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := func(env map[string]string) *TokenSource {
+		s := NewTokenSource(http.DefaultClient)
+		s.getenv = func(name string) string { return env[name] }
+		s.now = func() time.Time { return now }
+		s.wellKnownFile = filepath.Join(t.TempDir(), "missing.json")
+		return s
+	}
+
+	t.Run("Should sign a JWT with a service account key", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			assert.Len(t, parts, 3)
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+			var claims map[string]any
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			assert.NoError(t, json.Unmarshal(payload, &claims))
+			assert.Equal(t, "waf@project.iam.gserviceaccount.com", claims["iss"])
+			assert.Equal(t, Scope, claims["scope"])
+			fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, calls)
+		}))
+		defer server.Close()
+
+		der, _ := x509.MarshalPKCS8PrivateKey(key)
+		credentials, _ := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"project_id":   "project",
+			"client_email": "waf@project.iam.gserviceaccount.com",
+			"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			"token_uri":    server.URL,
+		})
+		file := filepath.Join(t.TempDir(), "key.json")
+		assert.NoError(t, os.WriteFile(file, credentials, 0o600))
+
+		s := source(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": file})
+		token, err := s.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, "token-1", token)
+		token, _ = s.Token()
+		assert.Equal(t, "token-1", token, "Expected a cached token")
+		now = now.Add(56 * time.Minute)
+		token, _ = s.Token()
+		assert.Equal(t, "token-2", token, "Expected the token to be refreshed before it expires")
+
+		project, err := s.ProjectID()
+		assert.NoError(t, err)
+		assert.Equal(t, "project", project)
+	})
+
+	t.Run("Should fall back to the metadata server", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			switch r.URL.Path {
+			case "/computeMetadata/v1/instance/service-accounts/default/token":
+				fmt.Fprint(w, `{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`)
+			case "/computeMetadata/v1/project/project-id":
+				fmt.Fprint(w, "gke-project")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		s := source(map[string]string{})
+		s.metadataEndpoint = server.URL
+		token, err := s.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, "metadata-token", token)
+		project, err := s.ProjectID()
+		assert.NoError(t, err)
+		assert.Equal(t, "gke-project", project)
+	})
+
+	t.Run("Should prefer GOOGLE_CLOUD_PROJECT", func(t *testing.T) {
+		project, err := source(map[string]string{"GOOGLE_CLOUD_PROJECT": "explicit"}).ProjectID()
+		assert.NoError(t, err)
+		assert.Equal(t, "explicit", project)
+	})
+
+	t.Run("Should fail without credentials", func(t *testing.T) {
+		s := source(map[string]string{})
+		s.metadataEndpoint = "http://127.0.0.1:1"
+		_, err := s.Token()
+		assert.ErrorContains(t, err, "no Google Cloud credentials found")
+	})
+}
@@ -0,0 +1,198 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Types of the data section fields
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds the nesting of maps, arrays and pointers, so a corrupt database cannot exhaust the stack
+const maxDepth = 32
+
+var errTruncated = errors.New("data section is truncated")
+
+// decoder decodes the fields of a data section into strings, uint64, int64, float64, bool, []byte, []any and
+// map[string]any
+type decoder struct {
+	data  []byte
+	depth int
+}
+
+// decode returns the field at offset and the offset following it
+func (d *decoder) decode(offset int) (any, int, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer || typ == typeMap || typ == typeArray {
+		if d.depth++; d.depth > maxDepth {
+			return nil, 0, errors.New("data section is nested too deep")
+		}
+		defer func() { d.depth-- }()
+	}
+	if typ == typePointer {
+		// The value is read where the pointer points, decoding goes on after the pointer
+		value, _, err := d.decode(size)
+		return value, offset, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			var key, value any
+			if key, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is a %T, not a string", key)
+			}
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			var value any
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.data) {
+		return nil, 0, errTruncated
+	}
+	b := d.data[offset : offset+size]
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("signed integer of %d bytes", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported field type %d", typ)
+	}
+}
+
+// control reads the control byte of the field at offset, returning its type, its size (or the offset a pointer
+// points to) and the offset of its payload
+func (d *decoder) control(offset int) (int, int, int, error) {
+	if offset >= len(d.data) {
+		return 0, 0, 0, errTruncated
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		return d.pointer(ctrl, offset)
+	}
+	if typ == typeExtended {
+		if offset >= len(d.data) {
+			return 0, 0, 0, errTruncated
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.data) {
+			return 0, 0, 0, errTruncated
+		}
+		extra := 0
+		for _, c := range d.data[offset : offset+n] {
+			extra = extra<<8 | int(c)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	return typ, size, offset, nil
+}
+
+// pointer reads a pointer, whose size bits hold the length and the highest bits of the offset it points to
+func (d *decoder) pointer(ctrl byte, offset int) (int, int, int, error) {
+	n := int(ctrl>>3&0x3) + 1
+	if offset+n > len(d.data) {
+		return 0, 0, 0, errTruncated
+	}
+	target := 0
+	if n < 4 {
+		target = int(ctrl & 0x7)
+	}
+	for _, c := range d.data[offset : offset+n] {
+		target = target<<8 | int(c)
+	}
+	switch n {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+	return typePointer, target, offset + n, nil
+}
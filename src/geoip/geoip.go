@@ -0,0 +1,180 @@
+// Package geoip reads MaxMind DB files, such as the GeoLite2 ASN database, to tell which network a client IP
+// belongs to
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker starts the metadata at the end of the file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree and the data section
+const dataSectionSeparator = 16
+
+// Metadata describes a database
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	RecordSize   int
+	NodeCount    int
+	BuildEpoch   uint64
+}
+
+// Reader looks up IPs in a MaxMind DB file held in memory
+type Reader struct {
+	metadata Metadata
+	tree     []byte
+	data     []byte
+	// ipv4Start is the node IPv4 addresses are looked up from in an IPv6 tree, after 96 zero bits
+	ipv4Start int
+}
+
+// ASN is the autonomous system announcing an IP
+type ASN struct {
+	Number       uint32
+	Organization string
+}
+
+// Open reads the database at path
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	r, err := New(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GeoIP database %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// New reads a database from its content
+func New(db []byte) (*Reader, error) {
+	start := bytes.LastIndex(db, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("metadata not found, not a MaxMind DB file")
+	}
+	value, _, err := (&decoder{data: db[start+len(metadataMarker):]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	metadata := Metadata{
+		DatabaseType: stringField(fields, "database_type"),
+		IPVersion:    int(uintField(fields, "ip_version")),
+		RecordSize:   int(uintField(fields, "record_size")),
+		NodeCount:    int(uintField(fields, "node_count")),
+		BuildEpoch:   uintField(fields, "build_epoch"),
+	}
+	if metadata.RecordSize != 24 && metadata.RecordSize != 28 && metadata.RecordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", metadata.IPVersion)
+	}
+	treeSize := metadata.RecordSize * 2 / 8 * metadata.NodeCount
+	if treeSize+dataSectionSeparator > start {
+		return nil, errors.New("search tree is larger than the file")
+	}
+
+	r := &Reader{metadata: metadata, tree: db[:treeSize], data: db[treeSize+dataSectionSeparator : start]}
+	if metadata.IPVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < metadata.NodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Metadata describes the database
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the record of the network holding the IP, false when the database has none
+func (r *Reader) Lookup(ip netip.Addr) (any, bool, error) {
+	ip = ip.Unmap()
+	node := 0
+	bits := ip.AsSlice()
+	switch {
+	case ip.Is4() && r.metadata.IPVersion == 6:
+		node = r.ipv4Start
+	case ip.Is6() && r.metadata.IPVersion == 4:
+		return nil, false, nil
+	}
+	for i := 0; i < len(bits)*8 && node < r.metadata.NodeCount; i++ {
+		bit := int(bits[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+	if node <= r.metadata.NodeCount {
+		// The node count itself is the empty record
+		return nil, false, nil
+	}
+	offset := node - r.metadata.NodeCount - dataSectionSeparator
+	if offset < 0 || offset >= len(r.data) {
+		return nil, false, fmt.Errorf("record points outside the data section")
+	}
+	value, _, err := (&decoder{data: r.data}).decode(offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// ASN returns the autonomous system of the IP from a GeoLite2 ASN or compatible database
+func (r *Reader) ASN(ip netip.Addr) (ASN, bool) {
+	value, ok, err := r.Lookup(ip)
+	if err != nil || !ok {
+		return ASN{}, false
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return ASN{}, false
+	}
+	number := uintField(fields, "autonomous_system_number")
+	if number == 0 {
+		return ASN{}, false
+	}
+	return ASN{Number: uint32(number), Organization: stringField(fields, "autonomous_system_organization")}, true
+}
+
+// record returns the left (0) or right (1) record of a node
+func (r *Reader) record(node int, bit int) int {
+	switch r.metadata.RecordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return int(b[3]&0xF0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0F)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	default:
+		b := r.tree[node*8+bit*4:]
+		return int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	}
+}
+
+func stringField(fields map[string]any, key string) string {
+	s, _ := fields[key].(string)
+	return s
+}
+
+func uintField(fields map[string]any, key string) uint64 {
+	switch v := fields[key].(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(max(v, 0))
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDB writes MaxMind DB files holding a record per network, the way the MaxMind writer lays them out
+type testDB struct {
+	ipVersion  int
+	recordSize int
+	networks   map[netip.Prefix][]byte
+}
+
+// encodeField encodes strings, unsigned integers, maps and pointers (given as pointerTo)
+func encodeField(value any) []byte {
+	var buf bytes.Buffer
+	control := func(typ int, size int) {
+		sizeBits, extra := size, -1
+		if size >= 29 {
+			sizeBits, extra = 29, size-29
+		}
+		if typ > 7 {
+			buf.WriteByte(byte(sizeBits))
+			buf.WriteByte(byte(typ - 7))
+		} else {
+			buf.WriteByte(byte(typ<<5 | sizeBits))
+		}
+		if extra >= 0 {
+			buf.WriteByte(byte(extra))
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		control(typeString, len(v))
+		buf.WriteString(v)
+	case uint64:
+		b := binary.BigEndian.AppendUint64(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		control(typeUint32, len(b))
+		buf.Write(b)
+	case pointerTo:
+		buf.WriteByte(byte(typePointer<<5 | int(v)>>8&0x7))
+		buf.WriteByte(byte(v))
+	case map[string]any:
+		control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			buf.Write(encodeField(key))
+			buf.Write(encodeField(v[key]))
+		}
+	}
+	return buf.Bytes()
+}
+
+type pointerTo int
+
+type testNode struct {
+	child [2]int
+	data  [2]int
+}
+
+func (db testDB) build() []byte {
+	nodes := []testNode{{child: [2]int{-1, -1}, data: [2]int{-1, -1}}}
+	var data []byte
+	prefixes := make([]netip.Prefix, 0, len(db.networks))
+	for prefix := range db.networks {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].String() < prefixes[j].String() })
+	for _, prefix := range prefixes {
+		offset := len(data)
+		data = append(data, db.networks[prefix]...)
+
+		ip := prefix.Addr().AsSlice()
+		bitCount := prefix.Bits()
+		if prefix.Addr().Is4() && db.ipVersion == 6 {
+			ip = append(make([]byte, 12), ip...)
+			bitCount += 96
+		}
+		node := 0
+		for i := range bitCount {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == bitCount-1 {
+				nodes[node].data[bit] = offset
+				break
+			}
+			if nodes[node].child[bit] < 0 {
+				nodes = append(nodes, testNode{child: [2]int{-1, -1}, data: [2]int{-1, -1}})
+				nodes[node].child[bit] = len(nodes) - 1
+			}
+			node = nodes[node].child[bit]
+		}
+	}
+
+	var tree []byte
+	for _, node := range nodes {
+		var records [2]int
+		for bit := range 2 {
+			switch {
+			case node.child[bit] >= 0:
+				records[bit] = node.child[bit]
+			case node.data[bit] >= 0:
+				records[bit] = len(nodes) + dataSectionSeparator + node.data[bit]
+			default:
+				records[bit] = len(nodes)
+			}
+		}
+		switch db.recordSize {
+		case 24:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]))
+			tree = append(tree, byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		case 28:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]))
+			tree = append(tree, byte(records[0]>>20&0xF0|records[1]>>24&0x0F))
+			tree = append(tree, byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, uint32(records[0]))
+			tree = binary.BigEndian.AppendUint32(tree, uint32(records[1]))
+		}
+	}
+
+	file := append(tree, make([]byte, dataSectionSeparator)...)
+	file = append(file, data...)
+	file = append(file, metadataMarker...)
+	return append(file, encodeField(map[string]any{
+		"database_type": "GeoLite2-ASN",
+		"ip_version":    uint64(db.ipVersion),
+		"record_size":   uint64(db.recordSize),
+		"node_count":    uint64(len(nodes)),
+		"build_epoch":   uint64(1700000000),
+	})...)
+}
+
+func TestReader(t *testing.T) {
+	amazon := encodeField(map[string]any{"autonomous_system_number": uint64(16509), "autonomous_system_organization": "AMAZON-02"})
+	networks := map[netip.Prefix][]byte{
+		netip.MustParsePrefix("192.0.2.0/24"):    amazon,
+		netip.MustParsePrefix("198.51.100.0/25"): encodeField(map[string]any{"autonomous_system_number": uint64(14061), "autonomous_system_organization": "DIGITALOCEAN-ASN"}),
+		// Points to the first record, sorted first in the data section
+		netip.MustParsePrefix("203.0.113.128/26"): encodeField(pointerTo(0)),
+	}
+
+	for _, db := range []testDB{
+		{ipVersion: 4, recordSize: 24, networks: networks},
+		{ipVersion: 6, recordSize: 24, networks: networks},
+		{ipVersion: 6, recordSize: 28, networks: networks},
+		{ipVersion: 4, recordSize: 32, networks: networks},
+	} {
+		reader, err := New(db.build())
+		require.NoError(t, err)
+		assert.Equal(t, "GeoLite2-ASN", reader.Metadata().DatabaseType)
+		assert.Equal(t, db.ipVersion, reader.Metadata().IPVersion)
+
+		asn, ok := reader.ASN(netip.MustParseAddr("192.0.2.77"))
+		assert.True(t, ok)
+		assert.Equal(t, ASN{Number: 16509, Organization: "AMAZON-02"}, asn)
+
+		asn, ok = reader.ASN(netip.MustParseAddr("::ffff:198.51.100.1"))
+		assert.True(t, ok, "IPv4-mapped addresses are looked up as IPv4")
+		assert.Equal(t, uint32(14061), asn.Number)
+
+		_, ok = reader.ASN(netip.MustParseAddr("198.51.100.200"))
+		assert.False(t, ok, "Expected no record outside the /25")
+
+		asn, ok = reader.ASN(netip.MustParseAddr("203.0.113.130"))
+		assert.True(t, ok)
+		assert.Equal(t, uint32(16509), asn.Number, "Expected the pointer to be followed")
+
+		_, ok = reader.ASN(netip.MustParseAddr("2001:db8::1"))
+		assert.False(t, ok)
+	}
+
+	t.Run("Should refuse files that are not MaxMind databases", func(t *testing.T) {
+		_, err := New([]byte("not a database"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Should refuse a tree larger than the file", func(t *testing.T) {
+		file := testDB{ipVersion: 4, recordSize: 24, networks: networks}.build()
+		_, err := New(file[bytes.Index(file, metadataMarker)-1:])
+		assert.Error(t, err)
+	})
+}
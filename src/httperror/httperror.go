@@ -0,0 +1,135 @@
+package httperror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/pool"
+)
+
+// Error codes shared by the WAF and admin servers
+const (
+	CodeBlocked            = "waf.blocked"
+	CodeBanned             = "waf.banned"
+	CodeIPDenied           = "waf.ip_denied"
+	CodeRequestLimit       = "waf.request_limit"
+	CodeTenantQuota        = "waf.tenant_quota_exceeded"
+	CodeASNDenied          = "waf.asn_denied"
+	CodeASNRateLimited     = "waf.asn_rate_limited"
+	CodeThreatIntel        = "waf.threat_intel"
+	CodeScoreDenied        = "waf.score_denied"
+	CodeCookieTampered     = "waf.cookie_tampered"
+	CodeCSRF               = "waf.csrf_failed"
+	CodeSuspiciousURI      = "waf.suspicious_uri"
+	CodeProtocolNotAllowed = "waf.protocol_not_allowed"
+	CodeDecisionDenied     = "waf.decision_denied"
+	CodePolicyDenied       = "waf.policy_denied"
+	CodeScriptDenied       = "waf.script_denied"
+	CodeServiceUnavailable = "waf.unavailable"
+	CodeNotReady           = "not_ready"
+	CodeInternal           = "internal_error"
+)
+
+// Response is the machine-readable error envelope
+type Response struct {
+	Error Body `json:"error"`
+}
+
+type Body struct {
+	Code          string `json:"code"`
+	Message       string `json:"message,omitempty"`
+	RuleIDs       []int  `json:"rule_ids,omitempty"`
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// encodeBuffers hold error envelopes while they are encoded, so denial floods do not allocate a buffer per response
+var encodeBuffers = pool.New("error_response", func() *bytes.Buffer { return new(bytes.Buffer) }, (*bytes.Buffer).Reset)
+
+// Write answers with the error envelope as JSON, or as plain text when the client prefers HTML or text,
+// such as a browser behind Traefik
+func Write(w http.ResponseWriter, r *http.Request, status int, body Body) {
+	if !prefersJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, text(status, body))
+		return
+	}
+	WriteJSON(w, status, body)
+}
+
+// WriteJSON answers with the error envelope as JSON regardless of the Accept header
+func WriteJSON(w http.ResponseWriter, status int, body Body) {
+	buf := encodeBuffers.Get()
+	defer encodeBuffers.Put(buf)
+	if err := json.NewEncoder(buf).Encode(Response{Error: body}); err != nil {
+		slog.Warn("Failed to encode error response", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Warn("Failed to write error response", "error", err)
+	}
+}
+
+// IsBrowser reports whether the request is a browser navigating to a page: it asks for HTML before JSON and its
+// User-Agent looks like a browser's. Scripts calling APIs from a browser usually accept JSON and are not matched.
+func IsBrowser(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla/") && prefersHTML(r.Header.Get("Accept"))
+}
+
+// prefersHTML reports whether the client asked for HTML before any other media type
+func prefersHTML(accept string) bool {
+	for accept != "" {
+		var mediaRange string
+		mediaRange, accept, _ = strings.Cut(accept, ",")
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/html", "application/xhtml+xml":
+			return true
+		case "":
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// prefersJSON reports whether JSON is acceptable and the client did not ask for HTML or plain text first
+func prefersJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for accept != "" {
+		var mediaRange string
+		mediaRange, accept, _ = strings.Cut(accept, ",")
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		mediaType = strings.TrimSpace(mediaType)
+		switch {
+		case strings.Contains(mediaType, "json"), mediaType == "*/*", mediaType == "application/*":
+			return true
+		case strings.HasPrefix(mediaType, "text/"):
+			return false
+		}
+	}
+	return false
+}
+
+func text(status int, body Body) string {
+	message := body.Message
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	line := fmt.Sprintf("%s (%s)", message, body.Code)
+	if body.TransactionID != "" {
+		line += " transaction " + body.TransactionID
+	}
+	return line
+}
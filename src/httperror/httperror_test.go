@@ -0,0 +1,63 @@
+package httperror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	body := Body{Code: CodeBlocked, RuleIDs: []int{930100}, TransactionID: "abc123"}
+
+	t.Run("Should write JSON by default", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json", "*/*", "application/problem+json, text/plain"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			Write(w, req, http.StatusForbidden, body)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"), accept)
+
+			var response Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, body, response.Error)
+		}
+	})
+
+	t.Run("Should write plain text for browsers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+		w := httptest.NewRecorder()
+		Write(w, req, http.StatusForbidden, body)
+
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Forbidden (waf.blocked) transaction abc123\n", w.Body.String())
+	})
+}
+
+func TestIsBrowser(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		accept    string
+		expected  bool
+	}{
+		{name: "Should match page navigations", userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", expected: true},
+		{name: "Should not match fetch calls from browsers", userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", accept: "*/*"},
+		{name: "Should not match API clients", userAgent: "curl/8.5.0", accept: "text/html"},
+		{name: "Should not match requests without Accept", userAgent: "Mozilla/5.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", test.userAgent)
+			req.Header.Set("Accept", test.accept)
+			assert.Equal(t, test.expected, IsBrowser(req))
+		})
+	}
+}
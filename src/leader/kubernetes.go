@@ -0,0 +1,213 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the token, CA and namespace mounted into every pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is the format of the Lease times
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// lease is the subset of a coordination.k8s.io/v1 Lease that is used
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// leaseLock holds a Kubernetes Lease through the API server, with the pod's service account. Concurrent updates are
+// refused by the API server thanks to the resource version, so only one replica takes an expired Lease.
+type leaseLock struct {
+	client   *http.Client
+	url      string
+	name     string
+	identity string
+	duration time.Duration
+	now      func() time.Time
+	// tokenFile is read on every request, as the kubelet rotates the token
+	tokenFile string
+}
+
+func newLeaseLock(options Options) (*leaseLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("the Kubernetes leader election backend only runs in a pod, KUBERNETES_SERVICE_HOST is not set")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("the service account CA holds no certificate")
+	}
+	namespace := options.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}}
+	return newLeaseLockAt(client, "https://"+net.JoinHostPort(host, port), namespace, filepath.Join(serviceAccountDir, "token"), options), nil
+}
+
+func newLeaseLockAt(client *http.Client, apiURL string, namespace string, tokenFile string, options Options) *leaseLock {
+	return &leaseLock{
+		client:    client,
+		url:       apiURL + "/apis/coordination.k8s.io/v1/namespaces/" + namespace + "/leases",
+		name:      options.Name,
+		identity:  options.Identity,
+		duration:  options.LeaseDuration,
+		now:       time.Now,
+		tokenFile: tokenFile,
+	}
+}
+
+func (l *leaseLock) acquire(ctx context.Context) (bool, error) {
+	now := l.now().UTC().Format(microTime)
+	current, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current == nil {
+		created := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: l.name},
+			Spec:       leaseSpec{HolderIdentity: l.identity, LeaseDurationSeconds: l.seconds(), AcquireTime: now, RenewTime: now},
+		}
+		return l.write(ctx, http.MethodPost, l.url, created)
+	}
+
+	spec := &current.Spec
+	if spec.HolderIdentity != l.identity {
+		if spec.HolderIdentity != "" && !l.expired(spec) {
+			return false, nil
+		}
+		spec.HolderIdentity = l.identity
+		spec.AcquireTime = now
+		spec.LeaseTransitions++
+	}
+	spec.LeaseDurationSeconds = l.seconds()
+	spec.RenewTime = now
+	return l.write(ctx, http.MethodPut, l.url+"/"+l.name, *current)
+}
+
+func (l *leaseLock) release(ctx context.Context) error {
+	current, err := l.get(ctx)
+	if err != nil || current == nil || current.Spec.HolderIdentity != l.identity {
+		return err
+	}
+	// Leave a short expired lease behind, as client-go does, so the next leader counts the transition
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	_, err = l.write(ctx, http.MethodPut, l.url+"/"+l.name, *current)
+	return err
+}
+
+// expired reports whether the holder has not renewed the lease in time
+func (l *leaseLock) expired(spec *leaseSpec) bool {
+	renewed, err := time.Parse(microTime, spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return l.now().After(renewed.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (l *leaseLock) seconds() int {
+	return max(1, int(l.duration.Round(time.Second)/time.Second))
+}
+
+// get returns the lease, or nil when it does not exist yet
+func (l *leaseLock) get(ctx context.Context) (*lease, error) {
+	resp, err := l.do(ctx, http.MethodGet, l.url+"/"+l.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	var current lease
+	if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+		return nil, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return &current, nil
+}
+
+// write creates or updates the lease, reporting false when another replica updated it first
+func (l *leaseLock) write(ctx context.Context, method string, target string, body lease) (bool, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+	resp, err := l.do(ctx, method, target, data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return false, nil
+	case resp.StatusCode >= 300:
+		return false, apiError(resp)
+	}
+	return true, nil
+}
+
+func (l *leaseLock) do(ctx context.Context, method string, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(l.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return l.client.Do(req)
+}
+
+func apiError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("kubernetes API answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
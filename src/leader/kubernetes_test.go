@@ -0,0 +1,108 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPIServer stores a single Lease, refusing updates of a stale resource version like the API server
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if s.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.lease)
+	case http.MethodPost, http.MethodPut:
+		var updated lease
+		json.NewDecoder(r.Body).Decode(&updated)
+		if (r.Method == http.MethodPost) != (s.lease == nil) || s.lease != nil && updated.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.version++
+		updated.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		s.lease = &updated
+		json.NewEncoder(w).Encode(s.lease)
+	}
+}
+
+func TestLeaseLock(t *testing.T) {
+	api := &fakeAPIServer{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0o600))
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newLock := func(identity string) *leaseLock {
+		l := newLeaseLockAt(server.Client(), server.URL, "waf", tokenFile, Options{Name: "waf-leader", Identity: identity, LeaseDuration: 15 * time.Second})
+		l.now = func() time.Time { return now }
+		return l
+	}
+	first, second := newLock("waf-0"), newLock("waf-1")
+	ctx := context.Background()
+
+	t.Run("Should create the lease and keep it while it is renewed", func(t *testing.T) {
+		held, err := first.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+		assert.Equal(t, "waf-0", api.lease.Spec.HolderIdentity)
+		assert.Equal(t, 15, api.lease.Spec.LeaseDurationSeconds)
+
+		now = now.Add(10 * time.Second)
+		held, err = second.acquire(ctx)
+		require.NoError(t, err)
+		assert.False(t, held)
+		held, err = first.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+		assert.Equal(t, now.Format(microTime), api.lease.Spec.RenewTime)
+	})
+
+	t.Run("Should take over an expired lease", func(t *testing.T) {
+		now = now.Add(16 * time.Second)
+		held, err := second.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+		assert.Equal(t, "waf-1", api.lease.Spec.HolderIdentity)
+		assert.Equal(t, 1, api.lease.Spec.LeaseTransitions)
+
+		held, err = first.acquire(ctx)
+		require.NoError(t, err)
+		assert.False(t, held)
+	})
+
+	t.Run("Should hand over a released lease right away", func(t *testing.T) {
+		require.NoError(t, first.release(ctx), "Expected releasing a lease held by another replica to do nothing")
+		assert.Equal(t, "waf-1", api.lease.Spec.HolderIdentity)
+
+		require.NoError(t, second.release(ctx))
+		held, err := first.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+	})
+}
@@ -0,0 +1,173 @@
+// Package leader elects one replica of the cluster to run the jobs that must run once, such as expiring shared
+// backups and sending reports, while every replica keeps handling requests
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Backends holding the leader lock
+const (
+	BackendKubernetes = "kubernetes"
+	BackendRedis      = "redis"
+)
+
+// Defaults of the Options
+const (
+	DefaultName          = "coraza-traefik-middleware"
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewInterval = 5 * time.Second
+)
+
+// Options configures the leader election
+type Options struct {
+	// Backend is BackendKubernetes or BackendRedis. Empty disables the election, every replica being the leader.
+	Backend string
+	// Name is the Lease or Redis key held by the leader
+	Name string
+	// Identity names this replica in the lock. Empty uses the hostname, which is the pod name in Kubernetes.
+	Identity string
+	// LeaseDuration is how long the lock is held without being renewed before another replica takes over
+	LeaseDuration time.Duration
+	// RenewInterval is how often the leader renews the lock and the other replicas try to take it
+	RenewInterval time.Duration
+	// Namespace holds the Lease. Empty uses the namespace of the pod's service account.
+	Namespace string
+	// RedisURL is the Redis server holding the lock, e.g. redis://:password@redis:6379/0, or rediss:// for TLS
+	RedisURL string
+}
+
+// lock is the leader lock shared by the replicas
+type lock interface {
+	// acquire takes the lock, or renews it when this replica holds it, reporting whether it holds it now
+	acquire(ctx context.Context) (bool, error)
+	// release gives up the lock if this replica holds it
+	release(ctx context.Context) error
+}
+
+// Elector keeps trying to hold the leader lock, renewing it while it is the leader
+type Elector struct {
+	options Options
+	lock    lock
+	logger  *slog.Logger
+	leader  atomic.Bool
+
+	stopSignal chan struct{}
+	jobDone    chan struct{}
+}
+
+// New returns an elector using the backend of the options. Without a backend, the elector is always the leader.
+func New(options Options) (*Elector, error) {
+	if options.Name == "" {
+		options.Name = DefaultName
+	}
+	if options.LeaseDuration <= 0 {
+		options.LeaseDuration = DefaultLeaseDuration
+	}
+	if options.RenewInterval <= 0 {
+		options.RenewInterval = DefaultRenewInterval
+	}
+	if options.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to name this replica: %w", err)
+		}
+		options.Identity = hostname
+	}
+
+	e := &Elector{options: options, logger: slog.Default(), stopSignal: make(chan struct{}), jobDone: make(chan struct{})}
+	switch options.Backend {
+	case "":
+		e.leader.Store(true)
+	case BackendKubernetes:
+		l, err := newLeaseLock(options)
+		if err != nil {
+			return nil, err
+		}
+		e.lock = l
+	case BackendRedis:
+		l, err := newRedisLock(options)
+		if err != nil {
+			return nil, err
+		}
+		e.lock = l
+	default:
+		return nil, fmt.Errorf("unknown leader election backend %q, expected %s or %s", options.Backend, BackendKubernetes, BackendRedis)
+	}
+	metricLeader.Set(boolValue(e.leader.Load()))
+	return e, nil
+}
+
+// IsLeader reports whether this replica runs the cluster-wide jobs
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Start takes part in the election until Stop is called
+func (e *Elector) Start() {
+	defer close(e.jobDone)
+	if e.lock == nil {
+		return
+	}
+	e.logger.Info("Starting leader election", "backend", e.options.Backend, "name", e.options.Name, "identity", e.options.Identity)
+
+	ticker := time.NewTicker(e.options.RenewInterval)
+	defer ticker.Stop()
+	for {
+		e.campaign()
+		select {
+		case <-e.stopSignal:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign tries to take or renew the lock once. A replica that cannot reach the backend steps down, since another
+// replica may take the lock once it expires.
+func (e *Elector) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.options.RenewInterval)
+	defer cancel()
+	held, err := e.lock.acquire(ctx)
+	if err != nil {
+		metricErrors.Inc()
+		e.logger.Warn("Failed to renew the leader lock", "backend", e.options.Backend, "error", err)
+		held = false
+	}
+	if e.leader.Swap(held) != held {
+		metricTransitions.Inc()
+		metricLeader.Set(boolValue(held))
+		if held {
+			e.logger.Info("Became the leader, running the cluster-wide jobs", "identity", e.options.Identity)
+		} else {
+			e.logger.Info("Lost the leadership, another replica runs the cluster-wide jobs", "identity", e.options.Identity)
+		}
+	}
+}
+
+// Stop leaves the election, releasing the lock so another replica takes over without waiting for it to expire
+func (e *Elector) Stop(ctx context.Context) error {
+	close(e.stopSignal)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-e.jobDone:
+	}
+	if e.lock == nil || !e.leader.Swap(false) {
+		return nil
+	}
+	metricLeader.Set(0)
+	return e.lock.release(ctx)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
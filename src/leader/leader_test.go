@@ -0,0 +1,72 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLock struct {
+	held     bool
+	err      error
+	released bool
+}
+
+func (f *fakeLock) acquire(ctx context.Context) (bool, error) {
+	return f.held, f.err
+}
+
+func (f *fakeLock) release(ctx context.Context) error {
+	f.released = true
+	return nil
+}
+
+func TestElector(t *testing.T) {
+	t.Run("Should always lead without a backend", func(t *testing.T) {
+		elector, err := New(Options{Identity: "waf-0"})
+		require.NoError(t, err)
+		assert.True(t, elector.IsLeader())
+		go elector.Start()
+		require.NoError(t, elector.Stop(context.Background()))
+	})
+
+	t.Run("Should follow the lock", func(t *testing.T) {
+		elector, err := New(Options{Identity: "waf-0"})
+		require.NoError(t, err)
+		lock := &fakeLock{}
+		elector.lock = lock
+		elector.leader.Store(false)
+
+		elector.campaign()
+		assert.False(t, elector.IsLeader())
+		lock.held = true
+		elector.campaign()
+		assert.True(t, elector.IsLeader())
+
+		lock.err = errors.New("connection refused")
+		elector.campaign()
+		assert.False(t, elector.IsLeader(), "Expected the leader to step down when the backend is unreachable")
+	})
+
+	t.Run("Should release the lock when stopping as the leader", func(t *testing.T) {
+		elector, err := New(Options{Identity: "waf-0"})
+		require.NoError(t, err)
+		lock := &fakeLock{held: true}
+		elector.lock = lock
+		go elector.Start()
+		assert.Eventually(t, elector.IsLeader, time.Second, time.Millisecond)
+
+		require.NoError(t, elector.Stop(context.Background()))
+		assert.True(t, lock.released)
+		assert.False(t, elector.IsLeader())
+	})
+
+	t.Run("Should refuse unknown backends", func(t *testing.T) {
+		_, err := New(Options{Backend: "zookeeper"})
+		assert.ErrorContains(t, err, "unknown leader election backend")
+	})
+}
@@ -0,0 +1,19 @@
+package leader
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricLeader = metrics.NewGauge(
+	"waf_leader",
+	"Whether this replica is the leader running the cluster-wide jobs (1) or not (0)",
+)
+
+var metricTransitions = metrics.NewCounter(
+	"waf_leader_transitions_total",
+	"The total number of times this replica became or stopped being the leader",
+)
+
+var metricErrors = metrics.NewCounter(
+	"waf_leader_errors_total",
+	"The total number of failures to reach the leader election backend",
+	metrics.WithAlertAbove(0),
+)
@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// acquireScript sets the key to the identity when it is free, or extends it when the identity already holds it
+const acquireScript = `local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then return redis.call('PEXPIRE', KEYS[1], ARGV[2]) end
+if holder then return 0 end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1`
+
+// releaseScript deletes the key only when the identity holds it
+const releaseScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) end
+return 0`
+
+// redisLock holds a key expiring after the lease duration, set and renewed atomically by a script so a replica never
+// extends a lock that another replica took over
+type redisLock struct {
+	address  string
+	password string
+	db       int
+	tls      *tls.Config
+	key      string
+	identity string
+	duration time.Duration
+}
+
+func newRedisLock(options Options) (*redisLock, error) {
+	u, err := url.Parse(options.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL %q, expected redis:// or rediss://", u.Redacted())
+	}
+	l := &redisLock{address: u.Host, key: options.Name, identity: options.Identity, duration: options.LeaseDuration}
+	if u.Port() == "" {
+		l.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		l.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	l.password, _ = u.User.Password()
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return l, nil
+}
+
+func (l *redisLock) acquire(ctx context.Context) (bool, error) {
+	reply, err := l.run(ctx, "EVAL", acquireScript, "1", l.key, l.identity, strconv.FormatInt(l.duration.Milliseconds(), 10))
+	return reply == "1", err
+}
+
+func (l *redisLock) release(ctx context.Context) error {
+	_, err := l.run(ctx, "EVAL", releaseScript, "1", l.key, l.identity)
+	return err
+}
+
+// run sends the command on a new connection, returning its simple, integer or bulk string reply. The lock is renewed
+// every few seconds, so the connection is not kept between commands.
+func (l *redisLock) run(ctx context.Context, args ...string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", l.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if l.tls != nil {
+		tlsConn := tls.Client(conn, l.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return "", err
+		}
+		conn = tlsConn
+	}
+
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	var commands [][]string
+	if l.password != "" {
+		commands = append(commands, []string{"AUTH", l.password})
+	}
+	if l.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(l.db)})
+	}
+	commands = append(commands, args)
+	for _, command := range commands {
+		writeCommand(writer, command)
+	}
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+	var reply string
+	for _, command := range commands {
+		if reply, err = readReply(reader); err != nil {
+			return "", fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return reply, nil
+}
+
+// writeCommand writes the command as a RESP array of bulk strings
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply reads a simple string, error, integer or bulk string reply. A nil bulk string is returned as empty.
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.New(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("malformed reply %q", line)
+		}
+		if size < 0 {
+			return "", nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		return string(data[:size]), nil
+	default:
+		return "", fmt.Errorf("unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,139 @@
+package leader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis runs the lock scripts against a single key, requiring a password
+type fakeRedis struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	now     time.Time
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	redis := &fakeRedis{now: time.Unix(1700000000, 0)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go redis.serve(conn)
+		}
+	}()
+	return redis, listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, count)
+		for i := range args {
+			header, _ := reader.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+			arg := make([]byte, size+2)
+			io.ReadFull(reader, arg)
+			args[i] = string(arg[:size])
+		}
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == "secret"
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "EVAL":
+			fmt.Fprintf(conn, ":%d\r\n", f.eval(args[1], args[4:]))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func (f *fakeRedis) eval(script string, argv []string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.now.Before(f.expires) {
+		f.holder = ""
+	}
+	switch {
+	case script == acquireScript && (f.holder == "" || f.holder == argv[0]):
+		ttl, _ := strconv.Atoi(argv[1])
+		f.holder, f.expires = argv[0], f.now.Add(time.Duration(ttl)*time.Millisecond)
+		return 1
+	case script == releaseScript && f.holder == argv[0]:
+		f.holder = ""
+		return 1
+	}
+	return 0
+}
+
+func (f *fakeRedis) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestRedisLock(t *testing.T) {
+	redis, address := newFakeRedis(t)
+	newLock := func(identity string, password string) *redisLock {
+		l, err := newRedisLock(Options{RedisURL: "redis://:" + password + "@" + address, Name: "waf-leader", Identity: identity, LeaseDuration: 15 * time.Second})
+		require.NoError(t, err)
+		return l
+	}
+	first, second := newLock("waf-0", "secret"), newLock("waf-1", "secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Should hold the lock until it expires", func(t *testing.T) {
+		held, err := first.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+		held, err = second.acquire(ctx)
+		require.NoError(t, err)
+		assert.False(t, held)
+
+		redis.advance(16 * time.Second)
+		held, err = second.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+	})
+
+	t.Run("Should hand over a released lock right away", func(t *testing.T) {
+		require.NoError(t, second.release(ctx))
+		held, err := first.acquire(ctx)
+		require.NoError(t, err)
+		assert.True(t, held)
+	})
+
+	t.Run("Should report Redis errors", func(t *testing.T) {
+		_, err := newLock("waf-2", "wrong").acquire(ctx)
+		assert.EqualError(t, err, "redis EVAL: NOAUTH Authentication required.")
+
+		_, err = newRedisLock(Options{RedisURL: "http://redis:6379"})
+		assert.ErrorContains(t, err, "expected redis:// or rediss://")
+	})
+}
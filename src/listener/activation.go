@@ -0,0 +1,170 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// Names of the sockets passed without a name, in order
+var defaultNames = []string{"waf", "admin"}
+
+// Activated returns the sockets passed to the process by systemd socket activation (LISTEN_FDS), or by the previous
+// process during an upgrade, keyed by their LISTEN_FDNAMES name. Unnamed sockets are taken to be the WAF socket,
+// then the admin socket. The variables are unset so that they are not inherited by child processes.
+func Activated() (map[string]net.Listener, error) {
+	count, names, err := activatedNames(os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	listeners := map[string]net.Listener{}
+	for i := range count {
+		fd := listenFDsStart + i
+		file := os.NewFile(uintptr(fd), names[i])
+		// The listener holds a duplicate of the descriptor, which is not inherited by child processes
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("passed file descriptor %d (%s) is not a listening socket: %w", fd, names[i], err)
+		}
+		listeners[names[i]] = l
+	}
+	return listeners, nil
+}
+
+// activatedNames returns the number of passed sockets and their names. LISTEN_PID is checked when it is set, as
+// systemd does; an upgrade from a previous process leaves it unset because the new process ID is not known in advance.
+func activatedNames(fds string, pid string, fdNames string, currentPID int) (int, []string, error) {
+	if fds == "" {
+		return 0, nil, nil
+	}
+	if pid != "" && pid != strconv.Itoa(currentPID) {
+		// Meant for another process
+		return 0, nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return 0, nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	var given []string
+	if fdNames != "" {
+		given = strings.Split(fdNames, ":")
+	}
+	names := make([]string, count)
+	unnamed := 0
+	for i := range names {
+		if i < len(given) && given[i] != "" && given[i] != "unknown" {
+			names[i] = given[i]
+			continue
+		}
+		if unnamed >= len(defaultNames) {
+			return 0, nil, errors.New("LISTEN_FDNAMES must name every socket after the WAF and admin sockets")
+		}
+		names[i] = defaultNames[unnamed]
+		unnamed++
+	}
+	return count, names, nil
+}
+
+// Upgrade starts a new instance of the running binary with the same arguments, passing it the listening sockets
+// so that it can take over without refusing connections. The new process finds them through Activated.
+func Upgrade(listeners map[string]net.Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the running binary: %w", err)
+	}
+
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for name, l := range listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s cannot be passed on", name)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pass on listener %s: %w", name, err)
+		}
+		if unix, ok := l.(*net.UnixListener); ok {
+			// The new process serves the socket file from now on
+			unix.SetUnlinkOnClose(false)
+		}
+		names = append(names, name)
+		files = append(files, file)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		UpgradeParentEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the new process: %w", err)
+	}
+	return cmd.Process, nil
+}
+
+// UpgradeParentEnv holds the ID of the process that started this one through Upgrade
+const UpgradeParentEnv = "WAF_UPGRADE_PARENT_PID"
+
+// NotifyReady tells the process that started this one through Upgrade that it can shut down, and tells systemd
+// (when NOTIFY_SOCKET is set) that the service is ready and which process it now is.
+func NotifyReady() error {
+	var errs []error
+	if parent := os.Getenv(UpgradeParentEnv); parent != "" {
+		os.Unsetenv(UpgradeParentEnv)
+		pid, err := strconv.Atoi(parent)
+		var process *os.Process
+		if err == nil {
+			process, err = os.FindProcess(pid)
+		}
+		if err == nil {
+			err = process.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop the previous process %s: %w", parent, err))
+		}
+	}
+	if socket := os.Getenv("NOTIFY_SOCKET"); socket != "" {
+		errs = append(errs, notifySystemd(socket, fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())))
+	}
+	return errors.Join(errs...)
+}
+
+// notifySystemd sends a sd_notify state to the socket
+func notifySystemd(socket string, state string) error {
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package listener
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivatedNames(t *testing.T) {
+	t.Run("no sockets passed", func(t *testing.T) {
+		count, names, err := activatedNames("", "", "", 10)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		assert.Nil(t, names)
+	})
+
+	t.Run("sockets meant for another process are ignored", func(t *testing.T) {
+		count, _, err := activatedNames("2", "11", "", 10)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("unnamed sockets are the WAF then admin sockets", func(t *testing.T) {
+		count, names, err := activatedNames("2", "10", "", 10)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []string{"waf", "admin"}, names)
+	})
+
+	t.Run("named sockets", func(t *testing.T) {
+		count, names, err := activatedNames("3", "", "admin:unknown:internal", 10)
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, []string{"admin", "waf", "internal"}, names)
+	})
+
+	t.Run("too many unnamed sockets", func(t *testing.T) {
+		_, _, err := activatedNames("3", "", "", 10)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		_, _, err := activatedNames("x", "", "", 10)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,250 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errSlowConnection = errors.New("connection closed: read rate below minimum")
+
+// GuardOptions configures the connection-level protections applied by a GuardedListener
+type GuardOptions struct {
+	// MinReadRate is the minimum bytes per second a client must send while a request is being read. Zero disables the check.
+	MinReadRate int
+	// ReadRateGracePeriod is how long a request may be read before the read rate is enforced
+	ReadRateGracePeriod time.Duration
+}
+
+// GuardedListener wraps a net.Listener to protect the server against slowloris-style clients.
+// Its ConnState and ConnContext methods must be installed on the http.Server, and its Handler must wrap the server's
+// handler, so that read rates are only enforced while requests are being read.
+type GuardedListener struct {
+	net.Listener
+	options GuardOptions
+}
+
+func NewGuardedListener(inner net.Listener, options GuardOptions) *GuardedListener {
+	return &GuardedListener{
+		Listener: inner,
+		options:  options,
+	}
+}
+
+// Accept waits for the next connection. The connection is not capped per IP: every connection comes from Traefik,
+// so a per-IP cap would throttle the proxy rather than a client.
+func (l *GuardedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &guardedConn{Conn: conn, listener: l, ip: remoteIP(conn)}, nil
+}
+
+// ConnState tracks when a connection starts and finishes reading a request
+func (l *GuardedListener) ConnState(conn net.Conn, state http.ConnState) {
+	gc, ok := conn.(*guardedConn)
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateActive:
+		gc.startRequest(time.Now())
+	case http.StateIdle:
+		gc.endRequest()
+	}
+}
+
+// connKey is the context key of the guarded connection a request arrived on
+type connKey struct{}
+
+// ConnContext makes the guarded connection available to Handler
+func (l *GuardedListener) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if gc, ok := conn.(*guardedConn); ok {
+		return context.WithValue(ctx, connKey{}, gc)
+	}
+	return ctx
+}
+
+// Handler stops enforcing the read rate once the request body has been read, so a connection is not closed while
+// its response is being prepared
+func (l *GuardedListener) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gc, ok := r.Context().Value(connKey{}).(*guardedConn)
+		if ok {
+			if r.Body == nil || r.Body == http.NoBody {
+				gc.endRequest()
+			} else {
+				r.Body = &guardedBody{ReadCloser: r.Body, conn: gc}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// guardedBody ends the read rate enforcement of its connection once it has been read or closed
+type guardedBody struct {
+	io.ReadCloser
+	conn *guardedConn
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.conn.endRequest()
+	}
+	return n, err
+}
+
+func (b *guardedBody) Close() error {
+	b.conn.endRequest()
+	return b.ReadCloser.Close()
+}
+
+type guardedConn struct {
+	net.Conn
+	listener *GuardedListener
+	ip       string
+
+	mu          sync.Mutex
+	activeSince time.Time
+	bytesRead   int64
+	// deadline is the read deadline set by the server, which the rate deadline may only bring forward
+	deadline time.Time
+}
+
+// Read reads with a deadline by which the minimum rate must be kept up, so a client that stops sending is closed
+// even though no read returns
+func (c *guardedConn) Read(b []byte) (int, error) {
+	rateDeadline, err := c.applyDeadline()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && c.rateExpired(rateDeadline, time.Now()) {
+		return n, c.closeSlow()
+	}
+	if n > 0 && err == nil && c.tooSlow(n, time.Now()) {
+		return n, c.closeSlow()
+	}
+	return n, err
+}
+
+// SetReadDeadline records the deadline of the server and applies it, brought forward by the rate deadline
+func (c *guardedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(earliest(t, c.rateDeadline()))
+}
+
+// SetDeadline records the read deadline of the server like SetReadDeadline
+func (c *guardedConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+// applyDeadline sets the earliest of the server and rate deadlines before a read, returning the rate deadline
+func (c *guardedConn) applyDeadline() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rateDeadline := c.rateDeadline()
+	if rateDeadline.IsZero() {
+		return rateDeadline, nil
+	}
+	return rateDeadline, c.Conn.SetReadDeadline(earliest(c.deadline, rateDeadline))
+}
+
+// rateDeadline returns when the request falls below the minimum rate if no more bytes arrive, zero when the rate
+// is not enforced. The caller must hold the lock.
+func (c *guardedConn) rateDeadline() time.Time {
+	minRate := c.listener.options.MinReadRate
+	if minRate <= 0 || c.activeSince.IsZero() {
+		return time.Time{}
+	}
+	return c.activeSince.Add(max(c.listener.options.ReadRateGracePeriod, time.Duration(float64(c.bytesRead)/float64(minRate)*float64(time.Second))))
+}
+
+// rateExpired reports whether a read timed out on its rate deadline rather than on the deadline of the server
+func (c *guardedConn) rateExpired(rateDeadline time.Time, now time.Time) bool {
+	if rateDeadline.IsZero() || now.Before(rateDeadline) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.activeSince.IsZero() && (c.deadline.IsZero() || rateDeadline.Before(c.deadline))
+}
+
+func (c *guardedConn) closeSlow() error {
+	metricSlowConnections.Inc()
+	slog.Warn("Closing slow connection", "remote_ip", c.ip, "min_read_rate", c.listener.options.MinReadRate)
+	c.Close()
+	return errSlowConnection
+}
+
+// earliest returns the earliest of two deadlines, zero meaning none
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func (c *guardedConn) startRequest(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.activeSince = now
+	c.bytesRead = 0
+}
+
+// endRequest stops enforcing the rate, restoring the deadline of the server for reads already waiting
+func (c *guardedConn) endRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.activeSince.IsZero() {
+		return
+	}
+	c.activeSince = time.Time{}
+	if c.listener.options.MinReadRate > 0 {
+		c.Conn.SetReadDeadline(c.deadline)
+	}
+}
+
+// tooSlow records the bytes read and reports whether the request is being sent below the minimum rate
+func (c *guardedConn) tooSlow(n int, now time.Time) bool {
+	minRate := c.listener.options.MinReadRate
+	if minRate <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.activeSince.IsZero() {
+		return false
+	}
+	c.bytesRead += int64(n)
+
+	elapsed := now.Sub(c.activeSince)
+	if elapsed <= c.listener.options.ReadRateGracePeriod {
+		return false
+	}
+	return float64(c.bytesRead)/elapsed.Seconds() < float64(minRate)
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
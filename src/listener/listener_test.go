@@ -0,0 +1,89 @@
+package listener
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardedConnReadRate(t *testing.T) {
+	listener := NewGuardedListener(nil, GuardOptions{
+		MinReadRate:         100,
+		ReadRateGracePeriod: time.Second,
+	})
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &guardedConn{Conn: server, listener: listener}
+	start := time.Now()
+
+	assert.False(t, conn.tooSlow(10, start.Add(5*time.Second)), "Should not enforce the rate outside of a request")
+
+	listener.ConnState(conn, http.StateActive)
+	conn.startRequest(start)
+	assert.False(t, conn.tooSlow(10, start.Add(500*time.Millisecond)), "Should not enforce the rate during the grace period")
+	assert.True(t, conn.tooSlow(10, start.Add(2*time.Second)), "Should flag 20 bytes over 2 seconds as too slow")
+
+	conn.startRequest(start)
+	assert.False(t, conn.tooSlow(500, start.Add(2*time.Second)), "Should allow 500 bytes over 2 seconds")
+
+	listener.ConnState(conn, http.StateIdle)
+	assert.False(t, conn.tooSlow(1, start.Add(time.Hour)), "Should stop enforcing once the connection is idle")
+}
+
+func TestGuardedListenerStalledRequest(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewGuardedListener(inner, GuardOptions{MinReadRate: 100, ReadRateGracePeriod: 200 * time.Millisecond})
+	bodyErrs := make(chan error, 1)
+	server := &http.Server{
+		Handler: listener.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > 0 {
+				_, err := io.ReadAll(r.Body)
+				bodyErrs <- err
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+		})),
+		ConnState:   listener.ConnState,
+		ConnContext: listener.ConnContext,
+		ReadTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("Should close a connection that stops sending the body", func(t *testing.T) {
+		conn := dial()
+		fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: waf\r\nContent-Length: 1000\r\n\r\nab")
+
+		select {
+		case err := <-bodyErrs:
+			assert.ErrorIs(t, err, errSlowConnection)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the stalled body read to fail before the server's read timeout")
+		}
+	})
+
+	t.Run("Should not close a connection whose request has been read", func(t *testing.T) {
+		conn := dial()
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: waf\r\n\r\n")
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
@@ -0,0 +1,11 @@
+package listener
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricSlowConnections = metrics.NewCounter(
+	"waf_slow_connections_terminated_total",
+	"The total number of connections closed for sending requests below the minimum read rate",
+	metrics.WithAlertAbove(1),
+)
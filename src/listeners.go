@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+)
+
+// wafListener is an additional WAF listener whose requests are evaluated with its own directives, so one process
+// can serve Traefik entrypoints with different policies
+type wafListener struct {
+	// Name identifies the listener in logs and selects its directives, DIRECTIVES_<NAME>
+	Name string
+	// Address is a TCP port, or unix:<path> for a unix socket
+	Address string
+}
+
+var listenerNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// parseWAFListeners parses a comma-separated list of "name=port" and "name=unix:<path>" listeners
+func parseWAFListeners(value string) ([]wafListener, error) {
+	var listeners []wafListener
+	for _, item := range splitList(value) {
+		name, address, ok := strings.Cut(item, "=")
+		if !ok || name == "" || address == "" {
+			return nil, fmt.Errorf("invalid listener %q, expected name=port or name=unix:<path>", item)
+		}
+		listeners = append(listeners, wafListener{Name: strings.TrimSpace(name), Address: strings.TrimSpace(address)})
+	}
+	return listeners, nil
+}
+
+// directivesVar is the environment variable holding the listener's directives
+func (l wafListener) directivesVar() string {
+	return coraza.DefaultDirectivesVar + "_" + strings.ToUpper(l.Name)
+}
+
+// socketPath returns the path of a unix socket listener
+func (l wafListener) socketPath() (string, bool) {
+	return strings.CutPrefix(l.Address, "unix:")
+}
+
+// handlerOptions derives the listener's WAF handler options from those of the main listener
+func (l wafListener) handlerOptions(options coraza.WAFHandlerOptions) coraza.WAFHandlerOptions {
+	options.DirectivesVar = l.directivesVar()
+	if options.DirectiveHistory.Dir != "" {
+		options.DirectiveHistory.Dir = filepath.Join(options.DirectiveHistory.Dir, l.Name)
+	}
+	if options.AccessLog != nil {
+		options.AccessLog = options.AccessLog.With("listener", l.Name)
+	}
+	return options
+}
+
+// listen opens the listener's socket, replacing a unix socket left behind by a previous run
+func (l wafListener) listen() (net.Listener, error) {
+	path, ok := l.socketPath()
+	if !ok {
+		return net.Listen("tcp", ":"+l.Address)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return net.Listen("unix", path)
+}
+
+// validateWAFListeners ensures every listener has a unique name and address
+func validateWAFListeners(listeners []wafListener, wafPort string, adminPort string) error {
+	names := map[string]bool{}
+	addresses := map[string]bool{wafPort: true, adminPort: true}
+	for _, l := range listeners {
+		if !listenerNamePattern.MatchString(l.Name) {
+			return fmt.Errorf("WAF_LISTENERS names must be lowercase letters, digits and underscores, got %q", l.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("WAF_LISTENERS has more than one listener named %q", l.Name)
+		}
+		names[l.Name] = true
+		if addresses[l.Address] {
+			return fmt.Errorf("WAF_LISTENERS listener %q reuses address %s", l.Name, l.Address)
+		}
+		addresses[l.Address] = true
+		if path, ok := l.socketPath(); ok {
+			if err := validateWritableDir(filepath.Dir(path)); err != nil {
+				return fmt.Errorf("WAF_LISTENERS listener %q: %w", l.Name, err)
+			}
+		} else if err := validatePort(l.Address); err != nil {
+			return fmt.Errorf("WAF_LISTENERS listener %q: %w", l.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+
+// StreamOptions configures where and how a log stream is written
+type StreamOptions struct {
+	// Output is stdout, stderr, file:<path>, or a tcp:<host:port>, udp:<host:port> or unix:<path> socket
+	Output string
+	// Format is json or text
+	Format string
+	Level  slog.Leveler
+}
+
+// ValidateOutput checks the syntax of a stream output without opening it
+func ValidateOutput(output string) error {
+	switch output {
+	case "stdout", "stderr":
+		return nil
+	}
+	scheme, target, ok := strings.Cut(output, ":")
+	if !ok || target == "" {
+		return fmt.Errorf("unknown log output %q, expected stdout, stderr, file:<path>, tcp:<address>, udp:<address> or unix:<path>", output)
+	}
+	switch scheme {
+	case "file", "tcp", "udp", "unix":
+		return nil
+	}
+	return fmt.Errorf("unknown log output scheme %q", scheme)
+}
+
+// ValidateFormat checks that a stream format is supported
+func ValidateFormat(format string) error {
+	if format != "json" && format != "text" {
+		return fmt.Errorf("unknown log format %q, expected json or text", format)
+	}
+	return nil
+}
+
+// NewLogger opens the stream's output and returns a logger writing to it
+func NewLogger(options StreamOptions) (*slog.Logger, error) {
+	w, err := open(options.Output)
+	if err != nil {
+		return nil, err
+	}
+	handlerOptions := &slog.HandlerOptions{Level: options.Level}
+	if options.Format == "text" {
+		return slog.New(slog.NewTextHandler(w, handlerOptions)), nil
+	}
+	return slog.New(slog.NewJSONHandler(w, handlerOptions)), nil
+}
+
+type requestKey struct{}
+
+// WithRequest attaches the request being served to ctx, so handlers such as an error tracker can report it along
+// with the records logged with ctx
+func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// RequestFrom returns the request attached by WithRequest, nil if there is none
+func RequestFrom(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+func open(output string) (io.Writer, error) {
+	switch output {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	if err := ValidateOutput(output); err != nil {
+		return nil, err
+	}
+
+	scheme, target, _ := strings.Cut(output, ":")
+	if scheme == "file" {
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		return f, nil
+	}
+	return &socketWriter{network: scheme, address: target}, nil
+}
+
+// socketWriter writes each log line to a socket, redialing after a failed write. Lines are dropped while the
+// socket is unreachable so logging never blocks requests for longer than the dial timeout.
+type socketWriter struct {
+	network string
+	address string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *socketWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, dialTimeout)
+		if err != nil {
+			return 0, err
+		}
+		w.conn = conn
+	}
+	n, err := w.conn.Write(p)
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	return n, err
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOutput(t *testing.T) {
+	for _, output := range []string{"stdout", "stderr", "file:/var/log/waf.log", "tcp:localhost:5170", "udp:10.0.0.1:514", "unix:/run/fluent.sock"} {
+		assert.NoError(t, ValidateOutput(output), output)
+	}
+	for _, output := range []string{"", "syslog", "file:", "http://example.com"} {
+		assert.Error(t, ValidateOutput(output), output)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	t.Run("Should write text to a file at the stream's level", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "access.log")
+		logger, err := NewLogger(StreamOptions{Output: "file:" + path, Format: "text", Level: slog.LevelInfo})
+		require.NoError(t, err)
+
+		logger.Debug("Filtered")
+		logger.Info("HTTP request", "status", 200)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "Filtered")
+		assert.Contains(t, string(content), `msg="HTTP request" status=200`)
+	})
+
+	t.Run("Should write JSON to a socket", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		lines := make(chan string, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			lines <- line
+		}()
+
+		logger, err := NewLogger(StreamOptions{Output: "tcp:" + listener.Addr().String(), Format: "json", Level: slog.LevelInfo})
+		require.NoError(t, err)
+		logger.Warn("Rule violations", "id", "abc")
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(<-lines)), &record))
+		assert.Equal(t, "Rule violations", record["msg"])
+		assert.Equal(t, "abc", record["id"])
+	})
+}
+
+func TestWithRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/search", nil)
+	assert.Same(t, r, RequestFrom(WithRequest(context.Background(), r)))
+	assert.Nil(t, RequestFrom(context.Background()))
+}
@@ -0,0 +1,101 @@
+package loglevel
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// State is the current log level and when it reverts to the configured level
+type State struct {
+	Level      string     `json:"level"`
+	Configured string     `json:"configured"`
+	RevertsAt  *time.Time `json:"reverts_at,omitempty"`
+}
+
+// Controller changes the application log level at runtime. Changed levels revert to the configured level
+// after a while so a forgotten debug level cannot flood the logs.
+type Controller struct {
+	configured  slog.Level
+	revertAfter time.Duration
+	level       slog.LevelVar
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	revertsAt time.Time
+}
+
+// New creates a controller at the configured level. Changed levels revert after revertAfter unless a
+// different duration is given; zero keeps them until reset.
+func New(configured slog.Level, revertAfter time.Duration) *Controller {
+	c := &Controller{configured: configured, revertAfter: revertAfter}
+	c.level.Set(configured)
+	return c
+}
+
+// Leveler is the level to configure the slog handler with
+func (c *Controller) Leveler() slog.Leveler {
+	return &c.level
+}
+
+// Set changes the log level until duration has passed. A zero duration uses the default revert duration.
+func (c *Controller) Set(level slog.Level, duration time.Duration) {
+	if duration == 0 {
+		duration = c.revertAfter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopTimer()
+	c.level.Set(level)
+	if duration > 0 && level != c.configured {
+		c.revertsAt = time.Now().Add(duration)
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() { c.revert(timer) })
+		c.timer = timer
+	}
+	slog.Warn("Log level changed", "level", level, "reverts_after", duration)
+}
+
+// Reset restores the configured log level
+func (c *Controller) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// revert resets the level unless the timer was replaced by a later change
+func (c *Controller) revert(timer *time.Timer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == timer {
+		c.reset()
+	}
+}
+
+func (c *Controller) reset() {
+	c.stopTimer()
+	if c.level.Level() != c.configured {
+		c.level.Set(c.configured)
+		slog.Warn("Log level reverted", "level", c.configured)
+	}
+}
+
+// State reports the current log level
+func (c *Controller) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := State{Level: c.level.Level().String(), Configured: c.configured.String()}
+	if c.timer != nil {
+		revertsAt := c.revertsAt
+		state.RevertsAt = &revertsAt
+	}
+	return state
+}
+
+func (c *Controller) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
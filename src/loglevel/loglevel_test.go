@@ -0,0 +1,50 @@
+package loglevel
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestController(t *testing.T) {
+	t.Run("Should start at the configured level", func(t *testing.T) {
+		c := New(slog.LevelInfo, time.Minute)
+		assert.Equal(t, slog.LevelInfo, c.Leveler().Level())
+		assert.Nil(t, c.State().RevertsAt)
+	})
+
+	t.Run("Should revert after the duration", func(t *testing.T) {
+		c := New(slog.LevelInfo, time.Minute)
+		c.Set(slog.LevelDebug, 20*time.Millisecond)
+		assert.Equal(t, slog.LevelDebug, c.Leveler().Level())
+		assert.NotNil(t, c.State().RevertsAt)
+
+		assert.Eventually(t, func() bool {
+			return c.Leveler().Level() == slog.LevelInfo
+		}, time.Second, 5*time.Millisecond)
+		assert.Nil(t, c.State().RevertsAt)
+	})
+
+	t.Run("Should use the default revert duration", func(t *testing.T) {
+		c := New(slog.LevelInfo, time.Hour)
+		c.Set(slog.LevelDebug, 0)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *c.State().RevertsAt, time.Second)
+	})
+
+	t.Run("Should keep the level when reverting is disabled", func(t *testing.T) {
+		c := New(slog.LevelInfo, 0)
+		c.Set(slog.LevelDebug, 0)
+		assert.Nil(t, c.State().RevertsAt)
+		assert.Equal(t, "DEBUG", c.State().Level)
+	})
+
+	t.Run("Should reset to the configured level", func(t *testing.T) {
+		c := New(slog.LevelWarn, time.Hour)
+		c.Set(slog.LevelDebug, 0)
+		c.Reset()
+		assert.Equal(t, slog.LevelWarn, c.Leveler().Level())
+		assert.Nil(t, c.State().RevertsAt)
+	})
+}
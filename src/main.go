@@ -2,95 +2,749 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/admin"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/adminaudit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/alert"
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/changes"
 	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/geoip"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/leader"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/listener"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/mirror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/nats"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/oidc"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rdns"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/readiness"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/replay"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/report"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/sentry"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+	"github.com/corazawaf/coraza-coreruleset/v4/tests"
 )
 
 var (
-	expirationStr            = getEnvOrDefault("AUDIT_LOG_EXPIRATION", "24h")
-	expirationJobIntervalStr = getEnvOrDefault("AUDIT_LOG_EXPIRATION_JOB_INTERVAL", "1h")
-	processingJobIntervalStr = getEnvOrDefault("AUDIT_LOG_PROCESSING_JOB_INTERVAL", "10s")
-	auditLogPath             = getEnvOrDefault("AUDIT_LOG_PATH", "/var/log/coraza-audit.log")
-	logLevel                 = getEnvOrDefault("LOG_LEVEL", "info")
-	wafPort                  = getEnvOrDefault("WAF_PORT", "8080")
-	adminPort                = getEnvOrDefault("ADMIN_PORT", "8081")
+	dryRun          = flag.Bool("dry-run", false, "Validate the configuration, print the startup report and exit")
+	verifyAuditLogs = flag.Bool("verify-audit-logs", false, "Verify the signatures of the rotated audit log backups and exit")
+	verifyAdminLog  = flag.Bool("verify-admin-audit-log", false, "Verify the hash chain of the admin audit log and exit")
+	decryptAuditLog = flag.String("decrypt-audit-log", "", "Write the plaintext of an encrypted audit log backup to stdout and exit")
+	replayFile      = flag.String("replay", "", "Replay the requests of a HAR file or request list through the WAF, print the decisions and exit")
+	evalFile        = flag.String("eval", "", "Evaluate the raw HTTP request in a file, or - for the standard input, print the decision as JSON and exit with 0 when allowed and 2 when denied")
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: getLogLevel(),
-	}))
+	flag.Parse()
+
+	cfg, configErr := loadConfig()
+
+	levels := loglevel.New(cfg.LogLevel, cfg.LogLevelRevertAfter)
+	cfg.Log.Level = levels.Leveler()
+	logger, err := logging.NewLogger(cfg.Log)
+	if err != nil {
+		// Fall back to stdout so the startup report below explains the invalid setting
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: levels.Leveler()}))
+		logger.Error("Failed to open application log", "error", err, "output", cfg.Log.Output)
+	}
 	slog.SetDefault(logger)
+	errorReporter := newErrorReporter(cfg.Sentry, logger)
+	handleLogLevelSignals(levels)
+
+	if *verifyAuditLogs {
+		os.Exit(verifyAuditLogBackups(cfg.AuditLogProcessor))
+	}
+	if *verifyAdminLog {
+		os.Exit(verifyAdminAuditLog(cfg.AdminAuditLogPath, cfg.AdminAuditLogKey))
+	}
+	if *decryptAuditLog != "" {
+		os.Exit(decryptAuditLogBackup(*decryptAuditLog, cfg.AuditLogProcessor.EncryptionKey))
+	}
+
+	// Validate the configuration before starting anything
+	report := validateConfig(cfg, configErr, *dryRun)
+	if !report.Valid {
+		slog.Info("Startup report", "valid", report.Valid, "checks", report.Checks)
+		slog.Error("Configuration is invalid, exiting")
+		os.Exit(1)
+	}
+	if *dryRun {
+		slog.Info("Startup report", "valid", report.Valid, "checks", report.Checks)
+		slog.Info("Dry run complete, exiting")
+		return
+	}
+	if *replayFile != "" {
+		os.Exit(replayRequests(*replayFile, cfg))
+	}
+	if *evalFile != "" {
+		os.Exit(evalRequest(*evalFile, cfg))
+	}
+
+	accessLog := openLogStream("access", cfg.AccessLog)
+	securityLog := openLogStream("security", cfg.SecurityLog)
 
 	// Process audit logs in the background
-	processor := audit.NewLogProcessor(auditLogProcessorOptions())
+	summarizer := audit.NewSummarizer(slog.Default())
+	heatmap := audit.NewRuleHeatmap()
+	scripts := loadScript(cfg.Script)
+	cfg.WAFHandler.Script = scripts
+	transport := newOutboundTransport(cfg.Outbound)
+	cfg.Loki.Transport = transport
+	cfg.CloudWatch.Transport = transport
+	cfg.GoogleCloud.Transport = transport
+	cfg.Alert.Transport = transport
+	cfg.AuditLogProcessor.Sinks = auditSinks(cfg, securityLog, summarizer, heatmap, scripts)
+	objectStore := openObjectStore(cfg.StorePath)
+	cfg.WAFHandler.Objects = objectStore
+	eventStore := openEventStore(cfg.Events)
+	if eventStore != nil {
+		cfg.AuditLogProcessor.Sinks = append(cfg.AuditLogProcessor.Sinks, eventStore)
+	}
+	if cfg.NATS.URL != "" {
+		cfg.NATS.TLSConfig = transport.TLSClientConfig
+		publisher := nats.New(cfg.NATS)
+		cfg.AuditLogProcessor.Sinks = append(cfg.AuditLogProcessor.Sinks, publisher)
+		cfg.WAFHandler.OnDecision = publisher.PublishDecision
+	}
+	elector := newLeaderElector(cfg.Leader)
+	go elector.Start()
+	cfg.Report.Leader = elector.IsLeader
+	reportCollector, reportJob := newReportJob(cfg.Report)
+	if reportJob != nil {
+		cfg.AuditLogProcessor.Sinks = append(cfg.AuditLogProcessor.Sinks, reportCollector)
+		cfg.WAFHandler.OnDecision = observeDecisions(cfg.WAFHandler.OnDecision, reportCollector.Observe)
+		go reportJob.Start()
+	}
+	captures := openCaptureStore(cfg.Capture)
+	cfg.AuditLogProcessor.DiskGuard.OnPressure = func(underPressure bool) {
+		// Snapshots hold request bodies and are usually written to the same disk
+		if captures != nil {
+			captures.Pause(underPressure)
+		}
+	}
+	cfg.AuditLogProcessor.Leader = elector.IsLeader
+	if cfg.ReverseDNSEnabled {
+		// Requests start the lookups in the background so they are usually cached once their audit logs are processed
+		resolver := rdns.New(cfg.ReverseDNS)
+		cfg.WAFHandler.VerifiedCrawlers.Resolver = resolver
+		cfg.AuditLogProcessor.ResolveClient = func(ip string) (string, string) {
+			result := resolver.Resolve(ip)
+			return result.Hostname, result.Crawler
+		}
+	}
+	processor := audit.NewLogProcessor(cfg.AuditLogProcessor)
+	metrics.RegisterProcessMetrics(processor.DiskUsage)
 	go processor.StartProcessingJob()
 	go processor.StartExpirationJob()
+	go processor.StartDiskGuardJob()
+	go summarizer.StartReportJob(cfg.SummaryJobInterval, cfg.SummaryWindows, cfg.SummaryTopN, elector.IsLeader)
 
-	// Start the servers
-	wafHandler := coraza.NewCorazaWAFHandler(processor)
-	adminHandler := admin.NewAdminHandler()
-	wafServer, adminServer := runServersInBackground(wafHandler, adminHandler)
+	bans := ban.New()
+	cfg.WAFHandler.Bans = bans
+	var tenants *middleware.TenantAccounting
+	if cfg.Tenants.Header != "" {
+		tenants = middleware.NewTenantAccounting(cfg.Tenants)
+		if err := tenants.Load(); err != nil {
+			slog.Error("Failed to load tenant usage", "error", err, "path", cfg.Tenants.UsagePath)
+			os.Exit(1)
+		}
+		cfg.WAFHandler.Tenants = tenants
+		go tenants.Start()
+	}
+	if cfg.ASNDatabase != "" {
+		cfg.WAFHandler.ASNPolicies = middleware.NewASNPolicies(openASNDatabase(cfg.ASNDatabase), cfg.ASNPolicies)
+	}
+	if len(cfg.Webhooks.Endpoints) > 0 {
+		cfg.WAFHandler.Webhooks = middleware.NewWebhookVerifier(cfg.Webhooks)
+	}
+	var intel *threatintel.Client
+	if len(cfg.ThreatIntel.Feeds) > 0 {
+		cfg.ThreatIntel.Transport = transport
+		intel = threatintel.New(cfg.ThreatIntel)
+		cfg.WAFHandler.ThreatIntel.Matcher = intel
+		go intel.Start()
+	}
+	debug := coraza.NewDebugCapture(cfg.Debug)
+	cfg.WAFHandler.Debug = debug
+	cfg.WAFHandler.Capture = captures
+	cfg.WAFHandler.AccessLog = accessLog
+	cfg.WAFHandler.DecisionWebhook.Transport = transport
+	cfg.WAFHandler.OPA.Transport = transport
+	cfg.WAFHandler.Scoring.Transport = transport
+	cfg.Mirror.Transport = transport
+	cfg.OIDC.Transport = transport
+	requestMirror := mirror.New(cfg.Mirror)
+
+	// Build the handlers while the servers are already listening. Both servers answer 503 until every step is done.
+	var (
+		wafHandler           *coraza.WAFHandler
+		wafFront, adminFront *readiness.Handler
+		startup              *orchestrator
+	)
+	listenerFronts := make([]*readiness.Handler, len(cfg.WAFListeners))
+	steps := []startupStep{{name: "waf", run: func() error {
+		wafHandler = coraza.NewCorazaWAFHandler(processor, cfg.WAFHandler)
+		report.addWarmup(wafHandler.Warmup())
+		slog.Info("Startup report", "valid", report.Valid, "checks", report.Checks)
+		wafFront.Set(requestMirror.Handler(wafHandler))
+		return nil
+	}}}
+	for i, l := range cfg.WAFListeners {
+		steps = append(steps, startupStep{name: "waf_" + l.Name, run: func() error {
+			listenerFronts[i].Set(requestMirror.Handler(coraza.NewCorazaWAFHandler(processor, l.handlerOptions(cfg.WAFHandler))))
+			return nil
+		}})
+	}
+	steps = append(steps,
+		startupStep{name: "admin", run: func() error {
+			adminHandler, err := newAdminHandler(cfg, wafHandler, admin.AdminHandlerOptions{
+				Summarizer:   summarizer,
+				Heatmap:      heatmap,
+				Bans:         bans,
+				Debug:        debug,
+				Capture:      captures,
+				Events:       eventStore,
+				Store:        objectStore,
+				LogLevel:     levels,
+				AccessLog:    accessLog,
+				HealthChecks: startup.gate.Checks,
+			})
+			if err != nil {
+				return err
+			}
+			adminFront.Set(adminHandler)
+			return nil
+		}},
+	)
+	startup = newOrchestrator(steps...)
+	startup.gate.AddCheck("audit_log_writable", processor.CheckWritable)
+	// The steps only run once the servers are listening, by which time the front handlers have been assigned
+	wafFront, adminFront = readiness.NewHandler(startup.gate), readiness.NewHandler(startup.gate)
+	listenerHandlers := make([]http.Handler, len(listenerFronts))
+	for i := range listenerFronts {
+		listenerFronts[i] = readiness.NewHandler(startup.gate)
+		listenerHandlers[i] = listenerFronts[i]
+	}
+	wafServers, adminServer, sockets := runServersInBackground(cfg, wafFront, listenerHandlers, startupAdminHandler(startup.gate, adminFront))
+	go func() {
+		startup.run()
+		if err := listener.NotifyReady(); err != nil {
+			slog.Error("Failed to signal readiness", "error", err)
+		}
+	}()
+	handleUpgradeSignal(sockets)
 
 	// Handle graceful shutdown
-	handleShutdown(wafServer, adminServer, processor)
+	handleShutdown(wafServers, adminServer, processor, summarizer, requestMirror, reportJob, intel, tenants, elector, errorReporter)
+}
+
+// newErrorReporter reports the errors logged to the application log to Sentry, returning nil when no DSN is set
+func newErrorReporter(options sentry.Options, logger *slog.Logger) *sentry.Client {
+	if options.DSN == "" {
+		return nil
+	}
+	client, err := sentry.New(options)
+	if err != nil {
+		// The startup report explains the invalid DSN
+		return nil
+	}
+	slog.SetDefault(slog.New(sentry.NewHandler(logger.Handler(), client)))
+	return client
+}
+
+// verifyAuditLogBackups prints the verification of each signed audit log backup, returning the exit code
+func verifyAuditLogBackups(options audit.AuditLogProcessorOptions) int {
+	// Each replica signs its own chain of backups
+	paths := []string{options.AuditLogPath}
+	if options.Instance != "" {
+		var err error
+		if paths, err = audit.ReplicaLogPaths(options.AuditLogPath); err != nil {
+			slog.Error("Failed to verify audit log backups", "error", err)
+			return 1
+		}
+	}
+	var results []audit.BackupVerification
+	for _, path := range paths {
+		verified, err := audit.VerifyBackups(path, options.SigningKey)
+		if err != nil {
+			slog.Error("Failed to verify audit log backups", "error", err, "path", path)
+			return 1
+		}
+		results = append(results, verified...)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.OK {
+			fmt.Printf("OK    %s\n", result.File)
+			continue
+		}
+		fmt.Printf("FAIL  %s: %s\n", result.File, result.Error)
+		failed++
+	}
+	fmt.Printf("%d backups verified, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyAdminAuditLog prints the verification of the admin audit log chain, returning the exit code
+func verifyAdminAuditLog(path string, key []byte) int {
+	if path == "" {
+		slog.Error("ADMIN_AUDIT_LOG_PATH is required to verify the admin audit log")
+		return 1
+	}
+	verified, err := adminaudit.Verify(path, key)
+	if err != nil {
+		fmt.Printf("FAIL  %s: %s\n", path, err)
+		fmt.Printf("%d entries verified before the failure\n", verified)
+		return 1
+	}
+	fmt.Printf("OK    %s\n", path)
+	fmt.Printf("%d entries verified\n", verified)
+	return 0
+}
+
+// decryptAuditLogBackup writes the plaintext of an encrypted audit log backup to stdout, returning the exit code
+func decryptAuditLogBackup(backupPath string, key []byte) int {
+	if len(key) == 0 {
+		slog.Error("AUDIT_LOG_ENCRYPTION_KEY is required to decrypt audit log backups")
+		return 1
+	}
+	plaintext, err := audit.DecryptBackup(backupPath, key)
+	if err != nil {
+		slog.Error("Failed to decrypt audit log backup", "error", err, "file", backupPath)
+		return 1
+	}
+	if _, err := os.Stdout.Write(plaintext); err != nil {
+		slog.Error("Failed to write decrypted audit log backup", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// replayRequests prints the WAF's decision on each request of a HAR file or request list, returning the exit code
+func replayRequests(path string, cfg config) int {
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Error("Failed to open replay file", "error", err)
+		return 1
+	}
+	defer file.Close()
+	requests, err := replay.Parse(file)
+	if err != nil {
+		slog.Error("Failed to parse replay file", "error", err, "file", path)
+		return 1
+	}
+
+	report := replay.Run(newOfflineWAFHandler(cfg), requests)
+	for _, result := range report.Results {
+		switch {
+		case result.Error != "":
+			fmt.Printf("ERROR     %s %s: %s\n", result.Method, result.URL, result.Error)
+		case result.Allowed:
+			fmt.Printf("ALLOW %d %s %s%s\n", result.Status, result.Method, result.URL, formatRules(result))
+		default:
+			fmt.Printf("DENY  %d %s %s%s\n", result.Status, result.Method, result.URL, formatRules(result))
+		}
+	}
+	fmt.Printf("%d requests replayed, %d denied\n", report.Total, report.Denied)
+	return 0
 }
 
-func getEnvOrDefault(envVar string, defaultValue string) string {
-	if value := os.Getenv(envVar); value != "" {
-		return value
+// evalRequest prints the WAF's decision on a raw HTTP request as JSON, returning 0 when it is allowed, 2 when it is
+// denied and 1 when it cannot be evaluated
+func evalRequest(path string, cfg config) int {
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			slog.Error("Failed to open request file", "error", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+	request, err := replay.ParseRaw(input)
+	if err != nil {
+		slog.Error("Failed to parse request", "error", err, "file", path)
+		return 1
+	}
+
+	result := replay.Run(newOfflineWAFHandler(cfg), []replay.Request{request}).Results[0]
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+	switch {
+	case result.Error != "":
+		return 1
+	case result.Allowed:
+		return 0
+	default:
+		return 2
+	}
+}
+
+// newOfflineWAFHandler builds the WAF handler of the configuration for the command line modes. The audit log is kept
+// in memory, and the external authorizers are not consulted.
+func newOfflineWAFHandler(cfg config) http.Handler {
+	cfg.AuditLogProcessor.Storage = audit.StorageMemory
+	cfg.WAFHandler.Script = loadScript(cfg.Script)
+	cfg.WAFHandler.OPA.URL = ""
+	cfg.WAFHandler.DecisionWebhook.URL = ""
+	cfg.WAFHandler.Scoring.URL = ""
+	return coraza.NewCorazaWAFHandler(audit.NewLogProcessor(cfg.AuditLogProcessor), cfg.WAFHandler)
+}
+
+// formatRules lists the rules a replayed request matched, and the one that denied it
+func formatRules(result replay.Result) string {
+	if len(result.RuleIDs) == 0 {
+		return ""
+	}
+	ids := make([]string, len(result.RuleIDs))
+	for i, id := range result.RuleIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	if result.InterruptedBy != 0 {
+		return fmt.Sprintf(" (denied by rule %d, matched %s)", result.InterruptedBy, strings.Join(ids, ","))
 	}
-	return defaultValue
+	return " (matched " + strings.Join(ids, ",") + ")"
 }
 
-func runServersInBackground(wafHandler http.Handler, adminHandler http.Handler) (wafServer *http.Server, adminServer *http.Server) {
+// newAdminHandler opens the admin API's persistent state and builds its handler
+func newAdminHandler(cfg config, wafHandler *coraza.WAFHandler, options admin.AdminHandlerOptions) (http.Handler, error) {
+	changeTrail, err := changes.Open(cfg.ChangeLogPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change log %s: %w", cfg.ChangeLogPath, err)
+	}
+
+	calls, err := adminaudit.Open(cfg.AdminAuditLogPath, cfg.AdminAuditLogKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open admin audit log %s: %w", cfg.AdminAuditLogPath, err)
+	}
+
+	options.Changes = changeTrail
+	options.Calls = calls
+	options.Directives = wafHandler
+	options.Rules = wafHandler
+	options.Engine = wafHandler
+	options.ASNPolicies = cfg.WAFHandler.ASNPolicies
+	options.ThreatIntel = cfg.WAFHandler.ThreatIntel.Matcher
+	options.FailurePolicy = cfg.WAFHandler.FailurePolicy
+	options.WAFHandler = wafHandler
+	options.FTWTests = ftwTests(cfg)
+	options.Config = cfg.settings()
+	options.Policy = cfg.AdminRoutePolicy
+	options.AnonymousRole = cfg.AdminAnonymousRole
+	options.RateLimit = cfg.AdminRateLimit
+	if cfg.OIDC.IssuerURL != "" {
+		options.Auth = oidc.New(cfg.OIDC)
+	} else {
+		slog.Warn("OIDC_ISSUER_URL is not set, the admin API is not authenticated", "role", cfg.AdminAnonymousRole.String())
+	}
+	return admin.NewAdminHandler(options), nil
+}
+
+// startupAdminHandler serves the health and readiness probes while the admin handler is still being built
+func startupAdminHandler(gate *readiness.Gate, adminHandler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", admin.HealthHandler(gate.Checks))
+	mux.Handle("GET /ready", gate.StatusHandler())
+	mux.Handle("/", adminHandler)
+	return mux
+}
+
+// openLogStream opens a separate log stream, sharing the application log when no output is configured
+func openLogStream(name string, options logging.StreamOptions) *slog.Logger {
+	if options.Output == "" {
+		return slog.Default()
+	}
+	logger, err := logging.NewLogger(options)
+	if err != nil {
+		slog.Error("Failed to open log stream", "stream", name, "error", err, "output", options.Output)
+		os.Exit(1)
+	}
+	return logger
+}
+
+// auditSinks builds the outputs that processed audit logs are sent to
+func auditSinks(cfg config, securityLog *slog.Logger, summarizer *audit.Summarizer, heatmap *audit.RuleHeatmap, scripts *script.Engine) []audit.Sink {
+	var logSink audit.Sink = audit.NewLogSink(securityLog)
+	if cfg.LogSinkAggregationWindow > 0 {
+		logSink = audit.NewAggregatingSink(logSink, cfg.LogSinkAggregationWindow)
+	}
+
+	sinks := []audit.Sink{logSink, summarizer, heatmap}
+	if scripts != nil && scripts.Has(script.HookAudit) {
+		sinks = append(sinks, scripts)
+	}
+	if cfg.Loki.URL != "" {
+		sinks = append(sinks, audit.NewLokiSink(cfg.Loki))
+	}
+	if cfg.Fluent.Address != "" {
+		sinks = append(sinks, audit.NewFluentSink(cfg.Fluent))
+	}
+	if cfg.CloudWatch.LogGroup != "" {
+		sinks = append(sinks, audit.NewCloudWatchSink(cfg.CloudWatch))
+	}
+	if cfg.GoogleCloud.LogName != "" {
+		sinks = append(sinks, audit.NewCloudLoggingSink(cfg.GoogleCloud))
+	}
+	if cfg.GoogleCloud.Topic != "" {
+		sinks = append(sinks, audit.NewPubSubSink(cfg.GoogleCloud))
+	}
+	if cfg.Alert.Path != "" {
+		engine, err := alert.New(cfg.Alert)
+		if err != nil {
+			slog.Error("Failed to load alert rules", "error", err, "path", cfg.Alert.Path)
+			os.Exit(1)
+		}
+		sinks = append(sinks, engine)
+	}
+	return sinks
+}
+
+// newLeaderElector returns the elector of the replica running the cluster-wide jobs, which is always the leader
+// without a backend
+func newLeaderElector(options leader.Options) *leader.Elector {
+	elector, err := leader.New(options)
+	if err != nil {
+		slog.Error("Failed to set up leader election", "error", err, "backend", options.Backend)
+		os.Exit(1)
+	}
+	return elector
+}
+
+// newReportJob returns the collector and job of the scheduled report, or nils when no schedule is set
+func newReportJob(options report.Options) (*report.Collector, *report.Job) {
+	if options.Schedule == "" {
+		return nil, nil
+	}
+	collector := report.NewCollector(options.Period())
+	return collector, report.NewJob(options, collector)
+}
+
+// observeDecisions combines the decision observers, skipping nil ones
+func observeDecisions(observers ...coraza.DecisionObserver) coraza.DecisionObserver {
+	var active []coraza.DecisionObserver
+	for _, observer := range observers {
+		if observer != nil {
+			active = append(active, observer)
+		}
+	}
+	return func(decision coraza.Decision) {
+		for _, observer := range active {
+			observer(decision)
+		}
+	}
+}
+
+// openCaptureStore opens the request capture store, returning nil when no directory is configured
+func openCaptureStore(options capture.Options) *capture.Store {
+	if options.Dir == "" {
+		return nil
+	}
+	captures, err := capture.Open(options)
+	if err != nil {
+		slog.Error("Failed to open capture store", "error", err, "dir", options.Dir)
+		os.Exit(1)
+	}
+	return captures
+}
+
+// openObjectStore opens the store of the objects managed through the admin API, which the WAF enforces
+func openObjectStore(path string) *store.Store {
+	objectStore, err := store.New(path)
+	if err != nil {
+		slog.Error("Failed to open store", "error", err, "path", path)
+		os.Exit(1)
+	}
+	if path == "" {
+		slog.Warn("STORE_PATH is not set, admin API objects will not survive restarts")
+	}
+	return objectStore
+}
+
+// openEventStore opens the event store, returning nil when no directory is configured
+func openEventStore(options events.Options) *events.Store {
+	if options.Dir == "" {
+		return nil
+	}
+	eventStore, err := events.Open(options)
+	if err != nil {
+		slog.Error("Failed to open event store", "error", err, "dir", options.Dir)
+		os.Exit(1)
+	}
+	return eventStore
+}
+
+// openASNDatabase reads the ASN database into memory
+func openASNDatabase(path string) *geoip.Reader {
+	reader, err := geoip.Open(path)
+	if err != nil {
+		slog.Error("Failed to open ASN database", "error", err, "path", path)
+		os.Exit(1)
+	}
+	metadata := reader.Metadata()
+	slog.Info("Loaded ASN database", "path", path, "type", metadata.DatabaseType, "built", time.Unix(int64(metadata.BuildEpoch), 0).UTC())
+	return reader
+}
+
+// loadScript loads the Lua hooks, returning nil when no script is configured
+func loadScript(options script.Options) *script.Engine {
+	if options.Path == "" {
+		return nil
+	}
+	engine, err := script.Load(options)
+	if err != nil {
+		slog.Error("Failed to load script", "error", err, "path", options.Path)
+		os.Exit(1)
+	}
+	return engine
+}
+
+// newOutboundTransport builds the transport shared by the mirror, the decision webhook, OPA and the remote audit sinks
+func newOutboundTransport(options outbound.Options) *http.Transport {
+	transport, err := outbound.NewTransport(options)
+	if err != nil {
+		slog.Error("Failed to build outbound transport", "error", err)
+		os.Exit(1)
+	}
+	return transport
+}
+
+// ftwTests returns the regression tests run by the FTW endpoint, defaulting to those bundled with the CRS
+func ftwTests(cfg config) fs.FS {
+	if cfg.FTWTestsDir != "" {
+		return os.DirFS(cfg.FTWTestsDir)
+	}
+	return tests.FS
+}
+
+func runServersInBackground(cfg config, wafHandler http.Handler, listenerHandlers []http.Handler, adminHandler http.Handler) (wafServers []*http.Server, adminServer *http.Server, sockets map[string]net.Listener) {
+	// Take over the sockets passed by systemd or by the process being upgraded, and open the others
+	sockets, err := listener.Activated()
+	if err != nil {
+		slog.Error("Failed to use the passed sockets", "error", err)
+		os.Exit(1)
+	}
+	if sockets == nil {
+		sockets = map[string]net.Listener{}
+	}
+	listen := func(name string, open func() (net.Listener, error)) net.Listener {
+		if socket, ok := sockets[name]; ok {
+			slog.Info("Using passed socket", "listener", name, "address", socket.Addr().String())
+			return socket
+		}
+		socket, err := open()
+		if err != nil {
+			slog.Error("Server failed to listen", "error", err, "listener", name)
+			os.Exit(1)
+		}
+		sockets[name] = socket
+		return socket
+	}
+
 	// Start the servers
-	wafServer = &http.Server{
-		Addr:              fmt.Sprintf(":%s", wafPort),
-		Handler:           wafHandler,
+	wafListener := listen("waf", func() (net.Listener, error) { return net.Listen("tcp", fmt.Sprintf(":%s", cfg.WAFPort)) })
+	wafServers = append(wafServers, serveWAF(wafListener, wafHandler, cfg.Guard, "port", cfg.WAFPort))
+	for i, l := range cfg.WAFListeners {
+		socket := listen(l.Name, l.listen)
+		wafServers = append(wafServers, serveWAF(socket, listenerHandlers[i], cfg.Guard, "listener", l.Name, "address", l.Address))
+	}
+
+	adminListener := listen("admin", func() (net.Listener, error) { return net.Listen("tcp", fmt.Sprintf(":%s", cfg.AdminPort)) })
+	adminServer = &http.Server{
+		Handler:           adminHandler,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
-	adminServer = &http.Server{
-		Addr:              fmt.Sprintf(":%s", adminPort),
-		Handler:           adminHandler,
+	go func() {
+		slog.Info("Starting admin server", "port", cfg.AdminPort)
+		if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Admin server failed to start", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	return wafServers, adminServer, sockets
+}
+
+// serveWAF serves the WAF handler on the socket in the background. TCP connections are guarded against slow
+// clients; unix sockets are only reachable from the host, so they are not.
+func serveWAF(socket net.Listener, handler http.Handler, guard listener.GuardOptions, logAttrs ...any) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	if _, ok := socket.Addr().(*net.TCPAddr); ok {
+		guardedListener := listener.NewGuardedListener(socket, guard)
+		server.ConnState = guardedListener.ConnState
+		server.ConnContext = guardedListener.ConnContext
+		server.Handler = guardedListener.Handler(handler)
+		socket = guardedListener
+	}
 
 	go func() {
-		slog.Info("Starting WAF server", "port", wafPort)
-		if err := wafServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("WAF server failed to start", "error", err)
+		slog.Info("Starting WAF server", logAttrs...)
+		if err := server.Serve(socket); err != nil && err != http.ErrServerClosed {
+			slog.Error("WAF server failed to start", append([]any{"error", err}, logAttrs...)...)
 			os.Exit(1)
 		}
 	}()
+	return server
+}
 
+// handleUpgradeSignal starts a new instance of the binary on SIGHUP and passes it the sockets. The new process stops
+// this one once it is ready, so connections are never refused while upgrading.
+func handleUpgradeSignal(sockets map[string]net.Listener) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
 	go func() {
-		slog.Info("Starting admin server", "port", adminPort)
-		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("Admin server failed to start", "error", err)
-			os.Exit(1)
+		for range signals {
+			process, err := listener.Upgrade(sockets)
+			if err != nil {
+				slog.Error("Upgrade failed", "error", err)
+				continue
+			}
+			slog.Info("Started upgraded process, waiting for it to take over", "pid", process.Pid)
+			go func() {
+				// Only returns before this process is stopped when the upgraded process fails to start
+				state, err := process.Wait()
+				slog.Error("Upgraded process exited", "pid", process.Pid, "state", state, "error", err)
+			}()
 		}
 	}()
-
-	return wafServer, adminServer
 }
 
-func handleShutdown(wafServer *http.Server, adminServer *http.Server, processor *audit.LogProcessor) {
+func handleShutdown(wafServers []*http.Server, adminServer *http.Server, processor *audit.LogProcessor, summarizer *audit.Summarizer, requestMirror *mirror.Mirror, reportJob *report.Job, intel *threatintel.Client, tenants *middleware.TenantAccounting, elector *leader.Elector, errorReporter *sentry.Client) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -100,9 +754,31 @@ func handleShutdown(wafServer *http.Server, adminServer *http.Server, processor
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	wafShutdownErr := wafServer.Shutdown(ctx)
+	var wafShutdownErrs []error
+	for _, wafServer := range wafServers {
+		wafShutdownErrs = append(wafShutdownErrs, wafServer.Shutdown(ctx))
+	}
+	wafShutdownErr := errors.Join(wafShutdownErrs...)
 	adminShutdownErr := adminServer.Shutdown(ctx)
+	mirrorErr := requestMirror.Stop(ctx)
 	processorErr := processor.Stop(ctx)
+	summarizerErr := summarizer.Stop(ctx)
+	var reportErr error
+	if reportJob != nil {
+		reportErr = reportJob.Stop(ctx)
+	}
+	var intelErr error
+	if intel != nil {
+		intelErr = intel.Stop(ctx)
+	}
+	var tenantsErr error
+	if tenants != nil {
+		tenantsErr = tenants.Stop(ctx)
+	}
+	// Release the lock once the jobs have stopped, so another replica takes them over right away
+	if err := elector.Stop(ctx); err != nil {
+		slog.Warn("Failed to release the leader lock", "error", err)
+	}
 
 	if wafShutdownErr != nil {
 		slog.Error("WAF server forced to shutdown", "error", wafShutdownErr)
@@ -110,63 +786,34 @@ func handleShutdown(wafServer *http.Server, adminServer *http.Server, processor
 	if adminShutdownErr != nil {
 		slog.Error("Admin server forced to shutdown", "error", adminShutdownErr)
 	}
+	if mirrorErr != nil {
+		slog.Error("Request mirror forced to shutdown", "error", mirrorErr)
+	}
 	if processorErr != nil {
 		slog.Error("Log processor forced to shutdown", "error", processorErr)
 	}
 
-	if wafShutdownErr != nil || adminShutdownErr != nil || processorErr != nil {
-		os.Exit(1)
+	if summarizerErr != nil {
+		slog.Error("Audit summary job forced to shutdown", "error", summarizerErr)
 	}
-
-	slog.Info("Applications exited gracefully")
-}
-
-func getLogLevel() slog.Level {
-	switch logLevel {
-	case "info":
-		return slog.LevelInfo
-	case "warn":
-		return slog.LevelWarn
-	case "error":
-		return slog.LevelError
-	case "debug":
-		return slog.LevelDebug
-	default:
-		return slog.LevelInfo
+	if reportErr != nil {
+		slog.Error("Report job forced to shutdown", "error", reportErr)
 	}
-}
-
-func auditLogProcessorOptions() audit.AuditLogProcessorOptions {
-	opts := audit.AuditLogProcessorOptions{
-		AuditLogPath: auditLogPath,
+	if intelErr != nil {
+		slog.Error("Threat intel job forced to shutdown", "error", intelErr)
 	}
-
-	if expirationStr != "" {
-		logExpiration, err := time.ParseDuration(expirationStr)
-		if err != nil {
-			slog.Error("Failed to parse log expiration duration", "error", err)
-			os.Exit(1)
-		}
-		opts.LogExpiration = logExpiration
+	if tenantsErr != nil {
+		slog.Error("Failed to save tenant usage", "error", tenantsErr)
 	}
-
-	if expirationJobIntervalStr != "" {
-		expirationJobInterval, err := time.ParseDuration(expirationJobIntervalStr)
-		if err != nil {
-			slog.Error("Failed to parse log expiration job interval", "error", err)
-			os.Exit(1)
+	if errorReporter != nil {
+		if err := errorReporter.Flush(ctx); err != nil {
+			slog.Warn("Failed to send queued events to Sentry", "error", err)
 		}
-		opts.ExpirationJobInterval = expirationJobInterval
 	}
 
-	if processingJobIntervalStr != "" {
-		processingJobInterval, err := time.ParseDuration(processingJobIntervalStr)
-		if err != nil {
-			slog.Error("Failed to parse log processing job interval", "error", err)
-			os.Exit(1)
-		}
-		opts.ProcessingJobInterval = processingJobInterval
+	if wafShutdownErr != nil || adminShutdownErr != nil || mirrorErr != nil || processorErr != nil || summarizerErr != nil || reportErr != nil || intelErr != nil || tenantsErr != nil {
+		os.Exit(1)
 	}
 
-	return opts
+	slog.Info("Applications exited gracefully")
 }
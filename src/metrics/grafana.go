@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	datasourceUID = "${DS_PROMETHEUS}"
+	rateWindow    = "5m"
+	panelWidth    = 12
+	panelHeight   = 8
+)
+
+// GrafanaDashboard generates a Grafana dashboard with one panel per registered metric
+func GrafanaDashboard() map[string]any {
+	definitions := Catalog()
+	panels := make([]map[string]any, 0, len(definitions))
+	for i, d := range definitions {
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       d.Name,
+			"description": d.Help,
+			"datasource":  map[string]any{"type": "prometheus", "uid": datasourceUID},
+			"gridPos": map[string]any{
+				"x": (i % 2) * panelWidth,
+				"y": (i / 2) * panelHeight,
+				"w": panelWidth,
+				"h": panelHeight,
+			},
+			"targets": []map[string]any{{
+				"refId":        "A",
+				"expr":         panelQuery(d),
+				"legendFormat": legendFormat(d),
+			}},
+		})
+	}
+
+	return map[string]any{
+		"title":         "Coraza Traefik Middleware",
+		"uid":           "coraza-traefik-middleware",
+		"schemaVersion": 39,
+		"time":          map[string]any{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]any{{
+				"name":  "DS_PROMETHEUS",
+				"type":  "datasource",
+				"query": "prometheus",
+			}},
+		},
+		"panels": panels,
+	}
+}
+
+// GrafanaAlertRules generates Grafana alert rule provisioning for metrics registered with an alert threshold
+func GrafanaAlertRules() map[string]any {
+	var rules []map[string]any
+	for _, d := range Catalog() {
+		if d.AlertAbove == nil {
+			continue
+		}
+		rules = append(rules, map[string]any{
+			"uid":       "coraza-" + strings.ReplaceAll(d.Name, "_", "-"),
+			"title":     fmt.Sprintf("%s above %g", d.Name, *d.AlertAbove),
+			"condition": "B",
+			"for":       rateWindow,
+			"annotations": map[string]any{
+				"summary": d.Help,
+			},
+			"data": []map[string]any{
+				{
+					"refId":             "A",
+					"datasourceUid":     datasourceUID,
+					"relativeTimeRange": map[string]any{"from": 600, "to": 0},
+					"model":             map[string]any{"refId": "A", "expr": alertQuery(d)},
+				},
+				{
+					"refId":         "B",
+					"datasourceUid": "__expr__",
+					"model": map[string]any{
+						"refId":      "B",
+						"type":       "threshold",
+						"expression": "A",
+						"conditions": []map[string]any{{
+							"evaluator": map[string]any{"type": "gt", "params": []float64{*d.AlertAbove}},
+						}},
+					},
+				},
+			},
+		})
+	}
+
+	return map[string]any{
+		"apiVersion": 1,
+		"groups": []map[string]any{{
+			"orgId":    1,
+			"name":     "coraza-traefik-middleware",
+			"folder":   "Coraza",
+			"interval": "1m",
+			"rules":    rules,
+		}},
+	}
+}
+
+func panelQuery(d Definition) string {
+	by := ""
+	if len(d.Labels) > 0 {
+		by = fmt.Sprintf(" by (%s)", d.Labels[0])
+	}
+
+	switch d.Kind {
+	case KindCounter:
+		return fmt.Sprintf("sum%s (rate(%s[%s]))", by, d.Name, rateWindow)
+	case KindHistogram:
+		return fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket[%s])))", d.Name, rateWindow)
+	default:
+		return fmt.Sprintf("sum%s (%s)", by, d.Name)
+	}
+}
+
+func alertQuery(d Definition) string {
+	if d.Kind == KindGauge {
+		return fmt.Sprintf("max(%s)", d.Name)
+	}
+	return fmt.Sprintf("sum(rate(%s[%s]))", d.Name, rateWindow)
+}
+
+func legendFormat(d Definition) string {
+	if len(d.Labels) == 0 || d.Kind == KindHistogram {
+		return d.Name
+	}
+	return fmt.Sprintf("{{%s}}", d.Labels[0])
+}
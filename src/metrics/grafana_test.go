@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ = NewCounterVec("test_requests_total", "Test requests", []string{"status"})
+	_ = NewGauge("test_queue_depth", "Test queue depth", WithAlertAbove(100))
+)
+
+func TestGrafanaDashboard(t *testing.T) {
+	dashboard := GrafanaDashboard()
+	panels := dashboard["panels"].([]map[string]any)
+
+	queries := map[string]string{}
+	for _, panel := range panels {
+		targets := panel["targets"].([]map[string]any)
+		queries[panel["title"].(string)] = targets[0]["expr"].(string)
+	}
+
+	assert.Len(t, panels, len(Catalog()), "Expected one panel per registered metric")
+	assert.Equal(t, "sum by (status) (rate(test_requests_total[5m]))", queries["test_requests_total"])
+	assert.Equal(t, "sum (test_queue_depth)", queries["test_queue_depth"])
+}
+
+func TestGrafanaAlertRules(t *testing.T) {
+	provisioning := GrafanaAlertRules()
+	groups := provisioning["groups"].([]map[string]any)
+	rules := groups[0]["rules"].([]map[string]any)
+
+	assert.Len(t, rules, 1, "Expected alert rules only for metrics with a threshold")
+	assert.Equal(t, "test_queue_depth above 100", rules[0]["title"])
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Kind is the Prometheus metric type
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// Definition describes a metric exposed by the service.
+// Every metric is registered through this package so that generated dashboards always match the code.
+type Definition struct {
+	Name   string   `json:"name"`
+	Help   string   `json:"help"`
+	Kind   Kind     `json:"kind"`
+	Labels []string `json:"labels,omitempty"`
+	// AlertAbove generates an alert rule firing when the per-second rate (or gauge value) exceeds it. Nil disables alerting.
+	AlertAbove *float64 `json:"alert_above,omitempty"`
+}
+
+// Option customizes a metric definition
+type Option func(*Definition)
+
+// WithAlertAbove generates an alert rule for the metric with the given threshold
+func WithAlertAbove(threshold float64) Option {
+	return func(d *Definition) {
+		d.AlertAbove = &threshold
+	}
+}
+
+var (
+	catalogMu sync.Mutex
+	catalog   = map[string]Definition{}
+)
+
+// Catalog returns every registered metric sorted by name
+func Catalog() []Definition {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	definitions := make([]Definition, 0, len(catalog))
+	for _, d := range catalog {
+		definitions = append(definitions, d)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+	return definitions
+}
+
+func record(name string, help string, kind Kind, labels []string, options []Option) {
+	d := Definition{Name: name, Help: help, Kind: kind, Labels: labels}
+	for _, option := range options {
+		option(&d)
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog[name] = d
+}
+
+func NewCounter(name string, help string, options ...Option) prometheus.Counter {
+	record(name, help, KindCounter, nil, options)
+	return promauto.NewCounter(prometheus.CounterOpts{Name: name, Help: help})
+}
+
+func NewCounterVec(name string, help string, labels []string, options ...Option) *prometheus.CounterVec {
+	record(name, help, KindCounter, labels, options)
+	return promauto.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+}
+
+func NewGauge(name string, help string, options ...Option) prometheus.Gauge {
+	record(name, help, KindGauge, nil, options)
+	return promauto.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+}
+
+func NewGaugeVec(name string, help string, labels []string, options ...Option) *prometheus.GaugeVec {
+	record(name, help, KindGauge, labels, options)
+	return promauto.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+}
+
+// NewGaugeFunc registers a gauge whose value is read from value when the metrics are collected
+func NewGaugeFunc(name string, help string, value func() float64, options ...Option) prometheus.GaugeFunc {
+	record(name, help, KindGauge, nil, options)
+	return promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, value)
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels []string, options ...Option) *prometheus.HistogramVec {
+	record(name, help, KindHistogram, labels, options)
+	return promauto.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+}
+
+// IncWithExemplar increments the counter, attaching the exemplar so a sample can be traced back to the
+// event that caused it. Exemplars are only exposed when /metrics is scraped in the OpenMetrics format.
+func IncWithExemplar(counter prometheus.Counter, exemplar prometheus.Labels) {
+	if adder, ok := counter.(prometheus.ExemplarAdder); ok {
+		adder.AddWithExemplar(1, exemplar)
+		return
+	}
+	counter.Inc()
+}
+
+// ObserveWithExemplar records the observation, attaching the exemplar when the observer supports it
+func ObserveWithExemplar(observer prometheus.Observer, value float64, exemplar prometheus.Labels) {
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+		exemplarObserver.ObserveWithExemplar(value, exemplar)
+		return
+	}
+	observer.Observe(value)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExemplars(t *testing.T) {
+	t.Run("Should attach exemplars to counters", func(t *testing.T) {
+		counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "test"})
+		IncWithExemplar(counter, prometheus.Labels{"transaction_id": "abc123"})
+
+		var m dto.Metric
+		require.NoError(t, counter.Write(&m))
+		assert.Equal(t, 1.0, m.GetCounter().GetValue())
+		assert.Equal(t, "abc123", m.GetCounter().GetExemplar().GetLabel()[0].GetValue())
+	})
+
+	t.Run("Should attach exemplars to histograms", func(t *testing.T) {
+		histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "test"})
+		ObserveWithExemplar(histogram, 0.2, prometheus.Labels{"transaction_id": "abc123"})
+
+		var m dto.Metric
+		require.NoError(t, histogram.Write(&m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+
+		found := false
+		for _, bucket := range m.GetHistogram().GetBucket() {
+			if bucket.GetExemplar() != nil {
+				found = true
+			}
+		}
+		assert.True(t, found)
+	})
+}
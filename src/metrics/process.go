@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+// heapInUseSamples are the runtime metrics adding up to the heap in use, as runtime.MemStats.HeapInuse, which is read
+// without stopping the world
+var heapInUseSamples = []string{"/memory/classes/heap/objects:bytes", "/memory/classes/heap/unused:bytes"}
+
+// RegisterProcessMetrics registers the waf_process_* gauges of the resources used by the process, read when the
+// metrics are collected. auditLogUsage returns the bytes used by the audit log on disk.
+func RegisterProcessMetrics(auditLogUsage func() (int64, error)) {
+	NewGaugeFunc("waf_process_goroutines", "Number of goroutines of the WAF process", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	NewGaugeFunc("waf_process_heap_inuse_bytes", "Bytes of heap spans in use by the WAF process", heapInUse)
+	NewGaugeFunc("waf_process_gc_pause_seconds", "Duration of the last garbage collection pause of the WAF process", lastGCPause)
+	if _, err := openFDs(); err == nil {
+		NewGaugeFunc("waf_process_open_fds", "Number of file descriptors open by the WAF process", func() float64 {
+			count, _ := openFDs()
+			return float64(count)
+		})
+	}
+	NewGaugeFunc("waf_process_audit_dir_bytes", "Bytes used by the audit log and its backups in the audit log directory", func() float64 {
+		usage, err := auditLogUsage()
+		if err != nil {
+			slog.Warn("Failed to measure audit log disk usage", "error", err)
+		}
+		return float64(usage)
+	})
+}
+
+func heapInUse() float64 {
+	samples := make([]metrics.Sample, len(heapInUseSamples))
+	for i, name := range heapInUseSamples {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	var total uint64
+	for _, sample := range samples {
+		if sample.Value.Kind() == metrics.KindUint64 {
+			total += sample.Value.Uint64()
+		}
+	}
+	return float64(total)
+}
+
+func lastGCPause() float64 {
+	var stats debug.GCStats
+	debug.ReadGCStats(&stats)
+	if len(stats.Pause) == 0 {
+		return 0
+	}
+	return stats.Pause[0].Seconds()
+}
@@ -0,0 +1,13 @@
+package metrics
+
+import "os"
+
+// openFDs counts the file descriptors open by the process
+func openFDs() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	// Reading the directory took a descriptor of its own
+	return len(entries) - 1, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package metrics
+
+import "errors"
+
+// openFDs is only implemented with /proc; elsewhere the gauge is not registered
+func openFDs() (int, error) {
+	return 0, errors.New("counting open file descriptors is only supported on Linux")
+}
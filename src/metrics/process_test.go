@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterProcessMetrics(t *testing.T) {
+	usage, usageErr := int64(4096), error(nil)
+	RegisterProcessMetrics(func() (int64, error) { return usage, usageErr })
+
+	gauges := func() map[string]float64 {
+		families, err := prometheus.DefaultGatherer.Gather()
+		require.NoError(t, err)
+		values := map[string]float64{}
+		for _, family := range families {
+			if len(family.GetMetric()) == 1 && family.GetMetric()[0].GetGauge() != nil {
+				values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+
+	runtime.GC()
+	values := gauges()
+	assert.Positive(t, values["waf_process_goroutines"])
+	assert.Positive(t, values["waf_process_heap_inuse_bytes"])
+	assert.Positive(t, values["waf_process_gc_pause_seconds"])
+	assert.Equal(t, 4096.0, values["waf_process_audit_dir_bytes"])
+
+	if runtime.GOOS == "linux" {
+		opened := values["waf_process_open_fds"]
+		assert.Positive(t, opened)
+		file, err := os.Open(os.Args[0])
+		require.NoError(t, err)
+		defer file.Close()
+		assert.Equal(t, opened+1, gauges()["waf_process_open_fds"])
+	}
+
+	usage, usageErr = 0, errors.New("permission denied")
+	assert.Zero(t, gauges()["waf_process_audit_dir_bytes"])
+
+	for _, name := range []string{"waf_process_goroutines", "waf_process_heap_inuse_bytes", "waf_process_gc_pause_seconds", "waf_process_audit_dir_bytes"} {
+		assert.Contains(t, Catalog(), Definition{Name: name, Help: catalog[name].Help, Kind: KindGauge}, "Should be in the catalog for the dashboards")
+	}
+}
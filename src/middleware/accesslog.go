@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+type accessLogKey struct{}
+
+// accessLogRecord carries attributes from the handlers that served the request to its access log line
+type accessLogRecord struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (a *accessLogRecord) get() []slog.Attr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.attrs
+}
+
+func withAccessLogRecord(ctx context.Context) (context.Context, *accessLogRecord) {
+	record := &accessLogRecord{}
+	return context.WithValue(ctx, accessLogKey{}, record), record
+}
+
+// AddAccessLogAttrs adds attributes to the request's access log line. It does nothing when the access log is
+// disabled.
+func AddAccessLogAttrs(r *http.Request, attrs ...slog.Attr) {
+	if record, ok := r.Context().Value(accessLogKey{}).(*accessLogRecord); ok {
+		record.mu.Lock()
+		record.attrs = append(record.attrs, attrs...)
+		record.mu.Unlock()
+	}
+}
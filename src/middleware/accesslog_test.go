@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAccessLogAttrs(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddAccessLogAttrs(r, slog.Int("anomaly_score", 10), slog.Any("attack_types", []string{"sqli"}))
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	LoggingMiddleware(handler, logger, slog.LevelInfo).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.EqualValues(t, 10, line["anomaly_score"])
+	assert.Equal(t, []any{"sqli"}, line["attack_types"])
+	assert.EqualValues(t, http.StatusForbidden, line["status"])
+
+	t.Run("Should do nothing without an access log", func(t *testing.T) {
+		assert.NotPanics(t, func() { AddAccessLogAttrs(httptest.NewRequest("GET", "/", nil), slog.Int("anomaly_score", 10)) })
+	})
+}
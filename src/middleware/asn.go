@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/geoip"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// Actions of an ASN policy
+const (
+	// ASNAllow exempts the requests from the policies that follow
+	ASNAllow = "allow"
+	// ASNDeny answers 403 without evaluating the requests
+	ASNDeny = "deny"
+	// ASNLimit answers 429 to the requests of each ASN over the rate
+	ASNLimit = "limit"
+)
+
+// ASNDatabase tells the autonomous system of an IP, such as geoip.Reader
+type ASNDatabase interface {
+	ASN(ip netip.Addr) (geoip.ASN, bool)
+}
+
+// ASNPolicy applies an action to the requests from a set of autonomous systems
+type ASNPolicy struct {
+	ASNs   []uint32
+	Action string
+	// Prefix restricts the policy to the paths starting with it. Empty applies it to every path.
+	Prefix string
+	// Rate is the number of requests per second each ASN is allowed with ASNLimit, up to Burst at once
+	Rate  float64
+	Burst int
+}
+
+// ParseASNPolicies parses one policy per line, "ASN[,ASN...] action [/prefix]" where the action is allow, deny or
+// limit=N/s, limit=N/m or limit=N/h, e.g. "16509,14061 limit=10/m /login"
+func ParseASNPolicies(value string) ([]ASNPolicy, error) {
+	var policies []ASNPolicy
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid ASN policy %q, expected \"ASN[,ASN...] action [/prefix]\"", line)
+		}
+		var policy ASNPolicy
+		for _, asn := range strings.Split(fields[0], ",") {
+			number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+			if err != nil || number == 0 {
+				return nil, fmt.Errorf("invalid ASN %q in policy %q", asn, line)
+			}
+			policy.ASNs = append(policy.ASNs, uint32(number))
+		}
+		action, limit, _ := strings.Cut(fields[1], "=")
+		switch action {
+		case ASNAllow, ASNDeny:
+			if limit != "" {
+				return nil, fmt.Errorf("invalid ASN policy %q, %s takes no rate", line, action)
+			}
+		case ASNLimit:
+			count, per, ok := strings.Cut(limit, "/")
+			n, err := strconv.Atoi(count)
+			unit := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[per]
+			if !ok || err != nil || n <= 0 || unit == 0 {
+				return nil, fmt.Errorf("invalid rate %q in ASN policy %q, expected N/s, N/m or N/h", limit, line)
+			}
+			policy.Rate, policy.Burst = float64(n)/unit.Seconds(), n
+		default:
+			return nil, fmt.Errorf("invalid action %q in ASN policy %q, expected allow, deny or limit=N/unit", fields[1], line)
+		}
+		policy.Action = action
+		if len(fields) == 3 {
+			if !strings.HasPrefix(fields[2], "/") {
+				return nil, fmt.Errorf("invalid path prefix %q in ASN policy %q", fields[2], line)
+			}
+			policy.Prefix = fields[2]
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// asnBucket is the token bucket of an ASN under a limit policy
+type asnBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+// ASNPolicies applies the first policy matching the ASN and path of each request
+type ASNPolicies struct {
+	database ASNDatabase
+	policies []ASNPolicy
+	now      func() time.Time
+
+	mu sync.Mutex
+	// buckets are keyed by policy index then ASN, which the policies bound
+	buckets map[int]map[uint32]*asnBucket
+}
+
+func NewASNPolicies(database ASNDatabase, policies []ASNPolicy) *ASNPolicies {
+	return &ASNPolicies{database: database, policies: policies, now: time.Now, buckets: map[int]map[uint32]*asnBucket{}}
+}
+
+// admit takes a token from the bucket of the ASN under the policy, returning how long until the next token when
+// there is none
+func (p *ASNPolicies) admit(index int, asn uint32) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	policy := p.policies[index]
+	now := p.now()
+	if p.buckets[index] == nil {
+		p.buckets[index] = map[uint32]*asnBucket{}
+	}
+	bucket, ok := p.buckets[index][asn]
+	if !ok {
+		bucket = &asnBucket{tokens: float64(policy.Burst), refilled: now}
+		p.buckets[index][asn] = bucket
+	}
+	bucket.tokens = min(float64(policy.Burst), bucket.tokens+now.Sub(bucket.refilled).Seconds()*policy.Rate)
+	bucket.refilled = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / policy.Rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Policy returns the first policy listing the ASN of the IP whose prefix matches the path, and the ASN. The ASN is
+// zero when the database does not know the IP.
+func (p *ASNPolicies) Policy(ip netip.Addr, path string) (ASNPolicy, uint32, bool) {
+	asn, ok := p.database.ASN(ip)
+	if !ok {
+		return ASNPolicy{}, 0, false
+	}
+	if i, ok := p.match(asn.Number, path); ok {
+		return p.policies[i], asn.Number, true
+	}
+	return ASNPolicy{}, asn.Number, false
+}
+
+// match returns the index of the first policy listing the ASN whose prefix matches the path
+func (p *ASNPolicies) match(asn uint32, path string) (int, bool) {
+	for i, policy := range p.policies {
+		if slices.Contains(policy.ASNs, asn) && strings.HasPrefix(path, policy.Prefix) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// ASNPolicyMiddleware looks up the ASN of the client, adding it to the access log, and applies the first policy
+// listing it whose prefix matches the path. Requests from IPs the database does not know pass.
+func ASNPolicyMiddleware(next http.Handler, policies *ASNPolicies) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := netip.ParseAddr(ClientIP(r))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		asn, ok := policies.database.ASN(ip)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		AddAccessLogAttrs(r, slog.Uint64("asn", uint64(asn.Number)))
+
+		i, ok := policies.match(asn.Number, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		label := strconv.FormatUint(uint64(asn.Number), 10)
+		switch policies.policies[i].Action {
+		case ASNDeny:
+			metricASNRequests.WithLabelValues(label, "deny").Inc()
+			httperror.Write(w, r, http.StatusForbidden, httperror.Body{Code: httperror.CodeASNDenied})
+			return
+		case ASNLimit:
+			if ok, wait := policies.admit(i, asn.Number); !ok {
+				metricASNRequests.WithLabelValues(label, "rate_limited").Inc()
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+				httperror.Write(w, r, http.StatusTooManyRequests, httperror.Body{Code: httperror.CodeASNRateLimited})
+				return
+			}
+			metricASNRequests.WithLabelValues(label, "limited").Inc()
+		default:
+			metricASNRequests.WithLabelValues(label, "allow").Inc()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
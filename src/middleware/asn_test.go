@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/geoip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeASNDatabase map[string]uint32
+
+func (f fakeASNDatabase) ASN(ip netip.Addr) (geoip.ASN, bool) {
+	number, ok := f[ip.String()]
+	return geoip.ASN{Number: number}, ok
+}
+
+func TestParseASNPolicies(t *testing.T) {
+	policies, err := ParseASNPolicies(`
+		15169 allow /login
+		AS16509,14061 limit=10/m /login
+		64496 deny
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, []ASNPolicy{
+		{ASNs: []uint32{15169}, Action: ASNAllow, Prefix: "/login"},
+		{ASNs: []uint32{16509, 14061}, Action: ASNLimit, Prefix: "/login", Rate: 10.0 / 60, Burst: 10},
+		{ASNs: []uint32{64496}, Action: ASNDeny},
+	}, policies)
+
+	for _, invalid := range []string{"16509", "AS0 deny", "x deny", "16509 block", "16509 limit=10", "16509 limit=0/s", "16509 deny login", "16509 deny=1/s"} {
+		_, err := ParseASNPolicies(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestASNPolicyMiddleware(t *testing.T) {
+	database := fakeASNDatabase{"192.0.2.1": 16509, "192.0.2.2": 16509, "198.51.100.1": 64496, "203.0.113.1": 15169}
+	parsed, err := ParseASNPolicies("15169 allow\n16509,15169 limit=2/m /login\n64496 deny")
+	require.NoError(t, err)
+	policies := NewASNPolicies(database, parsed)
+	now := time.Unix(1700000000, 0)
+	policies.now = func() time.Time { return now }
+	handler := ASNPolicyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), policies)
+
+	serve := func(remoteAddr string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should deny the requests of denied ASNs", func(t *testing.T) {
+		w := serve("198.51.100.1:1234", "/")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "waf.asn_denied")
+	})
+
+	t.Run("Should rate limit every client of an ASN together on the prefix", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", "/login").Code)
+		assert.Equal(t, http.StatusOK, serve("192.0.2.2:1234", "/login").Code)
+		w := serve("192.0.2.1:1234", "/login")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", "/").Code, "Expected other paths not to be limited")
+
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", "/login").Code)
+	})
+
+	t.Run("Should exempt allowed ASNs from the policies that follow", func(t *testing.T) {
+		for range 5 {
+			assert.Equal(t, http.StatusOK, serve("203.0.113.1:1234", "/login").Code)
+		}
+	})
+
+	t.Run("Should pass clients the database does not know", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("192.0.2.99:1234", "/login").Code)
+	})
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Reasons a request is allowed without being inspected
+const (
+	// BypassRuleEngineOff is a request allowed because SecRuleEngine is Off
+	BypassRuleEngineOff = "rule_engine_off"
+	// BypassPolicyExemption is a request whose evaluation was switched off by a rule, e.g. ctl:ruleEngine=Off
+	BypassPolicyExemption = "policy_exemption"
+	// BypassFailOpen is a request allowed by the failure policy because the WAF failed to evaluate it
+	BypassFailOpen = "fail_open"
+	// BypassIPAllowList is a request from a client on an allow list of the iplists collection
+	BypassIPAllowList = "ip_allow_list"
+	// BypassToken is a request presenting a token of the bypass_tokens collection
+	BypassToken = "bypass_token"
+)
+
+type bypassKey struct{}
+
+// bypassRecord carries the bypass reason from the handler that allowed the request to the access log
+type bypassRecord struct {
+	mu     sync.Mutex
+	reason string
+}
+
+func (b *bypassRecord) get() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reason
+}
+
+func withBypassRecord(ctx context.Context) (context.Context, *bypassRecord) {
+	record := &bypassRecord{}
+	return context.WithValue(ctx, bypassKey{}, record), record
+}
+
+// MarkBypassed records that the request was allowed without being inspected. It is counted in
+// waf_bypassed_requests_total and the reason is added to the request's access log line.
+func MarkBypassed(r *http.Request, reason string) {
+	if !IsSynthetic(r) {
+		metricBypassedRequests.WithLabelValues(reason).Inc()
+	}
+	if record, ok := r.Context().Value(bypassKey{}).(*bypassRecord); ok {
+		record.mu.Lock()
+		record.reason = reason
+		record.mu.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkBypassed(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	policy := FailurePolicy{Default: FailOpen}
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy.Fail(w, r, FailureClassBodyRead, errors.New("connection reset"))
+	})
+	before := testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassFailOpen))
+
+	w := httptest.NewRecorder()
+	LoggingMiddleware(failing, logger, slog.LevelInfo).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassFailOpen)))
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.Equal(t, BypassFailOpen, line["bypass"])
+
+	t.Run("Should not add a bypass to inspected requests", func(t *testing.T) {
+		logs.Reset()
+		LoggingMiddleware(okHandler, logger, slog.LevelInfo).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		assert.NotContains(t, logs.String(), "bypass")
+	})
+
+	t.Run("Should count bypasses without an access log", func(t *testing.T) {
+		before := testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassRuleEngineOff))
+		MarkBypassed(httptest.NewRequest("GET", "/", nil), BypassRuleEngineOff)
+		assert.Equal(t, before+1, testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassRuleEngineOff)))
+	})
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CanonicalizeMode determines whether request URIs are canonicalized before they are evaluated
+type CanonicalizeMode string
+
+const (
+	// CanonicalizeOff evaluates the URI as received
+	CanonicalizeOff CanonicalizeMode = "off"
+	// CanonicalizeNormalize rewrites the URI to its canonical form and counts suspicious URIs
+	CanonicalizeNormalize CanonicalizeMode = "normalize"
+	// CanonicalizeStrict also rejects suspicious URIs with 400
+	CanonicalizeStrict CanonicalizeMode = "strict"
+)
+
+// ParseCanonicalizeMode converts a configuration value into a CanonicalizeMode
+func ParseCanonicalizeMode(value string) (CanonicalizeMode, error) {
+	switch mode := CanonicalizeMode(value); mode {
+	case CanonicalizeOff, CanonicalizeNormalize, CanonicalizeStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid canonicalize mode %q, expected %q, %q or %q", value, CanonicalizeOff, CanonicalizeNormalize, CanonicalizeStrict)
+	}
+}
+
+// CanonicalizeMiddleware percent-decodes the path and query once, normalizes them to Unicode NFC and collapses
+// duplicate slashes and dot segments in the path, so that rules match the URI the backend will resolve. A URI
+// that only reaches its canonical form through an evasion technique, such as double encoding or an encoded dot
+// segment, is suspicious and rejected in strict mode.
+func CanonicalizeMiddleware(next http.Handler, mode CanonicalizeMode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonicalPath, canonicalQuery, suspicion := canonicalURI(rawRequestURI(r))
+		if suspicion != "" {
+			metricSuspiciousURIs.WithLabelValues(suspicion, string(mode)).Inc()
+			slog.Debug("Suspicious request URI", "reason", suspicion, "remote_addr", r.RemoteAddr, "mode", mode)
+			if mode == CanonicalizeStrict {
+				httperror.Write(w, r, http.StatusBadRequest, httperror.Body{Code: httperror.CodeSuspiciousURI, Message: "request URI has " + strings.ReplaceAll(suspicion, "_", " ")})
+				return
+			}
+		}
+
+		r.URL.Path, r.URL.RawPath, r.URL.RawQuery = canonicalPath, "", canonicalQuery
+		r.RequestURI = r.URL.RequestURI()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rawRequestURI returns the URI as the client sent it. Traefik passes it in X-Forwarded-Uri.
+func rawRequestURI(r *http.Request) string {
+	if uri := r.Header.Get("X-Forwarded-Uri"); uri != "" {
+		return uri
+	}
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+// canonicalURI returns the canonical path and query of a raw request URI, and the first reason it is suspicious
+func canonicalURI(raw string) (string, string, string) {
+	rawPath, rawQuery, _ := strings.Cut(raw, "?")
+
+	var suspicion string
+	flag := func(reason string) {
+		if suspicion == "" {
+			suspicion = reason
+		}
+	}
+
+	decoded, err := url.PathUnescape(rawPath)
+	switch {
+	case err != nil:
+		flag("invalid_encoding")
+		decoded = rawPath
+	case hasEscape(decoded):
+		flag("double_encoding")
+	}
+	decoded = normalizeUnicode(decoded, flag)
+	if strings.ContainsFunc(decoded, func(c rune) bool { return c < 0x20 || c == 0x7f }) {
+		flag("control_character")
+	}
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == "." || segment == ".." {
+			flag("dot_segment")
+			break
+		}
+	}
+
+	return cleanPath(decoded), canonicalQuery(rawQuery, flag), suspicion
+}
+
+// canonicalQuery decodes, normalizes and re-encodes each query parameter, keeping their order. Only invalid
+// encodings are suspicious, since parameters legitimately carry encoded URLs, binary data and free text.
+func canonicalQuery(raw string, flag func(string)) string {
+	if raw == "" {
+		return ""
+	}
+	var parts []string
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(pair, "=")
+		part := canonicalQueryComponent(key, flag)
+		if hasValue {
+			part += "=" + canonicalQueryComponent(value, flag)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "&")
+}
+
+func canonicalQueryComponent(raw string, flag func(string)) string {
+	decoded, err := url.QueryUnescape(raw)
+	if err != nil {
+		flag("invalid_encoding")
+		return raw
+	}
+	if utf8.ValidString(decoded) {
+		decoded = norm.NFC.String(decoded)
+	}
+	return url.QueryEscape(decoded)
+}
+
+// normalizeUnicode returns s in NFC. Invalid UTF-8 and compatibility characters, such as the fullwidth solidus
+// that NFKC folds into "/", are suspicious.
+func normalizeUnicode(s string, flag func(string)) string {
+	if !utf8.ValidString(s) {
+		flag("invalid_utf8")
+		return s
+	}
+	normalized := norm.NFC.String(s)
+	if norm.NFKC.String(s) != normalized {
+		flag("unicode_compatibility")
+	}
+	return normalized
+}
+
+// cleanPath collapses duplicate slashes and resolves dot segments, keeping a trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// hasEscape reports whether s still contains a percent-encoded byte
+func hasEscape(s string) bool {
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] == '%' && isHex(s[i+1]) && isHex(s[i+2]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalURI(t *testing.T) {
+	tests := []struct {
+		raw       string
+		path      string
+		query     string
+		suspicion string
+	}{
+		{raw: "/orders/42", path: "/orders/42"},
+		{raw: "/orders/42/?page=2&sort", path: "/orders/42/", query: "page=2&sort"},
+		{raw: "//orders///42", path: "/orders/42"},
+		{raw: "/caf%C3%A9", path: "/café"},
+		{raw: "/cafe%CC%81", path: "/café"},
+		{raw: "/search?q=caf%C3%A9+au+lait&&x=%41", path: "/search", query: "q=caf%C3%A9+au+lait&x=A"},
+		{raw: "/search?next=https%3A%2F%2Fexample.com%2F%3Fa%3Db%2520c", path: "/search", query: "next=https%3A%2F%2Fexample.com%2F%3Fa%3Db%2520c"},
+		{raw: "/static/../etc/passwd", path: "/etc/passwd", suspicion: "dot_segment"},
+		{raw: "/static/%2e%2e/etc/passwd", path: "/etc/passwd", suspicion: "dot_segment"},
+		{raw: "/static/%252e%252e/etc/passwd", path: "/static/%2e%2e/etc/passwd", suspicion: "double_encoding"},
+		{raw: "/static/%zz", path: "/static/%zz", suspicion: "invalid_encoding"},
+		{raw: "/search?q=%zz", path: "/search", query: "q=%zz", suspicion: "invalid_encoding"},
+		{raw: "/admin%00.html", path: "/admin\x00.html", suspicion: "control_character"},
+		{raw: "/admin%ff", path: "/admin\xff", suspicion: "invalid_utf8"},
+		{raw: "/static/%EF%BC%8E%EF%BC%8E%EF%BC%8Fetc", path: "/static/．．／etc", suspicion: "unicode_compatibility"},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			path, query, suspicion := canonicalURI(test.raw)
+			assert.Equal(t, test.path, path)
+			assert.Equal(t, test.query, query)
+			assert.Equal(t, test.suspicion, suspicion)
+		})
+	}
+}
+
+func TestCanonicalizeMiddleware(t *testing.T) {
+	var captured *http.Request
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured = r
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Should canonicalize the forwarded URI", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-Uri", "/api//v1/%2e%2e/orders?id=%34%32")
+		// ProxyHeaderMiddleware leaves the query in the path
+		req.URL.Path = req.Header.Get("X-Forwarded-Uri")
+
+		w := httptest.NewRecorder()
+		CanonicalizeMiddleware(testHandler, CanonicalizeNormalize).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "/api/orders", captured.URL.Path)
+		assert.Equal(t, "id=42", captured.URL.RawQuery)
+		assert.Equal(t, "/api/orders?id=42", captured.URL.String())
+	})
+
+	t.Run("Should reject suspicious URIs in strict mode", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/static/%252e%252e/etc/passwd", nil)
+
+		w := httptest.NewRecorder()
+		CanonicalizeMiddleware(testHandler, CanonicalizeStrict).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response httperror.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, httperror.CodeSuspiciousURI, response.Error.Code)
+		assert.Equal(t, "request URI has double encoding", response.Error.Message)
+	})
+
+	t.Run("Should pass canonical URIs in strict mode", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/orders/42?page=2", nil)
+
+		w := httptest.NewRecorder()
+		CanonicalizeMiddleware(testHandler, CanonicalizeStrict).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "/orders/42", captured.URL.Path)
+	})
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// CookieIntegrityMode determines how a request carrying a tampered cookie is handled
+type CookieIntegrityMode string
+
+const (
+	// CookieIntegrityFlag logs and counts tampered cookies but lets the request through to the WAF
+	CookieIntegrityFlag CookieIntegrityMode = "flag"
+	// CookieIntegrityBlock rejects requests carrying a tampered cookie with 403
+	CookieIntegrityBlock CookieIntegrityMode = "block"
+)
+
+// ParseCookieIntegrityMode converts a configuration value into a CookieIntegrityMode
+func ParseCookieIntegrityMode(value string) (CookieIntegrityMode, error) {
+	switch mode := CookieIntegrityMode(value); mode {
+	case CookieIntegrityFlag, CookieIntegrityBlock:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid cookie integrity mode %q, expected %q or %q", value, CookieIntegrityFlag, CookieIntegrityBlock)
+	}
+}
+
+// CookieIntegrity configures verification of HMAC-signed cookies
+type CookieIntegrity struct {
+	// Secret is the HMAC-SHA256 key the cookies are signed with
+	Secret []byte
+	// Cookies are the names of the cookies that must carry a valid signature. No cookies disables verification.
+	Cookies []string
+	Mode    CookieIntegrityMode
+}
+
+// SignCookie returns the value with its signature appended, in the "value.signature" form verified by
+// CookieIntegrityMiddleware. The signature covers the cookie name so values cannot be swapped between cookies.
+func SignCookie(secret []byte, name string, value string) string {
+	return value + "." + cookieSignature(secret, name, value)
+}
+
+// CookieIntegrityMiddleware verifies the signature of the configured cookies, flagging or blocking tampered values
+func CookieIntegrityMiddleware(next http.Handler, options CookieIntegrity) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, cookie := range r.Cookies() {
+			if !slices.Contains(options.Cookies, cookie.Name) || validCookie(options.Secret, cookie) {
+				continue
+			}
+
+			metricCookieTampering.WithLabelValues(cookie.Name, string(options.Mode)).Inc()
+			slog.Warn("Tampered cookie detected", "cookie", cookie.Name, "remote_addr", r.RemoteAddr, "mode", options.Mode)
+			if options.Mode == CookieIntegrityBlock {
+				httperror.Write(w, r, http.StatusForbidden, httperror.Body{Code: httperror.CodeCookieTampered, Message: "cookie " + cookie.Name + " has an invalid signature"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validCookie(secret []byte, cookie *http.Cookie) bool {
+	i := strings.LastIndexByte(cookie.Value, '.')
+	if i < 0 {
+		return false
+	}
+	expected := cookieSignature(secret, cookie.Name, cookie.Value[:i])
+	return hmac.Equal([]byte(cookie.Value[i+1:]), []byte(expected))
+}
+
+func cookieSignature(secret []byte, name string, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(name + "=" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieIntegrityMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	secret := []byte("test-secret")
+	options := CookieIntegrity{Secret: secret, Cookies: []string{"session"}, Mode: CookieIntegrityBlock}
+	middleware := CookieIntegrityMiddleware(testHandler, options)
+
+	send := func(handler http.Handler, cookie string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Should pass correctly signed cookies", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(middleware, "session="+SignCookie(secret, "session", "user-42")))
+	})
+
+	t.Run("Should ignore cookies that are not protected", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(middleware, "theme=dark"))
+	})
+
+	t.Run("Should block tampered and unsigned cookies", func(t *testing.T) {
+		signed := SignCookie(secret, "session", "user-42")
+		assert.Equal(t, http.StatusForbidden, send(middleware, "session=user-1"+signed[len("user-42"):]))
+		assert.Equal(t, http.StatusForbidden, send(middleware, "session=user-42"))
+	})
+
+	t.Run("Should not accept a signature from another cookie", func(t *testing.T) {
+		protected := CookieIntegrityMiddleware(testHandler, CookieIntegrity{Secret: secret, Cookies: []string{"session", "role"}, Mode: CookieIntegrityBlock})
+		assert.Equal(t, http.StatusForbidden, send(protected, "role="+SignCookie(secret, "session", "admin")))
+	})
+
+	t.Run("Should let tampered cookies through when flagging", func(t *testing.T) {
+		flagging := CookieIntegrityMiddleware(testHandler, CookieIntegrity{Secret: secret, Cookies: []string{"session"}, Mode: CookieIntegrityFlag})
+		assert.Equal(t, http.StatusOK, send(flagging, "session=user-1.bad"))
+	})
+}
+
+func TestParseCookieIntegrityMode(t *testing.T) {
+	mode, err := ParseCookieIntegrityMode("block")
+	assert.NoError(t, err)
+	assert.Equal(t, CookieIntegrityBlock, mode)
+
+	_, err = ParseCookieIntegrityMode("drop")
+	assert.Error(t, err)
+}
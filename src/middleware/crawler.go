@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rdns"
+)
+
+// DefaultVerifiedCrawlerHeader carries the name of the verified crawler a request comes from
+const DefaultVerifiedCrawlerHeader = "X-Verified-Crawler"
+
+// ClientResolver knows the reverse DNS of client IPs, such as rdns.Resolver
+type ClientResolver interface {
+	Cached(ip string) (rdns.Result, bool)
+	Prefetch(ip string)
+}
+
+// VerifiedCrawlerOptions configures the header telling rules which verified crawler a request comes from
+type VerifiedCrawlerOptions struct {
+	// Resolver verifies the crawlers. Nil disables the header.
+	Resolver ClientResolver
+	// Header is set to the crawler name, e.g. googlebot, so rules can tell verified crawlers from clients
+	// impersonating them. DefaultVerifiedCrawlerHeader when empty.
+	Header string
+}
+
+// VerifiedCrawlerMiddleware replaces the crawler header sent by the client with the crawler its IP was verified as.
+// Lookups never delay the request: an IP that is not cached yet is looked up in the background, and its later
+// requests carry the header.
+func VerifiedCrawlerMiddleware(next http.Handler, options VerifiedCrawlerOptions) http.Handler {
+	header := options.Header
+	if header == "" {
+		header = DefaultVerifiedCrawlerHeader
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(header)
+		ip := ClientIP(r)
+		if result, ok := options.Resolver.Cached(ip); !ok {
+			options.Resolver.Prefetch(ip)
+		} else if result.Crawler != "" {
+			r.Header.Set(header, result.Crawler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rdns"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClientResolver struct {
+	results    map[string]rdns.Result
+	prefetched []string
+}
+
+func (f *fakeClientResolver) Cached(ip string) (rdns.Result, bool) {
+	result, ok := f.results[ip]
+	return result, ok
+}
+
+func (f *fakeClientResolver) Prefetch(ip string) {
+	f.prefetched = append(f.prefetched, ip)
+}
+
+func TestVerifiedCrawlerMiddleware(t *testing.T) {
+	resolver := &fakeClientResolver{results: map[string]rdns.Result{
+		"66.249.66.1": {Hostname: "crawl-66-249-66-1.googlebot.com", Crawler: "googlebot"},
+		"192.0.2.1":   {Hostname: "host.example.com"},
+	}}
+	var crawler string
+	handler := VerifiedCrawlerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crawler = r.Header.Get(DefaultVerifiedCrawlerHeader)
+	}), VerifiedCrawlerOptions{Resolver: resolver})
+
+	serve := func(remoteAddr string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(DefaultVerifiedCrawlerHeader, "bingbot")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("Should set the header of a verified crawler", func(t *testing.T) {
+		serve("66.249.66.1:1234")
+		assert.Equal(t, "googlebot", crawler)
+	})
+
+	t.Run("Should strip the header sent by other clients", func(t *testing.T) {
+		serve("192.0.2.1:1234")
+		assert.Empty(t, crawler)
+		assert.Empty(t, resolver.prefetched)
+	})
+
+	t.Run("Should look up unknown clients in the background", func(t *testing.T) {
+		serve("198.51.100.1:1234")
+		assert.Empty(t, crawler)
+		assert.Equal(t, []string{"198.51.100.1"}, resolver.prefetched)
+	})
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	middleware := CSRFMiddleware(testHandler, CSRFOptions{
+		Paths:         []string{"/account"},
+		ExemptMethods: []string{"GET", "HEAD", "OPTIONS"},
+		CookieName:    "csrf_token",
+		HeaderName:    "X-CSRF-Token",
+	})
+
+	send := func(method string, target string, cookie string, header string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", "csrf_token="+cookie)
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Should pass exempt methods and unprotected paths", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("GET", "/account/settings", "", ""))
+		assert.Equal(t, http.StatusOK, send("POST", "/search", "", ""))
+	})
+
+	t.Run("Should pass a matching token", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("POST", "/account/settings", "abc123", "abc123"))
+	})
+
+	t.Run("Should reject missing or mismatched tokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("POST", "/account/settings", "", "abc123"))
+		assert.Equal(t, http.StatusForbidden, send("DELETE", "/account", "abc123", ""))
+		assert.Equal(t, http.StatusForbidden, send("PUT", "/account?x=1", "abc123", "other"))
+	})
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DeadlineOptions bounds how long the WAF may take to answer, so it never answers after Traefik has given up
+type DeadlineOptions struct {
+	// Header carries the caller's forward-auth timeout, as a duration such as 2s or a number of milliseconds.
+	// An empty header name ignores the caller's budget.
+	Header string
+	// Default is the budget of requests without the header. Zero leaves them unbounded.
+	Default time.Duration
+	// Margin is kept in reserve so that the answer arrives before the caller's timeout expires
+	Margin time.Duration
+}
+
+// budget returns the time the WAF has to answer r, or zero if it is unbounded
+func (o DeadlineOptions) budget(r *http.Request) (time.Duration, error) {
+	budget := o.Default
+	if value := r.Header.Get(o.Header); o.Header != "" && value != "" {
+		parsed, err := parseBudget(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s header %q: %w", o.Header, value, err)
+		}
+		budget = parsed
+	}
+	if budget <= 0 {
+		return 0, nil
+	}
+	// Never spend less than a millisecond, even when the margin exceeds the budget
+	return max(budget-o.Margin, time.Millisecond), nil
+}
+
+func parseBudget(value string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(value); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// DeadlineMiddleware cancels the request context once the budget is spent and answers according to the failure
+// policy, without waiting for the rest of the chain to notice the cancellation. The response of the chain is
+// buffered until it completes within the budget.
+func DeadlineMiddleware(next http.Handler, options DeadlineOptions, policy FailurePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, err := options.budget(r)
+		if err != nil {
+			// Evaluate the request without a deadline rather than rejecting it for a proxy misconfiguration
+			metricDeadlines.WithLabelValues("invalid").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+		if budget == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		buffered := &deadlineWriter{header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		// The chain may still be running after the budget is spent, so it gets its own copy of the headers and URL
+		// rather than sharing them with the outer middlewares that log the request
+		inner := r.Clone(ctx)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(buffered, inner)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			// Re-panic on the serving goroutine so FailurePolicyMiddleware can answer
+			panic(p)
+		case <-done:
+			metricDeadlines.WithLabelValues("met").Inc()
+			buffered.flushTo(w)
+		case <-ctx.Done():
+			buffered.expire()
+			if r.Context().Err() != nil {
+				// Traefik itself gave up, so there is nobody to answer
+				return
+			}
+			metricDeadlines.WithLabelValues("exceeded").Inc()
+			policy.Fail(w, r, FailureClassDeadline, fmt.Errorf("evaluation exceeded the %s budget", budget))
+		}
+	})
+}
+
+// deadlineWriter buffers a response until it is either flushed or expired, after which writes are discarded
+type deadlineWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	expired bool
+}
+
+func (w *deadlineWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 && !w.expired {
+		w.status = status
+	}
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *deadlineWriter) expire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expired = true
+}
+
+func (w *deadlineWriter) flushTo(dst http.ResponseWriter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, values := range w.header {
+		dst.Header()[name] = values
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	dst.WriteHeader(w.status)
+	dst.Write(w.body.Bytes())
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineMiddleware(t *testing.T) {
+	slowHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusTeapot)
+		case <-r.Context().Done():
+		}
+	})
+	fastHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("denied"))
+	})
+	options := DeadlineOptions{Header: "X-Auth-Budget", Margin: 10 * time.Millisecond}
+	serve := func(handler http.Handler, policy FailurePolicy, budget string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if budget != "" {
+			req.Header.Set("X-Auth-Budget", budget)
+		}
+		w := httptest.NewRecorder()
+		DeadlineMiddleware(handler, options, policy).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should pass through responses within the budget", func(t *testing.T) {
+		w := serve(fastHandler, FailurePolicy{Default: FailClosed}, "2s")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-Test"))
+		assert.Equal(t, "denied", w.Body.String())
+	})
+
+	t.Run("Should answer by the failure policy when the budget is spent", func(t *testing.T) {
+		start := time.Now()
+		w := serve(slowHandler, FailurePolicy{Default: FailClosed}, "60")
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		w = serve(slowHandler, FailurePolicy{Default: FailClosed, Overrides: map[FailureClass]FailureMode{FailureClassDeadline: FailOpen}}, "60ms")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Should ignore missing and invalid budgets", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(fastHandler, FailurePolicy{}, "").Code)
+		assert.Equal(t, http.StatusForbidden, serve(fastHandler, FailurePolicy{}, "soon").Code)
+	})
+
+	t.Run("Should recover panics on the serving goroutine", func(t *testing.T) {
+		panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Auth-Budget", "1s")
+		handler := FailurePolicyMiddleware(DeadlineMiddleware(panicking, options, FailurePolicy{}), FailurePolicy{Default: FailClosed})
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestDeadlineBudget(t *testing.T) {
+	options := DeadlineOptions{Header: "X-Auth-Budget", Default: time.Second, Margin: 100 * time.Millisecond}
+	budget := func(value string) time.Duration {
+		req := httptest.NewRequest("GET", "/", nil)
+		if value != "" {
+			req.Header.Set("X-Auth-Budget", value)
+		}
+		budget, err := options.budget(req)
+		assert.NoError(t, err)
+		return budget
+	}
+
+	assert.Equal(t, 900*time.Millisecond, budget(""))
+	assert.Equal(t, 1900*time.Millisecond, budget("2s"))
+	assert.Equal(t, 400*time.Millisecond, budget("500"))
+	assert.Equal(t, time.Millisecond, budget("50ms"), "Expected a minimum budget when the margin exceeds it")
+
+	t.Run("Should not share the request with a chain that outlives the budget", func(t *testing.T) {
+		// Run with -race: the abandoned chain keeps rewriting the request while the outer middleware reads it
+		finished := make(chan struct{})
+		mutating := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(finished)
+			<-r.Context().Done()
+			for i := 0; i < 100; i++ {
+				r.Header.Set("User-Agent", "inner")
+				r.URL.Path = "/inner"
+			}
+		})
+		req := httptest.NewRequest("GET", "/outer", nil)
+		req.Header.Set("X-Auth-Budget", "30ms")
+		req.Header.Set("User-Agent", "outer")
+		w := httptest.NewRecorder()
+		DeadlineMiddleware(mutating, options, FailurePolicy{Default: FailClosed}).ServeHTTP(w, req)
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, "outer", req.UserAgent())
+			assert.Equal(t, "/outer", req.URL.Path)
+		}
+		<-finished
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
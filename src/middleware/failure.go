@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
+)
+
+// FailureMode determines how a request is answered when the WAF itself fails to evaluate it
+type FailureMode string
+
+const (
+	// FailOpen lets the request through as if the WAF had allowed it
+	FailOpen FailureMode = "open"
+	// FailClosed rejects the request with 503 Service Unavailable
+	FailClosed FailureMode = "closed"
+)
+
+// FailureClass identifies the kind of WAF subsystem error that occurred
+type FailureClass string
+
+const (
+	FailureClassPanic    FailureClass = "panic"
+	FailureClassBodyRead FailureClass = "body_read"
+	// FailureClassDecisionWebhook is an error calling the decision webhook
+	FailureClassDecisionWebhook FailureClass = "decision_webhook"
+	// FailureClassOPA is an error querying the OPA policy
+	FailureClassOPA FailureClass = "opa"
+	// FailureClassScript is an error or timeout in a script hook
+	FailureClassScript FailureClass = "script"
+	// FailureClassScoring is an error calling the scoring service
+	FailureClassScoring FailureClass = "scoring"
+	// FailureClassDeadline is a request that could not be evaluated within its forward-auth budget
+	FailureClassDeadline FailureClass = "deadline"
+)
+
+// ParseFailureMode converts a configuration value into a FailureMode
+func ParseFailureMode(value string) (FailureMode, error) {
+	switch mode := FailureMode(value); mode {
+	case FailOpen, FailClosed:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid failure mode %q, expected %q or %q", value, FailOpen, FailClosed)
+	}
+}
+
+// FailurePolicy maps WAF error classes to the failure mode used to answer the request
+type FailurePolicy struct {
+	Default   FailureMode
+	Overrides map[FailureClass]FailureMode
+}
+
+// Mode returns the failure mode configured for the given error class
+func (p FailurePolicy) Mode(class FailureClass) FailureMode {
+	if mode, ok := p.Overrides[class]; ok {
+		return mode
+	}
+	if p.Default == "" {
+		return FailClosed
+	}
+	return p.Default
+}
+
+// Fail answers the request according to the failure mode configured for the error class
+func (p FailurePolicy) Fail(w http.ResponseWriter, r *http.Request, class FailureClass, err any) {
+	mode := p.Mode(class)
+	metricWAFFailures.WithLabelValues(string(class), string(mode)).Inc()
+	slog.ErrorContext(logging.WithRequest(r.Context(), r), "WAF failed to evaluate request", "class", class, "mode", mode, "error", err)
+
+	if mode == FailOpen {
+		MarkBypassed(r, BypassFailOpen)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	httperror.Write(w, r, http.StatusServiceUnavailable, httperror.Body{Code: httperror.CodeServiceUnavailable})
+}
+
+// FailurePolicyMiddleware recovers from panics in the WAF handler and answers according to the failure policy
+func FailurePolicyMiddleware(next http.Handler, policy FailurePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				policy.Fail(w, r, FailureClassPanic, err)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailurePolicyMiddleware(t *testing.T) {
+	panicHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	t.Run("Should fail closed by default", func(t *testing.T) {
+		handler := FailurePolicyMiddleware(panicHandler, FailurePolicy{})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Should reject the request with 503")
+	})
+
+	t.Run("Should fail open when configured", func(t *testing.T) {
+		handler := FailurePolicyMiddleware(panicHandler, FailurePolicy{Default: FailOpen})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, "Should allow the request")
+	})
+
+	t.Run("Should prefer the per-class override", func(t *testing.T) {
+		handler := FailurePolicyMiddleware(panicHandler, FailurePolicy{
+			Default:   FailOpen,
+			Overrides: map[FailureClass]FailureMode{FailureClassPanic: FailClosed},
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Should use the panic override")
+	})
+}
+
+func TestParseFailureMode(t *testing.T) {
+	mode, err := ParseFailureMode("open")
+	assert.NoError(t, err)
+	assert.Equal(t, FailOpen, mode)
+
+	mode, err = ParseFailureMode("closed")
+	assert.NoError(t, err)
+	assert.Equal(t, FailClosed, mode)
+
+	_, err = ParseFailureMode("sideways")
+	assert.Error(t, err)
+}
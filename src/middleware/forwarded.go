@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// The proxy headers are attacker-controlled when Traefik trusts the forwarded headers of its clients, so every value
+// is validated before it is applied. An invalid value is ignored rather than rejected, as the WAF still evaluates the
+// request as received.
+
+// forwardedClient returns the client address from X-Forwarded-For, or else from the Forwarded header (RFC 7239)
+func forwardedClient(header http.Header) (string, bool) {
+	if xff := header.Get("X-Forwarded-For"); xff != "" {
+		// X-Forwarded-For can contain multiple IPs: "client, proxy1, proxy2"
+		// Take the first one (leftmost) as the original client IP
+		first, _, _ := strings.Cut(xff, ",")
+		return parseNode(first)
+	}
+	if forwarded := header.Get("Forwarded"); forwarded != "" {
+		return parseNode(forwardedParam(forwarded, "for"))
+	}
+	return "", false
+}
+
+// parseNode returns the IP address of a forwarded node: an IP address, optionally with a port, IPv6 addresses in
+// brackets. Obfuscated identifiers and "unknown" are not addresses.
+func parseNode(node string) (string, bool) {
+	node = strings.TrimSpace(node)
+	if _, err := netip.ParseAddr(node); err == nil {
+		return node, true
+	}
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().String(), true
+	}
+	if len(node) > 2 && node[0] == '[' && node[len(node)-1] == ']' {
+		if addr, err := netip.ParseAddr(node[1 : len(node)-1]); err == nil {
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+// forwardedProto returns the scheme from X-Forwarded-Proto or Forwarded
+func forwardedProto(header http.Header) (string, bool) {
+	proto := firstValue(header.Get("X-Forwarded-Proto"))
+	if proto == "" {
+		proto = forwardedParam(header.Get("Forwarded"), "proto")
+	}
+	return proto, isScheme(proto)
+}
+
+// forwardedHost returns the host from X-Forwarded-Host or Forwarded
+func forwardedHost(header http.Header) (string, bool) {
+	host := firstValue(header.Get("X-Forwarded-Host"))
+	if host == "" {
+		host = forwardedParam(header.Get("Forwarded"), "host")
+	}
+	return host, isHost(host)
+}
+
+// forwardedMethod returns the method from X-Forwarded-Method
+func forwardedMethod(header http.Header) (string, bool) {
+	method := header.Get("X-Forwarded-Method")
+	return method, method != "" && isToken(method)
+}
+
+// splitRequestURI splits a request URI into the URL path, its escaped form and the raw query. A path that is not
+// validly escaped is kept as it is.
+func splitRequestURI(uri string) (path string, rawPath string, rawQuery string) {
+	rawPath, rawQuery, _ = strings.Cut(uri, "?")
+	rawPath, _, _ = strings.Cut(rawPath, "#")
+	if !strings.Contains(rawPath, "%") {
+		return rawPath, "", rawQuery
+	}
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return rawPath, "", rawQuery
+	}
+	return path, rawPath, rawQuery
+}
+
+// firstValue returns the first of comma-separated header values
+func firstValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// forwardedParam returns a parameter of the first element of a Forwarded header, unquoted
+func forwardedParam(header string, name string) string {
+	inQuotes := false
+	start := 0
+	for i := 0; i <= len(header); i++ {
+		if i < len(header) {
+			switch c := header[i]; {
+			case c == '\\' && inQuotes:
+				i++
+				continue
+			case c == '"':
+				inQuotes = !inQuotes
+				continue
+			case inQuotes || c != ';' && c != ',':
+				continue
+			}
+		}
+		key, value, _ := strings.Cut(header[start:i], "=")
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return unquote(strings.TrimSpace(value))
+		}
+		if i >= len(header) || header[i] == ',' {
+			// Only the first element, added by the proxy closest to the client, is used
+			return ""
+		}
+		start = i + 1
+	}
+	return ""
+}
+
+// unquote returns the content of a quoted string, or the value when it is a token
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// isScheme reports whether the value is a URI scheme (RFC 3986)
+func isScheme(value string) bool {
+	if value == "" || !isAlpha(value[0]) {
+		return false
+	}
+	for i := 1; i < len(value); i++ {
+		if c := value[i]; !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// isHost reports whether the value is a host name or IP address, IPv6 addresses in brackets, with an optional port.
+// It must not change how the URL is parsed, so delimiters such as '/', '?', '#' and '@' are rejected.
+func isHost(value string) bool {
+	host, port := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.IndexByte(value, ']')
+		if end < 0 {
+			return false
+		}
+		if addr, err := netip.ParseAddr(value[1:end]); err != nil || !addr.Is6() {
+			return false
+		}
+		host, port = "", value[end+1:]
+		if port != "" && port[0] != ':' {
+			return false
+		}
+	} else if i := strings.IndexByte(value, ':'); i >= 0 {
+		host, port = value[:i], value[i:]
+		if host == "" {
+			return false
+		}
+	} else if value == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		if c := host[i]; !isAlpha(c) && !isDigit(c) && c != '-' && c != '.' && c != '_' {
+			return false
+		}
+	}
+	for i := 1; i < len(port); i++ {
+		if !isDigit(port[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether the value is an HTTP token (RFC 9110), as methods are
+func isToken(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; !isAlpha(c) && !isDigit(c) && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return value != ""
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardedParam(t *testing.T) {
+	tests := []struct {
+		header string
+		name   string
+		want   string
+	}{
+		{header: "for=192.0.2.60;proto=http;by=203.0.113.43", name: "proto", want: "http"},
+		{header: "For=192.0.2.60", name: "for", want: "192.0.2.60"},
+		{header: `for="[2001:db8:cafe::17]:4711"`, name: "for", want: "[2001:db8:cafe::17]:4711"},
+		{header: `for="a;b,c";host=example.com`, name: "host", want: "example.com"},
+		{header: `for="quoted \"pair\""`, name: "for", want: `quoted "pair"`},
+		// Only the first element is used
+		{header: "for=192.0.2.43, for=198.51.100.17;proto=https", name: "proto", want: ""},
+		{header: "", name: "for", want: ""},
+		{header: `for="unterminated`, name: "for", want: `"unterminated`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, forwardedParam(tt.header, tt.name), "%s in %s", tt.name, tt.header)
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	tests := []struct {
+		node string
+		want string
+		ok   bool
+	}{
+		{node: "192.0.2.60", want: "192.0.2.60", ok: true},
+		{node: " 192.0.2.60:4711 ", want: "192.0.2.60", ok: true},
+		{node: "2001:db8::1", want: "2001:db8::1", ok: true},
+		{node: "[2001:db8::1]:4711", want: "2001:db8::1", ok: true},
+		{node: "[2001:db8::1]", want: "2001:db8::1", ok: true},
+		{node: "unknown"},
+		{node: "_hidden"},
+		{node: "192.0.2.60:4711:1"},
+		{node: "[]"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseNode(tt.node)
+		assert.Equal(t, tt.ok, ok, tt.node)
+		assert.Equal(t, tt.want, got, tt.node)
+	}
+}
+
+func TestSplitRequestURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		path     string
+		rawPath  string
+		rawQuery string
+	}{
+		{uri: "/orders/42", path: "/orders/42"},
+		{uri: "/search?q=a&b=c", path: "/search", rawQuery: "q=a&b=c"},
+		{uri: "/a%20b", path: "/a b", rawPath: "/a%20b"},
+		{uri: "/a%zzb?x=1", path: "/a%zzb", rawQuery: "x=1"},
+		{uri: "/page#section", path: "/page"},
+		{uri: "/?", path: "/"},
+	}
+
+	for _, tt := range tests {
+		path, rawPath, rawQuery := splitRequestURI(tt.uri)
+		assert.Equal(t, tt.path, path, tt.uri)
+		assert.Equal(t, tt.rawPath, rawPath, tt.uri)
+		assert.Equal(t, tt.rawQuery, rawQuery, tt.uri)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// HeaderTransform rewrites request headers before the request is handed to Coraza. Header names are matched
+// case-insensitively and written in canonical form.
+type HeaderTransform struct {
+	// Remove lists headers to strip. A trailing * matches every header with that prefix, e.g. X-Internal-*.
+	Remove []string
+	// Rename moves the values of a header to a new name, replacing any values already under that name
+	Rename map[string]string
+	// Set overwrites headers with static values
+	Set http.Header
+}
+
+// Empty reports whether the transform leaves requests unchanged
+func (t HeaderTransform) Empty() bool {
+	return len(t.Remove) == 0 && len(t.Rename) == 0 && len(t.Set) == 0
+}
+
+// HeaderTransformMiddleware removes, renames and then sets headers, in that order
+func HeaderTransformMiddleware(next http.Handler, transform HeaderTransform) http.Handler {
+	var names, prefixes []string
+	for _, name := range transform.Remove {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefixes = append(prefixes, http.CanonicalHeaderKey(prefix))
+		} else {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	rename := make(map[string]string, len(transform.Rename))
+	for from, to := range transform.Rename {
+		rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range names {
+			r.Header.Del(name)
+		}
+		if len(prefixes) > 0 {
+			for name := range r.Header {
+				for _, prefix := range prefixes {
+					if strings.HasPrefix(name, prefix) {
+						delete(r.Header, name)
+						break
+					}
+				}
+			}
+		}
+		for from, to := range rename {
+			if values, ok := r.Header[from]; ok {
+				delete(r.Header, from)
+				r.Header[to] = values
+			}
+		}
+		for name, values := range transform.Set {
+			r.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
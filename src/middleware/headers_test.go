@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderTransformMiddleware(t *testing.T) {
+	var seen http.Header
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	middleware := HeaderTransformMiddleware(testHandler, HeaderTransform{
+		Remove: []string{"x-debug", "X-Internal-*"},
+		Rename: map[string]string{"x-real-user": "x-user"},
+		Set:    http.Header{"x-environment": {"production"}},
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Internal-Trace", "abc")
+	req.Header.Set("X-Internal-Route", "orders")
+	req.Header.Set("X-Real-User", "alice")
+	req.Header.Set("X-User", "spoofed")
+	req.Header.Set("X-Environment", "staging")
+	req.Header.Set("Accept", "*/*")
+
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.Header{
+		"Accept":        {"*/*"},
+		"X-User":        {"alice"},
+		"X-Environment": {"production"},
+	}, seen)
+
+	t.Run("Should not share set values between requests", func(t *testing.T) {
+		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header["X-Environment"][0] = "changed"
+		})
+		transform := HeaderTransform{Set: http.Header{"X-Environment": {"production"}}}
+		HeaderTransformMiddleware(testHandler, transform).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "production", transform.Set.Get("X-Environment"))
+	})
+
+	t.Run("Should report an empty transform", func(t *testing.T) {
+		assert.True(t, HeaderTransform{Set: http.Header{}}.Empty())
+		assert.False(t, HeaderTransform{Remove: []string{"X-Debug"}}.Empty())
+	})
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// HoneypotOptions configures trap paths that no legitimate client requests
+type HoneypotOptions struct {
+	// Paths are the trap paths, such as /wp-login.php or /.env. No paths disables the honeypot.
+	Paths []string
+	// BanDuration is how long a client that requests a trap path is banned for
+	BanDuration time.Duration
+}
+
+// HoneypotMiddleware bans clients that request a trap path and answers them with a decoy not found response
+func HoneypotMiddleware(next http.Handler, options HoneypotOptions, bans *ban.List) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if !slices.Contains(options.Paths, path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricHoneypotHits.WithLabelValues(path).Inc()
+		bans.Add(ClientIP(r), "honeypot:"+path, options.BanDuration)
+		http.NotFound(w, r)
+	})
+}
+
+// BanMiddleware rejects requests from banned clients before they reach the WAF
+func BanMiddleware(next http.Handler, bans *ban.List) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, banned := bans.Banned(ClientIP(r)); banned {
+			metricBannedRequests.Inc()
+			httperror.Write(w, r, http.StatusForbidden, httperror.Body{Code: httperror.CodeBanned})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the client address of a request after ProxyHeaderMiddleware has applied X-Forwarded-For
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/ban"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoneypotMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	bans := ban.New()
+	middleware := BanMiddleware(HoneypotMiddleware(testHandler, HoneypotOptions{
+		Paths:       []string{"/.env", "/wp-login.php"},
+		BanDuration: time.Hour,
+	}, bans), bans)
+
+	t.Run("Should pass requests for other paths", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/index.html", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Should answer trap paths with a decoy and ban the client", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/.env?x=1", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		entry, banned := bans.Banned("192.0.2.1")
+		assert.True(t, banned)
+		assert.Equal(t, "honeypot:/.env", entry.Reason)
+	})
+
+	t.Run("Should reject banned clients", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/index.html", nil)
+		req.RemoteAddr = "192.0.2.1:5678"
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
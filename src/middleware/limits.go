@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// RequestLimits caps the size of request components before they reach the WAF.
+// A zero value disables the corresponding limit.
+type RequestLimits struct {
+	MaxURLLength   int
+	MaxHeaderCount int
+	MaxHeaderBytes int
+	MaxCookieCount int
+	MaxQueryParams int
+}
+
+// RequestLimitsMiddleware rejects requests exceeding the configured limits so that Coraza never has to parse them
+func RequestLimitsMiddleware(next http.Handler, limits RequestLimits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit, status := limits.check(r); limit != "" {
+			metricRequestLimitRejections.WithLabelValues(limit).Inc()
+			slog.Debug("Request rejected by request limits", "limit", limit, "remote_addr", r.RemoteAddr)
+			httperror.Write(w, r, status, httperror.Body{Code: httperror.CodeRequestLimit, Message: "request exceeds the " + limit + " limit"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check returns the name of the first exceeded limit and the status code to respond with
+func (l RequestLimits) check(r *http.Request) (string, int) {
+	if l.MaxURLLength > 0 && len(r.URL.RequestURI()) > l.MaxURLLength {
+		return "url_length", http.StatusRequestURITooLong
+	}
+
+	if l.MaxHeaderCount > 0 || l.MaxHeaderBytes > 0 {
+		count, size := 0, 0
+		for name, values := range r.Header {
+			for _, v := range values {
+				count++
+				size += len(name) + len(v)
+			}
+		}
+		if l.MaxHeaderCount > 0 && count > l.MaxHeaderCount {
+			return "header_count", http.StatusRequestHeaderFieldsTooLarge
+		}
+		if l.MaxHeaderBytes > 0 && size > l.MaxHeaderBytes {
+			return "header_bytes", http.StatusRequestHeaderFieldsTooLarge
+		}
+	}
+
+	if l.MaxCookieCount > 0 {
+		count := 0
+		for _, line := range r.Header.Values("Cookie") {
+			count += strings.Count(line, ";") + 1
+		}
+		if count > l.MaxCookieCount {
+			return "cookie_count", http.StatusRequestHeaderFieldsTooLarge
+		}
+	}
+
+	if l.MaxQueryParams > 0 && r.URL.RawQuery != "" {
+		if strings.Count(r.URL.RawQuery, "&")+1 > l.MaxQueryParams {
+			return "query_params", http.StatusBadRequest
+		}
+	}
+
+	return "", 0
+}
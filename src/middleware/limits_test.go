@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimitsMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	middleware := RequestLimitsMiddleware(testHandler, RequestLimits{
+		MaxURLLength:   64,
+		MaxHeaderCount: 3,
+		MaxHeaderBytes: 128,
+		MaxCookieCount: 2,
+		MaxQueryParams: 2,
+	})
+
+	t.Run("Should pass requests within limits", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test?a=1&b=2", nil)
+		req.Header.Set("Cookie", "a=1; b=2")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Should reject long URLs", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/"+strings.Repeat("a", 64), nil)
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestURITooLong, w.Code)
+	})
+
+	t.Run("Should reject too many headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Add("X-One", "1")
+		req.Header.Add("X-Two", "2")
+		req.Header.Add("X-Three", "3")
+		req.Header.Add("X-Four", "4")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+	})
+
+	t.Run("Should reject oversized headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Large", strings.Repeat("a", 128))
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+	})
+
+	t.Run("Should reject too many cookies", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Cookie", "a=1; b=2; c=3")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+	})
+
+	t.Run("Should reject too many query parameters", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test?a=1&b=2&c=3", nil)
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricWAFFailures = metrics.NewCounterVec(
+	"waf_failures_total",
+	"The total number of requests the WAF failed to evaluate, by error class and failure mode",
+	[]string{"class", "mode"},
+	metrics.WithAlertAbove(0),
+)
+
+var metricDeadlines = metrics.NewCounterVec(
+	"waf_deadlines_total",
+	"The total number of requests evaluated under a forward-auth budget, by whether the budget was met, exceeded or invalid",
+	[]string{"result"},
+)
+
+var metricBypassedRequests = metrics.NewCounterVec(
+	"waf_bypassed_requests_total",
+	"The total number of requests allowed without being inspected, by reason",
+	[]string{"reason"},
+)
+
+var metricRequestLimitRejections = metrics.NewCounterVec(
+	"waf_request_limit_rejections_total",
+	"The total number of requests rejected before WAF evaluation for exceeding a request limit",
+	[]string{"limit"},
+)
+
+var metricHoneypotHits = metrics.NewCounterVec(
+	"waf_honeypot_hits_total",
+	"The total number of requests for honeypot trap paths, by trap",
+	[]string{"trap"},
+)
+
+var metricIPListDenied = metrics.NewCounter(
+	"waf_ip_list_denied_requests_total",
+	"The total number of requests rejected because the client is on a deny list of the iplists collection",
+)
+
+var metricBannedRequests = metrics.NewCounter(
+	"waf_banned_requests_total",
+	"The total number of requests rejected because the client is banned",
+)
+
+var metricCookieTampering = metrics.NewCounterVec(
+	"waf_cookie_tampering_total",
+	"The total number of requests carrying a cookie with an invalid signature, by cookie and mode",
+	[]string{"cookie", "mode"},
+)
+
+var metricCSRFRejections = metrics.NewCounterVec(
+	"waf_csrf_rejections_total",
+	"The total number of requests rejected by the CSRF check, by reason",
+	[]string{"reason"},
+)
+
+var metricSuspiciousURIs = metrics.NewCounterVec(
+	"waf_suspicious_uris_total",
+	"The total number of requests whose URI only canonicalizes through an evasion technique, by reason and mode",
+	[]string{"reason", "mode"},
+)
+
+var metricProtocolRejections = metrics.NewCounterVec(
+	"waf_protocol_rejections_total",
+	"The total number of requests rejected before WAF evaluation for a method or protocol version that is not allowed",
+	[]string{"reason"},
+)
+
+var metricTenantRequests = metrics.NewCounterVec(
+	"waf_tenant_requests_total",
+	"The total number of requests by tenant and decision (allow, block, quota_exceeded)",
+	[]string{"tenant", "decision"},
+)
+
+var metricTenantBytes = metrics.NewCounterVec(
+	"waf_tenant_request_bytes_total",
+	"The total number of request body bytes forwarded for evaluation by tenant",
+	[]string{"tenant"},
+)
+
+var metricTenantQuotaUsed = metrics.NewGaugeVec(
+	"waf_tenant_quota_used_ratio",
+	"The share of its monthly request quota each tenant has used",
+	[]string{"tenant"},
+)
+
+var metricASNRequests = metrics.NewCounterVec(
+	"waf_asn_policy_requests_total",
+	"The total number of requests matching an ASN policy, by ASN and outcome (allow, deny, limited, rate_limited)",
+	[]string{"asn", "outcome"},
+)
+
+var metricWebhookSignatures = metrics.NewCounterVec(
+	"waf_webhook_signatures_total",
+	"The total number of webhook calls verified, by endpoint and result (valid or the reason they failed)",
+	[]string{"endpoint", "result"},
+)
+
+var metricThreatIntelMatches = metrics.NewCounterVec(
+	"waf_threat_intel_matches_total",
+	"The total number of requests matching a threat intel indicator, by feed and action",
+	[]string{"feed", "action"},
+)
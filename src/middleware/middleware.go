@@ -6,6 +6,9 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
 )
 
 // PanicMiddleware recovers from panics in HTTP handlers
@@ -13,48 +16,43 @@ func PanicMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				slog.Error("Recovered from panic in HTTP handler", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				slog.ErrorContext(logging.WithRequest(r.Context(), r), "Recovered from panic in HTTP handler", "error", err)
+				httperror.Write(w, r, http.StatusInternalServerError, httperror.Body{Code: httperror.CodeInternal})
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-// ProxyHeaderMiddleware processes X-Forwarded-* headers from Traefik
+// ProxyHeaderMiddleware processes X-Forwarded-* headers from Traefik, and the Forwarded header of other proxies.
+// It runs for every request, so it avoids allocating beyond the rewritten RemoteAddr.
 func ProxyHeaderMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			// X-Forwarded-For can contain multiple IPs: "client, proxy1, proxy2"
-			// Take the first one (leftmost) as the original client IP
-			if ips := strings.Split(xff, ","); len(ips) > 0 {
-				clientIP := strings.TrimSpace(ips[0])
-				if clientIP != "" {
-					// Update the request's RemoteAddr to reflect the real client IP
-					// Keep the port from the original RemoteAddr if possible
-					if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-						r.RemoteAddr = net.JoinHostPort(clientIP, port)
-					} else {
-						r.RemoteAddr = clientIP + ":0"
-					}
-				}
+		if clientIP, ok := forwardedClient(r.Header); ok {
+			// Update the request's RemoteAddr to reflect the real client IP
+			// Keep the port from the original RemoteAddr if possible
+			if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				r.RemoteAddr = net.JoinHostPort(clientIP, port)
+			} else {
+				r.RemoteAddr = net.JoinHostPort(clientIP, "0")
 			}
 		}
 
-		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		if proto, ok := forwardedProto(r.Header); ok {
 			r.URL.Scheme = proto
 		}
 
-		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		if host, ok := forwardedHost(r.Header); ok {
 			r.Host = host
 			r.URL.Host = host
 		}
 
-		if uri := r.Header.Get("X-Forwarded-Uri"); uri != "" {
-			r.URL.Path = uri
+		// The URI of the original request is in origin form, anything else would change how the URL is presented
+		if uri := r.Header.Get("X-Forwarded-Uri"); strings.HasPrefix(uri, "/") {
+			r.URL.Path, r.URL.RawPath, r.URL.RawQuery = splitRequestURI(uri)
 		}
 
-		if method := r.Header.Get("X-Forwarded-Method"); method != "" {
+		if method, ok := forwardedMethod(r.Header); ok {
 			r.Method = method
 		}
 
@@ -62,21 +60,34 @@ func ProxyHeaderMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// LoggingMiddleware logs incoming requests
-func LoggingMiddleware(next http.Handler, logLevel slog.Level) http.Handler {
+// LoggingMiddleware writes an access log line for every request. When the logger does not log at logLevel the
+// request is passed straight through without wrapping the response writer.
+func LoggingMiddleware(next http.Handler, logger *slog.Logger, logLevel slog.Level) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logger.Enabled(r.Context(), logLevel) || IsSynthetic(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		start := time.Now()
 		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		ctx, bypass := withBypassRecord(r.Context())
+		ctx, extra := withAccessLogRecord(ctx)
+		r = r.WithContext(ctx)
 		next.ServeHTTP(lrw, r)
-		duration := time.Since(start)
-		slog.Log(r.Context(), logLevel, "HTTP request",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"remote_addr", r.RemoteAddr,
-			"user_agent", r.UserAgent(),
-			"status", lrw.statusCode,
-			"duration_ms", duration.Milliseconds(),
-		)
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+			slog.Int("status", lrw.statusCode),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		}
+		if reason := bypass.get(); reason != "" {
+			attrs = append(attrs, slog.String("bypass", reason))
+		}
+		attrs = append(attrs, extra.get()...)
+		logger.LogAttrs(r.Context(), logLevel, "HTTP request", attrs...)
 	})
 }
 
@@ -1,8 +1,14 @@
 package middleware
 
 import (
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -162,4 +168,205 @@ func TestProxyHeaderMiddleware(t *testing.T) {
 
 		assert.Equal(t, "POST", capturedRequest.Method, "Should update method from X-Forwarded-Method")
 	})
+
+	t.Run("Should split the query from the path header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-Uri", "/search?q=a%26b")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "/search", capturedRequest.URL.Path)
+		assert.Equal(t, "q=a%26b", capturedRequest.URL.RawQuery)
+		assert.Equal(t, "/search?q=a%26b", capturedRequest.URL.RequestURI())
+	})
+
+	t.Run("Should not escape the path header twice", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-Uri", "/files/..%2f..%2fetc/passwd")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "/files/../../etc/passwd", capturedRequest.URL.Path)
+		assert.Equal(t, "/files/..%2f..%2fetc/passwd", capturedRequest.URL.RequestURI(), "Should keep the escaping of the client")
+	})
+
+	t.Run("Should take the client IP from X-Forwarded-For with a port", func(t *testing.T) {
+		for xff, want := range map[string]string{
+			"203.0.113.195:5555":    "203.0.113.195:8080",
+			"[2001:db8::1]:5555":    "[2001:db8::1]:8080",
+			"[2001:db8::1]":         "[2001:db8::1]:8080",
+			"2001:db8::1, 10.0.0.1": "[2001:db8::1]:8080",
+		} {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = "192.168.1.100:8080"
+			req.Header.Set("X-Forwarded-For", xff)
+
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, req)
+
+			assert.Equal(t, want, capturedRequest.RemoteAddr, "X-Forwarded-For: %s", xff)
+		}
+	})
+
+	t.Run("Should ignore invalid proxy headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.100:8080"
+		req.Header.Set("X-Forwarded-For", "unknown, 203.0.113.195")
+		req.Header.Set("X-Forwarded-Proto", "ht tp")
+		req.Header.Set("X-Forwarded-Host", "evil.example/path")
+		req.Header.Set("X-Forwarded-Method", "GET /admin")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "192.168.1.100:8080", capturedRequest.RemoteAddr)
+		assert.Equal(t, "", capturedRequest.URL.Scheme)
+		assert.Equal(t, "example.com", capturedRequest.Host)
+		assert.Equal(t, "GET", capturedRequest.Method)
+	})
+
+	t.Run("Should use the first of several proto and host values", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-Proto", "https, http")
+		req.Header.Set("X-Forwarded-Host", "api.example.com, internal:8080")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "https", capturedRequest.URL.Scheme)
+		assert.Equal(t, "api.example.com", capturedRequest.Host)
+	})
+
+	t.Run("Should process the Forwarded header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.100:8080"
+		req.Header.Set("Forwarded", `for="[2001:db8::1]:4711";proto=https;host=api.example.com, for=198.51.100.178`)
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "[2001:db8::1]:8080", capturedRequest.RemoteAddr)
+		assert.Equal(t, "https", capturedRequest.URL.Scheme)
+		assert.Equal(t, "api.example.com", capturedRequest.Host)
+	})
+
+	t.Run("Should prefer X-Forwarded-For over Forwarded", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.100:8080"
+		req.Header.Set("X-Forwarded-For", "203.0.113.195")
+		req.Header.Set("Forwarded", "for=198.51.100.178")
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, "203.0.113.195:8080", capturedRequest.RemoteAddr)
+	})
+}
+
+// FuzzProxyHeaderMiddleware checks that no proxy headers, which clients can set when Traefik trusts them, make the
+// request inconsistent: the client address stays an IP address and the URL still parses as it is presented
+func FuzzProxyHeaderMiddleware(f *testing.F) {
+	f.Add("203.0.113.195, 198.51.100.178", "", "https", "api.example.com", "/orders/42?expand=items", "POST")
+	f.Add("[2001:db8::1]:5555", "", "http", "example.com:8080", "/a%20b/..%2fc", "GET")
+	f.Add("", `for="[2001:db8::1]:4711";proto=https;host="api.example.com", for=unknown`, "", "", "/?q=%zz", "")
+	f.Add("unknown", `for=_hidden;by="\"`, "ht tp", "a/b@c", "/p#frag?x", "GET /")
+
+	f.Fuzz(func(t *testing.T, xff string, forwarded string, proto string, host string, uri string, method string) {
+		// net/http rejects requests with control characters in a header value
+		for _, value := range []string{xff, forwarded, proto, host, uri, method} {
+			if strings.ContainsFunc(value, func(r rune) bool { return r < ' ' && r != '\t' || r == 0x7f }) {
+				t.Skip()
+			}
+		}
+
+		var captured *http.Request
+		handler := ProxyHeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			captured = r
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.100:8080"
+		req.Header.Set("X-Forwarded-For", xff)
+		req.Header.Set("Forwarded", forwarded)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Uri", uri)
+		req.Header.Set("X-Forwarded-Method", method)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		ip, port, err := net.SplitHostPort(captured.RemoteAddr)
+		if assert.NoError(t, err, "RemoteAddr %q", captured.RemoteAddr) {
+			_, err := netip.ParseAddr(ip)
+			assert.NoError(t, err, "RemoteAddr %q", captured.RemoteAddr)
+			assert.Equal(t, "8080", port)
+		}
+
+		parsed, err := url.ParseRequestURI(captured.URL.RequestURI())
+		if assert.NoError(t, err, "URI %q", captured.URL.RequestURI()) {
+			assert.Equal(t, captured.URL.Path, parsed.Path)
+			assert.Equal(t, captured.URL.RawQuery, parsed.RawQuery)
+		}
+		parsed, err = url.Parse("http://" + captured.Host + "/")
+		if assert.NoError(t, err, "Host %q", captured.Host) {
+			assert.Equal(t, captured.Host, parsed.Host)
+		}
+		assert.NotContains(t, captured.Method, " ")
+	})
+}
+
+// forwardAuthRequest builds a request as Traefik's forwardAuth middleware sends it
+func forwardAuthRequest() *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:41234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.195, 198.51.100.178")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/orders/42")
+	req.Header.Set("X-Forwarded-Method", "POST")
+	return req
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func BenchmarkProxyHeaderMiddleware(b *testing.B) {
+	handler := ProxyHeaderMiddleware(okHandler)
+	req := forwardAuthRequest()
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	for range b.N {
+		req.RemoteAddr = "10.0.0.2:41234"
+		handler.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkLoggingMiddleware(b *testing.B) {
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		b.Run("handler level "+level.String(), func(b *testing.B) {
+			logger := slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: level}))
+			handler := LoggingMiddleware(okHandler, logger, slog.LevelDebug)
+			req := forwardAuthRequest()
+			w := httptest.NewRecorder()
+
+			b.ReportAllocs()
+			for range b.N {
+				handler.ServeHTTP(w, req)
+			}
+		})
+	}
+}
+
+func BenchmarkFailurePolicyMiddleware(b *testing.B) {
+	handler := FailurePolicyMiddleware(okHandler, FailurePolicy{Default: FailClosed})
+	req := forwardAuthRequest()
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	for range b.N {
+		handler.ServeHTTP(w, req)
+	}
 }
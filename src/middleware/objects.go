@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+)
+
+// BypassTokenHeader carries a token of the bypass_tokens collection
+const BypassTokenHeader = "X-Waf-Bypass-Token"
+
+// StoredObjectsMiddleware enforces the IP lists and bypass tokens managed through the admin API. Clients on a deny
+// list are rejected; clients on an allow list and requests presenting an unexpired bypass token are allowed without
+// being inspected.
+func StoredObjectsMiddleware(next http.Handler, objects *store.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enforced := objects.Enforced()
+		if ip, err := netip.ParseAddr(ClientIP(r)); err == nil {
+			if action, key, ok := enforced.MatchIP(ip); ok {
+				AddAccessLogAttrs(r, slog.String("ip_list", key))
+				if action == store.IPListDeny {
+					metricIPListDenied.Inc()
+					httperror.Write(w, r, http.StatusForbidden, httperror.Body{Code: httperror.CodeIPDenied})
+					return
+				}
+				MarkBypassed(r, BypassIPAllowList)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		if key, ok := enforced.Token(r.Header.Get(BypassTokenHeader), time.Now()); ok {
+			AddAccessLogAttrs(r, slog.String("bypass_token", key))
+			MarkBypassed(r, BypassToken)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredObjectsMiddleware(t *testing.T) {
+	objects, err := store.New("")
+	require.NoError(t, err)
+	require.NoError(t, objects.Put(store.CollectionIPLists, "office", json.RawMessage(`{"action":"allow","cidrs":["192.0.2.0/24"]}`)))
+	require.NoError(t, objects.Put(store.CollectionIPLists, "abuser", json.RawMessage(`{"action":"deny","cidrs":["198.51.100.7"]}`)))
+	require.NoError(t, objects.Put(store.CollectionBypassTokens, "ci", json.RawMessage(`{"token":"0123456789abcdef"}`)))
+	inspected := 0
+	handler := StoredObjectsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inspected++
+		w.WriteHeader(http.StatusForbidden)
+	}), objects)
+
+	serve := func(remoteAddr string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set(BypassTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should deny the clients of deny lists", func(t *testing.T) {
+		w := serve("198.51.100.7:1234", "0123456789abcdef")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "waf.ip_denied")
+		assert.Equal(t, 0, inspected)
+	})
+
+	t.Run("Should let the clients of allow lists through without inspection", func(t *testing.T) {
+		before := testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassIPAllowList))
+		assert.Equal(t, http.StatusOK, serve("192.0.2.10:1234", "").Code)
+		assert.Equal(t, 0, inspected)
+		assert.Equal(t, before+1, testutil.ToFloat64(metricBypassedRequests.WithLabelValues(BypassIPAllowList)))
+	})
+
+	t.Run("Should let requests with a bypass token through without inspection", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("203.0.113.1:1234", "0123456789abcdef").Code)
+		assert.Equal(t, 0, inspected)
+	})
+
+	t.Run("Should inspect other requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve("203.0.113.1:1234", "wrong-token-0000").Code)
+		assert.Equal(t, 1, inspected)
+	})
+
+	t.Run("Should enforce changes to the store at once", func(t *testing.T) {
+		require.NoError(t, objects.Delete(store.CollectionIPLists, "office"))
+		assert.Equal(t, http.StatusForbidden, serve("192.0.2.10:1234", "").Code)
+		assert.Equal(t, 2, inspected)
+	})
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// ProtocolPolicy restricts the methods and HTTP versions of requests before they reach the WAF
+type ProtocolPolicy struct {
+	// Methods are allowed for every path. No methods allows any method.
+	Methods []string
+	// Routes override Methods for paths under a prefix. The longest matching prefix wins.
+	Routes []RouteMethods
+	// Versions are the allowed protocol versions, e.g. HTTP/1.1. No versions allows any version.
+	Versions []string
+}
+
+// RouteMethods are the methods allowed for paths under Prefix
+type RouteMethods struct {
+	Prefix  string
+	Methods []string
+}
+
+// Empty reports whether the policy allows every request
+func (p ProtocolPolicy) Empty() bool {
+	return len(p.Methods) == 0 && len(p.Routes) == 0 && len(p.Versions) == 0
+}
+
+// methods returns the methods allowed for path, or nil if any method is allowed
+func (p ProtocolPolicy) methods(path string) []string {
+	var route *RouteMethods
+	for i, candidate := range p.Routes {
+		if strings.HasPrefix(path, candidate.Prefix) && (route == nil || len(candidate.Prefix) > len(route.Prefix)) {
+			route = &p.Routes[i]
+		}
+	}
+	if route != nil {
+		return route.Methods
+	}
+	return p.Methods
+}
+
+// ProtocolPolicyMiddleware rejects requests using a method that is not allowed for their path with 405, and
+// requests using a protocol version that is not allowed with 505
+func ProtocolPolicyMiddleware(next http.Handler, policy ProtocolPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(policy.Versions) > 0 && !slices.Contains(policy.Versions, r.Proto) {
+			metricProtocolRejections.WithLabelValues("version").Inc()
+			slog.Debug("Request rejected by protocol policy", "proto", r.Proto, "remote_addr", r.RemoteAddr)
+			httperror.Write(w, r, http.StatusHTTPVersionNotSupported, httperror.Body{Code: httperror.CodeProtocolNotAllowed, Message: r.Proto + " is not supported"})
+			return
+		}
+
+		// X-Forwarded-Uri may carry the query string in the path
+		path, _, _ := strings.Cut(r.URL.Path, "?")
+		if methods := policy.methods(path); len(methods) > 0 && !slices.Contains(methods, r.Method) {
+			metricProtocolRejections.WithLabelValues("method").Inc()
+			slog.Debug("Request rejected by protocol policy", "method", r.Method, "path", path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			httperror.Write(w, r, http.StatusMethodNotAllowed, httperror.Body{Code: httperror.CodeProtocolNotAllowed, Message: "method " + r.Method + " is not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
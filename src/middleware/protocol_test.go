@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocolPolicyMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	middleware := ProtocolPolicyMiddleware(testHandler, ProtocolPolicy{
+		Methods: []string{"GET", "HEAD"},
+		Routes: []RouteMethods{
+			{Prefix: "/api/", Methods: []string{"GET", "POST"}},
+			{Prefix: "/api/admin/", Methods: []string{"GET", "POST", "DELETE"}},
+		},
+		Versions: []string{"HTTP/1.1", "HTTP/2.0"},
+	})
+	serve := func(method string, target string, proto string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Proto = proto
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should allow permitted methods and versions", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("GET", "/", "HTTP/1.1").Code)
+		assert.Equal(t, http.StatusOK, serve("POST", "/api/orders", "HTTP/2.0").Code)
+		assert.Equal(t, http.StatusOK, serve("DELETE", "/api/admin/users/1", "HTTP/1.1").Code)
+	})
+
+	t.Run("Should reject methods not allowed for the route", func(t *testing.T) {
+		w := serve("POST", "/login", "HTTP/1.1")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+
+		w = serve("DELETE", "/api/orders?id=1", "HTTP/1.1")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, POST", w.Header().Get("Allow"))
+	})
+
+	t.Run("Should reject versions that are not allowed", func(t *testing.T) {
+		w := serve("GET", "/", "HTTP/1.0")
+		assert.Equal(t, http.StatusHTTPVersionNotSupported, w.Code)
+		assert.Contains(t, w.Body.String(), "HTTP/1.0 is not supported")
+	})
+
+	t.Run("Should allow any method without a policy", func(t *testing.T) {
+		assert.True(t, ProtocolPolicy{}.Empty())
+		assert.Nil(t, ProtocolPolicy{}.methods("/"))
+	})
+}
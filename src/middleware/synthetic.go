@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+type syntheticKey struct{}
+
+// WithSynthetic marks the requests of in-process checks, such as the self-test and the FTW regression tests. They are
+// evaluated like any other request but kept out of the audit log, the access log, the metrics and the tenant quotas,
+// so running a check doesn't show up as attack traffic.
+func WithSynthetic(ctx context.Context) context.Context {
+	return context.WithValue(ctx, syntheticKey{}, true)
+}
+
+// IsSynthetic reports whether the request was marked by WithSynthetic
+func IsSynthetic(r *http.Request) bool {
+	synthetic, _ := r.Context().Value(syntheticKey{}).(bool)
+	return synthetic
+}
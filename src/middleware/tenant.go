@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// DefaultMaxTenants bounds the tenants counted under their own label
+const DefaultMaxTenants = 100
+
+// DefaultTenantUsageSaveInterval is how often the quota usage is written to its file
+const DefaultTenantUsageSaveInterval = time.Minute
+
+// otherTenant labels the requests of tenants past the maximum
+const otherTenant = "other"
+
+// TenantOptions configures per-tenant accounting of the requests sharing the WAF
+type TenantOptions struct {
+	// Header names the request header identifying the tenant, set by Traefik or a request script. Empty disables
+	// accounting.
+	Header string
+	// MonthlyQuotas caps the requests per calendar month (UTC) of each tenant, "*" applying to tenants not listed.
+	// Tenants without a quota are not capped.
+	MonthlyQuotas map[string]int64
+	// MaxTenants is the number of tenants counted under their own label; the requests of later tenants are counted
+	// as "other". Zero uses DefaultMaxTenants.
+	MaxTenants int
+	// UsagePath is the JSON file the quota usage of the month is saved to and restored from on startup. Empty keeps
+	// usage in memory only, starting over when the process restarts.
+	UsagePath string
+	// SaveInterval is how often the usage is saved. Zero uses DefaultTenantUsageSaveInterval.
+	SaveInterval time.Duration
+}
+
+// tenantUsage is the content of the usage file
+type tenantUsage struct {
+	Month time.Time        `json:"month"`
+	Used  map[string]int64 `json:"used"`
+}
+
+// TenantAccounting counts the requests of every tenant and enforces their monthly quotas. Usage is counted per
+// replica and, with a usage file, saved periodically and at shutdown so a restart resumes it.
+type TenantAccounting struct {
+	options TenantOptions
+	now     func() time.Time
+	ctx     context.Context
+	stop    context.CancelFunc
+	jobDone chan struct{}
+
+	mu    sync.Mutex
+	month time.Time
+	// used is the number of requests of each tenant with a quota this month. Tenants past the maximum that are not
+	// listed in the quotas share the "*" quota under "other", so rotating the header neither escapes the quota nor
+	// grows the map.
+	used map[string]int64
+	// labels are the tenants counted under their own label
+	labels map[string]struct{}
+}
+
+// NewTenantAccounting returns the accounting of the tenants of the options
+func NewTenantAccounting(options TenantOptions) *TenantAccounting {
+	if options.MaxTenants <= 0 {
+		options.MaxTenants = DefaultMaxTenants
+	}
+	if options.SaveInterval <= 0 {
+		options.SaveInterval = DefaultTenantUsageSaveInterval
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &TenantAccounting{
+		options: options,
+		now:     time.Now,
+		ctx:     ctx,
+		stop:    stop,
+		jobDone: make(chan struct{}),
+		used:    map[string]int64{},
+		labels:  map[string]struct{}{},
+	}
+}
+
+// Load restores the usage saved to the usage file. Usage saved in an earlier month is ignored, and a missing file
+// is not an error.
+func (a *TenantAccounting) Load() error {
+	if a.options.UsagePath == "" {
+		return nil
+	}
+	content, err := os.ReadFile(a.options.UsagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tenant usage: %w", err)
+	}
+	var usage tenantUsage
+	if err := json.Unmarshal(content, &usage); err != nil {
+		return fmt.Errorf("failed to decode tenant usage: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if month := currentMonth(a.now()); !usage.Month.Equal(month) {
+		return nil
+	}
+	a.month = usage.Month
+	for tenant, used := range usage.Used {
+		a.used[tenant] = used
+		if _, listed := a.options.MonthlyQuotas[tenant]; !listed && tenant != otherTenant && len(a.labels) < a.options.MaxTenants {
+			a.labels[tenant] = struct{}{}
+		}
+		if quota := a.quota(tenant); quota > 0 {
+			metricTenantQuotaUsed.WithLabelValues(tenant).Set(float64(used) / float64(quota))
+		}
+	}
+	return nil
+}
+
+// Save atomically writes the usage of the month to the usage file
+func (a *TenantAccounting) Save() error {
+	if a.options.UsagePath == "" {
+		return nil
+	}
+	a.mu.Lock()
+	content, err := json.Marshal(tenantUsage{Month: a.month, Used: a.used})
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode tenant usage: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.options.UsagePath), filepath.Base(a.options.UsagePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary tenant usage file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write tenant usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write tenant usage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.options.UsagePath); err != nil {
+		return fmt.Errorf("failed to replace tenant usage: %w", err)
+	}
+	return nil
+}
+
+// Start saves the usage on the save interval until Stop is called
+func (a *TenantAccounting) Start() {
+	defer close(a.jobDone)
+	if a.options.UsagePath == "" {
+		return
+	}
+	ticker := time.NewTicker(a.options.SaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				slog.Error("Failed to save tenant usage", "error", err, "path", a.options.UsagePath)
+			}
+		}
+	}
+}
+
+// Stop stops the save job, waits for it to finish and saves the usage a last time
+func (a *TenantAccounting) Stop(ctx context.Context) error {
+	a.stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.jobDone:
+		return a.Save()
+	}
+}
+
+// currentMonth returns the start of the calendar month (UTC) of the time
+func currentMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// quota returns the monthly quota of the tenant, zero when it is not capped
+func (a *TenantAccounting) quota(tenant string) int64 {
+	if quota, ok := a.options.MonthlyQuotas[tenant]; ok {
+		return quota
+	}
+	return a.options.MonthlyQuotas["*"]
+}
+
+// admit counts a request of the tenant, returning its label, and whether it is within the tenant's quota with
+// the quota and the end of the month
+func (a *TenantAccounting) admit(tenant string) (string, bool, int64, time.Time) {
+	month := currentMonth(a.now())
+	end := month.AddDate(0, 1, 0)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !month.Equal(a.month) {
+		a.month = month
+		clear(a.used)
+	}
+
+	label := tenant
+	if _, ok := a.labels[tenant]; !ok {
+		if len(a.labels) < a.options.MaxTenants {
+			a.labels[tenant] = struct{}{}
+		} else {
+			label = otherTenant
+		}
+	}
+
+	key := tenant
+	if _, listed := a.options.MonthlyQuotas[tenant]; !listed && label == otherTenant {
+		key = otherTenant
+	}
+	quota := a.quota(key)
+	if quota == 0 {
+		return label, true, 0, end
+	}
+	if a.used[key] >= quota {
+		return label, false, quota, end
+	}
+	a.used[key]++
+	metricTenantQuotaUsed.WithLabelValues(label).Set(float64(a.used[key]) / float64(quota))
+	return label, true, quota, end
+}
+
+// TenantMiddleware counts the requests, bytes and blocks of every tenant, and answers 429 to the requests of a
+// tenant over its monthly quota without evaluating them. Requests without the tenant header, and synthetic requests,
+// are not accounted.
+func TenantMiddleware(next http.Handler, accounting *TenantAccounting) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(accounting.options.Header)
+		if tenant == "" || IsSynthetic(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		label, ok, quota, end := accounting.admit(tenant)
+		if r.ContentLength > 0 {
+			metricTenantBytes.WithLabelValues(label).Add(float64(r.ContentLength))
+		}
+		if !ok {
+			metricTenantRequests.WithLabelValues(label, "quota_exceeded").Inc()
+			now := accounting.now()
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", quota, int64(end.Sub(end.AddDate(0, -1, 0)).Seconds())))
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(end.Sub(now).Seconds())), 10))
+			httperror.Write(w, r, http.StatusTooManyRequests, httperror.Body{Code: httperror.CodeTenantQuota, Message: "tenant " + tenant + " is over its monthly quota"})
+			return
+		}
+
+		recorder := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		decision := "allow"
+		if recorder.statusCode != http.StatusOK {
+			decision = "block"
+		}
+		metricTenantRequests.WithLabelValues(label, decision).Inc()
+	})
+}
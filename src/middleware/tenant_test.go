@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "attack") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	accounting := NewTenantAccounting(TenantOptions{
+		Header:        "X-Tenant",
+		MonthlyQuotas: map[string]int64{"acme": 2, "*": 100},
+		MaxTenants:    2,
+	})
+	now := time.Date(2024, time.February, 29, 23, 0, 0, 0, time.UTC)
+	accounting.now = func() time.Time { return now }
+	middleware := TenantMiddleware(testHandler, accounting)
+
+	serve := func(tenant string, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader("body"))
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should count requests, blocks and bytes by tenant", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("acme", "/").Code)
+		assert.Equal(t, http.StatusForbidden, serve("acme", "/?attack").Code)
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("acme", "allow")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("acme", "block")))
+		assert.Equal(t, 8.0, testutil.ToFloat64(metricTenantBytes.WithLabelValues("acme")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantQuotaUsed.WithLabelValues("acme")))
+	})
+
+	t.Run("Should answer 429 over the monthly quota", func(t *testing.T) {
+		w := serve("acme", "/")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2;w=2505600", w.Header().Get("RateLimit-Policy"), "Expected the quota over the 29 days of February")
+		assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), "waf.tenant_quota_exceeded")
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("acme", "quota_exceeded")))
+	})
+
+	t.Run("Should reset the quota every month", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		assert.Equal(t, http.StatusOK, serve("acme", "/").Code)
+	})
+
+	t.Run("Should count tenants past the maximum as other", func(t *testing.T) {
+		serve("globex", "/")
+		serve("initech", "/")
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("globex", "allow")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("other", "allow")))
+	})
+
+	t.Run("Should share the quota of tenants past the maximum", func(t *testing.T) {
+		accounting := NewTenantAccounting(TenantOptions{Header: "X-Tenant", MonthlyQuotas: map[string]int64{"*": 5}, MaxTenants: 10})
+		middleware := TenantMiddleware(testHandler, accounting)
+		rejected := 0
+		for i := range 1000 {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Tenant", fmt.Sprintf("tenant-%d", i))
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, req)
+			if w.Code == http.StatusTooManyRequests {
+				rejected++
+			}
+		}
+		assert.Equal(t, 1000-10-5, rejected, "Expected the tenants past the maximum to share one quota")
+		assert.Len(t, accounting.used, 11)
+	})
+
+	t.Run("Should not account requests without a tenant", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("", "/").Code)
+		assert.Equal(t, 0.0, testutil.ToFloat64(metricTenantRequests.WithLabelValues("", "allow")))
+	})
+}
+
+func TestTenantUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	options := TenantOptions{Header: "X-Tenant", MonthlyQuotas: map[string]int64{"acme": 2}, UsagePath: path}
+	now := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	open := func() (*TenantAccounting, http.Handler) {
+		accounting := NewTenantAccounting(options)
+		accounting.now = func() time.Time { return now }
+		assert.NoError(t, accounting.Load())
+		return accounting, TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), accounting)
+	}
+	serve := func(handler http.Handler) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", "acme")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Should resume the usage of the month after a restart", func(t *testing.T) {
+		accounting, handler := open()
+		go accounting.Start()
+		assert.Equal(t, http.StatusOK, serve(handler))
+		assert.Equal(t, http.StatusOK, serve(handler))
+		assert.NoError(t, accounting.Stop(context.Background()))
+
+		_, handler = open()
+		assert.Equal(t, http.StatusTooManyRequests, serve(handler))
+	})
+
+	t.Run("Should ignore the usage of an earlier month", func(t *testing.T) {
+		now = now.AddDate(0, 1, 0)
+		_, handler := open()
+		assert.Equal(t, http.StatusOK, serve(handler))
+	})
+
+	t.Run("Should fail to load a corrupt usage file", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+		assert.Error(t, NewTenantAccounting(options).Load())
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+)
+
+// DefaultThreatIntelHeader carries the name of the alerting threat intel feed a request matched
+const DefaultThreatIntelHeader = "X-Waf-Threat-Intel"
+
+// ThreatIntelMatcher matches requests against threat intel indicators, such as threatintel.Client
+type ThreatIntelMatcher interface {
+	Match(ip netip.Addr, host string, path string, rawQuery string) (threatintel.Match, bool)
+}
+
+// ThreatIntelOptions configures the matching of requests against threat intel indicators
+type ThreatIntelOptions struct {
+	// Matcher holds the indicators. Nil disables matching.
+	Matcher ThreatIntelMatcher
+	// Header is set to the feed name of requests matching an alerting feed, so rules can key on it.
+	// DefaultThreatIntelHeader when empty.
+	Header string
+}
+
+// ThreatIntelMiddleware matches the client IP and URL of requests against the indicators, rejecting the requests
+// matching a blocking feed and flagging those matching an alerting feed. The header sent by the client is always
+// removed.
+func ThreatIntelMiddleware(next http.Handler, options ThreatIntelOptions) http.Handler {
+	header := options.Header
+	if header == "" {
+		header = DefaultThreatIntelHeader
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(header)
+		ip, _ := netip.ParseAddr(ClientIP(r))
+		match, ok := options.Matcher.Match(ip, r.Host, r.URL.EscapedPath(), r.URL.RawQuery)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricThreatIntelMatches.WithLabelValues(match.Feed, match.Action).Inc()
+		AddAccessLogAttrs(r, slog.String("threat_intel_feed", match.Feed), slog.String("threat_intel_indicator", match.IndicatorID))
+		if match.Action == threatintel.ActionBlock {
+			httperror.Write(w, r, http.StatusForbidden, httperror.Body{Code: httperror.CodeThreatIntel})
+			return
+		}
+		r.Header.Set(header, match.Feed)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeThreatIntel map[string]threatintel.Match
+
+func (f fakeThreatIntel) Match(ip netip.Addr, host string, path string, rawQuery string) (threatintel.Match, bool) {
+	match, ok := f[ip.String()]
+	return match, ok
+}
+
+func TestThreatIntelMiddleware(t *testing.T) {
+	intel := fakeThreatIntel{
+		"198.51.100.1": {Feed: "abuse", Action: threatintel.ActionBlock, IndicatorID: "indicator--1"},
+		"198.51.100.2": {Feed: "partner", Action: threatintel.ActionAlert, IndicatorID: "indicator--2"},
+	}
+	var flagged string
+	handler := ThreatIntelMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flagged = r.Header.Get(DefaultThreatIntelHeader)
+		w.WriteHeader(http.StatusOK)
+	}), ThreatIntelOptions{Matcher: intel})
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(DefaultThreatIntelHeader, "forged")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should reject requests matching a blocking feed", func(t *testing.T) {
+		w := serve("198.51.100.1:1234")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "waf.threat_intel")
+	})
+
+	t.Run("Should flag requests matching an alerting feed", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("198.51.100.2:1234").Code)
+		assert.Equal(t, "partner", flagged)
+	})
+
+	t.Run("Should remove the header sent by the client", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234").Code)
+		assert.Empty(t, flagged)
+	})
+}
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature schemes of webhook endpoints
+const (
+	// WebhookGitHub verifies X-Hub-Signature-256, the HMAC-SHA256 of the body, and takes the signature as the nonce.
+	// X-GitHub-Delivery is required but not signed, so it cannot tell a replay from a new call.
+	WebhookGitHub = "github"
+	// WebhookStripe verifies Stripe-Signature, the HMAC-SHA256 of the timestamp and body, within the timestamp
+	// tolerance and takes the signature as the nonce
+	WebhookStripe = "stripe"
+)
+
+// WebhookSignatureHeader is set to the reason the signature of a webhook call failed verification, for the rule
+// denying it. The header sent by the client is always removed.
+const WebhookSignatureHeader = "X-Waf-Webhook-Signature"
+
+// WebhookEndpoint is a webhook path whose calls must be signed with the secret
+type WebhookEndpoint struct {
+	// Prefix is the path prefix of the endpoint
+	Prefix string
+	Scheme string
+	Secret []byte
+}
+
+// WebhookOptions configures the signature verification of webhook endpoints
+type WebhookOptions struct {
+	// Endpoints are matched in order by their prefix. No endpoints disables verification.
+	Endpoints []WebhookEndpoint
+	// Tolerance is how far the timestamp of a signature may be from now, and how long a nonce is remembered for
+	Tolerance time.Duration
+	// NonceCacheSize bounds the nonces remembered; the oldest are forgotten when it is full
+	NonceCacheSize int
+	// MaxBodySize is the largest body that is verified. Larger bodies fail verification.
+	MaxBodySize int64
+}
+
+// ParseWebhookEndpoints parses one endpoint per line, "/prefix scheme SECRET_VAR" where the scheme is github or
+// stripe and SECRET_VAR names the environment variable holding the secret, which lookup reads
+func ParseWebhookEndpoints(value string, lookup func(string) string) ([]WebhookEndpoint, error) {
+	var endpoints []WebhookEndpoint
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid webhook endpoint %q, expected \"/prefix scheme SECRET_VAR\"", line)
+		}
+		if !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("invalid path prefix %q in webhook endpoint %q", fields[0], line)
+		}
+		if fields[1] != WebhookGitHub && fields[1] != WebhookStripe {
+			return nil, fmt.Errorf("invalid scheme %q in webhook endpoint %q, expected %q or %q", fields[1], line, WebhookGitHub, WebhookStripe)
+		}
+		secret := lookup(fields[2])
+		if secret == "" {
+			return nil, fmt.Errorf("environment variable %s holding the secret of webhook endpoint %q is not set", fields[2], fields[0])
+		}
+		endpoints = append(endpoints, WebhookEndpoint{Prefix: fields[0], Scheme: fields[1], Secret: []byte(secret)})
+	}
+	return endpoints, nil
+}
+
+// nonceEntry is a nonce and when it is forgotten
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// WebhookVerifier verifies the signatures of webhook calls and rejects the replay of a call it has seen
+type WebhookVerifier struct {
+	options WebhookOptions
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	// order lists the nonces oldest first, which is also the order they expire in
+	order []nonceEntry
+}
+
+func NewWebhookVerifier(options WebhookOptions) *WebhookVerifier {
+	return &WebhookVerifier{options: options, now: time.Now, nonces: map[string]time.Time{}}
+}
+
+// remember records the nonce, reporting false if it was seen within the tolerance
+func (v *WebhookVerifier) remember(nonce string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	for len(v.order) > 0 && (!v.order[0].expires.After(now) || len(v.order) >= v.options.NonceCacheSize) {
+		if v.nonces[v.order[0].nonce] == v.order[0].expires {
+			delete(v.nonces, v.order[0].nonce)
+		}
+		v.order = v.order[1:]
+	}
+	if expires, ok := v.nonces[nonce]; ok && expires.After(now) {
+		return false
+	}
+	expires := now.Add(v.options.Tolerance)
+	v.nonces[nonce] = expires
+	v.order = append(v.order, nonceEntry{nonce: nonce, expires: expires})
+	return true
+}
+
+// verify returns why the call fails verification, or an empty string when it is signed and not replayed
+func (v *WebhookVerifier) verify(endpoint WebhookEndpoint, r *http.Request, body []byte) string {
+	var nonce string
+	switch endpoint.Scheme {
+	case WebhookGitHub:
+		signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return "missing_signature"
+		}
+		if !validWebhookSignature(endpoint.Secret, body, signature) {
+			return "invalid_signature"
+		}
+		if r.Header.Get("X-GitHub-Delivery") == "" {
+			return "missing_delivery"
+		}
+		nonce = signature
+	case WebhookStripe:
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(part, "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				signatures = append(signatures, value)
+			}
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || len(signatures) == 0 {
+			return "missing_signature"
+		}
+		signed := append([]byte(timestamp+"."), body...)
+		for _, signature := range signatures {
+			if validWebhookSignature(endpoint.Secret, signed, signature) {
+				nonce = signature
+			}
+		}
+		if nonce == "" {
+			return "invalid_signature"
+		}
+		if age := v.now().Sub(time.Unix(seconds, 0)); age > v.options.Tolerance || age < -v.options.Tolerance {
+			return "expired_timestamp"
+		}
+	}
+	if !v.remember(endpoint.Prefix + " " + nonce) {
+		return "replayed"
+	}
+	return ""
+}
+
+func validWebhookSignature(secret []byte, payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// WebhookSignatureMiddleware verifies the calls to webhook endpoints, setting WebhookSignatureHeader to the reason
+// a call fails so the rule added for it denies the call and records it in the audit log. The body is read for the
+// signature and handed on unchanged.
+func WebhookSignatureMiddleware(next http.Handler, verifier *WebhookVerifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(WebhookSignatureHeader)
+		var endpoint *WebhookEndpoint
+		for i := range verifier.options.Endpoints {
+			if strings.HasPrefix(r.URL.Path, verifier.options.Endpoints[i].Prefix) {
+				endpoint = &verifier.options.Endpoints[i]
+				break
+			}
+		}
+		if endpoint == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var reason string
+		body, err := io.ReadAll(io.LimitReader(r.Body, verifier.options.MaxBodySize+1))
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		switch {
+		case err != nil:
+			reason = "unreadable_body"
+		case int64(len(body)) > verifier.options.MaxBodySize:
+			reason = "body_too_large"
+		default:
+			reason = verifier.verify(*endpoint, r, body)
+		}
+		if reason == "" {
+			metricWebhookSignatures.WithLabelValues(endpoint.Prefix, "valid").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricWebhookSignatures.WithLabelValues(endpoint.Prefix, reason).Inc()
+		slog.Warn("Webhook signature verification failed", "reason", reason, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		r.Header.Set(WebhookSignatureHeader, reason)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readCloser reads the body read ahead and then the rest, closing the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookSignature(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhookEndpoints(t *testing.T) {
+	lookup := func(name string) string { return map[string]string{"GITHUB_SECRET": "gh", "STRIPE_SECRET": "st"}[name] }
+	endpoints, err := ParseWebhookEndpoints("/hooks/github github GITHUB_SECRET\n\n/hooks/stripe stripe STRIPE_SECRET", lookup)
+	require.NoError(t, err)
+	assert.Equal(t, []WebhookEndpoint{
+		{Prefix: "/hooks/github", Scheme: WebhookGitHub, Secret: []byte("gh")},
+		{Prefix: "/hooks/stripe", Scheme: WebhookStripe, Secret: []byte("st")},
+	}, endpoints)
+
+	for _, invalid := range []string{"/hooks github", "hooks github GITHUB_SECRET", "/hooks slack GITHUB_SECRET", "/hooks github UNSET_SECRET"} {
+		_, err := ParseWebhookEndpoints(invalid, lookup)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestWebhookSignatureMiddleware(t *testing.T) {
+	verifier := NewWebhookVerifier(WebhookOptions{
+		Endpoints: []WebhookEndpoint{
+			{Prefix: "/hooks/github", Scheme: WebhookGitHub, Secret: []byte("gh")},
+			{Prefix: "/hooks/stripe", Scheme: WebhookStripe, Secret: []byte("st")},
+		},
+		Tolerance:      5 * time.Minute,
+		NonceCacheSize: 100,
+		MaxBodySize:    64,
+	})
+	now := time.Unix(1700000000, 0)
+	verifier.now = func() time.Time { return now }
+	var reason, body string
+	handler := WebhookSignatureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = r.Header.Get(WebhookSignatureHeader)
+		read, _ := io.ReadAll(r.Body)
+		body = string(read)
+	}), verifier)
+
+	serve := func(path string, payload string, headers map[string]string) string {
+		req := httptest.NewRequest("POST", path, strings.NewReader(payload))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, payload, body, "Expected the body to be handed on unchanged")
+		return reason
+	}
+
+	t.Run("Should pass GitHub calls signed with the secret once", func(t *testing.T) {
+		headers := map[string]string{"X-Hub-Signature-256": "sha256=" + webhookSignature("gh", `{"a":1}`), "X-GitHub-Delivery": "d1"}
+		assert.Empty(t, serve("/hooks/github", `{"a":1}`, headers))
+		assert.Equal(t, "replayed", serve("/hooks/github", `{"a":1}`, headers))
+
+		headers["X-GitHub-Delivery"] = "d2"
+		assert.Equal(t, "replayed", serve("/hooks/github", `{"a":1}`, headers), "Expected a new delivery ID not to hide the replay")
+		delete(headers, "X-GitHub-Delivery")
+		assert.Equal(t, "missing_delivery", serve("/hooks/github", `{"a":1}`, headers))
+	})
+
+	t.Run("Should mark forged GitHub calls", func(t *testing.T) {
+		assert.Equal(t, "missing_signature", serve("/hooks/github", `{}`, nil))
+		headers := map[string]string{"X-Hub-Signature-256": "sha256=" + webhookSignature("other", `{}`)}
+		assert.Equal(t, "invalid_signature", serve("/hooks/github", `{}`, headers))
+	})
+
+	t.Run("Should verify the Stripe timestamp within the tolerance", func(t *testing.T) {
+		sign := func(at time.Time, payload string) map[string]string {
+			timestamp := strconv.FormatInt(at.Unix(), 10)
+			return map[string]string{"Stripe-Signature": "t=" + timestamp + ",v1=00,v1=" + webhookSignature("st", timestamp+"."+payload)}
+		}
+		assert.Empty(t, serve("/hooks/stripe", `{"b":2}`, sign(now.Add(-time.Minute), `{"b":2}`)))
+		assert.Equal(t, "replayed", serve("/hooks/stripe", `{"b":2}`, sign(now.Add(-time.Minute), `{"b":2}`)))
+		assert.Equal(t, "expired_timestamp", serve("/hooks/stripe", `{"b":3}`, sign(now.Add(-time.Hour), `{"b":3}`)))
+		assert.Equal(t, "invalid_signature", serve("/hooks/stripe", `{"b":4}`, sign(now, `{"b":5}`)))
+	})
+
+	t.Run("Should mark bodies over the maximum size", func(t *testing.T) {
+		assert.Equal(t, "body_too_large", serve("/hooks/github", strings.Repeat("x", 65), nil))
+	})
+
+	t.Run("Should remove the header sent by the client from other paths", func(t *testing.T) {
+		assert.Empty(t, serve("/other", "", map[string]string{WebhookSignatureHeader: "forged"}))
+	})
+}
+
+func TestWebhookNonceExpiry(t *testing.T) {
+	verifier := NewWebhookVerifier(WebhookOptions{Tolerance: time.Minute, NonceCacheSize: 2})
+	now := time.Unix(1700000000, 0)
+	verifier.now = func() time.Time { return now }
+
+	assert.True(t, verifier.remember("a"))
+	assert.False(t, verifier.remember("a"))
+	now = now.Add(2 * time.Minute)
+	assert.True(t, verifier.remember("a"), "Expected the nonce to be forgotten after the tolerance")
+
+	assert.True(t, verifier.remember("b"))
+	assert.True(t, verifier.remember("c"))
+	assert.True(t, verifier.remember("a"), "Expected the oldest nonce to be forgotten when the cache is full")
+}
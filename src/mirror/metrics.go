@@ -0,0 +1,19 @@
+package mirror
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricMirrorRequests = metrics.NewCounterVec(
+	"waf_mirror_requests_total",
+	"The total number of allowed requests handled by the shadow backend mirror, by result",
+	[]string{"result"},
+)
+
+var metricMirrorDuration = metrics.NewHistogramVec(
+	"waf_mirror_request_duration_seconds",
+	"The time taken by the shadow backend to answer mirrored requests",
+	prometheus.DefBuckets,
+	nil,
+).WithLabelValues()
@@ -0,0 +1,234 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/pool"
+)
+
+const (
+	// queueSize bounds the requests waiting to be mirrored; requests are dropped when it is full
+	queueSize = 256
+	workers   = 4
+	// maxBodyBytes is the largest request body that is mirrored
+	maxBodyBytes = 1 << 20
+)
+
+// bodyBuffers hold the teed request bodies until the mirrored request has been sent
+var bodyBuffers = pool.New("mirror_body",
+	func() *cappedBuffer { return &cappedBuffer{max: maxBodyBytes} },
+	func(b *cappedBuffer) { b.Reset(); b.overflow = false },
+)
+
+// hopHeaders are not copied to the mirrored request
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// credentialHeaders carry the client's credentials and are never sent to the shadow backend, which may be a honeypot
+// or a third party
+var credentialHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Csrf-Token",
+	"X-Waf-Bypass-Token", "X-Hub-Signature-256", "X-Waf-Webhook-Signature",
+}
+
+type MirrorOptions struct {
+	// URL is the base URL allowed requests are mirrored to. An empty URL disables mirroring.
+	URL string
+	// Percent of allowed requests that are mirrored, from 0 to 100
+	Percent int
+	// Timeout for each mirrored request
+	Timeout time.Duration
+	// StripHeaders are removed from mirrored requests in addition to the credential headers
+	StripHeaders []string
+	// Transport carries the mirrored requests. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// Mirror asynchronously replays a sample of the requests the WAF allows to a shadow backend,
+// such as another WAF under evaluation or a honeypot. The original request is rebuilt from
+// the X-Forwarded-* headers sent by Traefik, without its credential headers. Forward-auth only sees the request, so
+// the shadow backend's responses are discarded rather than compared with the real upstream's.
+type Mirror struct {
+	options MirrorOptions
+	client  *http.Client
+	queue   chan *http.Request
+	sample  func() bool
+	wg      sync.WaitGroup
+}
+
+func New(options MirrorOptions) *Mirror {
+	m := &Mirror{
+		options: options,
+		client:  outbound.Client(options.Transport, "mirror", options.Timeout),
+		queue:   make(chan *http.Request, queueSize),
+		sample:  func() bool { return rand.IntN(100) < options.Percent },
+	}
+	if options.URL != "" {
+		for range workers {
+			m.wg.Add(1)
+			go m.work()
+		}
+	}
+	return m
+}
+
+// Handler wraps the WAF handler and queues allowed requests for mirroring
+func (m *Mirror) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.options.URL == "" || !m.sample() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := bodyBuffers.Get()
+		owned := true
+		defer func() {
+			if owned {
+				bodyBuffers.Put(body)
+			}
+		}()
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, body), r.Body}
+		}
+
+		srw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(srw, r)
+		if srw.statusCode != http.StatusOK {
+			return
+		}
+		if body.overflow {
+			metricMirrorRequests.WithLabelValues("skipped").Inc()
+			return
+		}
+
+		mirrored, err := m.buildRequest(r, body)
+		if err != nil {
+			slog.Debug("Failed to build mirrored request", "error", err)
+			metricMirrorRequests.WithLabelValues("error").Inc()
+			return
+		}
+
+		select {
+		case m.queue <- mirrored:
+			// The transport returns the buffer when it closes the mirrored request's body
+			owned = mirrored.Body == nil
+		default:
+			metricMirrorRequests.WithLabelValues("dropped").Inc()
+		}
+	})
+}
+
+// Stop waits for the queued requests to be sent. It must be called after the WAF server has shut down.
+func (m *Mirror) Stop(ctx context.Context) error {
+	close(m.queue)
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+func (m *Mirror) work() {
+	defer m.wg.Done()
+	for req := range m.queue {
+		start := time.Now()
+		resp, err := m.client.Do(req)
+		metricMirrorDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			slog.Debug("Failed to mirror request", "error", err)
+			metricMirrorRequests.WithLabelValues("error").Inc()
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		metricMirrorRequests.WithLabelValues("sent").Inc()
+	}
+}
+
+// buildRequest rebuilds the original client request, as described by Traefik's forward-auth headers, against the mirror URL
+func (m *Mirror) buildRequest(r *http.Request, body *cappedBuffer) (*http.Request, error) {
+	method := r.Method
+	if forwarded := r.Header.Get("X-Forwarded-Method"); forwarded != "" {
+		method = forwarded
+	}
+	uri := r.URL.RequestURI()
+	if forwarded := r.Header.Get("X-Forwarded-Uri"); forwarded != "" {
+		uri = forwarded
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, strings.TrimSuffix(m.options.URL, "/")+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body.Len() > 0 {
+		req.Body = &pooledBody{Reader: bytes.NewReader(body.Bytes()), buf: body}
+		req.ContentLength = int64(body.Len())
+	}
+	req.Header = r.Header.Clone()
+	for _, headers := range [][]string{hopHeaders, credentialHeaders, m.options.StripHeaders} {
+		for _, h := range headers {
+			req.Header.Del(h)
+		}
+	}
+	req.Host = r.Host
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// pooledBody is a mirrored request body that returns its buffer to the pool when the transport closes it
+type pooledBody struct {
+	*bytes.Reader
+	buf  *cappedBuffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() { bodyBuffers.Put(b.buf) })
+	return nil
+}
+
+// cappedBuffer keeps up to max bytes and records whether more were written
+type cappedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || b.Len()+len(p) > b.max {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,99 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	type mirrored struct {
+		method string
+		uri    string
+		host   string
+		body   string
+		header http.Header
+	}
+	received := make(chan mirrored, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{method: r.Method, uri: r.URL.RequestURI(), host: r.Host, body: string(body), header: r.Header}
+	}))
+	defer shadow.Close()
+
+	waf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if strings.Contains(r.Header.Get("X-Forwarded-Uri"), "attack") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	m := New(MirrorOptions{URL: shadow.URL, Percent: 100, Timeout: time.Second, StripHeaders: []string{"X-Session"}})
+	handler := m.Handler(waf)
+
+	t.Run("Should mirror allowed requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", strings.NewReader("name=value"))
+		req.Header.Set("X-Forwarded-Method", http.MethodPost)
+		req.Header.Set("X-Forwarded-Uri", "/login?next=%2F")
+		req.Header.Set("X-Forwarded-Host", "example.com")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		select {
+		case got := <-received:
+			got.header = nil
+			assert.Equal(t, mirrored{method: http.MethodPost, uri: "/login?next=%2F", host: "example.com", body: "name=value"}, got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the request to be mirrored")
+		}
+	})
+
+	t.Run("Should not send credentials to the mirror", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, name := range []string{"Authorization", "Cookie", "X-Waf-Bypass-Token", "X-Csrf-Token", "X-Session"} {
+			req.Header.Set(name, "secret")
+		}
+		req.Header.Set("Accept", "text/html")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		select {
+		case got := <-received:
+			for _, name := range []string{"Authorization", "Cookie", "X-Waf-Bypass-Token", "X-Csrf-Token", "X-Session"} {
+				assert.Empty(t, got.header.Values(name), name)
+			}
+			assert.Equal(t, "text/html", got.header.Get("Accept"))
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the request to be mirrored")
+		}
+	})
+
+	t.Run("Should not mirror blocked requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Uri", "/attack")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	require.NoError(t, m.Stop(context.Background()))
+	close(received)
+	_, ok := <-received
+	require.False(t, ok, "Blocked requests should not be mirrored")
+}
+
+func TestMirrorSampling(t *testing.T) {
+	m := New(MirrorOptions{URL: "http://127.0.0.1:0", Percent: 0})
+	defer m.Stop(context.Background())
+
+	assert.False(t, m.sample())
+}
@@ -0,0 +1,134 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type published struct {
+	subject string
+	data    string
+}
+
+// fakeServer speaks enough of the NATS protocol to accept publishes and, for subjects in streams, ack them like
+// JetStream
+type fakeServer struct {
+	listener net.Listener
+	// token is the required auth token, empty to accept any client
+	token   string
+	streams map[string]bool
+
+	mu       sync.Mutex
+	messages []published
+	connects []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	s := &fakeServer{listener: listener, streams: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) published() []published {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]published(nil), s.messages...)
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"headers\":true}\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "CONNECT":
+			s.mu.Lock()
+			s.connects = append(s.connects, args)
+			s.mu.Unlock()
+			if s.token != "" && !strings.Contains(args, `"auth_token":"`+s.token+`"`) {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			subject := fields[0]
+			s.mu.Lock()
+			s.messages = append(s.messages, published{subject, string(payload[:size])})
+			seq := len(s.messages)
+			s.mu.Unlock()
+			if len(fields) == 3 {
+				reply := fields[1]
+				if s.streams[subject] {
+					ack := fmt.Sprintf(`{"stream":"WAF","seq":%d}`, seq)
+					fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", reply, len(ack), ack)
+				} else {
+					headers := "NATS/1.0 503\r\n\r\n"
+					fmt.Fprintf(conn, "HMSG %s 1 %d %d\r\n%s\r\n", reply, len(headers), len(headers), headers)
+				}
+			}
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	server := newFakeServer(t)
+	server.token = "secret"
+	server.streams["waf.audit.critical"] = true
+
+	t.Run("Should reject invalid credentials", func(t *testing.T) {
+		_, err := dial(server.url(), "wrong", nil, time.Second)
+		assert.ErrorContains(t, err, "Authorization Violation")
+	})
+
+	c, err := dial(server.url(), "secret", nil, time.Second)
+	assert.NoError(t, err)
+	defer c.close()
+
+	t.Run("Should publish and confirm with a round trip", func(t *testing.T) {
+		c.publish("waf.decisions.deny", "", []byte(`{"decision":"deny"}`))
+		assert.NoError(t, c.flush())
+		assert.Equal(t, []published{{"waf.decisions.deny", `{"decision":"deny"}`}}, server.published())
+	})
+
+	t.Run("Should wait for JetStream acks", func(t *testing.T) {
+		assert.NoError(t, c.publishJetStream("waf.audit.critical", []byte("{}")))
+		assert.ErrorContains(t, c.publishJetStream("waf.audit.warning", []byte("{}")), "no JetStream stream")
+		assert.NoError(t, c.publishJetStream("waf.audit.critical", []byte("{}")), "Expected the connection to stay usable")
+	})
+}
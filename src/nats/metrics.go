@@ -0,0 +1,9 @@
+package nats
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricMessages = metrics.NewCounterVec(
+	"waf_nats_messages_total",
+	"The total number of messages published to NATS by kind (audit, decision) and result (published, failed, queue_full)",
+	[]string{"kind", "result"},
+)
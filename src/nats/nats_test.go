@@ -0,0 +1,67 @@
+package nats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublisher(t *testing.T) {
+	server := newFakeServer(t)
+	publisher := New(Options{
+		URL:             server.url(),
+		AuditSubject:    "waf.audit",
+		DecisionSubject: "waf.decisions",
+		Timeout:         time.Second,
+		QueueSize:       10,
+	})
+
+	t.Run("Should publish violation events and decisions to their subjects", func(t *testing.T) {
+		assert.NoError(t, publisher.Send(audit.Log{
+			Transaction: audit.Transaction{ID: "tx1", ClientIP: "192.0.2.1"},
+			Messages:    []audit.Message{{Data: audit.MessageData{ID: 942100, Severity: types.RuleSeverityCritical}}},
+		}))
+		publisher.PublishDecision(coraza.Decision{TransactionID: "tx2", Decision: "deny", Status: 403, RuleIDs: []int{942100}})
+		assert.NoError(t, publisher.Flush(true))
+
+		messages := server.published()
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "waf.audit.critical", messages[0].subject)
+		var event audit.Event
+		assert.NoError(t, json.Unmarshal([]byte(messages[0].data), &event))
+		assert.Equal(t, "tx1", event.ID)
+
+		assert.Equal(t, "waf.decisions.deny", messages[1].subject)
+		var decision coraza.Decision
+		assert.NoError(t, json.Unmarshal([]byte(messages[1].data), &decision))
+		assert.Equal(t, []int{942100}, decision.RuleIDs)
+	})
+
+	t.Run("Should reconnect after the server closed the connection", func(t *testing.T) {
+		publisher.conn.netConn.Close()
+		publisher.PublishDecision(coraza.Decision{Decision: "allow"})
+		assert.NoError(t, publisher.Flush(true))
+		assert.Len(t, server.published(), 3)
+	})
+
+	t.Run("Should not publish decisions without a subject", func(t *testing.T) {
+		publisher := New(Options{URL: server.url(), Timeout: time.Second, QueueSize: 10})
+		publisher.PublishDecision(coraza.Decision{Decision: "allow"})
+		assert.NoError(t, publisher.Flush(true))
+		assert.Len(t, server.published(), 3)
+	})
+
+	t.Run("Should count messages that could not be published", func(t *testing.T) {
+		before := testutil.ToFloat64(metricMessages.WithLabelValues(KindDecision, "failed"))
+		publisher := New(Options{URL: server.url(), DecisionSubject: "waf.decisions", JetStream: true, Timeout: time.Second, QueueSize: 10})
+		publisher.PublishDecision(coraza.Decision{Decision: "deny"})
+		assert.NoError(t, publisher.Flush(true))
+		assert.Equal(t, before+1, testutil.ToFloat64(metricMessages.WithLabelValues(KindDecision, "failed")))
+	})
+}
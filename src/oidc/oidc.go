@@ -0,0 +1,406 @@
+// Package oidc protects the admin server with OpenID Connect login, without an OAuth2 client library. Users sign in
+// with the authorization code flow and the groups of their ID token map to a role.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+)
+
+const (
+	// DefaultSessionTTL is how long a login lasts when the options do not say
+	DefaultSessionTTL = 8 * time.Hour
+	// DefaultGroupsClaim is the ID token claim listing the groups of the user
+	DefaultGroupsClaim = "groups"
+
+	sessionCookie = "waf_admin_session"
+	loginCookie   = "waf_admin_login"
+	// loginTTL bounds the time between starting a login and the identity provider redirecting back
+	loginTTL = 10 * time.Minute
+)
+
+// Options configures OpenID Connect login
+type Options struct {
+	// IssuerURL is the identity provider, discovered from <IssuerURL>/.well-known/openid-configuration. Empty
+	// disables login.
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the identity provider, the /auth/callback path of the admin server
+	RedirectURL string
+	// Scopes are requested in addition to openid
+	Scopes []string
+	// GroupsClaim names the ID token claim listing the groups of the user. Empty uses DefaultGroupsClaim.
+	GroupsClaim string
+	// ViewerGroups, OperatorGroups and AdminGroups grant their role to the members of any of the groups. Users in
+	// none of them cannot sign in.
+	ViewerGroups   []string
+	OperatorGroups []string
+	AdminGroups    []string
+	// SessionKey signs the session cookies. Empty generates a key, so sessions end when the process restarts and are
+	// not shared between replicas.
+	SessionKey []byte
+	// SessionTTL is how long a login lasts. Zero uses DefaultSessionTTL.
+	SessionTTL time.Duration
+	// Transport carries the requests to the identity provider. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// Identity is a signed in user
+type Identity struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Groups  []string  `json:"groups,omitempty"`
+	Role    rbac.Role `json:"role"`
+	Expires time.Time `json:"exp"`
+}
+
+// Actor names the user in the change trail and logs
+func (i Identity) Actor() string {
+	if i.Email != "" {
+		return i.Email
+	}
+	return i.Subject
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity of the user making an authenticated request
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// providerMetadata is the subset of the discovery document that is used
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Authenticator signs users in with the identity provider and authenticates every request
+type Authenticator struct {
+	options Options
+	client  *http.Client
+	now     func() time.Time
+	secure  bool
+
+	mu       sync.Mutex
+	provider *providerMetadata
+	keys     keySet
+}
+
+func New(options Options) *Authenticator {
+	if options.GroupsClaim == "" {
+		options.GroupsClaim = DefaultGroupsClaim
+	}
+	if options.SessionTTL <= 0 {
+		options.SessionTTL = DefaultSessionTTL
+	}
+	if len(options.SessionKey) == 0 {
+		options.SessionKey = make([]byte, 32)
+		rand.Read(options.SessionKey)
+		slog.Warn("OIDC_SESSION_KEY is not set, admin sessions will end on restart and are not shared between replicas")
+	}
+	options.IssuerURL = strings.TrimSuffix(options.IssuerURL, "/")
+	return &Authenticator{
+		options: options,
+		client:  &http.Client{Transport: options.Transport, Timeout: 10 * time.Second},
+		now:     time.Now,
+		secure:  strings.HasPrefix(options.RedirectURL, "https://"),
+	}
+}
+
+// Handler serves the login endpoints under /auth/
+func (a *Authenticator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/login", a.login)
+	mux.HandleFunc("GET /auth/callback", a.callback)
+	mux.HandleFunc("/auth/logout", a.logout)
+	mux.Handle("GET /auth/me", a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := FromContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Identity
+			Role string `json:"role"`
+		}{Identity: identity, Role: identity.Role.String()})
+	})))
+	return mux
+}
+
+// Authenticate only passes on requests from signed in users, with the session cookie or an ID token of the client as
+// a bearer token, carrying their identity and principal. Browsers without a session are sent to the login.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.authenticate(r)
+		if err != nil {
+			if r.Method == http.MethodGet && r.Header.Get("Authorization") == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/auth/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			slog.Debug("Admin request not authenticated", "error", err, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="waf-admin"`)
+			httperror.WriteJSON(w, http.StatusUnauthorized, httperror.Body{Code: "admin.unauthenticated", Message: "sign in at /auth/login or send an ID token as a bearer token"})
+			return
+		}
+		ctx := context.WithValue(r.Context(), identityKey{}, identity)
+		ctx = rbac.WithPrincipal(ctx, rbac.Principal{Actor: identity.Actor(), Role: identity.Role})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Identity, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := a.verifyIDToken(r.Context(), token)
+		if err != nil {
+			return Identity{}, err
+		}
+		return a.identity(claims), nil
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return Identity{}, errors.New("no session")
+	}
+	var identity Identity
+	if err := a.open(sessionCookie, cookie.Value, &identity); err != nil {
+		return Identity{}, err
+	}
+	if !a.now().Before(identity.Expires) {
+		return Identity{}, errors.New("session expired")
+	}
+	return identity, nil
+}
+
+// loginState is kept in a signed cookie between starting a login and the callback
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"exp"`
+}
+
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request) {
+	provider, err := a.discover(r.Context())
+	if err != nil {
+		slog.Error("Failed to discover the OIDC provider", "error", err, "issuer", a.options.IssuerURL)
+		httperror.WriteJSON(w, http.StatusBadGateway, httperror.Body{Code: "admin.login_unavailable", Message: "the identity provider is unavailable"})
+		return
+	}
+
+	redirect := r.URL.Query().Get("redirect")
+	// Only return to paths of the admin server, not to another site
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = "/auth/me"
+	}
+	state := loginState{State: randomString(), Nonce: randomString(), Verifier: randomString(), Redirect: redirect, Expires: a.now().Add(loginTTL)}
+	a.setCookie(w, loginCookie, a.seal(loginCookie, state), loginTTL)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.options.ClientID},
+		"redirect_uri":          {a.options.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, a.options.Scopes...), " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+func (a *Authenticator) callback(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code string, message string, err error) {
+		slog.Warn("Admin login failed", "error", err, "remote_addr", r.RemoteAddr)
+		httperror.WriteJSON(w, status, httperror.Body{Code: code, Message: message})
+	}
+
+	var state loginState
+	cookie, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = a.open(loginCookie, cookie.Value, &state)
+	}
+	if err != nil || !a.now().Before(state.Expires) || r.URL.Query().Get("state") != state.State {
+		fail(http.StatusBadRequest, "admin.login_failed", "the login expired or did not start here, sign in again", err)
+		return
+	}
+	a.setCookie(w, loginCookie, "", -1)
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		fail(http.StatusUnauthorized, "admin.login_failed", "the identity provider refused the login: "+errorCode, errors.New(errorCode))
+		return
+	}
+
+	rawToken, err := a.exchange(r.Context(), r.URL.Query().Get("code"), state.Verifier)
+	if err != nil {
+		fail(http.StatusBadGateway, "admin.login_failed", "failed to redeem the authorization code", err)
+		return
+	}
+	claims, err := a.verifyIDToken(r.Context(), rawToken)
+	if err != nil {
+		fail(http.StatusUnauthorized, "admin.login_failed", "the ID token is invalid", err)
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != state.Nonce {
+		fail(http.StatusUnauthorized, "admin.login_failed", "the ID token is invalid", errors.New("nonce mismatch"))
+		return
+	}
+
+	identity := a.identity(claims)
+	if identity.Role == rbac.RoleNone {
+		slog.Warn("Admin login refused, the user is in no admin group", "actor", identity.Actor(), "groups", identity.Groups)
+		httperror.WriteJSON(w, http.StatusForbidden, httperror.Body{Code: "admin.forbidden", Message: identity.Actor() + " is in no admin group"})
+		return
+	}
+	// The session outlives the ID token, whose expiry is usually short
+	identity.Expires = a.now().Add(a.options.SessionTTL)
+	a.setCookie(w, sessionCookie, a.seal(sessionCookie, identity), a.options.SessionTTL)
+	slog.Info("Admin login", "actor", identity.Actor(), "role", identity.Role.String())
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (a *Authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, sessionCookie, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exchange redeems the authorization code for an ID token
+func (a *Authenticator) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	provider, err := a.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.options.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.options.ClientID), url.QueryEscape(a.options.ClientSecret))
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := a.getJSON(req, &token); err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// discover fetches the discovery document of the issuer, once it succeeds
+func (a *Authenticator) discover(ctx context.Context) (*providerMetadata, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider != nil {
+		return a.provider, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.options.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var provider providerMetadata
+	if err := a.getJSON(req, &provider); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != a.options.IssuerURL {
+		return nil, fmt.Errorf("discovery document is for issuer %q", provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing an endpoint")
+	}
+	a.provider = &provider
+	return a.provider, nil
+}
+
+func (a *Authenticator) getJSON(req *http.Request, value any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d: %s", req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, value)
+}
+
+// identity maps the claims of an ID token to a user and the highest role of their groups
+func (a *Authenticator) identity(claims map[string]any) Identity {
+	identity := Identity{Expires: a.now().Add(a.options.SessionTTL)}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		identity.Expires = time.Unix(int64(exp), 0)
+	}
+	switch groups := claims[a.options.GroupsClaim].(type) {
+	case []any:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+
+	for role, groups := range map[rbac.Role][]string{rbac.RoleViewer: a.options.ViewerGroups, rbac.RoleOperator: a.options.OperatorGroups, rbac.RoleAdmin: a.options.AdminGroups} {
+		if role > identity.Role && slices.ContainsFunc(identity.Groups, func(group string) bool { return slices.Contains(groups, group) }) {
+			identity.Role = role
+		}
+	}
+	return identity
+}
+
+func (a *Authenticator) setCookie(w http.ResponseWriter, name string, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secure,
+		// Lax sends the cookies on the redirect back from the identity provider but not on cross-site API calls
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
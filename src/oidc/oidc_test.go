@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider is an identity provider issuing ID tokens for the groups of the code redeemed
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	now   time.Time
+	nonce string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &fakeProvider{key: key, now: time.Now()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`, p.URL, p.URL+"/authorize", p.URL+"/token", p.URL+"/keys")
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		assert.Equal(t, "waf", clientID)
+		assert.Equal(t, "secret", secret)
+		assert.NotEmpty(t, r.PostFormValue("code_verifier"))
+		fmt.Fprintf(w, `{"id_token":%q}`, p.token(map[string]any{"email": "jane@example.com", "groups": []string{r.PostFormValue("code")}, "nonce": p.nonce}))
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) token(claims map[string]any) string {
+	base := map[string]any{"iss": p.URL, "aud": "waf", "sub": "1234", "exp": p.now.Add(time.Hour).Unix()}
+	for k, v := range claims {
+		base[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1"})
+	payload, _ := json.Marshal(base)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticator(t *testing.T) {
+	provider := newFakeProvider(t)
+	auth := New(Options{
+		IssuerURL:      provider.URL,
+		ClientID:       "waf",
+		ClientSecret:   "secret",
+		RedirectURL:    "https://waf-admin.example.com/auth/callback",
+		ViewerGroups:   []string{"sre"},
+		OperatorGroups: []string{"secops"},
+		AdminGroups:    []string{"waf-admins"},
+		SessionKey:     []byte("session-key"),
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", auth.Handler())
+	mux.Handle("GET /api/v1/changes", auth.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := rbac.FromContext(r.Context())
+		fmt.Fprint(w, principal.Actor+" "+principal.Role.String())
+	})))
+
+	// login signs in as a member of the group, returning the session cookie
+	login := func(t *testing.T, group string) *http.Cookie {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?redirect=/api/v1/changes", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, provider.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+		assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+		provider.nonce = location.Query().Get("nonce")
+
+		req := httptest.NewRequest("GET", "/auth/callback?code="+group+"&state="+location.Query().Get("state"), nil)
+		for _, cookie := range w.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			return nil
+		}
+		assert.Equal(t, "/api/v1/changes", w.Header().Get("Location"))
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == sessionCookie && cookie.Value != "" {
+				assert.True(t, cookie.Secure)
+				assert.True(t, cookie.HttpOnly)
+				return cookie
+			}
+		}
+		return nil
+	}
+	serve := func(method string, target string, header http.Header, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Should refuse requests without a session", func(t *testing.T) {
+		w := serve("GET", "/api/v1/changes", nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "admin.unauthenticated")
+	})
+
+	t.Run("Should send browsers to the login", func(t *testing.T) {
+		w := serve("GET", "/api/v1/changes", http.Header{"Accept": {"text/html"}}, nil)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "/auth/login?redirect=%2Fapi%2Fv1%2Fchanges", w.Header().Get("Location"))
+	})
+
+	t.Run("Should sign in with the highest role of the groups", func(t *testing.T) {
+		session := login(t, "sre")
+		require.NotNil(t, session)
+		w := serve("GET", "/api/v1/changes", nil, session)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "jane@example.com viewer", w.Body.String())
+
+		assert.Equal(t, "jane@example.com admin", serve("GET", "/api/v1/changes", nil, login(t, "waf-admins")).Body.String())
+	})
+
+	t.Run("Should refuse users in no admin group", func(t *testing.T) {
+		assert.Nil(t, login(t, "marketing"))
+	})
+
+	t.Run("Should refuse tampered and expired sessions", func(t *testing.T) {
+		session := login(t, "sre")
+		require.NotNil(t, session)
+		tampered := *session
+		tampered.Value = "x" + session.Value
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/v1/changes", nil, &tampered).Code)
+
+		auth.now = func() time.Time { return time.Now().Add(DefaultSessionTTL) }
+		defer func() { auth.now = time.Now }()
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/v1/changes", nil, session).Code)
+	})
+
+	t.Run("Should accept an ID token as a bearer token", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer " + provider.token(map[string]any{"groups": []string{"secops"}})}}
+		w := serve("GET", "/api/v1/changes", header, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1234 operator", w.Body.String())
+
+		for name, claims := range map[string]map[string]any{
+			"another client": {"aud": "other", "groups": []string{"secops"}},
+			"another issuer": {"iss": "https://evil.example.com", "groups": []string{"secops"}},
+			"expired":        {"exp": time.Now().Add(-time.Hour).Unix(), "groups": []string{"secops"}},
+		} {
+			header := http.Header{"Authorization": {"Bearer " + provider.token(claims)}}
+			assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/v1/changes", header, nil).Code, name)
+		}
+		forged := provider.token(map[string]any{"groups": []string{"secops"}})
+		forged = forged[:strings.LastIndex(forged, ".")+1] + base64.RawURLEncoding.EncodeToString([]byte("forged"))
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/v1/changes", http.Header{"Authorization": {"Bearer " + forged}}, nil).Code)
+	})
+
+	t.Run("Should only redirect to local paths after the login", func(t *testing.T) {
+		w := serve("GET", "/auth/login?redirect=//evil.example.com", nil, nil)
+		var state loginState
+		for _, cookie := range w.Result().Cookies() {
+			require.NoError(t, auth.open(loginCookie, cookie.Value, &state))
+		}
+		assert.Equal(t, "/auth/me", state.Redirect)
+	})
+
+	t.Run("Should refuse a callback with another state", func(t *testing.T) {
+		w := serve("GET", "/auth/login", nil, nil)
+		req := httptest.NewRequest("GET", "/auth/callback?code=sre&state=forged", nil)
+		for _, cookie := range w.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "admin.login_failed")
+	})
+}
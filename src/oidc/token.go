@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// clockSkew is the leeway given to the expiry of ID tokens
+	clockSkew = time.Minute
+	// keyRefreshInterval limits how often the signing keys are fetched again for an unknown key ID
+	keyRefreshInterval = time.Minute
+)
+
+// keySet holds the RSA signing keys of the identity provider by key ID
+type keySet struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// verifyIDToken checks the RS256 signature, issuer, audience and expiry of an ID token, returning its claims
+func (a *Authenticator) verifyIDToken(ctx context.Context, raw string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	key, err := a.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if issuer, _ := claims["iss"].(string); issuer != a.provider.Issuer {
+		return nil, fmt.Errorf("token is issued by %q", issuer)
+	}
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, item := range aud {
+			if s, ok := item.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, a.options.ClientID) {
+		return nil, errors.New("token is for another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || !a.now().Before(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+// signingKey returns the key with the ID, fetching the key set when it is not known yet
+func (a *Authenticator) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	provider, err := a.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if key, ok := a.keys.keys[kid]; ok {
+		return key, nil
+	}
+	if a.now().Sub(a.keys.fetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	a.keys.fetched = a.now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := a.getJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	a.keys.keys = keys
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// seal encodes the value as a cookie signed for its purpose, so a login cookie is never accepted as a session
+func (a *Authenticator) seal(purpose string, value any) string {
+	data, _ := json.Marshal(value)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.mac(purpose, payload)
+}
+
+// open verifies a cookie sealed for the purpose and decodes its value
+func (a *Authenticator) open(purpose string, cookie string, value any) error {
+	payload, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.mac(purpose, payload))) {
+		return errors.New("invalid cookie signature")
+	}
+	return decodeSegment(payload, value)
+}
+
+func (a *Authenticator) mac(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, a.options.SessionKey)
+	mac.Write([]byte(purpose + "\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/readiness"
+)
+
+// startupStep is a piece of initialization that must complete before the servers handle traffic
+type startupStep struct {
+	name string
+	run  func() error
+}
+
+// orchestrator runs the startup steps in order while the servers are already listening, and opens the readiness
+// gate once all of them have completed. A failing step exits the process.
+type orchestrator struct {
+	gate  *readiness.Gate
+	steps []startupStep
+}
+
+func newOrchestrator(steps ...startupStep) *orchestrator {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	return &orchestrator{gate: readiness.New(names...), steps: steps}
+}
+
+func (o *orchestrator) run() {
+	start := time.Now()
+	for _, step := range o.steps {
+		stepStart := time.Now()
+		if err := step.run(); err != nil {
+			slog.Error("Startup step failed", "step", step.name, "error", err)
+			os.Exit(1)
+		}
+		o.gate.Done(step.name)
+		slog.Debug("Startup step complete", "step", step.name, "duration", time.Since(stepStart))
+	}
+	slog.Info("Startup complete, serving traffic", "duration", time.Since(start))
+}
@@ -0,0 +1,11 @@
+package outbound
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricConnections = metrics.NewCounterVec(
+	"waf_outbound_connections_total",
+	"The total number of connections obtained for outbound requests, by target and whether the connection was reused",
+	[]string{"target", "reused"},
+)
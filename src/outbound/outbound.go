@@ -0,0 +1,93 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Options tunes the transport shared by the outbound clients: the mirror, the decision webhook, OPA and the
+// remote audit sinks.
+// Go's default transport keeps only two idle connections per host, so under load most calls open a new one.
+type Options struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept to each host
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds all connections to each host. Zero means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections that have been idle this long
+	IdleConnTimeout time.Duration
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string
+	// HTTP2 negotiates HTTP/2 with TLS servers that support it
+	HTTP2 bool
+}
+
+// NewTransport builds the shared transport
+func NewTransport(options Options) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          options.MaxIdleConns,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     options.HTTP2,
+	}
+	if !options.HTTP2 {
+		// A non-nil empty map disables the HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA file contains no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
+
+// Client returns a client for target that sends its requests through transport, or the default transport if
+// nil, and counts whether each request reused a pooled connection
+func Client(transport http.RoundTripper, target string, timeout time.Duration) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{Timeout: timeout, Transport: &instrumentedTransport{next: transport, target: target}}
+}
+
+type instrumentedTransport struct {
+	next   http.RoundTripper
+	target string
+}
+
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metricConnections.WithLabelValues(t.target, strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+}
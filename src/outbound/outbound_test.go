@@ -0,0 +1,72 @@
+package outbound
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	transport, err := NewTransport(Options{MaxIdleConns: 10, MaxIdleConnsPerHost: 10, IdleConnTimeout: time.Minute})
+	assert.NoError(t, err)
+	client := Client(transport, "test", time.Second)
+
+	for range 3 {
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricConnections.WithLabelValues("test", "false")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricConnections.WithLabelValues("test", "true")))
+}
+
+func TestNewTransport(t *testing.T) {
+	transport, err := NewTransport(Options{HTTP2: false})
+	assert.NoError(t, err)
+	assert.NotNil(t, transport.TLSNextProto, "Expected HTTP/2 to be disabled")
+
+	t.Run("Should trust the CA file", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		resp, err := Client(nil, "tls", time.Second).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		assert.Error(t, err, "Expected the test certificate to be untrusted by default")
+
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		assert.NoError(t, os.WriteFile(caFile, certificatePEM(server), 0o644))
+		transport, err := NewTransport(Options{CAFile: caFile})
+		assert.NoError(t, err)
+		resp, err = Client(transport, "tls", time.Second).Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("Should reject invalid CA files", func(t *testing.T) {
+		_, err := NewTransport(Options{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+		assert.ErrorContains(t, err, "failed to read CA file")
+
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o644))
+		_, err = NewTransport(Options{CAFile: caFile})
+		assert.ErrorContains(t, err, "no PEM certificates")
+	})
+}
+
+func certificatePEM(server *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
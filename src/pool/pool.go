@@ -0,0 +1,50 @@
+package pool
+
+import (
+	"sync"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricPoolGets = metrics.NewCounterVec(
+	"waf_pool_gets_total",
+	"The total number of objects taken from a buffer pool, by pool and whether a pooled object was reused (hit) or allocated (miss)",
+	[]string{"pool", "result"},
+)
+
+// Pool reuses objects across requests to reduce allocation churn. Objects must be pointers so that putting
+// them back does not allocate.
+type Pool[T any] struct {
+	pool  sync.Pool
+	new   func() T
+	reset func(T)
+	hits  prometheus.Counter
+	miss  prometheus.Counter
+}
+
+// New creates a pool named for its metrics. reset is called on objects as they are put back.
+func New[T any](name string, newFn func() T, reset func(T)) *Pool[T] {
+	return &Pool[T]{
+		new:   newFn,
+		reset: reset,
+		hits:  metricPoolGets.WithLabelValues(name, "hit"),
+		miss:  metricPoolGets.WithLabelValues(name, "miss"),
+	}
+}
+
+// Get returns a pooled object, allocating one when the pool is empty
+func (p *Pool[T]) Get() T {
+	if v := p.pool.Get(); v != nil {
+		p.hits.Inc()
+		return v.(T)
+	}
+	p.miss.Inc()
+	return p.new()
+}
+
+// Put resets the object and returns it to the pool. The caller must not use it afterwards.
+func (p *Pool[T]) Put(v T) {
+	p.reset(v)
+	p.pool.Put(v)
+}
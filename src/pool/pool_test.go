@@ -0,0 +1,34 @@
+package pool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p := New("test", func() *bytes.Buffer { return new(bytes.Buffer) }, (*bytes.Buffer).Reset)
+
+	buf := p.Get()
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.miss))
+	buf.WriteString("request body")
+	p.Put(buf)
+
+	// sync.Pool may drop objects at any time, so only check that reused objects were reset
+	reused := p.Get()
+	assert.Equal(t, 0, reused.Len())
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.hits)+testutil.ToFloat64(p.miss))
+}
+
+func BenchmarkPool(b *testing.B) {
+	p := New("bench", func() *bytes.Buffer { return new(bytes.Buffer) }, (*bytes.Buffer).Reset)
+
+	b.ReportAllocs()
+	for range b.N {
+		buf := p.Get()
+		buf.WriteString("request body")
+		p.Put(buf)
+	}
+}
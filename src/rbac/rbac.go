@@ -0,0 +1,92 @@
+// Package rbac authorizes admin operations by role, independently of how callers authenticate
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Role is the access level of a caller. Each role includes the access of the roles before it.
+type Role int
+
+const (
+	// RoleNone is granted nothing. A route requiring it is denied to every caller.
+	RoleNone Role = iota
+	// RoleViewer reads the configuration, events and statistics
+	RoleViewer
+	// RoleOperator also changes objects, IP lists, bans, debug capture and the log level
+	RoleOperator
+	// RoleAdmin also replaces the stored objects and the directives the WAF runs
+	RoleAdmin
+)
+
+var roleNames = []string{"none", "viewer", "operator", "admin"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return roleNames[RoleNone]
+	}
+	return roleNames[r]
+}
+
+// ParseRole returns the role with the name
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return Role(i), nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q, expected one of %s", name, strings.Join(roleNames, ", "))
+}
+
+// Principal is the authenticated caller of an admin operation
+type Principal struct {
+	// Actor names the caller in the change trail and logs
+	Actor string
+	Role  Role
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the caller, set by the authentication in front of the admin API
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the caller of the request, if it was authenticated
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Policy is the role required by each route, keyed by method and path pattern such as "POST /import"
+type Policy map[string]Role
+
+// Allows reports whether the role may call the route. Routes without a policy are denied.
+func (p Policy) Allows(route string, role Role) bool {
+	required, ok := p[route]
+	return ok && required != RoleNone && role >= required
+}
+
+// ParsePolicy parses comma-separated "METHOD /path=role" entries, such as "GET /captures/{id}=admin"
+func ParsePolicy(value string) (Policy, error) {
+	policy := Policy{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		route, roleName, ok := strings.Cut(item, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !hasPath || !strings.HasPrefix(strings.TrimSpace(path), "/") {
+			return nil, fmt.Errorf("invalid policy %q, expected \"METHOD /path=role\"", item)
+		}
+		role, err := ParseRole(strings.TrimSpace(roleName))
+		if err != nil {
+			return nil, err
+		}
+		policy[strings.ToUpper(method)+" "+strings.TrimSpace(path)] = role
+	}
+	return policy, nil
+}
@@ -0,0 +1,36 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{"GET /changes": RoleViewer, "POST /import": RoleAdmin, "GET /config": RoleNone}
+
+	t.Run("Should allow the required role and above", func(t *testing.T) {
+		assert.True(t, policy.Allows("GET /changes", RoleViewer))
+		assert.True(t, policy.Allows("GET /changes", RoleAdmin))
+		assert.False(t, policy.Allows("POST /import", RoleOperator))
+		assert.True(t, policy.Allows("POST /import", RoleAdmin))
+	})
+
+	t.Run("Should deny routes without a policy or requiring no role", func(t *testing.T) {
+		assert.False(t, policy.Allows("DELETE /bans/{ip}", RoleAdmin))
+		assert.False(t, policy.Allows("GET /config", RoleAdmin))
+		assert.False(t, policy.Allows("GET /changes", RoleNone))
+	})
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("post /import=operator, GET /captures/{id}=Admin,GET /config=none")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{"POST /import": RoleOperator, "GET /captures/{id}": RoleAdmin, "GET /config": RoleNone}, policy)
+
+	_, err = ParsePolicy("/import=admin")
+	assert.ErrorContains(t, err, "expected \"METHOD /path=role\"")
+	_, err = ParsePolicy("POST /import=root")
+	assert.ErrorContains(t, err, "unknown role \"root\"")
+}
@@ -0,0 +1,35 @@
+package rdns
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricLookups = metrics.NewCounterVec(
+	"waf_rdns_lookups_total",
+	"The total number of reverse DNS lookups of client IPs, by result (found, not_found, error)",
+	[]string{"result"},
+)
+
+var metricCacheHits = metrics.NewCounter(
+	"waf_rdns_cache_hits_total",
+	"The total number of client IPs whose hostname was found in the reverse DNS cache",
+)
+
+var metricCacheMisses = metrics.NewCounter(
+	"waf_rdns_cache_misses_total",
+	"The total number of client IPs whose hostname had to be looked up",
+)
+
+var metricCacheSize = metrics.NewGauge(
+	"waf_rdns_cache_entries",
+	"The number of client IPs in the reverse DNS cache",
+)
+
+var metricDropped = metrics.NewCounter(
+	"waf_rdns_prefetches_dropped_total",
+	"The total number of reverse DNS prefetches dropped as too many lookups were running",
+)
+
+var metricVerifiedCrawlers = metrics.NewCounterVec(
+	"waf_rdns_verified_crawlers_total",
+	"The total number of client IPs verified as a crawler, by crawler",
+	[]string{"crawler"},
+)
@@ -0,0 +1,297 @@
+// Package rdns resolves the hostnames of client IPs in the background, caching answers and failures, and verifies
+// search engine crawlers by checking that their hostname resolves back to the client IP
+package rdns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the Options
+const (
+	DefaultTimeout     = 2 * time.Second
+	DefaultCacheTTL    = time.Hour
+	DefaultNegativeTTL = 5 * time.Minute
+	DefaultCacheSize   = 10000
+	DefaultConcurrency = 16
+)
+
+// Crawler is a search engine crawler verified by the domains its reverse DNS names are in
+type Crawler struct {
+	Name    string
+	Domains []string
+}
+
+// DefaultCrawlers are the crawlers documented to be verified with a reverse then forward lookup
+var DefaultCrawlers = []Crawler{
+	{Name: "googlebot", Domains: []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{Name: "bingbot", Domains: []string{"search.msn.com"}},
+}
+
+// Options configures the Resolver
+type Options struct {
+	// Timeout bounds each lookup
+	Timeout time.Duration
+	// CacheTTL is how long a resolved hostname is kept
+	CacheTTL time.Duration
+	// NegativeTTL is how long a failed lookup, or an IP without a hostname, is kept before it is looked up again
+	NegativeTTL time.Duration
+	// CacheSize is the number of IPs kept. The expired entries, then the oldest ones, are dropped when it is full.
+	CacheSize int
+	// Concurrency is the number of lookups run at once. Prefetches beyond it are dropped.
+	Concurrency int
+	// Crawlers are verified when a hostname is in one of their domains. Nil uses DefaultCrawlers.
+	Crawlers []Crawler
+}
+
+// Result is what is known of the hostname of a client IP
+type Result struct {
+	// Hostname is the first reverse DNS name of the IP, empty when it has none
+	Hostname string
+	// Crawler names the verified crawler the IP belongs to, empty when it is none
+	Crawler string
+}
+
+// entry is a cached result, or a lookup in flight until done is closed
+type entry struct {
+	result  Result
+	expires time.Time
+	done    chan struct{}
+}
+
+// Resolver looks up the hostnames of client IPs off the request path, so requests never wait on DNS
+type Resolver struct {
+	options Options
+	logger  *slog.Logger
+	now     func() time.Time
+	// lookupAddr and lookupHost resolve names, replaced in tests
+	lookupAddr func(ctx context.Context, ip string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	slots      chan struct{}
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New returns a resolver using the system resolver
+func New(options Options) *Resolver {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+	if options.NegativeTTL <= 0 {
+		options.NegativeTTL = DefaultNegativeTTL
+	}
+	if options.CacheSize <= 0 {
+		options.CacheSize = DefaultCacheSize
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.Crawlers == nil {
+		options.Crawlers = DefaultCrawlers
+	}
+	return &Resolver{
+		options:    options,
+		logger:     slog.Default(),
+		now:        time.Now,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		lookupHost: net.DefaultResolver.LookupHost,
+		slots:      make(chan struct{}, options.Concurrency),
+		entries:    map[string]*entry{},
+	}
+}
+
+// Cached returns the result of the IP if it was resolved and has not expired. It never blocks.
+func (r *Resolver) Cached(ip string) (Result, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[ip]
+	if !ok || !r.resolved(e) || !r.now().Before(e.expires) {
+		return Result{}, false
+	}
+	metricCacheHits.Inc()
+	return e.result, true
+}
+
+// Prefetch starts looking up the IP in the background unless it is cached or being looked up. The prefetch is
+// dropped when Concurrency lookups are already running.
+func (r *Resolver) Prefetch(ip string) {
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		metricDropped.Inc()
+		return
+	}
+	e, started := r.start(ip)
+	if !started {
+		<-r.slots
+		return
+	}
+	go func() {
+		defer func() { <-r.slots }()
+		r.resolve(ip, e)
+	}()
+}
+
+// Lookup returns the result of the IP, waiting for a lookup in flight or running one when it is not cached. A
+// lookup that times out is cached as a failure.
+func (r *Resolver) Lookup(ctx context.Context, ip string) Result {
+	e, started := r.start(ip)
+	if started {
+		r.resolve(ip, e)
+		return e.result
+	}
+	select {
+	case <-e.done:
+		return e.result
+	case <-ctx.Done():
+		return Result{}
+	}
+}
+
+// Resolve is Lookup bounded by the Timeout
+func (r *Resolver) Resolve(ip string) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.Timeout)
+	defer cancel()
+	return r.Lookup(ctx, ip)
+}
+
+// start returns the entry of the IP, reporting whether the caller must resolve it as it is missing or expired
+func (r *Resolver) start(ip string) (*entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if e, ok := r.entries[ip]; ok && (!r.resolved(e) || now.Before(e.expires)) {
+		if r.resolved(e) {
+			metricCacheHits.Inc()
+		}
+		return e, false
+	}
+	metricCacheMisses.Inc()
+	if len(r.entries) >= r.options.CacheSize {
+		r.evict(now)
+	}
+	e := &entry{done: make(chan struct{})}
+	r.entries[ip] = e
+	metricCacheSize.Set(float64(len(r.entries)))
+	return e, true
+}
+
+// resolve looks the IP up and completes its entry
+func (r *Resolver) resolve(ip string, e *entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.Timeout)
+	defer cancel()
+	result, err := r.verify(ctx, ip)
+
+	ttl := r.options.CacheTTL
+	switch {
+	case err != nil:
+		metricLookups.WithLabelValues("error").Inc()
+		r.logger.Debug("Failed to look up client hostname", "ip", ip, "error", err)
+		ttl = r.options.NegativeTTL
+	case result.Hostname == "":
+		metricLookups.WithLabelValues("not_found").Inc()
+		ttl = r.options.NegativeTTL
+	default:
+		metricLookups.WithLabelValues("found").Inc()
+	}
+	if result.Crawler != "" {
+		metricVerifiedCrawlers.WithLabelValues(result.Crawler).Inc()
+	}
+
+	r.mu.Lock()
+	e.result = result
+	e.expires = r.now().Add(ttl)
+	r.mu.Unlock()
+	close(e.done)
+}
+
+// verify resolves the hostname of the IP and, when it is in the domains of a crawler, checks that the hostname
+// resolves back to the IP, as a client controls the reverse DNS of its own addresses
+func (r *Resolver) verify(ctx context.Context, ip string) (Result, error) {
+	names, err := r.lookupAddr(ctx, ip)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return Result{}, nil
+		}
+		return Result{}, err
+	}
+	if len(names) == 0 {
+		return Result{}, nil
+	}
+	result := Result{Hostname: strings.TrimSuffix(names[0], ".")}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		crawler := r.crawler(name)
+		if crawler == "" {
+			continue
+		}
+		addresses, err := r.lookupHost(ctx, name)
+		if err != nil {
+			return result, err
+		}
+		if slices.ContainsFunc(addresses, func(address string) bool { return sameIP(address, ip) }) {
+			result.Hostname, result.Crawler = name, crawler
+			return result, nil
+		}
+		r.logger.Info("Reverse DNS name of a client claims a crawler but does not resolve back to it", "ip", ip, "hostname", name, "crawler", crawler)
+	}
+	return result, nil
+}
+
+// crawler returns the name of the crawler whose domains hold the hostname
+func (r *Resolver) crawler(hostname string) string {
+	for _, crawler := range r.options.Crawlers {
+		for _, domain := range crawler.Domains {
+			if strings.HasSuffix(hostname, "."+domain) {
+				return crawler.Name
+			}
+		}
+	}
+	return ""
+}
+
+// resolved reports whether the lookup of the entry completed. Called with mu held.
+func (r *Resolver) resolved(e *entry) bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict drops the expired entries, or else the entry expiring first. Called with mu held.
+func (r *Resolver) evict(now time.Time) {
+	var oldest string
+	for ip, e := range r.entries {
+		if !r.resolved(e) {
+			continue
+		}
+		if !now.Before(e.expires) {
+			delete(r.entries, ip)
+			continue
+		}
+		if oldest == "" || e.expires.Before(r.entries[oldest].expires) {
+			oldest = ip
+		}
+	}
+	if len(r.entries) >= r.options.CacheSize && oldest != "" {
+		delete(r.entries, oldest)
+	}
+}
+
+func sameIP(a string, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipA.Equal(ipB)
+}
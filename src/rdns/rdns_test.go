@@ -0,0 +1,133 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDNS answers lookups from maps, counting the reverse lookups
+type fakeDNS struct {
+	mu      sync.Mutex
+	ptr     map[string][]string
+	hosts   map[string][]string
+	lookups int
+}
+
+func (f *fakeDNS) lookupAddr(ctx context.Context, ip string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	names, ok := f.ptr[ip]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: ip, IsNotFound: true}
+	}
+	if names == nil {
+		return nil, errors.New("server failure")
+	}
+	return names, nil
+}
+
+func (f *fakeDNS) lookupHost(ctx context.Context, host string) ([]string, error) {
+	return f.hosts[host], nil
+}
+
+func newTestResolver(dns *fakeDNS, now *time.Time) *Resolver {
+	r := New(Options{CacheTTL: time.Hour, NegativeTTL: time.Minute, CacheSize: 3})
+	r.lookupAddr = dns.lookupAddr
+	r.lookupHost = dns.lookupHost
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func TestResolver(t *testing.T) {
+	dns := &fakeDNS{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"157.55.39.1":  {"msnbot-157-55-39-1.search.msn.com."},
+			"192.0.2.1":    {"host.example.com."},
+			"192.0.2.2":    {"fake.googlebot.com."},
+			"198.51.100.1": nil,
+		},
+		hosts: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com":   {"66.249.66.1"},
+			"msnbot-157-55-39-1.search.msn.com": {"157.55.39.1"},
+			"fake.googlebot.com":                {"66.249.66.2"},
+		},
+	}
+
+	t.Run("Should verify crawlers resolving back to the client IP", func(t *testing.T) {
+		now := time.Now()
+		r := newTestResolver(dns, &now)
+		assert.Equal(t, Result{Hostname: "crawl-66-249-66-1.googlebot.com", Crawler: "googlebot"}, r.Resolve("66.249.66.1"))
+		assert.Equal(t, Result{Hostname: "msnbot-157-55-39-1.search.msn.com", Crawler: "bingbot"}, r.Resolve("157.55.39.1"))
+		assert.Equal(t, Result{Hostname: "host.example.com"}, r.Resolve("192.0.2.1"))
+	})
+
+	t.Run("Should not verify crawler names that do not resolve back to the client IP", func(t *testing.T) {
+		now := time.Now()
+		r := newTestResolver(dns, &now)
+		assert.Equal(t, Result{Hostname: "fake.googlebot.com"}, r.Resolve("192.0.2.2"))
+	})
+
+	t.Run("Should cache answers until the TTL and failures until the negative TTL", func(t *testing.T) {
+		now := time.Now()
+		r := newTestResolver(dns, &now)
+		dns.lookups = 0
+
+		r.Resolve("192.0.2.1")
+		r.Resolve("198.51.100.1")
+		r.Resolve("203.0.113.1")
+		r.Resolve("192.0.2.1")
+		r.Resolve("198.51.100.1")
+		r.Resolve("203.0.113.1")
+		assert.Equal(t, 3, dns.lookups)
+		result, ok := r.Cached("203.0.113.1")
+		assert.True(t, ok)
+		assert.Empty(t, result.Hostname)
+
+		now = now.Add(2 * time.Minute)
+		_, ok = r.Cached("198.51.100.1")
+		assert.False(t, ok)
+		_, ok = r.Cached("192.0.2.1")
+		assert.True(t, ok)
+		r.Resolve("198.51.100.1")
+		r.Resolve("192.0.2.1")
+		assert.Equal(t, 4, dns.lookups)
+	})
+
+	t.Run("Should evict the entry expiring first when the cache is full", func(t *testing.T) {
+		now := time.Now()
+		r := newTestResolver(dns, &now)
+		r.Resolve("192.0.2.1")
+		r.Resolve("198.51.100.1")
+		r.Resolve("66.249.66.1")
+		r.Resolve("157.55.39.1")
+
+		_, ok := r.Cached("198.51.100.1")
+		assert.False(t, ok)
+		for _, ip := range []string{"192.0.2.1", "66.249.66.1", "157.55.39.1"} {
+			_, ok := r.Cached(ip)
+			assert.True(t, ok, ip)
+		}
+	})
+
+	t.Run("Should prefetch in the background", func(t *testing.T) {
+		now := time.Now()
+		r := newTestResolver(dns, &now)
+		_, ok := r.Cached("66.249.66.1")
+		require.False(t, ok)
+
+		r.Prefetch("66.249.66.1")
+		assert.Eventually(t, func() bool {
+			result, ok := r.Cached("66.249.66.1")
+			return ok && result.Crawler == "googlebot"
+		}, time.Second, time.Millisecond)
+	})
+}
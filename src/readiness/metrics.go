@@ -0,0 +1,10 @@
+package readiness
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricNotReady = metrics.NewCounter(
+	"waf_not_ready_responses_total",
+	"The total number of requests answered with 503 because startup had not completed",
+)
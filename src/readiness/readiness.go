@@ -0,0 +1,164 @@
+package readiness
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+)
+
+// Gate tracks the startup steps that must complete before the servers handle traffic
+type Gate struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	ready   chan struct{}
+	checks  map[string]func() error
+}
+
+// New creates a gate that is ready once every step is done
+func New(steps ...string) *Gate {
+	g := &Gate{pending: map[string]bool{}, ready: make(chan struct{}), checks: map[string]func() error{}}
+	for _, step := range steps {
+		g.pending[step] = true
+	}
+	if len(g.pending) == 0 {
+		close(g.ready)
+	}
+	return g
+}
+
+// Done marks a step as complete, opening the gate when it was the last one
+func (g *Gate) Done(step string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.pending[step] {
+		return
+	}
+	delete(g.pending, step)
+	if len(g.pending) == 0 {
+		close(g.ready)
+	}
+}
+
+// AddCheck adds a check run by every readiness probe once startup is done. A failing check makes the probe fail
+// without stopping the servers from handling traffic.
+func (g *Gate) AddCheck(name string, check func() error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.checks[name] = check
+}
+
+// Checks runs the checks, returning the error message of each failing one, or "ok"
+func (g *Gate) Checks() map[string]string {
+	g.mu.Lock()
+	checks := maps.Clone(g.checks)
+	g.mu.Unlock()
+
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		results[name] = "ok"
+		if err := check(); err != nil {
+			results[name] = err.Error()
+		}
+	}
+	return results
+}
+
+// Ready reports whether every step is done
+func (g *Gate) Ready() bool {
+	return g.isReady()
+}
+
+// isReady does not need the lock since the ready channel is only ever closed
+func (g *Gate) isReady() bool {
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait returns a channel that is closed once the gate is ready
+func (g *Gate) Wait() <-chan struct{} {
+	return g.ready
+}
+
+// Status is the readiness of the servers, the steps still pending and the checks failing
+type Status struct {
+	Ready   bool              `json:"ready"`
+	Pending []string          `json:"pending,omitempty"`
+	Failing map[string]string `json:"failing,omitempty"`
+}
+
+func (g *Gate) Status() Status {
+	g.mu.Lock()
+	status := Status{Ready: g.isReady()}
+	for step := range g.pending {
+		status.Pending = append(status.Pending, step)
+	}
+	g.mu.Unlock()
+	slices.Sort(status.Pending)
+	if !status.Ready {
+		return status
+	}
+
+	for name, result := range g.Checks() {
+		if result != "ok" {
+			if status.Failing == nil {
+				status.Failing = map[string]string{}
+			}
+			status.Failing[name] = result
+		}
+	}
+	status.Ready = len(status.Failing) == 0
+	return status
+}
+
+// StatusHandler answers readiness probes with the gate status, using 503 until the gate is ready
+func (g *Gate) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := g.Status()
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// Handler answers 503 until the gate is ready and the handler it stands in for has been set.
+// It lets servers listen while the handlers they serve are still being built.
+type Handler struct {
+	gate *Gate
+	next atomic.Pointer[http.Handler]
+}
+
+func NewHandler(gate *Gate) *Handler {
+	return &Handler{gate: gate}
+}
+
+// Set installs the handler requests are passed to once the gate is ready
+func (h *Handler) Set(next http.Handler) {
+	h.next.Store(&next)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	next := h.next.Load()
+	if next == nil || !h.gate.Ready() {
+		metricNotReady.Inc()
+		w.Header().Set("Retry-After", "1")
+		httperror.Write(w, r, http.StatusServiceUnavailable, httperror.Body{
+			Code:    httperror.CodeNotReady,
+			Message: "the server is starting up",
+		})
+		return
+	}
+	(*next).ServeHTTP(w, r)
+}
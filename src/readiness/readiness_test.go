@@ -0,0 +1,98 @@
+package readiness
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/httperror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGate(t *testing.T) {
+	gate := New("waf", "admin")
+	assert.False(t, gate.Ready())
+	assert.Equal(t, Status{Pending: []string{"admin", "waf"}}, gate.Status())
+
+	gate.Done("waf")
+	gate.Done("unknown")
+	assert.False(t, gate.Ready())
+	assert.Equal(t, []string{"admin"}, gate.Status().Pending)
+
+	gate.Done("admin")
+	assert.True(t, gate.Ready())
+	assert.Equal(t, Status{Ready: true}, gate.Status())
+	select {
+	case <-gate.Wait():
+	default:
+		t.Fatal("Expected the wait channel to be closed")
+	}
+
+	t.Run("Should not panic when a step is done twice", func(t *testing.T) {
+		gate.Done("admin")
+		assert.True(t, gate.Ready())
+	})
+
+	t.Run("Should be ready without steps", func(t *testing.T) {
+		assert.True(t, New().Ready())
+	})
+}
+
+func TestStatusHandler(t *testing.T) {
+	gate := New("waf")
+
+	w := httptest.NewRecorder()
+	gate.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var status Status
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, []string{"waf"}, status.Pending)
+
+	gate.Done("waf")
+	w = httptest.NewRecorder()
+	gate.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"ready":true}`, w.Body.String())
+
+	t.Run("Should fail while a check fails", func(t *testing.T) {
+		var checkErr error
+		gate.AddCheck("audit_log_writable", func() error { return checkErr })
+		assert.Equal(t, map[string]string{"audit_log_writable": "ok"}, gate.Checks())
+
+		checkErr = errors.New("read-only file system")
+		w := httptest.NewRecorder()
+		gate.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"ready":false,"failing":{"audit_log_writable":"read-only file system"}}`, w.Body.String())
+		assert.True(t, gate.Ready(), "Expected failing checks not to stop traffic")
+	})
+}
+
+func TestHandler(t *testing.T) {
+	gate := New("waf")
+	handler := NewHandler(gate)
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var response httperror.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, httperror.CodeNotReady, response.Error.Code)
+
+	t.Run("Should wait for the gate once the handler is set", func(t *testing.T) {
+		handler.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		assert.Equal(t, http.StatusServiceUnavailable, serve().Code)
+
+		gate.Done("waf")
+		assert.Equal(t, http.StatusTeapot, serve().Code)
+	})
+}
@@ -0,0 +1,243 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// Request is a request to replay through the WAF
+type Request struct {
+	Method string
+	// URL is absolute, or a path when the request list has no host
+	URL    string
+	Header http.Header
+	Body   string
+}
+
+// Report is the result of a replay
+type Report struct {
+	Total   int      `json:"total"`
+	Denied  int      `json:"denied"`
+	Results []Result `json:"results"`
+}
+
+type Result struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Status is the forward-auth response status, 200 when the request is allowed
+	Status  int  `json:"status"`
+	Allowed bool `json:"allowed"`
+	// RuleIDs are the matched rules that log a message
+	RuleIDs []int `json:"rule_ids,omitempty"`
+	// InterruptedBy is the rule that denied the request, zero when no rule did
+	InterruptedBy int    `json:"interrupted_by,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Parse reads the requests of a HAR file, or of a request list: one request per line, as a URL optionally preceded
+// by its method, with blank lines and lines starting with # ignored
+func Parse(r io.Reader) ([]Request, error) {
+	reader := bufio.NewReader(r)
+	start, err := reader.Peek(1)
+	for err == nil && strings.ContainsRune(" \t\r\n", rune(start[0])) {
+		reader.Discard(1)
+		start, err = reader.Peek(1)
+	}
+	if err == nil && start[0] == '{' {
+		return parseHAR(reader)
+	}
+	return parseList(reader)
+}
+
+// har is the subset of the HTTP Archive format describing requests
+type har struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method   string    `json:"method"`
+				URL      string    `json:"url"`
+				Headers  []harPair `json:"headers"`
+				PostData *struct {
+					MimeType string    `json:"mimeType"`
+					Text     string    `json:"text"`
+					Params   []harPair `json:"params"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func parseHAR(r io.Reader) ([]Request, error) {
+	var archive har
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("invalid HAR file: %w", err)
+	}
+	requests := make([]Request, 0, len(archive.Log.Entries))
+	for _, entry := range archive.Log.Entries {
+		request := Request{Method: entry.Request.Method, URL: entry.Request.URL, Header: http.Header{}}
+		for _, header := range entry.Request.Headers {
+			// HTTP/2 pseudo-headers are part of the request line
+			if !strings.HasPrefix(header.Name, ":") {
+				request.Header.Add(header.Name, header.Value)
+			}
+		}
+		if postData := entry.Request.PostData; postData != nil {
+			request.Body = postData.Text
+			if request.Body == "" && len(postData.Params) > 0 {
+				form := url.Values{}
+				for _, param := range postData.Params {
+					form.Add(param.Name, param.Value)
+				}
+				request.Body = form.Encode()
+			}
+			if request.Header.Get("Content-Type") == "" && postData.MimeType != "" {
+				request.Header.Set("Content-Type", postData.MimeType)
+			}
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func parseList(r io.Reader) ([]Request, error) {
+	var requests []Request
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		method, target, found := strings.Cut(text, " ")
+		if !found {
+			method, target = http.MethodGet, text
+		}
+		target = strings.TrimSpace(target)
+		if !strings.HasPrefix(target, "/") && !strings.Contains(target, "://") {
+			return nil, fmt.Errorf("line %d: %q is not a URL or path", line, target)
+		}
+		requests = append(requests, Request{Method: method, URL: target, Header: http.Header{}})
+	}
+	return requests, scanner.Err()
+}
+
+// ParseRaw reads a request in HTTP/1.x wire format, such as one copied from a proxy or written by hand. Its URL is
+// built from the request target and the Host header.
+func ParseRaw(r io.Reader) (Request, error) {
+	req, err := http.ReadRequest(bufio.NewReader(r))
+	if err != nil {
+		return Request{}, fmt.Errorf("invalid HTTP request: %w", err)
+	}
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return Request{}, fmt.Errorf("failed to read the request body: %w", err)
+	}
+
+	target := req.URL
+	if target.Host == "" {
+		target.Scheme, target.Host = "http", req.Host
+	}
+	if target.Host == "" {
+		target.Scheme = ""
+	}
+	return Request{Method: req.Method, URL: target.String(), Header: req.Header, Body: string(body)}, nil
+}
+
+// Run sends every request through the WAF handler as Traefik's forwardAuth middleware would, and reports the
+// decisions
+func Run(handler http.Handler, requests []Request) Report {
+	report := Report{Results: make([]Result, 0, len(requests))}
+	for _, request := range requests {
+		result := run(handler, request)
+		report.Total++
+		if !result.Allowed {
+			report.Denied++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+func run(handler http.Handler, request Request) Result {
+	result := Result{Method: request.Method, URL: request.URL}
+	if result.Method == "" {
+		result.Method = http.MethodGet
+	}
+	req, err := forwardAuthRequest(result.Method, request)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req = req.WithContext(coraza.WithTransactionObserver(req.Context(), func(tx types.Transaction) {
+		for _, rule := range tx.MatchedRules() {
+			// Rules without a message, such as the Core Rule Set's bookkeeping, are not in the audit log either
+			if rule.Message() != "" {
+				result.RuleIDs = append(result.RuleIDs, rule.Rule().ID())
+			}
+		}
+		if it := tx.Interruption(); it != nil {
+			result.InterruptedBy = it.RuleID
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	result.Status = w.Code
+	result.Allowed = w.Code == http.StatusOK
+	return result
+}
+
+// forwardAuthRequest builds the request Traefik sends the WAF for the original request: the request line and host
+// are in X-Forwarded-* headers, and the body is forwarded
+func forwardAuthRequest(method string, request Request) (*http.Request, error) {
+	target, err := url.Parse(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "" {
+		target.Scheme = "http"
+	}
+	host := target.Host
+	if host == "" {
+		host = request.Header.Get("Host")
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if !strings.HasPrefix(target.RequestURI(), "/") {
+		return nil, errors.New("URL has no path")
+	}
+
+	req, err := http.NewRequest(method, "/", strings.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range request.Header {
+		// The length is that of the replayed body
+		if !strings.EqualFold(name, "Content-Length") && !strings.EqualFold(name, "Host") {
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	req.Host = host
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set("X-Forwarded-Method", method)
+	req.Header.Set("X-Forwarded-Proto", target.Scheme)
+	req.Header.Set("X-Forwarded-Host", host)
+	req.Header.Set("X-Forwarded-Uri", target.RequestURI())
+	return req, nil
+}
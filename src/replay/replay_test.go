@@ -0,0 +1,148 @@
+package replay
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleHAR = `{
+  "log": {
+    "version": "1.2",
+    "entries": [
+      {
+        "request": {
+          "method": "GET",
+          "url": "https://shop.example.com/search?q=books",
+          "httpVersion": "HTTP/2",
+          "headers": [
+            {"name": ":authority", "value": "shop.example.com"},
+            {"name": "User-Agent", "value": "Mozilla/5.0"}
+          ]
+        }
+      },
+      {
+        "request": {
+          "method": "POST",
+          "url": "https://shop.example.com/login",
+          "headers": [{"name": "Content-Length", "value": "999"}],
+          "postData": {
+            "mimeType": "application/x-www-form-urlencoded",
+            "params": [{"name": "username", "value": "admin"}, {"name": "password", "value": "x y"}]
+          }
+        }
+      }
+    ]
+  }
+}`
+
+func TestParse(t *testing.T) {
+	t.Run("Should read HAR requests", func(t *testing.T) {
+		requests, err := Parse(strings.NewReader("\n  " + sampleHAR))
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+
+		assert.Equal(t, "GET", requests[0].Method)
+		assert.Equal(t, "https://shop.example.com/search?q=books", requests[0].URL)
+		assert.Equal(t, http.Header{"User-Agent": {"Mozilla/5.0"}}, requests[0].Header, "Should drop pseudo-headers")
+
+		assert.Equal(t, "POST", requests[1].Method)
+		assert.Equal(t, "password=x+y&username=admin", requests[1].Body)
+		assert.Equal(t, "application/x-www-form-urlencoded", requests[1].Header.Get("Content-Type"))
+	})
+
+	t.Run("Should read a request list", func(t *testing.T) {
+		requests, err := Parse(strings.NewReader("# reported by a customer\n/products?id=1\n\nPOST https://api.example.com/orders\n"))
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.Equal(t, Request{Method: "GET", URL: "/products?id=1", Header: http.Header{}}, requests[0])
+		assert.Equal(t, Request{Method: "POST", URL: "https://api.example.com/orders", Header: http.Header{}}, requests[1])
+	})
+
+	t.Run("Should reject invalid input", func(t *testing.T) {
+		_, err := Parse(strings.NewReader(`{"log":`))
+		assert.ErrorContains(t, err, "invalid HAR file")
+
+		_, err = Parse(strings.NewReader("GET /\nGET products\n"))
+		assert.EqualError(t, err, `line 2: "products" is not a URL or path`)
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Should send requests as forwardAuth does", func(t *testing.T) {
+		var received *http.Request
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			w.WriteHeader(http.StatusOK)
+		})
+		requests, err := Parse(strings.NewReader(sampleHAR))
+		require.NoError(t, err)
+
+		report := Run(handler, requests[1:])
+		assert.Equal(t, 1, report.Total)
+		assert.Equal(t, 0, report.Denied)
+		assert.Equal(t, "POST", received.Header.Get("X-Forwarded-Method"))
+		assert.Equal(t, "https", received.Header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "shop.example.com", received.Header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "/login", received.Header.Get("X-Forwarded-Uri"))
+		assert.Equal(t, int64(len(requests[1].Body)), received.ContentLength, "Should send the length of the replayed body")
+	})
+
+	t.Run("Should report invalid requests", func(t *testing.T) {
+		report := Run(http.NotFoundHandler(), []Request{{Method: "BAD METHOD", URL: "/"}, {URL: "mailto:alice@example.com"}})
+		assert.Equal(t, 2, report.Denied)
+		assert.Equal(t, `net/http: invalid method "BAD METHOD"`, report.Results[0].Error)
+		assert.Equal(t, "URL has no path", report.Results[1].Error)
+	})
+
+	t.Run("Should report the rules of the WAF's decisions", func(t *testing.T) {
+		t.Setenv("DIRECTIVES", `
+SecRuleEngine On
+SecRule ARGS "@contains attack" "id:1001,phase:1,deny,status:403,log,msg:'Attack'"
+SecRule REQUEST_FILENAME "@beginsWith /admin" "id:1002,phase:1,pass,log,msg:'Admin area'"
+SecAction "id:1003,phase:1,pass,nolog"
+`)
+		processor := audit.NewLogProcessor(audit.AuditLogProcessorOptions{
+			AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+			Storage:      audit.StorageMemory,
+		})
+		handler := coraza.NewCorazaWAFHandler(processor, coraza.WAFHandlerOptions{})
+
+		report := Run(handler, []Request{
+			{Method: "GET", URL: "https://example.com/search?q=attack"},
+			{Method: "GET", URL: "/admin/users"},
+		})
+		assert.Equal(t, 2, report.Total)
+		assert.Equal(t, 1, report.Denied)
+		assert.Equal(t, Result{Method: "GET", URL: "https://example.com/search?q=attack", Status: 403, RuleIDs: []int{1001}, InterruptedBy: 1001}, report.Results[0])
+		assert.Equal(t, Result{Method: "GET", URL: "/admin/users", Status: 200, Allowed: true, RuleIDs: []int{1002}}, report.Results[1])
+	})
+}
+
+func TestParseRaw(t *testing.T) {
+	t.Run("Should read a request with a body", func(t *testing.T) {
+		request, err := ParseRaw(strings.NewReader("POST /login?next=%2F HTTP/1.1\nHost: shop.example.com\nContent-Type: application/x-www-form-urlencoded\nContent-Length: 15\n\nusername=admin&ignored"))
+		require.NoError(t, err)
+		assert.Equal(t, "POST", request.Method)
+		assert.Equal(t, "http://shop.example.com/login?next=%2F", request.URL)
+		assert.Equal(t, "application/x-www-form-urlencoded", request.Header.Get("Content-Type"))
+		assert.Equal(t, "username=admin&", request.Body, "Should read the body up to its length")
+	})
+
+	t.Run("Should keep the path without a Host header", func(t *testing.T) {
+		request, err := ParseRaw(strings.NewReader("GET /products?id=1 HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "/products?id=1", request.URL)
+	})
+
+	t.Run("Should reject a malformed request", func(t *testing.T) {
+		_, err := ParseRaw(strings.NewReader("not a request\n\n"))
+		assert.ErrorContains(t, err, "invalid HTTP request")
+	})
+}
@@ -0,0 +1,197 @@
+// Package report emails or posts a periodic summary of WAF traffic and attacks, for teams that review WAF posture
+// asynchronously
+package report
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+)
+
+// maxClientsPerDay bounds the distinct attacker IPs remembered per day, so a distributed attack cannot exhaust
+// memory. Further IPs are counted but not listed.
+const maxClientsPerDay = 10000
+
+// day holds the counts of one UTC day
+type day struct {
+	requests   int
+	decisions  map[string]int
+	violations int
+	rules      map[int]int
+	clients    map[string]int
+}
+
+// Collector counts requests from the WAF's decisions, and violations from the processed audit logs, per day. It
+// is an audit.Sink.
+type Collector struct {
+	// retention is how many days are kept, two report periods so new attackers can be told apart
+	retention int
+	now       func() time.Time
+
+	mu       sync.Mutex
+	days     map[int64]*day
+	messages map[int]string
+}
+
+func NewCollector(period time.Duration) *Collector {
+	return &Collector{
+		retention: int(2 * period / (24 * time.Hour)),
+		now:       time.Now,
+		days:      map[int64]*day{},
+		messages:  map[int]string{},
+	}
+}
+
+func (c *Collector) dayOf(t time.Time) *day {
+	key := t.UTC().Truncate(24 * time.Hour).Unix()
+	d, ok := c.days[key]
+	if !ok {
+		d = &day{decisions: map[string]int{}, rules: map[int]int{}, clients: map[string]int{}}
+		c.days[key] = d
+	}
+	return d
+}
+
+// Observe counts a decision. It is a coraza.DecisionObserver.
+func (c *Collector) Observe(decision coraza.Decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.dayOf(decision.Time)
+	d.requests++
+	d.decisions[decision.Decision]++
+}
+
+func (c *Collector) Name() string {
+	return "report"
+}
+
+func (c *Collector) Send(log audit.Log) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.dayOf(log.Time())
+	d.violations++
+	if _, ok := d.clients[log.Transaction.ClientIP]; ok || len(d.clients) < maxClientsPerDay {
+		d.clients[log.Transaction.ClientIP]++
+	}
+	for _, msg := range log.Messages {
+		d.rules[msg.Data.ID]++
+		c.messages[msg.Data.ID] = msg.Data.Msg
+	}
+	return nil
+}
+
+// Flush drops the days older than the retention
+func (c *Collector) Flush(force bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -c.retention).Unix()
+	for key := range c.days {
+		if key < cutoff {
+			delete(c.days, key)
+		}
+	}
+	return nil
+}
+
+// Report covers the requests and violations of a period
+type Report struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Requests   int       `json:"requests"`
+	Blocked    int       `json:"blocked"`
+	Violations int       `json:"violations"`
+	// Decisions counts the requests by decision: allow, deny, error or cancelled
+	Decisions    map[string]int `json:"decisions"`
+	TopRules     []RuleEntry    `json:"top_rules"`
+	TopAttackers []Entry        `json:"top_attackers"`
+	// NewAttackers are the most active attackers that caused no violations in the previous period
+	NewAttackers []Entry `json:"new_attackers"`
+}
+
+type Entry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type RuleEntry struct {
+	ID      int    `json:"id"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// BlockRate is the share of requests that were blocked, in percent
+func (r Report) BlockRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Blocked) * 100 / float64(r.Requests)
+}
+
+// Report summarizes the days from from up to to, listing the n most frequent of each kind
+func (c *Collector) Report(from time.Time, to time.Time, n int) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := Report{From: from, To: to, Decisions: map[string]int{}}
+	rules, clients, previous := map[int]int{}, map[string]int{}, map[string]bool{}
+	previousFrom := from.Add(-to.Sub(from))
+	for key, d := range c.days {
+		start := time.Unix(key, 0)
+		if !start.Before(previousFrom) && start.Before(from) {
+			for client := range d.clients {
+				previous[client] = true
+			}
+		}
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		report.Requests += d.requests
+		report.Violations += d.violations
+		for decision, count := range d.decisions {
+			report.Decisions[decision] += count
+		}
+		for id, count := range d.rules {
+			rules[id] += count
+		}
+		for client, count := range d.clients {
+			clients[client] += count
+		}
+	}
+	report.Blocked = report.Decisions["deny"]
+
+	ruleCounts := map[string]int{}
+	for id, count := range rules {
+		ruleCounts[strconv.Itoa(id)] = count
+	}
+	for _, entry := range topN(ruleCounts, n) {
+		id, _ := strconv.Atoi(entry.Key)
+		report.TopRules = append(report.TopRules, RuleEntry{ID: id, Message: c.messages[id], Count: entry.Count})
+	}
+	report.TopAttackers = topN(clients, n)
+	for client := range previous {
+		delete(clients, client)
+	}
+	report.NewAttackers = topN(clients, n)
+	return report
+}
+
+func topN(counts map[string]int, n int) []Entry {
+	entries := make([]Entry, 0, len(counts))
+	for k, v := range counts {
+		entries = append(entries, Entry{Key: k, Count: v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
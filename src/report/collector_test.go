@@ -0,0 +1,62 @@
+package report
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func violation(clientIP string, at time.Time, ruleIDs ...int) audit.Log {
+	log := audit.Log{Transaction: audit.Transaction{UnixTimestamp: at.UnixNano(), ClientIP: clientIP}}
+	for _, id := range ruleIDs {
+		log.Messages = append(log.Messages, audit.Message{Data: audit.MessageData{ID: id, Msg: "rule " + strconv.Itoa(id)}})
+	}
+	return log
+}
+
+func TestCollector(t *testing.T) {
+	today := time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+	collector := NewCollector(24 * time.Hour)
+	collector.now = func() time.Time { return today.Add(12 * time.Hour) }
+
+	collector.Observe(coraza.Decision{Time: yesterday.Add(time.Hour), Decision: "deny"})
+	require.NoError(t, collector.Send(violation("192.0.2.1", yesterday.Add(time.Hour), 942100)))
+	for _, decision := range []string{"allow", "allow", "deny", "deny", "error"} {
+		collector.Observe(coraza.Decision{Time: today.Add(time.Hour), Decision: decision})
+	}
+	require.NoError(t, collector.Send(violation("192.0.2.1", today.Add(time.Hour), 942100, 949110)))
+	require.NoError(t, collector.Send(violation("192.0.2.2", today.Add(2*time.Hour), 942100, 949110)))
+	require.NoError(t, collector.Send(violation("192.0.2.2", today.Add(3*time.Hour), 930120)))
+
+	t.Run("Should summarize the requests and violations of the period", func(t *testing.T) {
+		report := collector.Report(today, today.AddDate(0, 0, 1), 2)
+		assert.Equal(t, 5, report.Requests)
+		assert.Equal(t, 2, report.Blocked)
+		assert.Equal(t, 3, report.Violations)
+		assert.Equal(t, map[string]int{"allow": 2, "deny": 2, "error": 1}, report.Decisions)
+		assert.InDelta(t, 40, report.BlockRate(), 0.001)
+		assert.Equal(t, []RuleEntry{
+			{ID: 942100, Message: "rule 942100", Count: 2},
+			{ID: 949110, Message: "rule 949110", Count: 2},
+		}, report.TopRules)
+		assert.Equal(t, []Entry{{Key: "192.0.2.2", Count: 2}, {Key: "192.0.2.1", Count: 1}}, report.TopAttackers)
+	})
+
+	t.Run("Should list attackers not seen in the previous period as new", func(t *testing.T) {
+		report := collector.Report(today, today.AddDate(0, 0, 1), 10)
+		assert.Equal(t, []Entry{{Key: "192.0.2.2", Count: 2}}, report.NewAttackers)
+	})
+
+	t.Run("Should drop days older than the retention on flush", func(t *testing.T) {
+		require.NoError(t, collector.Send(violation("192.0.2.3", today.AddDate(0, 0, -3))))
+		assert.Len(t, collector.days, 3)
+		require.NoError(t, collector.Flush(false))
+		assert.Len(t, collector.days, 2)
+	})
+}
@@ -0,0 +1,197 @@
+package report
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+type SMTPOptions struct {
+	// Address of the mail server, e.g. smtp.example.com:587. STARTTLS is used when the server offers it.
+	Address string
+	// Username and Password authenticate with PLAIN auth, which requires TLS. Empty sends unauthenticated.
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+type sender interface {
+	name() string
+	send(report Report) error
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("Mon 2 Jan 2006") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #222;">
+<h2>WAF report {{date .From}} – {{date .Last}}</h2>
+<table cellpadding="4">
+<tr><td>Requests</td><td><b>{{.Requests}}</b></td></tr>
+<tr><td>Blocked</td><td><b>{{.Blocked}}</b> ({{printf "%.2f" .BlockRate}}%)</td></tr>
+<tr><td>Requests with violations</td><td><b>{{.Violations}}</b></td></tr>
+</table>
+{{- define "entries"}}
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th align="left">Client IP</th><th align="right">Violations</th></tr>
+{{- range .}}
+<tr><td>{{.Key}}</td><td align="right">{{.Count}}</td></tr>
+{{- else}}
+<tr><td colspan="2">None</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h3>Top rules</h3>
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th align="left">Rule</th><th align="left">Message</th><th align="right">Hits</th></tr>
+{{- range .TopRules}}
+<tr><td>{{.ID}}</td><td>{{.Message}}</td><td align="right">{{.Count}}</td></tr>
+{{- else}}
+<tr><td colspan="3">None</td></tr>
+{{- end}}
+</table>
+<h3>Top attackers</h3>
+{{template "entries" .TopAttackers}}
+<h3>New attackers</h3>
+<p>Not seen in the previous period.</p>
+{{template "entries" .NewAttackers}}
+</body>
+</html>
+`))
+
+// renderHTML renders the report as a self-contained HTML page with inline styles, as mail clients expect
+func renderHTML(report Report) (string, error) {
+	var html strings.Builder
+	err := reportTemplate.Execute(&html, struct {
+		Report
+		BlockRate float64
+		Last      time.Time
+	}{report, report.BlockRate(), report.To.Add(-time.Second)})
+	return html.String(), err
+}
+
+// Subject is the one-line summary used as the email subject and webhook text
+func (r Report) Subject() string {
+	return fmt.Sprintf("WAF report %s: %d requests, %d blocked (%.2f%%), %d new attackers",
+		r.From.UTC().Format("2006-01-02"), r.Requests, r.Blocked, r.BlockRate(), len(r.NewAttackers))
+}
+
+type webhookSender struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSender(options Options) *webhookSender {
+	return &webhookSender{url: options.WebhookURL, client: outbound.Client(options.Transport, "report", options.Timeout)}
+}
+
+func (s *webhookSender) name() string {
+	return "webhook"
+}
+
+// send posts the report as JSON. The text field makes the payload readable by Slack-compatible incoming webhooks.
+func (s *webhookSender) send(report Report) error {
+	html, err := renderHTML(report)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{"text": report.Subject(), "report": report, "html": html})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+type smtpSender struct {
+	options SMTPOptions
+	timeout time.Duration
+}
+
+func newSMTPSender(options SMTPOptions, timeout time.Duration) *smtpSender {
+	return &smtpSender{options: options, timeout: timeout}
+}
+
+func (s *smtpSender) name() string {
+	return "smtp"
+}
+
+func (s *smtpSender) send(report Report) error {
+	html, err := renderHTML(report)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.options.Address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", s.options.Address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(s.timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.options.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.options.Username, s.options.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.options.From); err != nil {
+		return err
+	}
+	for _, to := range s.options.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	headers := []string{
+		"From: " + s.options.From,
+		"To: " + strings.Join(s.options.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", report.Subject()),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=utf-8",
+	}
+	if _, err := io.WriteString(w, strings.Join(headers, "\r\n")+"\r\n\r\n"+strings.ReplaceAll(html, "\n", "\r\n")); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
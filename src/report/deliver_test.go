@@ -0,0 +1,116 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() Report {
+	from := time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)
+	return Report{
+		From:         from,
+		To:           from.AddDate(0, 0, 1),
+		Requests:     200,
+		Blocked:      5,
+		Violations:   7,
+		Decisions:    map[string]int{"allow": 195, "deny": 5},
+		TopRules:     []RuleEntry{{ID: 942100, Message: "SQL Injection Attack Detected via libinjection", Count: 4}},
+		TopAttackers: []Entry{{Key: "192.0.2.1", Count: 6}},
+		NewAttackers: []Entry{{Key: "<script>", Count: 1}},
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	html, err := renderHTML(testReport())
+	require.NoError(t, err)
+	assert.Contains(t, html, "Mon 13 May 2024")
+	assert.Contains(t, html, "<b>5</b> (2.50%)")
+	assert.Contains(t, html, "SQL Injection Attack Detected via libinjection")
+	assert.Contains(t, html, "&lt;script&gt;", "Expected values to be escaped")
+}
+
+func TestWebhookSender(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	sender := newWebhookSender(Options{WebhookURL: server.URL, Timeout: time.Second})
+	require.NoError(t, sender.send(testReport()))
+	assert.Equal(t, "WAF report 2024-05-13: 200 requests, 5 blocked (2.50%), 1 new attackers", received["text"])
+	assert.Contains(t, received["html"], "<html>")
+	assert.EqualValues(t, 200, received["report"].(map[string]any)["requests"])
+}
+
+// fakeSMTP accepts a single message and returns the envelope and data it received
+func fakeSMTP(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case line == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				received <- lines
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPSender(t *testing.T) {
+	address, received := fakeSMTP(t)
+	sender := newSMTPSender(SMTPOptions{Address: address, From: "waf@example.com", To: []string{"a@example.com", "b@example.com"}}, time.Second)
+	require.NoError(t, sender.send(testReport()))
+
+	lines := strings.Join(<-received, "\n")
+	assert.Contains(t, lines, "MAIL FROM:<waf@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<a@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<b@example.com>")
+	assert.Contains(t, lines, "To: a@example.com, b@example.com")
+	assert.Contains(t, lines, "Subject: WAF report 2024-05-13: 200 requests, 5 blocked (2.50%), 1 new attackers")
+	assert.Contains(t, lines, "Content-Type: text/html; charset=utf-8")
+	assert.Contains(t, lines, "SQL Injection Attack Detected via libinjection")
+}
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Schedules
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+type Options struct {
+	// Schedule is daily or weekly. Weekly reports are sent on Mondays.
+	Schedule string
+	// At is the time of day the report is sent, as an offset from midnight UTC
+	At time.Duration
+	// TopN bounds the rules and attackers listed
+	TopN int
+	// WebhookURL receives the report as JSON, with its HTML rendering
+	WebhookURL string
+	// SMTP emails the HTML report when its address is set
+	SMTP SMTPOptions
+	// Timeout bounds each delivery
+	Timeout time.Duration
+	// Transport carries the webhook requests. Nil uses the default transport.
+	Transport http.RoundTripper
+	// Leader reports whether this replica sends the reports. Nil sends them from every replica.
+	Leader func() bool
+}
+
+// Period returns how long each report covers
+func (o Options) Period() time.Duration {
+	if o.Schedule == Weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// ParseTimeOfDay parses an HH:MM time into an offset from midnight
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Job sends a report of the collector's counts on the schedule
+type Job struct {
+	options   Options
+	collector *Collector
+	senders   []sender
+	logger    *slog.Logger
+	now       func() time.Time
+
+	stopSignal chan struct{}
+	jobDone    chan struct{}
+}
+
+func NewJob(options Options, collector *Collector) *Job {
+	job := &Job{
+		options:    options,
+		collector:  collector,
+		logger:     slog.Default(),
+		now:        time.Now,
+		stopSignal: make(chan struct{}),
+		jobDone:    make(chan struct{}),
+	}
+	if options.WebhookURL != "" {
+		job.senders = append(job.senders, newWebhookSender(options))
+	}
+	if options.SMTP.Address != "" {
+		job.senders = append(job.senders, newSMTPSender(options.SMTP, options.Timeout))
+	}
+	return job
+}
+
+// next returns when the report after now is due. Reports cover whole UTC days, ending at the midnight before
+// they are sent.
+func (j *Job) next(now time.Time) time.Time {
+	now = now.UTC()
+	due := now.Truncate(24 * time.Hour).Add(j.options.At)
+	if j.options.Schedule == Weekly {
+		// Go back to this week's Monday, counting Sunday as the end of the week
+		due = due.AddDate(0, 0, -(int(due.Weekday())+6)%7)
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 7)
+		}
+		return due
+	}
+	if !due.After(now) {
+		due = due.AddDate(0, 0, 1)
+	}
+	return due
+}
+
+// Start sends the reports until Stop is called
+func (j *Job) Start() {
+	defer close(j.jobDone)
+	j.logger.Info("Starting report job", "schedule", j.options.Schedule)
+	for {
+		due := j.next(j.now())
+		timer := time.NewTimer(due.Sub(j.now()))
+		select {
+		case <-j.stopSignal:
+			timer.Stop()
+			return
+		case <-timer.C:
+			if j.options.Leader != nil && !j.options.Leader() {
+				j.logger.Debug("Skipping the report, another replica is the leader")
+				continue
+			}
+			to := due.Truncate(24 * time.Hour)
+			j.send(j.collector.Report(to.Add(-j.options.Period()), to, j.options.TopN))
+		}
+	}
+}
+
+func (j *Job) send(report Report) {
+	for _, s := range j.senders {
+		if err := s.send(report); err != nil {
+			metricReports.WithLabelValues(s.name(), "failed").Inc()
+			j.logger.Error("Failed to send report", "channel", s.name(), "error", err)
+			continue
+		}
+		metricReports.WithLabelValues(s.name(), "sent").Inc()
+	}
+}
+
+// Stop stops the job and waits for it to finish
+func (j *Job) Stop(ctx context.Context) error {
+	close(j.stopSignal)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-j.jobDone:
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	at, err := ParseTimeOfDay("08:30")
+	require.NoError(t, err)
+	assert.Equal(t, 8*time.Hour+30*time.Minute, at)
+
+	_, err = ParseTimeOfDay("8am")
+	assert.Error(t, err)
+}
+
+func TestJobNext(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 5, 15, 9, 0, 0, 0, time.UTC)
+
+	t.Run("Should send daily reports at the time of day", func(t *testing.T) {
+		job := NewJob(Options{Schedule: Daily, At: 10 * time.Hour}, NewCollector(24*time.Hour))
+		assert.Equal(t, time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC), job.next(now))
+
+		job.options.At = 8 * time.Hour
+		assert.Equal(t, time.Date(2024, 5, 16, 8, 0, 0, 0, time.UTC), job.next(now))
+	})
+
+	t.Run("Should send weekly reports on Mondays", func(t *testing.T) {
+		job := NewJob(Options{Schedule: Weekly, At: 8 * time.Hour}, NewCollector(7*24*time.Hour))
+		assert.Equal(t, time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC), job.next(now))
+		assert.Equal(t, time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC), job.next(time.Date(2024, 5, 19, 23, 0, 0, 0, time.UTC)))
+		assert.Equal(t, time.Date(2024, 5, 13, 8, 0, 0, 0, time.UTC), job.next(time.Date(2024, 5, 13, 7, 0, 0, 0, time.UTC)))
+	})
+}
@@ -0,0 +1,9 @@
+package report
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricReports = metrics.NewCounterVec(
+	"waf_reports_total",
+	"The total number of scheduled reports by channel (webhook, smtp) and result (sent, failed)",
+	[]string{"channel", "result"},
+)
@@ -0,0 +1,19 @@
+package script
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricHookDuration = metrics.NewHistogramVec(
+	"waf_script_hook_duration_seconds",
+	"The time taken to run a script hook, by hook",
+	[]float64{.0001, .0005, .001, .005, .01, .05, .1},
+	[]string{"hook"},
+)
+
+var metricHookErrors = metrics.NewCounterVec(
+	"waf_script_hook_errors_total",
+	"The total number of script hook calls that failed or timed out, by hook",
+	[]string{"hook"},
+	metrics.WithAlertAbove(0),
+)
@@ -0,0 +1,396 @@
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/pool"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Hook functions a script may define
+const (
+	HookRequest  = "on_request"
+	HookDecision = "on_decision"
+	HookAudit    = "on_audit"
+)
+
+const (
+	defaultTimeout      = 50 * time.Millisecond
+	defaultMaxStackSize = 64 * 1024
+	// maxRepeatSize bounds the strings string.rep builds, which would otherwise allocate gigabytes in one call
+	maxRepeatSize = 1 << 20
+)
+
+// Options configures the Lua script loaded at startup
+type Options struct {
+	// Path of the Lua script. An empty path disables scripting.
+	Path string
+	// Timeout bounds the CPU time of each hook call
+	Timeout time.Duration
+	// MaxStackSize bounds the Lua value stack of each hook call
+	MaxStackSize int
+}
+
+// Result is a hook's verdict. A denied request is answered with Status, defaulting to 403.
+type Result struct {
+	Allow  bool
+	Status int
+	Reason string
+}
+
+// Engine runs the hooks of an operator-provided Lua script. Scripts run in a sandbox without access to the file
+// system, the OS or other modules, and each hook call is bounded by a timeout and a stack size. The interpreter has
+// no memory limit: repeated concatenation with .., table.concat or a growing table can exhaust the process memory
+// well within the timeout, so scripts must be trusted.
+type Engine struct {
+	options Options
+	hooks   map[string]bool
+	states  *pool.Pool[*lua.LState]
+}
+
+// Load compiles the script and reports which hooks it defines
+func Load(options Options) (*Engine, error) {
+	source, err := os.ReadFile(options.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(source)), options.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	proto, err := lua.Compile(chunk, options.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script: %w", err)
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.MaxStackSize <= 0 {
+		options.MaxStackSize = defaultMaxStackSize
+	}
+	e := &Engine{options: options, hooks: map[string]bool{}}
+	// Run the script once up front so that errors in its top level fail startup rather than the first request
+	L, err := e.newState(proto)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range []string{HookRequest, HookDecision, HookAudit} {
+		if L.GetGlobal(hook).Type() == lua.LTFunction {
+			e.hooks[hook] = true
+		}
+	}
+	if len(e.hooks) == 0 {
+		return nil, fmt.Errorf("script defines none of %s, %s or %s", HookRequest, HookDecision, HookAudit)
+	}
+
+	e.states = pool.New("lua_state", func() *lua.LState {
+		L, err := e.newState(proto)
+		if err != nil {
+			// The same script already ran successfully in Load, so this can only be a resource limit
+			slog.Error("Failed to initialize script", "error", err, "path", options.Path)
+		}
+		return L
+	}, func(L *lua.LState) { L.SetTop(0) })
+	e.states.Put(L)
+	return e, nil
+}
+
+// Has reports whether the script defines the hook
+func (e *Engine) Has(hook string) bool {
+	return e.hooks[hook]
+}
+
+// newState creates a sandboxed interpreter and runs the script's top level in it
+func (e *Engine) newState(proto *lua.FunctionProto) (*lua.LState, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       120,
+		RegistrySize:        min(1024, e.options.MaxStackSize),
+		RegistryMaxSize:     e.options.MaxStackSize,
+		IncludeGoStackTrace: false,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// string.rep is bounded since a single call can allocate gigabytes, but the sandbox has no memory limit
+	stringLib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	stringLib.RawSetString("rep", L.NewFunction(boundedRepeat))
+	// Remove the base functions that reach outside the sandbox
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "getfenv", "setfenv", "newproxy"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		var parts []string
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		slog.Info(strings.Join(parts, " "), "source", "script", "path", e.options.Path)
+		return 0
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.options.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("failed to run script: %w", err)
+	}
+	return L, nil
+}
+
+// boundedRepeat is string.rep, raising an error instead of building a string over maxRepeatSize
+func boundedRepeat(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(str) > 0 && n > maxRepeatSize/len(str) {
+		L.RaiseError("string.rep result is larger than %d bytes", maxRepeatSize)
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// call runs a hook with a single argument, returning its first result
+func (e *Engine) call(ctx context.Context, hook string, arg func(*lua.LState) lua.LValue) (result lua.LValue, L *lua.LState, err error) {
+	L = e.states.Get()
+	if L == nil {
+		return lua.LNil, nil, errors.New("script interpreter is unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.options.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	start := time.Now()
+	err = L.CallByParam(lua.P{Fn: L.GetGlobal(hook), NRet: 1, Protect: true}, arg(L))
+	metricHookDuration.WithLabelValues(hook).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metricHookErrors.WithLabelValues(hook).Inc()
+		// The interpreter may have been left in an inconsistent state
+		L.Close()
+		return lua.LNil, nil, fmt.Errorf("%s failed: %w", hook, err)
+	}
+	result = L.Get(-1)
+	L.Pop(1)
+	return result, L, nil
+}
+
+func (e *Engine) release(L *lua.LState) {
+	if L != nil {
+		e.states.Put(L)
+	}
+}
+
+// OnRequest passes the request to on_request before it is evaluated by the WAF. Changes the hook makes to the
+// method, URI, host and headers are applied to r.
+func (e *Engine) OnRequest(r *http.Request) (Result, error) {
+	var request *lua.LTable
+	result, L, err := e.call(r.Context(), HookRequest, func(L *lua.LState) lua.LValue {
+		request = requestTable(L, r)
+		return request
+	})
+	defer e.release(L)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := applyRequestTable(request, r); err != nil {
+		return Result{}, fmt.Errorf("%s returned an invalid request: %w", HookRequest, err)
+	}
+	return toResult(result), nil
+}
+
+// OnDecision passes the WAF's verdict and signals to on_decision, which may deny a request the WAF allowed
+func (e *Engine) OnDecision(ctx context.Context, decision any) (Result, error) {
+	result, L, err := e.call(ctx, HookDecision, func(L *lua.LState) lua.LValue { return toLua(L, decision) })
+	defer e.release(L)
+	if err != nil {
+		return Result{}, err
+	}
+	return toResult(result), nil
+}
+
+// Name implements audit.Sink
+func (e *Engine) Name() string {
+	return "script"
+}
+
+// Send passes a processed audit log to on_audit
+func (e *Engine) Send(log audit.Log) error {
+	_, L, err := e.call(context.Background(), HookAudit, func(L *lua.LState) lua.LValue { return toLua(L, log) })
+	e.release(L)
+	return err
+}
+
+func (e *Engine) Flush(force bool) error {
+	return nil
+}
+
+// requestTable exposes the request to Lua. Header names are canonicalized and only the first value is kept.
+func requestTable(L *lua.LState, r *http.Request) *lua.LTable {
+	headers := L.NewTable()
+	for name, values := range r.Header {
+		if len(values) > 0 {
+			headers.RawSetString(name, lua.LString(values[0]))
+		}
+	}
+	request := L.NewTable()
+	request.RawSetString("method", lua.LString(r.Method))
+	request.RawSetString("uri", lua.LString(r.URL.RequestURI()))
+	request.RawSetString("host", lua.LString(r.Host))
+	request.RawSetString("client_ip", lua.LString(middleware.ClientIP(r)))
+	request.RawSetString("headers", headers)
+	return request
+}
+
+// requestString returns a string field of the request table, rejecting other types rather than converting them
+func requestString(request *lua.LTable, field string) (string, error) {
+	value, ok := request.RawGetString(field).(lua.LString)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %s", field, request.RawGetString(field).Type())
+	}
+	return string(value), nil
+}
+
+// applyRequestTable copies the hook's changes back onto the request. The request is left unchanged when the table is
+// invalid.
+func applyRequestTable(request *lua.LTable, r *http.Request) error {
+	method, err := requestString(request, "method")
+	if err != nil {
+		return err
+	}
+	if method == "" {
+		return errors.New("method must not be empty")
+	}
+	uri, err := requestString(request, "uri")
+	if err != nil {
+		return err
+	}
+	host, err := requestString(request, "host")
+	if err != nil {
+		return err
+	}
+	var parsed *url.URL
+	if uri != r.URL.RequestURI() {
+		if parsed, err = url.ParseRequestURI(uri); err != nil {
+			return err
+		}
+	}
+	headers, ok := request.RawGetString("headers").(*lua.LTable)
+	if !ok {
+		return errors.New("headers must be a table")
+	}
+	values := map[string]string{}
+	headers.ForEach(func(name, value lua.LValue) {
+		key, keyOK := name.(lua.LString)
+		text, valueOK := value.(lua.LString)
+		if (!keyOK || !valueOK) && err == nil {
+			err = fmt.Errorf("header %s must be a string, got %s", name, value.Type())
+		}
+		values[http.CanonicalHeaderKey(string(key))] = string(text)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.Method, r.Host = method, host
+	if parsed != nil {
+		r.URL.Path, r.URL.RawPath, r.URL.RawQuery = parsed.Path, parsed.RawPath, parsed.RawQuery
+		r.RequestURI = uri
+	}
+	for name, value := range values {
+		if current := r.Header.Values(name); len(current) == 0 || current[0] != value {
+			r.Header.Set(name, value)
+		}
+	}
+	for name := range r.Header {
+		if _, ok := values[name]; !ok {
+			r.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// toResult interprets a hook's return value: nil and true allow, false denies, and a table sets allow, status and reason
+func toResult(value lua.LValue) Result {
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return Result{Allow: true}
+	case lua.LBool:
+		return Result{Allow: bool(v)}
+	case *lua.LTable:
+		result := Result{Allow: lua.LVAsBool(v.RawGetString("allow")), Reason: lua.LVAsString(v.RawGetString("reason"))}
+		if status, ok := v.RawGetString("status").(lua.LNumber); ok {
+			result.Status = int(status)
+		}
+		return result
+	default:
+		return Result{Allow: lua.LVAsBool(v)}
+	}
+}
+
+// toLua converts a value to Lua through its JSON representation
+func toLua(L *lua.LState, value any) lua.LValue {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return lua.LNil
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return lua.LNil
+	}
+	return jsonToLua(L, decoded)
+}
+
+func jsonToLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case map[string]any:
+		table := L.CreateTable(0, len(v))
+		for key, item := range v {
+			table.RawSetString(key, jsonToLua(L, item))
+		}
+		return table
+	case []any:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(jsonToLua(L, item))
+		}
+		return table
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	default:
+		return lua.LNil
+	}
+}
@@ -0,0 +1,159 @@
+package script
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadScript(t *testing.T, source string) (*Engine, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.lua")
+	assert.NoError(t, os.WriteFile(path, []byte(source), 0o644))
+	return Load(Options{Path: path, Timeout: 50 * time.Millisecond})
+}
+
+func TestLoad(t *testing.T) {
+	engine, err := loadScript(t, `function on_request(req) end`)
+	assert.NoError(t, err)
+	assert.True(t, engine.Has(HookRequest))
+	assert.False(t, engine.Has(HookDecision))
+
+	t.Run("Should reject invalid scripts", func(t *testing.T) {
+		_, err := Load(Options{Path: filepath.Join(t.TempDir(), "missing.lua")})
+		assert.ErrorContains(t, err, "failed to read script")
+
+		_, err = loadScript(t, `function on_request(req`)
+		assert.ErrorContains(t, err, "failed to parse script")
+
+		_, err = loadScript(t, `error("boom")`)
+		assert.ErrorContains(t, err, "failed to run script")
+
+		_, err = loadScript(t, `local x = 1`)
+		assert.ErrorContains(t, err, "defines none of")
+	})
+
+	t.Run("Should not expose the OS, files or modules", func(t *testing.T) {
+		engine, err := loadScript(t, `
+			function on_decision(d)
+				return os == nil and io == nil and require == nil and dofile == nil and load == nil
+			end`)
+		assert.NoError(t, err)
+		result, err := engine.OnDecision(context.Background(), map[string]any{})
+		assert.NoError(t, err)
+		assert.True(t, result.Allow)
+	})
+}
+
+func TestOnRequest(t *testing.T) {
+	engine, err := loadScript(t, `
+		function on_request(req)
+			if req.headers["X-Block"] then
+				return {allow = false, status = 451, reason = "blocked by script"}
+			end
+			req.headers["X-Tenant"] = "acme"
+			req.headers["Cookie"] = nil
+			req.uri = string.lower(req.uri)
+			req.method = "POST"
+		end`)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/Orders/42?Sort=ASC", nil)
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("Accept", "*/*")
+	result, err := engine.OnRequest(req)
+	assert.NoError(t, err)
+	assert.True(t, result.Allow)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/orders/42", req.URL.Path)
+	assert.Equal(t, "sort=asc", req.URL.RawQuery)
+	assert.Equal(t, "acme", req.Header.Get("X-Tenant"))
+	assert.Empty(t, req.Header.Get("Cookie"))
+	assert.Equal(t, "*/*", req.Header.Get("Accept"))
+
+	t.Run("Should deny", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Block", "1")
+		result, err := engine.OnRequest(req)
+		assert.NoError(t, err)
+		assert.Equal(t, Result{Status: 451, Reason: "blocked by script"}, result)
+	})
+
+	t.Run("Should reject fields that are not strings without changing the request", func(t *testing.T) {
+		for _, change := range []string{`req.method = nil`, `req.host = 42`, `req.uri = {}`, `req.headers["X-Count"] = 1`} {
+			engine, err := loadScript(t, `function on_request(req) `+change+` end`)
+			assert.NoError(t, err)
+			req := httptest.NewRequest("GET", "/", nil)
+			_, err = engine.OnRequest(req)
+			assert.ErrorContains(t, err, "must be a string", change)
+			assert.Equal(t, "GET", req.Method, change)
+			assert.Equal(t, "example.com", req.Host, change)
+		}
+	})
+}
+
+func TestHookLimits(t *testing.T) {
+	engine, err := loadScript(t, `
+		function on_request(req)
+			while true do end
+		end
+		function on_decision(d)
+			local function recurse(n) return recurse(n + 1) + 1 end
+			return recurse(1)
+		end`)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = engine.OnRequest(httptest.NewRequest("GET", "/", nil))
+	assert.Error(t, err, "Expected the timeout to stop the loop")
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = engine.OnDecision(context.Background(), map[string]any{})
+	assert.Error(t, err, "Expected unbounded recursion to hit the stack limit")
+
+	t.Run("Should bound the strings built by string.rep", func(t *testing.T) {
+		engine, err := loadScript(t, `
+			function on_decision(d)
+				return #string.rep("ab", 1024) == 2048 and pcall(string.rep, "x", 2^31) == false
+			end`)
+		assert.NoError(t, err)
+		result, err := engine.OnDecision(context.Background(), map[string]any{})
+		assert.NoError(t, err)
+		assert.True(t, result.Allow)
+	})
+
+	t.Run("Should recover after a failed call", func(t *testing.T) {
+		_, err := engine.OnRequest(httptest.NewRequest("GET", "/", nil))
+		assert.Error(t, err)
+	})
+}
+
+func TestOnDecisionAndAudit(t *testing.T) {
+	engine, err := loadScript(t, `
+		audited = {}
+		function on_decision(d)
+			return d.anomaly_score < 5 and d.tags[1] ~= "attack-rce"
+		end
+		function on_audit(log)
+			table.insert(audited, log.transaction.id)
+			if log.transaction.id == "fail" then error("rejected") end
+		end`)
+	assert.NoError(t, err)
+
+	result, err := engine.OnDecision(context.Background(), map[string]any{"anomaly_score": 3, "tags": []string{"attack-sqli"}})
+	assert.NoError(t, err)
+	assert.True(t, result.Allow)
+	result, err = engine.OnDecision(context.Background(), map[string]any{"anomaly_score": 3, "tags": []string{"attack-rce"}})
+	assert.NoError(t, err)
+	assert.False(t, result.Allow)
+
+	var sink audit.Sink = engine
+	assert.NoError(t, sink.Send(audit.Log{Transaction: audit.Transaction{ID: "abc"}}))
+	assert.ErrorContains(t, sink.Send(audit.Log{Transaction: audit.Transaction{ID: "fail"}}), "rejected")
+}
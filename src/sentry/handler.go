@@ -0,0 +1,202 @@
+package sentry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
+)
+
+// module marks the stack frames of this repository as in-app, so Sentry highlights them
+const module = "github.com/chairswithlegs/coraza-traefik-middleware"
+
+// sensitiveHeaders are left out of reported requests
+var sensitiveHeaders = []string{"auth", "cookie", "token", "key", "secret", "session"}
+
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Message     string            `json:"message"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+	Request     *request          `json:"request,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string     `json:"type"`
+	Value      string     `json:"value"`
+	Stacktrace stacktrace `json:"stacktrace"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type request struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// Handler reports records at the error level to Sentry and passes every record on to the next handler. Records
+// logged with a context from logging.WithRequest carry the request.
+type Handler struct {
+	next   slog.Handler
+	client *Client
+	attrs  []slog.Attr
+	group  string
+}
+
+func NewHandler(next slog.Handler, client *Client) *Handler {
+	return &Handler{next: next, client: client}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		h.client.capture(h.event(ctx, record))
+	}
+	if !h.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	qualified := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	qualified = append(qualified, h.attrs...)
+	for _, attr := range attrs {
+		qualified = append(qualified, slog.Attr{Key: h.group + attr.Key, Value: attr.Value})
+	}
+	return &Handler{next: h.next.WithAttrs(attrs), client: h.client, attrs: qualified, group: h.group}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), client: h.client, attrs: h.attrs, group: h.group + name + "."}
+}
+
+func (h *Handler) event(ctx context.Context, record slog.Record) *event {
+	e := &event{
+		EventID:   newEventID(),
+		Timestamp: record.Time.UTC(),
+		Level:     "error",
+		Platform:  "go",
+		Logger:    "slog",
+		Message:   record.Message,
+		Extra:     map[string]any{},
+	}
+	value := record.Message
+	addAttr := func(attr slog.Attr) {
+		attr.Value = attr.Value.Resolve()
+		if attr.Key == h.group+"error" {
+			value = fmt.Sprint(attr.Value.Any())
+			return
+		}
+		// Failure classes and sinks are worth filtering on, e.g. to route OPA errors to their owners
+		if attr.Key == "class" || attr.Key == "sink" {
+			if e.Tags == nil {
+				e.Tags = map[string]string{}
+			}
+			e.Tags[attr.Key] = attr.Value.String()
+		}
+		e.Extra[attr.Key] = attr.Value.String()
+	}
+	for _, attr := range h.attrs {
+		addAttr(attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(slog.Attr{Key: h.group + attr.Key, Value: attr.Value})
+		return true
+	})
+
+	// The message names the kind of failure, so Sentry groups the events by it and the stack
+	e.Exception = &exceptions{Values: []exception{{Type: record.Message, Value: value, Stacktrace: stacktrace{Frames: callers()}}}}
+	if r := logging.RequestFrom(ctx); r != nil {
+		e.Request = newRequest(r)
+	}
+	return e
+}
+
+// callers returns the stack of the logging call, outermost frame first as Sentry expects. While a panic is being
+// recovered the stack still holds the frames that panicked.
+func callers() []frame {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+	frames := runtime.CallersFrames(pcs)
+	var result []frame
+	for {
+		f, more := frames.Next()
+		// Drop the frames of this handler and of slog above the logging call
+		if strings.HasPrefix(f.Function, "log/slog.") {
+			result = nil
+		} else {
+			function, pkg := f.Function, ""
+			slash := max(strings.LastIndex(function, "/"), 0)
+			if dot := strings.Index(function[slash:], "."); dot >= 0 {
+				pkg, function = function[:slash+dot], function[slash+dot+1:]
+			}
+			result = append(result, frame{Function: function, Module: pkg, AbsPath: f.File, Lineno: f.Line, InApp: strings.HasPrefix(pkg, module)})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+func newRequest(r *http.Request) *request {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	req := &request{
+		Method:      r.Method,
+		URL:         scheme + "://" + r.Host + r.URL.Path,
+		QueryString: r.URL.RawQuery,
+		Headers:     map[string]string{},
+		Env:         map[string]string{"REMOTE_ADDR": r.RemoteAddr},
+	}
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		sensitive := false
+		for _, s := range sensitiveHeaders {
+			sensitive = sensitive || strings.Contains(lower, s)
+		}
+		if !sensitive {
+			req.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return req
+}
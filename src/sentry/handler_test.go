@@ -0,0 +1,68 @@
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	fake, dsn := newFakeSentry(t)
+	client, err := New(Options{DSN: dsn, Timeout: time.Second, QueueSize: 10})
+	require.NoError(t, err)
+	var output bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelInfo}), client))
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, client.Flush(ctx))
+	}
+
+	t.Run("Should report errors with the request and pass every record on", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://example.com/search?q=1", nil)
+		r.Header.Set("User-Agent", "curl")
+		r.Header.Set("Authorization", "Bearer secret")
+		logger.Info("Started")
+		logger.With("component", "waf").ErrorContext(logging.WithRequest(context.Background(), r), "WAF failed to evaluate request", "class", "opa", "error", errors.New("connection refused"))
+		flush()
+
+		assert.Contains(t, output.String(), "Started")
+		assert.Contains(t, output.String(), "connection refused")
+		require.Len(t, fake.events, 1)
+		event := fake.events[0]
+		assert.Equal(t, "error", event["level"])
+		assert.Equal(t, map[string]any{"class": "opa"}, event["tags"])
+		assert.Equal(t, map[string]any{"component": "waf", "class": "opa"}, event["extra"])
+
+		exception := event["exception"].(map[string]any)["values"].([]any)[0].(map[string]any)
+		assert.Equal(t, "WAF failed to evaluate request", exception["type"])
+		assert.Equal(t, "connection refused", exception["value"])
+		frames := exception["stacktrace"].(map[string]any)["frames"].([]any)
+		last := frames[len(frames)-1].(map[string]any)
+		assert.Equal(t, "TestHandler.func2", last["function"], "Expected the innermost frame to be the logging call")
+		assert.Equal(t, true, last["in_app"])
+
+		request := event["request"].(map[string]any)
+		assert.Equal(t, "http://example.com/search", request["url"])
+		assert.Equal(t, "q=1", request["query_string"])
+		assert.Equal(t, map[string]any{"User-Agent": "curl"}, request["headers"])
+	})
+
+	t.Run("Should report errors below the next handler's level", func(t *testing.T) {
+		fake.events = nil
+		quiet := slog.New(NewHandler(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.Level(12)}), client))
+		quiet.Warn("Ignored")
+		quiet.Error("Failed to process audit log file", "file", "audit.log")
+		flush()
+		require.Len(t, fake.events, 1)
+		assert.Equal(t, "Failed to process audit log file", fake.events[0]["message"])
+	})
+}
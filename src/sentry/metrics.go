@@ -0,0 +1,9 @@
+package sentry
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+
+var metricEvents = metrics.NewCounterVec(
+	"waf_sentry_events_total",
+	"The total number of error events reported to Sentry by result (sent, failed, queue_full)",
+	[]string{"result"},
+)
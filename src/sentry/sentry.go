@@ -0,0 +1,178 @@
+// Package sentry reports errors of the WAF itself to Sentry, without the Sentry SDK
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+const clientName = "coraza-traefik-middleware/1.0"
+
+type Options struct {
+	// DSN is the project's client key, e.g. https://<key>@o0.ingest.sentry.io/<project>
+	DSN string
+	// Environment and Release are attached to every event, e.g. production and the image tag
+	Environment string
+	Release     string
+	// ServerName identifies the replica, e.g. the pod name
+	ServerName string
+	Timeout    time.Duration
+	// QueueSize bounds the events waiting to be sent. Events are dropped while the queue is full.
+	QueueSize int
+	// Transport carries the events. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+// Client sends events to Sentry from a background goroutine, so reporting an error never blocks the caller
+type Client struct {
+	options  Options
+	endpoint string
+	auth     string
+	client   *http.Client
+	queue    chan *event
+	flushes  chan chan struct{}
+	logger   *slog.Logger
+}
+
+// ParseDSN returns the envelope endpoint and public key of a DSN
+func ParseDSN(dsn string) (endpoint string, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("invalid DSN: scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("invalid DSN: missing public key")
+	}
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return "", "", errors.New("invalid DSN: missing project ID")
+	}
+	return u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/envelope/", u.User.Username(), nil
+}
+
+func New(options Options) (*Client, error) {
+	endpoint, key, err := ParseDSN(options.DSN)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		options:  options,
+		endpoint: endpoint,
+		auth:     "Sentry sentry_version=7, sentry_client=" + clientName + ", sentry_key=" + key,
+		client:   outbound.Client(options.Transport, "sentry", options.Timeout),
+		queue:    make(chan *event, options.QueueSize),
+		flushes:  make(chan chan struct{}),
+		logger:   slog.Default(),
+	}
+	go c.run()
+	return c, nil
+}
+
+// capture queues an event, dropping it if the queue is full
+func (c *Client) capture(e *event) {
+	e.Environment = c.options.Environment
+	e.Release = c.options.Release
+	e.ServerName = c.options.ServerName
+	select {
+	case c.queue <- e:
+	default:
+		metricEvents.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Flush waits until the queued events have been sent or ctx is done
+func (c *Client) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case c.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) run() {
+	for {
+		select {
+		case e := <-c.queue:
+			c.send(e)
+		case done := <-c.flushes:
+			for len(c.queue) > 0 {
+				c.send(<-c.queue)
+			}
+			close(done)
+		}
+	}
+}
+
+func (c *Client) send(e *event) {
+	if err := c.post(e); err != nil {
+		metricEvents.WithLabelValues("failed").Inc()
+		// Logged below the error level, which would report the failure to Sentry again
+		c.logger.Warn("Failed to send event to Sentry", "error", err)
+		return
+	}
+	metricEvents.WithLabelValues("sent").Inc()
+}
+
+// post sends the event as an envelope, Sentry's ingestion format
+func (c *Client) post(e *event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": e.EventID, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sentry responded %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+func newEventID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,80 @@
+package sentry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDSN(t *testing.T) {
+	endpoint, key, err := ParseDSN("https://public@o1.ingest.sentry.io/42")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://o1.ingest.sentry.io/api/42/envelope/", endpoint)
+	assert.Equal(t, "public", key)
+
+	endpoint, _, err = ParseDSN("http://public@sentry.internal:9000/sentry/7")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://sentry.internal:9000/sentry/api/7/envelope/", endpoint)
+
+	for _, dsn := range []string{"sentry.io/42", "https://o1.ingest.sentry.io/42", "https://public@o1.ingest.sentry.io/"} {
+		_, _, err := ParseDSN(dsn)
+		assert.Error(t, err, dsn)
+	}
+}
+
+// fakeSentry records the events of the envelopes it receives
+type fakeSentry struct {
+	mu     sync.Mutex
+	events []map[string]any
+	auth   string
+}
+
+func newFakeSentry(t *testing.T) (*fakeSentry, string) {
+	fake := &fakeSentry{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/42/envelope/", r.URL.Path)
+		assert.Equal(t, "application/x-sentry-envelope", r.Header.Get("Content-Type"))
+		lines := bufio.NewScanner(r.Body)
+		var envelope []map[string]any
+		for lines.Scan() {
+			var line map[string]any
+			assert.NoError(t, json.Unmarshal(lines.Bytes(), &line))
+			envelope = append(envelope, line)
+		}
+		require.Len(t, envelope, 3)
+		assert.Equal(t, "event", envelope[1]["type"])
+		assert.Equal(t, envelope[0]["event_id"], envelope[2]["event_id"])
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.auth = r.Header.Get("X-Sentry-Auth")
+		fake.events = append(fake.events, envelope[2])
+	}))
+	t.Cleanup(server.Close)
+	return fake, strings.Replace(server.URL, "://", "://public@", 1) + "/42"
+}
+
+func TestClient(t *testing.T) {
+	fake, dsn := newFakeSentry(t)
+	client, err := New(Options{DSN: dsn, Environment: "production", Release: "1.2.3", Timeout: time.Second, QueueSize: 10})
+	require.NoError(t, err)
+
+	client.capture(&event{EventID: newEventID(), Message: "Failed"})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, client.Flush(ctx))
+
+	require.Len(t, fake.events, 1)
+	assert.Equal(t, "Failed", fake.events[0]["message"])
+	assert.Equal(t, "production", fake.events[0]["environment"])
+	assert.Equal(t, "1.2.3", fake.events[0]["release"])
+	assert.Contains(t, fake.auth, "sentry_key=public")
+}
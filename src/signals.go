@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
+)
+
+// handleLogLevelSignals switches to debug logging on SIGUSR1 and back to the configured level on SIGUSR2
+func handleLogLevelSignals(levels *loglevel.Controller) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				levels.Set(slog.LevelDebug, 0)
+			} else {
+				levels.Reset()
+			}
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/chairswithlegs/coraza-traefik-middleware/src/loglevel"
+
+// handleLogLevelSignals does nothing on Windows, which has no SIGUSR1 and SIGUSR2. Use the admin API to change the
+// log level instead.
+func handleLogLevelSignals(levels *loglevel.Controller) {}
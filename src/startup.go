@@ -0,0 +1,522 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/alert"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/audit"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/capture"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/coraza"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/events"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/geoip"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/leader"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/middleware"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/mirror"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/nats"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/report"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/script"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/sentry"
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/threatintel"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// startupCheck is the outcome of a single configuration check
+type startupCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// startupReport summarizes the configuration checks performed on boot
+type startupReport struct {
+	Valid  bool           `json:"valid"`
+	Checks []startupCheck `json:"checks"`
+}
+
+func (r *startupReport) add(name string, err error, detail string) {
+	check := startupCheck{Name: name, OK: err == nil, Detail: detail}
+	if err != nil {
+		check.Detail = err.Error()
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// validateConfig checks the loaded configuration against the environment it will run in. The directives are only
+// compiled when compileDirectives is set.
+func validateConfig(cfg config, configErr error, compileDirectives bool) startupReport {
+	report := startupReport{Valid: true}
+
+	report.add("environment", configErr, "all settings parsed")
+	report.add("job_intervals", validatePositive(map[string]time.Duration{
+		"AUDIT_LOG_PROCESSING_JOB_INTERVAL": cfg.AuditLogProcessor.ProcessingJobInterval,
+		"AUDIT_LOG_EXPIRATION_JOB_INTERVAL": cfg.AuditLogProcessor.ExpirationJobInterval,
+		"SUMMARY_JOB_INTERVAL":              cfg.SummaryJobInterval,
+	}), "all job intervals are positive")
+	report.add("waf_port", validatePort(cfg.WAFPort), cfg.WAFPort)
+	report.add("admin_port", validatePort(cfg.AdminPort), cfg.AdminPort)
+	if cfg.WAFPort == cfg.AdminPort {
+		report.add("distinct_ports", fmt.Errorf("WAF and admin servers cannot share port %s", cfg.WAFPort), "")
+	}
+	if len(cfg.WAFListeners) > 0 {
+		report.add("waf_listeners", validateWAFListeners(cfg.WAFListeners, cfg.WAFPort, cfg.AdminPort), fmt.Sprintf("%d additional listeners", len(cfg.WAFListeners)))
+	}
+	auditLocation, auditStorageErr := audit.ResolveStorage(cfg.AuditLogProcessor)
+	report.add("audit_log_storage", auditStorageErr, auditLocation.String())
+	if cfg.StorePath != "" {
+		report.add("store_directory", validateWritableDir(filepath.Dir(cfg.StorePath)), filepath.Dir(cfg.StorePath))
+	}
+	if cfg.ChangeLogPath != "" {
+		report.add("change_log_directory", validateWritableDir(filepath.Dir(cfg.ChangeLogPath)), filepath.Dir(cfg.ChangeLogPath))
+	}
+	if dir := cfg.WAFHandler.DirectiveHistory.Dir; dir != "" {
+		report.add("directives_history_directory", validateWritableDir(existingDir(dir)), dir)
+	}
+	if cfg.Capture.Dir != "" {
+		report.add("capture", validateCapture(cfg.Capture), cfg.Capture.Dir)
+	}
+	if cfg.Events.Dir != "" {
+		report.add("event_store", validateEventStore(cfg.Events), cfg.Events.Dir)
+	}
+	if fields := cfg.WAFHandler.AccessLogFields; len(fields) > 0 {
+		report.add("access_log_tx_fields", validateAccessLogFields(fields), strings.Join(fields, ","))
+	}
+	report.add("deny_status", validateDenyResponse(cfg.WAFHandler.DenyResponse), "deny statuses are valid")
+	if cookies := cfg.WAFHandler.CookieIntegrity; len(cookies.Cookies) > 0 && len(cookies.Secret) == 0 {
+		report.add("cookie_integrity", errors.New("COOKIE_INTEGRITY_SECRET is required when COOKIE_INTEGRITY_COOKIES is set"), "")
+	}
+	// Remote services are only checked for well-formed settings: none of them is contacted, so an unreachable or
+	// misauthenticated service only shows once the WAF runs
+	if cfg.Mirror.URL != "" {
+		report.add("mirror", validateMirror(cfg.Mirror), redactURL(cfg.Mirror.URL))
+	}
+	if webhook := cfg.WAFHandler.DecisionWebhook; webhook.URL != "" {
+		report.add("decision_webhook", validateDecisionWebhook(webhook), redactURL(webhook.URL))
+	}
+	if opa := cfg.WAFHandler.OPA; opa.URL != "" {
+		report.add("opa", validateOPA(opa), redactURL(opa.URL))
+	}
+	if scoring := cfg.WAFHandler.Scoring; scoring.URL != "" {
+		report.add("scoring", validateScoring(scoring), redactURL(scoring.URL))
+	}
+	if cfg.Loki.URL != "" {
+		report.add("loki", validateLoki(cfg.Loki), redactURL(cfg.Loki.URL))
+	}
+	if cfg.Fluent.Address != "" {
+		report.add("fluent", validateFluent(cfg.Fluent), cfg.Fluent.Address)
+	}
+	if cfg.CloudWatch.LogGroup != "" {
+		report.add("cloudwatch", validateCloudWatch(cfg.CloudWatch), cfg.CloudWatch.LogGroup)
+	}
+	if gc := cfg.GoogleCloud; gc.LogName != "" || gc.Topic != "" {
+		report.add("google_cloud", validateGoogleCloud(gc), strings.TrimSpace(gc.LogName+" "+gc.Topic))
+	}
+	if cfg.NATS.URL != "" {
+		report.add("nats", validateNATS(cfg.NATS), redactURL(cfg.NATS.URL))
+	}
+	if cfg.Alert.Path != "" {
+		_, err := alert.Load(cfg.Alert.Path)
+		report.add("alert_rules", err, cfg.Alert.Path)
+	}
+	if cfg.Report.Schedule != "" {
+		report.add("report", validateReport(cfg.Report), cfg.Report.Schedule)
+	}
+	if cfg.Leader.Backend != "" {
+		report.add("leader_election", validateLeaderElection(cfg.Leader), cfg.Leader.Backend+" "+cfg.Leader.Name)
+	}
+	if cfg.ASNDatabase != "" || len(cfg.ASNPolicies) > 0 {
+		report.add("asn_database", validateASNDatabase(cfg.ASNDatabase), fmt.Sprintf("%s, %d policies", cfg.ASNDatabase, len(cfg.ASNPolicies)))
+	}
+	if len(cfg.Webhooks.Endpoints) > 0 {
+		report.add("webhook_signatures", validateWebhooks(cfg.Webhooks), fmt.Sprintf("%d endpoints", len(cfg.Webhooks.Endpoints)))
+	}
+	if len(cfg.ThreatIntel.Feeds) > 0 {
+		report.add("threat_intel", validateThreatIntel(cfg.ThreatIntel), fmt.Sprintf("%d feeds", len(cfg.ThreatIntel.Feeds)))
+	}
+	if cfg.Sentry.DSN != "" {
+		report.add("sentry", validateSentry(cfg.Sentry), cfg.Sentry.Environment)
+	}
+	if cfg.Script.Path != "" {
+		_, err := script.Load(cfg.Script)
+		report.add("script", err, cfg.Script.Path)
+	}
+	if cfg.Outbound.CAFile != "" {
+		_, err := outbound.NewTransport(cfg.Outbound)
+		report.add("outbound_ca_file", err, cfg.Outbound.CAFile)
+	}
+	// Outside a dry run the directives are compiled once, by the WAF handler, rather than a second time here
+	outcome := "loaded, compiled when the WAF handler is built"
+	if compileDirectives {
+		outcome = "compiled"
+	}
+	validate := coraza.ValidateOptions{
+		Compile:  compileDirectives,
+		Webhooks: len(cfg.Webhooks.Endpoints) > 0,
+	}
+	if auditStorageErr == nil {
+		// The storage failure is already reported, rather than again as a directive error
+		validate.AuditLogDirectives = auditLocation.ValidationDirectives()
+	}
+	report.add("directives", coraza.ValidateDirectives("", validate), "directives "+outcome)
+	for _, l := range cfg.WAFListeners {
+		report.add("directives_"+l.Name, coraza.ValidateDirectives(l.directivesVar(), validate), l.directivesVar()+" "+outcome)
+	}
+
+	return report
+}
+
+// addWarmup records how long the directives took to warm up and how many attack probes they detected
+func (r *startupReport) addWarmup(warmup coraza.WarmupReport) {
+	if warmup.Transactions == 0 {
+		r.add("warmup", nil, "disabled")
+		return
+	}
+	r.add("warmup", nil, fmt.Sprintf("%d transactions in %s, %d of %d attack probes detected",
+		warmup.Transactions, warmup.Duration.Round(time.Millisecond), warmup.Detected, warmup.Probes))
+}
+
+func validateAccessLogFields(fields []string) error {
+	for _, field := range fields {
+		if !coraza.ValidAccessLogField(field) {
+			return fmt.Errorf("ACCESS_LOG_TX_FIELDS must list anomaly_score, matched_vars, attack_types or tx.<name>, got %q", field)
+		}
+	}
+	return nil
+}
+
+func validatePositive(durations map[string]time.Duration) error {
+	var errs []error
+	for name, d := range durations {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, d))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validatePort(port string) error {
+	parsed, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	if parsed < 1 || parsed > 65535 {
+		return fmt.Errorf("port %d is out of range", parsed)
+	}
+	return nil
+}
+
+// validateDenyResponse ensures denied requests are never answered with a status Traefik treats as allowed
+func validateDenyResponse(deny coraza.DenyResponse) error {
+	var errs []error
+	if deny.Status != 0 && (deny.Status < 300 || deny.Status > 599) {
+		errs = append(errs, fmt.Errorf("DENY_STATUS must be between 300 and 599, got %d", deny.Status))
+	}
+	for _, mapping := range deny.StatusMap {
+		if mapping.Status < 300 || mapping.Status > 599 {
+			errs = append(errs, fmt.Errorf("DENY_STATUS_MAP statuses must be between 300 and 599, got %d", mapping.Status))
+		}
+	}
+	if deny.AppealEmail != "" {
+		if address, err := mail.ParseAddress(deny.AppealEmail); err != nil || address.Address != deny.AppealEmail {
+			errs = append(errs, fmt.Errorf("DENY_APPEAL_EMAIL must be a bare email address, got %q", deny.AppealEmail))
+		}
+	}
+	if len(deny.Actions) > 0 {
+		if deny.DecoyStatus < 300 || deny.DecoyStatus > 599 {
+			errs = append(errs, fmt.Errorf("DENY_DECOY_STATUS must be between 300 and 599, got %d", deny.DecoyStatus))
+		}
+		if deny.TarpitDelay <= 0 {
+			errs = append(errs, fmt.Errorf("DENY_TARPIT_DELAY must be positive, got %s", deny.TarpitDelay))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateMirror(options mirror.MirrorOptions) error {
+	if _, err := url.ParseRequestURI(options.URL); err != nil {
+		return fmt.Errorf("invalid MIRROR_URL: %w", err)
+	}
+	if options.Percent < 0 || options.Percent > 100 {
+		return fmt.Errorf("MIRROR_PERCENT must be between 0 and 100, got %d", options.Percent)
+	}
+	return nil
+}
+
+func validateDecisionWebhook(options coraza.DecisionWebhookOptions) error {
+	if _, err := url.ParseRequestURI(options.URL); err != nil {
+		return fmt.Errorf("invalid DECISION_WEBHOOK_URL: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("DECISION_WEBHOOK_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	return nil
+}
+
+func validateOPA(options coraza.OPAOptions) error {
+	if _, err := url.ParseRequestURI(options.URL); err != nil {
+		return fmt.Errorf("invalid OPA_URL: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("OPA_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	return nil
+}
+
+func validateScoring(options coraza.ScoringOptions) error {
+	if _, err := url.ParseRequestURI(options.URL); err != nil {
+		return fmt.Errorf("invalid SCORING_URL: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("SCORING_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.Weight < 0 || options.Weight > 1 {
+		return fmt.Errorf("SCORING_WEIGHT must be between 0 and 1, got %g", options.Weight)
+	}
+	if options.AnomalyThreshold <= 0 {
+		return fmt.Errorf("SCORING_ANOMALY_THRESHOLD must be positive, got %d", options.AnomalyThreshold)
+	}
+	if options.Threshold <= 0 || options.Threshold > 1 {
+		return fmt.Errorf("SCORING_THRESHOLD must be greater than 0 and at most 1, got %g", options.Threshold)
+	}
+	return nil
+}
+
+func validateLoki(options audit.LokiOptions) error {
+	if _, err := url.ParseRequestURI(options.URL); err != nil {
+		return fmt.Errorf("invalid LOKI_URL: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("LOKI_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.BatchSize < 1 {
+		return fmt.Errorf("LOKI_BATCH_SIZE must be at least 1, got %d", options.BatchSize)
+	}
+	return nil
+}
+
+func validateFluent(options audit.FluentOptions) error {
+	if _, _, err := net.SplitHostPort(options.Address); err != nil {
+		return fmt.Errorf("invalid FLUENT_ADDRESS: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("FLUENT_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.BatchSize < 1 {
+		return fmt.Errorf("FLUENT_BATCH_SIZE must be at least 1, got %d", options.BatchSize)
+	}
+	return nil
+}
+
+func validateCloudWatch(options audit.CloudWatchOptions) error {
+	if options.Region == "" {
+		return errors.New("CLOUDWATCH_REGION, AWS_REGION or AWS_DEFAULT_REGION must be set")
+	}
+	if options.LogStream == "" {
+		return errors.New("CLOUDWATCH_LOG_STREAM must be set when the hostname is unknown")
+	}
+	if options.Endpoint != "" {
+		if _, err := url.ParseRequestURI(options.Endpoint); err != nil {
+			return fmt.Errorf("invalid CLOUDWATCH_ENDPOINT: %w", err)
+		}
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("CLOUDWATCH_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.BatchSize < 1 || options.BatchSize > 10000 {
+		return fmt.Errorf("CLOUDWATCH_BATCH_SIZE must be between 1 and 10000, got %d", options.BatchSize)
+	}
+	return nil
+}
+
+// gcpResourceName matches the log names and topic IDs Google Cloud accepts
+var gcpResourceName = regexp.MustCompile(`^[A-Za-z0-9._/-]{1,255}$`)
+
+func validateGoogleCloud(options audit.GoogleCloudOptions) error {
+	if options.LogName != "" && !gcpResourceName.MatchString(options.LogName) {
+		return fmt.Errorf("invalid GCP_LOG_NAME %q", options.LogName)
+	}
+	if options.Topic != "" && !gcpResourceName.MatchString(options.Topic) {
+		return fmt.Errorf("invalid GCP_PUBSUB_TOPIC %q", options.Topic)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("GCP_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.BatchSize < 1 {
+		return fmt.Errorf("GCP_BATCH_SIZE must be at least 1, got %d", options.BatchSize)
+	}
+	return nil
+}
+
+func validateNATS(options nats.Options) error {
+	u, err := url.Parse(options.URL)
+	if err != nil {
+		return fmt.Errorf("invalid NATS_URL: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return fmt.Errorf("NATS_URL must use the nats or tls scheme, got %q", u.Scheme)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("NATS_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.QueueSize < 1 {
+		return fmt.Errorf("NATS_QUEUE_SIZE must be at least 1, got %d", options.QueueSize)
+	}
+	return nil
+}
+
+func validateLeaderElection(options leader.Options) error {
+	if options.LeaseDuration <= 0 || options.RenewInterval <= 0 {
+		return errors.New("LEADER_ELECTION_LEASE_DURATION and LEADER_ELECTION_RENEW_INTERVAL must be positive")
+	}
+	if options.RenewInterval >= options.LeaseDuration {
+		return fmt.Errorf("LEADER_ELECTION_RENEW_INTERVAL (%s) must be shorter than LEADER_ELECTION_LEASE_DURATION (%s)", options.RenewInterval, options.LeaseDuration)
+	}
+	if options.Backend == leader.BackendRedis && options.RedisURL == "" {
+		return errors.New("LEADER_ELECTION_REDIS_URL is required by the redis backend")
+	}
+	_, err := leader.New(options)
+	return err
+}
+
+func validateASNDatabase(path string) error {
+	if path == "" {
+		return errors.New("ASN_DATABASE is required by ASN_POLICIES")
+	}
+	_, err := geoip.Open(path)
+	return err
+}
+
+func validateWebhooks(options middleware.WebhookOptions) error {
+	if options.Tolerance <= 0 {
+		return fmt.Errorf("WEBHOOK_TIMESTAMP_TOLERANCE must be positive, got %s", options.Tolerance)
+	}
+	if options.NonceCacheSize <= 0 {
+		return fmt.Errorf("WEBHOOK_NONCE_CACHE_SIZE must be positive, got %d", options.NonceCacheSize)
+	}
+	if options.MaxBodySize <= 0 {
+		return fmt.Errorf("WEBHOOK_MAX_BODY_SIZE must be positive, got %d", options.MaxBodySize)
+	}
+	return nil
+}
+
+func validateThreatIntel(options threatintel.Options) error {
+	if options.Interval <= 0 {
+		return fmt.Errorf("THREAT_INTEL_INTERVAL must be positive, got %s", options.Interval)
+	}
+	if options.MaxAge <= 0 {
+		return fmt.Errorf("THREAT_INTEL_MAX_AGE must be positive, got %s", options.MaxAge)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("THREAT_INTEL_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	return nil
+}
+
+func validateSentry(options sentry.Options) error {
+	if _, _, err := sentry.ParseDSN(options.DSN); err != nil {
+		return fmt.Errorf("invalid SENTRY_DSN: %w", err)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("SENTRY_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	if options.QueueSize < 1 {
+		return fmt.Errorf("SENTRY_QUEUE_SIZE must be at least 1, got %d", options.QueueSize)
+	}
+	return nil
+}
+
+func validateCapture(options capture.Options) error {
+	if err := validateWritableDir(existingDir(options.Dir)); err != nil {
+		return err
+	}
+	if options.Retention <= 0 {
+		return fmt.Errorf("CAPTURE_RETENTION must be positive, got %s", options.Retention)
+	}
+	if options.SampleRate <= 0 || options.SampleRate > 1 {
+		return fmt.Errorf("CAPTURE_SAMPLE_RATE must be greater than 0 and at most 1, got %g", options.SampleRate)
+	}
+	for _, decision := range options.Decisions {
+		if decision != "allow" && decision != "deny" && decision != "error" && decision != "cancelled" {
+			return fmt.Errorf("CAPTURE_DECISIONS must list allow, deny, error or cancelled, got %q", decision)
+		}
+	}
+	if options.MaxBodySize < 0 {
+		return fmt.Errorf("CAPTURE_MAX_BODY_SIZE must not be negative, got %d", options.MaxBodySize)
+	}
+	return nil
+}
+
+func validateEventStore(options events.Options) error {
+	if err := validateWritableDir(existingDir(options.Dir)); err != nil {
+		return err
+	}
+	if options.Retention <= 0 {
+		return fmt.Errorf("EVENT_STORE_RETENTION must be positive, got %s", options.Retention)
+	}
+	for class, retention := range options.ClassRetention {
+		if _, err := types.ParseRuleSeverity(class); err != nil && class != events.ClassBlocked && class != events.ClassAllowed {
+			return fmt.Errorf("EVENT_STORE_CLASS_RETENTION classes must be blocked, allowed or a severity, got %q", class)
+		}
+		if retention <= 0 {
+			return fmt.Errorf("EVENT_STORE_CLASS_RETENTION for %s must be positive, got %s", class, retention)
+		}
+	}
+	return nil
+}
+
+func validateReport(options report.Options) error {
+	if options.Schedule != report.Daily && options.Schedule != report.Weekly {
+		return fmt.Errorf("REPORT_SCHEDULE must be daily or weekly, got %q", options.Schedule)
+	}
+	if options.WebhookURL == "" && options.SMTP.Address == "" {
+		return errors.New("REPORT_WEBHOOK_URL or REPORT_SMTP_ADDRESS is required when REPORT_SCHEDULE is set")
+	}
+	if options.WebhookURL != "" {
+		if u, err := url.Parse(options.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("REPORT_WEBHOOK_URL must be an http or https URL")
+		}
+	}
+	if options.SMTP.Address != "" {
+		if _, _, err := net.SplitHostPort(options.SMTP.Address); err != nil {
+			return fmt.Errorf("invalid REPORT_SMTP_ADDRESS: %w", err)
+		}
+		if options.SMTP.From == "" || len(options.SMTP.To) == 0 {
+			return errors.New("REPORT_EMAIL_FROM and REPORT_EMAIL_TO are required when REPORT_SMTP_ADDRESS is set")
+		}
+	}
+	if options.TopN < 1 {
+		return fmt.Errorf("REPORT_TOP_N must be at least 1, got %d", options.TopN)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("REPORT_TIMEOUT must be positive, got %s", options.Timeout)
+	}
+	return nil
+}
+
+// existingDir returns dir, or its parent if dir will be created on startup
+func existingDir(dir string) string {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+func validateWritableDir(dir string) error {
+	file, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actions of an IP list
+const (
+	// IPListAllow lets the client IPs through without inspection
+	IPListAllow = "allow"
+	// IPListDeny rejects the client IPs before inspection
+	IPListDeny = "deny"
+)
+
+// minBypassTokenLength keeps bypass tokens long enough not to be guessed
+const minBypassTokenLength = 16
+
+// IPList lets through or rejects the client IPs in its CIDRs. It is stored in the iplists collection.
+type IPList struct {
+	Action        string   `json:"action"`
+	CIDRs         []string `json:"cidrs"`
+	Description   string   `json:"description,omitempty"`
+	TransactionID string   `json:"transaction_id,omitempty"`
+}
+
+// Exclusion stops rules from running on a path. It is stored in the policies collection.
+type Exclusion struct {
+	// Path is matched exactly against the decoded request path, the REQUEST_FILENAME seen by the rules
+	Path    string `json:"path"`
+	RuleIDs []int  `json:"rule_ids"`
+	// Directive is the equivalent rule to add to the directives, when the path can be written in one
+	Directive     string `json:"directive,omitempty"`
+	Description   string `json:"description,omitempty"`
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// BypassToken lets the requests presenting it through without inspection until it expires. It is stored in the
+// bypass_tokens collection.
+type BypassToken struct {
+	Token string `json:"token"`
+	// Expires is when the token stops working. A zero time never expires.
+	Expires     time.Time `json:"expires,omitzero"`
+	Description string    `json:"description,omitempty"`
+}
+
+// validate checks that the value follows the schema of the collection
+func validate(collection string, value json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	switch collection {
+	case CollectionIPLists:
+		var list IPList
+		if err := decoder.Decode(&list); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+		if list.Action != IPListAllow && list.Action != IPListDeny {
+			return fmt.Errorf("%w: action must be %q or %q", ErrInvalidValue, IPListAllow, IPListDeny)
+		}
+		if len(list.CIDRs) == 0 {
+			return fmt.Errorf("%w: cidrs must list at least one IP or CIDR", ErrInvalidValue)
+		}
+		for _, cidr := range list.CIDRs {
+			if _, err := parsePrefix(cidr); err != nil {
+				return fmt.Errorf("%w: invalid IP or CIDR %q", ErrInvalidValue, cidr)
+			}
+		}
+	case CollectionPolicies:
+		var exclusion Exclusion
+		if err := decoder.Decode(&exclusion); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+		if !strings.HasPrefix(exclusion.Path, "/") {
+			return fmt.Errorf("%w: path must start with /", ErrInvalidValue)
+		}
+		if len(exclusion.RuleIDs) == 0 || slices.ContainsFunc(exclusion.RuleIDs, func(id int) bool { return id <= 0 }) {
+			return fmt.Errorf("%w: rule_ids must list at least one rule ID", ErrInvalidValue)
+		}
+	case CollectionBypassTokens:
+		var token BypassToken
+		if err := decoder.Decode(&token); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+		if len(token.Token) < minBypassTokenLength {
+			return fmt.Errorf("%w: token must be at least %d characters", ErrInvalidValue, minBypassTokenLength)
+		}
+	default:
+		return ErrUnknownCollection
+	}
+	return nil
+}
+
+// parsePrefix parses an IP address or CIDR
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// namedPrefix is a CIDR of an IP list, with the key of the list
+type namedPrefix struct {
+	key    string
+	prefix netip.Prefix
+}
+
+// Enforced is the stored objects the WAF enforces: the IP lists, the rule exclusions and the bypass tokens
+type Enforced struct {
+	allow      []namedPrefix
+	deny       []namedPrefix
+	exclusions map[string][]int
+	tokens     map[string]BypassToken
+}
+
+// compile builds the enforced view of a snapshot. Objects persisted before their schema was validated are skipped.
+func compile(data Snapshot) *Enforced {
+	e := &Enforced{exclusions: map[string][]int{}, tokens: map[string]BypassToken{}}
+	for _, collection := range Collections {
+		for _, key := range slices.Sorted(maps.Keys(data[collection])) {
+			value := data[collection][key]
+			if err := validate(collection, value); err != nil {
+				slog.Warn("Stored object is not enforced", "collection", collection, "key", key, "error", err)
+				continue
+			}
+			switch collection {
+			case CollectionIPLists:
+				var list IPList
+				json.Unmarshal(value, &list)
+				for _, cidr := range list.CIDRs {
+					prefix, _ := parsePrefix(cidr)
+					if list.Action == IPListDeny {
+						e.deny = append(e.deny, namedPrefix{key: key, prefix: prefix})
+					} else {
+						e.allow = append(e.allow, namedPrefix{key: key, prefix: prefix})
+					}
+				}
+			case CollectionPolicies:
+				var exclusion Exclusion
+				json.Unmarshal(value, &exclusion)
+				for _, id := range exclusion.RuleIDs {
+					if !slices.Contains(e.exclusions[exclusion.Path], id) {
+						e.exclusions[exclusion.Path] = append(e.exclusions[exclusion.Path], id)
+					}
+				}
+			case CollectionBypassTokens:
+				var token BypassToken
+				json.Unmarshal(value, &token)
+				e.tokens[key] = token
+			}
+		}
+	}
+	return e
+}
+
+// MatchIP returns the action and the key of the IP list containing the address, deny lists taking precedence
+func (e *Enforced) MatchIP(addr netip.Addr) (string, string, bool) {
+	addr = addr.Unmap()
+	for _, lists := range []struct {
+		action   string
+		prefixes []namedPrefix
+	}{{IPListDeny, e.deny}, {IPListAllow, e.allow}} {
+		for _, p := range lists.prefixes {
+			if p.prefix.Contains(addr) {
+				return lists.action, p.key, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// Excluded returns the IDs of the rules excluded on the decoded request path
+func (e *Enforced) Excluded(path string) []int {
+	return e.exclusions[path]
+}
+
+// Token returns the key of the unexpired bypass token equal to value
+func (e *Enforced) Token(value string, now time.Time) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	for key, token := range e.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Token), []byte(value)) == 1 && (token.Expires.IsZero() || now.Before(token.Expires)) {
+			return key, true
+		}
+	}
+	return "", false
+}
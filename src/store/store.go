@@ -0,0 +1,216 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Collections managed through the admin API
+const (
+	CollectionPolicies     = "policies"
+	CollectionIPLists      = "iplists"
+	CollectionBypassTokens = "bypass_tokens"
+)
+
+// Collections lists every collection the store accepts
+var Collections = []string{CollectionPolicies, CollectionIPLists, CollectionBypassTokens}
+
+var (
+	ErrUnknownCollection = errors.New("unknown collection")
+	ErrInvalidValue      = errors.New("invalid value")
+)
+
+// Snapshot is the full content of the store, keyed by collection then object key
+type Snapshot map[string]map[string]json.RawMessage
+
+// Store persists admin API objects to a JSON file so runtime changes survive restarts, and compiles them for the
+// WAF to enforce. A store without a path keeps its objects in memory only.
+type Store struct {
+	path string
+
+	mu       sync.RWMutex
+	data     Snapshot
+	enforced atomic.Pointer[Enforced]
+}
+
+// New opens the store at path, loading any previously persisted objects
+func New(path string) (*Store, error) {
+	s := &Store{path: path, data: emptySnapshot()}
+	s.enforced.Store(compile(s.data))
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse store: %w", err)
+	}
+	if err := s.replace(snapshot); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the object stored under key
+func (s *Store) Get(collection string, key string) (json.RawMessage, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.data[collection]
+	if !ok {
+		return nil, false, ErrUnknownCollection
+	}
+	value, ok := objects[key]
+	return value, ok, nil
+}
+
+// List returns every object in the collection
+func (s *Store) List(collection string) (map[string]json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.data[collection]
+	if !ok {
+		return nil, ErrUnknownCollection
+	}
+
+	result := make(map[string]json.RawMessage, len(objects))
+	for k, v := range objects {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// Enforced returns the objects the WAF enforces, as of the last change
+func (s *Store) Enforced() *Enforced {
+	return s.enforced.Load()
+}
+
+// Put creates or replaces the object stored under key and persists the store. The value must follow the schema of
+// the collection.
+func (s *Store) Put(collection string, key string, value json.RawMessage) error {
+	if err := validate(collection, value); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[collection][key] = value
+	s.enforced.Store(compile(s.data))
+	return s.persist()
+}
+
+// Delete removes the object stored under key and persists the store
+func (s *Store) Delete(collection string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.data[collection]
+	if !ok {
+		return ErrUnknownCollection
+	}
+	delete(objects, key)
+	s.enforced.Store(compile(s.data))
+	return s.persist()
+}
+
+// Export returns a copy of every object in the store
+func (s *Store) Export() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := emptySnapshot()
+	for collection, objects := range s.data {
+		for k, v := range objects {
+			snapshot[collection][k] = v
+		}
+	}
+	return snapshot
+}
+
+// Import replaces the content of the store with the snapshot and persists it. Every object must follow the schema
+// of its collection.
+func (s *Store) Import(snapshot Snapshot) error {
+	for collection, objects := range snapshot {
+		for key, value := range objects {
+			if err := validate(collection, value); err != nil {
+				return fmt.Errorf("%s/%s: %w", collection, key, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.replace(snapshot); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (s *Store) replace(snapshot Snapshot) error {
+	data := emptySnapshot()
+	for collection, objects := range snapshot {
+		if _, ok := data[collection]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownCollection, collection)
+		}
+		for k, v := range objects {
+			data[collection][k] = v
+		}
+	}
+	s.data = data
+	s.enforced.Store(compile(data))
+	return nil
+}
+
+// persist atomically writes the store to disk. The caller must hold the write lock.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace store: %w", err)
+	}
+	return nil
+}
+
+func emptySnapshot() Snapshot {
+	snapshot := Snapshot{}
+	for _, collection := range Collections {
+		snapshot[collection] = map[string]json.RawMessage{}
+	}
+	return snapshot
+}
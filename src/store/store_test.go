@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePersistence(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "store.json")
+
+	s, err := New(storePath)
+	require.NoError(t, err)
+
+	err = s.Put(CollectionIPLists, "office", json.RawMessage(`{"action":"allow","cidrs":["10.0.0.0/8"]}`))
+	assert.NoError(t, err)
+	err = s.Put(CollectionPolicies, "strict", json.RawMessage(`{"path":"/upload","rule_ids":[920420]}`))
+	assert.NoError(t, err)
+	err = s.Delete(CollectionPolicies, "strict")
+	assert.NoError(t, err)
+
+	// Reopen the store to verify the changes survived
+	reopened, err := New(storePath)
+	require.NoError(t, err)
+
+	value, ok, err := reopened.Get(CollectionIPLists, "office")
+	assert.NoError(t, err)
+	assert.True(t, ok, "Expected the IP list to be persisted")
+	assert.JSONEq(t, `{"action":"allow","cidrs":["10.0.0.0/8"]}`, string(value))
+
+	_, ok, err = reopened.Get(CollectionPolicies, "strict")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Expected the deleted policy to stay deleted")
+}
+
+func TestStoreValidation(t *testing.T) {
+	s, err := New("")
+	require.NoError(t, err)
+
+	err = s.Put("unknown", "key", json.RawMessage(`{}`))
+	assert.ErrorIs(t, err, ErrUnknownCollection)
+
+	for collection, invalid := range map[string][]string{
+		CollectionPolicies:     {`{not json`, `{"path":"upload","rule_ids":[1]}`, `{"path":"/upload","rule_ids":[]}`, `{"path":"/upload","rule_ids":[1],"mode":"block"}`},
+		CollectionIPLists:      {`{"action":"maybe","cidrs":["10.0.0.0/8"]}`, `{"action":"deny","cidrs":["10.0.0.0/33"]}`, `{"action":"deny"}`},
+		CollectionBypassTokens: {`{"token":"short"}`, `{"expires":"2030-01-01T00:00:00Z"}`},
+	} {
+		for _, value := range invalid {
+			assert.ErrorIs(t, s.Put(collection, "key", json.RawMessage(value)), ErrInvalidValue, value)
+		}
+	}
+	err = s.Import(Snapshot{CollectionIPLists: {"office": json.RawMessage(`{"cidrs":["10.0.0.0/8"]}`)}})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
+
+func TestStoreEnforced(t *testing.T) {
+	s, err := New("")
+	require.NoError(t, err)
+	require.NoError(t, s.Put(CollectionIPLists, "office", json.RawMessage(`{"action":"allow","cidrs":["10.0.0.0/8"]}`)))
+	require.NoError(t, s.Put(CollectionIPLists, "abuser", json.RawMessage(`{"action":"deny","cidrs":["10.1.2.3"]}`)))
+	require.NoError(t, s.Put(CollectionPolicies, "upload", json.RawMessage(`{"path":"/upload","rule_ids":[920420,921110]}`)))
+	require.NoError(t, s.Put(CollectionBypassTokens, "ci", json.RawMessage(`{"token":"0123456789abcdef","expires":"2030-01-01T00:00:00Z"}`)))
+
+	enforced := s.Enforced()
+	action, key, ok := enforced.MatchIP(netip.MustParseAddr("10.9.9.9"))
+	assert.True(t, ok)
+	assert.Equal(t, IPListAllow+" office", action+" "+key)
+	action, key, _ = enforced.MatchIP(netip.MustParseAddr("::ffff:10.1.2.3"))
+	assert.Equal(t, IPListDeny+" abuser", action+" "+key, "Expected deny lists to take precedence")
+	_, _, ok = enforced.MatchIP(netip.MustParseAddr("192.0.2.1"))
+	assert.False(t, ok)
+
+	assert.Equal(t, []int{920420, 921110}, enforced.Excluded("/upload"))
+	assert.Empty(t, enforced.Excluded("/upload/"))
+
+	key, ok = enforced.Token("0123456789abcdef", time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, "ci", key)
+	_, ok = enforced.Token("0123456789abcdef", time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok, "Expected the token to expire")
+
+	require.NoError(t, s.Delete(CollectionIPLists, "office"))
+	_, _, ok = s.Enforced().MatchIP(netip.MustParseAddr("10.9.9.9"))
+	assert.False(t, ok, "Expected changes to be enforced right away")
+}
+
+func TestStoreExportImport(t *testing.T) {
+	source, err := New("")
+	require.NoError(t, err)
+	assert.NoError(t, source.Put(CollectionBypassTokens, "ci", json.RawMessage(`{"token":"0123456789abcdef","expires":"2030-01-01T00:00:00Z"}`)))
+
+	target, err := New(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err)
+	assert.NoError(t, target.Put(CollectionPolicies, "stale", json.RawMessage(`{"path":"/","rule_ids":[1]}`)))
+
+	assert.NoError(t, target.Import(source.Export()))
+
+	tokens, err := target.List(CollectionBypassTokens)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 1)
+
+	policies, err := target.List(CollectionPolicies)
+	assert.NoError(t, err)
+	assert.Empty(t, policies, "Expected import to replace existing objects")
+
+	err = target.Import(Snapshot{"unknown": {}})
+	assert.ErrorIs(t, err, ErrUnknownCollection)
+}
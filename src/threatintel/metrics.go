@@ -0,0 +1,23 @@
+package threatintel
+
+import (
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/metrics"
+)
+
+var metricIndicators = metrics.NewGaugeVec(
+	"waf_threat_intel_indicators",
+	"The number of unexpired indicators pulled from each threat intel feed",
+	[]string{"feed"},
+)
+
+var metricPolls = metrics.NewCounterVec(
+	"waf_threat_intel_polls_total",
+	"The total number of polls of each threat intel feed, by result (success, failure)",
+	[]string{"feed", "result"},
+)
+
+var metricUnsupported = metrics.NewCounterVec(
+	"waf_threat_intel_unsupported_indicators_total",
+	"The total number of indicators skipped because their pattern is not a disjunction of IP or URL comparisons",
+	[]string{"feed"},
+)
@@ -0,0 +1,97 @@
+package threatintel
+
+import (
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Indicator is a STIX 2.1 indicator object, of which only the fields used to compile it are kept
+type Indicator struct {
+	Type        string    `json:"type"`
+	ID          string    `json:"id"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+	Pattern     string    `json:"pattern"`
+	PatternType string    `json:"pattern_type"`
+	ValidFrom   time.Time `json:"valid_from"`
+	ValidUntil  time.Time `json:"valid_until"`
+	Revoked     bool      `json:"revoked"`
+}
+
+// observable is a value an indicator's pattern matches, an IP prefix or a URL
+type observable struct {
+	prefix netip.Prefix
+	url    urlKey
+}
+
+// urlKey identifies the URLs a URL indicator matches: the scheme is left out as the WAF does not always know it
+type urlKey struct {
+	host     string
+	path     string
+	rawQuery string
+}
+
+// comparisonPattern matches the equality comparisons of the observables the WAF can match on
+var comparisonPattern = regexp.MustCompile(`(ipv4-addr|ipv6-addr|url):value\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+// disjunctionPattern matches what may remain of a pattern once its comparisons are removed for it to be the
+// disjunction of them
+var disjunctionPattern = regexp.MustCompile(`^[\s\[\]]*(OR[\s\[\]]*)*$`)
+
+// parsePattern returns the observables of a STIX pattern that is a disjunction of equality comparisons of IP
+// addresses and URLs, e.g. "[ipv4-addr:value = '198.51.100.0/24'] OR [url:value = 'http://example.com/x']". Other
+// patterns cannot be matched on a single request and are not supported.
+func parsePattern(pattern string) ([]observable, bool) {
+	matches := comparisonPattern.FindAllStringSubmatch(pattern, -1)
+	if len(matches) == 0 || !disjunctionPattern.MatchString(comparisonPattern.ReplaceAllString(pattern, "")) {
+		return nil, false
+	}
+
+	observables := make([]observable, 0, len(matches))
+	for _, match := range matches {
+		value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(match[2])
+		switch match[1] {
+		case "ipv4-addr", "ipv6-addr":
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return nil, false
+			}
+			observables = append(observables, observable{prefix: prefix})
+		case "url":
+			key, ok := parseURLKey(value)
+			if !ok {
+				return nil, false
+			}
+			observables = append(observables, observable{url: key})
+		}
+	}
+	return observables, true
+}
+
+// parsePrefix parses an IP address or CIDR
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseURLKey(value string) (urlKey, bool) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return urlKey{}, false
+	}
+	path := parsed.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return urlKey{host: strings.ToLower(parsed.Host), path: path, rawQuery: parsed.RawQuery}, true
+}
@@ -0,0 +1,360 @@
+// Package threatintel pulls IP and URL indicators from TAXII 2.1 collections of STIX objects and matches requests
+// against them. Indicators age out at their valid_until, or MaxAge after they became valid when they have none.
+package threatintel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chairswithlegs/coraza-traefik-middleware/src/outbound"
+)
+
+// Actions taken on the requests matching the indicators of a feed
+const (
+	// ActionBlock rejects the requests
+	ActionBlock = "block"
+	// ActionAlert flags the requests for the rules and the access log
+	ActionAlert = "alert"
+)
+
+// taxiiMediaType is the media type of TAXII 2.1 responses
+const taxiiMediaType = "application/taxii+json;version=2.1"
+
+// maxPages bounds the pages read in one poll of a feed, in case a server keeps answering that there is more
+const maxPages = 1000
+
+// Feed is a TAXII collection whose indicators are matched against requests
+type Feed struct {
+	Name   string
+	Action string
+	// CollectionURL is the collection's URL, e.g. https://taxii.example.com/api1/collections/{id}/
+	CollectionURL string
+	// Username and Password authenticate to the server with basic authentication when the username is set
+	Username string
+	Password string
+}
+
+type Options struct {
+	Feeds []Feed
+	// Interval is how often each feed is polled for the indicators added since the last poll
+	Interval time.Duration
+	// MaxAge is how long an indicator without a valid_until is kept after it became valid. The first poll of a feed
+	// pulls the indicators added within it.
+	MaxAge time.Duration
+	// Timeout bounds each request to a TAXII server
+	Timeout time.Duration
+	// Transport carries the requests. Nil uses the default transport.
+	Transport http.RoundTripper
+}
+
+var feedNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ParseFeeds parses one feed per line, "name action collection_url" where the action is block or alert, e.g.
+// "abuse block https://taxii.example.com/api1/collections/91a7b528-80eb-42ed-a74d-c6fbd5a26116/". The credentials
+// of a feed are read by lookup from THREAT_INTEL_<NAME>_USERNAME and THREAT_INTEL_<NAME>_PASSWORD.
+func ParseFeeds(value string, lookup func(string) string) ([]Feed, error) {
+	var feeds []Feed
+	names := map[string]bool{}
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid threat intel feed %q, expected \"name action collection_url\"", line)
+		}
+		name, action, collectionURL := fields[0], fields[1], fields[2]
+		if !feedNamePattern.MatchString(name) || names[name] {
+			return nil, fmt.Errorf("invalid or duplicate feed name %q, expected lowercase letters, digits, _ and -", name)
+		}
+		names[name] = true
+		if action != ActionBlock && action != ActionAlert {
+			return nil, fmt.Errorf("invalid action %q of feed %s, expected %q or %q", action, name, ActionBlock, ActionAlert)
+		}
+		if parsed, err := url.Parse(collectionURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid collection URL %q of feed %s", collectionURL, name)
+		}
+		prefix := "THREAT_INTEL_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		feeds = append(feeds, Feed{
+			Name:          name,
+			Action:        action,
+			CollectionURL: strings.TrimSuffix(collectionURL, "/") + "/",
+			Username:      lookup(prefix + "_USERNAME"),
+			Password:      lookup(prefix + "_PASSWORD"),
+		})
+	}
+	return feeds, nil
+}
+
+// Match is the indicator a request matched
+type Match struct {
+	Feed        string
+	Action      string
+	IndicatorID string
+}
+
+// compiled is an indicator of a feed ready to be indexed
+type compiled struct {
+	id          string
+	modified    time.Time
+	expires     time.Time
+	observables []observable
+}
+
+type feedState struct {
+	feed       Feed
+	indicators map[string]compiled
+	// addedAfter is the date_added of the last indicator pulled, which the next poll starts after
+	addedAfter string
+}
+
+// hit is an indexed indicator
+type hit struct {
+	Match
+	expires time.Time
+}
+
+// index looks up the indicators matching a request
+type index struct {
+	prefixes map[netip.Prefix][]hit
+	// bits are the distinct lengths of the prefixes, so an IP is looked up once per length
+	bits []int
+	urls map[urlKey][]hit
+}
+
+// Client polls the feeds and matches requests against their indicators
+type Client struct {
+	options Options
+	client  *http.Client
+	logger  *slog.Logger
+	now     func() time.Time
+
+	mu    sync.Mutex
+	feeds []*feedState
+	index atomic.Pointer[index]
+
+	stop    context.CancelFunc
+	ctx     context.Context
+	jobDone chan struct{}
+}
+
+func New(options Options) *Client {
+	ctx, stop := context.WithCancel(context.Background())
+	c := &Client{
+		options: options,
+		client:  outbound.Client(options.Transport, "threat_intel", options.Timeout),
+		logger:  slog.Default(),
+		now:     time.Now,
+		ctx:     ctx,
+		stop:    stop,
+		jobDone: make(chan struct{}),
+	}
+	for _, feed := range options.Feeds {
+		c.feeds = append(c.feeds, &feedState{feed: feed, indicators: map[string]compiled{}})
+	}
+	c.index.Store(&index{})
+	return c
+}
+
+// Start polls the feeds right away and then on the interval until Stop is called
+func (c *Client) Start() {
+	defer close(c.jobDone)
+	c.logger.Info("Starting threat intel job", "feeds", len(c.feeds), "interval", c.options.Interval)
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+	for {
+		for _, state := range c.feeds {
+			if err := c.poll(c.ctx, state); err != nil && c.ctx.Err() == nil {
+				metricPolls.WithLabelValues(state.feed.Name, "failure").Inc()
+				c.logger.Error("Failed to poll threat intel feed", "feed", state.feed.Name, "error", err)
+			}
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops polling and waits for the poll in progress to be abandoned
+func (c *Client) Stop(ctx context.Context) error {
+	c.stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.jobDone:
+		return nil
+	}
+}
+
+// envelope is a page of a TAXII collection's objects
+type envelope struct {
+	More    bool        `json:"more"`
+	Next    string      `json:"next"`
+	Objects []Indicator `json:"objects"`
+}
+
+// poll pulls the indicators added to the feed since the last poll and rebuilds the index
+func (c *Client) poll(ctx context.Context, state *feedState) error {
+	addedAfter := state.addedAfter
+	if addedAfter == "" {
+		addedAfter = c.now().Add(-c.options.MaxAge).UTC().Format(time.RFC3339Nano)
+	}
+	query := url.Values{"match[type]": {"indicator"}, "added_after": {addedAfter}}
+	var indicators []Indicator
+	for range maxPages {
+		page, addedLast, err := c.fetch(ctx, state.feed, query)
+		if err != nil {
+			return err
+		}
+		indicators = append(indicators, page.Objects...)
+		if addedLast != "" {
+			addedAfter = addedLast
+		}
+		if !page.More || page.Next == "" {
+			break
+		}
+		query.Set("next", page.Next)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unsupported := c.apply(state, indicators)
+	state.addedAfter = addedAfter
+	c.rebuild()
+	metricPolls.WithLabelValues(state.feed.Name, "success").Inc()
+	if unsupported > 0 {
+		metricUnsupported.WithLabelValues(state.feed.Name).Add(float64(unsupported))
+	}
+	c.logger.Debug("Polled threat intel feed", "feed", state.feed.Name, "objects", len(indicators), "unsupported", unsupported, "indicators", len(state.indicators))
+	return nil
+}
+
+// fetch reads a page of the feed's indicators, with the date_added of its last object
+func (c *Client) fetch(ctx context.Context, feed Feed, query url.Values) (envelope, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.CollectionURL+"objects/?"+query.Encode(), nil)
+	if err != nil {
+		return envelope{}, "", err
+	}
+	req.Header.Set("Accept", taxiiMediaType)
+	if feed.Username != "" {
+		req.SetBasicAuth(feed.Username, feed.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return envelope{}, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return envelope{}, "", fmt.Errorf("TAXII server answered %s", resp.Status)
+	}
+	var page envelope
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return envelope{}, "", fmt.Errorf("failed to decode TAXII envelope: %w", err)
+	}
+	return page, resp.Header.Get("X-TAXII-Date-Added-Last"), nil
+}
+
+// apply adds, updates and revokes the feed's indicators, returning the number whose pattern is not supported. The
+// caller must hold the lock.
+func (c *Client) apply(state *feedState, indicators []Indicator) int {
+	unsupported := 0
+	for _, indicator := range indicators {
+		if indicator.Type != "indicator" {
+			continue
+		}
+		if existing, ok := state.indicators[indicator.ID]; ok && existing.modified.After(indicator.Modified) {
+			continue
+		}
+		if indicator.Revoked {
+			delete(state.indicators, indicator.ID)
+			continue
+		}
+		observables, ok := parsePattern(indicator.Pattern)
+		if (indicator.PatternType != "" && indicator.PatternType != "stix") || !ok {
+			unsupported++
+			continue
+		}
+		expires := indicator.ValidUntil
+		if expires.IsZero() {
+			validFrom := indicator.ValidFrom
+			if validFrom.IsZero() {
+				validFrom = indicator.Created
+			}
+			expires = validFrom.Add(c.options.MaxAge)
+		}
+		state.indicators[indicator.ID] = compiled{id: indicator.ID, modified: indicator.Modified, expires: expires, observables: observables}
+	}
+	return unsupported
+}
+
+// rebuild drops the expired indicators of every feed and swaps in a new index of the others. The caller must hold
+// the lock.
+func (c *Client) rebuild() {
+	now := c.now()
+	next := &index{prefixes: map[netip.Prefix][]hit{}, urls: map[urlKey][]hit{}}
+	for _, state := range c.feeds {
+		for id, indicator := range state.indicators {
+			if !indicator.expires.After(now) {
+				delete(state.indicators, id)
+				continue
+			}
+			h := hit{Match: Match{Feed: state.feed.Name, Action: state.feed.Action, IndicatorID: id}, expires: indicator.expires}
+			for _, o := range indicator.observables {
+				if o.prefix.IsValid() {
+					next.prefixes[o.prefix] = append(next.prefixes[o.prefix], h)
+					if !slices.Contains(next.bits, o.prefix.Bits()) {
+						next.bits = append(next.bits, o.prefix.Bits())
+					}
+				} else {
+					next.urls[o.url] = append(next.urls[o.url], h)
+				}
+			}
+		}
+		metricIndicators.WithLabelValues(state.feed.Name).Set(float64(len(state.indicators)))
+	}
+	c.index.Store(next)
+}
+
+// Match returns the indicator the client IP or the URL of a request matches, preferring those of blocking feeds.
+// Indicators that expired since the last poll are ignored.
+func (c *Client) Match(ip netip.Addr, host string, path string, rawQuery string) (Match, bool) {
+	idx := c.index.Load()
+	now := c.now()
+	var found *hit
+	consider := func(hits []hit) {
+		for i := range hits {
+			if hits[i].expires.After(now) && (found == nil || found.Action != ActionBlock) {
+				found = &hits[i]
+			}
+		}
+	}
+	if ip.IsValid() {
+		ip = ip.Unmap()
+		for _, bits := range idx.bits {
+			if prefix, err := ip.Prefix(bits); err == nil {
+				consider(idx.prefixes[prefix])
+			}
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	consider(idx.urls[urlKey{host: strings.ToLower(host), path: path, rawQuery: rawQuery}])
+	if found == nil {
+		return Match{}, false
+	}
+	return found.Match, true
+}
@@ -0,0 +1,149 @@
+package threatintel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFeeds(t *testing.T) {
+	lookup := func(name string) string {
+		return map[string]string{"THREAT_INTEL_PARTNER_CTI_USERNAME": "user", "THREAT_INTEL_PARTNER_CTI_PASSWORD": "pass"}[name]
+	}
+	feeds, err := ParseFeeds("abuse block https://taxii.example.com/api1/collections/a\npartner-cti alert https://cti.example.com/collections/b/", lookup)
+	require.NoError(t, err)
+	assert.Equal(t, []Feed{
+		{Name: "abuse", Action: ActionBlock, CollectionURL: "https://taxii.example.com/api1/collections/a/"},
+		{Name: "partner-cti", Action: ActionAlert, CollectionURL: "https://cti.example.com/collections/b/", Username: "user", Password: "pass"},
+	}, feeds)
+
+	for _, invalid := range []string{
+		"abuse block",
+		"Abuse block https://taxii.example.com/c/",
+		"abuse drop https://taxii.example.com/c/",
+		"abuse block ftp://taxii.example.com/c/",
+		"abuse block https://a.example.com/c/\nabuse alert https://b.example.com/c/",
+	} {
+		_, err := ParseFeeds(invalid, lookup)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	observables, ok := parsePattern("[ipv4-addr:value = '198.51.100.0/24'] OR [ipv6-addr:value = '2001:db8::1' OR url:value = 'http://Example.com/wp-login.php?x=1']")
+	require.True(t, ok)
+	assert.Equal(t, []observable{
+		{prefix: netip.MustParsePrefix("198.51.100.0/24")},
+		{prefix: netip.MustParsePrefix("2001:db8::1/128")},
+		{url: urlKey{host: "example.com", path: "/wp-login.php", rawQuery: "x=1"}},
+	}, observables)
+
+	for _, unsupported := range []string{
+		"[ipv4-addr:value = '198.51.100.1' AND network-traffic:dst_port = 22]",
+		"[domain-name:value = 'example.com']",
+		"[ipv4-addr:value = 'not-an-ip']",
+		"[ipv4-addr:value = '198.51.100.1'] FOLLOWEDBY [ipv4-addr:value = '198.51.100.2']",
+	} {
+		_, ok := parsePattern(unsupported)
+		assert.False(t, ok, unsupported)
+	}
+}
+
+func TestClient(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	pages := map[string]envelope{
+		"": {More: true, Next: "page2", Objects: []Indicator{
+			{Type: "indicator", ID: "indicator--1", Pattern: "[ipv4-addr:value = '198.51.100.0/24']", PatternType: "stix", ValidFrom: now.Add(-time.Hour)},
+			{Type: "indicator", ID: "indicator--2", Pattern: "[url:value = 'https://shop.example.com/xmlrpc.php']", PatternType: "stix", ValidFrom: now.Add(-time.Hour), ValidUntil: now.Add(time.Minute)},
+			{Type: "malware", ID: "malware--1"},
+		}},
+		"page2": {Objects: []Indicator{
+			{Type: "indicator", ID: "indicator--3", Pattern: "[ipv4-addr:value = '203.0.113.5']", PatternType: "stix", ValidFrom: now.Add(-time.Hour)},
+			{Type: "indicator", ID: "indicator--4", Pattern: "[file:hashes.MD5 = 'abc']", PatternType: "stix", ValidFrom: now},
+		}},
+	}
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/collections/a/objects/", r.URL.Path)
+		assert.Equal(t, taxiiMediaType, r.Header.Get("Accept"))
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "user:pass", username+":"+password)
+		queries = append(queries, r.URL.Query().Get("added_after"))
+		w.Header().Set("Content-Type", taxiiMediaType)
+		w.Header().Set("X-TAXII-Date-Added-Last", "2026-10-01T11:59:00Z")
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("next")])
+	}))
+	defer server.Close()
+
+	client := New(Options{
+		Feeds:    []Feed{{Name: "abuse", Action: ActionBlock, CollectionURL: server.URL + "/collections/a/", Username: "user", Password: "pass"}},
+		Interval: time.Hour,
+		MaxAge:   24 * time.Hour,
+		Timeout:  time.Second,
+	})
+	client.now = func() time.Time { return now }
+	require.NoError(t, client.poll(context.Background(), client.feeds[0]))
+
+	t.Run("Should pull every page added within the maximum age", func(t *testing.T) {
+		assert.Equal(t, []string{"2026-09-30T12:00:00Z", "2026-09-30T12:00:00Z"}, queries)
+		assert.Len(t, client.feeds[0].indicators, 3)
+	})
+
+	t.Run("Should match client IPs and URLs", func(t *testing.T) {
+		match, ok := client.Match(netip.MustParseAddr("198.51.100.7"), "shop.example.com", "/", "")
+		assert.True(t, ok)
+		assert.Equal(t, Match{Feed: "abuse", Action: ActionBlock, IndicatorID: "indicator--1"}, match)
+		match, ok = client.Match(netip.MustParseAddr("::ffff:203.0.113.5"), "shop.example.com", "/", "")
+		assert.True(t, ok)
+		assert.Equal(t, "indicator--3", match.IndicatorID)
+		match, ok = client.Match(netip.MustParseAddr("192.0.2.1"), "SHOP.example.com", "/xmlrpc.php", "")
+		assert.True(t, ok)
+		assert.Equal(t, "indicator--2", match.IndicatorID)
+		_, ok = client.Match(netip.MustParseAddr("192.0.2.1"), "shop.example.com", "/", "")
+		assert.False(t, ok)
+	})
+
+	t.Run("Should age out indicators", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		_, ok := client.Match(netip.MustParseAddr("192.0.2.1"), "shop.example.com", "/xmlrpc.php", "")
+		assert.False(t, ok, "Expected the indicator to expire at its valid_until")
+
+		now = now.Add(24 * time.Hour)
+		pages = map[string]envelope{"": {}}
+		require.NoError(t, client.poll(context.Background(), client.feeds[0]))
+		assert.Equal(t, "2026-10-01T11:59:00Z", queries[len(queries)-1], "Expected the poll to start after the last indicator pulled")
+		assert.Empty(t, client.feeds[0].indicators)
+	})
+
+	t.Run("Should revoke indicators", func(t *testing.T) {
+		pages = map[string]envelope{"": {Objects: []Indicator{
+			{Type: "indicator", ID: "indicator--5", Modified: now, Pattern: "[ipv4-addr:value = '192.0.2.9']", ValidFrom: now},
+		}}}
+		require.NoError(t, client.poll(context.Background(), client.feeds[0]))
+		_, ok := client.Match(netip.MustParseAddr("192.0.2.9"), "", "/", "")
+		assert.True(t, ok)
+
+		pages = map[string]envelope{"": {Objects: []Indicator{
+			{Type: "indicator", ID: "indicator--5", Modified: now.Add(time.Second), Pattern: "[ipv4-addr:value = '192.0.2.9']", Revoked: true},
+		}}}
+		require.NoError(t, client.poll(context.Background(), client.feeds[0]))
+		_, ok = client.Match(netip.MustParseAddr("192.0.2.9"), "", "/", "")
+		assert.False(t, ok)
+	})
+
+	t.Run("Should fail on server errors", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer failing.Close()
+		client := New(Options{Feeds: []Feed{{Name: "down", Action: ActionAlert, CollectionURL: failing.URL + "/"}}, Interval: time.Hour, MaxAge: time.Hour, Timeout: time.Second})
+		assert.Error(t, client.poll(context.Background(), client.feeds[0]))
+	})
+}
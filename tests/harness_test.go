@@ -0,0 +1,263 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// directives are the WAF rules of the stack: the CRS as in docker-compose.yml, and a rule tagging crawlers
+const directives = `
+SecDebugLogLevel 3
+Include @coraza.conf-recommended
+SecDefaultAction phase:1,log,auditlog,pass
+SecDefaultAction phase:2,log,auditlog,pass
+SecAction id:900990,phase:1,pass,t:none,nolog,tag:'OWASP_CRS',ver:'OWASP_CRS/4.17.1',setvar:tx.crs_setup_version=4171
+Include @owasp_crs/*.conf
+SecRule REQUEST_HEADERS:User-Agent "@pm crawler" "id:10010,phase:1,pass,nolog,tag:'request-tag:bot'"
+SecRuleEngine On
+`
+
+const staticConfig = `log:
+  level: INFO
+entryPoints:
+  web:
+    address: 127.0.0.1:%d
+providers:
+  file:
+    filename: %s
+`
+
+const dynamicConfig = `http:
+  routers:
+    upstream:
+      rule: PathPrefix(` + "`/`" + `)
+      service: upstream
+      entryPoints: [web]
+      middlewares: [coraza]
+    upstream-no-waf:
+      rule: PathPrefix(` + "`/`" + `) && Header(` + "`X-WAF-Disabled`, `true`" + `)
+      service: upstream
+      entryPoints: [web]
+  services:
+    upstream:
+      loadBalancer:
+        servers:
+          - url: %s
+  middlewares:
+    coraza:
+      forwardAuth:
+        address: http://127.0.0.1:%d
+        trustForwardHeader: true
+        authResponseHeaders: [X-Waf-Tags]
+`
+
+// stack is Traefik forwarding to an upstream recording the requests it receives, with the middleware built from
+// source as its forwardAuth middleware. Traefik is the traefik binary when TRAEFIK_BIN is set or it is on the
+// PATH, and otherwise a container of TRAEFIK_IMAGE on the host network.
+type stack struct {
+	traefikURL string
+	adminURL   string
+	upstream   *upstream
+
+	dir     string
+	cleanup []func()
+}
+
+// upstream records the headers of the last request it received
+type upstream struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	header http.Header
+}
+
+func newUpstream() *upstream {
+	u := &upstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.header = r.Header.Clone()
+		u.mu.Unlock()
+		w.Write([]byte("upstream"))
+	}))
+	return u
+}
+
+// lastHeader returns the headers of the last request received
+func (u *upstream) lastHeader() http.Header {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.header
+}
+
+func startStack() (*stack, error) {
+	dir, err := os.MkdirTemp("", "coraza-integration-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &stack{dir: dir, upstream: newUpstream()}
+	s.cleanup = append(s.cleanup, s.upstream.Close)
+
+	ports, err := freePorts(3)
+	if err != nil {
+		s.stop()
+		return nil, err
+	}
+	traefikPort, wafPort, adminPort := ports[0], ports[1], ports[2]
+	s.traefikURL = "http://127.0.0.1:" + strconv.Itoa(traefikPort)
+	s.adminURL = "http://127.0.0.1:" + strconv.Itoa(adminPort)
+
+	if err := s.startMiddleware(wafPort, adminPort); err != nil {
+		s.stop()
+		return nil, err
+	}
+	if err := s.startTraefik(traefikPort, wafPort); err != nil {
+		s.stop()
+		return nil, err
+	}
+	if err := s.waitUntilReady(2 * time.Minute); err != nil {
+		s.stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// startMiddleware builds the middleware and runs it with its logs in the stack directory
+func (s *stack) startMiddleware(wafPort int, adminPort int) error {
+	binary := filepath.Join(s.dir, "coraza-traefik-middleware")
+	build := exec.Command("go", "build", "-o", binary, "../src")
+	if output, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build the middleware: %w\n%s", err, output)
+	}
+
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(),
+		"WAF_PORT="+strconv.Itoa(wafPort),
+		"ADMIN_PORT="+strconv.Itoa(adminPort),
+		"AUDIT_LOG_PATH="+filepath.Join(s.dir, "audit", "coraza-audit.log"),
+		"LOG_LEVEL=info",
+		"DIRECTIVES="+directives,
+	)
+	return s.run(cmd, "middleware.log")
+}
+
+// startTraefik runs Traefik with a configuration pointing at the middleware and the upstream
+func (s *stack) startTraefik(traefikPort int, wafPort int) error {
+	dynamicPath := filepath.Join(s.dir, "dynamic.yml")
+	if err := os.WriteFile(dynamicPath, []byte(fmt.Sprintf(dynamicConfig, s.upstream.URL, wafPort)), 0o644); err != nil {
+		return err
+	}
+
+	binary := os.Getenv("TRAEFIK_BIN")
+	if binary == "" {
+		binary, _ = exec.LookPath("traefik")
+	}
+	if binary != "" {
+		staticPath := filepath.Join(s.dir, "static.yml")
+		if err := os.WriteFile(staticPath, []byte(fmt.Sprintf(staticConfig, traefikPort, dynamicPath)), 0o644); err != nil {
+			return err
+		}
+		return s.run(exec.Command(binary, "--configFile="+staticPath), "traefik.log")
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return errors.New("neither traefik nor docker was found, set TRAEFIK_BIN or install docker")
+	}
+	image := os.Getenv("TRAEFIK_IMAGE")
+	if image == "" {
+		image = "traefik:v3.5"
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "static.yml"), []byte(fmt.Sprintf(staticConfig, traefikPort, "/etc/traefik/dynamic.yml")), 0o644); err != nil {
+		return err
+	}
+	name := "coraza-integration-" + strconv.Itoa(os.Getpid())
+	// The host network lets Traefik reach the middleware and the upstream on the loopback interface
+	cmd := exec.Command("docker", "run", "--rm", "--name", name, "--network", "host",
+		"-v", s.dir+":/etc/traefik:ro", image, "--configFile=/etc/traefik/static.yml")
+	if err := s.run(cmd, "traefik.log"); err != nil {
+		return err
+	}
+	s.cleanup = append(s.cleanup, func() { exec.Command("docker", "rm", "-f", name).Run() })
+	return nil
+}
+
+// run starts a process of the stack, writing its output to a log file in the stack directory
+func (s *stack) run(cmd *exec.Cmd, logName string) error {
+	logFile, err := os.Create(filepath.Join(s.dir, logName))
+	if err != nil {
+		return err
+	}
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	s.cleanup = append(s.cleanup, func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		logFile.Close()
+	})
+	return nil
+}
+
+// waitUntilReady waits for the middleware to be ready and for Traefik to route through it
+func (s *stack) waitUntilReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, url := range []string{s.adminURL + "/ready", s.traefikURL + "/"} {
+		for {
+			resp, err := client.Get(url)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s did not return 200 within %s, see the logs in %s", url, timeout, s.dir)
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// stop stops the processes of the stack, keeping their logs for when it failed to start
+func (s *stack) stop() {
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		s.cleanup[i]()
+	}
+}
+
+// close stops the stack and removes its directory
+func (s *stack) close() {
+	s.stop()
+	os.RemoveAll(s.dir)
+}
+
+// freePorts returns ports that were free on the loopback interface
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	for range n {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
@@ -3,49 +3,32 @@
 package tests
 
 import (
-	"errors"
 	"io"
 	"net/http"
 	"os"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-const (
-	baseURLTraefik = "http://localhost:8000"
-	baseURLAdmin   = "http://localhost:8081"
-	readinessWait  = 2 * time.Second
-	readinessTries = 5
+// The stack the tests run against, started by TestMain
+var (
+	baseURLTraefik string
+	baseURLAdmin   string
+	testStack      *stack
 )
 
 func TestMain(m *testing.M) {
-	if err := waitForStack(); err != nil {
-		os.Stderr.WriteString("integration: stack not ready: " + err.Error() + "\nRun 'make run' first.\n")
+	s, err := startStack()
+	if err != nil {
+		os.Stderr.WriteString("integration: failed to start the stack: " + err.Error() + "\n")
 		os.Exit(1)
 	}
-	os.Exit(m.Run())
-}
-
-// waitForStack retries GET baseURLAdmin/health until 200 or max attempts.
-func waitForStack() error {
-	client := &http.Client{Timeout: 5 * time.Second}
-	url := baseURLAdmin + "/health"
-	for i := 0; i < readinessTries; i++ {
-		resp, err := client.Get(url)
-		if err == nil {
-			resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				return nil
-			}
-		}
-		if i < readinessTries-1 {
-			time.Sleep(readinessWait)
-		}
-	}
-	return errors.New("admin health did not return 200 after retries")
+	testStack, baseURLTraefik, baseURLAdmin = s, s.traefikURL, s.adminURL
+	code := m.Run()
+	s.close()
+	os.Exit(code)
 }
 
 func TestAdminHealth(t *testing.T) {
@@ -66,10 +49,10 @@ func TestFullStackAllowed(t *testing.T) {
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
-	assert.Equal(t, http.StatusOK, resp.StatusCode, "clean request through Traefik -> WAF -> whoami should return 200")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "clean request through Traefik -> WAF -> upstream should return 200")
 	body, err := io.ReadAll(resp.Body)
 	require.NoError(t, err)
-	assert.Greater(t, len(body), 0, "whoami should return a body")
+	assert.Greater(t, len(body), 0, "the upstream should return a body")
 }
 
 func TestRequestBlockedByWAF(t *testing.T) {
@@ -92,5 +75,22 @@ func TestBypassRoute(t *testing.T) {
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
-	assert.Equal(t, http.StatusOK, resp.StatusCode, "request with X-WAF-Disabled should skip WAF and reach whoami")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "request with X-WAF-Disabled should skip WAF and reach the upstream")
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	req, err := http.NewRequest("GET", baseURLTraefik+"/products?page=2", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "example-crawler/1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	header := testStack.upstream.lastHeader()
+	require.NotNil(t, header, "the upstream should have received the request")
+	assert.Equal(t, "bot", header.Get("X-Waf-Tags"), "Traefik should pass the request tags of the WAF to the upstream")
+	assert.NotEmpty(t, header.Get("X-Forwarded-For"))
+	assert.Equal(t, "GET", header.Get("X-Forwarded-Method"))
 }